@@ -0,0 +1,1074 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/buildvigil/agent/internal/api"
+	"github.com/buildvigil/agent/internal/config"
+	"github.com/buildvigil/agent/internal/platform"
+	"github.com/buildvigil/agent/internal/proxy"
+	"github.com/buildvigil/agent/internal/service"
+	"github.com/buildvigil/agent/internal/state"
+	"github.com/buildvigil/agent/internal/testutil"
+)
+
+// newTestAgent builds an agent wired like the real one, against the fake
+// control plane and a temporary hosts file.
+func newTestAgent(t *testing.T, cfg *config.Config) *Agent {
+	t.Helper()
+	stateMgr, err := state.NewManager(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to create state manager: %v", err)
+	}
+	t.Cleanup(func() { stateMgr.Close() })
+
+	agent, err := newAgent(cfg, stateMgr, nil, false)
+	if err != nil {
+		t.Fatalf("Failed to create agent: %v", err)
+	}
+	hostsFile := filepath.Join(t.TempDir(), "hosts")
+	if err := os.WriteFile(hostsFile, []byte("127.0.0.1 localhost\n"), 0644); err != nil {
+		t.Fatalf("Failed to write hosts file: %v", err)
+	}
+	agent.dnsMgr.SetHostsFile(hostsFile)
+	return agent
+}
+
+func TestAgentReconcilesDesiredState(t *testing.T) {
+	t.Logf("Testing a full reconcile against fake control plane and docker")
+
+	cp := testutil.NewFakeControlPlane(t)
+	docker := testutil.NewFakeDocker(t)
+	cfg := testutil.NewConfig(t, cp.URL)
+	agent := newTestAgent(t, cfg)
+
+	web := api.Service{ID: "svc-web", Name: "web", ServiceType: "docker", DockerImage: "nginx:1.25", Port: 80}
+	cp.Script(
+		api.DesiredState{StackID: cfg.StackID, Version: 1, Hash: "v1", Services: []api.Service{web}},
+		api.DesiredState{StackID: cfg.StackID, Version: 2, Hash: "v2"},
+	)
+
+	if err := agent.sync(); err != nil {
+		t.Fatalf("First sync failed: %v", err)
+	}
+	container, ok := docker.Container("potato-cloud-svc-web")
+	if !ok || container.Status != "running" || container.Image != "nginx:1.25" {
+		t.Fatalf("Expected running web container, got %+v (containers=%v)", container, docker.ContainerNames())
+	}
+	if docker.CallCount("pull") != 1 {
+		t.Errorf("Expected one image pull, got %d", docker.CallCount("pull"))
+	}
+
+	if err := agent.sendHeartbeat(); err != nil {
+		t.Fatalf("Heartbeat failed: %v", err)
+	}
+	hb, ok := cp.LastHeartbeat()
+	if !ok || hb.StackVersion != 1 || len(hb.ServicesStatus) != 1 || hb.ServicesStatus[0].Status != "running" {
+		t.Errorf("Expected heartbeat reporting web running, got %+v", hb)
+	}
+	if hb.Agent == nil || !slices.Contains(hb.Agent.Capabilities, "docker") || !slices.Contains(hb.Agent.Capabilities, "runtime:nodejs") {
+		t.Errorf("Expected docker and runtime capabilities in the heartbeat, got %+v", hb.Agent)
+	}
+
+	if err := agent.sync(); err != nil {
+		t.Fatalf("Second sync failed: %v", err)
+	}
+	if names := docker.ContainerNames(); len(names) != 0 {
+		t.Errorf("Expected removed service's containers to be gone, got %v", names)
+	}
+	t.Logf("✓ Agent deployed and removed the service")
+}
+
+func TestAgentReportsPartialApply(t *testing.T) {
+	t.Logf("Testing per-service revisions when one service fails")
+
+	cp := testutil.NewFakeControlPlane(t)
+	docker := testutil.NewFakeDocker(t)
+	cfg := testutil.NewConfig(t, cp.URL)
+	agent := newTestAgent(t, cfg)
+
+	web := api.Service{ID: "svc-web", Name: "web", ServiceType: "docker", DockerImage: "nginx:1.25", Port: 80}
+	broken := api.Service{ID: "svc-broken", Name: "broken", GitURL: "file://" + filepath.Join(t.TempDir(), "missing"), GitRef: "main", Port: 3000}
+	cp.SetDesiredState(api.DesiredState{StackID: cfg.StackID, Version: 7, Hash: "v7", Services: []api.Service{web, broken}})
+
+	if err := agent.sync(); err == nil {
+		t.Fatal("Expected sync to report errors")
+	}
+	if _, ok := docker.Container("potato-cloud-svc-web"); !ok {
+		t.Fatalf("Expected web to deploy despite the failure, got %v", docker.ContainerNames())
+	}
+	if applied, _ := agent.state.GetAppliedState(); applied != nil {
+		t.Errorf("Expected no stack-level applied state, got %+v", applied)
+	}
+
+	if err := agent.sendHeartbeat(); err != nil {
+		t.Fatalf("Heartbeat failed: %v", err)
+	}
+	hb, _ := cp.LastHeartbeat()
+	report := hb.Apply
+	if report == nil || !report.Partial || report.StackVersion != 7 {
+		t.Fatalf("Expected partial apply report for version 7, got %+v", report)
+	}
+	if len(report.Succeeded) != 1 || report.Succeeded[0] != "svc-web" || len(report.Failed) != 1 || report.Failed[0] != "svc-broken" {
+		t.Errorf("Unexpected succeeded=%v failed=%v", report.Succeeded, report.Failed)
+	}
+	if len(report.Revisions) != 1 || report.Revisions[0].ServiceID != "svc-web" || report.Revisions[0].StackVersion != 7 {
+		t.Errorf("Expected web's applied revision, got %+v", report.Revisions)
+	}
+	t.Logf("✓ Partial apply recorded per service and reported")
+}
+
+func TestAgentSyncsOnPublishedState(t *testing.T) {
+	t.Logf("Testing syncs triggered by the desired state stream")
+
+	cp := testutil.NewFakeControlPlane(t)
+	cfg := testutil.NewConfig(t, cp.URL)
+	agent := newTestAgent(t, cfg)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go agent.watchDesiredState(ctx)
+
+	waitFor := func(what string, cond func() bool) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for !cond() {
+			if time.Now().After(deadline) {
+				t.Fatalf("Timed out waiting for %s", what)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	requested := func() bool {
+		select {
+		case <-agent.syncNow:
+			return true
+		default:
+			return false
+		}
+	}
+
+	waitFor("stream to connect", func() bool { return cp.Streams() == 1 && agent.pushConnected.Load() })
+	waitFor("catch-up sync request", requested)
+
+	cp.Publish(api.DesiredState{StackID: cfg.StackID, Version: 2, Hash: "v2"})
+	waitFor("sync request for published state", requested)
+
+	cp.DropStreams()
+	waitFor("fallback to polling", func() bool { return !agent.pushConnected.Load() })
+	waitFor("stream to reconnect", func() bool { return cp.Streams() == 1 && agent.pushConnected.Load() })
+	cancel()
+	waitFor("stream to close", func() bool { return cp.Streams() == 0 && !agent.pushConnected.Load() })
+
+	agent.updatePushSync()
+	if agent.pushCancel != nil {
+		t.Fatal("Expected no stream without desired_state_push or the push_sync flag")
+	}
+	agent.applyFeatureFlags(map[string]bool{"push_sync": true})
+	agent.updatePushSync()
+	waitFor("push_sync to open the stream", func() bool { return cp.Streams() == 1 && agent.pushConnected.Load() })
+	agent.applyFeatureFlags(map[string]bool{"push_sync": false})
+	agent.updatePushSync()
+	waitFor("push_sync to close the stream", func() bool { return cp.Streams() == 0 && !agent.pushConnected.Load() })
+
+	t.Logf("✓ Published states trigger syncs, dropped streams reconnect and push_sync gates the stream")
+}
+
+func TestSLOWindowBurnRates(t *testing.T) {
+	t.Logf("Testing SLO compliance and burn rates")
+
+	slo := api.ServiceSLO{LatencyMs: 300, LatencyTarget: 0.99, ErrorRateTarget: 0.01}
+	window := sloWindow("1h", proxy.SLOCounts{Requests: 1000, Errors: 20, Slow: 5}, slo)
+	if window.Compliant || window.ErrorRate != 0.02 || window.ErrorBurn < 1.99 || window.ErrorBurn > 2.01 {
+		t.Errorf("Expected error budget burning at 2x, got %+v", window)
+	}
+	if window.LatencyBurn < 0.49 || window.LatencyBurn > 0.51 {
+		t.Errorf("Expected latency burn 0.5, got %+v", window)
+	}
+	if empty := sloWindow("5m", proxy.SLOCounts{}, slo); !empty.Compliant || empty.Requests != 0 {
+		t.Errorf("Expected empty window to be compliant, got %+v", empty)
+	}
+	if err := validateSLO(api.ServiceSLO{LatencyTarget: 0.99}); err == nil {
+		t.Error("Expected latency_target without latency_ms to be rejected")
+	}
+	t.Logf("✓ Burn rates computed against the objectives")
+}
+
+func TestHandleRegisterSavesCredentials(t *testing.T) {
+	t.Logf("Testing -register writes credentials to a new config file")
+
+	var requested []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req api.RegistrationRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		requested = req.Scopes
+		json.NewEncoder(w).Encode(api.RegistrationResponse{AgentID: "agent-9", APIKey: "key-9", StackID: "stack-9", Scopes: []string{"stack:read:stack-9"}})
+	}))
+	defer server.Close()
+
+	configPath := filepath.Join(t.TempDir(), "config.json")
+	controlPlane := optionalString{value: server.URL, set: true}
+	if err := handleRegister(configPath, "token", controlPlane, optionalString{}, optionalString{}); err != nil {
+		t.Fatalf("Registration failed: %v", err)
+	}
+
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		t.Fatalf("Failed to load saved config: %v", err)
+	}
+	if cfg.AgentID != "agent-9" || cfg.APIKey != "key-9" || cfg.StackID != "stack-9" || cfg.ControlPlane != server.URL {
+		t.Errorf("Unexpected saved config: agent=%s key=%s stack=%s control_plane=%s", cfg.AgentID, cfg.APIKey, cfg.StackID, cfg.ControlPlane)
+	}
+	if cfg.PollInterval != config.DefaultConfig().PollInterval {
+		t.Errorf("Expected defaults in new config, got poll_interval=%d", cfg.PollInterval)
+	}
+	if len(requested) == 0 || requested[0] != api.ScopeStackRead || len(cfg.APIKeyScopes) != 1 {
+		t.Errorf("Expected scopes requested and the granted ones saved, got requested=%q granted=%q", requested, cfg.APIKeyScopes)
+	}
+
+	if err := handleRegister(configPath, " ", controlPlane, optionalString{}, optionalString{}); err == nil {
+		t.Error("Expected missing install token to fail")
+	}
+	t.Logf("✓ Registration saved agent ID, API key and stack ID")
+}
+
+func TestAgentRunsQueuedCommands(t *testing.T) {
+	t.Logf("Testing commands queued by the control plane")
+
+	cp := testutil.NewFakeControlPlane(t)
+	docker := testutil.NewFakeDocker(t)
+	cfg := testutil.NewConfig(t, cp.URL)
+	agent := newTestAgent(t, cfg)
+
+	web := api.Service{ID: "svc-web", Name: "web", ServiceType: "docker", DockerImage: "nginx:1.25", Port: 80}
+	held := api.Service{ID: "svc-held", Name: "held", ServiceType: "docker", DockerImage: "nginx:1.25", Port: 8080}
+	cp.SetDesiredState(api.DesiredState{StackID: cfg.StackID, Version: 1, Hash: "v1", Services: []api.Service{web, held}})
+	if err := agent.sync(); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	if err := agent.state.SetServiceHold("svc-held", "maintenance"); err != nil {
+		t.Fatalf("Failed to hold service: %v", err)
+	}
+
+	cp.QueueCommands(
+		api.Command{ID: "cmd-1", Type: api.CommandRestartService, ServiceID: "svc-web"},
+		api.Command{ID: "cmd-2", Type: api.CommandFetchLogs, ServiceID: "svc-web", Args: map[string]string{"lines": "5"}},
+		api.Command{ID: "cmd-3", Type: api.CommandRedeployService, ServiceID: "svc-gone"},
+		api.Command{ID: "cmd-4", Type: "reboot", ServiceID: "svc-web"},
+		api.Command{ID: "cmd-5", Type: api.CommandRestartService, ServiceID: "svc-gone"},
+		api.Command{ID: "cmd-6", Type: api.CommandRestartService, ServiceID: "svc-held"},
+	)
+	if err := agent.sync(); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+
+	status := make(map[string]string)
+	for _, result := range cp.CommandResults() {
+		status[result.CommandID] = result.Status
+	}
+	want := map[string]string{"cmd-1": "succeeded", "cmd-2": "succeeded", "cmd-3": "rejected", "cmd-4": "rejected", "cmd-5": "rejected", "cmd-6": "rejected"}
+	for id, expected := range want {
+		if status[id] != expected {
+			t.Errorf("Expected %s to be %s, got %q", id, expected, status[id])
+		}
+	}
+	if docker.CallCount("restart") != 1 {
+		t.Errorf("Expected one container restart, got %d", docker.CallCount("restart"))
+	}
+
+	// Results are posted once; the next sync has nothing to run.
+	if err := agent.sync(); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	if got := len(cp.CommandResults()); got != len(want) {
+		t.Errorf("Expected %d results, got %d", len(want), got)
+	}
+	t.Logf("✓ Commands run once and their results posted")
+}
+
+func TestAgentWaitsForProtectedServiceConfirmation(t *testing.T) {
+	t.Logf("Testing protected service changes waiting for confirmation")
+
+	cp := testutil.NewFakeControlPlane(t)
+	docker := testutil.NewFakeDocker(t)
+	cfg := testutil.NewConfig(t, cp.URL)
+	agent := newTestAgent(t, cfg)
+
+	db := api.Service{ID: "svc-db", Name: "db", ServiceType: "docker", DockerImage: "postgres:15", Port: 5432, Protected: true}
+	cp.SetDesiredState(api.DesiredState{StackID: cfg.StackID, Version: 1, Hash: "v1", Services: []api.Service{db}})
+	if err := agent.sync(); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	if container, ok := docker.Container("potato-cloud-svc-db"); !ok || container.Status != "running" {
+		t.Fatalf("Expected first deploy of a protected service to proceed, got %v", docker.ContainerNames())
+	}
+	runs := docker.CallCount("run")
+
+	db.DockerImage = "postgres:16"
+	cp.SetDesiredState(api.DesiredState{StackID: cfg.StackID, Version: 2, Hash: "v2", Services: []api.Service{db}})
+	if err := agent.sync(); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	if docker.CallCount("run") != runs {
+		t.Errorf("Expected no redeploy before confirmation")
+	}
+	pending, _ := agent.state.GetPendingAction("svc-db")
+	if pending == nil || pending.Reason != "awaiting_confirmation" {
+		t.Fatalf("Expected change awaiting confirmation, got %+v", pending)
+	}
+
+	cp.QueueCommands(
+		api.Command{ID: "cmd-1", Type: api.CommandConfirmDeploy, ServiceID: "svc-db", Args: map[string]string{"revision": "stale"}},
+		api.Command{ID: "cmd-2", Type: api.CommandConfirmDeploy, ServiceID: "svc-db", Args: map[string]string{"revision": pending.Revision}},
+	)
+	agent.runCommands()
+	status := make(map[string]string)
+	for _, result := range cp.CommandResults() {
+		status[result.CommandID] = result.Status
+	}
+	if status["cmd-1"] != "failed" || status["cmd-2"] != "succeeded" {
+		t.Errorf("Expected stale confirmation to fail and current one to succeed, got %v", status)
+	}
+	if !agent.deployConfirmed("svc-db", pending.Revision) {
+		t.Errorf("Expected revision %s to be confirmed", pending.Revision)
+	}
+	t.Logf("✓ Protected change held until its revision was confirmed")
+}
+
+func TestAgentRejectsUnconfirmedProtectedRedeploys(t *testing.T) {
+	t.Logf("Testing redeploy commands can't apply an unconfirmed protected service change")
+
+	cp := testutil.NewFakeControlPlane(t)
+	docker := testutil.NewFakeDocker(t)
+	cfg := testutil.NewConfig(t, cp.URL)
+	agent := newTestAgent(t, cfg)
+
+	db := api.Service{ID: "svc-db", Name: "db", ServiceType: "docker", DockerImage: "postgres:15", Port: 5432, Protected: true}
+	cp.SetDesiredState(api.DesiredState{StackID: cfg.StackID, Version: 1, Hash: "v1", Services: []api.Service{db}})
+	if err := agent.sync(); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	db.DockerImage = "postgres:16"
+	cp.SetDesiredState(api.DesiredState{StackID: cfg.StackID, Version: 2, Hash: "v2", Services: []api.Service{db}})
+	if err := agent.sync(); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	pending, _ := agent.state.GetPendingAction("svc-db")
+	if pending == nil || pending.Reason != "awaiting_confirmation" {
+		t.Fatalf("Expected change awaiting confirmation, got %+v", pending)
+	}
+	runs := docker.CallCount("run")
+
+	cp.QueueCommands(
+		api.Command{ID: "cmd-1", Type: api.CommandRedeployService, ServiceID: "svc-db"},
+		api.Command{ID: "cmd-2", Type: api.CommandRotateSecret, ServiceID: "svc-db", Args: map[string]string{"name": "PASSWORD", "value": "hunter2"}},
+	)
+	agent.runCommands()
+	for _, result := range cp.CommandResults() {
+		if result.Status != "rejected" {
+			t.Errorf("Expected %s to be rejected, got %q", result.CommandID, result.Status)
+		}
+	}
+	if got := len(cp.CommandResults()); got != 2 {
+		t.Errorf("Expected 2 results, got %d", got)
+	}
+	if docker.CallCount("run") != runs {
+		t.Errorf("Expected no redeploy before confirmation")
+	}
+
+	if _, err := confirmPendingChange(agent.state, "svc-db", pending.Revision); err != nil {
+		t.Fatalf("Failed to confirm change: %v", err)
+	}
+	agent.desiredMu.RLock()
+	desired := agent.desiredServices["svc-db"]
+	agent.desiredMu.RUnlock()
+	if err := agent.checkChangeConfirmed(desired); err != nil {
+		t.Errorf("Expected the confirmed change to be redeployable, got %v", err)
+	}
+	t.Logf("✓ Redeploys wait for the protected change to be confirmed")
+}
+
+func TestAgentReportsDrift(t *testing.T) {
+	t.Logf("Testing the drift report between desired and running state")
+
+	cp := testutil.NewFakeControlPlane(t)
+	docker := testutil.NewFakeDocker(t)
+	cfg := testutil.NewConfig(t, cp.URL)
+	agent := newTestAgent(t, cfg)
+
+	if agent.driftReport() != nil {
+		t.Errorf("Expected no drift report before the first sync")
+	}
+	web := api.Service{ID: "svc-web", Name: "web", ServiceType: "docker", DockerImage: "nginx:1.25", Port: 80, Hostname: "web.example.com", EnvironmentVars: map[string]string{"MODE": "prod"}}
+	cp.SetDesiredState(api.DesiredState{StackID: cfg.StackID, Version: 1, Hash: "v1", Services: []api.Service{web}})
+	if err := agent.sync(); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+
+	report := agent.driftReport()
+	if report == nil || !report.InSync || len(report.Services) != 1 {
+		t.Fatalf("Expected an in-sync report for one service, got %+v", report)
+	}
+	drift := report.Services[0]
+	if drift.RunningReplicas != 1 || drift.DesiredEnvHash == "" || drift.DesiredEnvHash != drift.ActualEnvHash || drift.RoutedPort != drift.ServicePort {
+		t.Errorf("Expected matching replicas, environment and route, got %+v", drift)
+	}
+
+	docker.SetContainerStatus("potato-cloud-svc-web", "exited")
+	if err := agent.state.SaveServiceProcess(&state.ServiceProcess{ServiceID: "svc-old", ServiceName: "old", Status: "running"}); err != nil {
+		t.Fatalf("Failed to save process: %v", err)
+	}
+	report = agent.driftReport()
+	if report.InSync || len(report.Services) != 2 {
+		t.Fatalf("Expected drift for two services, got %+v", report)
+	}
+	categories := map[string]string{}
+	for _, drift := range report.Services {
+		categories[drift.ServiceID] = drift.Category + ":" + strings.Join(drift.Differences, ",")
+	}
+	if categories["svc-web"] != "pending_deploy:replicas" || categories["svc-old"] != "pending_removal:replicas" {
+		t.Errorf("Unexpected drift categories: %v", categories)
+	}
+	t.Logf("✓ Drift reported for a stopped container and an undesired service")
+}
+
+func TestAgentWritesStatusFile(t *testing.T) {
+	t.Logf("Testing the status file written after each heartbeat")
+
+	cp := testutil.NewFakeControlPlane(t)
+	testutil.NewFakeDocker(t)
+	cfg := testutil.NewConfig(t, cp.URL)
+	cfg.APIRetryAttempts = 1
+	agent := newTestAgent(t, cfg)
+
+	web := api.Service{ID: "svc-web", Name: "web", ServiceType: "docker", DockerImage: "nginx:1.25", Port: 80}
+	cp.SetDesiredState(api.DesiredState{StackID: cfg.StackID, Version: 1, Hash: "v1", Services: []api.Service{web}})
+	if err := agent.sync(); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+
+	readStatus := func() statusFile {
+		t.Helper()
+		data, err := os.ReadFile(filepath.Join(cfg.DataDir, "status.json"))
+		if err != nil {
+			t.Fatalf("Failed to read status file: %v", err)
+		}
+		var status statusFile
+		if err := json.Unmarshal(data, &status); err != nil {
+			t.Fatalf("Failed to decode status file: %v", err)
+		}
+		return status
+	}
+
+	if err := agent.sendHeartbeat(); err != nil {
+		t.Fatalf("Heartbeat failed: %v", err)
+	}
+	status := readStatus()
+	if !status.Delivered || status.Heartbeat.StackVersion != 1 || status.Services["svc-web"].Status != "running" {
+		t.Errorf("Expected a delivered heartbeat with web running, got %+v", status)
+	}
+
+	// A heartbeat the control plane refuses is still written, marked undelivered.
+	cp.FailNext(1)
+	if err := agent.sendHeartbeat(); err == nil {
+		t.Fatal("Expected the heartbeat to fail")
+	}
+	status = readStatus()
+	if status.Delivered || status.Error == "" || status.Services["svc-web"].Status != "running" {
+		t.Errorf("Expected an undelivered heartbeat with its error, got %+v", status)
+	}
+	if _, err := os.Stat(filepath.Join(cfg.DataDir, "status.json.tmp")); !os.IsNotExist(err) {
+		t.Errorf("Expected no temporary file left behind, got %v", err)
+	}
+	t.Logf("✓ Status file replaced after delivered and failed heartbeats")
+}
+
+func TestAgentCoalescesLifecycleHeartbeats(t *testing.T) {
+	t.Logf("Testing lifecycle updates gathered into one heartbeat")
+
+	cp := testutil.NewFakeControlPlane(t)
+	cfg := testutil.NewConfig(t, cp.URL)
+	agent := newTestAgent(t, cfg)
+	agent.lifecycleFlushInterval = 200 * time.Millisecond
+
+	waitHeartbeats := func(n int) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for len(cp.Heartbeats()) < n && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	for i := 0; i < 10; i++ {
+		svc := api.Service{ID: fmt.Sprintf("svc-%d", i), Name: fmt.Sprintf("web-%d", i)}
+		agent.onServiceLifecycleEvent(svc, "building", "", "")
+		agent.onServiceLifecycleEvent(svc, "deploying", "", "")
+	}
+	waitHeartbeats(1)
+	time.Sleep(400 * time.Millisecond)
+	heartbeats := cp.Heartbeats()
+	if len(heartbeats) != 1 {
+		t.Fatalf("Expected one coalesced heartbeat, got %d", len(heartbeats))
+	}
+	if statuses := heartbeats[0].ServicesStatus; len(statuses) != 10 || statuses[0].Status != "deploying" {
+		t.Errorf("Expected the latest status of all ten services, got %+v", statuses)
+	}
+
+	// A regular heartbeat carries a queued update; the flush is skipped.
+	agent.onServiceLifecycleEvent(api.Service{ID: "svc-0", Name: "web-0"}, "running", "healthy", "")
+	time.Sleep(20 * time.Millisecond)
+	if err := agent.sendHeartbeat(); err != nil {
+		t.Fatalf("Heartbeat failed: %v", err)
+	}
+	time.Sleep(400 * time.Millisecond)
+	if heartbeats := cp.Heartbeats(); len(heartbeats) != 2 || heartbeats[1].ServicesStatus[0].Status != "running" {
+		t.Errorf("Expected the update to ride on the regular heartbeat only, got %d heartbeats", len(heartbeats))
+	}
+	t.Logf("✓ Twenty updates sent in one heartbeat, and none sent twice")
+}
+
+func TestAgentReplaysQueuedHeartbeats(t *testing.T) {
+	t.Logf("Testing heartbeats queued while offline and replayed on reconnect")
+
+	cp := testutil.NewFakeControlPlane(t)
+	cfg := testutil.NewConfig(t, cp.URL)
+	cfg.APIRetryAttempts = 1
+	agent := newTestAgent(t, cfg)
+	agent.lifecycleFlushInterval = time.Hour
+
+	agent.onServiceLifecycleEvent(api.Service{ID: "svc-web", Name: "web"}, "crashed", "", "exit 137")
+	time.Sleep(20 * time.Millisecond)
+	cp.FailNext(1)
+	if err := agent.sendHeartbeat(); err == nil {
+		t.Fatal("Expected the heartbeat to fail")
+	}
+	queued, err := agent.state.QueuedHeartbeats(10)
+	if err != nil || len(queued) != 1 {
+		t.Fatalf("Expected one queued heartbeat, got %d (err=%v)", len(queued), err)
+	}
+	var offline api.HeartbeatRequest
+	json.Unmarshal(queued[0].Payload, &offline)
+
+	if err := agent.sendHeartbeat(); err != nil {
+		t.Fatalf("Heartbeat failed: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(cp.Heartbeats()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	heartbeats := cp.Heartbeats()
+	if len(heartbeats) != 2 || heartbeats[0].Replayed || !heartbeats[1].Replayed {
+		t.Fatalf("Expected a live heartbeat then a replayed one, got %+v", heartbeats)
+	}
+	replayed := heartbeats[1]
+	if !replayed.SentAt.Equal(offline.SentAt) || !replayed.SentAt.Before(heartbeats[0].SentAt) {
+		t.Errorf("Expected the original sent_at %s, got %s", offline.SentAt, replayed.SentAt)
+	}
+	if events := replayed.LifecycleEvents; len(events) != 1 || events[0].ServiceID != "svc-web" || events[0].Status != "crashed" {
+		t.Errorf("Expected the crash transition in the replayed heartbeat, got %+v", events)
+	}
+	if len(heartbeats[0].LifecycleEvents) != 0 {
+		t.Errorf("Expected the transition to be sent once, got %+v", heartbeats[0].LifecycleEvents)
+	}
+	if queued, _ := agent.state.QueuedHeartbeats(10); len(queued) != 0 {
+		t.Errorf("Expected the queue to be empty after replay, got %d", len(queued))
+	}
+	t.Logf("✓ Offline heartbeat and transition replayed with original timestamps")
+}
+
+func TestAgentDropsRejectedHeartbeats(t *testing.T) {
+	t.Logf("Testing heartbeats rejected with a 4xx are neither queued nor replayed")
+
+	cp := testutil.NewFakeControlPlane(t)
+	cfg := testutil.NewConfig(t, cp.URL)
+	cfg.APIRetryAttempts = 1
+	agent := newTestAgent(t, cfg)
+
+	cp.FailNext(1)
+	if err := agent.sendHeartbeat(); err == nil {
+		t.Fatal("Expected the heartbeat to fail")
+	}
+	cp.RejectNext(1)
+	if err := agent.sendHeartbeat(); err == nil {
+		t.Fatal("Expected the heartbeat to be rejected")
+	}
+	if queued, _ := agent.state.QueuedHeartbeats(10); len(queued) != 1 {
+		t.Fatalf("Expected only the 500 heartbeat queued, got %d", len(queued))
+	}
+
+	cp.RejectNext(1)
+	agent.replayHeartbeats()
+	if queued, _ := agent.state.QueuedHeartbeats(10); len(queued) != 0 {
+		t.Errorf("Expected the rejected replay to be dropped, got %d queued", len(queued))
+	}
+	if len(cp.Heartbeats()) != 0 {
+		t.Errorf("Expected no heartbeat accepted, got %+v", cp.Heartbeats())
+	}
+	t.Logf("✓ Rejected heartbeats dropped, failed ones kept")
+}
+
+func TestAgentHeartbeatsDuringSync(t *testing.T) {
+	t.Logf("Testing heartbeats read the firewall and security mode while a sync replaces them")
+
+	cp := testutil.NewFakeControlPlane(t)
+	cfg := testutil.NewConfig(t, cp.URL)
+	agent := newTestAgent(t, cfg)
+	agent.applyFirewall = true
+
+	// Alternating "" and "none" changes the firewall key without touching
+	// host rules, so every sync installs a new firewall manager.
+	var states []api.DesiredState
+	for i := 1; i <= 40; i++ {
+		mode := ""
+		if i%2 == 0 {
+			mode = "none"
+		}
+		states = append(states, api.DesiredState{StackID: cfg.StackID, Version: i, Hash: fmt.Sprintf("v%d", i), SecurityMode: mode})
+	}
+	cp.Script(states...)
+
+	done := make(chan error, 1)
+	go func() {
+		for range states {
+			if err := agent.sync(); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+	for {
+		if err := agent.sendHeartbeat(); err != nil {
+			t.Fatalf("Heartbeat failed: %v", err)
+		}
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("Sync failed: %v", err)
+			}
+		default:
+			continue
+		}
+		break
+	}
+
+	if fw, mode := agent.securityState(); fw == nil || mode != "none" {
+		t.Errorf("Expected the last sync's firewall and mode, got %v %q", fw, mode)
+	}
+	t.Logf("✓ Heartbeats and syncs share the security state safely")
+}
+
+func TestAgentDecommission(t *testing.T) {
+	t.Logf("Testing decommission removes services and deregisters")
+
+	cp := testutil.NewFakeControlPlane(t)
+	docker := testutil.NewFakeDocker(t)
+	cfg := testutil.NewConfig(t, cp.URL)
+	agent := newTestAgent(t, cfg)
+
+	web := api.Service{ID: "svc-web", Name: "web", ServiceType: "docker", DockerImage: "nginx:1.25", Port: 80}
+	cp.SetDesiredState(api.DesiredState{StackID: cfg.StackID, Version: 1, Hash: "v1", Services: []api.Service{web}})
+	if err := agent.sync(); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+
+	if err := agent.decommission(); err != nil {
+		t.Fatalf("Decommission failed: %v", err)
+	}
+	if names := docker.ContainerNames(); len(names) != 0 {
+		t.Errorf("Expected all containers to be removed, got %v", names)
+	}
+	if procs, _ := agent.state.ListServiceProcesses(); len(procs) != 0 {
+		t.Errorf("Expected no services left in state, got %+v", procs)
+	}
+	notices := cp.OfflineNotices()
+	if len(notices) != 1 || notices[0].Reason != api.OfflineReasonDecommission {
+		t.Errorf("Expected a decommission notice, got %+v", notices)
+	}
+	if !cp.Deregistered() {
+		t.Error("Expected the agent to deregister")
+	}
+
+	if reason := offlineReason(syscall.SIGTERM); reason != api.OfflineReasonShutdown {
+		t.Errorf("Expected SIGTERM to be a shutdown, got %s", reason)
+	}
+	for _, sig := range platform.UpgradeSignals {
+		if reason := offlineReason(sig); reason != api.OfflineReasonUpgrade {
+			t.Errorf("Expected %v to be an upgrade, got %s", sig, reason)
+		}
+	}
+	t.Logf("✓ Services removed, offline reason sent and agent deregistered")
+}
+
+func TestAgentReportsDeploys(t *testing.T) {
+	t.Logf("Testing a report sent for each deploy attempt")
+
+	cp := testutil.NewFakeControlPlane(t)
+	docker := testutil.NewFakeDocker(t)
+	cfg := testutil.NewConfig(t, cp.URL)
+	agent := newTestAgent(t, cfg)
+
+	waitReports := func(n int) []api.DeployReport {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for len(cp.DeployReports()) < n && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		return cp.DeployReports()
+	}
+
+	web := api.Service{ID: "svc-web", Name: "web", ServiceType: "docker", DockerImage: "nginx:1.25", Port: 80}
+	cp.SetDesiredState(api.DesiredState{StackID: cfg.StackID, Version: 1, Hash: "v1", Services: []api.Service{web}})
+	if err := agent.sync(); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	reports := waitReports(1)
+	if len(reports) != 1 {
+		t.Fatalf("Expected one deploy report, got %+v", reports)
+	}
+	ok := reports[0]
+	if ok.ServiceID != "svc-web" || ok.Result != "succeeded" || ok.DeployID == "" || ok.ImageID == "" || ok.ErrorClass != "" || ok.FinishedAt.Before(ok.StartedAt) {
+		t.Errorf("Unexpected report for a successful deploy: %+v", ok)
+	}
+
+	docker.PullShouldFail.Store(true)
+	apiSvc := api.Service{ID: "svc-api", Name: "api", ServiceType: "docker", DockerImage: "example/api:2", Port: 8080}
+	cp.SetDesiredState(api.DesiredState{StackID: cfg.StackID, Version: 2, Hash: "v2", Services: []api.Service{web, apiSvc}})
+	if err := agent.sync(); err == nil {
+		t.Fatal("Expected the sync to report the failed deploy")
+	}
+	reports = waitReports(2)
+	if len(reports) != 2 {
+		t.Fatalf("Expected a second deploy report, got %+v", reports)
+	}
+	failed := reports[1]
+	if failed.ServiceID != "svc-api" || failed.Result != "failed" || failed.ErrorClass != api.DeployErrorPull || failed.Error == "" || failed.ImageID != "" {
+		t.Errorf("Unexpected report for a failed pull: %+v", failed)
+	}
+	t.Logf("✓ Successful and failed deploys reported with their class")
+}
+
+func TestScopeWarnings(t *testing.T) {
+	t.Logf("Testing warnings for over- and under-privileged API keys")
+
+	cfg := testutil.NewConfig(t, "")
+	cfg.AgentID = "agent-1"
+	cfg.StackID = "stack-1"
+	cfg.RemoteCommands = false
+	cfg.LogShipEnabled = false
+	cfg.UploadBuildLogs, cfg.ReportDeploys, cfg.UploadDiagnostics = false, false, false
+	if scopes := boundAgentScopes(cfg); len(scopes) != 2 || scopes[0] != "stack:read:stack-1" || scopes[1] != "agent:heartbeat:agent-1" {
+		t.Fatalf("Expected read and heartbeat scopes only, got %q", scopes)
+	}
+
+	if warnings := scopeWarnings(cfg, []string{"stack:read:stack-1", "agent:heartbeat:agent-1"}); len(warnings) != 0 {
+		t.Errorf("Expected no warnings for an exactly scoped key, got %q", warnings)
+	}
+	if warnings := scopeWarnings(cfg, nil); len(warnings) != 0 {
+		t.Errorf("Expected no warnings when scopes are unknown, got %q", warnings)
+	}
+	warnings := scopeWarnings(cfg, []string{"stack:read", "agent:heartbeat:agent-1"})
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "over-privileged") || !strings.Contains(warnings[0], "scopes stack:read go beyond") {
+		t.Errorf("Expected an over-privileged warning for an unbound stack scope, got %q", warnings)
+	}
+	cfg.RemoteCommands = true
+	warnings = scopeWarnings(cfg, []string{"stack:read:stack-1", "agent:heartbeat:agent-1"})
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "lacks scopes agent:commands:agent-1") {
+		t.Errorf("Expected a missing commands scope, got %q", warnings)
+	}
+	t.Logf("✓ Excess and missing scopes reported")
+}
+
+func TestAgentRollsBackFailedDeploys(t *testing.T) {
+	t.Logf("Testing a service rolled back after repeated deploy failures")
+
+	cp := testutil.NewFakeControlPlane(t)
+	docker := testutil.NewFakeDocker(t)
+	cfg := testutil.NewConfig(t, cp.URL)
+	cfg.AutoRollbackFailures = 2
+	agent := newTestAgent(t, cfg)
+
+	if _, err := requestRollback(agent.state, "svc-web"); !errors.Is(err, service.ErrNoRollbackTarget) {
+		t.Fatalf("Expected no rollback target before the first deploy, got %v", err)
+	}
+
+	web := api.Service{ID: "svc-web", Name: "web", ServiceType: "docker", DockerImage: "nginx:1.25", Port: 80}
+	cp.SetDesiredState(api.DesiredState{StackID: cfg.StackID, Version: 1, Hash: "v1", Services: []api.Service{web}})
+	if err := agent.sync(); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	running, _ := docker.Container("potato-cloud-svc-web")
+
+	docker.PullShouldFail.Store(true)
+	web.DockerImage = "nginx:1.26"
+	cp.SetDesiredState(api.DesiredState{StackID: cfg.StackID, Version: 2, Hash: "v2", Services: []api.Service{web}})
+	if err := agent.sync(); err == nil {
+		t.Fatal("Expected the first failed deploy to fail the sync")
+	}
+	if rolledBack := agent.rolledBackRevision("svc-web"); rolledBack != "" {
+		t.Fatalf("Expected no rollback after one failure, got %q", rolledBack)
+	}
+	agent.sync()
+	if agent.rolledBackRevision("svc-web") == "" {
+		t.Fatal("Expected the service rolled back after two failures")
+	}
+	if event, _ := agent.state.LastServiceEvent("svc-web", "rollback"); event == nil || !strings.Contains(event.Message, "trigger=auto") {
+		t.Errorf("Expected an automatic rollback event, got %+v", event)
+	}
+	target, err := agent.state.GetRollbackTarget("svc-web")
+	if err != nil || target == nil || target.ImageTag != running.Image {
+		t.Fatalf("Expected the first release recorded as the rollback target, got %+v (err=%v)", target, err)
+	}
+	if c, ok := docker.Container("potato-cloud-svc-web"); !ok || c.Image != running.Image || c.Status != "running" {
+		t.Errorf("Expected the first release left running, got %+v", c)
+	}
+
+	pulls := docker.CallCount("pull")
+	if err := agent.sync(); err != nil {
+		t.Fatalf("Sync after the rollback failed: %v", err)
+	}
+	if docker.CallCount("pull") != pulls {
+		t.Error("Expected the rolled-back revision not to be deployed again")
+	}
+
+	if _, err := requestRollback(agent.state, "svc-web"); err != nil {
+		t.Fatalf("Failed to request a rollback: %v", err)
+	}
+	if pending, _ := agent.state.GetPendingAction("svc-web"); pending == nil || pending.Action != "rollback" || pending.Reason != rollbackRequested {
+		t.Fatalf("Expected a requested rollback pending, got %+v", pending)
+	}
+	t.Logf("✓ Failed release rolled back and not retried; rollbacks can be requested")
+}
+
+func TestFilePermissions(t *testing.T) {
+	t.Logf("Testing managed file modes are checked and narrowed")
+
+	cfg := config.DefaultConfig()
+	cfg.DataDir = t.TempDir()
+	os.Chmod(cfg.DataDir, 0755)
+	for _, dir := range []string{cfg.ReposPath(), cfg.SSHKeyDir()} {
+		if err := os.MkdirAll(dir, 0777); err != nil {
+			t.Fatalf("Failed to create %s: %v", dir, err)
+		}
+		os.Chmod(dir, 0777)
+	}
+	key := filepath.Join(cfg.SSHKeyDir(), "deploy.pub")
+	if err := os.WriteFile(key, []byte("ssh-ed25519 AAAA"), 0644); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+
+	if problems := checkFilePermissions(cfg, nil); len(problems) != 3 {
+		t.Fatalf("Expected loose repos, ssh and key file to be reported, got %q", problems)
+	}
+	id, err := platform.LookupIdentity(fmt.Sprint(os.Getuid()), fmt.Sprint(os.Getgid()))
+	if err != nil {
+		t.Fatalf("Failed to look up the current user: %v", err)
+	}
+	if err := applyFilePermissions(cfg, id); err != nil {
+		t.Fatalf("applyFilePermissions: %v", err)
+	}
+	if problems := checkFilePermissions(cfg, id); len(problems) != 0 {
+		t.Errorf("Expected no problems after applying, got %q", problems)
+	}
+	if info, _ := os.Stat(cfg.DataDir); info.Mode().Perm() != 0755 {
+		t.Errorf("Expected the data directory to keep mode 0755, got %04o", info.Mode().Perm())
+	}
+	if info, _ := os.Stat(key); info.Mode().Perm() != 0600 {
+		t.Errorf("Expected the key to be narrowed to 0600, got %04o", info.Mode().Perm())
+	}
+
+	cfg.ServiceGroup = "staff"
+	if _, err := serviceIdentity(cfg); err == nil {
+		t.Error("Expected service_group without service_user to be rejected")
+	}
+	t.Logf("✓ Loose modes reported, narrowed and then accepted")
+}
+
+func TestAgentServesSeveralStacks(t *testing.T) {
+	t.Logf("Testing one agent serving services from two stacks")
+
+	cp := testutil.NewFakeControlPlane(t)
+	testutil.NewFakeDocker(t)
+	cfg := testutil.NewConfig(t, cp.URL)
+	cfg.StackIDs = []string{"stack-extra"}
+	agent := newTestAgent(t, cfg)
+
+	cp.SetStackState(cfg.StackID, api.DesiredState{StackID: cfg.StackID, Version: 3, Hash: "main-v3", Services: []api.Service{
+		{ID: "svc-main-web", Name: "web", ServiceType: "docker", DockerImage: "nginx:1.25", Port: 80, Hostname: "web.example.com"},
+	}})
+	cp.SetStackState("stack-extra", api.DesiredState{StackID: "stack-extra", Version: 7, Hash: "extra-v7", Services: []api.Service{
+		{ID: "svc-extra-web", Name: "web", ServiceType: "docker", DockerImage: "nginx:1.25", Port: 80, Hostname: "web.example.com"},
+	}})
+	// Both stacks ask for the same hostname; the second is refused it.
+	if err := agent.sync(); err == nil {
+		t.Fatalf("Expected the sync to report the hostname conflict")
+	}
+
+	for _, serviceID := range []string{"svc-main-web", "svc-extra-web"} {
+		if proc, _ := agent.state.GetServiceProcess(serviceID); proc == nil || proc.Status != "running" {
+			t.Errorf("Expected %s to be running, got %+v", serviceID, proc)
+		}
+	}
+	routes, err := agent.state.GetRoutes("internal")
+	if err != nil {
+		t.Fatalf("Failed to get routes: %v", err)
+	}
+	for _, name := range []string{"web", "web.stack-test", "web.stack-extra"} {
+		if _, ok := routes[name]; !ok {
+			t.Errorf("Expected internal route %s, got %v", name, routes)
+		}
+	}
+	if routes["web.stack-test"] == routes["web.stack-extra"] {
+		t.Errorf("Expected services of different stacks on different ports, got %v", routes)
+	}
+
+	if err := agent.sendHeartbeat(); err != nil {
+		t.Fatalf("Heartbeat failed: %v", err)
+	}
+	heartbeats := cp.Heartbeats()
+	stacks := heartbeats[len(heartbeats)-1].Stacks
+	if len(stacks) != 2 || stacks[0].StackID != cfg.StackID || stacks[1].StackID != "stack-extra" {
+		t.Fatalf("Expected status for both stacks, got %+v", stacks)
+	}
+	if !stacks[0].Applied || stacks[0].Version != 3 {
+		t.Errorf("Expected the primary stack applied at version 3, got %+v", stacks[0])
+	}
+	if stacks[1].Applied || len(stacks[1].Failed) != 1 || stacks[1].Failed[0] != "svc-extra-web" {
+		t.Errorf("Expected the second stack to fail on the taken hostname, got %+v", stacks[1])
+	}
+	t.Logf("✓ Both stacks deployed, routed per stack and reported in heartbeats")
+}
+
+func TestAgentRecordsStateHistory(t *testing.T) {
+	t.Logf("Testing the history of applied desired states")
+
+	cp := testutil.NewFakeControlPlane(t)
+	testutil.NewFakeDocker(t)
+	cfg := testutil.NewConfig(t, cp.URL)
+	agent := newTestAgent(t, cfg)
+
+	web := api.Service{ID: "svc-web", Name: "web", ServiceType: "docker", DockerImage: "nginx:1.25", Port: 80}
+	cp.Script(
+		api.DesiredState{StackID: cfg.StackID, Version: 1, Hash: "v1"},
+		api.DesiredState{StackID: cfg.StackID, Version: 1, Hash: "v1"},
+		api.DesiredState{StackID: cfg.StackID, Version: 2, Hash: "v2", Services: []api.Service{web}},
+	)
+	for i := 0; i < 3; i++ {
+		if err := agent.sync(); err != nil {
+			t.Fatalf("Sync %d failed: %v", i+1, err)
+		}
+	}
+
+	snapshots, err := agent.state.ListStateSnapshots(0)
+	if err != nil || len(snapshots) != 2 || snapshots[0].Version != 2 || snapshots[1].Version != 1 {
+		t.Fatalf("Expected versions 2 and 1 in the history, got %+v (err=%v)", snapshots, err)
+	}
+	snapshot, err := agent.state.GetStateSnapshot(2)
+	if err != nil || snapshot == nil {
+		t.Fatalf("Expected version 2 in the history, got %+v (err=%v)", snapshot, err)
+	}
+	var applied api.DesiredState
+	if err := json.Unmarshal(snapshot.Document, &applied); err != nil {
+		t.Fatalf("Failed to decode the recorded state: %v", err)
+	}
+	if len(applied.Services) != 1 || applied.Services[0].DockerImage != "nginx:1.25" {
+		t.Errorf("Expected the recorded state to hold the web service, got %+v", applied.Services)
+	}
+	t.Logf("✓ Each new applied state recorded once with its document")
+}
+
+func TestAgentFallsBackToCachedStateOffline(t *testing.T) {
+	t.Logf("Testing the cached desired state when the control plane is down at startup")
+
+	cp := testutil.NewFakeControlPlane(t)
+	testutil.NewFakeDocker(t)
+	cfg := testutil.NewConfig(t, cp.URL)
+	agent := newTestAgent(t, cfg)
+	agent.api.SetRetryPolicy(api.RetryPolicy{MaxAttempts: 1})
+
+	cp.FailNext(100)
+	if err := agent.sync(); err == nil {
+		t.Fatalf("Expected the sync to fail with nothing cached")
+	}
+	cp.FailNext(0)
+
+	web := api.Service{ID: "svc-web", Name: "web", ServiceType: "docker", DockerImage: "nginx:1.25", Port: 80}
+	cp.SetDesiredState(api.DesiredState{StackID: cfg.StackID, Version: 5, Hash: "v5", Services: []api.Service{web}})
+	if err := agent.sync(); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+
+	// Once fetched, a failed fetch fails the sync as before.
+	cp.FailNext(100)
+	if err := agent.sync(); err == nil {
+		t.Fatalf("Expected the sync to fail while the agent has a live state")
+	}
+
+	// After a restart, which forgets the live state but keeps the state
+	// database, the cached state is applied instead.
+	agent.stackStates = nil
+	desired, err := agent.fetchDesiredState()
+	if err != nil {
+		t.Fatalf("Expected the cached state, got %v", err)
+	}
+	if !desired.Stale || desired.Version != 5 || len(desired.Services) != 1 || desired.Services[0].ID != "svc-web" {
+		t.Errorf("Expected stale version 5 with the web service, got %+v", desired)
+	}
+	if err := agent.sync(); err != nil {
+		t.Fatalf("Expected the sync to apply the cached state, got %v", err)
+	}
+	t.Logf("✓ Cached state restored while the control plane is unreachable")
+}
+
+func TestAgentUploadsBuildLogs(t *testing.T) {
+	t.Logf("Testing build log uploads and retries")
+
+	cp := testutil.NewFakeControlPlane(t)
+	testutil.NewFakeDocker(t)
+	cfg := testutil.NewConfig(t, cp.URL)
+	agent := newTestAgent(t, cfg)
+	agent.api.SetRetryPolicy(api.RetryPolicy{MaxAttempts: 1})
+
+	now := time.Now()
+	for _, deployID := range []string{"deploy-1", "deploy-2"} {
+		err := agent.state.SaveBuildLog(&state.BuildLog{ServiceID: "svc-web", DeployID: deployID, Status: "failed", Output: "npm ERR! missing script: build", StartedAt: now, FinishedAt: now})
+		if err != nil {
+			t.Fatalf("Failed to save build log: %v", err)
+		}
+	}
+
+	cp.FailNext(1)
+	agent.uploadBuildLogs()
+	if uploaded := cp.BuildLogs(); len(uploaded) != 0 {
+		t.Fatalf("Expected nothing uploaded while the control plane fails, got %d", len(uploaded))
+	}
+	if pending, _ := agent.state.PendingBuildLogs(); len(pending) != 2 {
+		t.Fatalf("Expected both build logs still pending, got %d", len(pending))
+	}
+
+	agent.uploadBuildLogs()
+	uploaded := cp.BuildLogs()
+	if len(uploaded) != 2 || uploaded[0].DeployID != "deploy-1" || uploaded[0].Output != "npm ERR! missing script: build" {
+		t.Fatalf("Expected both build logs uploaded oldest first, got %+v", uploaded)
+	}
+	if pending, _ := agent.state.PendingBuildLogs(); len(pending) != 0 {
+		t.Errorf("Expected no pending build logs, got %d", len(pending))
+	}
+	agent.uploadBuildLogs()
+	if len(cp.BuildLogs()) != 2 {
+		t.Errorf("Expected uploaded build logs not to be sent again")
+	}
+	t.Logf("✓ Build logs uploaded once, retried after a failure")
+}
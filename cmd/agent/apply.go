@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/buildvigil/agent/internal/api"
+)
+
+// recordApplyResult records the revision of every desired service that applied
+// in a sync and keeps the sync's outcome for the next heartbeat. Held services
+// are neither applied nor failed.
+func (a *Agent) recordApplyResult(desired *api.DesiredState, stateHash string, failed, held map[string]bool) {
+	report := &api.ApplyReport{
+		StackVersion: desired.Version,
+		StateHash:    stateHash,
+		Succeeded:    []string{},
+		FinishedAt:   time.Now().UTC(),
+	}
+	for _, svc := range desired.Services {
+		if failed[svc.ID] || held[svc.ID] {
+			continue
+		}
+		if svc.GitRef == "" {
+			svc.GitRef = "main"
+		}
+		revision := serviceDefinitionHash(svc)
+		if proc, _ := a.state.GetServiceProcess(svc.ID); proc != nil && proc.GitCommit != "" {
+			revision += ":" + proc.GitCommit
+		}
+		if err := a.state.SetServiceRevision(svc.ID, desired.Version, revision); err != nil {
+			log.Printf("Failed to record revision for service %s: %v", svc.Name, err)
+		}
+		report.Succeeded = append(report.Succeeded, svc.ID)
+	}
+	for serviceID := range failed {
+		report.Failed = append(report.Failed, serviceID)
+	}
+	sort.Strings(report.Succeeded)
+	sort.Strings(report.Failed)
+	report.Partial = len(report.Failed) > 0
+
+	if report.Partial {
+		log.Printf("State partially applied: version=%d succeeded=%d failed=%v", desired.Version, len(report.Succeeded), report.Failed)
+	}
+
+	a.applyMu.Lock()
+	a.lastApply = report
+	a.applyMu.Unlock()
+
+	if document, err := json.Marshal(desired); err != nil {
+		log.Printf("Failed to encode applied state: %v", err)
+	} else if err := a.state.RecordStateSnapshot(desired.Version, desired.Hash, report.Partial, document); err != nil {
+		log.Printf("Failed to record applied state: %v", err)
+	}
+	a.recordStackResults(desired, failed)
+}
+
+// applyReport returns the outcome of the latest sync with the applied
+// revision of every service, or nil before the first sync.
+func (a *Agent) applyReport() *api.ApplyReport {
+	a.applyMu.Lock()
+	last := a.lastApply
+	a.applyMu.Unlock()
+	if last == nil {
+		return nil
+	}
+
+	report := *last
+	revisions, err := a.state.ListServiceRevisions()
+	if err != nil {
+		log.Printf("Failed to list service revisions: %v", err)
+	}
+	for _, rev := range revisions {
+		report.Revisions = append(report.Revisions, api.AppliedRevision{
+			ServiceID:    rev.ServiceID,
+			StackVersion: rev.StackVersion,
+			Revision:     rev.Revision,
+			AppliedAt:    rev.AppliedAt,
+		})
+	}
+	return &report
+}
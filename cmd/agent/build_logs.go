@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/buildvigil/agent/internal/api"
+	"github.com/buildvigil/agent/internal/service"
+)
+
+// onBuildLog uploads a build's output, already stored by the service
+// manager, along with any earlier ones still pending.
+func (a *Agent) onBuildLog(buildLog api.BuildLog) {
+	log.Printf("Build finished: service=%s deploy=%s status=%s", buildLog.ServiceID, buildLog.DeployID, buildLog.Status)
+	go a.uploadBuildLogs()
+}
+
+// uploadBuildLogs uploads stored build logs the control plane hasn't
+// received yet, oldest first, stopping at the first failure so the rest are
+// retried after the next heartbeat.
+func (a *Agent) uploadBuildLogs() {
+	if !a.config.UploadBuildLogs {
+		return
+	}
+	a.buildLogsMu.Lock()
+	defer a.buildLogsMu.Unlock()
+
+	pending, err := a.state.PendingBuildLogs()
+	if err != nil {
+		log.Printf("Failed to list pending build logs: %v", err)
+		return
+	}
+	for _, buildLog := range pending {
+		err := a.api.UploadBuildLog(a.runCtx, api.BuildLog{
+			ServiceID:  buildLog.ServiceID,
+			DeployID:   buildLog.DeployID,
+			GitCommit:  buildLog.GitCommit,
+			Status:     buildLog.Status,
+			Builder:    buildLog.Builder,
+			Output:     buildLog.Output,
+			Truncated:  buildLog.Truncated,
+			StartedAt:  buildLog.StartedAt,
+			FinishedAt: buildLog.FinishedAt,
+		})
+		if err != nil {
+			log.Printf("Build log upload failed: service=%s deploy=%s err=%v", buildLog.ServiceID, buildLog.DeployID, err)
+			return
+		}
+		if err := a.state.MarkBuildLogUploaded(buildLog.ServiceID, buildLog.DeployID); err != nil {
+			log.Printf("Failed to mark build log uploaded: service=%s deploy=%s err=%v", buildLog.ServiceID, buildLog.DeployID, err)
+			return
+		}
+		log.Printf("Build log uploaded: service=%s deploy=%s", buildLog.ServiceID, buildLog.DeployID)
+	}
+}
+
+// handleShowBuildLog prints the stored Docker build output of a service's
+// latest build, or of the given deploy ID ("current" and "previous" work as
+// with -deploy).
+func handleShowBuildLog(configPath, serviceID, deployRef string) error {
+	if serviceID == "" {
+		return fmt.Errorf("service ID is required (use -log-service flag)")
+	}
+	stateMgr, err := openStateDB(configPath)
+	if err != nil {
+		return err
+	}
+	defer stateMgr.Close()
+
+	deployID := ""
+	if deployRef != "" {
+		if deployID, err = stateMgr.ResolveDeployRef(serviceID, deployRef); err != nil {
+			return err
+		}
+	}
+	buildLog, err := stateMgr.GetBuildLog(serviceID, deployID)
+	if err != nil {
+		return err
+	}
+	if buildLog == nil {
+		if deployID != "" {
+			return fmt.Errorf("no build log for deploy %s of service %s", deployID, serviceID)
+		}
+		return fmt.Errorf("no build logs recorded for service %s", serviceID)
+	}
+
+	uploaded := "not uploaded"
+	if !buildLog.UploadedAt.IsZero() {
+		uploaded = "uploaded"
+	}
+	fmt.Fprintf(os.Stderr, "Build %s of service %s: %s in %s on %s (%s)\n",
+		buildLog.DeployID, buildLog.ServiceID, buildLog.Status,
+		buildLog.FinishedAt.Sub(buildLog.StartedAt).Round(time.Second), buildLog.Builder, uploaded)
+	if buildLog.Truncated {
+		fmt.Fprintf(os.Stderr, "(earlier output dropped; only the last %d lines are kept)\n", service.BuildLogLines)
+	}
+	fmt.Println(buildLog.Output)
+	return nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/buildvigil/agent/internal/container"
+	"github.com/buildvigil/agent/internal/metrics"
+	"github.com/buildvigil/agent/internal/tunnel"
+)
+
+// capabilitiesRefreshInterval is how long detected host capabilities are
+// reported before heartbeats detect them again.
+const capabilitiesRefreshInterval = 10 * time.Minute
+
+// Host capabilities reported to the control plane with the build's
+// features, so it can gate features per agent.
+const (
+	capabilityDocker        = "docker"
+	capabilityUFW           = "ufw"
+	capabilityCloudflared   = "cloudflared"
+	runtimeCapabilityPrefix = "runtime:"
+)
+
+// detectCapabilities returns what this host can do: whether the Docker
+// daemon answers, whether fw (which may be nil) and cloudflared are usable,
+// and a runtime entry per language services can be auto-containerized from.
+func detectCapabilities(fw interface{ IsAvailable() bool }) []string {
+	var capabilities []string
+	if metrics.DockerAvailable() {
+		capabilities = append(capabilities, capabilityDocker)
+	}
+	if fw != nil && fw.IsAvailable() {
+		capabilities = append(capabilities, capabilityUFW)
+	}
+	if tunnel.IsCloudflaredAvailable() {
+		capabilities = append(capabilities, capabilityCloudflared)
+	}
+	for _, language := range container.Languages() {
+		capabilities = append(capabilities, runtimeCapabilityPrefix+language)
+	}
+	return capabilities
+}
+
+// hostCapabilities returns the host's capabilities for a heartbeat,
+// detecting them again once they are older than capabilitiesRefreshInterval.
+func (a *Agent) hostCapabilities(fw firewallManager) []string {
+	a.capabilitiesMu.Lock()
+	defer a.capabilitiesMu.Unlock()
+	if a.capabilities != nil && time.Since(a.capabilitiesAt) < capabilitiesRefreshInterval {
+		return a.capabilities
+	}
+	capabilities := detectCapabilities(fw)
+	if !slices.Equal(capabilities, a.capabilities) {
+		log.Printf("Host capabilities: %s", strings.Join(capabilities, ","))
+	}
+	a.capabilities = capabilities
+	a.capabilitiesAt = time.Now()
+	return capabilities
+}
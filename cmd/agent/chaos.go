@@ -0,0 +1,32 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/buildvigil/agent/internal/chaos"
+	"github.com/buildvigil/agent/internal/config"
+)
+
+// configureChaos applies the chaos_* failure injection rates. They only take
+// effect in builds with the chaos tag.
+func configureChaos(cfg *config.Config) error {
+	active, err := chaos.Configure(chaos.Settings{
+		Rates: map[chaos.Fault]float64{
+			chaos.BuildFailure:      cfg.ChaosBuildFailureRate,
+			chaos.HealthTimeout:     cfg.ChaosHealthTimeoutRate,
+			chaos.ControlPlaneError: cfg.ChaosControlPlaneErrorRate,
+			chaos.DockerSlowdown:    cfg.ChaosDockerSlowdownRate,
+		},
+		DockerDelay: time.Duration(cfg.ChaosDockerDelayMs) * time.Millisecond,
+	})
+	if err != nil {
+		return err
+	}
+	if active {
+		log.Printf("Warning: chaos mode is injecting failures: %s", chaos.Summary())
+	} else if summary := chaos.Summary(); summary != "" && !chaos.Enabled() {
+		log.Printf("Chaos rates ignored; this build was not made with the chaos tag: %s", summary)
+	}
+	return nil
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/buildvigil/agent/internal/api"
+	"github.com/buildvigil/agent/internal/service"
+	"github.com/buildvigil/agent/internal/state"
+)
+
+const (
+	// maxCommandOutput caps the output posted back for one command.
+	maxCommandOutput = 64 * 1024
+	// commandMemory is how long a command ID is remembered, so a command the
+	// control plane lists again before it sees the result is not rerun.
+	commandMemory = 24 * time.Hour
+	// defaultCommandLogLines and maxCommandLogLines bound fetch_logs.
+	defaultCommandLogLines = 100
+	maxCommandLogLines     = 1000
+)
+
+// errCommandRejected marks commands the agent will not run, as opposed to
+// ones that ran and failed.
+var errCommandRejected = errors.New("command rejected")
+
+// runCommands fetches the commands queued by the control plane, runs each
+// once and posts back its result. Results that could not be posted are sent
+// again on the next run, without running the command again.
+func (a *Agent) runCommands() {
+	if !a.config.RemoteCommands {
+		return
+	}
+	a.commandsMu.Lock()
+	defer a.commandsMu.Unlock()
+
+	a.sendCommandResults()
+	commands, err := a.api.GetCommands(a.runCtx)
+	if err != nil {
+		log.Printf("Failed to fetch commands: %v", err)
+		return
+	}
+	for id, seen := range a.commandsSeen {
+		if time.Since(seen) > commandMemory {
+			delete(a.commandsSeen, id)
+		}
+	}
+	for _, cmd := range commands {
+		if _, ok := a.commandsSeen[cmd.ID]; ok || cmd.ID == "" {
+			continue
+		}
+		a.commandsSeen[cmd.ID] = time.Now()
+		a.unsentResults = append(a.unsentResults, a.runCommand(cmd))
+		a.sendCommandResults()
+	}
+}
+
+// sendCommandResults posts unsent command results, keeping those that fail
+// for the next run. Callers hold commandsMu.
+func (a *Agent) sendCommandResults() {
+	remaining := a.unsentResults[:0]
+	for _, result := range a.unsentResults {
+		if err := a.api.SendCommandResult(a.runCtx, result); err != nil {
+			log.Printf("Failed to send command result: id=%s err=%v", result.CommandID, err)
+			remaining = append(remaining, result)
+		}
+	}
+	a.unsentResults = remaining
+}
+
+func (a *Agent) runCommand(cmd api.Command) api.CommandResult {
+	result := api.CommandResult{CommandID: cmd.ID, StartedAt: time.Now()}
+	log.Printf("Running command: id=%s type=%s service=%s", cmd.ID, cmd.Type, cmd.ServiceID)
+
+	output, err := a.executeCommand(cmd)
+	switch {
+	case errors.Is(err, errCommandRejected):
+		result.Status = "rejected"
+		result.Error = err.Error()
+	case err != nil:
+		result.Status = "failed"
+		result.Error = err.Error()
+	default:
+		result.Status = "succeeded"
+	}
+	if len(output) > maxCommandOutput {
+		output = output[len(output)-maxCommandOutput:]
+	}
+	result.Output = output
+	result.CompletedAt = time.Now()
+
+	log.Printf("Command finished: id=%s type=%s service=%s status=%s elapsed=%s", cmd.ID, cmd.Type, cmd.ServiceID, result.Status, result.CompletedAt.Sub(result.StartedAt))
+	if err := a.state.RecordEvent(cmd.ServiceID, "command", fmt.Sprintf("%s %s (id=%s)", cmd.Type, result.Status, cmd.ID)); err != nil {
+		log.Printf("Failed to record command event: %v", err)
+	}
+	return result
+}
+
+// executeCommand runs a command and returns its output.
+func (a *Agent) executeCommand(cmd api.Command) (string, error) {
+	if strings.TrimSpace(cmd.ServiceID) == "" {
+		return "", fmt.Errorf("%w: service_id is required", errCommandRejected)
+	}
+	a.desiredMu.RLock()
+	svc, known := a.desiredServices[cmd.ServiceID]
+	a.desiredMu.RUnlock()
+
+	switch cmd.Type {
+	case api.CommandRestartService:
+		if err := a.checkRedeployable(svc, known); err != nil {
+			return "", err
+		}
+		return "", a.services.RestartService(cmd.ServiceID)
+
+	case api.CommandRedeployService:
+		if err := a.checkRedeployable(svc, known); err != nil {
+			return "", err
+		}
+		if err := a.checkChangeConfirmed(svc); err != nil {
+			return "", err
+		}
+		return "", a.redeployService(svc)
+
+	case api.CommandFetchLogs:
+		lines := defaultCommandLogLines
+		if value := cmd.Args["lines"]; value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n <= 0 {
+				return "", fmt.Errorf("%w: invalid lines %q", errCommandRejected, value)
+			}
+			lines = min(n, maxCommandLogLines)
+		}
+		logs, err := a.state.GetServiceLogs(cmd.ServiceID, lines)
+		if err != nil {
+			return "", err
+		}
+		var out strings.Builder
+		for i := len(logs) - 1; i >= 0; i-- {
+			fmt.Fprintf(&out, "%s [%s] %s\n", logs[i].CreatedAt.Format(time.RFC3339), logs[i].Level, logs[i].Message)
+		}
+		return out.String(), nil
+
+	case api.CommandHealthCheck:
+		healthy, err := a.services.ProbeService(cmd.ServiceID)
+		if err != nil {
+			return "", err
+		}
+		if healthy {
+			return "healthy", nil
+		}
+		return "unhealthy", nil
+
+	case api.CommandRotateSecret:
+		name, value := strings.TrimSpace(cmd.Args["name"]), cmd.Args["value"]
+		if name == "" || value == "" {
+			return "", fmt.Errorf("%w: name and value are required", errCommandRejected)
+		}
+		if err := a.checkRedeployable(svc, known); err != nil {
+			return "", err
+		}
+		if err := a.checkChangeConfirmed(svc); err != nil {
+			return "", err
+		}
+		if a.secrets == nil {
+			return "", fmt.Errorf("secrets are not available on this agent")
+		}
+		if err := a.secrets.SetSecret(name, cmd.ServiceID, value); err != nil {
+			return "", err
+		}
+		// The container only sees the new value once it is recreated.
+		if err := a.redeployService(svc); err != nil {
+			return fmt.Sprintf("secret %s updated", name), err
+		}
+		return fmt.Sprintf("secret %s updated; service redeployed", name), nil
+
+	case api.CommandConfirmDeploy:
+		if _, err := confirmPendingChange(a.state, cmd.ServiceID, strings.TrimSpace(cmd.Args["revision"])); err != nil {
+			if errors.Is(err, errNothingToConfirm) {
+				return "", fmt.Errorf("%w: %v", errCommandRejected, err)
+			}
+			return "", err
+		}
+		a.requestSync()
+		return "change confirmed; deploying on the next sync", nil
+
+	case api.CommandRollbackService:
+		if err := a.checkRedeployable(svc, known); err != nil {
+			return "", err
+		}
+		if err := a.rollbackCurrentRelease(cmd.ServiceID, "command"); err != nil {
+			if errors.Is(err, service.ErrNoRollbackTarget) {
+				return "", fmt.Errorf("%w: %v", errCommandRejected, err)
+			}
+			return "", err
+		}
+		return "rolled back to the previous release", nil
+	}
+	return "", fmt.Errorf("%w: unsupported command type %q", errCommandRejected, cmd.Type)
+}
+
+// checkRedeployable rejects restarts and redeploys of services the agent
+// doesn't run containers for, or that are on hold.
+func (a *Agent) checkRedeployable(svc api.Service, known bool) error {
+	switch {
+	case !known:
+		return fmt.Errorf("%w: service is not in the desired state", errCommandRejected)
+	case service.IsTask(svc):
+		return fmt.Errorf("%w: tasks are run by sync", errCommandRejected)
+	case a.serviceHeld(svc):
+		return fmt.Errorf("%w: service is on hold", errCommandRejected)
+	}
+	return nil
+}
+
+// checkChangeConfirmed rejects redeploys that would apply a change to a
+// running protected service before an operator confirmed its revision, the
+// same gate sync applies.
+func (a *Agent) checkChangeConfirmed(svc api.Service) error {
+	if !svc.Protected {
+		return nil
+	}
+	proc, _ := a.state.GetServiceProcess(svc.ID)
+	if proc == nil || proc.Status != "running" {
+		return nil
+	}
+	definitionHash := serviceDefinitionHash(svc)
+	commit := redeployCommit(svc, proc)
+	unchanged := (proc.DefinitionHash == "" || proc.DefinitionHash == definitionHash) && proc.GitCommit == commit
+	if unchanged || a.deployConfirmed(svc.ID, definitionHash+":"+commit) {
+		return nil
+	}
+	return fmt.Errorf("%w: protected service change is awaiting confirmation", errCommandRejected)
+}
+
+// redeployCommit returns the revision a command redeploy uses: the image
+// signature, the pinned commit, or else the commit already deployed.
+func redeployCommit(svc api.Service, proc *state.ServiceProcess) string {
+	if service.UsesPrebuiltImage(svc) {
+		return serviceRevisionSignature(svc)
+	}
+	if proc != nil && strings.TrimSpace(svc.GitCommit) == "" {
+		return proc.GitCommit
+	}
+	return svc.GitCommit
+}
+
+// redeployService rebuilds or re-pulls a service at its current revision and
+// replaces its container.
+func (a *Agent) redeployService(svc api.Service) error {
+	proc, _ := a.state.GetServiceProcess(svc.ID)
+	svc.GitCommit = redeployCommit(svc, proc)
+
+	a.onServiceLifecycleEvent(svc, "building", "unknown", "")
+	log.Printf("Deploying service: name=%s service=%s reason=command", svc.Name, svc.ID)
+	if err := a.services.DeployService(svc); err != nil {
+		a.onServiceLifecycleEvent(svc, "error", "unknown", err.Error())
+		return err
+	}
+	return nil
+}
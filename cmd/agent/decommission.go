@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/buildvigil/agent/internal/api"
+	"github.com/buildvigil/agent/internal/config"
+	"github.com/buildvigil/agent/internal/firewall"
+	"github.com/buildvigil/agent/internal/platform"
+	"github.com/buildvigil/agent/internal/privhelper"
+	"github.com/buildvigil/agent/internal/secrets"
+	"github.com/buildvigil/agent/internal/state"
+)
+
+// offlineNoticeTimeout bounds the offline notice and deregistration, so an
+// unreachable control plane doesn't hold up shutdown.
+const offlineNoticeTimeout = 5 * time.Second
+
+// offlineReason is the reason sent with the offline notice for the signal
+// that stopped the agent.
+func offlineReason(sig os.Signal) string {
+	for _, upgrade := range platform.UpgradeSignals {
+		if sig == upgrade {
+			return api.OfflineReasonUpgrade
+		}
+	}
+	return api.OfflineReasonShutdown
+}
+
+// announceOffline tells the control plane the agent is stopping and why.
+func (a *Agent) announceOffline(reason string) {
+	ctx, cancel := context.WithTimeout(context.Background(), offlineNoticeTimeout)
+	defer cancel()
+	if err := a.api.GoingOffline(ctx, reason); err != nil {
+		log.Printf("Failed to send offline notice: reason=%s err=%v", reason, err)
+		return
+	}
+	log.Printf("Offline notice sent: reason=%s", reason)
+}
+
+// decommission announces the agent is going away, stops all services,
+// removes their networks and images, /etc/hosts entries and firewall
+// rules, then deregisters from the control plane. Cleanup carries on past
+// failures, which are returned together.
+func (a *Agent) decommission() error {
+	a.announceOffline(api.OfflineReasonDecommission)
+
+	var errs []error
+	if err := a.services.Decommission(a.config.Stacks()); err != nil {
+		errs = append(errs, err)
+	}
+	if err := a.hosts.Cleanup(); err != nil {
+		errs = append(errs, fmt.Errorf("failed to clean up hosts file: %w", err))
+	}
+	// Revert only removes rules tagged by the agent, so it is safe even if
+	// this run never applied any.
+	fw, _ := a.securityState()
+	if fw == nil {
+		fw = a.newFirewall(firewall.NewManager(firewall.SecurityModeNone, 0))
+	}
+	if fw.IsAvailable() {
+		if err := fw.Revert(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to revert firewall rules: %w", err))
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), offlineNoticeTimeout)
+	defer cancel()
+	if err := a.api.Deregister(ctx); err != nil {
+		errs = append(errs, err)
+	} else {
+		log.Printf("Agent deregistered: agent=%s", a.config.AgentID)
+	}
+	return errors.Join(errs...)
+}
+
+// handleDecommission removes everything the agent deployed from this
+// machine and deregisters it, clearing its API key from the config file.
+// The agent service should be stopped first, or it redeploys.
+func handleDecommission(configPath string) error {
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	if err := cfg.ApplyProxyEnv(); err != nil {
+		return fmt.Errorf("failed to apply proxy settings: %w", err)
+	}
+	stateMgr, err := state.NewManager(cfg.StateDBPath())
+	if err != nil {
+		return fmt.Errorf("failed to open state: %w", err)
+	}
+	defer stateMgr.Close()
+	secretsMgr, err := secrets.NewManager(cfg.SecretsPath(), cfg.AgentID)
+	if err != nil {
+		return fmt.Errorf("failed to open secrets: %w", err)
+	}
+	agent, err := newAgent(cfg, stateMgr, secretsMgr, true)
+	if err != nil {
+		return err
+	}
+	if cfg.PrivilegedHelperSocket != "" {
+		if err := agent.usePrivilegedHelper(privhelper.NewClient(cfg.PrivilegedHelperSocket)); err != nil {
+			return err
+		}
+	}
+
+	if err := agent.decommission(); err != nil {
+		return err
+	}
+	cfg.APIKey = ""
+	if err := cfg.Save(configPath); err != nil {
+		return fmt.Errorf("failed to save config: %w", err)
+	}
+	fmt.Printf("✓ Agent %s decommissioned and deregistered from %s\n", cfg.AgentID, cfg.ControlPlane)
+	fmt.Printf("- Data left in %s can be removed\n", cfg.DataDir)
+	return nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"log"
+
+	"github.com/buildvigil/agent/internal/api"
+)
+
+// maxUnsentDeployReports caps the deploy reports kept while the control
+// plane is unreachable; the oldest are dropped first.
+const maxUnsentDeployReports = 200
+
+// onDeployReport logs a deploy's outcome and sends it to the control plane.
+// It is called with the service manager's lock held, so sending happens in
+// the background.
+func (a *Agent) onDeployReport(report api.DeployReport) {
+	log.Printf("Deploy %s: service=%s deploy=%s duration_ms=%d error_class=%s", report.Result, report.ServiceID, report.DeployID, report.DurationMS, report.ErrorClass)
+	if !a.config.ReportDeploys {
+		return
+	}
+	go func() {
+		a.deployReportsMu.Lock()
+		defer a.deployReportsMu.Unlock()
+		a.unsentDeployReports = append(a.unsentDeployReports, report)
+		if excess := len(a.unsentDeployReports) - maxUnsentDeployReports; excess > 0 {
+			a.unsentDeployReports = a.unsentDeployReports[excess:]
+		}
+		a.sendDeployReports()
+	}()
+}
+
+// retryDeployReports sends deploy reports that failed earlier.
+func (a *Agent) retryDeployReports() {
+	a.deployReportsMu.Lock()
+	defer a.deployReportsMu.Unlock()
+	a.sendDeployReports()
+}
+
+// sendDeployReports posts unsent deploy reports in order, stopping at the
+// first failure so the rest are retried after the next heartbeat. Callers
+// hold deployReportsMu.
+func (a *Agent) sendDeployReports() {
+	for len(a.unsentDeployReports) > 0 {
+		report := a.unsentDeployReports[0]
+		if err := a.api.ReportDeploy(a.runCtx, report); err != nil {
+			log.Printf("Failed to send deploy report: service=%s deploy=%s err=%v", report.ServiceID, report.DeployID, err)
+			return
+		}
+		a.unsentDeployReports = a.unsentDeployReports[1:]
+	}
+}
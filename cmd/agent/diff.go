@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/buildvigil/agent/internal/config"
+)
+
+// runDiffCommand handles `agent diff`, which reports drift between the
+// desired state and what this host is running.
+func runDiffCommand(args []string) error {
+	fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+	configPath := fs.String("config", config.ConfigPath(), "Path to config file")
+	asJSON := fs.Bool("json", false, "Print the report as JSON, as sent in heartbeats")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	view, err := openLocalView(*configPath)
+	if err != nil {
+		return err
+	}
+	defer view.Close()
+	report := buildDriftReport(view.desired.Services, view.desired.Version, view.state, view.services)
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	}
+	fmt.Printf("%-20s %-16s %-28s %s\n", "SERVICE", "CATEGORY", "DIFFERENCES", "DETAIL")
+	drifted := 0
+	for _, drift := range report.Services {
+		if drift.Category != "in_sync" {
+			drifted++
+		}
+		differences := strings.Join(drift.Differences, ",")
+		if differences == "" {
+			differences = "-"
+		}
+		fmt.Printf("%-20s %-16s %-28s %s\n",
+			truncate(drift.Name, 20),
+			drift.Category,
+			truncate(differences, 28),
+			truncate(strings.Join(strings.Fields(drift.Detail), " "), 60))
+	}
+	fmt.Println()
+	if report.InSync {
+		fmt.Printf("✓ In sync with desired state version %d\n", report.StackVersion)
+	} else {
+		fmt.Printf("%d of %d services drifted from desired state version %d\n", drifted, len(report.Services), report.StackVersion)
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/buildvigil/agent/internal/config"
+	"github.com/buildvigil/agent/internal/platform"
+)
+
+// handleDoctor checks the configured service user, umask, and the ownership
+// and modes of the agent's files, printing each problem found.
+func handleDoctor(configPath string) error {
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	var problems []string
+	if mask, ok, err := cfg.Umask(); err != nil {
+		problems = append(problems, err.Error())
+	} else if ok {
+		fmt.Printf("✓ File umask %04o\n", mask)
+	}
+	id, err := serviceIdentity(cfg)
+	switch {
+	case err != nil:
+		problems = append(problems, err.Error())
+	case id == nil:
+		fmt.Println("- No service_user; files are owned by the user running the agent")
+	case !platform.IsRoot() && uint32(os.Geteuid()) != id.UID:
+		problems = append(problems, fmt.Sprintf("service_user %s needs the agent started as root or as %s", id.User, id.User))
+	default:
+		fmt.Printf("✓ Service user %s (uid %d, gid %d)\n", id.User, id.UID, id.GID)
+		if platform.IsRoot() && cfg.PrivilegedHelperSocket == "" {
+			fmt.Println("- No privileged_helper_socket; the agent keeps root for firewall and /etc/hosts")
+		}
+	}
+	problems = append(problems, checkFilePermissions(cfg, id)...)
+
+	if len(problems) == 0 {
+		fmt.Println("✓ File ownership and permissions are as expected")
+		return nil
+	}
+	for _, problem := range problems {
+		fmt.Printf("✗ %s\n", problem)
+	}
+	return fmt.Errorf("%d problem(s) found; starting the agent fixes file ownership and modes", len(problems))
+}
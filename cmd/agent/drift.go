@@ -0,0 +1,163 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/buildvigil/agent/internal/api"
+	"github.com/buildvigil/agent/internal/service"
+	"github.com/buildvigil/agent/internal/state"
+)
+
+// driftReport compares the latest desired state with what is running, or
+// returns nil before the first sync.
+func (a *Agent) driftReport() *api.DriftReport {
+	a.desiredMu.RLock()
+	desired := make([]api.Service, 0, len(a.desiredServices))
+	for _, svc := range a.desiredServices {
+		desired = append(desired, svc)
+	}
+	version, synced := a.desiredVersion, a.desiredServices != nil
+	a.desiredMu.RUnlock()
+	if !synced {
+		return nil
+	}
+	sort.Slice(desired, func(i, j int) bool { return desired[i].ID < desired[j].ID })
+	return buildDriftReport(desired, version, a.state, a.services)
+}
+
+// buildDriftReport compares desired services with the recorded processes,
+// their containers and the persisted external routes. It reads only local
+// state and docker, so it works whether or not the agent is running.
+func buildDriftReport(desired []api.Service, version int, stateMgr *state.Manager, svcMgr *service.Manager) *api.DriftReport {
+	report := &api.DriftReport{StackVersion: version, InSync: true, Services: []api.ServiceDrift{}, GeneratedAt: time.Now().UTC()}
+	routes, err := stateMgr.GetRoutes("external")
+	if err != nil {
+		log.Printf("Failed to read routes for drift report: %v", err)
+	}
+
+	desiredIDs := make(map[string]bool, len(desired))
+	for _, svc := range desired {
+		desiredIDs[svc.ID] = true
+		if service.IsTask(svc) {
+			continue
+		}
+		drift := serviceDrift(svc, stateMgr, svcMgr, routes)
+		report.InSync = report.InSync && drift.Category == "in_sync"
+		report.Services = append(report.Services, drift)
+	}
+
+	processes, err := stateMgr.ListServiceProcesses()
+	if err != nil {
+		log.Printf("Failed to list processes for drift report: %v", err)
+	}
+	for _, proc := range processes {
+		if desiredIDs[proc.ServiceID] {
+			continue
+		}
+		drift := api.ServiceDrift{
+			ServiceID:    proc.ServiceID,
+			Name:         proc.ServiceName,
+			Category:     "pending_removal",
+			Differences:  []string{"replicas"},
+			ActualCommit: proc.GitCommit,
+		}
+		if status, _ := svcMgr.ContainerStatus(proc.ContainerName); status == "running" {
+			drift.RunningReplicas = 1
+		}
+		if hold, _ := stateMgr.GetServiceHold(proc.ServiceID); hold != nil {
+			drift.Category, drift.Detail = "held", hold.Reason
+		}
+		report.InSync = false
+		report.Services = append(report.Services, drift)
+	}
+	return report
+}
+
+// serviceDrift compares one desired service with what is running. The
+// category is the most pressing of: held, manual_override, failed,
+// pending_deploy, in_sync.
+func serviceDrift(svc api.Service, stateMgr *state.Manager, svcMgr *service.Manager, routes map[string]int) api.ServiceDrift {
+	drift := api.ServiceDrift{ServiceID: svc.ID, Name: svc.Name, Category: "in_sync", DesiredReplicas: 1}
+	if service.UsesPrebuiltImage(svc) {
+		drift.DesiredCommit = serviceRevisionSignature(svc)
+	} else {
+		// Branch-tracking services have no desired commit until the next sync
+		// resolves the branch.
+		drift.DesiredCommit = strings.TrimSpace(svc.GitCommit)
+	}
+
+	proc, _ := stateMgr.GetServiceProcess(svc.ID)
+	pending, _ := stateMgr.GetPendingAction(svc.ID)
+	if proc == nil {
+		drift.Differences = append(drift.Differences, "replicas")
+	} else {
+		drift.ActualCommit = proc.GitCommit
+		status, _ := svcMgr.ContainerStatus(proc.ContainerName)
+		if status == "running" {
+			drift.RunningReplicas = 1
+			desiredEnv, actualEnv, err := svcMgr.EnvironmentHashes(svc, proc.ContainerName)
+			if err == nil {
+				drift.DesiredEnvHash, drift.ActualEnvHash = desiredEnv, actualEnv
+			}
+		}
+
+		if drift.DesiredCommit != "" && drift.DesiredCommit != proc.GitCommit {
+			drift.Differences = append(drift.Differences, "commit")
+		}
+		if proc.DefinitionHash != "" && proc.DefinitionHash != serviceDefinitionHash(svc) {
+			drift.Differences = append(drift.Differences, "definition")
+		}
+		if drift.DesiredEnvHash != drift.ActualEnvHash {
+			drift.Differences = append(drift.Differences, "environment")
+		}
+		if svcMgr.ImageDrift(svc.ID) != nil {
+			drift.Differences = append(drift.Differences, "image")
+		}
+		if drift.RunningReplicas != drift.DesiredReplicas {
+			drift.Differences = append(drift.Differences, "replicas")
+		}
+		if svc.Hostname != "" && !service.IsWorker(svc) {
+			drift.Route, drift.ServicePort, drift.RoutedPort = svc.Hostname, proc.ActivePort, routes[svc.Hostname]
+			if drift.RoutedPort != drift.ServicePort {
+				drift.Differences = append(drift.Differences, "route")
+			}
+		}
+	}
+
+	override := ""
+	if proc != nil {
+		override = outOfBandSince(stateMgr, svc.ID, proc.StartedAt)
+	}
+	hold, _ := stateMgr.GetServiceHold(svc.ID)
+	switch {
+	case svc.Hold || hold != nil:
+		drift.Category = "held"
+		if hold != nil {
+			drift.Detail = hold.Reason
+		}
+	case override != "":
+		drift.Category, drift.Detail = "manual_override", override
+	case pending != nil && pending.Attempts > 0:
+		drift.Category, drift.Detail = "failed", pending.LastError
+	case proc != nil && proc.Status != "running" && proc.LastError != "":
+		drift.Category, drift.Detail = "failed", proc.LastError
+	case pending != nil:
+		drift.Category, drift.Detail = "pending_deploy", pending.Reason
+	case len(drift.Differences) > 0:
+		drift.Category = "pending_deploy"
+	}
+	return drift
+}
+
+// outOfBandSince returns the latest out-of-band change to a service's
+// container made after since, or "" if there was none.
+func outOfBandSince(stateMgr *state.Manager, serviceID string, since time.Time) string {
+	event, err := stateMgr.LastServiceEvent(serviceID, "out_of_band_change")
+	if err != nil || event == nil || !event.CreatedAt.After(since) {
+		return ""
+	}
+	return event.Message
+}
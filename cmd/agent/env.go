@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/buildvigil/agent/internal/api"
+	"github.com/buildvigil/agent/internal/config"
+	"github.com/buildvigil/agent/internal/secrets"
+	"github.com/buildvigil/agent/internal/service"
+	"github.com/buildvigil/agent/internal/state"
+)
+
+// runEnvCommand handles `agent env diff -service <id>`.
+func runEnvCommand(args []string) error {
+	if len(args) == 0 || args[0] != "diff" {
+		return fmt.Errorf("usage: potato-cloud-agent env diff -service <id> [-config path]")
+	}
+	fs := flag.NewFlagSet("env diff", flag.ContinueOnError)
+	configPath := fs.String("config", config.ConfigPath(), "Path to config file")
+	serviceID := fs.String("service", "", "Service ID or name")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	if *serviceID == "" {
+		return fmt.Errorf("service ID is required (use -service flag)")
+	}
+	return handleEnvDiff(*configPath, *serviceID)
+}
+
+// handleEnvDiff prints which environment variables the next deploy of a
+// service would add, remove or change compared to its running container.
+// Values are never printed.
+func handleEnvDiff(configPath, serviceRef string) error {
+	view, err := openLocalView(configPath)
+	if err != nil {
+		return err
+	}
+	defer view.Close()
+
+	var svc *api.Service
+	for i := range view.desired.Services {
+		if view.desired.Services[i].ID == serviceRef || view.desired.Services[i].Name == serviceRef {
+			svc = &view.desired.Services[i]
+			break
+		}
+	}
+	if svc == nil {
+		return fmt.Errorf("service %q is not in the desired state", serviceRef)
+	}
+
+	containerName := ""
+	if proc, _ := view.state.GetServiceProcess(svc.ID); proc != nil {
+		containerName = proc.ContainerName
+	}
+	changes, err := view.services.DiffEnvironment(*svc, containerName)
+	if err != nil {
+		return fmt.Errorf("failed to read running environment: %w", err)
+	}
+
+	if containerName == "" {
+		fmt.Printf("Service '%s' (%s) is not running; its next deploy sets:\n", svc.Name, svc.ID)
+	} else if len(changes) == 0 {
+		fmt.Printf("✓ No environment changes for service '%s' (%s)\n", svc.Name, svc.ID)
+		return nil
+	} else {
+		fmt.Printf("Environment changes for service '%s' (%s) on the next deploy:\n", svc.Name, svc.ID)
+	}
+	symbols := map[string]string{"added": "+", "removed": "-", "changed": "~"}
+	for _, change := range changes {
+		source := ""
+		if change.Secret {
+			source = " (secret)"
+		}
+		fmt.Printf("  %s %-32s %s%s\n", symbols[change.Change], change.Name, change.Change, source)
+	}
+	return nil
+}
+
+// localView is the desired state with the host's state and service managers,
+// for commands that inspect the host while the agent may or may not be
+// running.
+type localView struct {
+	desired  *api.DesiredState
+	state    *state.Manager
+	services *service.Manager
+}
+
+// openLocalView fetches the desired state and opens the state database.
+// Services get the "main" git ref the agent defaults them to.
+func openLocalView(configPath string) (*localView, error) {
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config: %w", err)
+	}
+	client, err := newAPIClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	var states []*api.DesiredState
+	for _, stackID := range cfg.Stacks() {
+		stackState, err := client.GetDesiredState(ctx, stackID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch desired state for stack %s: %w", stackID, err)
+		}
+		states = append(states, stackState)
+	}
+	desired := states[0]
+	if len(states) > 1 {
+		desired, _ = api.MergeDesiredStates(states)
+	}
+	for i := range desired.Services {
+		if desired.Services[i].GitRef == "" {
+			desired.Services[i].GitRef = "main"
+		}
+	}
+
+	stateMgr, err := state.NewManager(cfg.StateDBPath())
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize state: %w", err)
+	}
+	secretsMgr, err := secrets.NewManager(cfg.SecretsPath(), cfg.AgentID)
+	if err != nil {
+		stateMgr.Close()
+		return nil, fmt.Errorf("failed to initialize secrets manager: %w", err)
+	}
+	return &localView{
+		desired:  desired,
+		state:    stateMgr,
+		services: service.NewManager(cfg.ReposPath(), stateMgr, secretsMgr, cfg.PortRangeStart, cfg.PortRangeEnd, false),
+	}, nil
+}
+
+// Close closes the state database.
+func (v *localView) Close() {
+	v.state.Close()
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"strings"
+)
+
+// featureDefaults lists the feature flags the agent understands and their
+// values when the desired state does not set them. Flags toggle existing
+// behaviors per stack without a new agent build; a behavior that is not in
+// this build has no flag here, so the control plane's flag for it is ignored.
+var featureDefaults = map[string]bool{
+	// deploy_backoff retries repeatedly failing deploys with exponential
+	// backoff instead of on every sync.
+	"deploy_backoff": true,
+	// egress_control adds outbound allow rules for the control plane,
+	// registries and NTP servers when the firewall is applied.
+	"egress_control": true,
+	// image_drift_self_heal redeploys services whose image drifted; unset
+	// falls back to the image_drift_self_heal config option.
+	"image_drift_self_heal": false,
+	// push_sync holds the desired state stream open and syncs as soon as a
+	// state is published; unset falls back to the desired_state_push config
+	// option.
+	"push_sync": false,
+}
+
+// applyFeatureFlags records the flags from the desired state. Unknown flags
+// are logged and ignored.
+func (a *Agent) applyFeatureFlags(flags map[string]bool) {
+	active := make(map[string]bool, len(featureDefaults))
+	for name := range featureDefaults {
+		active[name] = a.featureDefault(name)
+	}
+	var unknown []string
+	for name, value := range flags {
+		if _, ok := featureDefaults[name]; !ok {
+			unknown = append(unknown, name)
+			continue
+		}
+		active[name] = value
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		a.logVerbosef("Ignoring unknown feature flags: %s", strings.Join(unknown, ","))
+	}
+
+	a.featureMu.Lock()
+	defer a.featureMu.Unlock()
+	for name, value := range active {
+		if previous, ok := a.featureFlags[name]; ok && previous != value {
+			log.Printf("Feature flag changed: flag=%s enabled=%t", name, value)
+		}
+	}
+	a.featureFlags = active
+}
+
+// featureEnabled reports whether a feature flag is on, using its default
+// before the first desired state arrives.
+func (a *Agent) featureEnabled(name string) bool {
+	a.featureMu.Lock()
+	defer a.featureMu.Unlock()
+	if value, ok := a.featureFlags[name]; ok {
+		return value
+	}
+	return a.featureDefault(name)
+}
+
+// featureDefault returns a flag's value when the desired state does not set
+// it: its config option for flags that have one, otherwise featureDefaults.
+func (a *Agent) featureDefault(name string) bool {
+	switch name {
+	case "image_drift_self_heal":
+		return a.config.ImageDriftSelfHeal
+	case "push_sync":
+		return a.config.DesiredStatePush
+	}
+	return featureDefaults[name]
+}
+
+// activeFeatureFlags returns the effective value of every known flag for the
+// heartbeat.
+func (a *Agent) activeFeatureFlags() map[string]bool {
+	result := make(map[string]bool, len(featureDefaults))
+	for name := range featureDefaults {
+		result[name] = a.featureEnabled(name)
+	}
+	return result
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/buildvigil/agent/internal/api"
+)
+
+const (
+	// maxLifecycleEvents caps the transitions held for the next heartbeat;
+	// the oldest are dropped first.
+	maxLifecycleEvents = 500
+	// heartbeatReplayBatch is how many queued heartbeats are read at a time.
+	heartbeatReplayBatch = 50
+)
+
+// recordLifecycleEvent holds a transition that wasn't streamed for the next
+// heartbeat.
+func (a *Agent) recordLifecycleEvent(event api.LifecycleEvent) {
+	a.lifecycleEventsMu.Lock()
+	defer a.lifecycleEventsMu.Unlock()
+	a.lifecycleEvents = append(a.lifecycleEvents, event)
+	if excess := len(a.lifecycleEvents) - maxLifecycleEvents; excess > 0 {
+		a.lifecycleEvents = a.lifecycleEvents[excess:]
+	}
+}
+
+// takeLifecycleEvents returns and clears the held transitions.
+func (a *Agent) takeLifecycleEvents() []api.LifecycleEvent {
+	a.lifecycleEventsMu.Lock()
+	defer a.lifecycleEventsMu.Unlock()
+	events := a.lifecycleEvents
+	a.lifecycleEvents = nil
+	return events
+}
+
+// queueHeartbeat stores a heartbeat the control plane didn't receive, with
+// its transitions, for replay once it is reachable again. With the queue
+// disabled, the transitions are held for the next heartbeat instead.
+func (a *Agent) queueHeartbeat(req api.HeartbeatRequest) {
+	if a.config.HeartbeatQueueSize <= 0 {
+		a.lifecycleEventsMu.Lock()
+		a.lifecycleEvents = append(req.LifecycleEvents, a.lifecycleEvents...)
+		a.lifecycleEventsMu.Unlock()
+		return
+	}
+	payload, err := json.Marshal(req)
+	if err != nil {
+		log.Printf("Failed to encode heartbeat for replay: %v", err)
+		return
+	}
+	if err := a.state.QueueHeartbeat(payload, a.config.HeartbeatQueueSize); err != nil {
+		log.Printf("Failed to queue heartbeat for replay: %v", err)
+	}
+}
+
+// replayHeartbeats sends queued heartbeats oldest first, marked as replayed
+// and keeping their original sent_at. A heartbeat the control plane rejects
+// is dropped; any other failure stops the replay so the rest are retried
+// after the next heartbeat.
+func (a *Agent) replayHeartbeats() {
+	a.replayMu.Lock()
+	defer a.replayMu.Unlock()
+
+	replayed := 0
+	for {
+		queued, err := a.state.QueuedHeartbeats(heartbeatReplayBatch)
+		if err != nil {
+			log.Printf("Failed to list queued heartbeats: %v", err)
+			return
+		}
+		if len(queued) == 0 {
+			break
+		}
+		for _, q := range queued {
+			var req api.HeartbeatRequest
+			if err := json.Unmarshal(q.Payload, &req); err != nil {
+				log.Printf("Dropping unreadable queued heartbeat %d: %v", q.ID, err)
+			} else {
+				req.Replayed = true
+				if _, err := a.api.SendHeartbeat(a.runCtx, req); api.Rejected(err) {
+					log.Printf("Dropping queued heartbeat rejected by the control plane: sent_at=%s err=%v", req.SentAt, err)
+				} else if err != nil {
+					log.Printf("Heartbeat replay failed: sent_at=%s err=%v", req.SentAt, err)
+					return
+				} else {
+					replayed++
+				}
+			}
+			if err := a.state.DeleteQueuedHeartbeat(q.ID); err != nil {
+				log.Printf("Failed to delete replayed heartbeat: %v", err)
+				return
+			}
+		}
+	}
+	if replayed > 0 {
+		log.Printf("Replayed %d queued heartbeats", replayed)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+
+	"github.com/buildvigil/agent/internal/config"
+	"github.com/buildvigil/agent/internal/firewall"
+	"github.com/buildvigil/agent/internal/privhelper"
+	"github.com/buildvigil/agent/internal/proxy"
+)
+
+// firewallManager applies firewall settings, either directly or through
+// the privileged helper.
+type firewallManager interface {
+	Apply() error
+	Rollback() error
+	Revert() error
+	RefreshEgress() error
+	IsAvailable() bool
+	GetStatus() (map[string]interface{}, error)
+}
+
+// hostsUpdater keeps the *.svc.internal entries in /etc/hosts, either
+// directly or through the privileged helper.
+type hostsUpdater interface {
+	UpdateServices(serviceNames []string) error
+	Cleanup() error
+}
+
+// helperListens are the addresses the privileged helper binds for the
+// agent: the internal proxy, and the external proxy on a privileged port.
+func helperListens(cfg *config.Config) []string {
+	listens := []string{proxy.InternalAddr}
+	if cfg.ExternalProxyPort > 0 && cfg.ExternalProxyPort < 1024 {
+		listens = append(listens, "0.0.0.0:"+strconv.Itoa(cfg.ExternalProxyPort))
+	}
+	return listens
+}
+
+// newFirewall returns a firewall for settings, applied through the helper
+// when one is configured.
+func (a *Agent) newFirewall(fw *firewall.Manager) firewallManager {
+	if a.helper != nil {
+		return a.helper.Firewall(fw.Settings())
+	}
+	return fw
+}
+
+// usePrivilegedHelper routes firewall and /etc/hosts changes through the
+// helper.
+func (a *Agent) usePrivilegedHelper(client *privhelper.Client) error {
+	if err := client.Ping(); err != nil {
+		return err
+	}
+	a.helper = client
+	a.hosts = client.Hosts()
+	log.Printf("Privileged operations go through the helper: socket=%s", a.config.PrivilegedHelperSocket)
+	return nil
+}
+
+// takeHelperListeners has the helper bind the proxies' privileged ports.
+func (a *Agent) takeHelperListeners() error {
+	for _, addr := range helperListens(a.config) {
+		listener, err := a.helper.Listen(addr)
+		if err != nil {
+			return err
+		}
+		if addr == proxy.InternalAddr {
+			a.internalProxy.SetListener(listener)
+		} else {
+			a.externalProxy.SetListener(listener)
+		}
+	}
+	return nil
+}
+
+// runHelperCommand runs the privileged helper: a small root process that
+// applies firewall rules, edits /etc/hosts and binds privileged ports for
+// an agent running as service_user.
+func runHelperCommand(args []string) error {
+	fs := flag.NewFlagSet("helper", flag.ContinueOnError)
+	configPath := fs.String("config", config.ConfigPath(), "Path to config file")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	if cfg.PrivilegedHelperSocket == "" {
+		return fmt.Errorf("privileged_helper_socket is not set")
+	}
+	identity, err := serviceIdentity(cfg)
+	if err != nil {
+		return err
+	}
+	gid := -1
+	if identity != nil {
+		gid = int(identity.GID)
+	}
+
+	server := privhelper.NewServer(proxy.NewDNSManager(), helperListens(cfg))
+	if err := server.Listen(cfg.PrivilegedHelperSocket, gid); err != nil {
+		return err
+	}
+	defer server.Stop()
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	<-sigChan
+	log.Println("Helper shutting down...")
+	return nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/buildvigil/agent/internal/config"
+	"github.com/buildvigil/agent/internal/state"
+)
+
+const stateUsage = "usage: potato-cloud-agent state history [-limit n] [-json] [-config path]\n" +
+	"       potato-cloud-agent state show <version> [-config path]\n" +
+	"       potato-cloud-agent state show -at <RFC3339 time> [-config path]"
+
+// runStateCommand handles `agent state history` and `agent state show`,
+// which inspect the desired states the agent applied in the past.
+func runStateCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf(stateUsage)
+	}
+	switch args[0] {
+	case "history":
+		return runStateHistory(args[1:])
+	case "show":
+		return runStateShow(args[1:])
+	default:
+		return fmt.Errorf(stateUsage)
+	}
+}
+
+func runStateHistory(args []string) error {
+	fs := flag.NewFlagSet("state history", flag.ContinueOnError)
+	configPath := fs.String("config", config.ConfigPath(), "Path to config file")
+	limit := fs.Int("limit", 20, "Number of states to list (0 for all)")
+	asJSON := fs.Bool("json", false, "Print the history as JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	stateMgr, err := openStateDB(*configPath)
+	if err != nil {
+		return err
+	}
+	defer stateMgr.Close()
+	snapshots, err := stateMgr.ListStateSnapshots(*limit)
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(snapshots)
+	}
+	if len(snapshots) == 0 {
+		fmt.Println("No applied states recorded yet")
+		return nil
+	}
+	fmt.Printf("%-8s %-16s %-22s %s\n", "VERSION", "HASH", "APPLIED AT", "RESULT")
+	for _, snapshot := range snapshots {
+		result := "applied"
+		if snapshot.Partial {
+			result = "partial"
+		}
+		fmt.Printf("%-8d %-16s %-22s %s\n", snapshot.Version, truncate(snapshot.Hash, 16), snapshot.AppliedAt.Local().Format("2006-01-02 15:04:05"), result)
+	}
+	return nil
+}
+
+func runStateShow(args []string) error {
+	// Allow the version before the flags, as in `state show 42 -config path`.
+	versionArg := ""
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		versionArg, args = args[0], args[1:]
+	}
+	fs := flag.NewFlagSet("state show", flag.ContinueOnError)
+	configPath := fs.String("config", config.ConfigPath(), "Path to config file")
+	at := fs.String("at", "", "Show the state that was active at this time (RFC3339)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if versionArg == "" {
+		versionArg = fs.Arg(0)
+	}
+	if (versionArg == "") == (*at == "") {
+		return fmt.Errorf(stateUsage)
+	}
+
+	stateMgr, err := openStateDB(*configPath)
+	if err != nil {
+		return err
+	}
+	defer stateMgr.Close()
+
+	var snapshot *state.StateSnapshot
+	if *at != "" {
+		t, err := time.Parse(time.RFC3339, *at)
+		if err != nil {
+			return fmt.Errorf("invalid -at time %q: %w", *at, err)
+		}
+		if snapshot, err = stateMgr.StateSnapshotAt(t); err != nil {
+			return err
+		}
+		if snapshot == nil {
+			return fmt.Errorf("no state was applied before %s", t.Format(time.RFC3339))
+		}
+	} else {
+		version, err := strconv.Atoi(versionArg)
+		if err != nil {
+			return fmt.Errorf("invalid version %q", versionArg)
+		}
+		if snapshot, err = stateMgr.GetStateSnapshot(version); err != nil {
+			return err
+		}
+		if snapshot == nil {
+			return fmt.Errorf("version %d is not in the state history", version)
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "Version %d (hash %s), applied at %s\n", snapshot.Version, snapshot.Hash, snapshot.AppliedAt.Local().Format(time.RFC3339))
+	var out bytes.Buffer
+	if err := json.Indent(&out, snapshot.Document, "", "  "); err != nil {
+		return fmt.Errorf("failed to format state: %w", err)
+	}
+	out.WriteByte('\n')
+	_, err = out.WriteTo(os.Stdout)
+	return err
+}
+
+// openStateDB opens the state database named by the config at configPath.
+func openStateDB(configPath string) (*state.Manager, error) {
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config: %w", err)
+	}
+	stateMgr, err := state.NewManager(cfg.StateDBPath())
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize state: %w", err)
+	}
+	return stateMgr, nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/buildvigil/agent/internal/api"
+	"github.com/buildvigil/agent/internal/config"
+	"github.com/buildvigil/agent/internal/state"
+)
+
+// serviceHeld reports whether reconciliation of a service is paused, either
+// by the desired state's hold annotation or by a local -hold.
+func (a *Agent) serviceHeld(svc api.Service) bool {
+	if svc.Hold {
+		return true
+	}
+	hold, err := a.state.GetServiceHold(svc.ID)
+	if err != nil {
+		log.Printf("Failed to read hold for service %s: %v", svc.Name, err)
+		return false
+	}
+	return hold != nil
+}
+
+// handleHoldService places a service on hold so the running agent stops
+// reconciling it.
+func handleHoldService(configPath, serviceID, reason string) error {
+	if serviceID == "" {
+		return fmt.Errorf("service ID is required (use -service flag)")
+	}
+	stateMgr, err := openState(configPath)
+	if err != nil {
+		return err
+	}
+	defer stateMgr.Close()
+
+	if err := stateMgr.SetServiceHold(serviceID, reason); err != nil {
+		return err
+	}
+	_ = stateMgr.RecordEvent(serviceID, "hold", reason)
+	fmt.Printf("✓ Service '%s' is on hold; the agent will not deploy, restart or remove it\n", serviceID)
+	fmt.Println("Release it with -release -service " + serviceID)
+	return nil
+}
+
+// handleReleaseService removes a service's hold.
+func handleReleaseService(configPath, serviceID string) error {
+	if serviceID == "" {
+		return fmt.Errorf("service ID is required (use -service flag)")
+	}
+	stateMgr, err := openState(configPath)
+	if err != nil {
+		return err
+	}
+	defer stateMgr.Close()
+
+	released, err := stateMgr.ClearServiceHold(serviceID)
+	if err != nil {
+		return err
+	}
+	if !released {
+		fmt.Printf("Service '%s' was not on hold\n", serviceID)
+		return nil
+	}
+	_ = stateMgr.RecordEvent(serviceID, "hold_released", "")
+	fmt.Printf("✓ Service '%s' released; the next sync reconciles it\n", serviceID)
+	return nil
+}
+
+func openState(configPath string) (*state.Manager, error) {
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config: %w", err)
+	}
+	stateMgr, err := state.NewManager(cfg.StateDBPath())
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize state: %w", err)
+	}
+	return stateMgr, nil
+}
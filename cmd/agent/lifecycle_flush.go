@@ -0,0 +1,55 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// queueLifecycleHeartbeat schedules a heartbeat carrying lifecycle updates
+// that couldn't be streamed. Updates arriving before it is sent share it, so
+// a deploy of many services sends one heartbeat per lifecycleFlushInterval
+// rather than one per status change. A regular heartbeat sent meanwhile
+// carries the updates and cancels the flush.
+func (a *Agent) queueLifecycleHeartbeat() {
+	a.lifecycleFlushMu.Lock()
+	defer a.lifecycleFlushMu.Unlock()
+	a.lifecyclePending = true
+	if a.lifecycleFlush != nil {
+		return
+	}
+	a.lifecycleFlush = time.AfterFunc(a.lifecycleFlushInterval, a.flushLifecycle)
+}
+
+// flushLifecycle sends the queued lifecycle heartbeat, unless one was sent
+// since the updates were queued.
+func (a *Agent) flushLifecycle() {
+	a.lifecycleFlushMu.Lock()
+	a.lifecycleFlush = nil
+	pending := a.lifecyclePending
+	a.lifecycleFlushMu.Unlock()
+	if !pending || a.runCtx.Err() != nil {
+		return
+	}
+	if err := a.sendHeartbeat(); err != nil {
+		log.Printf("Lifecycle heartbeat failed: %v", err)
+	} else {
+		log.Printf("Lifecycle heartbeat sent")
+	}
+}
+
+// lifecycleSent marks queued lifecycle updates as carried by a heartbeat.
+func (a *Agent) lifecycleSent() {
+	a.lifecycleFlushMu.Lock()
+	defer a.lifecycleFlushMu.Unlock()
+	a.lifecyclePending = false
+}
+
+// stopLifecycleFlush drops a scheduled lifecycle heartbeat.
+func (a *Agent) stopLifecycleFlush() {
+	a.lifecycleFlushMu.Lock()
+	defer a.lifecycleFlushMu.Unlock()
+	if a.lifecycleFlush != nil {
+		a.lifecycleFlush.Stop()
+		a.lifecycleFlush = nil
+	}
+}
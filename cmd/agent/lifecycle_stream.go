@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/buildvigil/agent/internal/api"
+)
+
+// lifecycleStreamMaxReconnectDelay caps the wait between lifecycle stream
+// reconnects.
+const lifecycleStreamMaxReconnectDelay = time.Minute
+
+// runLifecycleStream keeps a gRPC lifecycle stream open until ctx is done,
+// so status changes reach the control plane as events instead of a full
+// heartbeat each. While it is down, lifecycle changes send heartbeats.
+func (a *Agent) runLifecycleStream(ctx context.Context) {
+	delay := time.Second
+	for {
+		stream, err := a.api.OpenLifecycleStream(ctx)
+		if err == nil {
+			a.setLifecycleStream(stream)
+			opened := time.Now()
+			select {
+			case <-ctx.Done():
+				a.setLifecycleStream(nil)
+				stream.Close()
+				return
+			case <-stream.Done():
+			}
+			a.setLifecycleStream(nil)
+			err = stream.Err()
+			// A stream that stayed up a while was healthy; start backing off afresh.
+			if time.Since(opened) > lifecycleStreamMaxReconnectDelay {
+				delay = time.Second
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		wait := delay
+		if api.IsGRPCCode(err, api.GRPCUnimplemented) {
+			wait = pushUnsupportedRetry
+			log.Printf("Lifecycle stream not supported by control plane; sending heartbeats, retry_in=%s", wait)
+		} else {
+			log.Printf("Lifecycle stream unavailable: %v (retry_in=%s)", err, wait)
+			delay *= 2
+			if delay > lifecycleStreamMaxReconnectDelay {
+				delay = lifecycleStreamMaxReconnectDelay
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (a *Agent) setLifecycleStream(stream *api.LifecycleStream) {
+	a.lifecycleStreamMu.Lock()
+	defer a.lifecycleStreamMu.Unlock()
+	a.lifecycleStream = stream
+}
+
+// streamLifecycleEvent sends a lifecycle event on the open stream, reporting
+// whether it was sent. The caller falls back to a heartbeat if not.
+func (a *Agent) streamLifecycleEvent(event api.LifecycleEvent) bool {
+	a.lifecycleStreamMu.Lock()
+	stream := a.lifecycleStream
+	a.lifecycleStreamMu.Unlock()
+	if stream == nil {
+		return false
+	}
+	if err := stream.Send(event); err != nil {
+		log.Printf("Lifecycle event failed: service=%s status=%s err=%v", event.ServiceID, event.Status, err)
+		return false
+	}
+	log.Printf("Lifecycle event sent: service=%s status=%s", event.ServiceID, event.Status)
+	return true
+}
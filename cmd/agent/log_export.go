@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/buildvigil/agent/internal/api"
+	"github.com/buildvigil/agent/internal/config"
+	"github.com/buildvigil/agent/internal/logexport"
+	"github.com/buildvigil/agent/internal/secrets"
+	"github.com/buildvigil/agent/internal/state"
+)
+
+// Secret names, stored under secrets.AgentScope, holding the S3 credentials
+// used by scheduled log export.
+const (
+	logExportAccessKeySecret = "s3_access_key_id"
+	logExportSecretKeySecret = "s3_secret_access_key"
+)
+
+// handleExportLogs writes service logs from the state database to outputPath,
+// or stdout when outputPath is empty.
+func handleExportLogs(configPath, serviceID, since, format, outputPath string) error {
+	if err := logexport.ValidateFormat(format); err != nil {
+		return err
+	}
+	sinceTime, err := logexport.ParseSince(since, time.Now())
+	if err != nil {
+		return err
+	}
+
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	stateMgr, err := state.NewManager(cfg.StateDBPath())
+	if err != nil {
+		return fmt.Errorf("failed to initialize state: %w", err)
+	}
+	defer stateMgr.Close()
+
+	var out io.Writer = os.Stdout
+	if outputPath != "" {
+		file, err := os.OpenFile(outputPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+		if err != nil {
+			return fmt.Errorf("failed to create export file: %w", err)
+		}
+		defer file.Close()
+		out = file
+	}
+
+	written, _, err := logexport.Export(stateMgr, out, serviceID, state.LogFilter{Since: sinceTime}, 0, format)
+	if err != nil {
+		return err
+	}
+	if outputPath != "" {
+		fmt.Fprintf(os.Stderr, "✓ Exported %d log lines to %s\n", written, outputPath)
+	}
+	return nil
+}
+
+// startLogExport starts scheduled S3 log export when a bucket is configured.
+// It returns nil when export is disabled or cannot be configured.
+func startLogExport(cfg *config.Config, stateMgr *state.Manager, secretsMgr *secrets.Manager) *logexport.Scheduler {
+	if cfg.LogExportS3Bucket == "" {
+		return nil
+	}
+
+	accessKey, err := secretsMgr.GetSecret(logExportAccessKeySecret, secrets.AgentScope)
+	if err != nil {
+		log.Printf("[LogExport] Disabled: %v (add it with -add-secret -service %s -secret-name %s)", err, secrets.AgentScope, logExportAccessKeySecret)
+		return nil
+	}
+	secretKey, err := secretsMgr.GetSecret(logExportSecretKeySecret, secrets.AgentScope)
+	if err != nil {
+		log.Printf("[LogExport] Disabled: %v (add it with -add-secret -service %s -secret-name %s)", err, secrets.AgentScope, logExportSecretKeySecret)
+		return nil
+	}
+
+	uploader, err := logexport.NewS3Uploader(logexport.S3Config{
+		Endpoint:        cfg.LogExportS3Endpoint,
+		Region:          cfg.LogExportS3Region,
+		Bucket:          cfg.LogExportS3Bucket,
+		Prefix:          cfg.LogExportS3Prefix,
+		AccessKeyID:     accessKey,
+		SecretAccessKey: secretKey,
+	})
+	if err != nil {
+		log.Printf("[LogExport] Disabled: %v", err)
+		return nil
+	}
+
+	interval := time.Duration(cfg.LogExportIntervalMinutes) * time.Minute
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	scheduler := logexport.NewScheduler(stateMgr, uploader, cfg.AgentID, cfg.AgentLogPath(), cfg.LogExportProgressPath(), interval)
+	scheduler.Start()
+	log.Printf("[LogExport] Scheduled: bucket=%s interval=%s", cfg.LogExportS3Bucket, interval)
+	return scheduler
+}
+
+// startLogShipping starts shipping service logs to the control plane when
+// enabled. It returns nil when shipping is off.
+func startLogShipping(cfg *config.Config, stateMgr *state.Manager, client *api.Client) *logexport.Shipper {
+	if !cfg.LogShipEnabled {
+		return nil
+	}
+	interval := time.Duration(cfg.LogShipIntervalSeconds) * time.Second
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	shipper := logexport.NewShipper(stateMgr, client, cfg.LogShipCursorPath(), interval, cfg.LogShipBatchSize)
+	shipper.Start()
+	log.Printf("[LogShip] Shipping service logs to the control plane: interval=%s batch_size=%d", interval, cfg.LogShipBatchSize)
+	return shipper
+}
@@ -2,6 +2,11 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
@@ -10,17 +15,26 @@ import (
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
+	"github.com/buildvigil/agent/internal/admin"
+	"github.com/buildvigil/agent/internal/alerts"
 	"github.com/buildvigil/agent/internal/api"
 	"github.com/buildvigil/agent/internal/config"
+	"github.com/buildvigil/agent/internal/container"
 	"github.com/buildvigil/agent/internal/firewall"
 	"github.com/buildvigil/agent/internal/git"
+	"github.com/buildvigil/agent/internal/logging"
+	"github.com/buildvigil/agent/internal/metrics"
+	"github.com/buildvigil/agent/internal/platform"
+	"github.com/buildvigil/agent/internal/privhelper"
 	"github.com/buildvigil/agent/internal/proxy"
 	"github.com/buildvigil/agent/internal/secrets"
 	"github.com/buildvigil/agent/internal/service"
 	"github.com/buildvigil/agent/internal/state"
+	"github.com/buildvigil/agent/internal/synthetic"
 )
 
 type optionalString struct {
@@ -30,6 +44,10 @@ type optionalString struct {
 
 const branchSelfHealInterval = 15 * time.Minute
 
+// egressRefreshInterval is how often the firewall's egress allowlist is
+// re-resolved.
+const egressRefreshInterval = 10 * time.Minute
+
 func (o *optionalString) String() string {
 	return o.value
 }
@@ -41,12 +59,46 @@ func (o *optionalString) Set(value string) error {
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "version" {
+		if err := runVersionCommand(os.Args[2:]); err != nil {
+			os.Exit(2)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "env" {
+		if err := runEnvCommand(os.Args[2:]); err != nil {
+			log.Fatalf("%v", err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "diff" {
+		if err := runDiffCommand(os.Args[2:]); err != nil {
+			log.Fatalf("%v", err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "helper" {
+		if err := runHelperCommand(os.Args[2:]); err != nil {
+			log.Fatalf("Helper: %v", err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "state" {
+		if err := runStateCommand(os.Args[2:]); err != nil {
+			log.Fatalf("%v", err)
+		}
+		return
+	}
+
 	var (
 		genSSHKey     = flag.Bool("gen-ssh-key", false, "Generate an SSH keypair for git access")
 		sshKeyName    = flag.String("ssh-key-name", "default", "SSH key name to generate (filename under ssh dir)")
 		configPath    = flag.String("config", config.ConfigPath(), "Path to config file")
 		applyFirewall = flag.Bool("apply-firewall", false, "Apply firewall rules (requires root)")
 		showStatus    = flag.Bool("status", false, "Show current service status")
+		showUptime    = flag.Bool("uptime", false, "Show rolling 24h/7d/30d uptime per service")
+		showVersion   = flag.Bool("version", false, "Show agent version, commit, build date and features")
+		versionJSON   = flag.Bool("json", false, "With -version, print build information as JSON")
 
 		agentIDFlag            optionalString
 		stackIDFlag            optionalString
@@ -59,13 +111,38 @@ func main() {
 		listSecrets   = flag.Bool("list-secrets", false, "List all secrets for a service")
 		deleteSecret  = flag.Bool("delete-secret", false, "Delete a secret")
 		secretName    = flag.String("secret-name", "", "Name of the secret")
-		secretService = flag.String("service", "", "Service ID or name for the secret")
+		secretService = flag.String("service", "", "Service ID or name for the secret, -hold, -release, -confirm or -rollback")
 		secretValue   = flag.String("value", "", "Secret value (if not provided, will prompt)")
 
 		// Log management flags
 		showLogs   = flag.Bool("logs", false, "Show service logs")
 		followLogs = flag.Bool("f", false, "Follow logs in real-time (tail -f style)")
 		logService = flag.String("log-service", "", "Service ID for log viewing")
+		agentLogs  = flag.Bool("agent", false, "With -logs, show the agent's own log file")
+		logDeploy  = flag.String("deploy", "", "With -logs, only show logs from this deploy ID (or 'current'/'previous')")
+		logCtr     = flag.String("container", "", "With -logs, only show logs from this container ID (prefix match)")
+		logDeploys = flag.Bool("deploys", false, "With -logs, list deploys that have captured logs")
+		logBuild   = flag.Bool("build", false, "With -logs, show the Docker build output of the latest build, or of -deploy")
+		logExport  = flag.Bool("export", false, "With -logs, export logs (all services unless -log-service is set) to -o or stdout")
+		logSince   = flag.String("since", "", "With -logs -export, only export logs newer than this (e.g. 24h, 7d, 2026-01-31)")
+		logFormat  = flag.String("format", "jsonl", "With -logs -export, output format: jsonl or text")
+
+		// Support flags
+		supportBundle = flag.Bool("support-bundle", false, "Collect a support bundle (tar.gz) for bug reports")
+		doctor        = flag.Bool("doctor", false, "Check the service user, umask and the ownership and modes of the agent's files")
+		decommission  = flag.Bool("decommission", false, "Stop all services, remove their networks, images and firewall rules, and deregister from the control plane")
+		outputPath    = flag.String("o", "", "Output file path")
+
+		// Hold flags
+		holdService     = flag.Bool("hold", false, "Pause reconciliation of the service given by -service")
+		releaseService  = flag.Bool("release", false, "Resume reconciliation of the service given by -service")
+		holdReason      = flag.String("reason", "", "With -hold, why the service is held")
+		confirmService  = flag.Bool("confirm", false, "Confirm the change awaiting confirmation for the protected service given by -service")
+		rollbackService = flag.Bool("rollback", false, "Roll the service given by -service back to the release it ran before its latest deploy")
+
+		// Registration flags
+		register     = flag.Bool("register", false, "Register with the control plane using -install-token and save the credentials to the config file")
+		installToken = flag.String("install-token", "", "With -register, the one-time install token from the control plane")
 	)
 
 	flag.Var(&agentIDFlag, "agent-id", "Agent ID")
@@ -86,6 +163,20 @@ func main() {
 		return
 	}
 
+	if *register {
+		if err := handleRegister(*configPath, *installToken, controlPlaneFlag, accessClientIDFlag, accessClientSecretFlag); err != nil {
+			log.Fatalf("Registration failed: %v", err)
+		}
+		return
+	}
+
+	if *showVersion {
+		if err := printVersion(*versionJSON); err != nil {
+			log.Fatalf("Failed to print version: %v", err)
+		}
+		return
+	}
+
 	if *showStatus {
 		if err := printServiceStatus(*configPath); err != nil {
 			log.Fatalf("Failed to get status: %v", err)
@@ -93,6 +184,13 @@ func main() {
 		return
 	}
 
+	if *showUptime {
+		if err := printServiceUptime(*configPath); err != nil {
+			log.Fatalf("Failed to get uptime: %v", err)
+		}
+		return
+	}
+
 	// Handle secret management commands
 	if *addSecret {
 		if err := handleAddSecret(*configPath, *secretService, *secretName, *secretValue); err != nil {
@@ -116,13 +214,78 @@ func main() {
 	}
 
 	// Handle log viewing
+	if *showLogs && *agentLogs {
+		if err := handleShowAgentLogs(*configPath, *followLogs); err != nil {
+			log.Fatalf("Failed to show agent logs: %v", err)
+		}
+		return
+	}
+	if *showLogs && *logBuild {
+		if err := handleShowBuildLog(*configPath, *logService, *logDeploy); err != nil {
+			log.Fatalf("Failed to show build log: %v", err)
+		}
+		return
+	}
+	if *showLogs && *logExport {
+		if err := handleExportLogs(*configPath, *logService, *logSince, *logFormat, *outputPath); err != nil {
+			log.Fatalf("Failed to export logs: %v", err)
+		}
+		return
+	}
 	if *showLogs {
-		if err := handleShowLogs(*configPath, *logService, *followLogs); err != nil {
+		filter := state.LogFilter{DeployID: *logDeploy, ContainerID: *logCtr}
+		if err := handleShowLogs(*configPath, *logService, *followLogs, *logDeploys, filter); err != nil {
 			log.Fatalf("Failed to show logs: %v", err)
 		}
 		return
 	}
 
+	if *supportBundle {
+		if err := handleSupportBundle(*configPath, *outputPath); err != nil {
+			log.Fatalf("Failed to create support bundle: %v", err)
+		}
+		return
+	}
+
+	if *doctor {
+		if err := handleDoctor(*configPath); err != nil {
+			log.Fatalf("Doctor: %v", err)
+		}
+		return
+	}
+
+	if *decommission {
+		if err := handleDecommission(*configPath); err != nil {
+			log.Fatalf("Decommission failed: %v", err)
+		}
+		return
+	}
+
+	if *holdService {
+		if err := handleHoldService(*configPath, *secretService, *holdReason); err != nil {
+			log.Fatalf("Failed to hold service: %v", err)
+		}
+		return
+	}
+	if *releaseService {
+		if err := handleReleaseService(*configPath, *secretService); err != nil {
+			log.Fatalf("Failed to release service: %v", err)
+		}
+		return
+	}
+	if *confirmService {
+		if err := handleConfirmService(*configPath, *secretService); err != nil {
+			log.Fatalf("Failed to confirm change: %v", err)
+		}
+		return
+	}
+	if *rollbackService {
+		if err := handleRollbackService(*configPath, *secretService); err != nil {
+			log.Fatalf("Failed to request rollback: %v", err)
+		}
+		return
+	}
+
 	// Load configuration
 	cfg, err := config.Load(*configPath)
 	if err != nil {
@@ -132,12 +295,45 @@ func main() {
 	if err := applyConfigOverrides(cfg, *configPath, agentIDFlag, stackIDFlag, controlPlaneFlag, accessClientIDFlag, accessClientSecretFlag); err != nil {
 		log.Fatalf("Failed to apply config overrides: %v", err)
 	}
+	if err := cfg.ApplyProxyEnv(); err != nil {
+		log.Fatalf("Failed to apply proxy settings: %v", err)
+	}
+	if mask, ok, err := cfg.Umask(); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	} else if ok {
+		platform.SetUmask(mask)
+	}
+	identity, err := serviceIdentity(cfg)
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	if identity != nil && !platform.IsRoot() && uint32(os.Geteuid()) != identity.UID {
+		log.Fatalf("service_user %s needs the agent started as root or as %s", identity.User, identity.User)
+	}
+
+	logging.SetBaseVerbose(cfg.VerboseLogging)
+	if cfg.AgentLogFile {
+		logWriter, err := logging.Setup(
+			cfg.AgentLogPath(),
+			int64(cfg.AgentLogMaxSizeMB)*1024*1024,
+			time.Duration(cfg.AgentLogRotateHours)*time.Hour,
+			cfg.AgentLogRetain,
+		)
+		if err != nil {
+			log.Fatalf("Failed to set up agent log file: %v", err)
+		}
+		defer logWriter.Close()
+	}
+
+	if platform.DevMode {
+		log.Printf("Development mode: firewall and /etc/hosts changes are disabled (data_dir=%s)", cfg.DataDir)
+	}
 
 	// Ensure data directories exist
 	if err := os.MkdirAll(cfg.DataDir, 0755); err != nil {
 		log.Fatalf("Failed to create data directory: %v", err)
 	}
-	if err := os.MkdirAll(cfg.ReposPath(), 0755); err != nil {
+	if err := os.MkdirAll(cfg.ReposPath(), 0750); err != nil {
 		log.Fatalf("Failed to create repos directory: %v", err)
 	}
 
@@ -154,63 +350,143 @@ func main() {
 		log.Fatalf("Failed to initialize secrets manager: %v", err)
 	}
 
-	// Initialize git manager
-	gitMgr := git.NewManager(cfg.ReposPath(), cfg.SSHKeyDir())
-
-	// Initialize service manager
-	svcMgr := service.NewManager(cfg.ReposPath(), stateMgr, secretsMgr, cfg.PortRangeStart, cfg.PortRangeEnd, cfg.VerboseLogging)
-
-	// Initialize proxies
-	externalProxy := proxy.NewExternalProxy(cfg.ExternalProxyPort, "0.0.0.0")
-	internalProxy := proxy.NewInternalProxy()
-
-	// Initialize DNS manager
-	dnsMgr := proxy.NewDNSManager()
-
-	// Initialize API client
-	apiClient := api.NewClient(cfg.ControlPlane, cfg.AgentID, cfg.AccessClientID, cfg.AccessClientSecret)
+	if err := configureChaos(cfg); err != nil {
+		log.Fatalf("Invalid chaos configuration: %v", err)
+	}
 
-	// Initialize firewall manager (will be configured after first sync)
-	var fwMgr *firewall.Manager
+	if err := applyFilePermissions(cfg, identity); err != nil {
+		log.Fatalf("Failed to set file ownership: %v", err)
+	}
+	if identity != nil && platform.IsRoot() && cfg.PrivilegedHelperSocket == "" {
+		platform.SetCommandIdentity(identity)
+		log.Printf("Files owned by %s; git and plugins run as %s, root kept for firewall and /etc/hosts (set privileged_helper_socket to drop it)", identity.User, identity.User)
+	}
 
 	// Create agent
-		agent := &Agent{
-			config:        cfg,
-			state:         stateMgr,
-			git:           gitMgr,
-		services:      svcMgr,
-		api:           apiClient,
-		externalProxy: externalProxy,
-		internalProxy: internalProxy,
-		dnsMgr:        dnsMgr,
-		fwMgr:         fwMgr,
-			applyFirewall: *applyFirewall,
-			healthPort:    9090, // Health check server port
-			lifecycle:     make(map[string]api.ServiceStatus),
-			lastBranchSync: make(map[string]time.Time),
+	agent, err := newAgent(cfg, stateMgr, secretsMgr, *applyFirewall)
+	if err != nil {
+		log.Fatalf("Failed to create agent: %v", err)
+	}
+	if cfg.PrivilegedHelperSocket != "" {
+		if err := agent.usePrivilegedHelper(privhelper.NewClient(cfg.PrivilegedHelperSocket)); err != nil {
+			log.Fatalf("Failed to use privileged helper: %v", err)
 		}
-	svcMgr.SetLifecycleReporter(agent.onServiceLifecycleEvent)
+		if err := agent.takeHelperListeners(); err != nil {
+			log.Fatalf("Failed to get listeners from privileged helper: %v", err)
+		}
+		// Everything needing root now goes through the helper.
+		if identity != nil && platform.IsRoot() {
+			if err := platform.DropPrivileges(identity); err != nil {
+				log.Fatalf("Failed to drop privileges to %s: %v", identity.User, err)
+			}
+			log.Printf("Dropped root: running as %s; firewall, /etc/hosts and privileged ports go through the helper", identity.User)
+		}
+	}
 
 	// Set up signal handling
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	signal.Notify(sigChan, append([]os.Signal{syscall.SIGINT, syscall.SIGTERM}, platform.UpgradeSignals...)...)
 
 	// Start agent
 	go agent.Run()
 
-	// Start health check server
-	// Note: Health check server functionality not yet implemented
-	// go func() {
-	// 	log.Printf("Starting health check server on port %d", agent.healthPort)
-	// 	if err := agent.services.StartHealthCheckServer(agent.healthPort); err != nil {
-	// 		log.Printf("Health check server failed: %v", err)
-	// 	}
-	// }()
+	agent.synthetics.Start()
+	defer agent.synthetics.Stop()
+
+	// Start admin API
+	adminSrv := admin.NewServer(stateMgr)
+	if err := adminSrv.ListenUnix(cfg.AdminSocketPath()); err != nil {
+		log.Printf("Admin API unavailable: %v", err)
+	}
+	if cfg.AdminListenAddr != "" {
+		auth := admin.AuthConfig{
+			Token:        cfg.AdminToken,
+			HMACSecret:   cfg.AdminHMACSecret,
+			AllowedCIDRs: cfg.AdminAllowedCIDRs,
+			TunnelCIDRs:  cfg.AdminTunnelCIDRs,
+			TLSCert:      cfg.AdminTLSCert,
+			TLSKey:       cfg.AdminTLSKey,
+			ClientCA:     cfg.AdminClientCA,
+		}
+		if auth.Token == "" && auth.HMACSecret == "" && auth.ClientCA == "" {
+			log.Printf("Warning: admin API on %s has no admin_token, admin_hmac_secret or admin_client_ca", cfg.AdminListenAddr)
+		}
+		if err := adminSrv.SetTCPAuth(auth); err != nil {
+			log.Printf("Admin API TCP listener disabled: %v", err)
+		} else if err := adminSrv.ListenTCP(cfg.AdminListenAddr); err != nil {
+			log.Printf("Admin API TCP listener unavailable: %v", err)
+		}
+	}
+	defer adminSrv.Stop()
+
+	// Start metrics sampling
+	if cfg.MetricsIntervalSeconds > 0 {
+		collector := metrics.NewCollector(stateMgr, agent.metricsTargets, agent.externalProxy.RequestCounts,
+			time.Duration(cfg.MetricsIntervalSeconds)*time.Second,
+			time.Duration(cfg.MetricsRawRetentionHours)*time.Hour,
+			time.Duration(cfg.MetricsRetentionDays)*24*time.Hour)
+		collector.Start()
+		defer collector.Stop()
+	}
+
+	// Start availability probing
+	if cfg.UptimeProbeIntervalSeconds > 0 {
+		tracker := metrics.NewAvailabilityTracker(stateMgr, agent.metricsTargets, agent.services.ProbeService,
+			time.Duration(cfg.UptimeProbeIntervalSeconds)*time.Second)
+		tracker.Start()
+		defer tracker.Stop()
+	}
+
+	// Start local alert evaluation
+	if cfg.AlertIntervalSeconds > 0 {
+		agent.alerts.Start()
+		defer agent.alerts.Stop()
+	}
+
+	// Start scheduled log export
+	if exporter := startLogExport(cfg, stateMgr, secretsMgr); exporter != nil {
+		defer exporter.Stop()
+	}
+
+	// Start shipping logs to the control plane
+	if shipper := startLogShipping(cfg, stateMgr, agent.api); shipper != nil {
+		defer shipper.Stop()
+	}
 
 	// Wait for shutdown signal
-	<-sigChan
-	log.Println("Shutting down...")
+	sig := <-sigChan
+	reason := offlineReason(sig)
+	log.Printf("Shutting down: reason=%s", reason)
 	agent.Stop()
+	agent.announceOffline(reason)
+}
+
+// newAPIClient creates a control plane client from the agent's config.
+func newAPIClient(cfg *config.Config) (*api.Client, error) {
+	apiClient := api.NewClient(cfg.ControlPlane, cfg.AgentID, cfg.AccessClientID, cfg.AccessClientSecret)
+	apiClient.SetFallbackURLs(cfg.ControlPlaneFallbacks...)
+	apiClient.SetAgentInfo(agentInfo())
+	apiClient.SetAPIKey(cfg.APIKey)
+	if err := apiClient.SetClientTLS(cfg.ControlPlaneClientCert, cfg.ControlPlaneClientKey, cfg.ControlPlaneCACert); err != nil {
+		return nil, fmt.Errorf("invalid control plane TLS configuration: %w", err)
+	}
+	caBundle, err := cfg.CABundle()
+	if err != nil {
+		return nil, fmt.Errorf("invalid ca_certs: %w", err)
+	}
+	if err := apiClient.SetExtraCAs(caBundle); err != nil {
+		return nil, fmt.Errorf("invalid ca_certs: %w", err)
+	}
+	if err := apiClient.SetTransport(cfg.ControlPlaneTransport); err != nil {
+		return nil, fmt.Errorf("invalid control_plane_transport: %w", err)
+	}
+	apiClient.SetRetryPolicy(api.RetryPolicy{
+		MaxAttempts: cfg.APIRetryAttempts,
+		BaseDelay:   time.Duration(cfg.APIRetryBaseDelayMs) * time.Millisecond,
+		MaxDelay:    time.Duration(cfg.APIRetryMaxDelayMs) * time.Millisecond,
+		Jitter:      cfg.APIRetryJitter,
+	})
+	return apiClient, nil
 }
 
 func applyConfigOverrides(cfg *config.Config, configPath string, agentID, stackID, controlPlane, accessClientID, accessClientSecret optionalString) error {
@@ -258,28 +534,213 @@ type Agent struct {
 	externalProxy     *proxy.ExternalProxy
 	internalProxy     *proxy.InternalProxy
 	dnsMgr            *proxy.DNSManager
-	fwMgr             *firewall.Manager
+	hosts             hostsUpdater
+	fwMgr             firewallManager
+	helper            *privhelper.Client // Set when privileged operations go through the helper
 	stopChan          chan struct{}
+	runDone           chan struct{} // Closed when Run returns, after any sync it started has finished
+	runCtx            context.Context // Cancelled by Stop to abandon control plane retries
+	cancelRun         context.CancelFunc
 	applyFirewall     bool
+	securityMu        sync.RWMutex // Guards fwMgr and currentMode; fwMgr is also only replaced under firewallMu
 	currentMode       string
-	healthPort        int
+	firewallMu        sync.Mutex
+	currentFirewall   string
+	rejectedFirewall  string
+	pendingFirewall   *pendingFirewall
+	lastEgressRefresh time.Time
 	heartbeatMu       sync.Mutex
 	heartbeatInterval int
 	lifecycleMu       sync.RWMutex
 	lifecycle         map[string]api.ServiceStatus
+	lifecycleStreamMu sync.Mutex
+	lifecycleStream   *api.LifecycleStream
+	// lifecycleFlush is the scheduled heartbeat for lifecycle updates that
+	// weren't streamed; lifecyclePending is whether any are not yet sent.
+	lifecycleFlushMu       sync.Mutex
+	lifecycleFlush         *time.Timer
+	lifecyclePending       bool
+	lifecycleFlushInterval time.Duration
+	// lifecycleEvents are transitions held for the next heartbeat; replayMu
+	// serializes replays of the offline heartbeat queue.
+	lifecycleEventsMu sync.Mutex
+	lifecycleEvents   []api.LifecycleEvent
+	replayMu          sync.Mutex
 	lastBranchSync    map[string]time.Time
+	synthetics        *synthetic.Runner
+	alerts            *alerts.Evaluator
+	system            *metrics.SystemCollector
+	featureMu         sync.Mutex
+	featureFlags      map[string]bool
+	applyMu           sync.Mutex
+	lastApply         *api.ApplyReport
+	syncNow           chan struct{}
+	sloMu             sync.Mutex
+	slos              []serviceSLO
+	pushConnected     atomic.Bool
+	pushCancel        context.CancelFunc // Stops the desired state streams; nil while push_sync is off
+	secrets           *secrets.Manager
+	desiredMu         sync.RWMutex
+	desiredServices   map[string]api.Service
+	desiredVersion    int
+	stacksMu          sync.Mutex
+	stackStates       map[string]*api.DesiredState
+	stackStatus       map[string]api.StackStatus
+	commandsMu        sync.Mutex
+	buildLogsMu       sync.Mutex
+	commandsSeen      map[string]time.Time
+	unsentResults     []api.CommandResult
+
+	deployReportsMu     sync.Mutex
+	unsentDeployReports []api.DeployReport
+
+	scopesMu      sync.Mutex
+	grantedScopes []string
+
+	capabilitiesMu sync.Mutex
+	capabilities   []string
+	capabilitiesAt time.Time
+}
+
+// newAgent wires up the agent's managers, proxies and control plane client.
+func newAgent(cfg *config.Config, stateMgr *state.Manager, secretsMgr *secrets.Manager, applyFirewall bool) (*Agent, error) {
+	svcMgr := service.NewManager(cfg.ReposPath(), stateMgr, secretsMgr, cfg.PortRangeStart, cfg.PortRangeEnd, cfg.VerboseLogging)
+	if err := svcMgr.SetPortPolicy(cfg.ExcludedPorts, cfg.PortRanges, cfg.PortPairing); err != nil {
+		return nil, fmt.Errorf("invalid port configuration: %w", err)
+	}
+	if err := svcMgr.SetRemoteBuild(cfg.BuildDockerHost, cfg.BuildxBuilder); err != nil {
+		return nil, fmt.Errorf("invalid build configuration: %w", err)
+	}
+	if err := svcMgr.SetBuildDir(cfg.BuildDir); err != nil {
+		return nil, fmt.Errorf("invalid build configuration: %w", err)
+	}
+	svcMgr.SetImageBudgets(cfg.ImageServiceBudgetMB, cfg.ImageTotalBudgetMB)
+	svcMgr.SetHealthProbeParallelism(cfg.HealthProbeParallelism)
+	svcMgr.SetResourceLimitCaps(cfg.MaxUlimits, cfg.AllowedSysctls, cfg.MaxShmSizeMB)
+	svcMgr.SetAllowedBindMounts(cfg.AllowedBindMounts)
+	svcMgr.SetHealthCheckDirectIP(cfg.HealthCheckDirectIP)
+	switch cfg.ServiceNaming {
+	case "", api.NamingSlug, api.NamingStrict:
+	default:
+		return nil, fmt.Errorf("invalid service_naming %q: use %q or %q", cfg.ServiceNaming, api.NamingSlug, api.NamingStrict)
+	}
+	if cfg.APIRetryAttempts < 0 || cfg.APIRetryBaseDelayMs < 0 || cfg.APIRetryMaxDelayMs < 0 {
+		return nil, fmt.Errorf("api_retry_attempts, api_retry_base_delay_ms and api_retry_max_delay_ms must not be negative")
+	}
+	if cfg.ImageServiceBudgetMB < 0 || cfg.ImageTotalBudgetMB < 0 {
+		return nil, fmt.Errorf("image_service_budget_mb and image_total_budget_mb must not be negative")
+	}
+	if cfg.APIRetryJitter < 0 || cfg.APIRetryJitter > 1 {
+		return nil, fmt.Errorf("invalid api_retry_jitter %v: must be between 0 and 1", cfg.APIRetryJitter)
+	}
+	if cfg.MaxShmSizeMB < 0 {
+		return nil, fmt.Errorf("max_shm_size_mb must not be negative")
+	}
+	if cfg.DockerBuildTimeoutSeconds < 0 || cfg.DockerPullTimeoutSeconds < 0 || cfg.DockerRunTimeoutSeconds < 0 || cfg.DockerInspectTimeoutSeconds < 0 || cfg.DockerStopTimeoutSeconds < 0 {
+		return nil, fmt.Errorf("docker_*_timeout_seconds must not be negative")
+	}
+	container.SetDockerTimeouts(container.DockerTimeouts{
+		Build:   time.Duration(cfg.DockerBuildTimeoutSeconds) * time.Second,
+		Pull:    time.Duration(cfg.DockerPullTimeoutSeconds) * time.Second,
+		Run:     time.Duration(cfg.DockerRunTimeoutSeconds) * time.Second,
+		Inspect: time.Duration(cfg.DockerInspectTimeoutSeconds) * time.Second,
+		Stop:    time.Duration(cfg.DockerStopTimeoutSeconds) * time.Second,
+	})
+	if cfg.HealthProbeParallelism < 0 {
+		return nil, fmt.Errorf("health_probe_parallelism must not be negative")
+	}
+	if cfg.LogShipIntervalSeconds < 0 || cfg.LogShipBatchSize < 0 {
+		return nil, fmt.Errorf("log_ship_interval_seconds and log_ship_batch_size must not be negative")
+	}
+	if cfg.LifecycleFlushSeconds < 0 {
+		return nil, fmt.Errorf("lifecycle_flush_seconds must not be negative")
+	}
+	if cfg.HeartbeatQueueSize < 0 {
+		return nil, fmt.Errorf("heartbeat_queue_size must not be negative")
+	}
+	if cfg.AutoRollbackFailures < 0 {
+		return nil, fmt.Errorf("auto_rollback_failures must not be negative")
+	}
+
+	apiClient, err := newAPIClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	caBundle, err := cfg.CABundle()
+	if err != nil {
+		return nil, fmt.Errorf("invalid ca_certs: %w", err)
+	}
+	gitMgr := git.NewManager(cfg.ReposPath(), cfg.SSHKeyDir())
+	gitMgr.SetCABundle(caBundle)
+	if identity, err := serviceIdentity(cfg); err != nil {
+		return nil, err
+	} else if identity != nil && platform.IsRoot() {
+		gitMgr.SetOwner(identity)
+	}
+	runCtx, cancelRun := context.WithCancel(context.Background())
+	dnsMgr := proxy.NewDNSManager()
+
+	agent := &Agent{
+		config:         cfg,
+		state:          stateMgr,
+		git:            gitMgr,
+		services:       svcMgr,
+		api:            apiClient,
+		externalProxy:  proxy.NewExternalProxy(cfg.ExternalProxyPort, "0.0.0.0"),
+		internalProxy:  proxy.NewInternalProxy(),
+		dnsMgr:         dnsMgr,
+		hosts:          dnsMgr,
+		applyFirewall:  applyFirewall,
+		lifecycle:      make(map[string]api.ServiceStatus),
+		lastBranchSync: make(map[string]time.Time),
+		syncNow:        make(chan struct{}, 1),
+		stopChan:       make(chan struct{}),
+		runDone:        make(chan struct{}),
+		synthetics:     synthetic.NewRunner(),
+		runCtx:         runCtx,
+		cancelRun:      cancelRun,
+		secrets:        secretsMgr,
+		commandsSeen:   make(map[string]time.Time),
+
+		lifecycleFlushInterval: time.Duration(cfg.LifecycleFlushSeconds) * time.Second,
+	}
+	svcMgr.SetLifecycleReporter(agent.onServiceLifecycleEvent)
+	svcMgr.SetDiagnostics(cfg.DiagnosticsPath(), agent.onDeployDiagnostics)
+	svcMgr.SetBuildLogReporter(agent.onBuildLog)
+	svcMgr.SetDeployReporter(agent.onDeployReport)
+	svcMgr.SetPluginsDir(cfg.PluginsPath())
+	svcMgr.SetConfigFilesDir(cfg.ConfigFilesPath())
+	canaries := proxy.NewCanaryRouter()
+	agent.externalProxy.SetCanaryRouter(canaries)
+	agent.internalProxy.SetCanaryRouter(canaries)
+	svcMgr.SetCanaryRouter(canaries)
+
+	alertRules := alerts.Rules{
+		ServiceDownMinutes: cfg.AlertServiceDownMinutes,
+		RestartsPerHour:    cfg.AlertRestartsPerHour,
+		CrashesPerHour:     cfg.AlertCrashesPerHour,
+		CrashesPerDay:      cfg.AlertCrashesPerDay,
+		DiskPercent:        cfg.AlertDiskPercent,
+		CertExpiryDays:     cfg.AlertCertExpiryDays,
+	}
+	agent.system = metrics.NewSystemCollector(cfg.DataDir, agent.metricsTargets)
+	agent.alerts = alerts.NewEvaluator(stateMgr, alertRules, agent.metricsTargets, cfg.DataDir, func(alert alerts.Alert) {
+		service.NotifyPlugins(cfg.PluginsPath(), service.HookAlert, alert)
+	}, time.Duration(cfg.AlertIntervalSeconds)*time.Second)
+	agent.checkAPIKeyScopes(cfg.APIKeyScopes)
+	return agent, nil
 }
 
 // Run starts the agent main loop
 func (a *Agent) Run() {
-	a.stopChan = make(chan struct{})
+	defer close(a.runDone)
 	a.heartbeatMu.Lock()
 	if a.heartbeatInterval <= 0 {
 		a.heartbeatInterval = 30
 	}
 	initialHeartbeatInterval := a.heartbeatInterval
 	a.heartbeatMu.Unlock()
-	log.Printf("Agent run loop started: poll_interval=%ds heartbeat_interval=%ds branch_self_heal_interval=%s (initial, may update from desired state)", a.config.PollInterval, initialHeartbeatInterval, branchSelfHealInterval)
+	log.Printf("Agent run loop started: version=%s poll_interval=%ds heartbeat_interval=%ds branch_self_heal_interval=%s (initial, may update from desired state)", agentInfo().Version, a.config.PollInterval, initialHeartbeatInterval, branchSelfHealInterval)
 
 	if a.externalProxy != nil {
 		go func() {
@@ -296,6 +757,8 @@ func (a *Agent) Run() {
 		}()
 	}
 
+	go a.services.WatchContainerEvents(a.stopChan)
+
 	// Do initial sync
 	if err := a.sync(); err != nil {
 		log.Printf("Initial sync failed: %v", err)
@@ -309,6 +772,12 @@ func (a *Agent) Run() {
 	// Start poll loop
 	ticker := time.NewTicker(time.Duration(a.config.PollInterval) * time.Second)
 	defer ticker.Stop()
+	lastSync := time.Now()
+
+	a.updatePushSync()
+	if a.api.UsesGRPC() {
+		go a.runLifecycleStream(a.runCtx)
+	}
 
 	// Start heartbeat loop with the current interval (possibly updated by initial sync)
 	a.heartbeatMu.Lock()
@@ -322,15 +791,43 @@ func (a *Agent) Run() {
 	lastHeartbeatInterval := currentHeartbeatInterval
 	defer heartbeatTicker.Stop()
 
+	// Syncs run off the loop, so heartbeats keep going through long builds
+	// and canary bakes. A sync asked for while one runs starts after it.
+	syncDone := make(chan error, 1)
+	syncing, syncQueued := false, false
+	startSync := func() {
+		if syncing {
+			syncQueued = true
+			return
+		}
+		syncing = true
+		lastSync = time.Now()
+		go func() { syncDone <- a.sync() }()
+	}
+
 	for {
 		select {
 		case <-a.stopChan:
+			if syncing {
+				// Stop tears down what the sync is using once Run returns.
+				<-syncDone
+			}
 			return
 		case <-ticker.C:
+			if a.pushConnected.Load() && time.Since(lastSync) < pushSafetyPollInterval {
+				continue
+			}
 			a.logVerbosef("Sync tick")
-			if err := a.sync(); err != nil {
+			startSync()
+		case <-a.syncNow:
+			a.logVerbosef("Sync requested by desired state stream")
+			startSync()
+		case err := <-syncDone:
+			syncing = false
+			if err != nil {
 				log.Printf("Sync failed: %v", err)
 			}
+			a.updatePushSync()
 			// Reset heartbeat ticker only when interval actually changes.
 			// If poll interval < heartbeat interval, resetting every sync would prevent heartbeats.
 			a.heartbeatMu.Lock()
@@ -340,6 +837,10 @@ func (a *Agent) Run() {
 				heartbeatTicker.Reset(time.Duration(currentInterval) * time.Second)
 				lastHeartbeatInterval = currentInterval
 			}
+			if syncQueued {
+				syncQueued = false
+				startSync()
+			}
 		case <-heartbeatTicker.C:
 			a.logVerbosef("Heartbeat tick")
 			if err := a.sendHeartbeat(); err != nil {
@@ -349,9 +850,76 @@ func (a *Agent) Run() {
 	}
 }
 
+// metricsTargets lists running services for the metrics collector.
+func (a *Agent) metricsTargets() []metrics.Target {
+	running := a.services.RunningServices()
+	targets := make([]metrics.Target, 0, len(running))
+	for _, svc := range running {
+		targets = append(targets, metrics.Target{
+			ServiceID:     svc.ServiceID,
+			ContainerName: svc.ContainerName,
+			Hostname:      svc.Hostname,
+		})
+	}
+	return targets
+}
+
+// warmService sends a service's warm-up requests through the external proxy.
+func (a *Agent) warmService(svc api.Service) {
+	start := time.Now()
+	failed := 0
+	for _, result := range a.externalProxy.Warm(svc.Hostname, svc.WarmupPaths) {
+		if result.Err != nil {
+			failed++
+			log.Printf("Warm-up request failed: service=%s path=%s err=%v", svc.ID, result.Path, result.Err)
+			continue
+		}
+		a.logVerbosef("Warm-up request: service=%s path=%s status=%d latency=%s", svc.ID, result.Path, result.StatusCode, result.Latency)
+	}
+	log.Printf("Warm-up complete: service=%s paths=%d failed=%d elapsed=%s", svc.ID, len(svc.WarmupPaths), failed, time.Since(start))
+}
+
+// uptimeSummary returns rolling uptime for a service, or nil when it has never
+// been probed.
+func (a *Agent) uptimeSummary(serviceID string) *api.UptimeSummary {
+	uptimes, err := metrics.ServiceUptimes(a.state, serviceID, time.Now())
+	if err != nil || len(uptimes) != 3 || uptimes[2].Observed == 0 {
+		return nil
+	}
+	return &api.UptimeSummary{
+		Last24h: uptimes[0].Percent,
+		Last7d:  uptimes[1].Percent,
+		Last30d: uptimes[2].Percent,
+	}
+}
+
+// restartSummary returns a service's crash restarts over the last hour and
+// day, and the day's OOM kills, or nil when it has had none.
+func (a *Agent) restartSummary(serviceID string) *api.RestartSummary {
+	now := time.Now()
+	day, err := a.state.CountServiceRestarts(serviceID, now.Add(-24*time.Hour))
+	if err != nil || day == 0 {
+		return nil
+	}
+	hour, err := a.state.CountServiceRestarts(serviceID, now.Add(-time.Hour))
+	if err != nil {
+		return nil
+	}
+	oomKills, err := a.state.CountServiceOOMKills(serviceID, now.Add(-24*time.Hour))
+	if err != nil {
+		return nil
+	}
+	return &api.RestartSummary{LastHour: hour, Last24h: day, OOMKills24h: oomKills}
+}
+
 // Stop stops the agent
 func (a *Agent) Stop() {
 	close(a.stopChan)
+	a.cancelRun()
+	a.stopLifecycleFlush()
+	// The control plane retries of an in-flight sync are abandoned above;
+	// wait for it before the proxies, firewall and logs go away.
+	<-a.runDone
 
 	// Stop all running services
 	// Note: ListRunningServices not yet implemented
@@ -371,29 +939,48 @@ func (a *Agent) Stop() {
 	}
 
 	// Cleanup DNS
-	if a.dnsMgr != nil {
-		a.dnsMgr.Cleanup()
+	if a.hosts != nil {
+		a.hosts.Cleanup()
 	}
 
 	// Revert firewall rules
-	if a.fwMgr != nil && a.applyFirewall {
-		if err := a.fwMgr.Revert(); err != nil {
+	if fw, _ := a.securityState(); fw != nil && a.applyFirewall {
+		if err := fw.Revert(); err != nil {
 			log.Printf("Failed to revert firewall rules: %v", err)
 		}
 	}
+
+	// Write service logs still buffered
+	if err := a.state.FlushLogs(); err != nil {
+		log.Printf("Failed to flush service logs: %v", err)
+	}
 }
 
 // sync fetches desired state and applies changes
 func (a *Agent) sync() error {
 	start := time.Now()
-	log.Printf("Sync started: stack=%s", a.config.StackID)
+	log.Printf("Sync started: stack=%s", strings.Join(a.config.Stacks(), ","))
 
 	// Fetch desired state
-	desired, err := a.api.GetDesiredState(a.config.StackID)
+	desired, err := a.fetchDesiredState()
 	if err != nil {
 		return fmt.Errorf("failed to fetch desired state: %w", err)
 	}
-	a.logVerbosef("Desired state received: version=%d hash=%s services=%d mode=%s poll_interval=%d heartbeat_interval=%d", desired.Version, desired.Hash, len(desired.Services), desired.SecurityMode, desired.PollInterval, desired.HeartbeatInterval)
+	// Queued commands run once the state is applied, even if it failed.
+	defer a.runCommands()
+	a.logVerbosef("Desired state received: version=%d hash=%s services=%d mode=%s poll_interval=%d heartbeat_interval=%d not_modified=%t stale=%t", desired.Version, desired.Hash, len(desired.Services), desired.SecurityMode, desired.PollInterval, desired.HeartbeatInterval, desired.NotModified, desired.Stale)
+
+	// Reject the whole state if any service names are unusable, before
+	// anything is changed.
+	if err := a.validateServiceNames(desired.Services); err != nil {
+		var nameErrs api.ServiceNamesError
+		if errors.As(err, &nameErrs) {
+			for _, nameErr := range nameErrs {
+				log.Printf("Invalid service name: service=%s name=%q reason=%s", nameErr.ServiceID, nameErr.Name, nameErr.Reason)
+			}
+		}
+		return fmt.Errorf("rejected desired state version %d: %w", desired.Version, err)
+	}
 
 	// Update heartbeat interval from desired state (validate range: 30-300 seconds)
 	newInterval := desired.HeartbeatInterval
@@ -409,20 +996,34 @@ func (a *Agent) sync() error {
 	}
 	a.heartbeatMu.Unlock()
 
+	a.synthetics.SetChecks(desired.SyntheticChecks)
+	a.applyFeatureFlags(desired.Features)
+
 	// Check if we need to apply changes
 	applied, err := a.state.GetAppliedState()
 	if err != nil {
 		return fmt.Errorf("failed to get applied state: %w", err)
 	}
-	stateChanged := applied == nil || applied.StateHash != desired.Hash
+	// Applied state is tracked by the agent's own hash, so a change in how
+	// the control plane computes desired.Hash is not seen as a new state.
+	stateHash := desired.StateHash()
+	stateChanged := applied == nil || applied.StateHash != stateHash
 
 	if stateChanged {
-		log.Printf("Applying state version %d (hash: %s)", desired.Version, desired.Hash)
+		log.Printf("Applying state version %d (hash: %s)", desired.Version, stateHash)
 	} else {
-		log.Printf("Desired state unchanged (hash: %s); reconciling runtime and routes", desired.Hash)
+		log.Printf("Desired state unchanged (hash: %s); reconciling runtime and routes", stateHash)
 	}
 
 	hadErrors := false
+	// Failures are tracked per service so the services that did apply are
+	// recorded and reported even when others fail.
+	failed := make(map[string]bool)
+	heldServices := make(map[string]bool)
+	failService := func(serviceID string) {
+		failed[serviceID] = true
+		hadErrors = true
+	}
 	desiredByID := make(map[string]api.Service)
 	for _, svc := range desired.Services {
 		if svc.GitRef == "" {
@@ -430,6 +1031,24 @@ func (a *Agent) sync() error {
 		}
 		desiredByID[svc.ID] = svc
 	}
+	a.desiredMu.Lock()
+	a.desiredServices = desiredByID
+	a.desiredVersion = desired.Version
+	a.desiredMu.Unlock()
+
+	// Start pulling the registry images of changed services now, so each
+	// deploy below finds its image local instead of waiting on the download.
+	var prePulls []api.Service
+	for _, svc := range desired.Services {
+		svc = desiredByID[svc.ID]
+		if proc, _ := a.state.GetServiceProcess(svc.ID); proc != nil && proc.DefinitionHash == serviceDefinitionHash(svc) {
+			continue
+		}
+		if !a.serviceHeld(svc) {
+			prePulls = append(prePulls, svc)
+		}
+	}
+	a.services.PrePullImages(prePulls)
 
 	// Stop services removed from desired state
 	if existing, err := a.state.ListServiceProcesses(); err == nil {
@@ -437,17 +1056,30 @@ func (a *Agent) sync() error {
 			if _, ok := desiredByID[proc.ServiceID]; ok {
 				continue
 			}
+			if hold, _ := a.state.GetServiceHold(proc.ServiceID); hold != nil {
+				log.Printf("Service on hold, not removing: service=%s", proc.ServiceID)
+				a.markPending(proc.ServiceID, "remove", "held", "")
+				continue
+			}
+			a.clearPending(proc.ServiceID)
 			if err := a.services.StopService(proc.ServiceID); err != nil {
 				log.Printf("Failed to stop removed service %s: %v", proc.ServiceID, err)
-				hadErrors = true
+				failService(proc.ServiceID)
 			}
+			a.services.ForgetTask(proc.ServiceID)
 			if err := a.state.DeleteServiceProcess(proc.ServiceID); err != nil {
 				log.Printf("Failed to delete state for service %s: %v", proc.ServiceID, err)
-				hadErrors = true
+				failService(proc.ServiceID)
+			}
+			if err := a.state.DeleteServiceRevision(proc.ServiceID); err != nil {
+				log.Printf("Failed to delete revision for service %s: %v", proc.ServiceID, err)
+			}
+			if err := a.state.DeleteRollbackTarget(proc.ServiceID); err != nil {
+				log.Printf("Failed to delete rollback target for service %s: %v", proc.ServiceID, err)
 			}
 				if err := a.git.RemoveRepo(proc.ServiceID); err != nil {
 					log.Printf("Failed to remove repo for service %s: %v", proc.ServiceID, err)
-					hadErrors = true
+					failService(proc.ServiceID)
 				}
 				delete(a.lastBranchSync, proc.ServiceID)
 			}
@@ -455,11 +1087,23 @@ func (a *Agent) sync() error {
 			log.Printf("Failed to list existing services: %v", err)
 			hadErrors = true
 	}
+	if pending, err := a.state.ListPendingActions(); err == nil {
+		for _, p := range pending {
+			if _, ok := desiredByID[p.ServiceID]; !ok && p.Action != "remove" {
+				a.clearPending(p.ServiceID)
+			}
+		}
+	}
+	a.runRequestedRollbacks(desiredByID)
 
 	// Update proxy routes
 	externalRoutes := make(map[string]int)
+	hostHeaders := make(map[string]string)
+	var slos []serviceSLO
 	internalRoutes := make(map[string]int)
+	routeOwners := make(map[string]string)
 	var serviceNames []string
+	var deployed []api.Service
 
 	// Get list of currently running services
 	// Note: ListRunningServices not yet implemented
@@ -474,14 +1118,32 @@ func (a *Agent) sync() error {
 		if svc.GitRef == "" {
 			svc.GitRef = "main"
 		}
-		serviceNames = append(serviceNames, svc.Name)
+		held := a.serviceHeld(svc)
+		heldServices[svc.ID] = held
+		if held {
+			a.logVerbosef("Service on hold, skipping reconciliation: name=%s service=%s", svc.Name, svc.ID)
+		}
+		if service.IsTask(svc) {
+			if held {
+				continue
+			}
+			if err := a.syncTask(svc); err != nil {
+				log.Printf("Task failed: name=%s service=%s err=%v", svc.Name, svc.ID, err)
+				failService(svc.ID)
+			}
+			continue
+		}
+		worker := service.IsWorker(svc)
+		if !worker {
+			serviceNames = append(serviceNames, a.internalNames(svc)...)
+		}
 
 		assignedPort, exists := a.services.GetServicePort(svc.ID)
 		if !exists {
 			recoveredPort, recovered, recoverErr := a.services.RecoverService(svc)
 			if recoverErr != nil {
 				log.Printf("Failed to recover service %s: %v", svc.Name, recoverErr)
-				hadErrors = true
+				failService(svc.ID)
 			} else if recovered {
 				assignedPort = recoveredPort
 				exists = true
@@ -490,7 +1152,15 @@ func (a *Agent) sync() error {
 		}
 
 		proc, _ := a.state.GetServiceProcess(svc.ID)
-		needsDeploy := !exists || proc == nil || proc.Status != "running"
+		definitionHash := serviceDefinitionHash(svc)
+		definitionChanged := proc == nil || proc.DefinitionHash != definitionHash
+		// A service recorded before definition hashes existed is synced once to
+		// store its hash, but is not redeployed for that alone.
+		definitionEdited := proc != nil && proc.DefinitionHash != "" && proc.DefinitionHash != definitionHash
+		imageDrifted := a.featureEnabled("image_drift_self_heal") && a.services.ImageDrift(svc.ID) != nil
+		reloadCandidate := exists && proc != nil && proc.Status == "running" && definitionEdited && !imageDrifted
+		wantDeploy := !exists || proc == nil || proc.Status != "running" || definitionEdited || imageDrifted
+		needsDeploy := !held && wantDeploy
 		resolvedCommit := ""
 		if proc != nil {
 			resolvedCommit = proc.GitCommit
@@ -499,8 +1169,8 @@ func (a *Agent) sync() error {
 
 			// For branch-tracking git services (no pinned git_commit), do a slower self-heal sync.
 			// Webhooks should be the primary trigger for rapid deploys.
-			if !isDockerServiceType(svc.ServiceType) && strings.TrimSpace(svc.GitCommit) == "" {
-				shouldCheckBranchLatest := stateChanged || needsDeploy
+			if !held && !service.UsesPrebuiltImage(svc) && strings.TrimSpace(svc.GitCommit) == "" {
+				shouldCheckBranchLatest := definitionChanged || needsDeploy
 				if !shouldCheckBranchLatest {
 					lastCheck, ok := a.lastBranchSync[svc.ID]
 					shouldCheckBranchLatest = !ok || time.Since(lastCheck) >= branchSelfHealInterval
@@ -510,7 +1180,8 @@ func (a *Agent) sync() error {
 					if err != nil {
 						a.onServiceLifecycleEvent(svc, "error", "unknown", err.Error())
 						log.Printf("Failed to sync repo for service %s: %v", svc.Name, err)
-						hadErrors = true
+						a.markPendingFailed(svc.ID, "sync_repo", svc.GitRef, svc.GitRef, err)
+						failService(svc.ID)
 						continue
 					}
 					resolvedCommit = latestCommit
@@ -522,8 +1193,8 @@ func (a *Agent) sync() error {
 				delete(a.lastBranchSync, svc.ID)
 			}
 
-		if stateChanged || needsDeploy || repoSynced {
-			if !isDockerServiceType(svc.ServiceType) {
+		if !held && (definitionChanged || needsDeploy || repoSynced) {
+			if !service.UsesPrebuiltImage(svc) {
 				shouldSyncRepo := !repoSynced && (needsDeploy || proc == nil || strings.TrimSpace(svc.GitCommit) != "" || strings.TrimSpace(resolvedCommit) == "")
 				if shouldSyncRepo {
 					var err error
@@ -531,7 +1202,8 @@ func (a *Agent) sync() error {
 					if err != nil {
 						a.onServiceLifecycleEvent(svc, "error", "unknown", err.Error())
 						log.Printf("Failed to sync repo for service %s: %v", svc.Name, err)
-						hadErrors = true
+						a.markPendingFailed(svc.ID, "sync_repo", svc.GitRef, svc.GitRef, err)
+						failService(svc.ID)
 						continue
 					}
 				}
@@ -542,68 +1214,190 @@ func (a *Agent) sync() error {
 
 			needsDeploy = needsDeploy || proc == nil || proc.GitCommit != resolvedCommit || proc.Status != "running"
 
+			// Config-file-only edits to a service with a reload_signal are applied
+			// in place and signalled rather than redeployed.
+			if needsDeploy && reloadCandidate && proc.GitCommit == resolvedCommit && strings.TrimSpace(svc.ReloadSignal) != "" {
+				reloaded, err := a.services.ReloadConfig(svc)
+				if err != nil {
+					log.Printf("Failed to reload config, redeploying: name=%s service=%s error=%v", svc.Name, svc.ID, err)
+				} else if reloaded {
+					log.Printf("Config reloaded: name=%s service=%s signal=%s", svc.Name, svc.ID, svc.ReloadSignal)
+					needsDeploy = false
+				}
+			}
+
+			// A deploy that keeps failing for the same definition and commit is
+			// retried with exponential backoff instead of on every sync.
+			revision := definitionHash + ":" + resolvedCommit
+			// A rolled-back revision leaves the earlier release running until the
+			// desired state moves on.
+			rolledBack := false
+			if needsDeploy && a.rolledBackRevision(svc.ID) == revision {
+				a.logVerbosef("Deploy skipped, revision was rolled back: name=%s service=%s revision=%s", svc.Name, svc.ID, revision)
+				heldServices[svc.ID] = true
+				needsDeploy = false
+				rolledBack = true
+			}
+			backedOff := false
 			if needsDeploy {
+				if wait := a.retryWait(svc.ID, "deploy", revision); wait > 0 {
+					pending, _ := a.state.GetPendingAction(svc.ID)
+					log.Printf("Deploy backoff: name=%s service=%s failures=%d retry_in=%s", svc.Name, svc.ID, pending.Attempts, wait.Round(time.Second))
+					failService(svc.ID)
+					needsDeploy = false
+					backedOff = true
+				}
+			}
+
+			// Changes to a running protected service wait until an operator
+			// confirms this revision.
+			awaitingConfirmation := false
+			if needsDeploy && svc.Protected && exists && proc != nil && proc.Status == "running" && !a.deployConfirmed(svc.ID, revision) {
+				log.Printf("Protected service change awaiting confirmation: name=%s service=%s", svc.Name, svc.ID)
+				a.markPending(svc.ID, "deploy", "awaiting_confirmation", revision)
+				heldServices[svc.ID] = true
+				needsDeploy = false
+				awaitingConfirmation = true
+			}
+
+			if needsDeploy {
+				reason := deployReason(definitionEdited, imageDrifted, exists, proc, resolvedCommit)
 				a.onServiceLifecycleEvent(svc, "building", "unknown", "")
-				log.Printf("Deploying service: name=%s service=%s reason=%s", svc.Name, svc.ID, deployReason(stateChanged, exists, proc, resolvedCommit))
+				log.Printf("Deploying service: name=%s service=%s reason=%s", svc.Name, svc.ID, reason)
+				a.markPending(svc.ID, "deploy", reason, revision)
 				if err := a.services.DeployService(svc); err != nil {
-					a.onServiceLifecycleEvent(svc, "error", "unknown", err.Error())
+					status := "error"
+					if errors.Is(err, service.ErrDeployTimeout) {
+						status = "deploy_timeout"
+					} else if errors.Is(err, service.ErrDependencyUnavailable) {
+						status = "dependency_unavailable"
+					}
+					a.onServiceLifecycleEvent(svc, status, "unknown", err.Error())
 					log.Printf("Failed to deploy service %s: %v", svc.Name, err)
-					hadErrors = true
-					continue
+					a.markPendingFailed(svc.ID, "deploy", reason, revision, err)
+					if !a.autoRollback(svc, revision) {
+						failService(svc.ID)
+						continue
+					}
+					// The earlier release serves again; keep routing to it.
+					heldServices[svc.ID] = true
+					rolledBack = true
+				} else {
+					a.clearPending(svc.ID)
+					if svc.Protected {
+						if err := a.state.ClearServiceConfirmation(svc.ID); err != nil {
+							log.Printf("Failed to clear confirmation for service %s: %v", svc.Name, err)
+						}
+					}
+					if !worker {
+						deployed = append(deployed, svc)
+					}
 				}
-			} else {
+			} else if !backedOff && !awaitingConfirmation && !rolledBack {
 				a.clearTransientLifecycleStatus(svc.ID)
+				a.clearPending(svc.ID)
 			}
 
 			assignedPort, exists = a.services.GetServicePort(svc.ID)
 			if !exists {
 				log.Printf("Warning: no port assigned for service %s after sync", svc.Name)
-				hadErrors = true
+				failService(svc.ID)
 				continue
 			}
+			if definitionChanged && !backedOff && !awaitingConfirmation && !rolledBack {
+				if err := a.state.SetServiceDefinitionHash(svc.ID, definitionHash); err != nil {
+					log.Printf("Failed to record definition hash for service %s: %v", svc.Name, err)
+				}
+			}
 		} else {
 			a.clearTransientLifecycleStatus(svc.ID)
+			if held && wantDeploy {
+				a.markPending(svc.ID, "deploy", "held", "")
+			} else if a.rolledBackRevision(svc.ID) == "" {
+				a.clearPending(svc.ID)
+			}
 		}
 
 		if !exists {
 			log.Printf("Warning: no port assigned for service %s", svc.Name)
-			hadErrors = true
+			failService(svc.ID)
+			continue
+		}
+		if worker {
 			continue
 		}
 
 		// Build routes (hostname-based routing)
 		if svc.Hostname != "" {
-			externalRoutes[svc.Hostname] = assignedPort
+			if _, err := proxy.NormalizeHostPattern(svc.Hostname); err != nil {
+				log.Printf("Invalid hostname, not routed: name=%s service=%s err=%v", svc.Name, svc.ID, err)
+				failService(svc.ID)
+			} else if err := proxy.ValidateHostHeader(svc.HostHeader); err != nil {
+				log.Printf("Invalid host header, not routed: name=%s service=%s err=%v", svc.Name, svc.ID, err)
+				failService(svc.ID)
+			} else if owner, taken := routeOwners[svc.Hostname]; taken {
+				log.Printf("Hostname already routed to another service, not routed: name=%s service=%s hostname=%s owner=%s", svc.Name, svc.ID, svc.Hostname, owner)
+				failService(svc.ID)
+			} else {
+				routeOwners[svc.Hostname] = svc.ID
+				externalRoutes[svc.Hostname] = assignedPort
+				if svc.HostHeader != "" {
+					hostHeaders[svc.Hostname] = svc.HostHeader
+				}
+				if svc.SLO != nil {
+					if err := validateSLO(*svc.SLO); err != nil {
+						log.Printf("Invalid SLO, not tracked: name=%s service=%s err=%v", svc.Name, svc.ID, err)
+					} else {
+						slos = append(slos, serviceSLO{serviceID: svc.ID, route: svc.Hostname, slo: *svc.SLO})
+					}
+				}
+			}
+		}
+		for _, name := range a.internalNames(svc) {
+			internalRoutes[name] = assignedPort
 		}
-		internalRoutes[svc.Name] = assignedPort
 	}
 
 	// Update security mode if changed
-	if a.currentMode != desired.SecurityMode {
-		a.currentMode = desired.SecurityMode
-		if a.applyFirewall {
-			if err := a.updateFirewall(desired.SecurityMode, desired.ExternalProxyPort); err != nil {
-				log.Printf("Failed to update firewall: %v", err)
-			}
-		}
+	a.securityMu.Lock()
+	a.currentMode = desired.SecurityMode
+	a.securityMu.Unlock()
+	if a.applyFirewall {
+		a.reconcileFirewall(desired)
 	}
 
 	// Update proxy routes
 	a.externalProxy.UpdateRoutes(externalRoutes)
+	a.externalProxy.SetHostHeaders(hostHeaders)
+	a.setSLOs(slos)
 	a.internalProxy.UpdateRoutes(internalRoutes)
 	log.Printf("Routes updated: external=%d internal=%d services=%d", len(externalRoutes), len(internalRoutes), len(serviceNames))
+	if err := a.state.SaveRoutes("external", externalRoutes); err != nil {
+		log.Printf("Failed to persist external routes: %v", err)
+	}
+	if err := a.state.SaveRoutes("internal", internalRoutes); err != nil {
+		log.Printf("Failed to persist internal routes: %v", err)
+	}
+
+	// Warm newly deployed services now that traffic routes to them
+	for _, svc := range deployed {
+		if svc.Hostname != "" && len(svc.WarmupPaths) > 0 {
+			go a.warmService(svc)
+		}
+	}
 
 	// Update DNS entries
-	if err := a.dnsMgr.UpdateServices(serviceNames); err != nil {
+	if err := a.hosts.UpdateServices(serviceNames); err != nil {
 		log.Printf("Failed to update DNS: %v", err)
 	}
 
 	// Record that we applied this state
+	a.recordApplyResult(desired, stateHash, failed, heldServices)
 	if hadErrors {
 		return fmt.Errorf("state applied with errors")
 	}
 	if stateChanged {
-		if err := a.state.SetAppliedState(desired.Version, desired.Hash); err != nil {
+		if err := a.state.SetAppliedState(desired.Version, stateHash); err != nil {
 			return fmt.Errorf("failed to record applied state: %w", err)
 		}
 	}
@@ -612,8 +1406,127 @@ func (a *Agent) sync() error {
 	return nil
 }
 
+// pendingFirewall is a firewall change awaiting confirmation by a heartbeat.
+type pendingFirewall struct {
+	key         string
+	previousKey string
+	appliedAt   time.Time
+	fw          firewallManager
+	timer       *time.Timer
+}
+
+// reconcileFirewall applies the desired firewall settings if they changed.
+// New rules are rolled back unless a heartbeat confirms the control plane is
+// still reachable within firewall_confirm_minutes; a rolled-back rule set is
+// not retried until the desired state changes.
+func (a *Agent) reconcileFirewall(desired *api.DesiredState) {
+	sshPort, sshCIDR := a.sshRestrictions(desired)
+	egress := a.featureEnabled("egress_control")
+	key := fmt.Sprintf("%s|%d|%d|%s|egress=%t", desired.SecurityMode, desired.ExternalProxyPort, sshPort, sshCIDR, egress)
+
+	a.firewallMu.Lock()
+	defer a.firewallMu.Unlock()
+	if key == a.currentFirewall {
+		a.refreshEgress()
+		return
+	}
+	if key == a.rejectedFirewall {
+		a.logVerbosef("Firewall settings were rolled back earlier; not reapplying: %s", key)
+		return
+	}
+	if a.pendingFirewall != nil {
+		// Superseded before confirmation; the new change gets its own timer.
+		a.pendingFirewall.timer.Stop()
+		a.pendingFirewall = nil
+	}
+
+	previousKey := a.currentFirewall
+	if err := a.updateFirewall(desired.SecurityMode, desired.ExternalProxyPort, sshPort, sshCIDR, egress); err != nil {
+		// Retried on the next sync.
+		log.Printf("Failed to update firewall: %v", err)
+		return
+	}
+	a.currentFirewall = key
+
+	window := time.Duration(a.config.FirewallConfirmMinutes) * time.Minute
+	if window <= 0 || desired.SecurityMode == string(firewall.SecurityModeNone) {
+		return
+	}
+	pending := &pendingFirewall{key: key, previousKey: previousKey, appliedAt: time.Now(), fw: a.fwMgr}
+	pending.timer = time.AfterFunc(window, func() { a.rollbackFirewall(pending) })
+	a.pendingFirewall = pending
+	log.Printf("Firewall applied; awaiting heartbeat confirmation within %s", window)
+}
+
+// securityState returns the firewall manager in use and the security mode
+// applied by the last sync.
+func (a *Agent) securityState() (firewallManager, string) {
+	a.securityMu.RLock()
+	defer a.securityMu.RUnlock()
+	return a.fwMgr, a.currentMode
+}
+
+// refreshEgress re-resolves the always-allowed egress destinations so that
+// rotating control plane or registry addresses stay reachable. Callers hold
+// firewallMu.
+func (a *Agent) refreshEgress() {
+	if a.fwMgr == nil || time.Since(a.lastEgressRefresh) < egressRefreshInterval {
+		return
+	}
+	a.lastEgressRefresh = time.Now()
+	if err := a.fwMgr.RefreshEgress(); err != nil {
+		log.Printf("Failed to refresh firewall egress rules: %v", err)
+	}
+}
+
+// confirmFirewall marks a pending firewall change as safe once a heartbeat
+// that started after it was applied succeeds.
+func (a *Agent) confirmFirewall(heartbeatStart time.Time) {
+	a.firewallMu.Lock()
+	defer a.firewallMu.Unlock()
+	pending := a.pendingFirewall
+	if pending == nil || heartbeatStart.Before(pending.appliedAt) {
+		return
+	}
+	pending.timer.Stop()
+	a.pendingFirewall = nil
+	log.Printf("Firewall change confirmed by heartbeat")
+}
+
+func (a *Agent) rollbackFirewall(pending *pendingFirewall) {
+	a.firewallMu.Lock()
+	defer a.firewallMu.Unlock()
+	if a.pendingFirewall != pending {
+		return
+	}
+	a.pendingFirewall = nil
+
+	log.Printf("No heartbeat confirmed the firewall change; rolling back: settings=%s", pending.key)
+	if err := pending.fw.Rollback(); err != nil {
+		log.Printf("Firewall rollback failed: %v", err)
+	}
+	a.currentFirewall = pending.previousKey
+	a.rejectedFirewall = pending.key
+	if err := a.state.RecordEvent("", "firewall_rollback", "settings="+pending.key); err != nil {
+		a.logVerbosef("Failed to record firewall rollback event: %v", err)
+	}
+}
+
+// sshRestrictions returns the SSH port and allowed CIDR, preferring values
+// from the desired state over the local config.
+func (a *Agent) sshRestrictions(desired *api.DesiredState) (int, string) {
+	port, cidr := a.config.SSHPort, a.config.SSHAllowedCIDR
+	if desired.SSHPort > 0 {
+		port = desired.SSHPort
+	}
+	if desired.SSHAllowedCIDR != "" {
+		cidr = desired.SSHAllowedCIDR
+	}
+	return port, cidr
+}
+
 // updateFirewall updates firewall rules based on security mode
-func (a *Agent) updateFirewall(mode string, port int) error {
+func (a *Agent) updateFirewall(mode string, port, sshPort int, sshCIDR string, egress bool) error {
 	var securityMode firewall.SecurityMode
 	switch mode {
 	case "daemon-port":
@@ -624,7 +1537,17 @@ func (a *Agent) updateFirewall(mode string, port int) error {
 		securityMode = firewall.SecurityModeNone
 	}
 
-	a.fwMgr = firewall.NewManager(securityMode, port)
+	fw := firewall.NewManager(securityMode, port)
+	fw.SetSSHRestrictions(sshPort, sshCIDR)
+	if egress {
+		// Proxies are parsed like control plane URLs, so they stay reachable too.
+		upstreams := append(a.config.ControlPlanes(), a.config.HTTPProxy, a.config.HTTPSProxy)
+		fw.SetEgressAllowlist(firewall.EssentialEndpoints(upstreams, a.config.RegistryHosts, a.config.NTPServers))
+	}
+	a.securityMu.Lock()
+	a.fwMgr = a.newFirewall(fw)
+	a.securityMu.Unlock()
+	a.lastEgressRefresh = time.Now()
 
 	if securityMode == firewall.SecurityModeNone {
 		return nil
@@ -646,12 +1569,22 @@ func (a *Agent) sendHeartbeat() error {
 	start := time.Now()
 	log.Printf("Heartbeat started")
 
+	a.services.CheckImageDrift()
+
 	// Get all service statuses
 	processes, err := a.state.ListServiceProcesses()
 	if err != nil {
 		return fmt.Errorf("failed to list processes: %w", err)
 	}
 
+	heldServices := make(map[string]bool)
+	if holds, err := a.state.ListServiceHolds(); err == nil {
+		for _, hold := range holds {
+			heldServices[hold.ServiceID] = true
+		}
+	}
+
+	health := a.services.CheckAllServicesHealth(service.HealthCheckDeadline)
 	statusByService := make(map[string]api.ServiceStatus)
 	for _, proc := range processes {
 		// Check if actually running
@@ -662,17 +1595,14 @@ func (a *Agent) sendHeartbeat() error {
 			}
 		}
 
-		// Get health check result
-		// Note: CheckServiceHealth not yet implemented
-		// healthResult, err := a.services.CheckServiceHealth(proc.ServiceID)
 		healthStatus := "unknown"
-		// if err == nil && healthResult != nil {
-		// 	if healthResult.Healthy {
-		// 		healthStatus = "healthy"
-		// 	} else {
-		// 		healthStatus = "unhealthy"
-		// 	}
-		// }
+		if result, ok := health[proc.ServiceID]; ok {
+			if result.Healthy {
+				healthStatus = "healthy"
+			} else {
+				healthStatus = "unhealthy"
+			}
+		}
 
 		statusByService[proc.ServiceID] = api.ServiceStatus{
 			ServiceID:    proc.ServiceID,
@@ -682,9 +1612,14 @@ func (a *Agent) sendHeartbeat() error {
 			RestartCount: proc.RestartCount,
 			LastError:    proc.LastError,
 			HealthStatus: healthStatus,
+			Held:         heldServices[proc.ServiceID],
+			Uptime:       a.uptimeSummary(proc.ServiceID),
+			ImageDrift:   a.services.ImageDrift(proc.ServiceID),
+			Restarts:     a.restartSummary(proc.ServiceID),
 		}
 	}
 
+	a.lifecycleSent()
 	a.lifecycleMu.RLock()
 	for serviceID, lifecycleStatus := range a.lifecycle {
 		if existing, ok := statusByService[serviceID]; !ok || shouldPreferLifecycleStatus(existing.Status, lifecycleStatus.Status) {
@@ -712,41 +1647,93 @@ func (a *Agent) sendHeartbeat() error {
 	}
 
 	// Get firewall status
+	fw, mode := a.securityState()
 	var fwStatus map[string]interface{}
-	if a.fwMgr != nil {
-		fwStatus, _ = a.fwMgr.GetStatus()
+	if fw != nil {
+		fwStatus, _ = fw.GetStatus()
 	}
 
+	buildInfo := agentInfo()
+	buildInfo.Capabilities = a.hostCapabilities(fw)
 	req := api.HeartbeatRequest{
 		StackVersion:   stackVersion,
 		AgentStatus:    "healthy",
 		ServicesStatus: servicesStatus,
 		SecurityState: map[string]interface{}{
-			"mode":              a.currentMode,
-			"external_exposure": a.getExternalExposure(),
+			"mode":              mode,
+			"external_exposure": externalExposure(mode),
 			"firewall_status":   fwStatus,
 		},
 		SystemInfo: map[string]interface{}{
-			"hostname": getHostname(),
+			"hostname":   getHostname(),
+			"log_levels": logging.Snapshot(),
 		},
-	}
-
-	if err := a.api.SendHeartbeat(req); err != nil {
+		SyntheticChecks: a.synthetics.Results(),
+		Certificates:    a.alerts.Certificates(),
+		Tasks:           a.services.TaskResults(),
+		PendingActions:  a.pendingActions(),
+		Agent:           &buildInfo,
+		FeatureFlags:    a.activeFeatureFlags(),
+		Apply:           a.applyReport(),
+		SLOs:            a.sloStatuses(),
+		Stacks:          a.stackStatuses(),
+	}
+	if a.system != nil {
+		system := a.system.Snapshot()
+		req.System = &system
+	}
+	req.Drift = a.driftReport()
+	req.SentAt = start.UTC()
+	req.LifecycleEvents = a.takeLifecycleEvents()
+
+	resp, err := a.api.SendHeartbeat(a.runCtx, req)
+	a.writeStatusFile(req, err)
+	if err != nil {
+		if api.Rejected(err) {
+			log.Printf("Dropping heartbeat rejected by the control plane: %v", err)
+		} else {
+			a.queueHeartbeat(req)
+		}
 		return err
 	}
+	a.applyRemoteLogLevels(resp)
+	a.checkAPIKeyScopes(resp.Scopes)
+	a.confirmFirewall(start)
+	// Replay heartbeats and retry build logs and deploy reports that failed
+	// while the control plane was unreachable.
+	go a.replayHeartbeats()
+	go a.uploadBuildLogs()
+	go a.retryDeployReports()
 	log.Printf("Heartbeat sent: stack_version=%d services=%d elapsed=%s", stackVersion, len(servicesStatus), time.Since(start))
 	return nil
 }
 
 func (a *Agent) logVerbosef(format string, args ...interface{}) {
-	if a.config != nil && a.config.VerboseLogging {
+	if logging.DebugEnabled("agent") {
 		log.Printf(format, args...)
 	}
 }
 
+// applyRemoteLogLevels applies log verbosity requested by the control plane in
+// a heartbeat response.
+func (a *Agent) applyRemoteLogLevels(resp *api.HeartbeatResponse) {
+	var until time.Time
+	if resp.LogLevelUntil != nil {
+		until = *resp.LogLevelUntil
+	}
+	changed, err := logging.SetRemoteLevels(resp.LogLevel, resp.LogLevels, until)
+	if err != nil {
+		log.Printf("Ignoring remote log level: %v", err)
+		return
+	}
+	if changed {
+		log.Printf("Log levels updated: %v", logging.Snapshot())
+	}
+}
+
 func shouldPreferLifecycleStatus(processStatus, lifecycleStatus string) bool {
 	switch strings.TrimSpace(lifecycleStatus) {
-	case "building", "deploying", "health_check", "error", "crashed", "stopped":
+	case "building", "initializing", "deploying", "health_check", "canary", "error", "deploy_timeout", "dependency_unavailable", "crashed", "stopped":
 		return true
 	case "running":
 		return strings.TrimSpace(processStatus) != "running"
@@ -764,35 +1751,60 @@ func (a *Agent) clearTransientLifecycleStatus(serviceID string) {
 		return
 	}
 	switch strings.TrimSpace(current.Status) {
-	case "building", "deploying", "health_check":
+	case "building", "deploying", "health_check", "canary":
 		delete(a.lifecycle, serviceID)
 	}
 }
 
 func (a *Agent) onServiceLifecycleEvent(service api.Service, status, healthStatus, lastError string) {
-	a.lifecycleMu.Lock()
-	a.lifecycle[service.ID] = api.ServiceStatus{
-		ServiceID:    service.ID,
-		Name:         service.Name,
-		Status:       status,
-		RestartCount: 0,
-		LastError:    lastError,
-		HealthStatus: healthStatus,
+	event := api.LifecycleEvent{
+		ServiceStatus: api.ServiceStatus{
+			ServiceID:    service.ID,
+			Name:         service.Name,
+			Status:       status,
+			RestartCount: 0,
+			LastError:    lastError,
+			HealthStatus: healthStatus,
+		},
+		At: time.Now().UTC(),
 	}
+	a.lifecycleMu.Lock()
+	a.lifecycle[service.ID] = event.ServiceStatus
 	a.lifecycleMu.Unlock()
 
 	log.Printf("Lifecycle update: service=%s name=%s status=%s health=%s", service.ID, service.Name, status, healthStatus)
+	eventMessage := fmt.Sprintf("status=%s health=%s", status, healthStatus)
+	if lastError != "" {
+		eventMessage += " error=" + lastError
+	}
+	if err := a.state.RecordEvent(service.ID, "lifecycle", eventMessage); err != nil {
+		a.logVerbosef("Failed to record lifecycle event: service=%s err=%v", service.ID, err)
+	}
 
 	go func() {
-		if err := a.sendHeartbeat(); err != nil {
-			log.Printf("Lifecycle heartbeat failed: service=%s status=%s err=%v", service.ID, status, err)
+		if a.streamLifecycleEvent(event) {
+			return
+		}
+		a.recordLifecycleEvent(event)
+		a.queueLifecycleHeartbeat()
+	}()
+}
+
+func (a *Agent) onDeployDiagnostics(bundle api.DeployDiagnostics) {
+	if !a.config.UploadDiagnostics {
+		return
+	}
+
+	go func() {
+		if err := a.api.UploadDiagnostics(bundle); err != nil {
+			log.Printf("Diagnostics upload failed: service=%s err=%v", bundle.ServiceID, err)
 		} else {
-			log.Printf("Lifecycle heartbeat sent: service=%s status=%s", service.ID, status)
+			log.Printf("Diagnostics uploaded: service=%s", bundle.ServiceID)
 		}
 	}()
 }
 
-func deployReason(stateChanged bool, serviceFound bool, proc *state.ServiceProcess, resolvedCommit string) string {
+func deployReason(definitionChanged, imageDrifted bool, serviceFound bool, proc *state.ServiceProcess, resolvedCommit string) string {
 	if !serviceFound {
 		return "not_tracked_in_memory"
 	}
@@ -805,25 +1817,24 @@ func deployReason(stateChanged bool, serviceFound bool, proc *state.ServiceProce
 	if proc.Status != "running" {
 		return "persisted_status_not_running"
 	}
-	if stateChanged {
-		return "state_changed"
+	if definitionChanged {
+		return "definition_changed"
+	}
+	if imageDrifted {
+		return "image_drift"
 	}
 	return "unknown"
 }
 
-func isDockerServiceType(serviceType string) bool {
-	return strings.EqualFold(strings.TrimSpace(serviceType), "docker")
-}
-
 func serviceRevisionSignature(svc api.Service) string {
-	if isDockerServiceType(svc.ServiceType) {
+	if service.UsesPrebuiltImage(svc) {
 		return fmt.Sprintf("docker:%s|args:%s|cmd:%s", strings.TrimSpace(svc.DockerImage), strings.TrimSpace(svc.DockerRunArgs), strings.TrimSpace(svc.RunCommand))
 	}
 	return svc.GitCommit
 }
 
-func (a *Agent) getExternalExposure() string {
-	switch a.currentMode {
+func externalExposure(mode string) string {
+	switch mode {
 	case "blocked":
 		return "none"
 	case "daemon-port":
@@ -833,6 +1844,19 @@ func (a *Agent) getExternalExposure() string {
 	}
 }
 
+// serviceDefinitionHash fingerprints a service definition as received from the
+// control plane, so that a stack change only touches the services it edited.
+func serviceDefinitionHash(svc api.Service) string {
+	// Placing or lifting a hold or protection, or changing how releases
+	// are canaried, must not itself cause a redeploy.
+	svc.Hold = false
+	svc.Protected = false
+	svc.Canary = nil
+	data, _ := json.Marshal(svc)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
 func getHostname() string {
 	hostname, _ := os.Hostname()
 	return hostname
@@ -861,6 +1885,13 @@ func printServiceStatus(configPath string) error {
 		return nil
 	}
 
+	held := make(map[string]bool)
+	if holds, err := stateMgr.ListServiceHolds(); err == nil {
+		for _, hold := range holds {
+			held[hold.ServiceID] = true
+		}
+	}
+
 	fmt.Printf("%-20s %-12s %-8s %-15s %-20s\n", "SERVICE", "STATUS", "PID", "RUNTIME", "COMMIT")
 	fmt.Println("-------------------------------------------------------------------------------------------")
 
@@ -873,14 +1904,102 @@ func printServiceStatus(configPath string) error {
 		if len(commit) > 8 {
 			commit = commit[:8]
 		}
+		status := proc.Status
+		if held[proc.ServiceID] {
+			status += " (held)"
+		}
 		fmt.Printf("%-20s %-12s %-8s %-15s %-20s\n",
 			truncate(proc.ServiceName, 20),
-			proc.Status,
+			status,
 			pid,
 			proc.Runtime,
 			commit)
 	}
 
+	pending, err := stateMgr.ListPendingActions()
+	if err != nil {
+		return fmt.Errorf("failed to list pending actions: %w", err)
+	}
+	if len(pending) > 0 {
+		fmt.Println()
+		fmt.Printf("%-20s %-10s %-24s %-9s %-20s %s\n", "PENDING", "ACTION", "REASON", "ATTEMPTS", "NEXT ATTEMPT", "LAST ERROR")
+		for _, p := range pending {
+			next := "next sync"
+			if p.Reason == "held" {
+				next = "on release"
+			} else if p.Reason == "awaiting_confirmation" {
+				next = "on confirm"
+			} else if p.NextAttemptAt.After(time.Now()) {
+				next = p.NextAttemptAt.Local().Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%-20s %-10s %-24s %-9d %-20s %s\n",
+				truncate(p.ServiceID, 20),
+				p.Action,
+				truncate(p.Reason, 24),
+				p.Attempts,
+				next,
+				truncate(strings.Join(strings.Fields(p.LastError), " "), 60))
+		}
+	}
+
+	return nil
+}
+
+// printServiceUptime prints rolling uptime for every service with recorded
+// availability.
+func printServiceUptime(configPath string) error {
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	stateMgr, err := state.NewManager(cfg.StateDBPath())
+	if err != nil {
+		return fmt.Errorf("failed to initialize state: %w", err)
+	}
+	defer stateMgr.Close()
+
+	serviceIDs, err := stateMgr.ListAvailabilityServices()
+	if err != nil {
+		return err
+	}
+	if len(serviceIDs) == 0 {
+		fmt.Println("No availability data recorded")
+		return nil
+	}
+
+	names := map[string]string{}
+	if processes, err := stateMgr.ListServiceProcesses(); err == nil {
+		for _, proc := range processes {
+			names[proc.ServiceID] = proc.ServiceName
+		}
+	}
+
+	fmt.Printf("%-20s %-10s %-10s %-10s\n", "SERVICE", "24H", "7D", "30D")
+	fmt.Println("----------------------------------------------------")
+
+	now := time.Now()
+	for _, serviceID := range serviceIDs {
+		uptimes, err := metrics.ServiceUptimes(stateMgr, serviceID, now)
+		if err != nil {
+			return err
+		}
+		name := names[serviceID]
+		if name == "" {
+			name = serviceID
+		}
+		columns := make([]interface{}, 0, len(uptimes)+1)
+		columns = append(columns, truncate(name, 20))
+		for _, uptime := range uptimes {
+			if uptime.Observed == 0 {
+				columns = append(columns, "-")
+			} else {
+				columns = append(columns, fmt.Sprintf("%.2f%%", uptime.Percent))
+			}
+		}
+		fmt.Printf("%-20s %-10s %-10s %-10s\n", columns...)
+	}
+
 	return nil
 }
 
@@ -995,8 +2114,44 @@ func handleDeleteSecret(configPath, serviceID, name string) error {
 	return nil
 }
 
+// handleShowAgentLogs shows the agent's own log file
+func handleShowAgentLogs(configPath string, follow bool) error {
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	path := cfg.AgentLogPath()
+	lines, err := logging.TailLines(path, 100)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("no agent log file at %s (set agent_log_file: true in config)", path)
+		}
+		return fmt.Errorf("failed to read agent log: %w", err)
+	}
+	for _, line := range lines {
+		fmt.Println(line)
+	}
+
+	if follow {
+		return logging.Follow(path, os.Stdout)
+	}
+	return nil
+}
+
+// shortContainerID trims a container ID to the 12 characters docker prints.
+func shortContainerID(id string) string {
+	if len(id) > 12 {
+		return id[:12]
+	}
+	if id == "" {
+		return "-"
+	}
+	return id
+}
+
 // handleShowLogs shows logs for a service
-func handleShowLogs(configPath, serviceID string, follow bool) error {
+func handleShowLogs(configPath, serviceID string, follow, listDeploys bool, filter state.LogFilter) error {
 	cfg, err := config.Load(configPath)
 	if err != nil {
 		return fmt.Errorf("failed to load config: %w", err)
@@ -1028,9 +2183,47 @@ func handleShowLogs(configPath, serviceID string, follow bool) error {
 		return nil
 	}
 
+	if listDeploys {
+		deploys, err := stateMgr.ListLogDeploys(serviceID)
+		if err != nil {
+			return err
+		}
+		if len(deploys) == 0 {
+			fmt.Printf("No deploys with logs found for service '%s'\n", serviceID)
+			return nil
+		}
+		fmt.Printf("%-28s %-8s %-20s %s\n", "DEPLOY", "LINES", "FIRST", "LAST")
+		for _, d := range deploys {
+			fmt.Printf("%-28s %-8d %-20s %s\n", d.DeployID, d.Lines, d.FirstSeen.Format("2006-01-02 15:04:05"), d.LastSeen.Format("2006-01-02 15:04:05"))
+		}
+		return nil
+	}
+
+	if filter.DeployID != "" {
+		deployID, err := stateMgr.ResolveDeployRef(serviceID, filter.DeployID)
+		if err != nil {
+			return err
+		}
+		filter.DeployID = deployID
+	}
+	filtered := filter != state.LogFilter{}
+	if follow && filtered {
+		return fmt.Errorf("-f cannot be combined with -deploy or -container")
+	}
+
 	// Show logs for specific service
 	if follow {
 		fmt.Printf("Following logs for service '%s' (Ctrl+C to exit)...\n", serviceID)
+
+		// Prefer streaming from the running agent; fall back to polling the
+		// database directly when the agent isn't running.
+		client := admin.NewSocketClient(cfg.AdminSocketPath())
+		if err := client.Ping(); err == nil {
+			return client.StreamLogs(context.Background(), serviceID, 0, func(entry admin.LogEntry) {
+				fmt.Printf("[%s] %s: %s\n", entry.CreatedAt.Format("2006-01-02 15:04:05"), entry.Level, entry.Message)
+			})
+		}
+
 		var lastID int64 = 0
 		for {
 			logs, err := stateMgr.StreamLogs(serviceID, lastID)
@@ -1043,7 +2236,24 @@ func handleShowLogs(configPath, serviceID string, follow bool) error {
 				lastID = log.ID
 			}
 
-			time.Sleep(1 * time.Second)
+			if len(logs) < state.StreamLogsPageSize {
+				time.Sleep(1 * time.Second)
+			}
+		}
+	} else if filtered {
+		logs, err := stateMgr.QueryServiceLogs(serviceID, filter, 100)
+		if err != nil {
+			return err
+		}
+
+		if len(logs) == 0 {
+			fmt.Printf("No logs found for service '%s' matching the filter\n", serviceID)
+			return nil
+		}
+
+		for i := len(logs) - 1; i >= 0; i-- {
+			log := logs[i]
+			fmt.Printf("[%s] %s %s %s: %s\n", log.CreatedAt.Format("2006-01-02 15:04:05"), log.DeployID, shortContainerID(log.ContainerID), log.Level, log.Message)
 		}
 	} else {
 		logs, err := stateMgr.GetServiceLogs(serviceID, 100)
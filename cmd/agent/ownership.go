@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/buildvigil/agent/internal/config"
+	"github.com/buildvigil/agent/internal/platform"
+)
+
+// managedPath is a file or directory the agent creates, with the most
+// permissive mode it may have.
+type managedPath struct {
+	path    string
+	mode    os.FileMode
+	private bool // Files under it must not be readable by group or others
+}
+
+// managedPaths lists the agent's own files and directories. The data
+// directory stays traversable so tools can read status.json.
+func managedPaths(cfg *config.Config) []managedPath {
+	return []managedPath{
+		{path: cfg.DataDir, mode: 0755},
+		{path: cfg.StateDBPath(), mode: 0600},
+		{path: cfg.ReposPath(), mode: 0750},
+		{path: cfg.SSHKeyDir(), mode: 0700, private: true},
+		{path: cfg.SecretsPath(), mode: 0700, private: true},
+		{path: cfg.DiagnosticsPath(), mode: 0700, private: true},
+		{path: filepath.Dir(cfg.AgentLogPath()), mode: 0750},
+	}
+}
+
+// serviceIdentity resolves service_user and service_group, or returns nil
+// when no service user is configured.
+func serviceIdentity(cfg *config.Config) (*platform.Identity, error) {
+	if cfg.ServiceUser == "" {
+		if cfg.ServiceGroup != "" {
+			return nil, fmt.Errorf("service_group requires service_user")
+		}
+		return nil, nil
+	}
+	id, err := platform.LookupIdentity(cfg.ServiceUser, cfg.ServiceGroup)
+	if err != nil {
+		return nil, fmt.Errorf("invalid service_user: %w", err)
+	}
+	return id, nil
+}
+
+// applyFilePermissions narrows each managed path to its mode, strips group
+// and other access from files in private directories, and, given an
+// identity, gives each path and its contents to it. Modes are never widened.
+func applyFilePermissions(cfg *config.Config, id *platform.Identity) error {
+	var chowned []string
+	for _, managed := range managedPaths(cfg) {
+		info, err := os.Lstat(managed.path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if perm := info.Mode().Perm(); perm&^managed.mode != 0 {
+			if err := os.Chmod(managed.path, perm&managed.mode); err != nil {
+				return fmt.Errorf("failed to restrict %s: %w", managed.path, err)
+			}
+		}
+		if managed.private && info.IsDir() {
+			err := filepath.WalkDir(managed.path, func(path string, entry fs.DirEntry, err error) error {
+				if err != nil || entry.IsDir() || entry.Type()&fs.ModeSymlink != 0 {
+					return err
+				}
+				info, err := entry.Info()
+				if err != nil {
+					return err
+				}
+				if perm := info.Mode().Perm(); perm&0077 != 0 {
+					return os.Chmod(path, perm&^0077)
+				}
+				return nil
+			})
+			if err != nil {
+				return fmt.Errorf("failed to restrict %s: %w", managed.path, err)
+			}
+		}
+		if id == nil || withinAny(managed.path, chowned) {
+			continue
+		}
+		if err := id.Chown(managed.path); err != nil {
+			return err
+		}
+		chowned = append(chowned, managed.path)
+	}
+	return nil
+}
+
+// checkFilePermissions describes each managed path that is more permissive
+// than its mode, holds group- or world-readable private files, or, given an
+// identity, has anything not owned by it.
+func checkFilePermissions(cfg *config.Config, id *platform.Identity) []string {
+	var problems []string
+	for _, managed := range managedPaths(cfg) {
+		info, err := os.Lstat(managed.path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+		if perm := info.Mode().Perm(); perm&^managed.mode != 0 {
+			problems = append(problems, fmt.Sprintf("%s has mode %04o; expected at most %04o", managed.path, perm, managed.mode))
+		}
+		var loose, foreign string
+		filepath.WalkDir(managed.path, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			info, err := entry.Info()
+			if err != nil {
+				return nil
+			}
+			if managed.private && loose == "" && !entry.IsDir() && entry.Type()&fs.ModeSymlink == 0 && info.Mode().Perm()&0077 != 0 {
+				loose = path
+			}
+			if id != nil && foreign == "" {
+				if uid, gid, ok := platform.FileOwner(info); ok && (uid != id.UID || gid != id.GID) {
+					foreign = path
+				}
+			}
+			if (loose != "" || !managed.private) && (foreign != "" || id == nil) {
+				return filepath.SkipAll
+			}
+			return nil
+		})
+		if loose != "" {
+			problems = append(problems, fmt.Sprintf("%s is readable by group or others", loose))
+		}
+		if foreign != "" {
+			problems = append(problems, fmt.Sprintf("%s is not owned by %s", foreign, id.User))
+		}
+	}
+	return problems
+}
+
+// withinAny reports whether path is one of roots or below one.
+func withinAny(path string, roots []string) bool {
+	for _, root := range roots {
+		if path == root || strings.HasPrefix(path, root+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
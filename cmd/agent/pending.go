@@ -0,0 +1,108 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/buildvigil/agent/internal/api"
+	"github.com/buildvigil/agent/internal/state"
+)
+
+// maxRetryBackoff caps how long a repeatedly failing action waits between
+// attempts.
+const maxRetryBackoff = time.Hour
+
+// retryBackoff returns how long to wait after the given number of
+// consecutive failures: the next sync after the first failure, then one,
+// three, seven... skipped syncs, up to maxRetryBackoff.
+func retryBackoff(pollInterval time.Duration, failures int) time.Duration {
+	if failures <= 1 {
+		return 0
+	}
+	delay := pollInterval
+	for i := 2; i < failures && delay < maxRetryBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxRetryBackoff {
+		delay = maxRetryBackoff
+	}
+	return delay
+}
+
+// retryWait returns how much longer a failing action for this revision should
+// back off, or zero when it is due.
+func (a *Agent) retryWait(serviceID, action, revision string) time.Duration {
+	if !a.featureEnabled("deploy_backoff") {
+		return 0
+	}
+	pending, _ := a.state.GetPendingAction(serviceID)
+	if pending == nil || pending.Action != action || pending.Revision != revision || pending.Attempts == 0 {
+		return 0
+	}
+	// Syncs run every poll interval, so an attempt due within half of one is
+	// taken now rather than skipped.
+	wait := time.Until(pending.NextAttemptAt)
+	if wait <= time.Duration(a.config.PollInterval)*time.Second/2 {
+		return 0
+	}
+	return wait
+}
+
+// markPending records that the agent is about to attempt an action for a
+// service. Failures of the same action and revision so far are kept.
+func (a *Agent) markPending(serviceID, action, reason, revision string) {
+	pending := a.pendingFor(serviceID, action, revision)
+	pending.Reason = reason
+	pending.NextAttemptAt = time.Now()
+	if err := a.state.SavePendingAction(pending); err != nil {
+		log.Printf("Failed to record pending action for service %s: %v", serviceID, err)
+	}
+}
+
+// markPendingFailed records a failed attempt and when to retry it.
+func (a *Agent) markPendingFailed(serviceID, action, reason, revision string, cause error) {
+	pending := a.pendingFor(serviceID, action, revision)
+	pending.Reason = reason
+	pending.Attempts++
+	pending.LastError = cause.Error()
+	pending.NextAttemptAt = time.Now().Add(retryBackoff(time.Duration(a.config.PollInterval)*time.Second, pending.Attempts))
+	if err := a.state.SavePendingAction(pending); err != nil {
+		log.Printf("Failed to record pending action for service %s: %v", serviceID, err)
+	}
+}
+
+// clearPending drops a service's pending action once nothing is left to do.
+func (a *Agent) clearPending(serviceID string) {
+	if err := a.state.ClearPendingAction(serviceID); err != nil {
+		log.Printf("Failed to clear pending action for service %s: %v", serviceID, err)
+	}
+}
+
+func (a *Agent) pendingFor(serviceID, action, revision string) state.PendingAction {
+	if existing, _ := a.state.GetPendingAction(serviceID); existing != nil && existing.Action == action && existing.Revision == revision {
+		return *existing
+	}
+	return state.PendingAction{ServiceID: serviceID, Action: action, Revision: revision}
+}
+
+// pendingActions lists pending actions for the heartbeat.
+func (a *Agent) pendingActions() []api.PendingAction {
+	actions, err := a.state.ListPendingActions()
+	if err != nil {
+		log.Printf("Failed to list pending actions: %v", err)
+		return nil
+	}
+	result := make([]api.PendingAction, 0, len(actions))
+	for _, p := range actions {
+		result = append(result, api.PendingAction{
+			ServiceID:     p.ServiceID,
+			Action:        p.Action,
+			Reason:        p.Reason,
+			Revision:      p.Revision,
+			Attempts:      p.Attempts,
+			LastError:     p.LastError,
+			NextAttemptAt: p.NextAttemptAt,
+		})
+	}
+	return result
+}
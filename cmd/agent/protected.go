@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/buildvigil/agent/internal/state"
+)
+
+// errNothingToConfirm is returned when a service has no change awaiting
+// confirmation.
+var errNothingToConfirm = errors.New("no change is awaiting confirmation")
+
+// deployConfirmed reports whether an operator has confirmed this revision of
+// a protected service.
+func (a *Agent) deployConfirmed(serviceID, revision string) bool {
+	confirmation, err := a.state.GetServiceConfirmation(serviceID)
+	if err != nil {
+		log.Printf("Failed to read confirmation for service %s: %v", serviceID, err)
+		return false
+	}
+	return confirmation != nil && confirmation.Revision == revision
+}
+
+// confirmPendingChange lets the change a protected service is waiting on be
+// applied by the next sync. A non-empty revision must match the waiting one,
+// so a confirmation can't carry over to a change made after it was reviewed.
+// It returns the confirmed revision.
+func confirmPendingChange(stateMgr *state.Manager, serviceID, revision string) (string, error) {
+	pending, err := stateMgr.GetPendingAction(serviceID)
+	if err != nil {
+		return "", err
+	}
+	if pending == nil || pending.Reason != "awaiting_confirmation" {
+		return "", errNothingToConfirm
+	}
+	if revision != "" && revision != pending.Revision {
+		return "", fmt.Errorf("revision %s is not the one awaiting confirmation (%s)", revision, pending.Revision)
+	}
+	if err := stateMgr.ConfirmServiceRevision(serviceID, pending.Revision); err != nil {
+		return "", err
+	}
+	_ = stateMgr.RecordEvent(serviceID, "deploy_confirmed", pending.Revision)
+	return pending.Revision, nil
+}
+
+// handleConfirmService confirms the change a protected service is waiting
+// on.
+func handleConfirmService(configPath, serviceID string) error {
+	if serviceID == "" {
+		return fmt.Errorf("service ID is required (use -service flag)")
+	}
+	stateMgr, err := openState(configPath)
+	if err != nil {
+		return err
+	}
+	defer stateMgr.Close()
+
+	_, err = confirmPendingChange(stateMgr, serviceID, "")
+	if errors.Is(err, errNothingToConfirm) {
+		fmt.Printf("Service '%s' has no change awaiting confirmation\n", serviceID)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("✓ Change to service '%s' confirmed; the next sync deploys it\n", serviceID)
+	return nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/buildvigil/agent/internal/api"
+)
+
+const (
+	// pushSafetyPollInterval is how often the agent still polls while the
+	// desired state stream is connected, in case an event is lost.
+	pushSafetyPollInterval = 5 * time.Minute
+	// pushMaxReconnectDelay caps the wait between stream reconnects.
+	pushMaxReconnectDelay = time.Minute
+	// pushUnsupportedRetry is how long to wait before retrying a control
+	// plane without a stream endpoint.
+	pushUnsupportedRetry = 10 * time.Minute
+)
+
+// requestSync asks the run loop to sync as soon as it is free. Requests made
+// while one is already queued are merged.
+func (a *Agent) requestSync() {
+	select {
+	case a.syncNow <- struct{}{}:
+	default:
+	}
+}
+
+// updatePushSync opens or closes the desired state streams to follow the
+// push_sync feature flag. It is called from the run loop only.
+func (a *Agent) updatePushSync() {
+	enabled := a.featureEnabled("push_sync")
+	if enabled == (a.pushCancel != nil) {
+		return
+	}
+	if !enabled {
+		a.pushCancel()
+		a.pushCancel = nil
+		log.Printf("Desired state push turned off by feature flag; polling every %ds", a.config.PollInterval)
+		return
+	}
+	ctx, cancel := context.WithCancel(a.runCtx)
+	a.pushCancel = cancel
+	go a.watchDesiredState(ctx)
+}
+
+// watchDesiredState keeps a desired state stream open for every stack while
+// the agent runs, requesting a sync whenever a new state is published. Until
+// every stream is connected the run loop polls every poll_interval.
+func (a *Agent) watchDesiredState(ctx context.Context) {
+	stacks := a.config.Stacks()
+	var streams atomic.Int32
+	var wg sync.WaitGroup
+	for _, stackID := range stacks {
+		wg.Add(1)
+		go func(stackID string) {
+			defer wg.Done()
+			a.watchStack(ctx, stackID, func(connected bool) {
+				if connected {
+					a.pushConnected.Store(streams.Add(1) == int32(len(stacks)))
+				} else {
+					streams.Add(-1)
+					a.pushConnected.Store(false)
+				}
+			})
+		}(stackID)
+	}
+	wg.Wait()
+}
+
+// watchStack keeps the desired state stream of one stack open until ctx is
+// done, reporting each connect and disconnect to setConnected.
+func (a *Agent) watchStack(ctx context.Context, stackID string, setConnected func(bool)) {
+	delay := time.Second
+	for {
+		connected := false
+		err := a.api.WatchDesiredState(ctx, stackID, func() {
+			connected = true
+			setConnected(true)
+			delay = time.Second
+			log.Printf("Desired state stream connected: stack=%s; polling every %s", stackID, pushSafetyPollInterval)
+			// Catch up on anything published while disconnected.
+			a.requestSync()
+		}, func(event api.StateEvent) {
+			if event.Commands {
+				log.Printf("Commands queued by control plane")
+			} else {
+				log.Printf("Desired state published: version=%d hash=%s", event.Version, event.Hash)
+			}
+			a.requestSync()
+		})
+		if connected {
+			setConnected(false)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		wait := delay
+		if errors.Is(err, api.ErrStreamUnsupported) {
+			wait = pushUnsupportedRetry
+			log.Printf("Desired state stream not supported by control plane; polling, retry_in=%s", wait)
+		} else {
+			if connected {
+				log.Printf("Desired state stream dropped, falling back to polling: %v", err)
+			} else {
+				log.Printf("Desired state stream unavailable: %v (retry_in=%s)", err, wait)
+			}
+			delay *= 2
+			if delay > pushMaxReconnectDelay {
+				delay = pushMaxReconnectDelay
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
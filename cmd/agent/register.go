@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/buildvigil/agent/internal/api"
+	"github.com/buildvigil/agent/internal/config"
+	"github.com/buildvigil/agent/internal/firewall"
+)
+
+// handleRegister exchanges an install token for agent credentials and saves
+// them to the config file, creating it with defaults if it does not exist.
+// The control plane URL and Access credentials may be given as flags.
+func handleRegister(configPath, installToken string, controlPlane, accessClientID, accessClientSecret optionalString) error {
+	installToken = strings.TrimSpace(installToken)
+	if installToken == "" {
+		return fmt.Errorf("install token is required (use -install-token flag)")
+	}
+
+	cfg, err := config.Load(configPath)
+	if errors.Is(err, os.ErrNotExist) {
+		cfg = config.DefaultConfig()
+	} else if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	if controlPlane.set {
+		cfg.ControlPlane = strings.TrimSpace(controlPlane.value)
+	}
+	if accessClientID.set {
+		cfg.AccessClientID = strings.TrimSpace(accessClientID.value)
+	}
+	if accessClientSecret.set {
+		cfg.AccessClientSecret = strings.TrimSpace(accessClientSecret.value)
+	}
+	if err := cfg.ApplyProxyEnv(); err != nil {
+		return fmt.Errorf("failed to apply proxy settings: %w", err)
+	}
+
+	client := api.NewClient(cfg.ControlPlane, "", cfg.AccessClientID, cfg.AccessClientSecret)
+	client.SetFallbackURLs(cfg.ControlPlaneFallbacks...)
+	if err := client.SetClientTLS(cfg.ControlPlaneClientCert, cfg.ControlPlaneClientKey, cfg.ControlPlaneCACert); err != nil {
+		return fmt.Errorf("invalid control plane TLS configuration: %w", err)
+	}
+	caBundle, err := cfg.CABundle()
+	if err != nil {
+		return fmt.Errorf("invalid ca_certs: %w", err)
+	}
+	if err := client.SetExtraCAs(caBundle); err != nil {
+		return fmt.Errorf("invalid ca_certs: %w", err)
+	}
+	info := agentInfo()
+	info.Capabilities = detectCapabilities(firewall.NewManager(firewall.SecurityMode(cfg.SecurityMode), cfg.ExternalProxyPort))
+	client.SetAgentInfo(info)
+	registration, err := client.Register(api.RegistrationRequest{
+		InstallToken: installToken,
+		Hostname:     getHostname(),
+		Agent:        &info,
+		Scopes:       agentScopes(cfg),
+	})
+	if err != nil {
+		return err
+	}
+
+	previousID := cfg.AgentID
+	cfg.AgentID = registration.AgentID
+	cfg.APIKey = registration.APIKey
+	cfg.StackID = registration.StackID
+	cfg.APIKeyScopes = registration.Scopes
+	if err := cfg.Save(configPath); err != nil {
+		return fmt.Errorf("failed to save config: %w", err)
+	}
+
+	fmt.Printf("✓ Agent registered with %s\n", cfg.ControlPlane)
+	fmt.Printf("- Agent ID: %s\n", cfg.AgentID)
+	fmt.Printf("- Stack ID: %s\n", cfg.StackID)
+	fmt.Printf("- Config:   %s\n", configPath)
+	if previousID != "" && previousID != cfg.AgentID {
+		fmt.Printf("Replaced previous agent ID %s\n", previousID)
+	}
+	for _, warning := range scopeWarnings(cfg, cfg.APIKeyScopes) {
+		fmt.Printf("Warning: %s\n", warning)
+	}
+	fmt.Println("Start the agent with -config " + configPath)
+	return nil
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/buildvigil/agent/internal/api"
+	"github.com/buildvigil/agent/internal/service"
+	"github.com/buildvigil/agent/internal/state"
+)
+
+// Pending action reasons for rollbacks. A requested rollback is carried out
+// by the next sync; a rolled-back revision is not deployed again until the
+// desired state changes.
+const (
+	rollbackRequested  = "requested"
+	revisionRolledBack = "rolled_back"
+)
+
+// autoRollback rolls a service back once deploys of revision have failed
+// auto_rollback_failures times in a row. It reports whether the service now
+// runs its earlier release.
+func (a *Agent) autoRollback(svc api.Service, revision string) bool {
+	limit := a.config.AutoRollbackFailures
+	if limit <= 0 {
+		return false
+	}
+	pending, _ := a.state.GetPendingAction(svc.ID)
+	if pending == nil || pending.Action != "deploy" || pending.Revision != revision || pending.Attempts < limit {
+		return false
+	}
+	log.Printf("Rolling back after repeated deploy failures: name=%s service=%s failures=%d", svc.Name, svc.ID, pending.Attempts)
+	return a.rollbackService(svc.ID, revision, "auto") == nil
+}
+
+// rollbackCurrentRelease rolls a service back from the release it runs now.
+func (a *Agent) rollbackCurrentRelease(serviceID, trigger string) error {
+	proc, err := a.state.GetServiceProcess(serviceID)
+	if err != nil {
+		return err
+	}
+	if proc == nil {
+		return fmt.Errorf("service %s is not deployed", serviceID)
+	}
+	return a.rollbackService(serviceID, proc.DefinitionHash+":"+proc.GitCommit, trigger)
+}
+
+// rollbackService rolls a service back to its previous release and records
+// revision as rolled back, so syncs leave the earlier release running until
+// the desired state changes.
+func (a *Agent) rollbackService(serviceID, revision, trigger string) error {
+	if err := a.services.RollbackService(serviceID); err != nil {
+		log.Printf("Rollback failed: service=%s trigger=%s err=%v", serviceID, trigger, err)
+		_ = a.state.RecordEvent(serviceID, "rollback_failed", fmt.Sprintf("trigger=%s error=%v", trigger, err))
+		return err
+	}
+	pending := a.pendingFor(serviceID, "deploy", revision)
+	pending.Reason = revisionRolledBack
+	if err := a.state.SavePendingAction(pending); err != nil {
+		log.Printf("Failed to record rollback for service %s: %v", serviceID, err)
+	}
+	log.Printf("Service rolled back: service=%s trigger=%s revision=%s", serviceID, trigger, revision)
+	_ = a.state.RecordEvent(serviceID, "rollback", fmt.Sprintf("trigger=%s revision=%s", trigger, revision))
+	return nil
+}
+
+// rolledBackRevision returns the revision of a service that was rolled
+// back, or "" if none was.
+func (a *Agent) rolledBackRevision(serviceID string) string {
+	pending, _ := a.state.GetPendingAction(serviceID)
+	if pending == nil || pending.Action != "deploy" || pending.Reason != revisionRolledBack {
+		return ""
+	}
+	return pending.Revision
+}
+
+// runRequestedRollbacks carries out rollbacks requested with -rollback.
+// Held services keep their request until released.
+func (a *Agent) runRequestedRollbacks(desired map[string]api.Service) {
+	pending, err := a.state.ListPendingActions()
+	if err != nil {
+		log.Printf("Failed to list pending actions: %v", err)
+		return
+	}
+	for _, p := range pending {
+		if p.Action != "rollback" || p.Reason != rollbackRequested {
+			continue
+		}
+		svc, ok := desired[p.ServiceID]
+		if !ok {
+			continue
+		}
+		if a.serviceHeld(svc) {
+			log.Printf("Service on hold, rollback waits: name=%s service=%s", svc.Name, svc.ID)
+			continue
+		}
+		if err := a.rollbackCurrentRelease(svc.ID, "requested"); err != nil {
+			a.clearPending(svc.ID)
+		}
+	}
+}
+
+// requestRollback asks the running agent to roll a service back on its
+// next sync, and returns the release it will roll back to.
+func requestRollback(stateMgr *state.Manager, serviceID string) (*state.RollbackTarget, error) {
+	target, err := stateMgr.GetRollbackTarget(serviceID)
+	if err != nil {
+		return nil, err
+	}
+	if target == nil {
+		return nil, fmt.Errorf("%w for service %s", service.ErrNoRollbackTarget, serviceID)
+	}
+	if err := stateMgr.SavePendingAction(state.PendingAction{
+		ServiceID:     serviceID,
+		Action:        "rollback",
+		Reason:        rollbackRequested,
+		NextAttemptAt: time.Now(),
+	}); err != nil {
+		return nil, err
+	}
+	_ = stateMgr.RecordEvent(serviceID, "rollback_requested", target.DeployID)
+	return target, nil
+}
+
+// handleRollbackService requests a rollback of a service to the release it
+// ran before its latest deploy.
+func handleRollbackService(configPath, serviceID string) error {
+	if serviceID == "" {
+		return fmt.Errorf("service ID is required (use -service flag)")
+	}
+	stateMgr, err := openState(configPath)
+	if err != nil {
+		return err
+	}
+	defer stateMgr.Close()
+
+	target, err := requestRollback(stateMgr, serviceID)
+	if errors.Is(err, service.ErrNoRollbackTarget) {
+		fmt.Printf("Service '%s' has no earlier release to roll back to\n", serviceID)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("✓ Rollback of service '%s' requested; the next sync redeploys it\n", serviceID)
+	fmt.Printf("- Image:  %s\n", target.ImageTag)
+	if target.GitCommit != "" {
+		fmt.Printf("- Commit: %s\n", target.GitCommit)
+	}
+	if target.DeployID != "" {
+		fmt.Printf("- Deploy: %s (port %d, recorded %s)\n", target.DeployID, target.Port, target.RecordedAt.Local().Format(time.RFC1123))
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+
+	"github.com/buildvigil/agent/internal/api"
+	"github.com/buildvigil/agent/internal/config"
+)
+
+// agentScopes returns the unbound scopes the agent's credential needs for
+// the features enabled in cfg, as requested at registration.
+func agentScopes(cfg *config.Config) []string {
+	scopes := []string{api.ScopeStackRead, api.ScopeAgentHeartbeat}
+	if cfg.RemoteCommands {
+		scopes = append(scopes, api.ScopeAgentCommands)
+	}
+	if cfg.LogShipEnabled {
+		scopes = append(scopes, api.ScopeAgentLogs)
+	}
+	if cfg.UploadBuildLogs || cfg.ReportDeploys || cfg.UploadDiagnostics {
+		scopes = append(scopes, api.ScopeAgentReports)
+	}
+	return scopes
+}
+
+// boundAgentScopes binds agentScopes to the agent's stacks and ID.
+func boundAgentScopes(cfg *config.Config) []string {
+	var scopes []string
+	for _, scope := range agentScopes(cfg) {
+		if scope == api.ScopeStackRead {
+			for _, stackID := range cfg.Stacks() {
+				scopes = append(scopes, api.BindScope(scope, stackID))
+			}
+			continue
+		}
+		scopes = append(scopes, api.BindScope(scope, cfg.AgentID))
+	}
+	return scopes
+}
+
+// scopeWarnings describes how a credential's granted scopes differ from
+// those the agent needs. It returns nothing when none are known.
+func scopeWarnings(cfg *config.Config, granted []string) []string {
+	if len(granted) == 0 {
+		return nil
+	}
+	missing, excess := api.CheckScopes(granted, boundAgentScopes(cfg))
+	var warnings []string
+	if len(excess) > 0 {
+		warnings = append(warnings, fmt.Sprintf("API key is over-privileged: scopes %s go beyond this agent's stacks and features; issue a key scoped to %s",
+			strings.Join(excess, ", "), strings.Join(boundAgentScopes(cfg), ", ")))
+	}
+	if len(missing) > 0 {
+		warnings = append(warnings, fmt.Sprintf("API key lacks scopes %s; requests needing them will be refused", strings.Join(missing, ", ")))
+	}
+	return warnings
+}
+
+// checkAPIKeyScopes warns when the scopes granted to the agent's API key
+// differ from those it needs. The control plane reports them at
+// registration and in heartbeat responses; unchanged scopes are checked once.
+func (a *Agent) checkAPIKeyScopes(granted []string) {
+	if len(granted) == 0 {
+		return
+	}
+	a.scopesMu.Lock()
+	defer a.scopesMu.Unlock()
+	if slices.Equal(a.grantedScopes, granted) {
+		return
+	}
+	a.grantedScopes = slices.Clone(granted)
+	for _, warning := range scopeWarnings(a.config, granted) {
+		log.Printf("Warning: %s", warning)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/buildvigil/agent/internal/api"
+	"github.com/buildvigil/agent/internal/proxy"
+)
+
+// sloWindows are the rolling windows SLO compliance is reported over: a short
+// one that reacts quickly and the hour that decides the status.
+var sloWindows = []struct {
+	name     string
+	duration time.Duration
+}{
+	{"5m", 5 * time.Minute},
+	{"1h", time.Hour},
+}
+
+// serviceSLO is the objective of a routed service.
+type serviceSLO struct {
+	serviceID string
+	route     string
+	slo       api.ServiceSLO
+}
+
+func validateSLO(slo api.ServiceSLO) error {
+	if slo.LatencyMs < 0 {
+		return fmt.Errorf("invalid latency_ms %d", slo.LatencyMs)
+	}
+	if slo.LatencyTarget < 0 || slo.LatencyTarget >= 1 {
+		return fmt.Errorf("latency_target %g must be between 0 and 1", slo.LatencyTarget)
+	}
+	if slo.LatencyTarget > 0 && slo.LatencyMs == 0 {
+		return fmt.Errorf("latency_target needs latency_ms")
+	}
+	if slo.ErrorRateTarget < 0 || slo.ErrorRateTarget >= 1 {
+		return fmt.Errorf("error_rate_target %g must be between 0 and 1", slo.ErrorRateTarget)
+	}
+	return nil
+}
+
+// setSLOs replaces the tracked objectives and gives the proxy each route's
+// latency threshold.
+func (a *Agent) setSLOs(slos []serviceSLO) {
+	thresholds := make(map[string]time.Duration, len(slos))
+	for _, s := range slos {
+		if s.slo.LatencyMs > 0 {
+			thresholds[s.route] = time.Duration(s.slo.LatencyMs) * time.Millisecond
+		}
+	}
+	a.externalProxy.SetLatencyThresholds(thresholds)
+
+	a.sloMu.Lock()
+	a.slos = slos
+	a.sloMu.Unlock()
+}
+
+// sloStatuses reports each service's compliance with its objectives.
+func (a *Agent) sloStatuses() []api.SLOStatus {
+	a.sloMu.Lock()
+	slos := a.slos
+	a.sloMu.Unlock()
+
+	statuses := make([]api.SLOStatus, 0, len(slos))
+	for _, s := range slos {
+		status := api.SLOStatus{ServiceID: s.serviceID, Status: "no_data"}
+		for _, window := range sloWindows {
+			result := sloWindow(window.name, a.externalProxy.SLOCounts(s.route, window.duration), s.slo)
+			status.Windows = append(status.Windows, result)
+			if window.duration == time.Hour && result.Requests > 0 {
+				status.Status = "ok"
+				if !result.Compliant {
+					status.Status = "violated"
+				}
+			}
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// sloWindow computes compliance and burn rates from a window's counts.
+func sloWindow(name string, counts proxy.SLOCounts, slo api.ServiceSLO) api.SLOWindow {
+	window := api.SLOWindow{Window: name, Requests: counts.Requests, Compliant: true}
+	if counts.Requests == 0 {
+		return window
+	}
+	window.ErrorRate = float64(counts.Errors) / float64(counts.Requests)
+	window.SlowRate = float64(counts.Slow) / float64(counts.Requests)
+	if slo.ErrorRateTarget > 0 {
+		window.ErrorBurn = window.ErrorRate / slo.ErrorRateTarget
+		window.Compliant = window.Compliant && window.ErrorRate <= slo.ErrorRateTarget
+	}
+	if slo.LatencyTarget > 0 {
+		allowed := 1 - slo.LatencyTarget
+		window.LatencyBurn = window.SlowRate / allowed
+		window.Compliant = window.Compliant && window.SlowRate <= allowed
+	}
+	return window
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/buildvigil/agent/internal/api"
+)
+
+// fetchDesiredState fetches the desired state of every stack the agent
+// serves, merged into one when there are several. A stack that can't be
+// fetched keeps its last fetched state, so its services are neither
+// redeployed nor removed. Until it has been fetched once since the agent
+// started, the state cached by an earlier run is used instead, flagged
+// Stale, so services come back after a reboot during a control plane
+// outage.
+func (a *Agent) fetchDesiredState() (*api.DesiredState, error) {
+	stacks := a.config.Stacks()
+	a.stacksMu.Lock()
+	defer a.stacksMu.Unlock()
+	if a.stackStates == nil {
+		a.stackStates = make(map[string]*api.DesiredState)
+	}
+	if len(stacks) <= 1 {
+		// With a single stack a failed fetch fails the sync, as the
+		// running services already match the last fetched state.
+		desired, err := a.fetchStack(a.config.StackID)
+		if err != nil && a.stackStates[a.config.StackID] == nil {
+			return a.offlineDesiredState(a.config.StackID, err)
+		}
+		return desired, err
+	}
+
+	if a.stackStatus == nil {
+		a.stackStatus = make(map[string]api.StackStatus)
+	}
+	states := make([]*api.DesiredState, 0, len(stacks))
+	for _, stackID := range stacks {
+		status := a.stackStatus[stackID]
+		status.StackID = stackID
+		state, err := a.fetchStack(stackID)
+		if err != nil {
+			status.Error = err.Error()
+			status.Stale = true
+			a.stackStatus[stackID] = status
+			if state = a.stackStates[stackID]; state != nil {
+				log.Printf("Failed to fetch desired state, keeping the last one: stack=%s version=%d err=%v", stackID, state.Version, err)
+			} else if state, err = a.offlineDesiredState(stackID, err); err != nil {
+				return nil, fmt.Errorf("stack %s: %w", stackID, err)
+			}
+		} else {
+			status.Version, status.Hash, status.Services = state.Version, state.Hash, len(state.Services)
+			status.Error = ""
+			status.Stale = false
+			status.FetchedAt = time.Now().UTC()
+			a.stackStatus[stackID] = status
+		}
+		states = append(states, state)
+	}
+
+	merged, duplicates := api.MergeDesiredStates(states)
+	for _, serviceID := range duplicates {
+		log.Printf("Service is in several stacks, keeping the first: service=%s", serviceID)
+	}
+	return merged, nil
+}
+
+// fetchStack fetches the desired state of one stack, keeping it in memory
+// and in the state database for offline use. The caller holds stacksMu.
+func (a *Agent) fetchStack(stackID string) (*api.DesiredState, error) {
+	desired, err := a.api.GetDesiredState(a.runCtx, stackID)
+	if err != nil {
+		return nil, err
+	}
+	if previous := a.stackStates[stackID]; previous == nil || previous.Hash != desired.Hash {
+		if document, err := api.MarshalOfflineState(desired); err != nil {
+			log.Printf("Failed to encode desired state for offline use: stack=%s err=%v", stackID, err)
+		} else if err := a.state.SaveDesiredState(stackID, document); err != nil {
+			log.Printf("Failed to cache desired state: stack=%s err=%v", stackID, err)
+		}
+	}
+	a.stackStates[stackID] = desired
+	return desired, nil
+}
+
+// offlineDesiredState returns the desired state cached for a stack by an
+// earlier run, or fetchErr if there is none.
+func (a *Agent) offlineDesiredState(stackID string, fetchErr error) (*api.DesiredState, error) {
+	document, fetchedAt, err := a.state.LoadDesiredState(stackID)
+	if err != nil {
+		log.Printf("Failed to load cached desired state: stack=%s err=%v", stackID, err)
+	}
+	if document == nil {
+		return nil, fetchErr
+	}
+	desired, err := api.UnmarshalOfflineState(document)
+	if err != nil {
+		log.Printf("Failed to decode cached desired state: stack=%s err=%v", stackID, err)
+		return nil, fetchErr
+	}
+	log.Printf("Control plane unreachable, using cached desired state: stack=%s version=%d fetched_at=%s err=%v", stackID, desired.Version, fetchedAt.Format(time.RFC3339), fetchErr)
+	return desired, nil
+}
+
+// validateServiceNames checks service names stack by stack; services of
+// different stacks may share a name.
+func (a *Agent) validateServiceNames(services []api.Service) error {
+	byStack := make(map[string][]api.Service)
+	for _, svc := range services {
+		byStack[svc.StackID] = append(byStack[svc.StackID], svc)
+	}
+	var errs api.ServiceNamesError
+	for _, stackServices := range byStack {
+		if err := api.ValidateServiceNames(stackServices, a.config.ServiceNaming); err != nil {
+			stackErrs, ok := err.(api.ServiceNamesError)
+			if !ok {
+				return err
+			}
+			errs = append(errs, stackErrs...)
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	sort.SliceStable(errs, func(i, j int) bool { return errs[i].ServiceID < errs[j].ServiceID })
+	return errs
+}
+
+// internalNames returns the names a service is reachable at under
+// .svc.internal. When the agent serves several stacks, services are
+// reachable as <service>.<stack>, and the primary stack's also keep the
+// bare <service>.
+func (a *Agent) internalNames(svc api.Service) []string {
+	slug := api.ServiceSlug(svc.Name)
+	if svc.StackID == "" {
+		return []string{slug}
+	}
+	qualified := slug + "." + api.ServiceSlug(svc.StackID)
+	if svc.StackID == a.config.Stacks()[0] {
+		return []string{slug, qualified}
+	}
+	return []string{qualified}
+}
+
+// recordStackResults updates each stack's status with the outcome of a
+// sync of the merged state.
+func (a *Agent) recordStackResults(desired *api.DesiredState, failed map[string]bool) {
+	a.stacksMu.Lock()
+	defer a.stacksMu.Unlock()
+	if a.stackStatus == nil {
+		return
+	}
+	failedByStack := make(map[string][]string)
+	for _, svc := range desired.Services {
+		if failed[svc.ID] {
+			failedByStack[svc.StackID] = append(failedByStack[svc.StackID], svc.ID)
+		}
+	}
+	for stackID, status := range a.stackStatus {
+		status.Failed = failedByStack[stackID]
+		sort.Strings(status.Failed)
+		status.Applied = len(status.Failed) == 0 && status.Error == ""
+		a.stackStatus[stackID] = status
+	}
+}
+
+// stackStatuses returns the status of every stack, or nil when the agent
+// serves only one.
+func (a *Agent) stackStatuses() []api.StackStatus {
+	a.stacksMu.Lock()
+	defer a.stacksMu.Unlock()
+	if len(a.stackStatus) == 0 {
+		return nil
+	}
+	var statuses []api.StackStatus
+	for _, stackID := range a.config.Stacks() {
+		if status, ok := a.stackStatus[stackID]; ok {
+			statuses = append(statuses, status)
+		}
+	}
+	return statuses
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/buildvigil/agent/internal/api"
+)
+
+// statusFile is the document written to status.json after every heartbeat,
+// for monitoring scripts that can't reach the admin API.
+type statusFile struct {
+	UpdatedAt time.Time `json:"updated_at"`
+	// Delivered reports whether the control plane accepted the heartbeat;
+	// Error says why not.
+	Delivered bool                         `json:"delivered"`
+	Error     string                       `json:"error,omitempty"`
+	Services  map[string]api.ServiceStatus `json:"services"` // Keyed by service ID
+	Heartbeat api.HeartbeatRequest         `json:"heartbeat"`
+}
+
+// writeStatusFile replaces status.json with the heartbeat just sent and its
+// outcome. It writes through a temporary file so readers never see a
+// partial document.
+func (a *Agent) writeStatusFile(req api.HeartbeatRequest, sendErr error) {
+	status := statusFile{
+		UpdatedAt: time.Now().UTC(),
+		Delivered: sendErr == nil,
+		Services:  make(map[string]api.ServiceStatus, len(req.ServicesStatus)),
+		Heartbeat: req,
+	}
+	if sendErr != nil {
+		status.Error = sendErr.Error()
+	}
+	for _, svc := range req.ServicesStatus {
+		status.Services[svc.ServiceID] = svc
+	}
+	if err := saveStatusFile(a.config.StatusFilePath(), status); err != nil {
+		log.Printf("Failed to write status file: %v", err)
+	}
+}
+
+func saveStatusFile(path string, status statusFile) error {
+	data, err := json.MarshalIndent(status, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode status: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", tmp, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to replace %s: %w", path, err)
+	}
+	return nil
+}
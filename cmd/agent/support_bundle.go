@@ -0,0 +1,216 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/buildvigil/agent/internal/config"
+	"github.com/buildvigil/agent/internal/firewall"
+	"github.com/buildvigil/agent/internal/service"
+	"github.com/buildvigil/agent/internal/state"
+	"github.com/buildvigil/agent/internal/tunnel"
+)
+
+const (
+	supportCommandTimeout = 30 * time.Second
+	supportAgentLogLines  = 2000
+	supportEventLimit     = 500
+)
+
+// supportBundleWriter adds named entries to a gzipped tarball.
+type supportBundleWriter struct {
+	tw      *tar.Writer
+	created time.Time
+}
+
+func (w *supportBundleWriter) add(name string, data []byte) error {
+	header := &tar.Header{
+		Name:    name,
+		Mode:    0600,
+		Size:    int64(len(data)),
+		ModTime: w.created,
+	}
+	if err := w.tw.WriteHeader(header); err != nil {
+		return fmt.Errorf("failed to write header for %s: %w", name, err)
+	}
+	if _, err := w.tw.Write(data); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	return nil
+}
+
+func (w *supportBundleWriter) addJSON(name string, value interface{}) error {
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return w.add(name, []byte(fmt.Sprintf("failed to marshal: %v\n", err)))
+	}
+	return w.add(name, data)
+}
+
+// handleSupportBundle gathers redacted config, logs, state and host diagnostics
+// into a single tar.gz for attaching to bug reports.
+func handleSupportBundle(configPath, outputPath string) error {
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	created := time.Now().UTC()
+	if outputPath == "" {
+		outputPath = fmt.Sprintf("potato-cloud-support-%s.tar.gz", created.Format("20060102T150405Z"))
+	}
+
+	file, err := os.OpenFile(outputPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create bundle file: %w", err)
+	}
+	defer file.Close()
+
+	gz := gzip.NewWriter(file)
+	tw := tar.NewWriter(gz)
+	w := &supportBundleWriter{tw: tw, created: created}
+
+	if err := w.addJSON("config.json", cfg.Redacted()); err != nil {
+		return err
+	}
+	if err := w.add("agent.log", runSupportCommand("journalctl", "-u", "potato-cloud-agent", "--no-pager", "-n", fmt.Sprintf("%d", supportAgentLogLines))); err != nil {
+		return err
+	}
+	if err := addStateSummary(w, cfg); err != nil {
+		return err
+	}
+	if err := w.add("docker-info.txt", runSupportCommand("docker", "info")); err != nil {
+		return err
+	}
+	if err := w.add("docker-ps.txt", runSupportCommand("docker", "ps", "-a", "--filter", "name="+service.ContainerPrefix)); err != nil {
+		return err
+	}
+	if err := w.add("firewall.txt", firewallSupportStatus(cfg)); err != nil {
+		return err
+	}
+	if err := w.addJSON("tunnel.json", tunnelSupportStatus(cfg)); err != nil {
+		return err
+	}
+	if err := addDiagnosticsBundles(w, cfg.DiagnosticsPath()); err != nil {
+		return err
+	}
+
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to finalize tar: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("failed to finalize gzip: %w", err)
+	}
+
+	fmt.Printf("✓ Support bundle written to %s\n", outputPath)
+	fmt.Println("Note: Credentials in config are redacted, but review the bundle before sharing.")
+	return nil
+}
+
+func addStateSummary(w *supportBundleWriter, cfg *config.Config) error {
+	stateMgr, err := state.NewManager(cfg.StateDBPath())
+	if err != nil {
+		return w.add("state.txt", []byte(fmt.Sprintf("failed to open state database: %v\n", err)))
+	}
+	defer stateMgr.Close()
+
+	summary := map[string]interface{}{}
+	if applied, err := stateMgr.GetAppliedState(); err != nil {
+		summary["applied_state_error"] = err.Error()
+	} else {
+		summary["applied_state"] = applied
+	}
+	if processes, err := stateMgr.ListServiceProcesses(); err != nil {
+		summary["service_processes_error"] = err.Error()
+	} else {
+		summary["service_processes"] = processes
+	}
+	if err := w.addJSON("state.json", summary); err != nil {
+		return err
+	}
+
+	events, err := stateMgr.ListRecentEvents(supportEventLimit)
+	if err != nil {
+		return w.add("events.txt", []byte(fmt.Sprintf("failed to list events: %v\n", err)))
+	}
+	if err := w.addJSON("events.json", events); err != nil {
+		return err
+	}
+
+	routes := map[string]interface{}{}
+	for _, kind := range []string{"external", "internal"} {
+		table, err := stateMgr.GetRoutes(kind)
+		if err != nil {
+			routes[kind] = err.Error()
+			continue
+		}
+		routes[kind] = table
+	}
+	return w.addJSON("routes.json", routes)
+}
+
+func addDiagnosticsBundles(w *supportBundleWriter, dir string) error {
+	matches, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil
+	}
+	for _, path := range matches {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		if err := w.add(filepath.Join("diagnostics", filepath.Base(path)), data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func firewallSupportStatus(cfg *config.Config) []byte {
+	fw := firewall.NewManager(firewall.SecurityMode(cfg.SecurityMode), cfg.ExternalProxyPort)
+	if !fw.IsAvailable() {
+		return []byte("ufw not available\n")
+	}
+	status, err := fw.GetStatus()
+	if err != nil {
+		return []byte(fmt.Sprintf("failed to get firewall status: %v\n", err))
+	}
+	return []byte(fmt.Sprintf("mode: %v\n\n%v", status["mode"], status["status"]))
+}
+
+func tunnelSupportStatus(cfg *config.Config) map[string]interface{} {
+	status := map[string]interface{}{
+		"cloudflared_available": tunnel.IsCloudflaredAvailable(),
+		"configured":            cfg.HasCloudflareConfig(),
+		"tunnel_id":             cfg.CloudflareTunnelID,
+		"config_path":           cfg.TunnelConfigPath(),
+	}
+	if _, err := os.Stat(cfg.TunnelConfigPath()); err == nil {
+		status["config_present"] = true
+	} else {
+		status["config_present"] = false
+	}
+	status["processes"] = strings.TrimSpace(string(runSupportCommand("pgrep", "-a", "cloudflared")))
+	return status
+}
+
+// runSupportCommand runs a diagnostic command and returns its output, or a
+// description of the failure so the bundle is still produced.
+func runSupportCommand(name string, args ...string) []byte {
+	ctx, cancel := context.WithTimeout(context.Background(), supportCommandTimeout)
+	defer cancel()
+
+	output, err := exec.CommandContext(ctx, name, args...).CombinedOutput()
+	if err != nil {
+		return append(output, []byte(fmt.Sprintf("\n%s %s failed: %v\n", name, strings.Join(args, " "), err))...)
+	}
+	return output
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/buildvigil/agent/internal/api"
+	"github.com/buildvigil/agent/internal/service"
+)
+
+// syncTask runs a task service when its revision or definition changed since
+// the last run. Tasks get no port or routes. A failed run is not repeated
+// until one of them changes again; task_retries covers transient failures.
+func (a *Agent) syncTask(svc api.Service) error {
+	proc, _ := a.state.GetServiceProcess(svc.ID)
+	definitionHash := serviceDefinitionHash(svc)
+	definitionChanged := proc == nil || proc.DefinitionHash != definitionHash
+
+	revision := ""
+	if proc != nil {
+		revision = proc.GitCommit
+	}
+	if service.UsesPrebuiltImage(svc) {
+		revision = serviceRevisionSignature(svc)
+		delete(a.lastBranchSync, svc.ID)
+	} else {
+		pinned := strings.TrimSpace(svc.GitCommit)
+		lastCheck, checked := a.lastBranchSync[svc.ID]
+		shouldSync := definitionChanged || revision == "" ||
+			(pinned != "" && pinned != revision) ||
+			(pinned == "" && (!checked || time.Since(lastCheck) >= branchSelfHealInterval))
+		if shouldSync {
+			commit, err := a.git.CloneOrPull(svc.ID, svc.GitURL, svc.GitRef, svc.GitCommit, svc.GitSSHKey)
+			if err != nil {
+				a.onServiceLifecycleEvent(svc, "error", "unknown", err.Error())
+				return fmt.Errorf("failed to sync repo: %w", err)
+			}
+			revision = commit
+			a.lastBranchSync[svc.ID] = time.Now()
+		}
+	}
+	svc.GitCommit = revision
+
+	if !definitionChanged && proc != nil && proc.GitCommit == revision {
+		return nil
+	}
+
+	log.Printf("Running task: name=%s service=%s revision=%s", svc.Name, svc.ID, revision)
+	_, runErr := a.services.RunTask(svc)
+	if err := a.state.SetServiceDefinitionHash(svc.ID, definitionHash); err != nil {
+		log.Printf("Failed to record definition hash for task %s: %v", svc.Name, err)
+	}
+	return runErr
+}
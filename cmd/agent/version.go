@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/buildvigil/agent/internal/api"
+	"github.com/buildvigil/agent/internal/version"
+)
+
+// agentInfo returns the build information reported to the control plane.
+func agentInfo() api.AgentInfo {
+	info := version.Get()
+	return api.AgentInfo{
+		Version:   info.Version,
+		Commit:    info.Commit,
+		BuildDate: info.BuildDate,
+		GoVersion: info.GoVersion,
+		Platform:  info.Platform,
+		Features:  info.Features,
+	}
+}
+
+// runVersionCommand handles `agent version [--json]`.
+func runVersionCommand(args []string) error {
+	fs := flag.NewFlagSet("version", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "Print build information as JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	return printVersion(*asJSON)
+}
+
+func printVersion(asJSON bool) error {
+	info := version.Get()
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(info)
+	}
+	fmt.Printf("potato-cloud-agent %s\n", info)
+	fmt.Printf("Go:       %s\n", info.GoVersion)
+	fmt.Printf("Platform: %s\n", info.Platform)
+	fmt.Printf("Features: %d\n", len(info.Features))
+	for _, feature := range info.Features {
+		fmt.Printf("  - %s\n", feature)
+	}
+	return nil
+}
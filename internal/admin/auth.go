@@ -0,0 +1,262 @@
+package admin
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// TimestampHeader and SignatureHeader carry HMAC request signatures.
+	TimestampHeader = "X-Agent-Timestamp"
+	SignatureHeader = "X-Agent-Signature"
+
+	// maxClockSkew is how far a signed request's timestamp may be from the
+	// agent's clock. Signatures are remembered this long to reject replays.
+	maxClockSkew = 5 * time.Minute
+
+	maxSignedBodyBytes = 1 << 20
+)
+
+// AuthConfig protects the TCP admin listener. A request must present the
+// token, a valid signature or a client certificate issued by ClientCA when
+// any of them is configured, and come from an allowed network when any are
+// listed. Requests from a peer in TunnelCIDRs, the tunnel connector, are
+// checked against the CF-Connecting-IP address it reports. With TLSCert and
+// TLSKey the listener serves HTTPS. The Unix socket relies on file
+// permissions instead.
+type AuthConfig struct {
+	Token        string
+	HMACSecret   string
+	AllowedCIDRs []string
+	TunnelCIDRs  []string
+	TLSCert      string
+	TLSKey       string
+	ClientCA     string
+}
+
+type authenticator struct {
+	token     string
+	secret    []byte
+	networks  []*net.IPNet
+	tunnels   []*net.IPNet
+	tlsConfig *tls.Config
+	clientCA  bool
+	now       func() time.Time
+
+	mu   sync.Mutex
+	seen map[string]time.Time // signature -> expiry
+}
+
+func newAuthenticator(cfg AuthConfig) (*authenticator, error) {
+	a := &authenticator{
+		token:  cfg.Token,
+		secret: []byte(cfg.HMACSecret),
+		now:    time.Now,
+		seen:   make(map[string]time.Time),
+	}
+	for _, cidr := range cfg.AllowedCIDRs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid admin allowed CIDR %q: %w", cidr, err)
+		}
+		a.networks = append(a.networks, network)
+	}
+	for _, cidr := range cfg.TunnelCIDRs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid admin tunnel CIDR %q: %w", cidr, err)
+		}
+		a.tunnels = append(a.tunnels, network)
+	}
+	if err := a.loadTLS(cfg); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// loadTLS builds the listener's TLS config. The certificate is re-read for
+// each new connection, so a renewed one is served without a restart.
+func (a *authenticator) loadTLS(cfg AuthConfig) error {
+	if cfg.TLSCert == "" && cfg.TLSKey == "" && cfg.ClientCA == "" {
+		return nil
+	}
+	if cfg.TLSCert == "" || cfg.TLSKey == "" {
+		return fmt.Errorf("admin TLS certificate and key must both be set")
+	}
+	if _, err := tls.LoadX509KeyPair(cfg.TLSCert, cfg.TLSKey); err != nil {
+		return fmt.Errorf("failed to load admin TLS certificate: %w", err)
+	}
+	a.tlsConfig = &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			cert, err := tls.LoadX509KeyPair(cfg.TLSCert, cfg.TLSKey)
+			if err != nil {
+				return nil, fmt.Errorf("failed to load admin TLS certificate: %w", err)
+			}
+			return &cert, nil
+		},
+	}
+	if cfg.ClientCA != "" {
+		bundle, err := os.ReadFile(cfg.ClientCA)
+		if err != nil {
+			return fmt.Errorf("failed to read admin client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(bundle) {
+			return fmt.Errorf("no certificates found in admin client CA %s", cfg.ClientCA)
+		}
+		// Clients without a certificate may still use the token or a
+		// signature; one presented must verify.
+		a.tlsConfig.ClientCAs = pool
+		a.tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		a.clientCA = true
+	}
+	return nil
+}
+
+// SetTCPAuth configures authentication for TCP listeners started afterwards.
+func (s *Server) SetTCPAuth(cfg AuthConfig) error {
+	auth, err := newAuthenticator(cfg)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.tcpAuth = auth
+	s.mu.Unlock()
+	return nil
+}
+
+func (a *authenticator) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := a.check(r); err != nil {
+			log.Printf("[Admin] Rejected request: remote=%s path=%s err=%v", r.RemoteAddr, r.URL.Path, err)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (a *authenticator) check(r *http.Request) error {
+	if len(a.networks) > 0 {
+		ip := a.clientIP(r)
+		if !contains(a.networks, ip) {
+			return fmt.Errorf("client %v not in allowed networks", ip)
+		}
+	}
+	if !a.hasCredentials() {
+		return nil
+	}
+	if a.clientCA && r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		return nil
+	}
+	if a.token != "" {
+		if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok &&
+			subtle.ConstantTimeCompare([]byte(bearer), []byte(a.token)) == 1 {
+			return nil
+		}
+	}
+	if len(a.secret) > 0 && r.Header.Get(SignatureHeader) != "" {
+		return a.verifySignature(r)
+	}
+	return fmt.Errorf("missing credentials")
+}
+
+// hasCredentials reports whether requests must present a token, signature
+// or client certificate.
+func (a *authenticator) hasCredentials() bool {
+	return a.token != "" || len(a.secret) > 0 || a.clientCA
+}
+
+func contains(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if ip != nil && network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the peer address, or the address cloudflared reports when
+// the request arrived from a configured tunnel connector. The header is
+// ignored from any other peer, loopback included, since anything on the
+// host could set it.
+func (a *authenticator) clientIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if contains(a.tunnels, ip) {
+		if forwarded := net.ParseIP(r.Header.Get("CF-Connecting-IP")); forwarded != nil {
+			return forwarded
+		}
+	}
+	return ip
+}
+
+// verifySignature checks the request's HMAC and timestamp, and rejects a
+// signature seen before within the skew window.
+func (a *authenticator) verifySignature(r *http.Request) error {
+	timestamp, err := strconv.ParseInt(r.Header.Get(TimestampHeader), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid timestamp")
+	}
+	now := a.now()
+	signedAt := time.Unix(timestamp, 0)
+	if signedAt.Before(now.Add(-maxClockSkew)) || signedAt.After(now.Add(maxClockSkew)) {
+		return fmt.Errorf("timestamp outside allowed skew")
+	}
+
+	var body []byte
+	if r.Body != nil {
+		body, err = io.ReadAll(io.LimitReader(r.Body, maxSignedBodyBytes))
+		if err != nil {
+			return fmt.Errorf("failed to read body: %w", err)
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	expected := Signature(a.secret, timestamp, r.Method, r.URL.RequestURI(), body)
+	signature := r.Header.Get(SignatureHeader)
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return fmt.Errorf("signature mismatch")
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for sig, expiry := range a.seen {
+		if now.After(expiry) {
+			delete(a.seen, sig)
+		}
+	}
+	if _, replayed := a.seen[signature]; replayed {
+		return fmt.Errorf("replayed signature")
+	}
+	a.seen[signature] = signedAt.Add(maxClockSkew)
+	return nil
+}
+
+// Signature returns the hex HMAC-SHA256 of a request: the unix timestamp,
+// method, request URI and SHA-256 of the body, newline separated.
+func Signature(secret []byte, timestamp int64, method, requestURI string, body []byte) string {
+	bodyHash := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, secret)
+	fmt.Fprintf(mac, "%d\n%s\n%s\n%s", timestamp, method, requestURI, hex.EncodeToString(bodyHash[:]))
+	return hex.EncodeToString(mac.Sum(nil))
+}
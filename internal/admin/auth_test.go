@@ -0,0 +1,260 @@
+package admin
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestAuthenticator_TokenAndAllowlist(t *testing.T) {
+	t.Logf("Testing admin token auth and IP allowlist...")
+
+	auth, err := newAuthenticator(AuthConfig{Token: "s3cret", AllowedCIDRs: []string{"10.0.0.0/8"}, TunnelCIDRs: []string{"127.0.0.1/32"}})
+	if err != nil {
+		t.Fatalf("Failed to create authenticator: %v", err)
+	}
+	handler := auth.wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	cases := []struct {
+		name   string
+		remote string
+		header map[string]string
+		code   int
+	}{
+		{"valid", "10.1.2.3:5000", map[string]string{"Authorization": "Bearer s3cret"}, http.StatusOK},
+		{"wrong token", "10.1.2.3:5000", map[string]string{"Authorization": "Bearer nope"}, http.StatusUnauthorized},
+		{"outside allowlist", "192.0.2.1:5000", map[string]string{"Authorization": "Bearer s3cret"}, http.StatusUnauthorized},
+		{"via tunnel", "127.0.0.1:5000", map[string]string{"Authorization": "Bearer s3cret", "CF-Connecting-IP": "10.9.9.9"}, http.StatusOK},
+		{"tunnel from outside", "127.0.0.1:5000", map[string]string{"Authorization": "Bearer s3cret", "CF-Connecting-IP": "192.0.2.1"}, http.StatusUnauthorized},
+		{"header from other loopback peer", "127.0.0.2:5000", map[string]string{"Authorization": "Bearer s3cret", "CF-Connecting-IP": "10.9.9.9"}, http.StatusUnauthorized},
+		{"header from allowed peer", "10.1.2.3:5000", map[string]string{"Authorization": "Bearer s3cret", "CF-Connecting-IP": "192.0.2.1"}, http.StatusOK},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/v1/health", nil)
+		req.RemoteAddr = tc.remote
+		for key, value := range tc.header {
+			req.Header.Set(key, value)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tc.code {
+			t.Errorf("%s: expected %d, got %d", tc.name, tc.code, rec.Code)
+		}
+	}
+
+	if _, err := newAuthenticator(AuthConfig{AllowedCIDRs: []string{"not-a-cidr"}}); err == nil {
+		t.Errorf("Expected invalid CIDR to be rejected")
+	}
+	if _, err := newAuthenticator(AuthConfig{TunnelCIDRs: []string{"127.0.0.1"}}); err == nil {
+		t.Errorf("Expected invalid tunnel CIDR to be rejected")
+	}
+	t.Logf("✓ Token and source network enforced")
+}
+
+func TestListenTCP_RefusesOpenListenerOffLoopback(t *testing.T) {
+	t.Logf("Testing the TCP admin listener needs credentials off loopback")
+
+	srv := NewServer(nil)
+	t.Cleanup(func() { srv.Stop() })
+	if err := srv.SetTCPAuth(AuthConfig{AllowedCIDRs: []string{"10.0.0.0/8"}}); err != nil {
+		t.Fatalf("Failed to configure auth: %v", err)
+	}
+	if err := srv.ListenTCP("0.0.0.0:0"); err == nil {
+		t.Error("Expected a listener on all interfaces without credentials to be refused")
+	}
+	if err := srv.ListenTCP("127.0.0.1:0"); err != nil {
+		t.Errorf("Expected a loopback listener without credentials to start, got %v", err)
+	}
+
+	if err := srv.SetTCPAuth(AuthConfig{Token: "s3cret"}); err != nil {
+		t.Fatalf("Failed to configure auth: %v", err)
+	}
+	if err := srv.ListenTCP("0.0.0.0:0"); err != nil {
+		t.Errorf("Expected a listener with a token to start, got %v", err)
+	}
+	t.Logf("✓ Unauthenticated listeners kept on loopback")
+}
+
+func TestAuthenticator_HMACReplayProtection(t *testing.T) {
+	t.Logf("Testing admin HMAC signatures...")
+
+	secret := []byte("hmac-key")
+	auth, err := newAuthenticator(AuthConfig{HMACSecret: string(secret)})
+	if err != nil {
+		t.Fatalf("Failed to create authenticator: %v", err)
+	}
+	clock := time.Date(2026, 6, 1, 10, 0, 0, 0, time.UTC)
+	auth.now = func() time.Time { return clock }
+	handler := auth.wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	send := func(timestamp int64, signature string) int {
+		req := httptest.NewRequest(http.MethodGet, "/v1/services/svc/logs?limit=5", nil)
+		req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+		req.Header.Set(SignatureHeader, signature)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	now := clock.Unix()
+	signature := Signature(secret, now, http.MethodGet, "/v1/services/svc/logs?limit=5", nil)
+	if code := send(now, signature); code != http.StatusOK {
+		t.Fatalf("Expected valid signature to pass, got %d", code)
+	}
+	if code := send(now, signature); code != http.StatusUnauthorized {
+		t.Errorf("Expected replayed signature to be rejected, got %d", code)
+	}
+
+	stale := clock.Add(-10 * time.Minute).Unix()
+	if code := send(stale, Signature(secret, stale, http.MethodGet, "/v1/services/svc/logs?limit=5", nil)); code != http.StatusUnauthorized {
+		t.Errorf("Expected stale timestamp to be rejected, got %d", code)
+	}
+	if code := send(now+1, Signature(secret, now+1, http.MethodGet, "/v1/services/other/logs", nil)); code != http.StatusUnauthorized {
+		t.Errorf("Expected signature for another path to be rejected, got %d", code)
+	}
+	if code := send(now, ""); code != http.StatusUnauthorized {
+		t.Errorf("Expected unsigned request to be rejected, got %d", code)
+	}
+	t.Logf("✓ Signatures verified, stale and replayed requests rejected")
+}
+
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+// newTestCert issues a certificate signed by parent, or a self-signed CA
+// when parent is nil.
+func newTestCert(t *testing.T, name string, parent *testCert, usage x509.ExtKeyUsage) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	return &testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+func writeTestFile(t *testing.T, dir, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatalf("Failed to write %s: %v", name, err)
+	}
+	return path
+}
+
+func TestListenTCP_MutualTLS(t *testing.T) {
+	t.Logf("Testing the TCP admin listener with client certificates")
+
+	ca := newTestCert(t, "admin-ca", nil, x509.ExtKeyUsageAny)
+	serverCert := newTestCert(t, "agent", ca, x509.ExtKeyUsageServerAuth)
+	clientCert := newTestCert(t, "operator", ca, x509.ExtKeyUsageClientAuth)
+	otherCA := newTestCert(t, "other-ca", nil, x509.ExtKeyUsageAny)
+	strangerCert := newTestCert(t, "stranger", otherCA, x509.ExtKeyUsageClientAuth)
+
+	dir := t.TempDir()
+	srv := NewServer(nil)
+	err := srv.SetTCPAuth(AuthConfig{
+		Token:    "s3cret",
+		TLSCert:  writeTestFile(t, dir, "server.crt", serverCert.certPEM),
+		TLSKey:   writeTestFile(t, dir, "server.key", serverCert.keyPEM),
+		ClientCA: writeTestFile(t, dir, "ca.crt", ca.certPEM),
+	})
+	if err != nil {
+		t.Fatalf("Failed to configure auth: %v", err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to reserve a port: %v", err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+	if err := srv.ListenTCP(addr); err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { srv.Stop() })
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	get := func(cert *testCert, token string) (int, error) {
+		tlsConfig := &tls.Config{RootCAs: roots}
+		if cert != nil {
+			pair, err := tls.X509KeyPair(cert.certPEM, cert.keyPEM)
+			if err != nil {
+				t.Fatalf("Failed to load client certificate: %v", err)
+			}
+			tlsConfig.Certificates = []tls.Certificate{pair}
+		}
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+		req, _ := http.NewRequest(http.MethodGet, "https://"+addr+"/v1/health", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return 0, err
+		}
+		resp.Body.Close()
+		return resp.StatusCode, nil
+	}
+
+	if code, err := get(clientCert, ""); err != nil || code != http.StatusOK {
+		t.Errorf("Expected a trusted client certificate to be accepted, got %d (err=%v)", code, err)
+	}
+	if code, err := get(nil, "s3cret"); err != nil || code != http.StatusOK {
+		t.Errorf("Expected the token to be accepted without a certificate, got %d (err=%v)", code, err)
+	}
+	if code, err := get(nil, ""); err != nil || code != http.StatusUnauthorized {
+		t.Errorf("Expected no credentials to be rejected, got %d (err=%v)", code, err)
+	}
+	if code, err := get(strangerCert, ""); err == nil && code == http.StatusOK {
+		t.Errorf("Expected a certificate from another CA to be rejected")
+	}
+
+	if err := NewServer(nil).SetTCPAuth(AuthConfig{ClientCA: filepath.Join(dir, "ca.crt")}); err == nil {
+		t.Errorf("Expected a client CA without a server certificate to be rejected")
+	}
+	t.Logf("✓ Client certificates and tokens accepted over TLS, others rejected")
+}
@@ -0,0 +1,153 @@
+package admin
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Client talks to a running agent's admin API.
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	token      string
+	secret     []byte
+}
+
+// NewSocketClient returns a client that connects over the agent's Unix socket.
+func NewSocketClient(socketPath string) *Client {
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socketPath)
+		},
+	}
+	return &Client{
+		baseURL:    "http://agent",
+		httpClient: &http.Client{Transport: transport},
+	}
+}
+
+// NewClient returns a client for an admin API reachable over HTTP, e.g.
+// through the tunnel.
+func NewClient(baseURL string) *Client {
+	return &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		httpClient: &http.Client{},
+	}
+}
+
+// SetAuth sets the bearer token or HMAC secret sent with requests to a TCP
+// admin listener.
+func (c *Client) SetAuth(token, hmacSecret string) {
+	c.token = token
+	c.secret = []byte(hmacSecret)
+}
+
+// authorize adds credentials to a bodiless request.
+func (c *Client) authorize(req *http.Request) {
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	if len(c.secret) > 0 {
+		timestamp := time.Now().Unix()
+		req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+		req.Header.Set(SignatureHeader, Signature(c.secret, timestamp, req.Method, req.URL.RequestURI(), nil))
+	}
+}
+
+// Ping checks that the agent is serving the admin API.
+func (c *Client) Ping() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/v1/health", nil)
+	if err != nil {
+		return err
+	}
+	c.authorize(req)
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("admin API returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// StreamLogs follows a service's logs, calling fn for each entry after
+// afterID, until ctx is cancelled or the agent closes the stream.
+func (c *Client) StreamLogs(ctx context.Context, serviceID string, afterID int64, fn func(LogEntry)) error {
+	endpoint := fmt.Sprintf("%s/v1/services/%s/logs/stream?after=%d", c.baseURL, url.PathEscape(serviceID), afterID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	c.authorize(req)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to open log stream: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("log stream failed with status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	return readEvents(resp.Body, func(event, data string) error {
+		if event == "error" {
+			message, err := strconv.Unquote(data)
+			if err != nil {
+				message = data
+			}
+			return fmt.Errorf("agent reported: %s", message)
+		}
+		var entry LogEntry
+		if err := json.Unmarshal([]byte(data), &entry); err != nil {
+			return fmt.Errorf("failed to decode log entry: %w", err)
+		}
+		fn(entry)
+		return nil
+	})
+}
+
+// readEvents parses a text/event-stream body, calling fn for each event.
+func readEvents(body io.Reader, fn func(event, data string) error) error {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	event := ""
+	var data []string
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if len(data) > 0 {
+				if err := fn(event, strings.Join(data, "\n")); err != nil {
+					return err
+				}
+			}
+			event = ""
+			data = nil
+		case strings.HasPrefix(line, ":"):
+			// Comment / keep-alive.
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			data = append(data, strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+	}
+	return scanner.Err()
+}
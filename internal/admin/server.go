@@ -0,0 +1,343 @@
+// Package admin serves the agent's local admin API over a Unix socket and,
+// optionally, a TCP address that can be exposed through the tunnel.
+package admin
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/buildvigil/agent/internal/state"
+)
+
+const (
+	// LogPollInterval is how often a follow stream checks for new log rows.
+	LogPollInterval = 1 * time.Second
+	// streamKeepAlive is how often an idle SSE stream sends a comment so
+	// proxies and tunnels don't close the connection.
+	streamKeepAlive = 15 * time.Second
+	defaultLogLimit = 100
+	maxLogLimit     = 10000
+)
+
+// LogEntry is a service log line as returned by the admin API.
+type LogEntry struct {
+	ID          int64     `json:"id,omitempty"`
+	DeployID    string    `json:"deploy_id,omitempty"`
+	ContainerID string    `json:"container_id,omitempty"`
+	Level       string    `json:"level"`
+	Message     string    `json:"message"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Server is the admin HTTP API.
+type Server struct {
+	state   *state.Manager
+	mux     *http.ServeMux
+	servers []*http.Server
+	tcpAuth *authenticator
+	mu      sync.Mutex
+}
+
+// NewServer creates an admin API server backed by the agent's state manager.
+func NewServer(stateMgr *state.Manager) *Server {
+	s := &Server{
+		state: stateMgr,
+		mux:   http.NewServeMux(),
+	}
+	s.mux.HandleFunc("/v1/health", s.handleHealth)
+	s.mux.HandleFunc("/v1/services/", s.handleServices)
+	return s
+}
+
+// Handle registers an additional admin endpoint.
+func (s *Server) Handle(pattern string, handler http.HandlerFunc) {
+	s.mux.HandleFunc(pattern, handler)
+}
+
+// ListenUnix serves the admin API on a Unix socket, replacing a stale socket
+// left behind by a previous run.
+func (s *Server) ListenUnix(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create socket directory: %w", err)
+	}
+	_ = os.Remove(path)
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", path, err)
+	}
+	if err := os.Chmod(path, 0660); err != nil {
+		listener.Close()
+		return fmt.Errorf("failed to chmod admin socket: %w", err)
+	}
+	s.serve(listener, s.mux)
+	log.Printf("[Admin] Listening on unix socket %s", path)
+	return nil
+}
+
+// ListenTCP serves the admin API on a TCP address, behind the authentication
+// and TLS set with SetTCPAuth. It refuses an address off loopback unless
+// requests must present credentials.
+func (s *Server) ListenTCP(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	s.mu.Lock()
+	auth := s.tcpAuth
+	s.mu.Unlock()
+	if tcpAddr, ok := listener.Addr().(*net.TCPAddr); ok && !tcpAddr.IP.IsLoopback() && (auth == nil || !auth.hasCredentials()) {
+		listener.Close()
+		return fmt.Errorf("refusing to listen on %s without admin_token, admin_hmac_secret or admin_client_ca", addr)
+	}
+	var handler http.Handler = s.mux
+	scheme := "http"
+	if auth != nil {
+		handler = auth.wrap(s.mux)
+		if auth.tlsConfig != nil {
+			listener = tls.NewListener(listener, auth.tlsConfig)
+			scheme = "https"
+		}
+	}
+	s.serve(listener, handler)
+	log.Printf("[Admin] Listening on %s://%s", scheme, addr)
+	return nil
+}
+
+func (s *Server) serve(listener net.Listener, handler http.Handler) {
+	server := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	s.mu.Lock()
+	s.servers = append(s.servers, server)
+	s.mu.Unlock()
+
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Printf("[Admin] Server error: %v", err)
+		}
+	}()
+}
+
+// Stop shuts down all listeners. Open log streams are closed.
+func (s *Server) Stop() error {
+	s.mu.Lock()
+	servers := s.servers
+	s.servers = nil
+	s.mu.Unlock()
+
+	// Log streams never go idle, so close rather than wait for a graceful
+	// shutdown to time out.
+	var firstErr error
+	for _, server := range servers {
+		if err := server.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// handleServices routes /v1/services/{id}/logs, /v1/services/{id}/logs/stream
+// and /v1/services/{id}/metrics.
+func (s *Server) handleServices(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/v1/services/"), "/"), "/")
+	if len(parts) < 2 || parts[0] == "" || (parts[1] != "logs" && parts[1] != "metrics") {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	serviceID := parts[0]
+	switch {
+	case len(parts) == 2 && parts[1] == "metrics":
+		s.handleMetrics(w, r, serviceID)
+	case len(parts) == 2:
+		s.handleLogs(w, r, serviceID)
+	case len(parts) == 3 && parts[2] == "stream":
+		s.handleLogStream(w, r, serviceID)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// handleLogs returns the most recent logs for a service, oldest first,
+// optionally filtered by ?deploy= (an ID, "current" or "previous") and
+// ?container= (a container ID prefix).
+func (s *Server) handleLogs(w http.ResponseWriter, r *http.Request, serviceID string) {
+	limit := defaultLogLimit
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed <= 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		if parsed > maxLogLimit {
+			parsed = maxLogLimit
+		}
+		limit = parsed
+	}
+
+	filter := state.LogFilter{ContainerID: r.URL.Query().Get("container")}
+	if ref := r.URL.Query().Get("deploy"); ref != "" {
+		deployID, err := s.state.ResolveDeployRef(serviceID, ref)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		filter.DeployID = deployID
+	}
+
+	logs, err := s.state.QueryServiceLogs(serviceID, filter, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	entries := make([]LogEntry, 0, len(logs))
+	for i := len(logs) - 1; i >= 0; i-- {
+		entries = append(entries, LogEntry{
+			ID:          logs[i].ID,
+			DeployID:    logs[i].DeployID,
+			ContainerID: logs[i].ContainerID,
+			Level:       logs[i].Level,
+			Message:     logs[i].Message,
+			CreatedAt:   logs[i].CreatedAt,
+		})
+	}
+	writeJSON(w, http.StatusOK, entries)
+}
+
+// handleLogStream streams new log rows as server-sent events. Each event's id
+// is the log row ID, so clients resume with Last-Event-ID or ?after=.
+func (s *Server) handleLogStream(w http.ResponseWriter, r *http.Request, serviceID string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	lastID, err := streamStartID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	poll := time.NewTicker(LogPollInterval)
+	defer poll.Stop()
+	lastWrite := time.Now()
+
+	for {
+		logs, err := s.state.StreamLogs(serviceID, lastID)
+		if err != nil {
+			fmt.Fprintf(w, "event: error\ndata: %s\n\n", strconv.Quote(err.Error()))
+			flusher.Flush()
+			return
+		}
+		for _, entry := range logs {
+			data, _ := json.Marshal(LogEntry{
+				ID:        entry.ID,
+				Level:     entry.Level,
+				Message:   entry.Message,
+				CreatedAt: entry.CreatedAt,
+			})
+			fmt.Fprintf(w, "id: %d\ndata: %s\n\n", entry.ID, data)
+			lastID = entry.ID
+		}
+		if len(logs) > 0 {
+			flusher.Flush()
+			lastWrite = time.Now()
+			if len(logs) == state.StreamLogsPageSize {
+				continue
+			}
+		} else if time.Since(lastWrite) >= streamKeepAlive {
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+			lastWrite = time.Now()
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-poll.C:
+		}
+	}
+}
+
+// handleMetrics returns a service's metric samples, oldest first.
+// ?resolution= is raw (default) or hourly; ?since= is a duration such as 6h.
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request, serviceID string) {
+	resolution := state.MetricResolutionRaw
+	switch r.URL.Query().Get("resolution") {
+	case "", "raw":
+	case "hourly":
+		resolution = state.MetricResolutionHourly
+	default:
+		http.Error(w, "invalid resolution (use raw or hourly)", http.StatusBadRequest)
+		return
+	}
+
+	var since time.Time
+	if raw := r.URL.Query().Get("since"); raw != "" {
+		d, err := time.ParseDuration(raw)
+		if err != nil || d <= 0 {
+			http.Error(w, "invalid since duration", http.StatusBadRequest)
+			return
+		}
+		since = time.Now().Add(-d)
+	}
+
+	samples, err := s.state.ListMetricSamples(serviceID, resolution, since)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if samples == nil {
+		samples = []state.MetricSample{}
+	}
+	writeJSON(w, http.StatusOK, samples)
+}
+
+func streamStartID(r *http.Request) (int64, error) {
+	raw := r.Header.Get("Last-Event-ID")
+	if raw == "" {
+		raw = r.URL.Query().Get("after")
+	}
+	if raw == "" {
+		return 0, nil
+	}
+	id, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || id < 0 {
+		return 0, fmt.Errorf("invalid log id %q", raw)
+	}
+	return id, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(value)
+}
@@ -0,0 +1,136 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/buildvigil/agent/internal/state"
+)
+
+func setupTestServer(t *testing.T) (*Server, *state.Manager) {
+	t.Helper()
+	// A file database so the stream handler's connection sees the same data.
+	stateMgr, err := state.NewManager(filepath.Join(t.TempDir(), "state.db"))
+	if err != nil {
+		t.Fatalf("Failed to create state manager: %v", err)
+	}
+	t.Cleanup(func() { stateMgr.Close() })
+	return NewServer(stateMgr), stateMgr
+}
+
+func TestHandleLogs_ReturnsOldestFirst(t *testing.T) {
+	t.Logf("Testing admin log listing...")
+	server, stateMgr := setupTestServer(t)
+
+	stateMgr.LogServiceMessage("svc-1", "info", "first")
+	stateMgr.LogServiceMessage("svc-1", "error", "second")
+
+	rec := httptest.NewRecorder()
+	server.mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/services/svc-1/logs?limit=10", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var entries []LogEntry
+	if err := json.NewDecoder(rec.Body).Decode(&entries); err != nil {
+		t.Fatalf("Failed to decode logs: %v", err)
+	}
+	if len(entries) != 2 || entries[0].Message != "first" || entries[1].Message != "second" {
+		t.Errorf("Expected [first second], got %+v", entries)
+	}
+	t.Logf("✓ Logs returned oldest first")
+}
+
+func TestHandleLogs_FiltersByPreviousDeploy(t *testing.T) {
+	t.Logf("Testing admin log filtering by deploy...")
+	server, stateMgr := setupTestServer(t)
+
+	stateMgr.LogServiceMessageTagged("svc-1", "d1", "c1", "error", "old crash")
+	stateMgr.LogServiceMessageTagged("svc-1", "d2", "c2", "info", "new start")
+
+	rec := httptest.NewRecorder()
+	server.mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/services/svc-1/logs?deploy=previous", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var entries []LogEntry
+	if err := json.NewDecoder(rec.Body).Decode(&entries); err != nil {
+		t.Fatalf("Failed to decode logs: %v", err)
+	}
+	if len(entries) != 1 || entries[0].DeployID != "d1" || entries[0].ContainerID != "c1" {
+		t.Errorf("Expected only the previous deploy's log, got %+v", entries)
+	}
+	t.Logf("✓ Logs filtered to the previous deploy")
+}
+
+func TestStreamLogs_FollowsNewEntries(t *testing.T) {
+	t.Logf("Testing admin log streaming over the unix socket...")
+	server, stateMgr := setupTestServer(t)
+
+	socketPath := filepath.Join(t.TempDir(), "admin.sock")
+	if err := server.ListenUnix(socketPath); err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer server.Stop()
+
+	stateMgr.LogServiceMessage("svc-1", "info", "before")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	received := make(chan LogEntry, 10)
+	go func() {
+		NewSocketClient(socketPath).StreamLogs(ctx, "svc-1", 0, func(entry LogEntry) {
+			received <- entry
+		})
+	}()
+
+	first := <-received
+	if first.Message != "before" {
+		t.Fatalf("Expected existing entry first, got %+v", first)
+	}
+
+	stateMgr.LogServiceMessage("svc-1", "info", "after")
+	select {
+	case entry := <-received:
+		if entry.Message != "after" || entry.ID <= first.ID {
+			t.Errorf("Expected followed entry 'after' with increasing ID, got %+v", entry)
+		}
+	case <-ctx.Done():
+		t.Fatal("Timed out waiting for followed log entry")
+	}
+	t.Logf("✓ New log entries streamed to client")
+}
+
+func TestHandleMetrics(t *testing.T) {
+	t.Logf("Testing admin metrics endpoint...")
+	server, stateMgr := setupTestServer(t)
+
+	stateMgr.RecordMetricSample(state.MetricSample{ServiceID: "svc-1", BucketStart: time.Now(), CPUPercent: 42})
+
+	rec := httptest.NewRecorder()
+	server.mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/services/svc-1/metrics?since=1h", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var samples []state.MetricSample
+	if err := json.NewDecoder(rec.Body).Decode(&samples); err != nil {
+		t.Fatalf("Failed to decode metrics: %v", err)
+	}
+	if len(samples) != 1 || samples[0].CPUPercent != 42 {
+		t.Errorf("Expected one sample, got %+v", samples)
+	}
+
+	rec = httptest.NewRecorder()
+	server.mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/services/svc-1/metrics?resolution=daily", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for unknown resolution, got %d", rec.Code)
+	}
+	t.Logf("✓ Metrics served")
+}
@@ -0,0 +1,455 @@
+// Package alerts evaluates simple alert rules locally on the agent, so that
+// service outages, crash loops, full disks and expiring certificates are
+// noticed even while the control plane is unreachable.
+package alerts
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/buildvigil/agent/internal/api"
+	"github.com/buildvigil/agent/internal/container"
+	"github.com/buildvigil/agent/internal/metrics"
+	"github.com/buildvigil/agent/internal/platform"
+	"github.com/buildvigil/agent/internal/state"
+)
+
+// Rule names used in Alert.Rule.
+const (
+	RuleServiceDown = "service_down"
+	RuleRestarts    = "restart_loop"
+	RuleCrashBudget = "crash_budget"
+	RuleDisk        = "disk_usage"
+	RuleCertExpiry  = "cert_expiry"
+	RuleCertCheck   = "cert_check_failed"
+)
+
+// Alert states.
+const (
+	StateFiring   = "firing"
+	StateResolved = "resolved"
+)
+
+const (
+	// certCheckInterval limits how often public certificates are fetched.
+	certCheckInterval = 6 * time.Hour
+	// staleAvailability is how old the latest probe may be before the
+	// service-down rule ignores it.
+	staleAvailability = 5 * time.Minute
+	// certFailureThreshold is how many consecutive failed certificate checks
+	// fire RuleCertCheck.
+	certFailureThreshold = 3
+)
+
+var (
+	diskUsage         = platform.DiskUsage
+	containerRestarts = defaultContainerRestarts
+	fetchCertExpiry   = defaultFetchCertExpiry
+)
+
+// Rules holds alert thresholds. A zero threshold disables its rule.
+type Rules struct {
+	ServiceDownMinutes int
+	RestartsPerHour    int
+	DiskPercent        int
+	// CrashesPerHour and CrashesPerDay are a service's crash budget: how
+	// many times its container may exit on its own in a rolling hour or day.
+	CrashesPerHour int
+	CrashesPerDay  int
+	CertExpiryDays int
+}
+
+// Alert is a rule firing or resolving for a subject (a service ID, a path or
+// a hostname). It is passed to alert plugins as JSON.
+type Alert struct {
+	Rule      string    `json:"rule"`
+	State     string    `json:"state"`
+	ServiceID string    `json:"service_id,omitempty"`
+	Subject   string    `json:"subject"`
+	Message   string    `json:"message"`
+	FiredAt   time.Time `json:"fired_at"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+func (a Alert) key() string {
+	return a.Rule + "/" + a.Subject
+}
+
+type restartSample struct {
+	at    time.Time
+	count int
+}
+
+// Evaluator checks Rules on an interval and reports transitions.
+type Evaluator struct {
+	state    *state.Manager
+	rules    Rules
+	targets  func() []metrics.Target
+	diskPath string
+	notify   func(Alert)
+	interval time.Duration
+	now      func() time.Time
+
+	active       map[string]Alert
+	restarts     map[string][]restartSample
+	certMu       sync.Mutex
+	certs        map[string]time.Time
+	certFailures map[string]int
+	certChecked  time.Time
+
+	stopChan chan struct{}
+	done     chan struct{}
+}
+
+// NewEvaluator creates an evaluator. diskPath is the filesystem checked by the
+// disk rule and notify, which may be nil, is called on every transition.
+func NewEvaluator(stateMgr *state.Manager, rules Rules, targets func() []metrics.Target, diskPath string, notify func(Alert), interval time.Duration) *Evaluator {
+	return &Evaluator{
+		state:    stateMgr,
+		rules:    rules,
+		targets:  targets,
+		diskPath: diskPath,
+		notify:   notify,
+		interval: interval,
+		now:      time.Now,
+		active:   map[string]Alert{},
+		restarts: map[string][]restartSample{},
+		certs:    map[string]time.Time{},
+
+		certFailures: map[string]int{},
+	}
+}
+
+// Start evaluates every interval until Stop.
+func (e *Evaluator) Start() {
+	e.stopChan = make(chan struct{})
+	e.done = make(chan struct{})
+	go func() {
+		defer close(e.done)
+		ticker := time.NewTicker(e.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-e.stopChan:
+				return
+			case <-ticker.C:
+				e.Evaluate()
+			}
+		}
+	}()
+}
+
+// Stop stops evaluation and waits for an in-flight round to finish.
+func (e *Evaluator) Stop() {
+	if e.stopChan == nil {
+		return
+	}
+	close(e.stopChan)
+	<-e.done
+}
+
+// Active returns the currently firing alerts ordered by rule and subject.
+func (e *Evaluator) Active() []Alert {
+	alerts := make([]Alert, 0, len(e.active))
+	for _, alert := range e.active {
+		alerts = append(alerts, alert)
+	}
+	sort.Slice(alerts, func(i, j int) bool { return alerts[i].key() < alerts[j].key() })
+	return alerts
+}
+
+// Evaluate checks every rule once, firing new alerts and resolving cleared ones.
+func (e *Evaluator) Evaluate() {
+	now := e.now()
+	var targets []metrics.Target
+	if e.targets != nil {
+		targets = e.targets()
+	}
+
+	var firing []Alert
+	firing = append(firing, e.checkServiceDown(targets, now)...)
+	firing = append(firing, e.checkRestarts(targets, now)...)
+	firing = append(firing, e.checkCrashBudget(targets, now)...)
+	firing = append(firing, e.checkDisk()...)
+	firing = append(firing, e.checkCerts(targets, now)...)
+
+	current := map[string]bool{}
+	for _, alert := range firing {
+		current[alert.key()] = true
+		if _, ok := e.active[alert.key()]; ok {
+			continue
+		}
+		alert.State = StateFiring
+		alert.FiredAt = now
+		alert.Timestamp = now
+		e.active[alert.key()] = alert
+		e.report(alert)
+	}
+	for key, alert := range e.active {
+		if current[key] {
+			continue
+		}
+		delete(e.active, key)
+		alert.State = StateResolved
+		alert.Timestamp = now
+		e.report(alert)
+	}
+}
+
+func (e *Evaluator) report(alert Alert) {
+	log.Printf("[Alerts] Alert %s: rule=%s subject=%s message=%s", alert.State, alert.Rule, alert.Subject, alert.Message)
+	eventType := "alert"
+	if alert.State == StateResolved {
+		eventType = "alert_resolved"
+	}
+	if err := e.state.RecordEvent(alert.ServiceID, eventType, fmt.Sprintf("%s: %s", alert.Rule, alert.Message)); err != nil {
+		log.Printf("[Alerts] Failed to record alert event: %v", err)
+	}
+	if e.notify != nil {
+		e.notify(alert)
+	}
+}
+
+// checkServiceDown fires when a service's latest probes have been unhealthy
+// for at least ServiceDownMinutes.
+func (e *Evaluator) checkServiceDown(targets []metrics.Target, now time.Time) []Alert {
+	if e.rules.ServiceDownMinutes <= 0 {
+		return nil
+	}
+	threshold := time.Duration(e.rules.ServiceDownMinutes) * time.Minute
+	var alerts []Alert
+	for _, target := range targets {
+		latest, err := e.state.LatestAvailability(target.ServiceID)
+		if err != nil || latest == nil || latest.Healthy {
+			continue
+		}
+		// Stale intervals mean probing stopped; don't alert on old data.
+		if now.Sub(latest.EndedAt) > staleAvailability {
+			continue
+		}
+		down := latest.EndedAt.Sub(latest.StartedAt)
+		if down < threshold {
+			continue
+		}
+		alerts = append(alerts, Alert{
+			Rule:      RuleServiceDown,
+			ServiceID: target.ServiceID,
+			Subject:   target.ServiceID,
+			Message:   fmt.Sprintf("service unhealthy for %s", down.Round(time.Minute)),
+		})
+	}
+	return alerts
+}
+
+// checkRestarts fires when a service's container restarted more than
+// RestartsPerHour times within the last hour.
+func (e *Evaluator) checkRestarts(targets []metrics.Target, now time.Time) []Alert {
+	if e.rules.RestartsPerHour <= 0 {
+		return nil
+	}
+	var alerts []Alert
+	seen := map[string]bool{}
+	for _, target := range targets {
+		seen[target.ServiceID] = true
+		count, err := containerRestarts(target.ContainerName)
+		if err != nil {
+			continue
+		}
+
+		samples := e.restarts[target.ServiceID]
+		// A lower count means the container was replaced by a deploy.
+		if len(samples) > 0 && count < samples[len(samples)-1].count {
+			samples = nil
+		}
+		samples = append(samples, restartSample{at: now, count: count})
+		for len(samples) > 1 && now.Sub(samples[0].at) > time.Hour {
+			samples = samples[1:]
+		}
+		e.restarts[target.ServiceID] = samples
+
+		restarts := count - samples[0].count
+		if restarts > e.rules.RestartsPerHour {
+			alerts = append(alerts, Alert{
+				Rule:      RuleRestarts,
+				ServiceID: target.ServiceID,
+				Subject:   target.ServiceID,
+				Message:   fmt.Sprintf("container restarted %d times in the last hour", restarts),
+			})
+		}
+	}
+	for serviceID := range e.restarts {
+		if !seen[serviceID] {
+			delete(e.restarts, serviceID)
+		}
+	}
+	return alerts
+}
+
+// checkCrashBudget fires when a service's container exited on its own more
+// than CrashesPerHour times in the last hour or CrashesPerDay times in the
+// last day. Unlike checkRestarts it counts across deploys and agent restarts,
+// so a service that crashes slowly enough to stay under RestartsPerHour is
+// still noticed.
+func (e *Evaluator) checkCrashBudget(targets []metrics.Target, now time.Time) []Alert {
+	if e.rules.CrashesPerHour <= 0 && e.rules.CrashesPerDay <= 0 {
+		return nil
+	}
+	var alerts []Alert
+	for _, target := range targets {
+		for _, budget := range []struct {
+			limit  int
+			window time.Duration
+			label  string
+		}{
+			{e.rules.CrashesPerHour, time.Hour, "hour"},
+			{e.rules.CrashesPerDay, 24 * time.Hour, "day"},
+		} {
+			if budget.limit <= 0 {
+				continue
+			}
+			crashes, err := e.state.CountServiceRestarts(target.ServiceID, now.Add(-budget.window))
+			if err != nil || crashes <= budget.limit {
+				continue
+			}
+			alerts = append(alerts, Alert{
+				Rule:      RuleCrashBudget,
+				ServiceID: target.ServiceID,
+				Subject:   target.ServiceID,
+				Message:   fmt.Sprintf("container crashed %d times in the last %s (budget %d)", crashes, budget.label, budget.limit),
+			})
+			break
+		}
+	}
+	return alerts
+}
+
+// checkDisk fires when the data filesystem is fuller than DiskPercent.
+func (e *Evaluator) checkDisk() []Alert {
+	if e.rules.DiskPercent <= 0 || e.diskPath == "" {
+		return nil
+	}
+	used, total, err := diskUsage(e.diskPath)
+	if err != nil || total == 0 {
+		return nil
+	}
+	percent := float64(used) * 100 / float64(total)
+	if percent < float64(e.rules.DiskPercent) {
+		return nil
+	}
+	return []Alert{{
+		Rule:    RuleDisk,
+		Subject: e.diskPath,
+		Message: fmt.Sprintf("disk %.1f%% full (threshold %d%%)", percent, e.rules.DiskPercent),
+	}}
+}
+
+// checkCerts fires when the certificate served for a service's public
+// hostname expires within CertExpiryDays, or when it could not be fetched
+// certFailureThreshold times in a row. Certificates are refetched at most
+// every certCheckInterval.
+func (e *Evaluator) checkCerts(targets []metrics.Target, now time.Time) []Alert {
+	if e.rules.CertExpiryDays <= 0 {
+		return nil
+	}
+	if now.Sub(e.certChecked) >= certCheckInterval {
+		certs := map[string]time.Time{}
+		failures := map[string]int{}
+		for _, target := range targets {
+			if target.Hostname == "" {
+				continue
+			}
+			expiry, err := fetchCertExpiry(target.Hostname)
+			if err != nil {
+				log.Printf("[Alerts] Failed to check certificate: host=%s err=%v", target.Hostname, err)
+				failures[target.Hostname] = e.certFailures[target.Hostname] + 1
+				continue
+			}
+			certs[target.Hostname] = expiry
+		}
+		e.certMu.Lock()
+		e.certs = certs
+		e.certFailures = failures
+		e.certMu.Unlock()
+		e.certChecked = now
+	}
+
+	threshold := time.Duration(e.rules.CertExpiryDays) * 24 * time.Hour
+	var alerts []Alert
+	for _, target := range targets {
+		if e.certFailures[target.Hostname] >= certFailureThreshold {
+			alerts = append(alerts, Alert{
+				Rule:      RuleCertCheck,
+				ServiceID: target.ServiceID,
+				Subject:   target.Hostname,
+				Message:   fmt.Sprintf("certificate for %s could not be checked %d times in a row", target.Hostname, e.certFailures[target.Hostname]),
+			})
+		}
+		expiry, ok := e.certs[target.Hostname]
+		if !ok || expiry.Sub(now) > threshold {
+			continue
+		}
+		alerts = append(alerts, Alert{
+			Rule:      RuleCertExpiry,
+			ServiceID: target.ServiceID,
+			Subject:   target.Hostname,
+			Message:   fmt.Sprintf("certificate for %s expires %s", target.Hostname, expiry.Format(time.RFC3339)),
+		})
+	}
+	return alerts
+}
+
+// Certificates returns the last checked expiry of each public hostname's
+// certificate, ordered by hostname. It is safe to call concurrently with
+// evaluation.
+func (e *Evaluator) Certificates() []api.CertificateStatus {
+	if e == nil {
+		return nil
+	}
+	e.certMu.Lock()
+	defer e.certMu.Unlock()
+	now := e.now()
+	statuses := make([]api.CertificateStatus, 0, len(e.certs))
+	for hostname, expiry := range e.certs {
+		statuses = append(statuses, api.CertificateStatus{
+			Hostname:      hostname,
+			ExpiresAt:     expiry.UTC(),
+			DaysRemaining: int(expiry.Sub(now).Hours() / 24),
+		})
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Hostname < statuses[j].Hostname })
+	return statuses
+}
+
+func defaultContainerRestarts(containerName string) (int, error) {
+	output, err := container.DockerOutput(container.DockerInspect, "inspect", "--format", "{{.RestartCount}}", containerName)
+	if err != nil {
+		return 0, fmt.Errorf("docker inspect failed: %w", err)
+	}
+	return strconv.Atoi(strings.TrimSpace(string(output)))
+}
+
+func defaultFetchCertExpiry(hostname string) (time.Time, error) {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	conn, err := tls.DialWithDialer(dialer, "tcp", net.JoinHostPort(hostname, "443"), &tls.Config{
+		ServerName: hostname,
+		// Only the expiry date is read; an already expired certificate must
+		// still be reported rather than fail the handshake.
+		InsecureSkipVerify: true,
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer conn.Close()
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return time.Time{}, fmt.Errorf("no certificate presented")
+	}
+	return certs[0].NotAfter, nil
+}
@@ -0,0 +1,184 @@
+package alerts
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/buildvigil/agent/internal/metrics"
+	"github.com/buildvigil/agent/internal/state"
+)
+
+func TestEvaluator_FiresAndResolves(t *testing.T) {
+	t.Logf("Testing local alert evaluation...")
+
+	stateMgr, err := state.NewManager(filepath.Join(t.TempDir(), "state.db"))
+	if err != nil {
+		t.Fatalf("Failed to create state manager: %v", err)
+	}
+	defer stateMgr.Close()
+
+	restarts := 0
+	diskUsed := uint64(50)
+	origRestarts, origDisk, origCert := containerRestarts, diskUsage, fetchCertExpiry
+	containerRestarts = func(string) (int, error) { return restarts, nil }
+	diskUsage = func(string) (uint64, uint64, error) { return diskUsed, 100, nil }
+	defer func() { containerRestarts, diskUsage, fetchCertExpiry = origRestarts, origDisk, origCert }()
+
+	clock := time.Date(2026, 6, 1, 10, 0, 0, 0, time.UTC)
+	fetchCertExpiry = func(string) (time.Time, error) { return clock.Add(3 * 24 * time.Hour), nil }
+
+	var notified []Alert
+	evaluator := NewEvaluator(stateMgr,
+		Rules{ServiceDownMinutes: 5, RestartsPerHour: 3, DiskPercent: 90, CertExpiryDays: 14},
+		func() []metrics.Target {
+			return []metrics.Target{{ServiceID: "svc", ContainerName: "potato-cloud-svc", Hostname: "app.example.com"}}
+		},
+		"/var/lib/potato-cloud",
+		func(alert Alert) { notified = append(notified, alert) },
+		time.Minute)
+	evaluator.now = func() time.Time { return clock }
+
+	for m := 0; m <= 6; m++ {
+		if err := stateMgr.RecordAvailability("svc", false, clock.Add(time.Duration(m-6)*time.Minute), 2*time.Minute); err != nil {
+			t.Fatalf("Failed to record availability: %v", err)
+		}
+	}
+	evaluator.Evaluate()
+
+	fired := map[string]bool{}
+	for _, alert := range notified {
+		fired[alert.Rule] = alert.State == StateFiring
+	}
+	if !fired[RuleServiceDown] || !fired[RuleCertExpiry] || fired[RuleDisk] || fired[RuleRestarts] {
+		t.Fatalf("Expected service_down and cert_expiry to fire, got %+v", notified)
+	}
+
+	// Crash loop and full disk fire; an unchanged alert is not re-notified.
+	notified = nil
+	clock = clock.Add(time.Minute)
+	restarts = 4
+	diskUsed = 95
+	stateMgr.RecordAvailability("svc", true, clock, 2*time.Minute)
+	evaluator.Evaluate()
+
+	states := map[string]string{}
+	for _, alert := range notified {
+		states[alert.Rule] = alert.State
+	}
+	if states[RuleRestarts] != StateFiring || states[RuleDisk] != StateFiring || states[RuleServiceDown] != StateResolved {
+		t.Errorf("Unexpected transitions: %+v", notified)
+	}
+	if _, ok := states[RuleCertExpiry]; ok {
+		t.Errorf("Expected still-firing cert alert not to be re-notified")
+	}
+	if len(evaluator.Active()) != 3 {
+		t.Errorf("Expected 3 active alerts, got %+v", evaluator.Active())
+	}
+
+	events, err := stateMgr.ListRecentEvents(10)
+	if err != nil {
+		t.Fatalf("Failed to list events: %v", err)
+	}
+	counts := map[string]int{}
+	for _, event := range events {
+		counts[event.EventType]++
+	}
+	if counts["alert"] != 4 || counts["alert_resolved"] != 1 {
+		t.Errorf("Expected alert events in state, got %v", counts)
+	}
+
+	t.Logf("✓ Alerts fire once, resolve, and are recorded")
+}
+
+func TestEvaluator_CertificateChecks(t *testing.T) {
+	t.Logf("Testing certificate tracking...")
+
+	stateMgr, err := state.NewManager(filepath.Join(t.TempDir(), "state.db"))
+	if err != nil {
+		t.Fatalf("Failed to create state manager: %v", err)
+	}
+	defer stateMgr.Close()
+
+	clock := time.Date(2026, 6, 1, 10, 0, 0, 0, time.UTC)
+	expiry := clock.Add(40 * 24 * time.Hour)
+	origCert := fetchCertExpiry
+	fetchCertExpiry = func(hostname string) (time.Time, error) {
+		if hostname == "broken.example.com" {
+			return time.Time{}, errors.New("handshake failed")
+		}
+		return expiry, nil
+	}
+	defer func() { fetchCertExpiry = origCert }()
+
+	evaluator := NewEvaluator(stateMgr, Rules{CertExpiryDays: 14},
+		func() []metrics.Target {
+			return []metrics.Target{
+				{ServiceID: "a", Hostname: "app.example.com"},
+				{ServiceID: "b", Hostname: "broken.example.com"},
+			}
+		}, "", nil, time.Minute)
+	evaluator.now = func() time.Time { return clock }
+
+	for i := 0; i < certFailureThreshold; i++ {
+		if len(evaluator.Active()) != 0 {
+			t.Fatalf("Expected no alert before %d failures, got %+v", certFailureThreshold, evaluator.Active())
+		}
+		evaluator.Evaluate()
+		clock = clock.Add(certCheckInterval)
+	}
+	active := evaluator.Active()
+	if len(active) != 1 || active[0].Rule != RuleCertCheck || active[0].Subject != "broken.example.com" {
+		t.Errorf("Expected repeated check failures to alert, got %+v", active)
+	}
+
+	// Three checks 6h apart leave the clock 18h later: 39.25 days remaining.
+	certs := evaluator.Certificates()
+	if len(certs) != 1 || certs[0].Hostname != "app.example.com" || certs[0].DaysRemaining != 39 {
+		t.Errorf("Unexpected certificate statuses: %+v", certs)
+	}
+	t.Logf("✓ Expiry tracked per hostname and repeated failures alert")
+}
+
+func TestEvaluator_CrashBudget(t *testing.T) {
+	t.Logf("Testing the crash budget over rolling windows...")
+
+	stateMgr, err := state.NewManager(filepath.Join(t.TempDir(), "state.db"))
+	if err != nil {
+		t.Fatalf("Failed to create state manager: %v", err)
+	}
+	defer stateMgr.Close()
+
+	clock := time.Date(2026, 6, 1, 10, 0, 0, 0, time.UTC)
+	var notified []Alert
+	evaluator := NewEvaluator(stateMgr, Rules{CrashesPerHour: 3, CrashesPerDay: 5},
+		func() []metrics.Target {
+			return []metrics.Target{{ServiceID: "web"}, {ServiceID: "api"}}
+		}, "", func(alert Alert) { notified = append(notified, alert) }, time.Minute)
+	evaluator.now = func() time.Time { return clock }
+
+	// Six crashes spread over the day stay under the hourly budget but
+	// exceed the daily one.
+	for i := 1; i <= 6; i++ {
+		stateMgr.RecordServiceRestart("web", "potato-cloud-web", 1, false, clock.Add(-time.Duration(i)*3*time.Hour))
+	}
+	stateMgr.RecordServiceRestart("api", "potato-cloud-api", 1, false, clock.Add(-time.Minute))
+	evaluator.Evaluate()
+
+	if len(notified) != 1 || notified[0].Rule != RuleCrashBudget || notified[0].ServiceID != "web" || notified[0].State != StateFiring {
+		t.Fatalf("Expected the daily crash budget to fire for web, got %+v", notified)
+	}
+	if notified[0].Message != "container crashed 6 times in the last day (budget 5)" {
+		t.Errorf("Unexpected message %q", notified[0].Message)
+	}
+
+	// Once the oldest crashes leave the window the alert resolves.
+	notified = nil
+	clock = clock.Add(7 * time.Hour)
+	evaluator.Evaluate()
+	if len(notified) != 1 || notified[0].State != StateResolved {
+		t.Errorf("Expected the crash budget alert to resolve, got %+v", notified)
+	}
+	t.Logf("✓ Crash budget fires and resolves with the rolling window")
+}
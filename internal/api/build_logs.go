@@ -0,0 +1,41 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// BuildLog is the Docker build output of one deployment.
+type BuildLog struct {
+	ServiceID  string    `json:"service_id"`
+	DeployID   string    `json:"deploy_id"`
+	GitCommit  string    `json:"git_commit,omitempty"`
+	Status     string    `json:"status"` // "succeeded", "failed" or "timed_out"
+	Builder    string    `json:"builder,omitempty"`
+	Output     string    `json:"output"`
+	Truncated  bool      `json:"truncated,omitempty"` // The earliest lines were dropped
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+}
+
+// UploadBuildLog sends a deployment's build output to the control plane.
+func (c *Client) UploadBuildLog(ctx context.Context, buildLog BuildLog) error {
+	body, err := json.Marshal(buildLog)
+	if err != nil {
+		return fmt.Errorf("failed to marshal build log: %w", err)
+	}
+
+	resp, err := c.do(ctx, "POST", "/api/agents/build-logs", body, "application/json")
+	if err != nil {
+		return fmt.Errorf("failed to upload build log: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("build log upload failed with status: %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -1,26 +1,93 @@
 package api
 
 import (
-	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
+
+	"google.golang.org/grpc"
 )
 
 // Client communicates with the control plane
 type Client struct {
-	baseURL            string
 	agentID            string
 	accessClientID     string
 	accessClientSecret string
+	apiKey             string
 	httpClient         *http.Client
+
+	// baseURLs holds the primary control plane URL followed by fallbacks;
+	// active indexes the one in use.
+	mu        sync.Mutex
+	baseURLs  []string
+	active    int
+	lastProbe time.Time
+	now       func() time.Time
+
+	// services caches definitions fetched by ServiceRef.
+	services map[string]cachedService
+
+	// agentInfo identifies the agent build on every request.
+	agentInfo *AgentInfo
+
+	// retry applies to desired state fetches and heartbeats.
+	retry RetryPolicy
+
+	// lastStates holds the last desired state fetched for each stack. Its
+	// ETag is sent as If-None-Match, and a 304 reuses it.
+	lastStates map[string]cachedState
+
+	// tlsConfig is built by SetClientTLS and SetExtraCAs from the client
+	// certificate, the control plane CA bundle and the extra CAs, and shared
+	// by the gRPC transport.
+	tlsConfig  *tls.Config
+	clientCert func(*tls.CertificateRequestInfo) (*tls.Certificate, error)
+	caBundle   []byte
+	extraCAs   []byte
+	// grpc sends desired state fetches, heartbeats and lifecycle events
+	// over gRPC instead of HTTP; see SetTransport. grpcConns holds its
+	// connections, keyed by control plane URL.
+	grpc      bool
+	grpcConns map[string]*grpc.ClientConn
+	// lifecycleFailures counts lifecycle streams in a row that failed to
+	// connect; each moves the next one on to another candidate URL.
+	lifecycleFailures int
+}
+
+type cachedState struct {
+	state *DesiredState
+	etag  string
+}
+
+// AgentInfo is the agent's build information, supported features and host
+// capabilities.
+type AgentInfo struct {
+	Version   string   `json:"version"`
+	Commit    string   `json:"commit,omitempty"`
+	BuildDate string   `json:"build_date,omitempty"`
+	GoVersion string   `json:"go_version"`
+	Platform  string   `json:"platform"`
+	Features  []string `json:"features"`
+	// Capabilities are what the host can do, detected at runtime: "docker",
+	// "ufw" and "cloudflared" when usable, and "runtime:<language>" per
+	// language services can be auto-containerized from.
+	Capabilities []string `json:"capabilities,omitempty"`
 }
 
 // NewClient creates a new API client
 func NewClient(baseURL, agentID, accessClientID, accessClientSecret string) *Client {
 	return &Client{
-		baseURL:            baseURL,
+		baseURLs:           []string{baseURL},
+		now:                time.Now,
 		agentID:            agentID,
 		accessClientID:     accessClientID,
 		accessClientSecret: accessClientSecret,
@@ -30,10 +97,27 @@ func NewClient(baseURL, agentID, accessClientID, accessClientSecret string) *Cli
 	}
 }
 
+// SetAgentInfo sends the agent's build information with every request, so
+// the control plane knows the agent version from its first state fetch.
+func (c *Client) SetAgentInfo(info AgentInfo) {
+	c.agentInfo = &info
+}
+
 func (c *Client) setAccessHeaders(req *http.Request) {
 	if c.agentID != "" {
 		req.Header.Set("X-Agent-Id", c.agentID)
 	}
+	if c.agentInfo != nil {
+		req.Header.Set("User-Agent", "potato-cloud-agent/"+c.agentInfo.Version)
+		req.Header.Set("X-Agent-Version", c.agentInfo.Version)
+		if c.agentInfo.Commit != "" {
+			req.Header.Set("X-Agent-Commit", c.agentInfo.Commit)
+		}
+		req.Header.Set("X-Agent-Features", strings.Join(c.agentInfo.Features, ","))
+	}
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
 	if c.accessClientID != "" {
 		req.Header.Set("CF-Access-Client-Id", c.accessClientID)
 	}
@@ -67,18 +151,128 @@ type Service struct {
 	DockerRunArgs       string            `json:"docker_run_args"`
 	BuildCommand        string            `json:"build_command"`
 	RunCommand          string            `json:"run_command"`
+	Entrypoint          string            `json:"entrypoint"` // Optional: replaces the image's entrypoint; words after the first lead the command
+	Workdir             string            `json:"workdir"`    // Optional: absolute working directory inside the container
 	Runtime             string            `json:"runtime"`
 	DockerfilePath      string            `json:"dockerfile_path"`
 	DockerContext       string            `json:"docker_context"`
+	BuildDir            string            `json:"build_dir"`
 	DockerContainerPort int               `json:"docker_container_port"`
 	ImageRetainCount    int               `json:"image_retain_count"`
+	ImageBudgetMB       int               `json:"image_budget_mb"`
 	BaseImage           string            `json:"base_image"` // Optional: override default base image
 	Language            string            `json:"language"`   // Language/runtime: nodejs, golang, python, rust, java, generic, auto
+	Arch                string            `json:"arch"`       // Optional: target architecture (amd64, arm64); defaults to host
 	Port                int               `json:"port"`
+	PortRange           string            `json:"port_range"`  // Optional: named host port range from the agent config
+	SinglePort          bool              `json:"single_port"` // Hold one host port between deploys instead of a blue/green pair
 	Hostname            string            `json:"hostname"`
+	HostHeader          string            `json:"host_header"` // Optional: "preserve" (default), "rewrite" to localhost, or a custom host sent upstream
 	HealthCheckPath     string            `json:"health_check_path"`
 	HealthCheckInterval int               `json:"health_check_interval"` // Defaults to global config
+	HealthCheckCommand  string            `json:"health_check_command"`  // Optional: run inside the container; exit 0 is healthy
+	RestartPolicy       string            `json:"restart_policy"`        // Docker restart policy; defaults to unless-stopped
+	StopSignal          string            `json:"stop_signal"`           // SIGTERM (default), SIGINT or SIGQUIT
+	StopTimeout         int               `json:"stop_timeout"`          // Seconds to wait before SIGKILL; defaults to 10
+	PreStopCommand      string            `json:"pre_stop_command"`      // Optional: run inside the container before stopping
+	WarmupPaths         []string          `json:"warmup_paths"`          // Optional: requested through the proxy after cutover
+	MaxDeployDuration   int               `json:"max_deploy_duration"`   // Seconds before a deploy is aborted; defaults to 30 minutes
+	TaskRetries         int               `json:"task_retries"`          // Task services: extra attempts after a failed run
+	TaskTimeout         int               `json:"task_timeout"`          // Task services: seconds per attempt; defaults to 1 hour
 	EnvironmentVars     map[string]string `json:"environment_vars"`
+	Secrets             []string          `json:"secrets"` // Optional: names of agent-stored secrets injected as env vars
+	Group               string            `json:"group"`   // Optional: ServiceGroup to inherit settings from
+	Sidecars            []Sidecar         `json:"sidecars"`
+	InitContainers      []InitContainer   `json:"init_containers"`
+	ConfigFiles         []ConfigFile      `json:"config_files"`
+	Volumes             []Volume          `json:"volumes"`
+	ReloadSignal        string            `json:"reload_signal"`    // Optional: SIGHUP, SIGUSR1 or SIGUSR2 sent instead of redeploying for config file edits
+	Timezone            string            `json:"timezone"`         // Optional: IANA zone such as Europe/Berlin, applied via TZ and /etc/localtime
+	Locale              string            `json:"locale"`           // Optional: such as en_US.UTF-8, applied via LANG and LC_ALL
+	Ulimits             map[string]string `json:"ulimits"`          // Optional: e.g. {"nofile": "65536"} or "soft:hard"; capped by the agent's max_ulimits
+	Sysctls             map[string]string `json:"sysctls"`          // Optional: namespaced kernel parameters such as net.core.somaxconn; limited to allowed_sysctls
+	ShmSizeMB           int               `json:"shm_size_mb"`      // Optional: size of /dev/shm; capped by the agent's max_shm_size_mb
+	CPULimit            float64           `json:"cpu_limit"`        // Optional: CPUs the container may use, e.g. 1.5
+	MemoryLimit         string            `json:"memory_limit"`     // Optional: memory the container may use, e.g. 512m or 2g; exceeding it gets the container OOM-killed
+	PidsLimit           int               `json:"pids_limit"`       // Optional: processes the container may run
+	Hold                bool              `json:"hold"`             // Optional: pause reconciliation; the running container is left as is
+	Protected           bool              `json:"protected"`        // Optional: changes to the running service wait for an explicit confirm
+	Dependencies        []Dependency      `json:"dependencies"`     // Optional: checked before each new container starts
+	SLO                 *ServiceSLO       `json:"slo"`              // Optional: objectives tracked by the external proxy
+	Canary              *ServiceCanary    `json:"canary,omitempty"` // Optional: bake new releases on part of the traffic before promoting them
+
+	// StackID is the stack the service belongs to when the agent serves
+	// several; see MergeDesiredStates. It is not part of the definition.
+	StackID string `json:"-"`
+}
+
+// ServiceSLO declares a service's objectives for requests through the
+// external proxy. Either objective may be left unset.
+type ServiceSLO struct {
+	LatencyMs       int     `json:"latency_ms"`        // Requests slower than this count against latency_target
+	LatencyTarget   float64 `json:"latency_target"`    // Fraction of requests faster than latency_ms, e.g. 0.99
+	ErrorRateTarget float64 `json:"error_rate_target"` // Highest acceptable fraction of 5xx responses, e.g. 0.001
+}
+
+// ServiceCanary makes blue/green deploys of a service canary deploys: the new
+// container first serves Weight percent of the service's traffic for the bake
+// period, and is promoted only if it stays healthy and its error rate stays
+// within MaxErrorRate.
+type ServiceCanary struct {
+	Weight       int     `json:"weight"`         // Percent of requests sent to the new container, 1-99
+	BakeSeconds  int     `json:"bake_seconds"`   // How long the canary is observed; defaults to 5 minutes
+	MaxErrorRate float64 `json:"max_error_rate"` // Highest acceptable fraction of 5xx responses; defaults to 0.05
+}
+
+// Dependency is an external service checked before a deploy starts the new
+// container. Set exactly one of TCP and HTTP; both may reference the service's
+// environment variables and secrets, e.g. "$DATABASE_URL".
+type Dependency struct {
+	Name    string `json:"name"`
+	TCP     string `json:"tcp"`     // host:port or a DSN such as postgres://user:pass@db:5432/app
+	HTTP    string `json:"http"`    // URL that must answer 200
+	Timeout int    `json:"timeout"` // Seconds; defaults to 5
+}
+
+// ConfigFile is a small file the agent writes on the host and bind mounts
+// read-only into the service's containers.
+type ConfigFile struct {
+	Path    string `json:"path"` // Absolute path inside the container
+	Content string `json:"content"`
+	Mode    string `json:"mode"` // Octal permissions; defaults to 0644
+}
+
+// Volume is storage mounted into a service's containers that outlives them.
+// A named volume is created for the service and shared by its blue and green
+// containers; a bind mount uses a host directory the agent allows.
+type Volume struct {
+	Name     string `json:"name"`      // Named volume; exclusive with HostPath
+	HostPath string `json:"host_path"` // Host directory to bind-mount; must be under the agent's allowed_bind_mounts
+	Path     string `json:"path"`      // Absolute path inside the container
+	ReadOnly bool   `json:"read_only"`
+}
+
+// InitContainer runs to completion before each new service container starts,
+// e.g. to wait for a schema or download assets into a shared volume.
+type InitContainer struct {
+	Name            string            `json:"name"`
+	Image           string            `json:"image"`
+	Command         string            `json:"command"`
+	EnvironmentVars map[string]string `json:"environment_vars"` // Added to the service's environment
+	DockerRunArgs   string            `json:"docker_run_args"`
+	Timeout         int               `json:"timeout"` // Seconds; defaults to 5 minutes
+}
+
+// Sidecar is a helper container (log shipper, database proxy) deployed,
+// health checked and stopped together with its service's container.
+type Sidecar struct {
+	Name               string            `json:"name"`
+	Image              string            `json:"image"`
+	Command            string            `json:"command"`
+	EnvironmentVars    map[string]string `json:"environment_vars"`
+	DockerRunArgs      string            `json:"docker_run_args"`
+	Network            string            `json:"network"` // "shared" (default) joins the service's network namespace; "stack" joins the stack network
+	HealthCheckCommand string            `json:"health_check_command"`
 }
 
 // DesiredState represents the full desired state from the control plane
@@ -86,39 +280,187 @@ type DesiredState struct {
 	StackID           string    `json:"stack_id"`
 	Version           int       `json:"version"`
 	Hash              string    `json:"hash"`
+	HashAlgorithm     string    `json:"hash_algorithm,omitempty"` // "services-sha256" has Hash verified on receipt
 	PollInterval      int       `json:"poll_interval"`
 	HeartbeatInterval int       `json:"heartbeat_interval"`
 	SecurityMode      string    `json:"security_mode"`
+	SSHPort           int       `json:"ssh_port,omitempty"`         // Optional: overrides the agent's ssh_port
+	SSHAllowedCIDR    string    `json:"ssh_allowed_cidr,omitempty"` // Optional: overrides the agent's ssh_allowed_cidr
 	ExternalProxyPort int       `json:"external_proxy_port"`
 	Services          []Service `json:"services"`
+
+	SyntheticChecks []SyntheticCheck `json:"synthetic_checks,omitempty"`
+	Groups          []ServiceGroup   `json:"groups,omitempty"`
+
+	// Features toggles agent behaviors for the stack, e.g.
+	// {"egress_control": false}. Flags the agent does not know are ignored.
+	Features map[string]bool `json:"features,omitempty"`
+
+	// Large stacks may page the service list (NextCursor) and send
+	// ServiceRefs instead of full definitions; GetDesiredState resolves both
+	// into Services.
+	NextCursor  string       `json:"next_cursor,omitempty"`
+	ServiceRefs []ServiceRef `json:"service_refs,omitempty"`
+
+	// NotModified is set when the control plane answered 304 and the
+	// previously fetched state was reused.
+	NotModified bool `json:"-"`
+
+	// Stale is set when the control plane was unreachable and the state was
+	// restored from the agent's local cache; see UnmarshalOfflineState.
+	Stale bool `json:"-"`
+
+	// canonicalServices holds each service definition as received, in
+	// canonical JSON, keyed by ID; see ServicesHash.
+	canonicalServices map[string]string
+	// etag is the ETag the first page was served with.
+	etag string
 }
 
-// GetDesiredState fetches the desired state from the control plane
-func (c *Client) GetDesiredState(stackID string) (*DesiredState, error) {
-	url := fmt.Sprintf("%s/api/stacks/%s/desired-state", c.baseURL, stackID)
+// ServiceRef identifies a service definition by the control plane's hash of
+// it, so unchanged definitions are not downloaded again.
+type ServiceRef struct {
+	ID   string `json:"id"`
+	Hash string `json:"hash"`
+}
 
-	req, err := http.NewRequest("GET", url, nil)
+// SyntheticCheck is an HTTP request the agent makes periodically against a
+// public URL, exercising DNS, the tunnel, the proxy and the container.
+type SyntheticCheck struct {
+	Name            string `json:"name"`
+	URL             string `json:"url"`
+	ExpectedStatus  int    `json:"expected_status,omitempty"`  // Defaults to 200
+	BodyContains    string `json:"body_contains,omitempty"`    // Optional: substring the body must contain
+	MaxLatencyMs    int    `json:"max_latency_ms,omitempty"`   // Optional: slower responses fail
+	IntervalSeconds int    `json:"interval_seconds,omitempty"` // Defaults to 60
+	TimeoutSeconds  int    `json:"timeout_seconds,omitempty"`  // Defaults to 10
+}
+
+// GetDesiredState fetches the desired state from the control plane,
+// following pages, fetching only service definitions whose hash changed, and
+// applying service groups. Failed requests are retried under the retry
+// policy until ctx is done.
+func (c *Client) GetDesiredState(ctx context.Context, stackID string) (*DesiredState, error) {
+	c.mu.Lock()
+	cached, etag := c.lastStates[stackID].state, c.lastStates[stackID].etag
+	c.mu.Unlock()
+
+	state, err := c.getDesiredStatePage(ctx, stackID, "", etag)
+	if errors.Is(err, errNotModified) && cached != nil {
+		reused := *cached
+		reused.Services = append([]Service(nil), cached.Services...)
+		reused.NotModified = true
+		return &reused, nil
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+		return nil, err
+	}
+	seen := map[string]bool{}
+	for pages := 1; state.NextCursor != ""; pages++ {
+		if pages >= maxDesiredStatePages || seen[state.NextCursor] {
+			return nil, fmt.Errorf("desired state pagination did not terminate")
+		}
+		seen[state.NextCursor] = true
+		page, err := c.getDesiredStatePage(ctx, stackID, state.NextCursor, "")
+		if err != nil {
+			return nil, err
+		}
+		state.Services = append(state.Services, page.Services...)
+		state.ServiceRefs = append(state.ServiceRefs, page.ServiceRefs...)
+		state.NextCursor = page.NextCursor
+		for id, canonical := range page.canonicalServices {
+			state.setCanonicalService(id, canonical)
+		}
+	}
+	if len(state.ServiceRefs) > 0 {
+		if err := c.resolveServiceRefs(ctx, stackID, state); err != nil {
+			return nil, err
+		}
+	}
+	if err := state.verifyHash(); err != nil {
+		// A cached definition may be the corrupt one; fetch all again next time.
+		c.mu.Lock()
+		c.services = nil
+		delete(c.lastStates, stackID)
+		c.mu.Unlock()
+		return nil, err
+	}
+	if err := state.ResolveGroups(); err != nil {
+		return nil, err
 	}
 
-	c.setAccessHeaders(req)
+	// Without an ETag header, the state's hash identifies it.
+	etag = state.etag
+	if etag == "" && state.Hash != "" {
+		etag = strconv.Quote(state.Hash)
+	}
+	c.mu.Lock()
+	if c.lastStates == nil {
+		c.lastStates = make(map[string]cachedState)
+	}
+	c.lastStates[stackID] = cachedState{state: state, etag: etag}
+	c.mu.Unlock()
+	return state, nil
+}
+
+// errNotModified is returned by getDesiredStatePage on a 304 response.
+var errNotModified = errors.New("desired state not modified")
 
-	resp, err := c.httpClient.Do(req)
+// getDesiredStatePage fetches one page of the desired state. etag, when
+// set, is sent as If-None-Match.
+func (c *Client) getDesiredStatePage(ctx context.Context, stackID, cursor, etag string) (*DesiredState, error) {
+	if c.UsesGRPC() {
+		return c.getDesiredStatePageGRPC(ctx, stackID, cursor, etag)
+	}
+	path := fmt.Sprintf("/api/stacks/%s/desired-state?services=refs", stackID)
+	if cursor != "" {
+		path += "&cursor=" + url.QueryEscape(cursor)
+	}
+	var header http.Header
+	if etag != "" {
+		header = http.Header{"If-None-Match": {etag}}
+	}
+	resp, err := c.doWithRetry(ctx, "GET", path, nil, "", header)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch desired state: %w", err)
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNotModified && etag != "" {
+		return nil, errNotModified
+	}
 	if resp.StatusCode != http.StatusOK {
 		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
 	}
 
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	state, err := decodeDesiredState(data)
+	if err != nil {
+		return nil, err
+	}
+	state.etag = resp.Header.Get("ETag")
+	return state, nil
+}
+
+// decodeDesiredState decodes one page of the desired state, keeping each
+// service definition as received for hash verification.
+func decodeDesiredState(data []byte) (*DesiredState, error) {
 	var state DesiredState
-	if err := json.NewDecoder(resp.Body).Decode(&state); err != nil {
+	if err := json.Unmarshal(data, &state); err != nil {
 		return nil, fmt.Errorf("failed to decode response: %w", err)
 	}
-
+	var raw struct {
+		Services []json.RawMessage `json:"services"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	if err := state.recordCanonicalServices(raw.Services); err != nil {
+		return nil, err
+	}
 	return &state, nil
 }
 
@@ -129,6 +471,175 @@ type HeartbeatRequest struct {
 	ServicesStatus []ServiceStatus        `json:"services_status"`
 	SecurityState  map[string]interface{} `json:"security_state"`
 	SystemInfo     map[string]interface{} `json:"system_info"`
+
+	SyntheticChecks []SyntheticResult   `json:"synthetic_checks,omitempty"`
+	Certificates    []CertificateStatus `json:"certificates,omitempty"`
+	Tasks           []TaskResult        `json:"tasks,omitempty"`
+	PendingActions  []PendingAction     `json:"pending_actions,omitempty"`
+	Agent           *AgentInfo          `json:"agent,omitempty"`
+	FeatureFlags    map[string]bool     `json:"feature_flags,omitempty"` // Effective value of every flag the agent knows
+	Apply           *ApplyReport        `json:"apply,omitempty"`
+	SLOs            []SLOStatus         `json:"slos,omitempty"`
+	System          *SystemMetrics      `json:"system,omitempty"`
+	Drift           *DriftReport        `json:"drift,omitempty"`
+	Stacks          []StackStatus       `json:"stacks,omitempty"` // Sent when the agent serves several stacks
+
+	// SentAt is when the heartbeat was collected. Replayed marks one
+	// delivered late from the offline queue, after a newer one.
+	SentAt   time.Time `json:"sent_at"`
+	Replayed bool      `json:"replayed,omitempty"`
+	// LifecycleEvents are the status transitions not streamed since the
+	// previous heartbeat, oldest first.
+	LifecycleEvents []LifecycleEvent `json:"lifecycle_events,omitempty"`
+}
+
+// StackStatus is the agent's view of one of the stacks it serves.
+type StackStatus struct {
+	StackID   string    `json:"stack_id"`
+	Version   int       `json:"version"` // Latest fetched version
+	Hash      string    `json:"hash,omitempty"`
+	Services  int       `json:"services"`
+	Applied   bool      `json:"applied"`          // The latest sync applied every service of the stack
+	Failed    []string  `json:"failed,omitempty"` // Services that failed in the latest sync
+	Error     string    `json:"error,omitempty"`  // Why the latest fetch failed; the previous state was kept
+	Stale     bool      `json:"stale,omitempty"`  // The latest fetch failed and an older state is in use
+	FetchedAt time.Time `json:"fetched_at"`
+}
+
+// SystemMetrics is the host's resource usage when a heartbeat is sent. Load
+// and memory are only reported on Linux.
+type SystemMetrics struct {
+	Load1                float64                `json:"load_1,omitempty"`
+	Load5                float64                `json:"load_5,omitempty"`
+	Load15               float64                `json:"load_15,omitempty"`
+	CPUCount             int                    `json:"cpu_count"`
+	MemoryTotalBytes     uint64                 `json:"memory_total_bytes,omitempty"`
+	MemoryAvailableBytes uint64                 `json:"memory_available_bytes,omitempty"`
+	DiskUsedBytes        uint64                 `json:"disk_used_bytes"` // Filesystem holding the data directory
+	DiskTotalBytes       uint64                 `json:"disk_total_bytes"`
+	DockerAvailable      bool                   `json:"docker_available"`
+	DockerError          string                 `json:"docker_error,omitempty"`
+	Services             []ServiceResourceUsage `json:"services,omitempty"`
+}
+
+// ServiceResourceUsage is a service container's CPU and memory use.
+type ServiceResourceUsage struct {
+	ServiceID   string  `json:"service_id"`
+	CPUPercent  float64 `json:"cpu_percent"`
+	MemoryBytes int64   `json:"memory_bytes"`
+}
+
+// DriftReport compares the desired state with the containers and routes the
+// agent is actually running.
+type DriftReport struct {
+	StackVersion int            `json:"stack_version"`
+	InSync       bool           `json:"in_sync"`
+	Services     []ServiceDrift `json:"services"`
+	GeneratedAt  time.Time      `json:"generated_at"`
+}
+
+// ServiceDrift is how one service differs from its desired state. Category
+// is "in_sync", "pending_deploy", "pending_removal", "failed",
+// "manual_override" or "held".
+type ServiceDrift struct {
+	ServiceID       string   `json:"service_id"`
+	Name            string   `json:"name,omitempty"`
+	Category        string   `json:"category"`
+	Differences     []string `json:"differences,omitempty"` // Any of "commit", "definition", "environment", "image", "replicas" and "route"
+	Detail          string   `json:"detail,omitempty"`      // Pending reason, last error or out-of-band change
+	DesiredCommit   string   `json:"desired_commit,omitempty"`
+	ActualCommit    string   `json:"actual_commit,omitempty"`
+	DesiredEnvHash  string   `json:"desired_env_hash,omitempty"`
+	ActualEnvHash   string   `json:"actual_env_hash,omitempty"`
+	Route           string   `json:"route,omitempty"`        // Desired hostname
+	ServicePort     int      `json:"service_port,omitempty"` // Port the service's active container listens on
+	RoutedPort      int      `json:"routed_port,omitempty"`  // Port the external proxy routes the hostname to
+	DesiredReplicas int      `json:"desired_replicas"`
+	RunningReplicas int      `json:"running_replicas"`
+}
+
+// SLOStatus is a service's compliance with its ServiceSLO.
+type SLOStatus struct {
+	ServiceID string      `json:"service_id"`
+	Status    string      `json:"status"` // "ok", "violated" (over the last hour) or "no_data"
+	Windows   []SLOWindow `json:"windows"`
+}
+
+// SLOWindow is SLO compliance over a rolling window. A burn rate is the
+// observed failure fraction divided by the fraction the objective allows: 1
+// spends the error budget exactly at the sustainable rate.
+type SLOWindow struct {
+	Window      string  `json:"window"` // "5m" or "1h"
+	Requests    uint64  `json:"requests"`
+	ErrorRate   float64 `json:"error_rate"`
+	SlowRate    float64 `json:"slow_rate"`
+	ErrorBurn   float64 `json:"error_burn,omitempty"`
+	LatencyBurn float64 `json:"latency_burn,omitempty"`
+	Compliant   bool    `json:"compliant"`
+}
+
+// ApplyReport is the outcome of the agent's latest sync of the desired state.
+// StackVersion in the heartbeat only advances once a state applies in full;
+// Succeeded and Failed show how far a partial apply got.
+type ApplyReport struct {
+	StackVersion int               `json:"stack_version"`
+	StateHash    string            `json:"state_hash"`
+	Partial      bool              `json:"partial"`
+	Succeeded    []string          `json:"succeeded"`
+	Failed       []string          `json:"failed,omitempty"`
+	Revisions    []AppliedRevision `json:"revisions,omitempty"`
+	FinishedAt   time.Time         `json:"finished_at"`
+}
+
+// AppliedRevision is the revision of a service last applied successfully.
+type AppliedRevision struct {
+	ServiceID    string    `json:"service_id"`
+	StackVersion int       `json:"stack_version"`
+	Revision     string    `json:"revision"` // definition hash and commit
+	AppliedAt    time.Time `json:"applied_at"`
+}
+
+// PendingAction is a change the agent has yet to apply to a service, such as
+// a failed deploy awaiting retry or one blocked by a hold.
+type PendingAction struct {
+	ServiceID     string    `json:"service_id"`
+	Action        string    `json:"action"` // "deploy", "sync_repo" or "remove"
+	Reason        string    `json:"reason"`
+	Revision      string    `json:"revision,omitempty"` // definition hash and commit the attempts apply to
+	Attempts      int       `json:"attempts"`
+	LastError     string    `json:"last_error,omitempty"`
+	NextAttemptAt time.Time `json:"next_attempt_at"`
+}
+
+// TaskResult is the outcome of the latest run of a task service.
+type TaskResult struct {
+	ServiceID  string    `json:"service_id"`
+	Revision   string    `json:"revision"`
+	Succeeded  bool      `json:"succeeded"`
+	ExitCode   int       `json:"exit_code"`
+	Attempts   int       `json:"attempts"`
+	DurationMs int64     `json:"duration_ms"`
+	Output     string    `json:"output,omitempty"` // Tail of the last attempt's output
+	Error      string    `json:"error,omitempty"`
+	FinishedAt time.Time `json:"finished_at"`
+}
+
+// CertificateStatus reports the certificate served for a public hostname.
+type CertificateStatus struct {
+	Hostname      string    `json:"hostname"`
+	ExpiresAt     time.Time `json:"expires_at"`
+	DaysRemaining int       `json:"days_remaining"`
+}
+
+// SyntheticResult is the latest outcome of a SyntheticCheck.
+type SyntheticResult struct {
+	Name       string    `json:"name"`
+	URL        string    `json:"url"`
+	OK         bool      `json:"ok"`
+	StatusCode int       `json:"status_code,omitempty"`
+	LatencyMs  int64     `json:"latency_ms"`
+	Error      string    `json:"error,omitempty"`
+	CheckedAt  time.Time `json:"checked_at"`
 }
 
 // ServiceStatus represents the status of a running service
@@ -140,33 +651,133 @@ type ServiceStatus struct {
 	RestartCount int    `json:"restart_count"`
 	LastError    string `json:"last_error,omitempty"`
 	HealthStatus string `json:"health_status,omitempty"`
+	Held         bool   `json:"held,omitempty"` // On hold locally; the agent is not reconciling it
+
+	Uptime     *UptimeSummary  `json:"uptime,omitempty"`
+	ImageDrift *ImageDrift     `json:"image_drift,omitempty"`
+	Restarts   *RestartSummary `json:"restarts,omitempty"`
+}
+
+// RestartSummary counts the times a service's container exited without the
+// agent or an operator stopping it, over rolling windows.
+type RestartSummary struct {
+	LastHour int `json:"last_hour"`
+	Last24h  int `json:"last_24h"`
+	// OOMKills24h counts the restarts in the last day where the kernel
+	// killed the container for exceeding its memory limit.
+	OOMKills24h int `json:"oom_kills_24h,omitempty"`
+}
+
+// ImageDrift reports a running container whose image differs from the one the
+// agent deployed, e.g. after a manual retag and restart.
+type ImageDrift struct {
+	Expected   string    `json:"expected"` // Image ID recorded at deploy
+	Running    string    `json:"running"`  // Image ID of the running container
+	DetectedAt time.Time `json:"detected_at"`
+}
+
+// UptimeSummary is the percentage of observed time a service was healthy over
+// rolling windows.
+type UptimeSummary struct {
+	Last24h float64 `json:"last_24h"`
+	Last7d  float64 `json:"last_7d"`
+	Last30d float64 `json:"last_30d"`
+}
+
+// HeartbeatResponse carries optional runtime settings returned by the control
+// plane in reply to a heartbeat.
+type HeartbeatResponse struct {
+	LogLevel      string            `json:"log_level,omitempty"`       // "info" or "debug"; empty clears a remote override
+	LogLevels     map[string]string `json:"log_levels,omitempty"`      // Per-module levels, e.g. {"service": "debug"}
+	LogLevelUntil *time.Time        `json:"log_level_until,omitempty"` // Optional: override expires at this time
+	Scopes        []string          `json:"scopes,omitempty"`          // Optional: scopes currently granted to the agent's API key
+}
+
+// StatusError is an unexpected HTTP status in reply to a call.
+type StatusError struct {
+	Op         string
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s failed with status: %d", e.Op, e.StatusCode)
 }
 
-// SendHeartbeat sends a heartbeat to the control plane
-func (c *Client) SendHeartbeat(req HeartbeatRequest) error {
-	url := fmt.Sprintf("%s/api/agents/heartbeat", c.baseURL)
+// Rejected reports whether the control plane refused a call outright: a 4xx
+// status other than 429, or a gRPC code not matching a 5xx, 429 or timeout.
+// Sending the same request again won't succeed, unlike after a network or
+// server error.
+func Rejected(err error) bool {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= 400 && statusErr.StatusCode < 500 && statusErr.StatusCode != http.StatusTooManyRequests
+	}
+	var grpcErr *GRPCError
+	return errors.As(err, &grpcErr) && !grpcRetryable(err)
+}
 
+// SendHeartbeat sends a heartbeat to the control plane, retrying failures
+// under the retry policy until ctx is done.
+func (c *Client) SendHeartbeat(ctx context.Context, req HeartbeatRequest) (*HeartbeatResponse, error) {
+	if c.UsesGRPC() {
+		var heartbeatResp HeartbeatResponse
+		if err := c.grpcInvoke(ctx, "Heartbeat", req, &heartbeatResp); err != nil {
+			return nil, fmt.Errorf("failed to send heartbeat: %w", err)
+		}
+		return &heartbeatResp, nil
+	}
 	body, err := json.Marshal(req)
 	if err != nil {
-		return fmt.Errorf("failed to marshal heartbeat: %w", err)
+		return nil, fmt.Errorf("failed to marshal heartbeat: %w", err)
 	}
 
-	httpReq, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	resp, err := c.doWithRetry(ctx, "POST", "/api/agents/heartbeat", body, "application/json", nil)
 	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+		return nil, fmt.Errorf("failed to send heartbeat: %w", err)
 	}
+	defer resp.Body.Close()
 
-	httpReq.Header.Set("Content-Type", "application/json")
-	c.setAccessHeaders(httpReq)
+	if resp.StatusCode != http.StatusOK {
+		return nil, &StatusError{Op: "heartbeat", StatusCode: resp.StatusCode}
+	}
 
-	resp, err := c.httpClient.Do(httpReq)
+	// Older control planes reply with an empty body; treat that as no settings.
+	var heartbeatResp HeartbeatResponse
+	if err := json.NewDecoder(resp.Body).Decode(&heartbeatResp); err != nil {
+		return &HeartbeatResponse{}, nil
+	}
+	return &heartbeatResp, nil
+}
+
+// DeployDiagnostics is a snapshot collected when a deployment fails.
+type DeployDiagnostics struct {
+	ServiceID        string    `json:"service_id"`
+	ServiceName      string    `json:"service_name"`
+	GitCommit        string    `json:"git_commit"`
+	Error            string    `json:"error"`
+	CollectedAt      time.Time `json:"collected_at"`
+	BuildLogTail     []string  `json:"build_log_tail,omitempty"`
+	ContainerName    string    `json:"container_name,omitempty"`
+	ContainerInspect string    `json:"container_inspect,omitempty"`
+	ServiceLogs      []string  `json:"service_logs,omitempty"`
+	HealthCheckTrace []string  `json:"health_check_trace,omitempty"`
+}
+
+// UploadDiagnostics sends a deployment failure diagnostics bundle to the control plane
+func (c *Client) UploadDiagnostics(bundle DeployDiagnostics) error {
+	body, err := json.Marshal(bundle)
 	if err != nil {
-		return fmt.Errorf("failed to send heartbeat: %w", err)
+		return fmt.Errorf("failed to marshal diagnostics: %w", err)
+	}
+
+	resp, err := c.do(context.Background(), "POST", "/api/agents/diagnostics", body, "application/json")
+	if err != nil {
+		return fmt.Errorf("failed to upload diagnostics: %w", err)
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("heartbeat failed with status: %d", resp.StatusCode)
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("diagnostics upload failed with status: %d", resp.StatusCode)
 	}
 
 	return nil
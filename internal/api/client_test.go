@@ -1,10 +1,13 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 )
 
 const (
@@ -65,7 +68,7 @@ func TestGetDesiredState_Success(t *testing.T) {
 
 	client := NewClient(server.URL, testAgentID, testAccessClientID, testAccessClientSecret)
 
-	state, err := client.GetDesiredState("stack-123")
+	state, err := client.GetDesiredState(context.Background(), "stack-123")
 	if err != nil {
 		t.Fatalf("Expected no error, got: %v", err)
 	}
@@ -100,7 +103,7 @@ func TestGetDesiredState_HTTPError(t *testing.T) {
 
 	client := NewClient(server.URL, testAgentID, testAccessClientID, testAccessClientSecret)
 
-	_, err := client.GetDesiredState("stack-123")
+	_, err := client.GetDesiredState(context.Background(), "stack-123")
 	if err == nil {
 		t.Fatal("Expected error for HTTP 500, got nil")
 	}
@@ -120,7 +123,7 @@ func TestGetDesiredState_InvalidJSON(t *testing.T) {
 
 	client := NewClient(server.URL, testAgentID, testAccessClientID, testAccessClientSecret)
 
-	_, err := client.GetDesiredState("stack-123")
+	_, err := client.GetDesiredState(context.Background(), "stack-123")
 	if err == nil {
 		t.Fatal("Expected error for invalid JSON, got nil")
 	}
@@ -128,6 +131,54 @@ func TestGetDesiredState_InvalidJSON(t *testing.T) {
 	t.Logf("✓ GetDesiredState correctly returned error for invalid JSON")
 }
 
+func TestGetDesiredState_NotModified(t *testing.T) {
+	t.Logf("Testing GetDesiredState conditional requests")
+
+	var ifNoneMatch []string
+	etag := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ifNoneMatch = append(ifNoneMatch, r.Header.Get("If-None-Match"))
+		if match := r.Header.Get("If-None-Match"); match != "" && match == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		if etag != "" {
+			w.Header().Set("ETag", etag)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(DesiredState{StackID: "stack-123", Version: 7, Hash: "abc123", Services: []Service{{ID: "svc-1", Name: "web"}}})
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, testAgentID, testAccessClientID, testAccessClientSecret)
+
+	// Without an ETag header, the state hash is used as the validator.
+	etag = `"abc123"`
+	first, err := client.GetDesiredState(context.Background(), "stack-123")
+	if err != nil || first.NotModified {
+		t.Fatalf("Expected a full state, got %+v (err=%v)", first, err)
+	}
+	second, err := client.GetDesiredState(context.Background(), "stack-123")
+	if err != nil {
+		t.Fatalf("GetDesiredState failed: %v", err)
+	}
+	if !second.NotModified || second.Version != 7 || len(second.Services) != 1 || second.Services[0].Name != "web" {
+		t.Errorf("Expected the cached state on 304, got %+v", second)
+	}
+	if ifNoneMatch[0] != "" || ifNoneMatch[1] != `"abc123"` {
+		t.Errorf("Unexpected If-None-Match headers: %q", ifNoneMatch)
+	}
+
+	// Another stack never reuses the cached state.
+	if _, err := client.GetDesiredState(context.Background(), "stack-456"); err != nil {
+		t.Fatalf("GetDesiredState failed: %v", err)
+	}
+	if ifNoneMatch[2] != "" {
+		t.Errorf("Expected no If-None-Match for another stack, got %q", ifNoneMatch[2])
+	}
+	t.Logf("✓ Unchanged state served from cache on 304")
+}
+
 func TestSendHeartbeat_Success(t *testing.T) {
 	t.Logf("Testing SendHeartbeat success")
 
@@ -184,7 +235,7 @@ func TestSendHeartbeat_Success(t *testing.T) {
 		},
 	}
 
-	err := client.SendHeartbeat(req)
+	_, err := client.SendHeartbeat(context.Background(), req)
 	if err != nil {
 		t.Fatalf("Expected no error, got: %v", err)
 	}
@@ -205,6 +256,66 @@ func TestSendHeartbeat_Success(t *testing.T) {
 	t.Logf("✓ SendHeartbeat sent correct data")
 }
 
+func TestSendHeartbeat_LogLevelResponse(t *testing.T) {
+	t.Logf("Testing SendHeartbeat response parsing")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"log_level":"info","log_levels":{"service":"debug"},"log_level_until":"2026-01-01T00:00:00Z"}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, testAgentID, testAccessClientID, testAccessClientSecret)
+
+	resp, err := client.SendHeartbeat(context.Background(), HeartbeatRequest{AgentStatus: "healthy"})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if resp.LogLevel != "info" || resp.LogLevels["service"] != "debug" {
+		t.Errorf("Unexpected log levels: %+v", resp)
+	}
+	if resp.LogLevelUntil == nil || resp.LogLevelUntil.Year() != 2026 {
+		t.Errorf("Expected log_level_until to be parsed, got %v", resp.LogLevelUntil)
+	}
+
+	t.Logf("✓ SendHeartbeat parsed log level settings")
+}
+
+func TestSendHeartbeat_AgentInfo(t *testing.T) {
+	t.Logf("Testing agent build info in headers and heartbeat")
+
+	info := AgentInfo{Version: "1.4.0", Commit: "3f2a9c1", GoVersion: "go1.21.0", Platform: "linux/amd64", Features: []string{"sidecars", "tasks"}}
+	var received HeartbeatRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("X-Agent-Version"); got != "1.4.0" {
+			t.Errorf("Expected X-Agent-Version 1.4.0, got %q", got)
+		}
+		if got := r.Header.Get("X-Agent-Commit"); got != "3f2a9c1" {
+			t.Errorf("Expected X-Agent-Commit 3f2a9c1, got %q", got)
+		}
+		if got := r.Header.Get("X-Agent-Features"); got != "sidecars,tasks" {
+			t.Errorf("Expected X-Agent-Features sidecars,tasks, got %q", got)
+		}
+		if got := r.Header.Get("User-Agent"); got != "potato-cloud-agent/1.4.0" {
+			t.Errorf("Unexpected User-Agent %q", got)
+		}
+		json.NewDecoder(r.Body).Decode(&received)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, testAgentID, testAccessClientID, testAccessClientSecret)
+	client.SetAgentInfo(info)
+	if _, err := client.SendHeartbeat(context.Background(), HeartbeatRequest{AgentStatus: "healthy", Agent: &info}); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if received.Agent == nil || received.Agent.Version != "1.4.0" || len(received.Agent.Features) != 2 {
+		t.Errorf("Expected agent info in heartbeat, got %+v", received.Agent)
+	}
+
+	t.Logf("✓ Agent build info sent")
+}
+
 func TestSendHeartbeat_HTTPError(t *testing.T) {
 	t.Logf("Testing SendHeartbeat HTTP error")
 
@@ -221,10 +332,174 @@ func TestSendHeartbeat_HTTPError(t *testing.T) {
 		AgentStatus:  "healthy",
 	}
 
-	err := client.SendHeartbeat(req)
+	_, err := client.SendHeartbeat(context.Background(), req)
 	if err == nil {
 		t.Fatal("Expected error for HTTP 503, got nil")
 	}
+	if Rejected(err) {
+		t.Errorf("Expected a 503 to be worth retrying, got %v", err)
+	}
+	if !Rejected(&StatusError{Op: "heartbeat", StatusCode: 400}) || Rejected(&StatusError{Op: "heartbeat", StatusCode: 429}) || !Rejected(&GRPCError{Code: 3}) || Rejected(errors.New("connection refused")) {
+		t.Errorf("Expected only 4xx statuses other than 429 and their gRPC codes to be rejections")
+	}
 
 	t.Logf("✓ SendHeartbeat correctly returned error for HTTP 503")
 }
+
+func TestUploadDiagnostics_Success(t *testing.T) {
+	t.Logf("Testing UploadDiagnostics success")
+
+	var received *DeployDiagnostics
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			t.Errorf("Expected POST request, got %s", r.Method)
+		}
+		if r.URL.Path != "/api/agents/diagnostics" {
+			t.Errorf("Expected path /api/agents/diagnostics, got %s", r.URL.Path)
+		}
+		if r.Header.Get("X-Agent-Id") != testAgentID {
+			t.Errorf("Expected agent header '%s', got '%s'", testAgentID, r.Header.Get("X-Agent-Id"))
+		}
+
+		var bundle DeployDiagnostics
+		if err := json.NewDecoder(r.Body).Decode(&bundle); err != nil {
+			t.Errorf("Failed to decode diagnostics: %v", err)
+		}
+		received = &bundle
+
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, testAgentID, testAccessClientID, testAccessClientSecret)
+
+	bundle := DeployDiagnostics{
+		ServiceID:        "svc-1",
+		ServiceName:      "api-gateway",
+		Error:            "health check failed",
+		HealthCheckTrace: []string{"attempt=1 status=503"},
+	}
+
+	if err := client.UploadDiagnostics(bundle); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	if received == nil {
+		t.Fatal("Server did not receive diagnostics")
+	}
+	if received.ServiceID != bundle.ServiceID {
+		t.Errorf("Expected ServiceID '%s', got '%s'", bundle.ServiceID, received.ServiceID)
+	}
+	if len(received.HealthCheckTrace) != 1 {
+		t.Errorf("Expected 1 health check trace line, got %d", len(received.HealthCheckTrace))
+	}
+
+	t.Logf("✓ UploadDiagnostics sent correct data")
+}
+
+func TestClient_FailoverAndPrimaryReprobe(t *testing.T) {
+	t.Logf("Testing control plane failover")
+
+	primaryDown := true
+	var primaryHits, fallbackHits int
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		primaryHits++
+		if primaryDown {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte("{}"))
+	}))
+	defer primary.Close()
+	fallback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fallbackHits++
+		if r.Header.Get("X-Agent-Id") != testAgentID {
+			t.Errorf("Expected access headers on fallback request")
+		}
+		w.Write([]byte("{}"))
+	}))
+	defer fallback.Close()
+
+	client := NewClient(primary.URL, testAgentID, testAccessClientID, testAccessClientSecret)
+	client.SetFallbackURLs(fallback.URL)
+	clock := time.Date(2026, 6, 1, 10, 0, 0, 0, time.UTC)
+	client.now = func() time.Time { return clock }
+
+	if _, err := client.SendHeartbeat(context.Background(), HeartbeatRequest{StackVersion: 1}); err != nil {
+		t.Fatalf("Expected heartbeat to fail over, got %v", err)
+	}
+	if client.BaseURL() != fallback.URL || primaryHits != 1 || fallbackHits != 1 {
+		t.Fatalf("Expected failover to fallback, active=%s primary=%d fallback=%d", client.BaseURL(), primaryHits, fallbackHits)
+	}
+
+	// Stays on the fallback until the primary is due a re-probe.
+	primaryDown = false
+	client.SendHeartbeat(context.Background(), HeartbeatRequest{})
+	if primaryHits != 1 || fallbackHits != 2 {
+		t.Errorf("Expected primary not to be retried yet, primary=%d fallback=%d", primaryHits, fallbackHits)
+	}
+
+	clock = clock.Add(primaryProbeInterval)
+	if _, err := client.GetDesiredState(context.Background(), "stack-123"); err != nil {
+		t.Fatalf("GetDesiredState failed: %v", err)
+	}
+	if client.BaseURL() != primary.URL || primaryHits != 2 {
+		t.Errorf("Expected switch back to primary, active=%s primary=%d", client.BaseURL(), primaryHits)
+	}
+
+	t.Logf("✓ Requests fail over and return to the primary once it recovers")
+}
+
+func TestGetDesiredState_PagesAndServiceRefs(t *testing.T) {
+	t.Logf("Testing paginated desired state with service refs")
+
+	hashes := map[string]string{"svc-a": "h1", "svc-b": "h1"}
+	fetches := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/stacks/stack-123/desired-state":
+			if r.URL.Query().Get("services") != "refs" {
+				t.Errorf("Expected refs to be requested, got %q", r.URL.RawQuery)
+			}
+			state := DesiredState{StackID: "stack-123", Version: 2}
+			if r.URL.Query().Get("cursor") == "" {
+				state.ServiceRefs = []ServiceRef{{ID: "svc-a", Hash: hashes["svc-a"]}}
+				state.NextCursor = "page-2"
+			} else {
+				state.ServiceRefs = []ServiceRef{{ID: "svc-b", Hash: hashes["svc-b"]}}
+			}
+			json.NewEncoder(w).Encode(state)
+		case "/api/stacks/stack-123/services/svc-a", "/api/stacks/stack-123/services/svc-b":
+			id := r.URL.Path[len("/api/stacks/stack-123/services/"):]
+			fetches[id]++
+			json.NewEncoder(w).Encode(Service{ID: id, Name: id + "-" + hashes[id]})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, testAgentID, testAccessClientID, testAccessClientSecret)
+	state, err := client.GetDesiredState(context.Background(), "stack-123")
+	if err != nil {
+		t.Fatalf("GetDesiredState failed: %v", err)
+	}
+	if len(state.Services) != 2 || state.Services[0].ID != "svc-a" || state.Services[1].ID != "svc-b" || len(state.ServiceRefs) != 0 {
+		t.Fatalf("Expected both pages resolved in order, got %+v", state)
+	}
+
+	// Only the changed definition is downloaded again.
+	hashes["svc-b"] = "h2"
+	state, err = client.GetDesiredState(context.Background(), "stack-123")
+	if err != nil {
+		t.Fatalf("GetDesiredState failed: %v", err)
+	}
+	if fetches["svc-a"] != 1 || fetches["svc-b"] != 2 {
+		t.Errorf("Expected only svc-b to be refetched, got %v", fetches)
+	}
+	if state.Services[1].Name != "svc-b-h2" {
+		t.Errorf("Expected updated definition, got %+v", state.Services[1])
+	}
+	t.Logf("✓ Pages followed and unchanged services served from cache")
+}
@@ -0,0 +1,86 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Command types the control plane can queue for an agent.
+const (
+	CommandRestartService  = "restart_service"
+	CommandRedeployService = "redeploy_service"
+	CommandFetchLogs       = "fetch_logs"
+	CommandHealthCheck     = "health_check"
+	CommandRotateSecret    = "rotate_secret"
+	CommandConfirmDeploy   = "confirm_deploy"
+	CommandRollbackService = "rollback_service"
+)
+
+// Command is an operation queued by the control plane for the agent to run.
+type Command struct {
+	ID        string            `json:"id"`
+	Type      string            `json:"type"`
+	ServiceID string            `json:"service_id"`
+	Args      map[string]string `json:"args,omitempty"` // e.g. "lines" for fetch_logs, "name" and "value" for rotate_secret, "revision" for confirm_deploy
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+// CommandResult is the outcome of a command, posted back once it has run.
+type CommandResult struct {
+	CommandID   string    `json:"command_id"`
+	Status      string    `json:"status"` // "succeeded", "failed" or "rejected"
+	Output      string    `json:"output,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	StartedAt   time.Time `json:"started_at"`
+	CompletedAt time.Time `json:"completed_at"`
+}
+
+// GetCommands fetches the commands waiting for this agent. A control plane
+// without the commands endpoint has none.
+func (c *Client) GetCommands(ctx context.Context) ([]Command, error) {
+	resp, err := c.do(ctx, "GET", fmt.Sprintf("/api/agents/%s/commands", url.PathEscape(c.agentID)), nil, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch commands: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound, http.StatusNotImplemented:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unexpected status code fetching commands: %d", resp.StatusCode)
+	}
+
+	var body struct {
+		Commands []Command `json:"commands"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("failed to decode commands: %w", err)
+	}
+	return body.Commands, nil
+}
+
+// SendCommandResult reports the outcome of a command.
+func (c *Client) SendCommandResult(ctx context.Context, result CommandResult) error {
+	body, err := json.Marshal(result)
+	if err != nil {
+		return fmt.Errorf("failed to marshal command result: %w", err)
+	}
+
+	path := fmt.Sprintf("/api/agents/%s/commands/%s/result", url.PathEscape(c.agentID), url.PathEscape(result.CommandID))
+	resp, err := c.doWithRetry(ctx, "POST", path, body, "application/json", nil)
+	if err != nil {
+		return fmt.Errorf("failed to send command result: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("command result rejected with status: %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGetCommands(t *testing.T) {
+	t.Logf("Testing GetCommands decodes queued commands")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" || r.URL.Path != "/api/agents/agent-123/commands" {
+			t.Errorf("Unexpected request: %s %s", r.Method, r.URL.Path)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"commands":[{"id":"cmd-1","type":"fetch_logs","service_id":"svc-1","args":{"lines":"20"}}]}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, testAgentID, testAccessClientID, testAccessClientSecret)
+	commands, err := client.GetCommands(context.Background())
+	if err != nil {
+		t.Fatalf("GetCommands failed: %v", err)
+	}
+	if len(commands) != 1 {
+		t.Fatalf("Expected 1 command, got %d", len(commands))
+	}
+	if commands[0].ID != "cmd-1" || commands[0].Type != CommandFetchLogs || commands[0].ServiceID != "svc-1" || commands[0].Args["lines"] != "20" {
+		t.Errorf("Unexpected command: %+v", commands[0])
+	}
+	t.Logf("✓ Commands decoded")
+}
+
+func TestGetCommands_NotSupported(t *testing.T) {
+	t.Logf("Testing GetCommands against a control plane without the endpoint")
+
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	client := NewClient(server.URL, testAgentID, testAccessClientID, testAccessClientSecret)
+	commands, err := client.GetCommands(context.Background())
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if commands != nil {
+		t.Errorf("Expected no commands, got %+v", commands)
+	}
+	t.Logf("✓ Missing endpoint means no commands")
+}
+
+func TestSendCommandResult(t *testing.T) {
+	t.Logf("Testing SendCommandResult posts to the command's result path")
+
+	var received CommandResult
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/api/agents/agent-123/commands/cmd-1/result" {
+			t.Errorf("Unexpected request: %s %s", r.Method, r.URL.Path)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Errorf("Failed to decode result: %v", err)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, testAgentID, testAccessClientID, testAccessClientSecret)
+	err := client.SendCommandResult(context.Background(), CommandResult{CommandID: "cmd-1", Status: "failed", Error: "boom"})
+	if err != nil {
+		t.Fatalf("SendCommandResult failed: %v", err)
+	}
+	if received.CommandID != "cmd-1" || received.Status != "failed" || received.Error != "boom" {
+		t.Errorf("Unexpected result: %+v", received)
+	}
+	t.Logf("✓ Result posted")
+}
@@ -0,0 +1,60 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Deploy failure classes, in DeployReport.ErrorClass.
+const (
+	DeployErrorInvalidConfig = "invalid_config"
+	DeployErrorDependency    = "dependency_unavailable"
+	DeployErrorTimeout       = "timeout"
+	DeployErrorPull          = "pull_failed"
+	DeployErrorBuild         = "build_failed"
+	DeployErrorPort          = "port_unavailable"
+	DeployErrorStart         = "start_failed"
+	DeployErrorHealthCheck   = "health_check_failed"
+	DeployErrorProxy         = "proxy_failed"
+	DeployErrorCanary        = "canary_failed"
+	DeployErrorUnknown       = "unknown"
+)
+
+// DeployReport is the outcome of one deploy attempt of a service.
+type DeployReport struct {
+	ServiceID   string    `json:"service_id"`
+	ServiceName string    `json:"service_name"`
+	DeployID    string    `json:"deploy_id"`
+	GitCommit   string    `json:"git_commit,omitempty"`
+	ImageID     string    `json:"image_id,omitempty"`  // Image of the new container, on success
+	BuildLog    string    `json:"build_log,omitempty"` // Deploy ID of the build log, when the deploy built an image
+	Result      string    `json:"result"`              // "succeeded" or "failed"
+	Rollback    bool      `json:"rollback,omitempty"`  // The deploy rolled back to an earlier release
+	Error       string    `json:"error,omitempty"`
+	ErrorClass  string    `json:"error_class,omitempty"` // One of the DeployError* classes
+	StartedAt   time.Time `json:"started_at"`
+	FinishedAt  time.Time `json:"finished_at"`
+	DurationMS  int64     `json:"duration_ms"`
+}
+
+// ReportDeploy sends the outcome of a deploy attempt to the control plane.
+func (c *Client) ReportDeploy(ctx context.Context, report DeployReport) error {
+	body, err := json.Marshal(report)
+	if err != nil {
+		return fmt.Errorf("failed to marshal deploy report: %w", err)
+	}
+
+	resp, err := c.do(ctx, "POST", "/api/agents/deploy-reports", body, "application/json")
+	if err != nil {
+		return fmt.Errorf("failed to send deploy report: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("deploy report failed with status: %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Reasons an agent goes offline, sent with GoingOffline.
+const (
+	OfflineReasonShutdown     = "shutdown"
+	OfflineReasonUpgrade      = "upgrade"
+	OfflineReasonDecommission = "decommission"
+)
+
+// OfflineNotice tells the control plane the agent is stopping on purpose, so
+// missed heartbeats aren't reported as an outage.
+type OfflineNotice struct {
+	Reason string    `json:"reason"` // "shutdown", "upgrade" or "decommission"
+	At     time.Time `json:"at"`
+}
+
+// GoingOffline tells the control plane the agent is about to stop.
+func (c *Client) GoingOffline(ctx context.Context, reason string) error {
+	body, err := json.Marshal(OfflineNotice{Reason: reason, At: time.Now().UTC()})
+	if err != nil {
+		return fmt.Errorf("failed to marshal offline notice: %w", err)
+	}
+
+	resp, err := c.do(ctx, "POST", "/api/agents/offline", body, "application/json")
+	if err != nil {
+		return fmt.Errorf("failed to send offline notice: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("offline notice failed with status: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Deregister removes the agent from the control plane, invalidating its
+// credentials. An agent the control plane no longer knows is treated as
+// already deregistered.
+func (c *Client) Deregister(ctx context.Context) error {
+	resp, err := c.do(ctx, "POST", "/api/agents/deregister", nil, "")
+	if err != nil {
+		return fmt.Errorf("failed to deregister agent: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent, http.StatusNotFound, http.StatusGone:
+		return nil
+	default:
+		return fmt.Errorf("deregistration failed with status: %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,55 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGoingOfflineAndDeregister(t *testing.T) {
+	t.Logf("Testing the offline notice and deregistration")
+
+	var notices []OfflineNotice
+	deregisterStatus := http.StatusNoContent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Agent-Id") != testAgentID {
+			t.Errorf("Expected the agent ID header, got %v", r.Header)
+		}
+		switch r.URL.Path {
+		case "/api/agents/offline":
+			var notice OfflineNotice
+			if err := json.NewDecoder(r.Body).Decode(&notice); err != nil {
+				t.Fatalf("Failed to decode notice: %v", err)
+			}
+			notices = append(notices, notice)
+			w.WriteHeader(http.StatusNoContent)
+		case "/api/agents/deregister":
+			w.WriteHeader(deregisterStatus)
+		default:
+			t.Errorf("Unexpected request %s %s", r.Method, r.URL.Path)
+		}
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, testAgentID, "", "")
+	if err := client.GoingOffline(context.Background(), OfflineReasonUpgrade); err != nil {
+		t.Fatalf("GoingOffline failed: %v", err)
+	}
+	if len(notices) != 1 || notices[0].Reason != OfflineReasonUpgrade || notices[0].At.IsZero() {
+		t.Errorf("Unexpected notices %+v", notices)
+	}
+
+	for _, status := range []int{http.StatusNoContent, http.StatusNotFound} {
+		deregisterStatus = status
+		if err := client.Deregister(context.Background()); err != nil {
+			t.Errorf("Expected deregistration to succeed with status %d, got %v", status, err)
+		}
+	}
+	deregisterStatus = http.StatusForbidden
+	if err := client.Deregister(context.Background()); err == nil {
+		t.Error("Expected a refused deregistration to fail")
+	}
+	t.Logf("✓ Offline reason sent and deregistration treats an unknown agent as done")
+}
@@ -0,0 +1,121 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/buildvigil/agent/internal/chaos"
+)
+
+// primaryProbeInterval is how often a client that failed over retries the
+// primary control plane URL.
+const primaryProbeInterval = 5 * time.Minute
+
+// SetFallbackURLs configures control plane URLs tried in order when the
+// primary is unreachable or returns a server error.
+func (c *Client) SetFallbackURLs(urls ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.baseURLs = append([]string{c.baseURLs[0]}, urls...)
+	c.active = 0
+}
+
+// BaseURL returns the control plane URL currently in use.
+func (c *Client) BaseURL() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.baseURLs[c.active]
+}
+
+// candidates returns the URL indexes to try, in order: the active URL first,
+// preceded by the primary when it is due a re-probe, then the rest.
+func (c *Client) candidates() []int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	order := make([]int, 0, len(c.baseURLs))
+	if c.active != 0 && c.now().Sub(c.lastProbe) >= primaryProbeInterval {
+		c.lastProbe = c.now()
+		order = append(order, 0)
+	}
+	order = append(order, c.active)
+	for i := range c.baseURLs {
+		if i != c.active && (i != 0 || order[0] != 0) {
+			order = append(order, i)
+		}
+	}
+	return order
+}
+
+func (c *Client) setActive(index int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if index == c.active {
+		return
+	}
+	if index == 0 {
+		log.Printf("[API] Primary control plane reachable again: url=%s", c.baseURLs[0])
+	} else {
+		log.Printf("[API] Failing over control plane: from=%s to=%s", c.baseURLs[c.active], c.baseURLs[index])
+		c.lastProbe = c.now()
+	}
+	c.active = index
+}
+
+// do sends a request to path on each candidate URL until one answers
+// without a network error or 5xx status. The outcome of the last attempt is
+// returned if none do.
+func (c *Client) do(ctx context.Context, method, path string, body []byte, contentType string) (*http.Response, error) {
+	return c.doWithHeader(ctx, method, path, body, contentType, nil)
+}
+
+// doWithHeader is do with extra request headers.
+func (c *Client) doWithHeader(ctx context.Context, method, path string, body []byte, contentType string, header http.Header) (*http.Response, error) {
+	var resp *http.Response
+	var err error
+	for _, index := range c.candidates() {
+		if resp != nil {
+			resp.Body.Close()
+		}
+
+		c.mu.Lock()
+		baseURL := c.baseURLs[index]
+		c.mu.Unlock()
+
+		var reader io.Reader
+		if body != nil {
+			reader = bytes.NewReader(body)
+		}
+		req, reqErr := http.NewRequestWithContext(ctx, method, baseURL+path, reader)
+		if reqErr != nil {
+			return nil, reqErr
+		}
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		for key, values := range header {
+			req.Header[key] = values
+		}
+		c.setAccessHeaders(req)
+		c.signRequest(req, body)
+
+		if chaos.Inject(chaos.ControlPlaneError) {
+			resp, err = &http.Response{
+				StatusCode: http.StatusInternalServerError,
+				Body:       io.NopCloser(strings.NewReader(chaos.ErrInjected.Error())),
+				Request:    req,
+			}, nil
+			continue
+		}
+		resp, err = c.httpClient.Do(req)
+		if err == nil && resp.StatusCode < http.StatusInternalServerError {
+			c.setActive(index)
+			return resp, nil
+		}
+	}
+	return resp, err
+}
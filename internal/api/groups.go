@@ -0,0 +1,86 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ServiceGroup holds settings shared by its member services. Members inherit
+// every field they leave unset; environment variables and secrets are merged,
+// with the member's own values winning.
+type ServiceGroup struct {
+	Name                string            `json:"name"`
+	EnvironmentVars     map[string]string `json:"environment_vars"`
+	Secrets             []string          `json:"secrets"`
+	HealthCheckPath     string            `json:"health_check_path"`
+	HealthCheckInterval int               `json:"health_check_interval"`
+	DockerRunArgs       string            `json:"docker_run_args"` // e.g. resource limits; member args are appended after
+}
+
+// ResolveGroups applies each service's group settings to the service. It
+// fails if a service names a group that is not defined.
+func (s *DesiredState) ResolveGroups() error {
+	if len(s.Groups) == 0 && !anyGrouped(s.Services) {
+		return nil
+	}
+	groups := make(map[string]ServiceGroup, len(s.Groups))
+	for _, group := range s.Groups {
+		groups[group.Name] = group
+	}
+	for i, svc := range s.Services {
+		if svc.Group == "" {
+			continue
+		}
+		group, ok := groups[svc.Group]
+		if !ok {
+			return fmt.Errorf("service %s references unknown group %q", svc.ID, svc.Group)
+		}
+		s.Services[i] = group.apply(svc)
+	}
+	return nil
+}
+
+func anyGrouped(services []Service) bool {
+	for _, svc := range services {
+		if svc.Group != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// apply returns svc with the group's settings filled in. Maps and slices are
+// copied so the group and cached definitions are never modified.
+func (g ServiceGroup) apply(svc Service) Service {
+	if len(g.EnvironmentVars) > 0 {
+		env := make(map[string]string, len(g.EnvironmentVars)+len(svc.EnvironmentVars))
+		for key, value := range g.EnvironmentVars {
+			env[key] = value
+		}
+		for key, value := range svc.EnvironmentVars {
+			env[key] = value
+		}
+		svc.EnvironmentVars = env
+	}
+	if len(g.Secrets) > 0 {
+		seen := make(map[string]bool, len(g.Secrets)+len(svc.Secrets))
+		var names []string
+		for _, name := range append(append([]string{}, svc.Secrets...), g.Secrets...) {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+		svc.Secrets = names
+	}
+	if svc.HealthCheckPath == "" {
+		svc.HealthCheckPath = g.HealthCheckPath
+	}
+	if svc.HealthCheckInterval == 0 {
+		svc.HealthCheckInterval = g.HealthCheckInterval
+	}
+	if g.DockerRunArgs != "" {
+		svc.DockerRunArgs = strings.TrimSpace(g.DockerRunArgs + " " + svc.DockerRunArgs)
+	}
+	return svc
+}
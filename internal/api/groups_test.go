@@ -0,0 +1,60 @@
+package api
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestResolveGroups_InheritsAndOverrides(t *testing.T) {
+	t.Logf("Testing service group inheritance")
+
+	state := &DesiredState{
+		Groups: []ServiceGroup{{
+			Name:                "web",
+			EnvironmentVars:     map[string]string{"REGION": "eu", "LOG_LEVEL": "info"},
+			Secrets:             []string{"DATABASE_URL"},
+			HealthCheckPath:     "/healthz",
+			HealthCheckInterval: 15,
+			DockerRunArgs:       "--memory 512m",
+		}},
+		Services: []Service{
+			{ID: "api", Group: "web", EnvironmentVars: map[string]string{"LOG_LEVEL": "debug"}, HealthCheckPath: "/ready", DockerRunArgs: "--memory 1g", Secrets: []string{"API_KEY"}},
+			{ID: "site", Group: "web"},
+			{ID: "solo", HealthCheckPath: "/"},
+		},
+	}
+	if err := state.ResolveGroups(); err != nil {
+		t.Fatalf("ResolveGroups failed: %v", err)
+	}
+
+	api, site, solo := state.Services[0], state.Services[1], state.Services[2]
+	if !reflect.DeepEqual(api.EnvironmentVars, map[string]string{"REGION": "eu", "LOG_LEVEL": "debug"}) {
+		t.Errorf("Expected merged env with member override, got %v", api.EnvironmentVars)
+	}
+	if api.HealthCheckPath != "/ready" || api.HealthCheckInterval != 15 {
+		t.Errorf("Expected own path and inherited interval, got %q %d", api.HealthCheckPath, api.HealthCheckInterval)
+	}
+	if api.DockerRunArgs != "--memory 512m --memory 1g" {
+		t.Errorf("Expected member run args after group args, got %q", api.DockerRunArgs)
+	}
+	if !reflect.DeepEqual(api.Secrets, []string{"API_KEY", "DATABASE_URL"}) {
+		t.Errorf("Expected merged secrets, got %v", api.Secrets)
+	}
+	if site.HealthCheckPath != "/healthz" || site.EnvironmentVars["REGION"] != "eu" {
+		t.Errorf("Expected site to inherit group settings, got %+v", site)
+	}
+	if solo.EnvironmentVars != nil || solo.HealthCheckPath != "/" {
+		t.Errorf("Expected ungrouped service untouched, got %+v", solo)
+	}
+
+	site.EnvironmentVars["REGION"] = "us"
+	if state.Groups[0].EnvironmentVars["REGION"] != "eu" {
+		t.Errorf("Expected group env not to be shared with members")
+	}
+
+	bad := &DesiredState{Services: []Service{{ID: "x", Group: "missing"}}}
+	if err := bad.ResolveGroups(); err == nil {
+		t.Errorf("Expected unknown group to fail")
+	}
+	t.Logf("✓ Members inherit group settings with their own overrides")
+}
@@ -0,0 +1,405 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Control plane transports accepted by SetTransport.
+const (
+	TransportHTTP = "http"
+	TransportGRPC = "grpc"
+)
+
+// grpcServicePath prefixes the method names of the control plane's gRPC
+// service, defined in proto/potatocloud/agent/v1/control_plane.proto.
+const grpcServicePath = "/potatocloud.agent.v1.ControlPlane/"
+
+// grpcMaxMessageSize bounds one message received from the control plane.
+const grpcMaxMessageSize = 64 << 20
+
+// gRPC status codes the agent acts on.
+const (
+	GRPCUnknown       = int(codes.Unknown)
+	GRPCUnimplemented = int(codes.Unimplemented)
+	GRPCInternal      = int(codes.Internal)
+	GRPCUnavailable   = int(codes.Unavailable)
+)
+
+// jsonCodec carries gRPC messages as the same JSON documents the HTTP API
+// uses, with the content type application/grpc+json. An empty message
+// leaves the target untouched.
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	if len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, v)
+}
+
+func (jsonCodec) Name() string {
+	return "json"
+}
+
+func init() {
+	encoding.RegisterCodec(jsonCodec{})
+}
+
+// GRPCError is a non-OK status returned for a gRPC call.
+type GRPCError struct {
+	Code    int
+	Message string
+}
+
+func (e *GRPCError) Error() string {
+	return fmt.Sprintf("grpc status %d: %s", e.Code, e.Message)
+}
+
+// IsGRPCCode reports whether err is a GRPCError with the given code.
+func IsGRPCCode(err error, code int) bool {
+	var grpcErr *GRPCError
+	return errors.As(err, &grpcErr) && grpcErr.Code == code
+}
+
+// grpcError converts the status of a failed call to a GRPCError. Errors
+// that carry no status are returned as they are.
+func grpcError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if s, ok := status.FromError(err); ok {
+		return &GRPCError{Code: int(s.Code()), Message: s.Message()}
+	}
+	return err
+}
+
+// SetTransport selects how desired state fetches, heartbeats and lifecycle
+// events reach the control plane: "http" (the default) or "grpc". Over gRPC
+// the control plane URL's scheme picks TLS ("https") or cleartext HTTP/2
+// ("http"); SetClientTLS applies to both. Every other call, and the desired
+// state push stream, stays on HTTP.
+func (c *Client) SetTransport(transport string) error {
+	switch strings.ToLower(strings.TrimSpace(transport)) {
+	case "", TransportHTTP:
+		c.grpc = false
+	case TransportGRPC:
+		c.grpc = true
+	default:
+		return fmt.Errorf("unknown transport %q; expected http or grpc", transport)
+	}
+	return nil
+}
+
+// UsesGRPC reports whether the client was set to the gRPC transport.
+func (c *Client) UsesGRPC() bool {
+	return c.grpc
+}
+
+// grpcConn returns the connection to the control plane at baseURL, dialling
+// it on first use. grpc-go tunnels it through the proxy HTTPS_PROXY names,
+// unless NO_PROXY exempts the host.
+func (c *Client) grpcConn(baseURL string) (*grpc.ClientConn, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if conn := c.grpcConns[baseURL]; conn != nil {
+		return conn, nil
+	}
+
+	u, err := url.Parse(baseURL)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid control plane URL %q", baseURL)
+	}
+	creds, port := insecure.NewCredentials(), "80"
+	if u.Scheme == "https" {
+		creds, port = credentials.NewTLS(c.tlsConfig), "443"
+	}
+	target := u.Host
+	if u.Port() == "" {
+		target = net.JoinHostPort(u.Hostname(), port)
+	}
+	opts := []grpc.DialOption{
+		grpc.WithTransportCredentials(creds),
+		grpc.WithDefaultCallOptions(grpc.CallContentSubtype(jsonCodec{}.Name()), grpc.MaxCallRecvMsgSize(grpcMaxMessageSize)),
+	}
+	if c.agentInfo != nil {
+		opts = append(opts, grpc.WithUserAgent("potato-cloud-agent/"+c.agentInfo.Version))
+	}
+	conn, err := grpc.Dial(target, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", baseURL, err)
+	}
+	if c.grpcConns == nil {
+		c.grpcConns = make(map[string]*grpc.ClientConn)
+	}
+	c.grpcConns[baseURL] = conn
+	return conn, nil
+}
+
+// grpcContext adds the agent's access headers to ctx as call metadata, with
+// a request signature over msg, the encoded request message.
+func (c *Client) grpcContext(ctx context.Context, method string, msg []byte) context.Context {
+	req := &http.Request{Method: http.MethodPost, URL: &url.URL{Path: grpcServicePath + method}, Header: make(http.Header)}
+	c.setAccessHeaders(req)
+	c.signRequest(req, msg)
+	md := metadata.MD{}
+	for key, values := range req.Header {
+		// gRPC sends its own user-agent, set when dialling.
+		if key == "User-Agent" {
+			continue
+		}
+		md.Append(key, values...)
+	}
+	return metadata.NewOutgoingContext(ctx, md)
+}
+
+// grpcInvoke makes a unary gRPC call, retrying network errors and the
+// Unavailable and Internal codes under the retry policy until ctx is done.
+func (c *Client) grpcInvoke(ctx context.Context, method string, in, out interface{}) error {
+	data, err := json.Marshal(in)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s request: %w", method, err)
+	}
+	msg := json.RawMessage(data)
+
+	c.mu.Lock()
+	policy := c.retry
+	c.mu.Unlock()
+
+	for attempt := 1; ; attempt++ {
+		err := c.grpcCall(ctx, method, msg, out)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err == nil || !grpcRetryable(err) || attempt >= policy.MaxAttempts {
+			return err
+		}
+		if err := waitRetry(ctx, policy, attempt, "grpc "+method, err.Error()); err != nil {
+			return err
+		}
+	}
+}
+
+// grpcCall makes one attempt at a unary gRPC call, trying each candidate
+// control plane URL until one answers without a network error or a status
+// matching a 5xx. The last failure is returned if none do.
+func (c *Client) grpcCall(ctx context.Context, method string, msg json.RawMessage, out interface{}) error {
+	var err error
+	for _, index := range c.candidates() {
+		c.mu.Lock()
+		baseURL := c.baseURLs[index]
+		c.mu.Unlock()
+		err = c.grpcCallURL(ctx, baseURL, method, msg, out)
+		if err == nil || !grpcRetryable(err) {
+			c.setActive(index)
+			return err
+		}
+		if ctx.Err() != nil {
+			return err
+		}
+	}
+	return err
+}
+
+// grpcCallURL makes a unary gRPC call to one control plane URL, bounded by
+// the HTTP client's timeout.
+func (c *Client) grpcCallURL(ctx context.Context, baseURL, method string, msg json.RawMessage, out interface{}) error {
+	conn, err := c.grpcConn(baseURL)
+	if err != nil {
+		return err
+	}
+	if timeout := c.httpClient.Timeout; timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	return grpcError(conn.Invoke(c.grpcContext(ctx, method, msg), grpcServicePath+method, msg, out))
+}
+
+// grpcRetryable reports whether a failed call is worth retrying: errors
+// without a status and the codes matching a 5xx or 429 response.
+func grpcRetryable(err error) bool {
+	var grpcErr *GRPCError
+	if !errors.As(err, &grpcErr) {
+		return true
+	}
+	switch codes.Code(grpcErr.Code) {
+	case codes.Unavailable, codes.Internal, codes.DeadlineExceeded, codes.ResourceExhausted:
+		return true
+	}
+	return false
+}
+
+// desiredStateRequest is the GetDesiredState request message.
+type desiredStateRequest struct {
+	StackID string `json:"stack_id"`
+	Cursor  string `json:"cursor,omitempty"`
+	ETag    string `json:"etag,omitempty"` // Set to skip an unchanged state
+}
+
+// desiredStateReply is the GetDesiredState reply message. State is a page
+// of the desired state as the HTTP API returns it, with full service
+// definitions.
+type desiredStateReply struct {
+	NotModified bool            `json:"not_modified"`
+	ETag        string          `json:"etag"`
+	State       json.RawMessage `json:"state"`
+}
+
+// getDesiredStatePageGRPC is getDesiredStatePage over gRPC.
+func (c *Client) getDesiredStatePageGRPC(ctx context.Context, stackID, cursor, etag string) (*DesiredState, error) {
+	var reply desiredStateReply
+	if err := c.grpcInvoke(ctx, "GetDesiredState", desiredStateRequest{StackID: stackID, Cursor: cursor, ETag: etag}, &reply); err != nil {
+		return nil, fmt.Errorf("failed to fetch desired state: %w", err)
+	}
+	if reply.NotModified && etag != "" {
+		return nil, errNotModified
+	}
+	if len(reply.State) == 0 {
+		return nil, fmt.Errorf("desired state reply carried no state")
+	}
+	state, err := decodeDesiredState(reply.State)
+	if err != nil {
+		return nil, err
+	}
+	state.etag = reply.ETag
+	return state, nil
+}
+
+// LifecycleEvent is a service status change reported as it happens.
+type LifecycleEvent struct {
+	ServiceStatus
+	At time.Time `json:"at"`
+}
+
+// lifecycleStreamDesc describes the control plane's bidirectional Lifecycle
+// method.
+var lifecycleStreamDesc = grpc.StreamDesc{StreamName: "Lifecycle", ServerStreams: true, ClientStreams: true}
+
+// LifecycleStream is an open client stream of lifecycle events to the
+// control plane. The control plane's replies are acknowledgements and are
+// discarded.
+type LifecycleStream struct {
+	mu     sync.Mutex
+	stream grpc.ClientStream
+	cancel context.CancelFunc
+	done   chan struct{}
+	err    error
+}
+
+// OpenLifecycleStream opens the control plane's bidirectional Lifecycle
+// stream. It requires the gRPC transport. The stream goes to the URL in use,
+// or, after streams there failed to connect, to the next candidate URL in
+// turn. A control plane without the method ends the stream with a
+// GRPCUnimplemented error.
+func (c *Client) OpenLifecycleStream(ctx context.Context) (*LifecycleStream, error) {
+	if !c.UsesGRPC() {
+		return nil, &GRPCError{Code: GRPCUnimplemented, Message: "lifecycle stream requires the grpc transport"}
+	}
+	candidates := c.candidates()
+	c.mu.Lock()
+	index := candidates[c.lifecycleFailures%len(candidates)]
+	baseURL := c.baseURLs[index]
+	c.mu.Unlock()
+
+	conn, err := c.grpcConn(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	streamCtx, cancel := context.WithCancel(ctx)
+	clientStream, err := conn.NewStream(c.grpcContext(streamCtx, "Lifecycle", nil), &lifecycleStreamDesc, grpcServicePath+"Lifecycle")
+	c.mu.Lock()
+	if err != nil {
+		c.lifecycleFailures++
+	} else {
+		c.lifecycleFailures = 0
+	}
+	c.mu.Unlock()
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to open lifecycle stream: %w", grpcError(err))
+	}
+	c.setActive(index)
+
+	stream := &LifecycleStream{stream: clientStream, cancel: cancel, done: make(chan struct{})}
+	go func() {
+		defer close(stream.done)
+		stream.err = receiveLifecycleReplies(clientStream)
+		cancel()
+	}()
+	return stream, nil
+}
+
+// receiveLifecycleReplies reads replies until the control plane ends the
+// stream, returning why it ended.
+func receiveLifecycleReplies(stream grpc.ClientStream) error {
+	for {
+		var reply struct{}
+		if err := stream.RecvMsg(&reply); err != nil {
+			if err == io.EOF {
+				return errors.New("lifecycle stream closed")
+			}
+			return grpcError(err)
+		}
+	}
+}
+
+// Send writes an event to the stream.
+func (s *LifecycleStream) Send(event LifecycleEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-s.done:
+		return s.err
+	default:
+	}
+	if err := s.stream.SendMsg(event); err != nil {
+		return fmt.Errorf("failed to send lifecycle event: %w", err)
+	}
+	return nil
+}
+
+// Done is closed when the stream has ended; Err then says why.
+func (s *LifecycleStream) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err returns why the stream ended, or nil while it is open.
+func (s *LifecycleStream) Err() error {
+	select {
+	case <-s.done:
+		return s.err
+	default:
+		return nil
+	}
+}
+
+// Close ends the stream.
+func (s *LifecycleStream) Close() {
+	s.mu.Lock()
+	s.stream.CloseSend()
+	s.mu.Unlock()
+	s.cancel()
+	<-s.done
+}
@@ -0,0 +1,311 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// newGRPCServer starts a cleartext gRPC server that passes every call to
+// handler with its method name, and returns its URL.
+func newGRPCServer(t *testing.T, handler func(method string, stream grpc.ServerStream) error) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	server := grpc.NewServer(grpc.UnknownServiceHandler(func(srv interface{}, stream grpc.ServerStream) error {
+		method, _ := grpc.MethodFromServerStream(stream)
+		return handler(method, stream)
+	}))
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+	return "http://" + listener.Addr().String()
+}
+
+// replyGRPC answers a unary call with reply after reading its request.
+func replyGRPC(stream grpc.ServerStream, reply interface{}) error {
+	var req json.RawMessage
+	if err := stream.RecvMsg(&req); err != nil {
+		return err
+	}
+	return stream.SendMsg(reply)
+}
+
+// mdValue returns the first value of a metadata key.
+func mdValue(md metadata.MD, key string) string {
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func newGRPCTestClient(t *testing.T, url string) *Client {
+	t.Helper()
+	client := NewClient(url, testAgentID, testAccessClientID, testAccessClientSecret)
+	if err := client.SetTransport("grpc"); err != nil {
+		t.Fatalf("SetTransport: %v", err)
+	}
+	return client
+}
+
+func TestGRPC_GetDesiredState(t *testing.T) {
+	t.Logf("Testing desired state fetch over gRPC")
+
+	var requests []desiredStateRequest
+	url := newGRPCServer(t, func(method string, stream grpc.ServerStream) error {
+		md, _ := metadata.FromIncomingContext(stream.Context())
+		if method != grpcServicePath+"GetDesiredState" || mdValue(md, "content-type") != "application/grpc+json" {
+			t.Errorf("Unexpected call method=%s metadata=%v", method, md)
+		}
+		if mdValue(md, "x-agent-id") != testAgentID || mdValue(md, "cf-access-client-id") != testAccessClientID {
+			t.Errorf("Expected agent and Access metadata, got %v", md)
+		}
+		var req desiredStateRequest
+		if err := stream.RecvMsg(&req); err != nil {
+			return err
+		}
+		requests = append(requests, req)
+		if req.ETag != "" {
+			return stream.SendMsg(desiredStateReply{NotModified: true})
+		}
+		return stream.SendMsg(map[string]interface{}{
+			"etag":  `"v7"`,
+			"state": map[string]interface{}{"stack_id": req.StackID, "version": 7, "services": []map[string]interface{}{{"id": "svc-1", "name": "web"}}},
+		})
+	})
+
+	client := newGRPCTestClient(t, url)
+	state, err := client.GetDesiredState(context.Background(), "stack-123")
+	if err != nil {
+		t.Fatalf("GetDesiredState: %v", err)
+	}
+	if state.Version != 7 || len(state.Services) != 1 || state.Services[0].Name != "web" {
+		t.Errorf("Unexpected state %+v", state)
+	}
+
+	again, err := client.GetDesiredState(context.Background(), "stack-123")
+	if err != nil {
+		t.Fatalf("Second GetDesiredState: %v", err)
+	}
+	if !again.NotModified || again.Version != 7 {
+		t.Errorf("Expected the cached state to be reused, got %+v", again)
+	}
+	if len(requests) != 2 || requests[0].StackID != "stack-123" || requests[1].ETag != `"v7"` {
+		t.Errorf("Unexpected requests %+v", requests)
+	}
+	t.Logf("✓ State decoded from gRPC replies and reused when not modified")
+}
+
+func TestGRPC_SignsCalls(t *testing.T) {
+	t.Logf("Testing gRPC calls carry a signature over the request message")
+
+	url := newGRPCServer(t, func(method string, stream grpc.ServerStream) error {
+		md, _ := metadata.FromIncomingContext(stream.Context())
+		var msg json.RawMessage
+		if err := stream.RecvMsg(&msg); err != nil {
+			return err
+		}
+		timestamp, _ := strconv.ParseInt(mdValue(md, "x-agent-timestamp"), 10, 64)
+		want := RequestSignature([]byte("secret-key"), timestamp, mdValue(md, "x-agent-nonce"), "POST", method, msg)
+		if mdValue(md, "x-agent-signature") != want {
+			return status.Error(codes.Unauthenticated, "bad signature")
+		}
+		if mdValue(md, "authorization") != "Bearer secret-key" {
+			return status.Error(codes.Unauthenticated, "missing API key")
+		}
+		return stream.SendMsg(HeartbeatResponse{LogLevel: "info"})
+	})
+
+	client := newGRPCTestClient(t, url)
+	client.SetAPIKey("secret-key")
+	resp, err := client.SendHeartbeat(context.Background(), HeartbeatRequest{StackVersion: 3})
+	if err != nil || resp.LogLevel != "info" {
+		t.Fatalf("Expected the signed heartbeat to be accepted, got %+v (err=%v)", resp, err)
+	}
+	t.Logf("✓ Signature verified against the received message")
+}
+
+func TestGRPC_SendHeartbeatRetriesUnavailable(t *testing.T) {
+	t.Logf("Testing heartbeat over gRPC with retries")
+
+	var hits atomic.Int32
+	url := newGRPCServer(t, func(method string, stream grpc.ServerStream) error {
+		if method != grpcServicePath+"Heartbeat" {
+			t.Errorf("Unexpected method %s", method)
+		}
+		switch hits.Add(1) {
+		case 1:
+			return status.Error(codes.Unavailable, "try again")
+		case 2:
+			return replyGRPC(stream, HeartbeatResponse{LogLevel: "debug"})
+		default:
+			return status.Error(codes.PermissionDenied, "try again")
+		}
+	})
+
+	client := newGRPCTestClient(t, url)
+	client.SetRetryPolicy(RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond})
+	resp, err := client.SendHeartbeat(context.Background(), HeartbeatRequest{StackVersion: 1})
+	if err != nil {
+		t.Fatalf("Expected heartbeat to succeed on retry, got %v", err)
+	}
+	if resp.LogLevel != "debug" || hits.Load() != 2 {
+		t.Errorf("Unexpected response %+v after %d attempts", resp, hits.Load())
+	}
+
+	_, err = client.SendHeartbeat(context.Background(), HeartbeatRequest{StackVersion: 1})
+	if !IsGRPCCode(err, 7) || hits.Load() != 3 {
+		t.Errorf("Expected PermissionDenied without retries, got %v after %d attempts", err, hits.Load())
+	}
+	if err != nil && err.Error() != "failed to send heartbeat: grpc status 7: try again" {
+		t.Errorf("Expected the status message, got %q", err.Error())
+	}
+	if !Rejected(err) {
+		t.Errorf("Expected PermissionDenied to count as rejected")
+	}
+	t.Logf("✓ Unavailable retried, other codes returned")
+}
+
+func TestGRPC_FailsOverToFallbackURLs(t *testing.T) {
+	t.Logf("Testing gRPC calls and streams fail over to fallback URLs")
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	downURL := "http://" + listener.Addr().String()
+	listener.Close()
+	var unavailable atomic.Int32
+	sick := newGRPCServer(t, func(method string, stream grpc.ServerStream) error {
+		unavailable.Add(1)
+		return status.Error(codes.Unavailable, "overloaded")
+	})
+	healthy := newGRPCServer(t, func(method string, stream grpc.ServerStream) error {
+		if method == grpcServicePath+"Lifecycle" {
+			<-stream.Context().Done()
+			return nil
+		}
+		return replyGRPC(stream, HeartbeatResponse{LogLevel: "info"})
+	})
+
+	client := newGRPCTestClient(t, downURL)
+	client.SetFallbackURLs(sick, healthy)
+	client.SetRetryPolicy(RetryPolicy{MaxAttempts: 1})
+	resp, err := client.SendHeartbeat(context.Background(), HeartbeatRequest{StackVersion: 1})
+	if err != nil || resp.LogLevel != "info" {
+		t.Fatalf("Expected the heartbeat to reach the healthy fallback, got %+v (err=%v)", resp, err)
+	}
+	if client.BaseURL() != healthy || unavailable.Load() != 1 {
+		t.Errorf("Expected failover to %s past an Unavailable URL, active %s after %d Unavailable", healthy, client.BaseURL(), unavailable.Load())
+	}
+
+	client = newGRPCTestClient(t, downURL)
+	client.SetFallbackURLs(healthy)
+	if _, err := client.OpenLifecycleStream(context.Background()); err == nil {
+		t.Fatal("Expected the stream to the down URL to fail")
+	}
+	stream, err := client.OpenLifecycleStream(context.Background())
+	if err != nil {
+		t.Fatalf("OpenLifecycleStream: %v", err)
+	}
+	defer stream.Close()
+	if client.BaseURL() != healthy || stream.Err() != nil {
+		t.Errorf("Expected the next stream to open on %s, active %s (err=%v)", healthy, client.BaseURL(), stream.Err())
+	}
+	t.Logf("✓ Calls and streams moved on to the fallback URLs")
+}
+
+func TestGRPC_LifecycleStream(t *testing.T) {
+	t.Logf("Testing lifecycle events over a gRPC stream")
+
+	received := make(chan LifecycleEvent, 4)
+	url := newGRPCServer(t, func(method string, stream grpc.ServerStream) error {
+		if method != grpcServicePath+"Lifecycle" {
+			return status.Error(codes.Unimplemented, "unknown method")
+		}
+		for {
+			var event LifecycleEvent
+			if err := stream.RecvMsg(&event); err != nil {
+				if err == io.EOF {
+					return nil
+				}
+				return err
+			}
+			received <- event
+			if err := stream.SendMsg(struct{}{}); err != nil {
+				return err
+			}
+		}
+	})
+
+	client := newGRPCTestClient(t, url)
+	stream, err := client.OpenLifecycleStream(context.Background())
+	if err != nil {
+		t.Fatalf("OpenLifecycleStream: %v", err)
+	}
+	at := time.Date(2026, 10, 16, 7, 0, 0, 0, time.UTC)
+	for _, phase := range []string{"building", "running"} {
+		if err := stream.Send(LifecycleEvent{ServiceStatus: ServiceStatus{ServiceID: "svc-1", Status: phase}, At: at}); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+	}
+	for _, want := range []string{"building", "running"} {
+		select {
+		case event := <-received:
+			if event.ServiceID != "svc-1" || event.Status != want || !event.At.Equal(at) {
+				t.Errorf("Unexpected event %+v, want status %s", event, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for %s event", want)
+		}
+	}
+	if stream.Err() != nil {
+		t.Errorf("Expected the stream to be open, got %v", stream.Err())
+	}
+	stream.Close()
+	if err := stream.Send(LifecycleEvent{}); err == nil {
+		t.Error("Expected Send on a closed stream to fail")
+	}
+
+	httpClient := NewClient(url, testAgentID, testAccessClientID, testAccessClientSecret)
+	if _, err := httpClient.OpenLifecycleStream(context.Background()); !IsGRPCCode(err, GRPCUnimplemented) {
+		t.Errorf("Expected the HTTP transport to have no lifecycle stream, got %v", err)
+	}
+	if err := httpClient.SetTransport("websocket"); err == nil {
+		t.Error("Expected an unknown transport to be rejected")
+	}
+	t.Logf("✓ Events delivered in order over one stream")
+}
+
+func TestGRPC_LifecycleStreamUnimplemented(t *testing.T) {
+	t.Logf("Testing a control plane without the Lifecycle method")
+
+	url := newGRPCServer(t, func(method string, stream grpc.ServerStream) error {
+		return status.Error(codes.Unimplemented, "unknown method")
+	})
+	client := newGRPCTestClient(t, url)
+	stream, err := client.OpenLifecycleStream(context.Background())
+	if err != nil {
+		t.Fatalf("OpenLifecycleStream: %v", err)
+	}
+	select {
+	case <-stream.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the stream to end")
+	}
+	if !IsGRPCCode(stream.Err(), GRPCUnimplemented) {
+		t.Errorf("Expected Unimplemented, got %v", stream.Err())
+	}
+	t.Logf("✓ Missing method reported as Unimplemented")
+}
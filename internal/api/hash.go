@@ -0,0 +1,119 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// ServicesHashAlgorithm names the agent's canonical services hash. A control
+// plane that sets hash_algorithm to it has its hash verified on receipt.
+const ServicesHashAlgorithm = "services-sha256"
+
+// ErrStateHashMismatch is returned when a desired state's services do not
+// match the hash the control plane sent, e.g. after truncation.
+var ErrStateHashMismatch = errors.New("desired state hash mismatch")
+
+// canonicalJSON re-encodes a JSON value with object keys sorted, no
+// insignificant whitespace and no HTML escaping.
+func canonicalJSON(data []byte) (string, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(value); err != nil {
+		return "", err
+	}
+	return string(bytes.TrimSuffix(buf.Bytes(), []byte("\n"))), nil
+}
+
+// canonicalService returns a service definition's ID and canonical JSON.
+func canonicalService(raw json.RawMessage) (string, string, error) {
+	var ident struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(raw, &ident); err != nil {
+		return "", "", err
+	}
+	canonical, err := canonicalJSON(raw)
+	return ident.ID, canonical, err
+}
+
+// recordCanonicalServices keeps the canonical form of service definitions as
+// received, so hashes cover fields this agent does not know about.
+func (s *DesiredState) recordCanonicalServices(raw []json.RawMessage) error {
+	for _, item := range raw {
+		id, canonical, err := canonicalService(item)
+		if err != nil {
+			return fmt.Errorf("failed to canonicalize service: %w", err)
+		}
+		s.setCanonicalService(id, canonical)
+	}
+	return nil
+}
+
+func (s *DesiredState) setCanonicalService(id, canonical string) {
+	if s.canonicalServices == nil {
+		s.canonicalServices = make(map[string]string)
+	}
+	s.canonicalServices[id] = canonical
+}
+
+// ServicesHash returns the canonical hash of the services: the SHA-256 of a
+// JSON array of the service definitions as received, sorted by ID, each with
+// object keys sorted and no insignificant whitespace.
+func (s *DesiredState) ServicesHash() string {
+	services := append([]Service(nil), s.Services...)
+	sort.SliceStable(services, func(i, j int) bool { return services[i].ID < services[j].ID })
+
+	var buf bytes.Buffer
+	buf.WriteByte('[')
+	for i, svc := range services {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		canonical, ok := s.canonicalServices[svc.ID]
+		if !ok {
+			data, _ := json.Marshal(svc)
+			canonical, _ = canonicalJSON(data)
+		}
+		buf.WriteString(canonical)
+	}
+	buf.WriteByte(']')
+	sum := sha256.Sum256(buf.Bytes())
+	return hex.EncodeToString(sum[:])
+}
+
+// StateHash returns the agent's own hash of the desired state, used for
+// applied-state bookkeeping so that a change to how the control plane
+// computes its hash is not mistaken for a state change.
+func (s *DesiredState) StateHash() string {
+	servicesHash := s.ServicesHash()
+	if len(s.Groups) == 0 {
+		return servicesHash
+	}
+	groups, _ := json.Marshal(s.Groups)
+	sum := sha256.Sum256(append([]byte(servicesHash+"\n"), groups...))
+	return hex.EncodeToString(sum[:])
+}
+
+// verifyHash checks the received services against the control plane's hash
+// when it was computed with the agent's algorithm.
+func (s *DesiredState) verifyHash() error {
+	if s.HashAlgorithm != ServicesHashAlgorithm {
+		return nil
+	}
+	if local := s.ServicesHash(); local != s.Hash {
+		return fmt.Errorf("%w: control plane sent %s, received services hash %s", ErrStateHashMismatch, s.Hash, local)
+	}
+	return nil
+}
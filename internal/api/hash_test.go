@@ -0,0 +1,87 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestServicesHash_Canonical(t *testing.T) {
+	t.Logf("Testing services hash ignores key order, whitespace and service order")
+
+	a := &DesiredState{Services: []Service{{ID: "svc-1"}, {ID: "svc-2"}}}
+	if err := a.recordCanonicalServices([]json.RawMessage{
+		json.RawMessage(`{"id":"svc-1","name":"api","port":3000}`),
+		json.RawMessage(`{"id":"svc-2","name":"web"}`),
+	}); err != nil {
+		t.Fatalf("recordCanonicalServices failed: %v", err)
+	}
+	b := &DesiredState{Services: []Service{{ID: "svc-2"}, {ID: "svc-1"}}}
+	if err := b.recordCanonicalServices([]json.RawMessage{
+		json.RawMessage(`{ "name": "web", "id": "svc-2" }`),
+		json.RawMessage("{\n  \"port\": 3000,\n  \"name\": \"api\",\n  \"id\": \"svc-1\"\n}"),
+	}); err != nil {
+		t.Fatalf("recordCanonicalServices failed: %v", err)
+	}
+
+	if a.ServicesHash() != b.ServicesHash() {
+		t.Errorf("Expected equal hashes, got %s and %s", a.ServicesHash(), b.ServicesHash())
+	}
+
+	b.canonicalServices["svc-1"] = `{"id":"svc-1","name":"api","port":3001}`
+	if a.ServicesHash() == b.ServicesHash() {
+		t.Error("Expected hash to change with service contents")
+	}
+
+	t.Logf("✓ Services hash is canonical")
+}
+
+func TestGetDesiredState_HashVerification(t *testing.T) {
+	t.Logf("Testing desired state hash verification")
+
+	body := `{"stack_id":"stack-123","version":1,"services":[{"id":"svc-1","name":"api"}]`
+	expected := &DesiredState{Services: []Service{{ID: "svc-1"}}}
+	expected.setCanonicalService("svc-1", `{"id":"svc-1","name":"api"}`)
+	goodHash := expected.ServicesHash()
+
+	tests := []struct {
+		name      string
+		hash      string
+		algorithm string
+		wantErr   bool
+	}{
+		{"matching hash", goodHash, ServicesHashAlgorithm, false},
+		{"mismatched hash", "deadbeef", ServicesHashAlgorithm, true},
+		{"other algorithm", "deadbeef", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(body + `,"hash":"` + tt.hash + `","hash_algorithm":"` + tt.algorithm + `"}`))
+			}))
+			defer server.Close()
+
+			client := NewClient(server.URL, testAgentID, testAccessClientID, testAccessClientSecret)
+			state, err := client.GetDesiredState(context.Background(), "stack-123")
+			if tt.wantErr {
+				if !errors.Is(err, ErrStateHashMismatch) {
+					t.Fatalf("Expected ErrStateHashMismatch, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("GetDesiredState failed: %v", err)
+			}
+			if state.StateHash() != goodHash {
+				t.Errorf("Expected local hash %s, got %s", goodHash, state.StateHash())
+			}
+		})
+	}
+
+	t.Logf("✓ Desired state hash verification works")
+}
@@ -0,0 +1,83 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LogEntry is a service log line shipped to the control plane.
+type LogEntry struct {
+	ID          int64     `json:"id"`
+	ServiceID   string    `json:"service_id"`
+	DeployID    string    `json:"deploy_id,omitempty"`
+	ContainerID string    `json:"container_id,omitempty"`
+	Level       string    `json:"level"`
+	Message     string    `json:"message"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// ThrottledError is returned when the control plane asks the agent to slow
+// down (429 or 503). RetryAfter is the wait it asked for, or 0 if none.
+type ThrottledError struct {
+	StatusCode int
+	RetryAfter time.Duration
+}
+
+func (e *ThrottledError) Error() string {
+	if e.RetryAfter > 0 {
+		return fmt.Sprintf("control plane throttled request: status %d, retry after %s", e.StatusCode, e.RetryAfter)
+	}
+	return fmt.Sprintf("control plane throttled request: status %d", e.StatusCode)
+}
+
+// SendLogs posts a batch of service log lines to the control plane's log
+// ingest endpoint. It makes a single attempt; the caller decides when to
+// retry, honouring any *ThrottledError.
+func (c *Client) SendLogs(ctx context.Context, entries []LogEntry) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"agent_id": c.agentID,
+		"logs":     entries,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal logs: %w", err)
+	}
+
+	resp, err := c.do(ctx, "POST", fmt.Sprintf("/api/agents/%s/logs", url.PathEscape(c.agentID)), body, "application/json")
+	if err != nil {
+		return fmt.Errorf("failed to send logs: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusAccepted, http.StatusNoContent:
+		return nil
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return &ThrottledError{StatusCode: resp.StatusCode, RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"))}
+	default:
+		return fmt.Errorf("logs rejected with status: %d", resp.StatusCode)
+	}
+}
+
+// parseRetryAfter reads a Retry-After header given in seconds or as an HTTP
+// date. It returns 0 when the header is missing or unreadable.
+func parseRetryAfter(value string) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(at); wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSendLogs(t *testing.T) {
+	t.Logf("Testing SendLogs posts a batch")
+
+	var body struct {
+		AgentID string     `json:"agent_id"`
+		Logs    []LogEntry `json:"logs"`
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/api/agents/agent-123/logs" {
+			t.Errorf("Unexpected request: %s %s", r.Method, r.URL.Path)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("Failed to decode logs: %v", err)
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, testAgentID, testAccessClientID, testAccessClientSecret)
+	err := client.SendLogs(context.Background(), []LogEntry{{ID: 7, ServiceID: "svc-1", Level: "info", Message: "hello"}})
+	if err != nil {
+		t.Fatalf("SendLogs failed: %v", err)
+	}
+	if body.AgentID != testAgentID || len(body.Logs) != 1 || body.Logs[0].ID != 7 || body.Logs[0].Message != "hello" {
+		t.Errorf("Unexpected body: %+v", body)
+	}
+	t.Logf("✓ Logs posted")
+}
+
+func TestSendLogs_Throttled(t *testing.T) {
+	t.Logf("Testing SendLogs reports backpressure")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "30")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, testAgentID, testAccessClientID, testAccessClientSecret)
+	err := client.SendLogs(context.Background(), []LogEntry{{ID: 1}})
+	var throttled *ThrottledError
+	if !errors.As(err, &throttled) {
+		t.Fatalf("Expected ThrottledError, got %v", err)
+	}
+	if throttled.RetryAfter != 30*time.Second {
+		t.Errorf("Expected retry after 30s, got %s", throttled.RetryAfter)
+	}
+	t.Logf("✓ 429 with Retry-After surfaced as ThrottledError")
+}
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Service naming modes. In NamingSlug mode names are reduced to their slug;
+// in NamingStrict mode a name must already be a valid slug.
+const (
+	NamingSlug   = "slug"
+	NamingStrict = "strict"
+)
+
+// maxSlugLength is the longest DNS label.
+const maxSlugLength = 63
+
+var (
+	slugPattern   = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+	slugSeparator = regexp.MustCompile(`[^a-z0-9]+`)
+)
+
+// ServiceSlug returns the DNS-safe form of a service name, used for its
+// svc.internal hostname and internal route: lowercase letters, digits and
+// single dashes, at most 63 characters. "My API_v2" becomes "my-api-v2".
+func ServiceSlug(name string) string {
+	slug := slugSeparator.ReplaceAllString(strings.ToLower(name), "-")
+	slug = strings.Trim(slug, "-")
+	if len(slug) > maxSlugLength {
+		slug = strings.TrimRight(slug[:maxSlugLength], "-")
+	}
+	return slug
+}
+
+// ServiceNameError describes why one service's name was rejected.
+type ServiceNameError struct {
+	ServiceID string
+	Name      string
+	Reason    string
+}
+
+func (e ServiceNameError) Error() string {
+	return fmt.Sprintf("service %s: name %q %s", e.ServiceID, e.Name, e.Reason)
+}
+
+// ServiceNamesError lists every rejected service name in a desired state.
+type ServiceNamesError []ServiceNameError
+
+func (e ServiceNamesError) Error() string {
+	messages := make([]string, len(e))
+	for i, nameErr := range e {
+		messages[i] = nameErr.Error()
+	}
+	return "invalid service names: " + strings.Join(messages, "; ")
+}
+
+// ValidateServiceNames checks that every service has a usable name and that
+// no two services share a slug. It returns a ServiceNamesError naming each
+// offending service.
+func ValidateServiceNames(services []Service, mode string) error {
+	var errs ServiceNamesError
+	bySlug := make(map[string][]Service)
+	for _, svc := range services {
+		slug := ServiceSlug(svc.Name)
+		switch {
+		case strings.TrimSpace(svc.Name) == "":
+			errs = append(errs, ServiceNameError{svc.ID, svc.Name, "is empty"})
+			continue
+		case mode == NamingStrict && !slugPattern.MatchString(svc.Name):
+			errs = append(errs, ServiceNameError{svc.ID, svc.Name, fmt.Sprintf("is not a valid name; use lowercase letters, digits and dashes (e.g. %q)", slug)})
+			continue
+		case slug == "":
+			errs = append(errs, ServiceNameError{svc.ID, svc.Name, "has no letters or digits"})
+			continue
+		}
+		bySlug[slug] = append(bySlug[slug], svc)
+	}
+
+	for slug, named := range bySlug {
+		if len(named) < 2 {
+			continue
+		}
+		for _, svc := range named {
+			var others []string
+			for _, other := range named {
+				if other.ID != svc.ID {
+					others = append(others, other.ID)
+				}
+			}
+			errs = append(errs, ServiceNameError{svc.ID, svc.Name, fmt.Sprintf("duplicates %q used by %s", slug, strings.Join(others, ", "))})
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	sort.SliceStable(errs, func(i, j int) bool { return errs[i].ServiceID < errs[j].ServiceID })
+	return errs
+}
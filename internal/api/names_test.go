@@ -0,0 +1,60 @@
+package api
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestServiceSlug(t *testing.T) {
+	t.Logf("Testing service name slugs")
+
+	tests := map[string]string{
+		"api":                          "api",
+		"My API_v2":                    "my-api-v2",
+		"  --Web  Frontend-- ":         "web-frontend",
+		"café":                         "caf",
+		"!!!":                          "",
+		strings.Repeat("a", 62) + "-b": strings.Repeat("a", 62),
+	}
+	for name, want := range tests {
+		if got := ServiceSlug(name); got != want {
+			t.Errorf("ServiceSlug(%q) = %q, want %q", name, got, want)
+		}
+	}
+	t.Logf("✓ Slugs are DNS-safe and deterministic")
+}
+
+func TestValidateServiceNames(t *testing.T) {
+	t.Logf("Testing service name validation")
+
+	services := []Service{
+		{ID: "svc-1", Name: "API"},
+		{ID: "svc-2", Name: "api"},
+		{ID: "svc-3", Name: ""},
+		{ID: "svc-4", Name: "web"},
+	}
+
+	err := ValidateServiceNames(services, NamingSlug)
+	var nameErrs ServiceNamesError
+	if !errors.As(err, &nameErrs) {
+		t.Fatalf("Expected ServiceNamesError, got %v", err)
+	}
+	if len(nameErrs) != 3 || nameErrs[0].ServiceID != "svc-1" || nameErrs[1].ServiceID != "svc-2" || nameErrs[2].ServiceID != "svc-3" {
+		t.Fatalf("Unexpected errors: %v", err)
+	}
+	if !strings.Contains(nameErrs[0].Reason, "svc-2") {
+		t.Errorf("Expected duplicate error to name the other service, got %q", nameErrs[0].Reason)
+	}
+
+	if err := ValidateServiceNames(services[1:2], NamingSlug); err != nil {
+		t.Errorf("Expected valid name to pass, got %v", err)
+	}
+	if err := ValidateServiceNames([]Service{{ID: "svc-1", Name: "My API"}}, NamingSlug); err != nil {
+		t.Errorf("Expected slug mode to accept sanitizable name, got %v", err)
+	}
+	if err := ValidateServiceNames([]Service{{ID: "svc-1", Name: "My API"}}, NamingStrict); err == nil || !strings.Contains(err.Error(), `"my-api"`) {
+		t.Errorf("Expected strict mode to reject name and suggest slug, got %v", err)
+	}
+	t.Logf("✓ Invalid and duplicate names rejected per service")
+}
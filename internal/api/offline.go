@@ -0,0 +1,34 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// offlineState is a desired state as persisted for offline use, keeping the
+// service definitions as received so its hashes survive the round trip.
+type offlineState struct {
+	State             *DesiredState     `json:"state"`
+	CanonicalServices map[string]string `json:"canonical_services,omitempty"`
+}
+
+// MarshalOfflineState encodes a fetched desired state so that it can be
+// restored with UnmarshalOfflineState when the control plane is unreachable.
+func MarshalOfflineState(state *DesiredState) ([]byte, error) {
+	return json.Marshal(offlineState{State: state, CanonicalServices: state.canonicalServices})
+}
+
+// UnmarshalOfflineState decodes a state encoded by MarshalOfflineState and
+// marks it Stale.
+func UnmarshalOfflineState(data []byte) (*DesiredState, error) {
+	var stored offlineState
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, fmt.Errorf("failed to decode cached desired state: %w", err)
+	}
+	if stored.State == nil {
+		return nil, fmt.Errorf("cached desired state is empty")
+	}
+	stored.State.canonicalServices = stored.CanonicalServices
+	stored.State.Stale = true
+	return stored.State, nil
+}
@@ -0,0 +1,38 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestOfflineStateRoundTrip(t *testing.T) {
+	t.Logf("Testing that a cached desired state restores with the same hash")
+
+	state := &DesiredState{StackID: "stack-1", Version: 4, Hash: "v4", Services: []Service{{ID: "svc-1", Name: "web"}}}
+	if err := state.recordCanonicalServices([]json.RawMessage{json.RawMessage(`{"id":"svc-1","name":"web","future_field":true}`)}); err != nil {
+		t.Fatalf("Failed to record canonical services: %v", err)
+	}
+
+	data, err := MarshalOfflineState(state)
+	if err != nil {
+		t.Fatalf("Failed to encode state: %v", err)
+	}
+	restored, err := UnmarshalOfflineState(data)
+	if err != nil {
+		t.Fatalf("Failed to decode state: %v", err)
+	}
+	if !restored.Stale || state.Stale {
+		t.Errorf("Expected only the restored state to be stale")
+	}
+	if restored.Version != 4 || len(restored.Services) != 1 || restored.Services[0].Name != "web" {
+		t.Errorf("Unexpected restored state: %+v", restored)
+	}
+	if restored.StateHash() != state.StateHash() {
+		t.Errorf("Expected the state hash to survive the round trip")
+	}
+
+	if _, err := UnmarshalOfflineState([]byte(`{}`)); err == nil {
+		t.Errorf("Expected an empty cache entry to be rejected")
+	}
+	t.Logf("✓ Cached state restored as stale with its hash intact")
+}
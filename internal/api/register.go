@@ -0,0 +1,71 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// ErrInstallTokenRejected is returned by Register when the control plane
+// refuses the install token, e.g. because it expired or was already used.
+var ErrInstallTokenRejected = errors.New("install token rejected")
+
+// RegistrationRequest exchanges a one-time install token for agent
+// credentials.
+type RegistrationRequest struct {
+	InstallToken string     `json:"install_token"`
+	Hostname     string     `json:"hostname"`
+	Agent        *AgentInfo `json:"agent,omitempty"`
+	// Scopes are the unbound scopes the agent needs for its enabled
+	// features; the control plane should grant no more.
+	Scopes []string `json:"scopes,omitempty"`
+}
+
+// RegistrationResponse holds the credentials of a newly registered agent.
+type RegistrationResponse struct {
+	AgentID string `json:"agent_id"`
+	APIKey  string `json:"api_key"`
+	StackID string `json:"stack_id"`
+	// Scopes are the scopes granted to APIKey; empty when the control plane
+	// does not scope credentials.
+	Scopes []string `json:"scopes,omitempty"`
+}
+
+// SetAPIKey sends key as a bearer token on every request.
+func (c *Client) SetAPIKey(key string) {
+	c.apiKey = key
+}
+
+// Register registers this machine with the control plane using an install
+// token and returns the agent's credentials.
+func (c *Client) Register(req RegistrationRequest) (*RegistrationResponse, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal registration: %w", err)
+	}
+
+	resp, err := c.do(context.Background(), "POST", "/api/agents/register", body, "application/json")
+	if err != nil {
+		return nil, fmt.Errorf("failed to register agent: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated:
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return nil, ErrInstallTokenRejected
+	default:
+		return nil, fmt.Errorf("registration failed with status: %d", resp.StatusCode)
+	}
+
+	var registration RegistrationResponse
+	if err := json.NewDecoder(resp.Body).Decode(&registration); err != nil {
+		return nil, fmt.Errorf("failed to decode registration: %w", err)
+	}
+	if registration.AgentID == "" || registration.APIKey == "" || registration.StackID == "" {
+		return nil, fmt.Errorf("registration response is missing agent_id, api_key or stack_id")
+	}
+	return &registration, nil
+}
@@ -0,0 +1,76 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestRegister(t *testing.T) {
+	t.Logf("Testing agent registration")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/api/agents/register" {
+			t.Errorf("Unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		var req RegistrationRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatalf("Failed to decode request: %v", err)
+		}
+		switch req.InstallToken {
+		case "good":
+			if !reflect.DeepEqual(req.Scopes, []string{ScopeStackRead, ScopeAgentHeartbeat}) {
+				t.Errorf("Expected the requested scopes, got %q", req.Scopes)
+			}
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(RegistrationResponse{AgentID: "agent-1", APIKey: "key-1", StackID: "stack-1", Scopes: []string{"stack:read:stack-1"}})
+		case "partial":
+			json.NewEncoder(w).Encode(RegistrationResponse{AgentID: "agent-1"})
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, "", "", "")
+	resp, err := client.Register(RegistrationRequest{InstallToken: "good", Hostname: "vm-1", Scopes: []string{ScopeStackRead, ScopeAgentHeartbeat}})
+	if err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+	if !reflect.DeepEqual(*resp, RegistrationResponse{AgentID: "agent-1", APIKey: "key-1", StackID: "stack-1", Scopes: []string{"stack:read:stack-1"}}) {
+		t.Errorf("Unexpected registration %+v", resp)
+	}
+
+	if _, err := client.Register(RegistrationRequest{InstallToken: "expired"}); !errors.Is(err, ErrInstallTokenRejected) {
+		t.Errorf("Expected ErrInstallTokenRejected, got %v", err)
+	}
+	if _, err := client.Register(RegistrationRequest{InstallToken: "partial"}); err == nil {
+		t.Error("Expected incomplete registration to fail")
+	}
+	t.Logf("✓ Registration returns credentials and reports rejected tokens")
+}
+
+func TestAPIKeyHeader(t *testing.T) {
+	t.Logf("Testing API key sent as bearer token")
+
+	var auth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, testAgentID, "", "")
+	client.SetAPIKey("secret-key")
+	if _, err := client.SendHeartbeat(context.Background(), HeartbeatRequest{}); err != nil {
+		t.Fatalf("Heartbeat failed: %v", err)
+	}
+	if auth != "Bearer secret-key" {
+		t.Errorf("Expected bearer token, got %q", auth)
+	}
+	t.Logf("✓ API key sent on requests")
+}
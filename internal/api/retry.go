@@ -0,0 +1,93 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// RetryPolicy controls how GetDesiredState and SendHeartbeat retry network
+// errors and 5xx responses (or, over gRPC, Unavailable and Internal). The
+// zero value makes a single attempt.
+type RetryPolicy struct {
+	MaxAttempts int           // Attempts including the first; 1 or less disables retries
+	BaseDelay   time.Duration // Wait before the first retry, doubled for each one after
+	MaxDelay    time.Duration // Upper bound on one wait; 0 means no bound
+	Jitter      float64       // Fraction (0 to 1) of each wait that is randomized
+}
+
+// SetRetryPolicy sets the retry policy for desired state fetches and
+// heartbeats.
+func (c *Client) SetRetryPolicy(policy RetryPolicy) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.retry = policy
+}
+
+// delay returns the wait before retry number n (starting at 1), reduced by
+// up to Jitter of itself so agents that failed together spread out.
+func (p RetryPolicy) delay(n int, random func() float64) time.Duration {
+	d := p.BaseDelay
+	for i := 1; i < n && (p.MaxDelay <= 0 || d < p.MaxDelay); i++ {
+		d *= 2
+	}
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	if p.Jitter > 0 {
+		d -= time.Duration(float64(d) * p.Jitter * random())
+	}
+	return d
+}
+
+// doWithRetry sends a request with doWithHeader, retrying network errors
+// and 5xx responses according to the retry policy. It gives up as soon as
+// ctx is done, returning ctx's error.
+func (c *Client) doWithRetry(ctx context.Context, method, path string, body []byte, contentType string, header http.Header) (*http.Response, error) {
+	c.mu.Lock()
+	policy := c.retry
+	c.mu.Unlock()
+
+	for attempt := 1; ; attempt++ {
+		resp, err := c.doWithHeader(ctx, method, path, body, contentType, header)
+		if ctx.Err() != nil {
+			if resp != nil {
+				resp.Body.Close()
+			}
+			return nil, ctx.Err()
+		}
+		if (err == nil && resp.StatusCode < http.StatusInternalServerError) || attempt >= policy.MaxAttempts {
+			return resp, err
+		}
+
+		reason := ""
+		if err != nil {
+			reason = err.Error()
+		} else {
+			reason = fmt.Sprintf("status %d", resp.StatusCode)
+			resp.Body.Close()
+		}
+		if err := waitRetry(ctx, policy, attempt, method+" "+path, reason); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// waitRetry logs a retry of request and waits before attempt+1, returning
+// ctx's error if it is done first.
+func waitRetry(ctx context.Context, policy RetryPolicy, attempt int, request, reason string) error {
+	wait := policy.delay(attempt, rand.Float64)
+	log.Printf("[API] Retrying request: request=%s attempt=%d/%d wait=%s reason=%s", request, attempt+1, policy.MaxAttempts, wait, reason)
+
+	timer := time.NewTimer(wait)
+	select {
+	case <-ctx.Done():
+		timer.Stop()
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,106 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicy_Delay(t *testing.T) {
+	t.Logf("Testing exponential backoff with jitter")
+
+	policy := RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	none := func() float64 { return 0 }
+	for n, want := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 4: 800 * time.Millisecond, 5: time.Second, 50: time.Second} {
+		if got := policy.delay(n, none); got != want {
+			t.Errorf("Retry %d: expected %s, got %s", n, want, got)
+		}
+	}
+
+	policy.Jitter = 0.5
+	if got := policy.delay(2, func() float64 { return 1 }); got != 100*time.Millisecond {
+		t.Errorf("Expected full jitter to halve the wait, got %s", got)
+	}
+	t.Logf("✓ Waits double up to the cap and jitter shortens them")
+}
+
+func TestSendHeartbeat_RetriesServerErrors(t *testing.T) {
+	t.Logf("Testing heartbeat retry on 502")
+
+	var hits atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hits.Add(1) < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, testAgentID, testAccessClientID, testAccessClientSecret)
+	client.SetRetryPolicy(RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond})
+	if _, err := client.SendHeartbeat(context.Background(), HeartbeatRequest{StackVersion: 1}); err != nil {
+		t.Fatalf("Expected heartbeat to succeed on the third attempt, got %v", err)
+	}
+	if hits.Load() != 3 {
+		t.Errorf("Expected 3 attempts, got %d", hits.Load())
+	}
+
+	hits.Store(-10)
+	if _, err := client.GetDesiredState(context.Background(), "stack-123"); err == nil {
+		t.Error("Expected desired state fetch to fail after 3 attempts")
+	}
+	if hits.Load() != -7 {
+		t.Errorf("Expected 3 more attempts, got %d", hits.Load()+10)
+	}
+	t.Logf("✓ Server errors retried up to the attempt limit")
+}
+
+func TestGetDesiredState_NoRetryOnClientError(t *testing.T) {
+	t.Logf("Testing 4xx responses are not retried")
+
+	var hits atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, testAgentID, testAccessClientID, testAccessClientSecret)
+	client.SetRetryPolicy(RetryPolicy{MaxAttempts: 5, BaseDelay: time.Millisecond})
+	if _, err := client.GetDesiredState(context.Background(), "stack-123"); err == nil {
+		t.Fatal("Expected error for HTTP 404")
+	}
+	if hits.Load() != 1 {
+		t.Errorf("Expected a single attempt, got %d", hits.Load())
+	}
+	t.Logf("✓ Client errors returned immediately")
+}
+
+func TestGetDesiredState_RetryAbandonedOnCancel(t *testing.T) {
+	t.Logf("Testing retries stop when the context is cancelled")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, testAgentID, testAccessClientID, testAccessClientSecret)
+	client.SetRetryPolicy(RetryPolicy{MaxAttempts: 10, BaseDelay: time.Hour})
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	start := time.Now()
+	_, err := client.GetDesiredState(ctx, "stack-123")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected cancellation to end the backoff wait, took %s", elapsed)
+	}
+	t.Logf("✓ Backoff abandoned on shutdown")
+}
@@ -0,0 +1,69 @@
+package api
+
+import (
+	"sort"
+	"strings"
+)
+
+// Scopes an agent credential may carry. Unbound, as requested at
+// registration, they apply to the stack and agent the credential is issued
+// for; the control plane grants them bound to an ID with BindScope, e.g.
+// "stack:read:stack-123". A "*" segment matches any value.
+const (
+	ScopeStackRead      = "stack:read"      // Desired state, its stream and service refs
+	ScopeAgentHeartbeat = "agent:heartbeat" // Heartbeats, lifecycle events and offline notices
+	ScopeAgentCommands  = "agent:commands"  // Remote commands and their results
+	ScopeAgentLogs      = "agent:logs"      // Shipped service logs
+	ScopeAgentReports   = "agent:reports"   // Build logs, deploy reports and diagnostics
+)
+
+// BindScope restricts scope to one stack or agent ID.
+func BindScope(scope, id string) string {
+	return scope + ":" + id
+}
+
+// scopeCovers reports whether a granted scope allows needed. An unbound
+// scope covers every ID, so it allows more than an agent needs.
+func scopeCovers(granted, needed string) bool {
+	grantedParts := strings.Split(granted, ":")
+	neededParts := strings.Split(needed, ":")
+	if len(grantedParts) > len(neededParts) {
+		return false
+	}
+	for i, part := range grantedParts {
+		if part != "*" && part != neededParts[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// CheckScopes compares a credential's granted scopes with the bound scopes
+// an agent needs. It returns the needed scopes no grant covers and the
+// granted scopes beyond those needed, both sorted.
+func CheckScopes(granted, needed []string) (missing, excess []string) {
+	neededSet := make(map[string]bool, len(needed))
+	for _, scope := range needed {
+		neededSet[scope] = true
+	}
+	for _, scope := range granted {
+		if scope = strings.TrimSpace(scope); scope != "" && !neededSet[scope] {
+			excess = append(excess, scope)
+		}
+	}
+	for _, scope := range needed {
+		covered := false
+		for _, grant := range granted {
+			if scopeCovers(strings.TrimSpace(grant), scope) {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			missing = append(missing, scope)
+		}
+	}
+	sort.Strings(missing)
+	sort.Strings(excess)
+	return missing, excess
+}
@@ -0,0 +1,36 @@
+package api
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCheckScopes(t *testing.T) {
+	t.Logf("Testing granted scopes against the scopes an agent needs")
+
+	needed := []string{
+		BindScope(ScopeStackRead, "stack-1"),
+		BindScope(ScopeAgentHeartbeat, "agent-1"),
+		BindScope(ScopeAgentCommands, "agent-1"),
+	}
+	for name, tc := range map[string]struct {
+		granted         []string
+		missing, excess []string
+	}{
+		"exact":         {needed, nil, nil},
+		"missing":       {needed[:2], []string{"agent:commands:agent-1"}, nil},
+		"other stack":   {append([]string{"stack:read:stack-2"}, needed...), nil, []string{"stack:read:stack-2"}},
+		"unbound":       {[]string{"stack:read", "agent:heartbeat:agent-1", "agent:commands:agent-1"}, nil, []string{"stack:read"}},
+		"wildcard":      {[]string{"*"}, nil, []string{"*"}},
+		"wildcard id":   {[]string{"stack:read:*", "agent:*:agent-1"}, nil, []string{"agent:*:agent-1", "stack:read:*"}},
+		"wrong agent":   {[]string{"stack:read:stack-1", "agent:heartbeat:agent-2"}, []string{"agent:commands:agent-1", "agent:heartbeat:agent-1"}, []string{"agent:heartbeat:agent-2"}},
+		"longer scope":  {[]string{"stack:read:stack-1:extra"}, []string{"agent:commands:agent-1", "agent:heartbeat:agent-1", "stack:read:stack-1"}, []string{"stack:read:stack-1:extra"}},
+		"nothing given": {nil, []string{"agent:commands:agent-1", "agent:heartbeat:agent-1", "stack:read:stack-1"}, nil},
+	} {
+		missing, excess := CheckScopes(tc.granted, needed)
+		if !reflect.DeepEqual(missing, tc.missing) || !reflect.DeepEqual(excess, tc.excess) {
+			t.Errorf("%s: expected missing=%q excess=%q, got missing=%q excess=%q", name, tc.missing, tc.excess, missing, excess)
+		}
+	}
+	t.Logf("✓ Missing and over-privileged scopes reported")
+}
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// maxDesiredStatePages bounds how many pages one desired state fetch follows.
+const maxDesiredStatePages = 1000
+
+type cachedService struct {
+	hash      string
+	service   Service
+	canonical string
+}
+
+// resolveServiceRefs fills state.Services from state.ServiceRefs, reusing
+// cached definitions whose hash is unchanged and fetching the rest. Cache
+// entries for services no longer referenced are dropped.
+func (c *Client) resolveServiceRefs(ctx context.Context, stackID string, state *DesiredState) error {
+	c.mu.Lock()
+	cache := c.services
+	c.mu.Unlock()
+
+	next := make(map[string]cachedService, len(state.ServiceRefs))
+	services := make([]Service, 0, len(state.ServiceRefs))
+	for _, ref := range state.ServiceRefs {
+		entry, ok := cache[ref.ID]
+		if !ok || entry.hash != ref.Hash {
+			service, canonical, err := c.getService(ctx, stackID, ref.ID)
+			if err != nil {
+				return err
+			}
+			entry = cachedService{hash: ref.Hash, service: *service, canonical: canonical}
+		}
+		next[ref.ID] = entry
+		services = append(services, entry.service)
+		state.setCanonicalService(ref.ID, entry.canonical)
+	}
+	// Full definitions sent inline alongside refs are used as-is.
+	state.Services = append(state.Services, services...)
+	state.ServiceRefs = nil
+
+	c.mu.Lock()
+	c.services = next
+	c.mu.Unlock()
+	return nil
+}
+
+// getService fetches a single service definition, returning it with its
+// canonical JSON.
+func (c *Client) getService(ctx context.Context, stackID, serviceID string) (*Service, string, error) {
+	resp, err := c.doWithRetry(ctx, "GET", fmt.Sprintf("/api/stacks/%s/services/%s", stackID, url.PathEscape(serviceID)), nil, "", nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to fetch service %s: %w", serviceID, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("unexpected status code fetching service %s: %d", serviceID, resp.StatusCode)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read service %s: %w", serviceID, err)
+	}
+	var service Service
+	if err := json.Unmarshal(data, &service); err != nil {
+		return nil, "", fmt.Errorf("failed to decode service %s: %w", serviceID, err)
+	}
+	_, canonical, err := canonicalService(data)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to decode service %s: %w", serviceID, err)
+	}
+	return &service, canonical, nil
+}
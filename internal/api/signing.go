@@ -0,0 +1,46 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// Headers carrying the HMAC signature of a control plane request.
+const (
+	TimestampHeader = "X-Agent-Timestamp"
+	NonceHeader     = "X-Agent-Nonce"
+	SignatureHeader = "X-Agent-Signature"
+)
+
+// signRequest signs req with the agent's API key, so a request can't be
+// forged or replayed by someone holding only the Access service token.
+// Requests are sent unsigned until the agent has an API key.
+func (c *Client) signRequest(req *http.Request, body []byte) {
+	if c.apiKey == "" {
+		return
+	}
+	timestamp := c.now().Unix()
+	var raw [16]byte
+	if _, err := rand.Read(raw[:]); err != nil {
+		return
+	}
+	nonce := hex.EncodeToString(raw[:])
+	req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(NonceHeader, nonce)
+	req.Header.Set(SignatureHeader, RequestSignature([]byte(c.apiKey), timestamp, nonce, req.Method, req.URL.RequestURI(), body))
+}
+
+// RequestSignature returns the hex HMAC-SHA256 of a request: the unix
+// timestamp, nonce, method, request URI and SHA-256 of the body, newline
+// separated. The control plane recomputes it with the agent's API key.
+func RequestSignature(key []byte, timestamp int64, nonce, method, requestURI string, body []byte) string {
+	bodyHash := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "%d\n%s\n%s\n%s\n%s", timestamp, nonce, method, requestURI, hex.EncodeToString(bodyHash[:]))
+	return hex.EncodeToString(mac.Sum(nil))
+}
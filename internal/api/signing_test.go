@@ -0,0 +1,58 @@
+package api
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestRequestSigning(t *testing.T) {
+	t.Logf("Testing HMAC signatures on control plane requests")
+
+	var headers []http.Header
+	var bodies [][]byte
+	var uris []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		headers = append(headers, r.Header.Clone())
+		bodies = append(bodies, body)
+		uris = append(uris, r.URL.RequestURI())
+		w.Write([]byte(`{"status":"ok"}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, testAgentID, testAccessClientID, testAccessClientSecret)
+	if _, err := client.SendHeartbeat(context.Background(), HeartbeatRequest{AgentStatus: "online"}); err != nil {
+		t.Fatalf("Heartbeat failed: %v", err)
+	}
+	if headers[0].Get(SignatureHeader) != "" {
+		t.Errorf("Expected no signature without an API key")
+	}
+
+	client.SetAPIKey("agent-key")
+	for i := 0; i < 2; i++ {
+		if _, err := client.SendHeartbeat(context.Background(), HeartbeatRequest{AgentStatus: "online"}); err != nil {
+			t.Fatalf("Heartbeat failed: %v", err)
+		}
+	}
+	for i := 1; i <= 2; i++ {
+		timestamp, err := strconv.ParseInt(headers[i].Get(TimestampHeader), 10, 64)
+		if err != nil {
+			t.Fatalf("Invalid timestamp header: %q", headers[i].Get(TimestampHeader))
+		}
+		expected := RequestSignature([]byte("agent-key"), timestamp, headers[i].Get(NonceHeader), http.MethodPost, uris[i], bodies[i])
+		if headers[i].Get(SignatureHeader) != expected {
+			t.Errorf("Signature mismatch: got %q, want %q", headers[i].Get(SignatureHeader), expected)
+		}
+	}
+	if headers[1].Get(NonceHeader) == headers[2].Get(NonceHeader) {
+		t.Errorf("Expected a fresh nonce per request")
+	}
+	if RequestSignature([]byte("agent-key"), 1, "n", "POST", "/a", []byte("x")) == RequestSignature([]byte("agent-key"), 1, "n", "POST", "/a", []byte("y")) {
+		t.Errorf("Expected the body to be covered by the signature")
+	}
+	t.Logf("✓ Requests signed with the API key, each with its own nonce")
+}
@@ -0,0 +1,56 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+// MergeDesiredStates combines the desired states of the stacks an agent
+// serves into one, so they are reconciled together. Host-wide settings
+// (intervals, security mode, SSH, the external proxy port and feature flags)
+// come from the first, primary, stack. Each service records its stack in
+// StackID. A service whose ID an earlier stack already uses is left out and
+// its ID returned.
+func MergeDesiredStates(states []*DesiredState) (*DesiredState, []string) {
+	primary := states[0]
+	merged := &DesiredState{
+		StackID:           primary.StackID,
+		Version:           primary.Version,
+		PollInterval:      primary.PollInterval,
+		HeartbeatInterval: primary.HeartbeatInterval,
+		SecurityMode:      primary.SecurityMode,
+		SSHPort:           primary.SSHPort,
+		SSHAllowedCIDR:    primary.SSHAllowedCIDR,
+		ExternalProxyPort: primary.ExternalProxyPort,
+		Features:          primary.Features,
+		NotModified:       true,
+	}
+
+	var duplicates []string
+	seen := make(map[string]bool)
+	hash := sha256.New()
+	for _, state := range states {
+		fmt.Fprintf(hash, "%s=%s\n", state.StackID, state.StateHash())
+		merged.NotModified = merged.NotModified && state.NotModified
+		merged.Stale = merged.Stale || state.Stale
+		merged.SyntheticChecks = append(merged.SyntheticChecks, state.SyntheticChecks...)
+		// Groups are already applied to the services; they are kept so a
+		// change to one still changes the merged state hash.
+		merged.Groups = append(merged.Groups, state.Groups...)
+		for _, svc := range state.Services {
+			if seen[svc.ID] {
+				duplicates = append(duplicates, svc.ID)
+				continue
+			}
+			seen[svc.ID] = true
+			svc.StackID = state.StackID
+			merged.Services = append(merged.Services, svc)
+			if canonical, ok := state.canonicalServices[svc.ID]; ok {
+				merged.setCanonicalService(svc.ID, canonical)
+			}
+		}
+	}
+	merged.Hash = hex.EncodeToString(hash.Sum(nil))
+	return merged, duplicates
+}
@@ -0,0 +1,38 @@
+package api
+
+import "testing"
+
+func TestMergeDesiredStates(t *testing.T) {
+	t.Logf("Testing merging the desired states of several stacks")
+
+	primary := &DesiredState{
+		StackID: "stack-a", Version: 3, Hash: "a3", SecurityMode: "daemon-port", PollInterval: 30, NotModified: true,
+		Services: []Service{{ID: "svc-1", Name: "api"}},
+	}
+	secondary := &DesiredState{
+		StackID: "stack-b", Version: 7, Hash: "b7", SecurityMode: "none", PollInterval: 60,
+		Services: []Service{{ID: "svc-2", Name: "api"}, {ID: "svc-1", Name: "shadow"}},
+	}
+
+	merged, duplicates := MergeDesiredStates([]*DesiredState{primary, secondary})
+	if merged.StackID != "stack-a" || merged.Version != 3 || merged.SecurityMode != "daemon-port" || merged.PollInterval != 30 {
+		t.Errorf("Expected host settings from the primary stack, got %+v", merged)
+	}
+	if len(merged.Services) != 2 || merged.Services[0].StackID != "stack-a" || merged.Services[1].StackID != "stack-b" {
+		t.Errorf("Expected one service per stack tagged with its stack, got %+v", merged.Services)
+	}
+	if len(duplicates) != 1 || duplicates[0] != "svc-1" {
+		t.Errorf("Expected svc-1 from the second stack dropped, got %v", duplicates)
+	}
+	if merged.NotModified {
+		t.Errorf("Expected merged state modified when any stack is")
+	}
+
+	before := merged.StateHash()
+	secondary.Services[0].DockerImage = "nginx:1.26"
+	again, _ := MergeDesiredStates([]*DesiredState{primary, secondary})
+	if again.StateHash() == before {
+		t.Errorf("Expected a change in the second stack to change the merged state hash")
+	}
+	t.Logf("✓ Stacks merged with primary host settings and per-service stacks")
+}
@@ -0,0 +1,103 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// StreamIdleTimeout is how long a desired state stream may stay silent
+// before it is treated as dead. The control plane sends keep-alive comments
+// more often than this.
+const StreamIdleTimeout = 90 * time.Second
+
+// ErrStreamUnsupported is returned by WatchDesiredState when the control
+// plane has no desired state stream.
+var ErrStreamUnsupported = errors.New("desired state stream not supported")
+
+// StateEvent announces that a new desired state version was published, or,
+// with Commands set, that commands are waiting for the agent.
+type StateEvent struct {
+	Version  int    `json:"version"`
+	Hash     string `json:"hash"`
+	Commands bool   `json:"-"`
+}
+
+// WatchDesiredState holds a server-sent events stream open to the active
+// control plane URL and calls onEvent for each "state" and "commands" event. onConnect is
+// called once the stream is established. It returns when the stream ends,
+// falls silent for StreamIdleTimeout, or ctx is cancelled.
+func (c *Client) WatchDesiredState(ctx context.Context, stackID string, onConnect func(), onEvent func(StateEvent)) error {
+	streamCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(streamCtx, http.MethodGet, c.BaseURL()+fmt.Sprintf("/api/stacks/%s/desired-state/stream", stackID), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Cache-Control", "no-cache")
+	c.setAccessHeaders(req)
+	c.signRequest(req, nil)
+
+	// The stream outlives the request timeout of the regular client.
+	client := &http.Client{Transport: c.httpClient.Transport}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to open desired state stream: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusNotImplemented:
+		return ErrStreamUnsupported
+	case resp.StatusCode != http.StatusOK:
+		return fmt.Errorf("unexpected status code opening desired state stream: %d", resp.StatusCode)
+	}
+	if onConnect != nil {
+		onConnect()
+	}
+
+	idle := time.AfterFunc(StreamIdleTimeout, cancel)
+	defer idle.Stop()
+
+	scanner := bufio.NewScanner(resp.Body)
+	var eventType string
+	var data []string
+	for scanner.Scan() {
+		idle.Reset(StreamIdleTimeout)
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if eventType == "state" && len(data) > 0 {
+				var event StateEvent
+				if err := json.Unmarshal([]byte(strings.Join(data, "\n")), &event); err == nil {
+					onEvent(event)
+				}
+			} else if eventType == "commands" {
+				onEvent(StateEvent{Commands: true})
+			}
+			eventType, data = "", nil
+		case strings.HasPrefix(line, ":"):
+			// Keep-alive comment
+		case strings.HasPrefix(line, "event:"):
+			eventType = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			data = append(data, strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+	}
+	switch {
+	case ctx.Err() != nil:
+		return ctx.Err()
+	case streamCtx.Err() != nil:
+		return fmt.Errorf("desired state stream idle for %s", StreamIdleTimeout)
+	case scanner.Err() != nil:
+		return fmt.Errorf("desired state stream failed: %w", scanner.Err())
+	}
+	return fmt.Errorf("desired state stream closed")
+}
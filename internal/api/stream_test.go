@@ -0,0 +1,64 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWatchDesiredState(t *testing.T) {
+	t.Logf("Testing desired state event stream")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/stacks/stack-123/desired-state/stream" || r.Header.Get("Accept") != "text/event-stream" {
+			t.Errorf("Unexpected request %s accept=%q", r.URL.Path, r.Header.Get("Accept"))
+		}
+		if r.Header.Get("X-Agent-Id") != testAgentID {
+			t.Errorf("Expected agent ID header, got %q", r.Header.Get("X-Agent-Id"))
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, ": keep-alive\n\n")
+		fmt.Fprint(w, "event: state\ndata: {\"version\":5,\"hash\":\"abc\"}\n\n")
+		fmt.Fprint(w, "event: ping\ndata: {}\n\n")
+		fmt.Fprint(w, "event: commands\ndata: {}\n\n")
+		fmt.Fprint(w, "event: state\ndata: {\"version\":6,\n")
+		fmt.Fprint(w, "data: \"hash\":\"def\"}\n\n")
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, testAgentID, testAccessClientID, testAccessClientSecret)
+	connected := false
+	var events []StateEvent
+	err := client.WatchDesiredState(context.Background(), "stack-123", func() { connected = true }, func(e StateEvent) {
+		events = append(events, e)
+	})
+	if err == nil {
+		t.Error("Expected an error when the stream closes")
+	}
+	if !connected {
+		t.Error("Expected onConnect to be called")
+	}
+	if len(events) != 3 || events[0] != (StateEvent{Version: 5, Hash: "abc"}) || events[1] != (StateEvent{Commands: true}) || events[2] != (StateEvent{Version: 6, Hash: "def"}) {
+		t.Errorf("Unexpected events %+v", events)
+	}
+
+	t.Logf("✓ State and command events parsed from the stream")
+}
+
+func TestWatchDesiredState_Unsupported(t *testing.T) {
+	t.Logf("Testing desired state stream on a control plane without one")
+
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	client := NewClient(server.URL, testAgentID, testAccessClientID, testAccessClientSecret)
+	err := client.WatchDesiredState(context.Background(), "stack-123", nil, func(StateEvent) {})
+	if !errors.Is(err, ErrStreamUnsupported) {
+		t.Errorf("Expected ErrStreamUnsupported, got %v", err)
+	}
+
+	t.Logf("✓ Missing stream endpoint reported")
+}
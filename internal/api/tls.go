@@ -0,0 +1,91 @@
+package api
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// SetClientTLS enables mutual TLS to the control plane. certFile and keyFile
+// are the agent's PEM client certificate and key, presented on every
+// connection; caFile is a PEM bundle of CAs trusted for the control plane
+// instead of the system roots. Any may be empty, but the certificate and key
+// go together. The certificate is re-read on each new connection, so a
+// renewed one is picked up without a restart.
+func (c *Client) SetClientTLS(certFile, keyFile, caFile string) error {
+	if certFile == "" && keyFile == "" && caFile == "" {
+		return nil
+	}
+	if (certFile == "") != (keyFile == "") {
+		return fmt.Errorf("client certificate and key must be set together")
+	}
+
+	if certFile != "" {
+		if _, err := tls.LoadX509KeyPair(certFile, keyFile); err != nil {
+			return fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		c.clientCert = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to load client certificate: %w", err)
+			}
+			return &cert, nil
+		}
+	}
+	if caFile != "" {
+		bundle, err := os.ReadFile(caFile)
+		if err != nil {
+			return fmt.Errorf("failed to read CA bundle: %w", err)
+		}
+		if !x509.NewCertPool().AppendCertsFromPEM(bundle) {
+			return fmt.Errorf("no certificates found in CA bundle %s", caFile)
+		}
+		c.caBundle = bundle
+	}
+	c.applyTLS()
+	return nil
+}
+
+// SetExtraCAs trusts the PEM certificates in bundle for the control plane
+// on top of the system roots, or of the CA bundle given to SetClientTLS,
+// such as the CA of a TLS-inspecting egress proxy. An empty bundle leaves
+// the client unchanged.
+func (c *Client) SetExtraCAs(bundle []byte) error {
+	if len(bundle) == 0 {
+		return nil
+	}
+	if !x509.NewCertPool().AppendCertsFromPEM(bundle) {
+		return fmt.Errorf("no certificates found in extra CAs")
+	}
+	c.extraCAs = bundle
+	c.applyTLS()
+	return nil
+}
+
+// applyTLS rebuilds the client's TLS config and transports from its client
+// certificate and CAs. The transport keeps the default proxy settings.
+func (c *Client) applyTLS() {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12, GetClientCertificate: c.clientCert}
+	if c.caBundle != nil || c.extraCAs != nil {
+		pool := x509.NewCertPool()
+		if c.caBundle == nil {
+			if system, err := x509.SystemCertPool(); err == nil {
+				pool = system
+			}
+		}
+		pool.AppendCertsFromPEM(c.caBundle)
+		pool.AppendCertsFromPEM(c.extraCAs)
+		tlsConfig.RootCAs = pool
+	}
+
+	c.tlsConfig = tlsConfig
+	for _, conn := range c.grpcConns {
+		conn.Close()
+	}
+	c.grpcConns = nil
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	c.httpClient.Transport = transport
+}
@@ -0,0 +1,191 @@
+package api
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+// newTestCert issues a certificate signed by parent, or a self-signed CA
+// when parent is nil.
+func newTestCert(t *testing.T, name string, parent *testCert, usage x509.ExtKeyUsage) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	return &testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+func writeTestFile(t *testing.T, dir, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatalf("Failed to write %s: %v", name, err)
+	}
+	return path
+}
+
+func TestClient_MutualTLS(t *testing.T) {
+	t.Logf("Testing mutual TLS to the control plane")
+
+	ca := newTestCert(t, "test-ca", nil, x509.ExtKeyUsageAny)
+	serverCert := newTestCert(t, "control-plane", ca, x509.ExtKeyUsageServerAuth)
+	clientCert := newTestCert(t, "agent-123", ca, x509.ExtKeyUsageClientAuth)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	serverPair, err := tls.X509KeyPair(serverCert.certPEM, serverCert.keyPEM)
+	if err != nil {
+		t.Fatalf("Failed to load server certificate: %v", err)
+	}
+	var peer string
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		peer = r.TLS.PeerCertificates[0].Subject.CommonName
+		w.Write([]byte("{}"))
+	}))
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{serverPair},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	dir := t.TempDir()
+	certFile := writeTestFile(t, dir, "agent.crt", clientCert.certPEM)
+	keyFile := writeTestFile(t, dir, "agent.key", clientCert.keyPEM)
+	caFile := writeTestFile(t, dir, "ca.pem", ca.certPEM)
+
+	// Without a client certificate the server refuses the handshake.
+	client := NewClient(server.URL, testAgentID, testAccessClientID, testAccessClientSecret)
+	if err := client.SetClientTLS("", "", caFile); err != nil {
+		t.Fatalf("SetClientTLS failed: %v", err)
+	}
+	if _, err := client.SendHeartbeat(context.Background(), HeartbeatRequest{}); err == nil {
+		t.Fatal("Expected heartbeat without a client certificate to fail")
+	}
+
+	client = NewClient(server.URL, testAgentID, testAccessClientID, testAccessClientSecret)
+	if err := client.SetClientTLS(certFile, keyFile, caFile); err != nil {
+		t.Fatalf("SetClientTLS failed: %v", err)
+	}
+	if _, err := client.SendHeartbeat(context.Background(), HeartbeatRequest{}); err != nil {
+		t.Fatalf("Expected heartbeat over mutual TLS to succeed, got %v", err)
+	}
+	if peer != "agent-123" {
+		t.Errorf("Expected server to see client certificate agent-123, got %q", peer)
+	}
+	t.Logf("✓ Agent authenticated with its client certificate")
+}
+
+func TestClient_SetClientTLSErrors(t *testing.T) {
+	t.Logf("Testing invalid TLS settings are rejected")
+
+	dir := t.TempDir()
+	client := NewClient("https://control.example", testAgentID, "", "")
+	if err := client.SetClientTLS("", "", ""); err != nil {
+		t.Errorf("Expected no TLS settings to be accepted, got %v", err)
+	}
+	if err := client.SetClientTLS(filepath.Join(dir, "agent.crt"), "", ""); err == nil {
+		t.Error("Expected certificate without key to be rejected")
+	}
+	if err := client.SetClientTLS(filepath.Join(dir, "agent.crt"), filepath.Join(dir, "agent.key"), ""); err == nil {
+		t.Error("Expected missing certificate files to be rejected")
+	}
+	if err := client.SetClientTLS("", "", writeTestFile(t, dir, "ca.pem", []byte("not a certificate"))); err == nil {
+		t.Error("Expected CA bundle without certificates to be rejected")
+	}
+	t.Logf("✓ Invalid TLS settings rejected")
+}
+
+func TestClient_SetExtraCAs(t *testing.T) {
+	t.Logf("Testing extra CAs trusted alongside the control plane CA")
+
+	proxyCA := newTestCert(t, "proxy-ca", nil, x509.ExtKeyUsageAny)
+	serverCert := newTestCert(t, "control-plane", proxyCA, x509.ExtKeyUsageServerAuth)
+	serverPair, err := tls.X509KeyPair(serverCert.certPEM, serverCert.keyPEM)
+	if err != nil {
+		t.Fatalf("Failed to load server certificate: %v", err)
+	}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("{}"))
+	}))
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{serverPair}}
+	server.StartTLS()
+	defer server.Close()
+
+	client := NewClient(server.URL, testAgentID, testAccessClientID, testAccessClientSecret)
+	if _, err := client.SendHeartbeat(context.Background(), HeartbeatRequest{}); err == nil {
+		t.Fatal("Expected heartbeat to an untrusted CA to fail")
+	}
+
+	// The extra CAs are kept when control_plane_ca_cert names another CA,
+	// whichever is set first.
+	otherCA := newTestCert(t, "other-ca", nil, x509.ExtKeyUsageAny)
+	caFile := writeTestFile(t, t.TempDir(), "ca.pem", otherCA.certPEM)
+	if err := client.SetExtraCAs(proxyCA.certPEM); err != nil {
+		t.Fatalf("SetExtraCAs failed: %v", err)
+	}
+	if err := client.SetClientTLS("", "", caFile); err != nil {
+		t.Fatalf("SetClientTLS failed: %v", err)
+	}
+	if _, err := client.SendHeartbeat(context.Background(), HeartbeatRequest{}); err != nil {
+		t.Fatalf("Expected heartbeat trusted by the extra CA to succeed, got %v", err)
+	}
+
+	if err := client.SetExtraCAs([]byte("not a certificate")); err == nil {
+		t.Error("Expected extra CAs without certificates to be rejected")
+	}
+	if err := client.SetExtraCAs(nil); err != nil {
+		t.Errorf("Expected no extra CAs to be accepted, got %v", err)
+	}
+	t.Logf("✓ Control plane trusted through the extra CA")
+}
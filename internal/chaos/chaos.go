@@ -0,0 +1,123 @@
+// Package chaos injects simulated failures (build failures, health check
+// timeouts, control plane 500s, slow docker commands) at configured rates, so
+// the reconciler's failure handling can be tested end-to-end in CI.
+//
+// Injection only happens in builds with the chaos tag; release builds ignore
+// the chaos_* config options:
+//
+//	go build -tags chaos ./cmd/agent
+package chaos
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Fault is a kind of simulated failure.
+type Fault string
+
+const (
+	BuildFailure      Fault = "build_failure"
+	HealthTimeout     Fault = "health_timeout"
+	ControlPlaneError Fault = "control_plane_error"
+	DockerSlowdown    Fault = "docker_slowdown"
+)
+
+// ErrInjected is wrapped by every simulated failure.
+var ErrInjected = errors.New("injected by chaos mode")
+
+// Settings are the injection rates, each the probability (0 to 1) that one
+// operation fails, and how long a slowed docker command is delayed.
+type Settings struct {
+	Rates       map[Fault]float64
+	DockerDelay time.Duration
+}
+
+// enabled is set by the chaos build tag; tests override it.
+var enabled = buildEnabled
+
+var (
+	mu      sync.Mutex
+	current Settings
+	roll    = rand.Float64
+	sleep   = time.Sleep
+)
+
+// Enabled reports whether this build injects failures.
+func Enabled() bool {
+	return enabled
+}
+
+// Configure validates and applies the injection rates. It returns whether
+// any failure will be injected.
+func Configure(settings Settings) (bool, error) {
+	active := false
+	for fault, rate := range settings.Rates {
+		if rate < 0 || rate > 1 {
+			return false, fmt.Errorf("invalid chaos rate for %s: %v; expected 0 to 1", fault, rate)
+		}
+		if rate > 0 {
+			active = true
+		}
+	}
+	if settings.DockerDelay < 0 {
+		return false, fmt.Errorf("invalid chaos docker delay %s", settings.DockerDelay)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	current = settings
+	return active && enabled, nil
+}
+
+// Summary describes the configured rates for logging, e.g.
+// "build_failure=0.2 health_timeout=0.1".
+func Summary() string {
+	mu.Lock()
+	defer mu.Unlock()
+	var parts []string
+	for fault, rate := range current.Rates {
+		if rate > 0 {
+			parts = append(parts, fmt.Sprintf("%s=%v", fault, rate))
+		}
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, " ")
+}
+
+// Inject reports whether this operation should fail with the given fault.
+func Inject(fault Fault) bool {
+	if !enabled {
+		return false
+	}
+	mu.Lock()
+	rate := current.Rates[fault]
+	mu.Unlock()
+	if rate <= 0 || roll() >= rate {
+		return false
+	}
+	log.Printf("[Chaos] Injecting fault: fault=%s", fault)
+	return true
+}
+
+// Error returns a simulated failure for the fault.
+func Error(fault Fault, format string, args ...interface{}) error {
+	return fmt.Errorf("%s (%s): %w", fmt.Sprintf(format, args...), fault, ErrInjected)
+}
+
+// Delay slows a docker command when a docker_slowdown fault is injected.
+func Delay() {
+	if !Inject(DockerSlowdown) {
+		return
+	}
+	mu.Lock()
+	delay := current.DockerDelay
+	mu.Unlock()
+	sleep(delay)
+}
@@ -0,0 +1,78 @@
+package chaos
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func withChaos(t *testing.T, on bool, value float64) {
+	t.Helper()
+	oldEnabled, oldRoll, oldSleep := enabled, roll, sleep
+	t.Cleanup(func() {
+		enabled, roll, sleep = oldEnabled, oldRoll, oldSleep
+		Configure(Settings{})
+	})
+	enabled = on
+	roll = func() float64 { return value }
+}
+
+func TestConfigureValidatesRates(t *testing.T) {
+	t.Logf("Testing chaos rate validation")
+	withChaos(t, true, 0)
+
+	if _, err := Configure(Settings{Rates: map[Fault]float64{BuildFailure: 1.5}}); err == nil {
+		t.Errorf("Expected error for rate above 1")
+	}
+	if _, err := Configure(Settings{DockerDelay: -time.Second}); err == nil {
+		t.Errorf("Expected error for negative delay")
+	}
+	active, err := Configure(Settings{Rates: map[Fault]float64{BuildFailure: 0.5, HealthTimeout: 0}})
+	if err != nil || !active {
+		t.Errorf("Expected active chaos settings, got active=%t err=%v", active, err)
+	}
+	if got := Summary(); got != "build_failure=0.5" {
+		t.Errorf("Unexpected summary %q", got)
+	}
+	t.Logf("✓ Chaos rates validated")
+}
+
+func TestInjectUsesRates(t *testing.T) {
+	t.Logf("Testing fault injection")
+	withChaos(t, true, 0.3)
+	var slept time.Duration
+	sleep = func(d time.Duration) { slept = d }
+
+	Configure(Settings{Rates: map[Fault]float64{BuildFailure: 0.5, HealthTimeout: 0.2, DockerSlowdown: 1}, DockerDelay: 2 * time.Second})
+	if !Inject(BuildFailure) {
+		t.Errorf("Expected build failure with roll below rate")
+	}
+	if Inject(HealthTimeout) {
+		t.Errorf("Expected no health timeout with roll above rate")
+	}
+	if Inject(ControlPlaneError) {
+		t.Errorf("Expected no fault without a rate")
+	}
+	Delay()
+	if slept != 2*time.Second {
+		t.Errorf("Expected docker delay of 2s, got %s", slept)
+	}
+	if err := Error(BuildFailure, "docker build failed"); !errors.Is(err, ErrInjected) {
+		t.Errorf("Expected injected error, got %v", err)
+	}
+	t.Logf("✓ Faults injected at configured rates")
+}
+
+func TestInjectDisabledWithoutBuildTag(t *testing.T) {
+	t.Logf("Testing chaos mode off in release builds")
+	withChaos(t, false, 0)
+
+	active, err := Configure(Settings{Rates: map[Fault]float64{BuildFailure: 1}})
+	if err != nil || active {
+		t.Errorf("Expected inactive settings, got active=%t err=%v", active, err)
+	}
+	if Inject(BuildFailure) {
+		t.Errorf("Expected no faults without the chaos build tag")
+	}
+	t.Logf("✓ No faults without the chaos build tag")
+}
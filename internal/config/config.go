@@ -1,37 +1,236 @@
 package config
 
 import (
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
 
+	"github.com/buildvigil/agent/internal/platform"
 	"github.com/buildvigil/agent/internal/tunnel"
 )
 
 // Config holds the agent configuration.
 type Config struct {
-	AgentID            string `json:"agent_id"`
-	StackID            string `json:"stack_id"`
-	ControlPlane       string `json:"control_plane"`
-	PollInterval       int    `json:"poll_interval"`
-	DataDir            string `json:"data_dir"`
-	ExternalProxyPort  int    `json:"external_proxy_port"`
-	SecurityMode       string `json:"security_mode"`
+	AgentID           string   `json:"agent_id"`
+	StackID           string   `json:"stack_id"`
+	StackIDs          []string `json:"stack_ids,omitempty"` // Optional: further stacks served besides stack_id
+	ControlPlane      string   `json:"control_plane"`
+	PollInterval      int      `json:"poll_interval"`
+	DataDir           string   `json:"data_dir"`
+	ExternalProxyPort int      `json:"external_proxy_port"`
+	SecurityMode      string   `json:"security_mode"`
+	SSHPort           int      `json:"ssh_port"`                   // Allowed through the firewall; 0 closes SSH
+	SSHAllowedCIDR    string   `json:"ssh_allowed_cidr,omitempty"` // Optional: only allow SSH from this range
+
+	// FirewallConfirmMinutes is how long new firewall rules have to be
+	// confirmed by a heartbeat before they are rolled back; 0 disables.
+	FirewallConfirmMinutes int `json:"firewall_confirm_minutes"`
+
+	// ControlPlaneFallbacks are tried in order when control_plane is down.
+	ControlPlaneFallbacks []string `json:"control_plane_fallbacks,omitempty"`
+	// ControlPlaneTransport is "http" (default) or "grpc" for desired state
+	// fetches, heartbeats and lifecycle events.
+	ControlPlaneTransport string `json:"control_plane_transport,omitempty"`
+	// LifecycleFlushSeconds is how long lifecycle updates are gathered into
+	// one heartbeat when they can't be streamed.
+	LifecycleFlushSeconds int `json:"lifecycle_flush_seconds"`
+	// HeartbeatQueueSize is how many undelivered heartbeats are kept for
+	// replay; 0 disables the queue.
+	HeartbeatQueueSize int `json:"heartbeat_queue_size"`
+
+	// APIRetry* retry desired state fetches and heartbeats that fail with a
+	// network error or 5xx, waiting BaseDelay, doubling up to MaxDelay, with
+	// up to Jitter (0 to 1) of each wait randomized.
+	APIRetryAttempts    int     `json:"api_retry_attempts"`
+	APIRetryBaseDelayMs int     `json:"api_retry_base_delay_ms"`
+	APIRetryMaxDelayMs  int     `json:"api_retry_max_delay_ms"`
+	APIRetryJitter      float64 `json:"api_retry_jitter"`
+
+	// HTTPProxy, HTTPSProxy and NoProxy are exported to the agent's
+	// environment for outbound calls, git and docker builds, for hosts where
+	// the service unit does not inherit them.
+	HTTPProxy  string `json:"http_proxy,omitempty"`
+	HTTPSProxy string `json:"https_proxy,omitempty"`
+	NoProxy    string `json:"no_proxy,omitempty"`
+	// CACerts are PEM files of extra CAs trusted on top of the system roots
+	// by the control plane client, Cloudflare API calls and git over HTTPS,
+	// such as a TLS-inspecting proxy's.
+	CACerts []string `json:"ca_certs,omitempty"`
+
+	// RegistryHosts and NTPServers are always allowed outbound by the
+	// firewall, along with the control plane and DNS servers.
+	RegistryHosts []string `json:"registry_hosts"`
+	NTPServers    []string `json:"ntp_servers"`
+
 	GitSSHKeyDir       string `json:"git_ssh_key_dir"`
 	AccessClientID     string `json:"access_client_id"`
 	AccessClientSecret string `json:"access_client_secret"`
+	// APIKey authenticates the agent to the control plane; -register sets it.
+	APIKey string `json:"api_key,omitempty"`
+	// APIKeyScopes are the scopes granted to APIKey at registration; the
+	// agent warns when they go beyond or fall short of what it needs.
+	APIKeyScopes []string `json:"api_key_scopes,omitempty"`
+	// ControlPlaneClientCert and ControlPlaneClientKey are PEM files for
+	// mutual TLS to the control plane; ControlPlaneCACert is a PEM bundle
+	// trusted for it instead of the system roots.
+	ControlPlaneClientCert string `json:"control_plane_client_cert,omitempty"`
+	ControlPlaneClientKey  string `json:"control_plane_client_key,omitempty"`
+	ControlPlaneCACert     string `json:"control_plane_ca_cert,omitempty"`
 
 	VerboseLogging bool `json:"verbose_logging"`
 	PortRangeStart int  `json:"port_range_start"`
 	PortRangeEnd   int  `json:"port_range_end"`
 	LogRetention   int  `json:"log_retention"`
 
+	// ExcludedPorts ("3306", "5000-5010") are never allocated to services;
+	// PortRanges names sub-ranges, e.g. {"web": "3000-3049"}.
+	ExcludedPorts []string          `json:"excluded_ports,omitempty"`
+	PortRanges    map[string]string `json:"port_ranges,omitempty"`
+	// PortPairing is "consecutive" (default) or "any".
+	PortPairing string `json:"port_pairing,omitempty"`
+	// ReposDir moves git checkouts off DataDir; BuildDir makes each build run
+	// from a copy under it (e.g. on tmpfs), removed once the build succeeds.
+	ReposDir string `json:"repos_dir,omitempty"`
+	BuildDir string `json:"build_dir,omitempty"`
+	// ServiceUser and ServiceGroup own the agent's files; when the agent
+	// starts as root, git and plugins also run as them. FileUmask (octal,
+	// e.g. "027") is applied to everything the agent creates.
+	ServiceUser  string `json:"service_user,omitempty"`
+	ServiceGroup string `json:"service_group,omitempty"`
+	FileUmask    string `json:"file_umask,omitempty"`
+	// PrivilegedHelperSocket is the unix socket of the privileged helper
+	// ("potato-cloud-agent helper"). When set, firewall rules, /etc/hosts
+	// and the proxies' privileged ports go through it, so the agent itself
+	// can run as service_user.
+	PrivilegedHelperSocket string `json:"privileged_helper_socket,omitempty"`
+	// BuildDockerHost (a DOCKER_HOST such as ssh://builder@10.0.0.5) or
+	// BuildxBuilder (a buildx builder name, e.g. a remote BuildKit) moves
+	// image builds off this host; the images are then loaded locally.
+	BuildDockerHost string `json:"build_docker_host,omitempty"`
+	BuildxBuilder   string `json:"buildx_builder,omitempty"`
+	// ImageServiceBudgetMB and ImageTotalBudgetMB cap the disk used by the
+	// layers of built images, per service and across services; 0 disables.
+	ImageServiceBudgetMB int `json:"image_service_budget_mb,omitempty"`
+	ImageTotalBudgetMB   int `json:"image_total_budget_mb,omitempty"`
+
+	// HealthProbeParallelism caps HTTP health probes in flight across all
+	// deploys (0 removes the cap). HealthCheckDirectIP probes containers on
+	// their bridge IP instead of the published localhost port.
+	HealthProbeParallelism int  `json:"health_probe_parallelism"`
+	HealthCheckDirectIP    bool `json:"health_check_direct_ip"`
+	// MaxUlimits, AllowedSysctls and MaxShmSizeMB cap what services may ask
+	// for with ulimits, sysctls and shm_size_mb. A ulimit capped at 0 is
+	// refused and -1 allows unlimited; a trailing "*" allows a sysctl prefix.
+	MaxUlimits     map[string]int64 `json:"max_ulimits"`
+	AllowedSysctls []string         `json:"allowed_sysctls"`
+	MaxShmSizeMB   int              `json:"max_shm_size_mb"`
+	// AllowedBindMounts are the host directories services may bind-mount
+	// with a volume's host_path, each with everything under it. Empty
+	// refuses bind mounts; named volumes are always allowed.
+	AllowedBindMounts []string `json:"allowed_bind_mounts"`
+	// Docker*TimeoutSeconds bound each docker invocation by kind: builds,
+	// pulls, starting and changing containers, reads such as inspect, and
+	// stops and removals (on top of the container's stop_timeout). 0 uses
+	// the default.
+	DockerBuildTimeoutSeconds   int `json:"docker_build_timeout_seconds"`
+	DockerPullTimeoutSeconds    int `json:"docker_pull_timeout_seconds"`
+	DockerRunTimeoutSeconds     int `json:"docker_run_timeout_seconds"`
+	DockerInspectTimeoutSeconds int `json:"docker_inspect_timeout_seconds"`
+	DockerStopTimeoutSeconds    int `json:"docker_stop_timeout_seconds"`
+	// ServiceNaming is "slug" (default), which reduces service names to
+	// DNS-safe slugs, or "strict", which rejects names that are not slugs.
+	ServiceNaming string `json:"service_naming,omitempty"`
+
+	// DesiredStatePush keeps a server-sent events stream open to the control
+	// plane and syncs as soon as a new state is published, polling only as a
+	// fallback.
+	DesiredStatePush bool `json:"desired_state_push"`
+
+	// RemoteCommands runs commands queued by the control plane (restarts,
+	// redeploys, log fetches, health checks, secret rotation) during sync.
+	RemoteCommands bool `json:"remote_commands"`
+
+	UploadDiagnostics bool `json:"upload_diagnostics"`
+	// UploadBuildLogs sends the Docker build output of each deploy to the
+	// control plane.
+	UploadBuildLogs bool `json:"upload_build_logs"`
+	// ReportDeploys sends a report of each deploy attempt (result, commit,
+	// image, duration and error class) to the control plane.
+	ReportDeploys bool `json:"report_deploys"`
+	// ImageDriftSelfHeal redeploys services whose running image no longer
+	// matches the one deployed.
+	ImageDriftSelfHeal bool `json:"image_drift_self_heal"`
+	// AutoRollbackFailures rolls a service back to its previous release once
+	// this many deploys of the same revision have failed in a row; 0
+	// disables automatic rollback.
+	AutoRollbackFailures int `json:"auto_rollback_failures"`
+
+	AgentLogFile        bool `json:"agent_log_file"`
+	AgentLogMaxSizeMB   int  `json:"agent_log_max_size_mb"`
+	AgentLogRotateHours int  `json:"agent_log_rotate_hours"`
+	AgentLogRetain      int  `json:"agent_log_retain"`
+
+	AdminListenAddr string `json:"admin_listen_addr,omitempty"`
+
+	// Authentication for the TCP admin listener: a bearer token or HMAC
+	// request signatures, and an optional source allowlist. Requests from
+	// AdminTunnelCIDRs, where cloudflared connects from, are checked against
+	// the client address it forwards.
+	AdminToken        string   `json:"admin_token,omitempty"`
+	AdminHMACSecret   string   `json:"admin_hmac_secret,omitempty"`
+	AdminAllowedCIDRs []string `json:"admin_allowed_cidrs,omitempty"`
+	AdminTunnelCIDRs  []string `json:"admin_tunnel_cidrs,omitempty"`
+	// AdminTLSCert and AdminTLSKey serve the TCP admin listener over HTTPS;
+	// clients presenting a certificate issued by AdminClientCA are
+	// authenticated by it.
+	AdminTLSCert  string `json:"admin_tls_cert,omitempty"`
+	AdminTLSKey   string `json:"admin_tls_key,omitempty"`
+	AdminClientCA string `json:"admin_client_ca,omitempty"`
+
+	LogExportS3Endpoint      string `json:"log_export_s3_endpoint,omitempty"`
+	LogExportS3Region        string `json:"log_export_s3_region,omitempty"`
+	LogExportS3Bucket        string `json:"log_export_s3_bucket,omitempty"`
+	LogExportS3Prefix        string `json:"log_export_s3_prefix,omitempty"`
+	LogExportIntervalMinutes int    `json:"log_export_interval_minutes"`
+
+	// LogShip* send new service logs to the control plane's ingest endpoint
+	// in batches, resuming from an on-disk cursor after restarts.
+	LogShipEnabled         bool `json:"log_ship_enabled"`
+	LogShipIntervalSeconds int  `json:"log_ship_interval_seconds"`
+	LogShipBatchSize       int  `json:"log_ship_batch_size"`
+
+	MetricsIntervalSeconds   int `json:"metrics_interval_seconds"`
+	MetricsRawRetentionHours int `json:"metrics_raw_retention_hours"`
+	MetricsRetentionDays     int `json:"metrics_retention_days"`
+
+	UptimeProbeIntervalSeconds int `json:"uptime_probe_interval_seconds"`
+
+	AlertIntervalSeconds    int `json:"alert_interval_seconds"`
+	AlertServiceDownMinutes int `json:"alert_service_down_minutes"`
+	AlertRestartsPerHour    int `json:"alert_restarts_per_hour"`
+	AlertDiskPercent        int `json:"alert_disk_percent"`
+	AlertCertExpiryDays     int `json:"alert_cert_expiry_days"`
+	// AlertCrashesPerHour and AlertCrashesPerDay are each service's crash
+	// budget; exceeding either fires the crash_budget alert.
+	AlertCrashesPerHour int `json:"alert_crashes_per_hour"`
+	AlertCrashesPerDay  int `json:"alert_crashes_per_day"`
+
 	StackNetworkPrefix string `json:"stack_network_prefix"`
 	StackNetworkSubnet string `json:"stack_network_subnet"`
 
+	// Chaos* inject simulated failures at these rates (0 to 1) in builds
+	// with the chaos tag; see internal/chaos. Release builds ignore them.
+	ChaosBuildFailureRate      float64 `json:"chaos_build_failure_rate,omitempty"`
+	ChaosHealthTimeoutRate     float64 `json:"chaos_health_timeout_rate,omitempty"`
+	ChaosControlPlaneErrorRate float64 `json:"chaos_control_plane_error_rate,omitempty"`
+	ChaosDockerSlowdownRate    float64 `json:"chaos_docker_slowdown_rate,omitempty"`
+	ChaosDockerDelayMs         int     `json:"chaos_docker_delay_ms,omitempty"`
+
 	CloudflareAccountID   string `json:"cloudflare_account_id,omitempty"`
 	CloudflareAPIToken    string `json:"cloudflare_api_token,omitempty"`
 	CloudflareTunnelID    string `json:"cloudflare_tunnel_id,omitempty"`
@@ -41,20 +240,80 @@ type Config struct {
 // DefaultConfig returns the default configuration.
 func DefaultConfig() *Config {
 	return &Config{
-		ControlPlane:       "http://localhost:8787",
-		PollInterval:       30,
-		DataDir:            "/var/lib/potato-cloud",
-		ExternalProxyPort:  8080,
-		SecurityMode:       "none",
-		VerboseLogging:     false,
-		PortRangeStart:     3000,
-		PortRangeEnd:       3100,
-		LogRetention:       10000,
-		StackNetworkPrefix: "stack-",
-		StackNetworkSubnet: "172.20.0.0/16",
+		ControlPlane:                "http://localhost:8787",
+		PollInterval:                30,
+		LifecycleFlushSeconds:       5,
+		HeartbeatQueueSize:          1000,
+		DataDir:                     platform.DefaultDataDir(),
+		ExternalProxyPort:           8080,
+		SecurityMode:                "none",
+		SSHPort:                     22,
+		FirewallConfirmMinutes:      5,
+		RemoteCommands:              true,
+		UploadBuildLogs:             true,
+		ReportDeploys:               true,
+		AutoRollbackFailures:        3,
+		HealthProbeParallelism:      8,
+		MaxUlimits:                  map[string]int64{"nofile": 1048576, "nproc": 65536},
+		AllowedSysctls:              []string{"net.core.somaxconn", "net.ipv4.ip_local_port_range", "net.ipv4.tcp_*"},
+		MaxShmSizeMB:                2048,
+		DockerBuildTimeoutSeconds:   600,
+		DockerPullTimeoutSeconds:    600,
+		DockerRunTimeoutSeconds:     120,
+		DockerInspectTimeoutSeconds: 30,
+		DockerStopTimeoutSeconds:    60,
+		APIRetryAttempts:            3,
+		APIRetryBaseDelayMs:         500,
+		APIRetryMaxDelayMs:          10000,
+		APIRetryJitter:              0.2,
+		RegistryHosts:               []string{"registry-1.docker.io", "auth.docker.io", "production.cloudflare.docker.com"},
+		NTPServers:                  []string{"pool.ntp.org"},
+		VerboseLogging:              false,
+		PortRangeStart:              3000,
+		PortRangeEnd:                3100,
+		LogRetention:                10000,
+		AgentLogMaxSizeMB:           50,
+		AgentLogRotateHours:         24,
+		AgentLogRetain:              7,
+		LogExportIntervalMinutes:    60,
+		LogShipIntervalSeconds:      10,
+		LogShipBatchSize:            500,
+		MetricsIntervalSeconds:      60,
+		MetricsRawRetentionHours:    24,
+		MetricsRetentionDays:        30,
+		UptimeProbeIntervalSeconds:  30,
+		AlertIntervalSeconds:        60,
+		AlertServiceDownMinutes:     5,
+		AlertRestartsPerHour:        5,
+		AlertDiskPercent:            90,
+		AlertCertExpiryDays:         14,
+		AlertCrashesPerHour:         10,
+		AlertCrashesPerDay:          30,
+		StackNetworkPrefix:          "stack-",
+		StackNetworkSubnet:          "172.20.0.0/16",
 	}
 }
 
+// ControlPlanes returns the primary control plane URL followed by fallbacks.
+func (c *Config) ControlPlanes() []string {
+	return append([]string{c.ControlPlane}, c.ControlPlaneFallbacks...)
+}
+
+// Stacks returns the stacks the agent serves: stack_id, the primary stack,
+// followed by stack_ids, without blanks or duplicates.
+func (c *Config) Stacks() []string {
+	var stacks []string
+	seen := make(map[string]bool)
+	for _, stackID := range append([]string{c.StackID}, c.StackIDs...) {
+		stackID = strings.TrimSpace(stackID)
+		if stackID != "" && !seen[stackID] {
+			seen[stackID] = true
+			stacks = append(stacks, stackID)
+		}
+	}
+	return stacks
+}
+
 // Load reads configuration from file.
 func Load(path string) (*Config, error) {
 	data, err := os.ReadFile(path)
@@ -88,9 +347,95 @@ func (c *Config) Save(path string) error {
 	return nil
 }
 
+// redactedValue replaces secret values in redacted copies of the config.
+const redactedValue = "[REDACTED]"
+
+// Redacted returns a copy of the configuration with credentials masked,
+// suitable for including in support bundles.
+func (c *Config) Redacted() *Config {
+	out := *c
+	for _, field := range []*string{
+		&out.AccessClientSecret,
+		&out.APIKey,
+		&out.CloudflareAPIToken,
+		&out.CloudflareTunnelToken,
+		&out.AdminToken,
+		&out.AdminHMACSecret,
+	} {
+		if *field != "" {
+			*field = redactedValue
+		}
+	}
+	out.HTTPProxy = redactProxyURL(out.HTTPProxy)
+	out.HTTPSProxy = redactProxyURL(out.HTTPSProxy)
+	return &out
+}
+
+// redactProxyURL masks the password in a proxy URL.
+func redactProxyURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.User == nil {
+		return raw
+	}
+	return u.Redacted()
+}
+
+// ApplyProxyEnv exports the configured proxy settings as HTTP_PROXY,
+// HTTPS_PROXY and NO_PROXY (and their lowercase forms), overriding the
+// environment. It must run before the first outbound request, since Go reads
+// the proxy environment once.
+func (c *Config) ApplyProxyEnv() error {
+	for name, value := range map[string]string{
+		"HTTP_PROXY":  c.HTTPProxy,
+		"HTTPS_PROXY": c.HTTPSProxy,
+		"NO_PROXY":    c.NoProxy,
+	} {
+		if value == "" {
+			continue
+		}
+		if err := os.Setenv(name, value); err != nil {
+			return fmt.Errorf("failed to set %s: %w", name, err)
+		}
+		if err := os.Setenv(strings.ToLower(name), value); err != nil {
+			return fmt.Errorf("failed to set %s: %w", strings.ToLower(name), err)
+		}
+	}
+	return nil
+}
+
+// CABundle reads the CACerts files into one PEM bundle, or returns nil when
+// none are configured.
+func (c *Config) CABundle() ([]byte, error) {
+	var bundle []byte
+	for _, path := range c.CACerts {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA certificate: %w", err)
+		}
+		if !x509.NewCertPool().AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in %s", path)
+		}
+		bundle = append(bundle, data...)
+		bundle = append(bundle, '\n')
+	}
+	return bundle, nil
+}
+
+// Umask parses FileUmask, reporting false when it is not set.
+func (c *Config) Umask() (int, bool, error) {
+	if c.FileUmask == "" {
+		return 0, false, nil
+	}
+	mask, err := strconv.ParseUint(c.FileUmask, 8, 32)
+	if err != nil || mask > 0777 {
+		return 0, false, fmt.Errorf("invalid file_umask %q; expected an octal mask such as 027", c.FileUmask)
+	}
+	return int(mask), true, nil
+}
+
 // ConfigPath returns the default configuration file path.
 func ConfigPath() string {
-	return "/etc/potato-cloud/config.json"
+	return platform.DefaultConfigPath()
 }
 
 // StateDBPath returns the path to the SQLite state database.
@@ -100,6 +445,9 @@ func (c *Config) StateDBPath() string {
 
 // ReposPath returns the path where repositories are cloned.
 func (c *Config) ReposPath() string {
+	if c.ReposDir != "" {
+		return c.ReposDir
+	}
 	return filepath.Join(c.DataDir, "repos")
 }
 
@@ -116,6 +464,49 @@ func (c *Config) SecretsPath() string {
 	return filepath.Join(c.DataDir, "secrets")
 }
 
+// DiagnosticsPath returns the path where deploy failure diagnostics are stored.
+func (c *Config) DiagnosticsPath() string {
+	return filepath.Join(c.DataDir, "diagnostics")
+}
+
+// ConfigFilesPath returns the directory holding services' rendered config files.
+func (c *Config) ConfigFilesPath() string {
+	return filepath.Join(c.DataDir, "configs")
+}
+
+// PluginsPath returns the directory scanned for deploy hook plugins.
+func (c *Config) PluginsPath() string {
+	return filepath.Join(c.DataDir, "plugins")
+}
+
+// AgentLogPath returns the path of the agent's own log file.
+func (c *Config) AgentLogPath() string {
+	return filepath.Join(c.DataDir, "logs", "agent.log")
+}
+
+// AdminSocketPath returns the path of the admin API Unix socket.
+func (c *Config) AdminSocketPath() string {
+	return filepath.Join(c.DataDir, "admin.sock")
+}
+
+// LogExportProgressPath returns the file tracking what scheduled log export
+// has already uploaded.
+func (c *Config) LogExportProgressPath() string {
+	return filepath.Join(c.DataDir, "log_export.json")
+}
+
+// LogShipCursorPath returns the file tracking the last service log line
+// shipped to the control plane.
+func (c *Config) LogShipCursorPath() string {
+	return filepath.Join(c.DataDir, "log_ship.json")
+}
+
+// StatusFilePath returns the file the latest heartbeat is written to for
+// external tooling.
+func (c *Config) StatusFilePath() string {
+	return filepath.Join(c.DataDir, "status.json")
+}
+
 // TunnelConfigPath returns the path to the Cloudflare tunnel config.
 func (c *Config) TunnelConfigPath() string {
 	return filepath.Join(c.DataDir, "tunnel.json")
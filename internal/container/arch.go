@@ -0,0 +1,116 @@
+package container
+
+import (
+	"encoding/json"
+	"fmt"
+	"runtime"
+	"strings"
+	"time"
+)
+
+const manifestInspectTimeout = 30 * time.Second
+
+// archAliases maps architecture names reported by uname, Docker and users to
+// Go/OCI architecture names.
+var archAliases = map[string]string{
+	"x86_64":  "amd64",
+	"x64":     "amd64",
+	"amd64":   "amd64",
+	"aarch64": "arm64",
+	"arm64":   "arm64",
+	"arm64v8": "arm64",
+	"armv7":   "arm",
+	"armv7l":  "arm",
+	"armhf":   "arm",
+	"arm":     "arm",
+}
+
+// NormalizeArch converts an architecture name to its OCI form (amd64, arm64,
+// arm). Unknown names are returned lower-cased.
+func NormalizeArch(arch string) string {
+	arch = strings.ToLower(strings.TrimSpace(arch))
+	if normalized, ok := archAliases[arch]; ok {
+		return normalized
+	}
+	return arch
+}
+
+// HostArch returns the architecture the agent is running on.
+func HostArch() string {
+	return NormalizeArch(runtime.GOARCH)
+}
+
+// PlatformForArch returns the docker --platform value for an architecture.
+func PlatformForArch(arch string) string {
+	arch = NormalizeArch(arch)
+	if arch == "arm" {
+		return "linux/arm/v7"
+	}
+	return "linux/" + arch
+}
+
+// inspectManifest returns the raw `docker manifest inspect` output for an
+// image. It is a variable so tests can stub the registry lookup.
+var inspectManifest = func(image string) (string, error) {
+	return runDockerWithTimeout(manifestInspectTimeout, "manifest", "inspect", image)
+}
+
+// ImageArchitectures returns the linux architectures published for an image.
+// Single-platform images do not list their architecture in the manifest, in
+// which case an empty slice is returned.
+func ImageArchitectures(image string) ([]string, error) {
+	output, err := inspectManifest(image)
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect manifest for %s: %w", image, err)
+	}
+	return parseManifestArchitectures([]byte(output))
+}
+
+func parseManifestArchitectures(data []byte) ([]string, error) {
+	var manifest struct {
+		Manifests []struct {
+			Platform struct {
+				Architecture string `json:"architecture"`
+				OS           string `json:"os"`
+			} `json:"platform"`
+		} `json:"manifests"`
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+
+	seen := make(map[string]bool)
+	archs := []string{}
+	for _, entry := range manifest.Manifests {
+		if entry.Platform.OS != "" && entry.Platform.OS != "linux" {
+			continue
+		}
+		arch := NormalizeArch(entry.Platform.Architecture)
+		if arch == "" || arch == "unknown" || seen[arch] {
+			continue
+		}
+		seen[arch] = true
+		archs = append(archs, arch)
+	}
+	return archs, nil
+}
+
+// VerifyImageArch returns an error when an image's manifest list is known not
+// to include the given architecture. Images whose platforms cannot be
+// determined are assumed to be compatible.
+func VerifyImageArch(image, arch string) error {
+	archs, err := ImageArchitectures(image)
+	if err != nil {
+		return err
+	}
+	if len(archs) == 0 {
+		return nil
+	}
+	arch = NormalizeArch(arch)
+	for _, candidate := range archs {
+		if candidate == arch {
+			return nil
+		}
+	}
+	return fmt.Errorf("image %s does not publish %s (available: %s)", image, arch, strings.Join(archs, ", "))
+}
@@ -0,0 +1,63 @@
+package container
+
+import (
+	"testing"
+)
+
+func TestNormalizeArch(t *testing.T) {
+	t.Logf("Testing architecture normalization")
+
+	cases := map[string]string{
+		"x86_64":  "amd64",
+		"AMD64":   "amd64",
+		"aarch64": "arm64",
+		"arm64v8": "arm64",
+		"armv7l":  "arm",
+		"riscv64": "riscv64",
+		"":        "",
+	}
+	for input, expected := range cases {
+		if got := NormalizeArch(input); got != expected {
+			t.Errorf("NormalizeArch(%q) = %q, expected %q", input, got, expected)
+		}
+	}
+	if PlatformForArch("aarch64") != "linux/arm64" {
+		t.Errorf("Expected linux/arm64 platform, got %s", PlatformForArch("aarch64"))
+	}
+
+	t.Logf("✓ Architecture aliases normalized")
+}
+
+func TestVerifyImageArch(t *testing.T) {
+	t.Logf("Testing base image architecture verification")
+
+	original := inspectManifest
+	defer func() { inspectManifest = original }()
+
+	inspectManifest = func(image string) (string, error) {
+		switch image {
+		case "multi:latest":
+			return `{"manifests":[
+				{"platform":{"architecture":"amd64","os":"linux"}},
+				{"platform":{"architecture":"arm64","os":"linux","variant":"v8"}},
+				{"platform":{"architecture":"unknown","os":"unknown"}}
+			]}`, nil
+		case "amd64only:latest":
+			return `{"manifests":[{"platform":{"architecture":"amd64","os":"linux"}}]}`, nil
+		default:
+			return `{"schemaVersion":2,"config":{}}`, nil
+		}
+	}
+
+	if err := VerifyImageArch("multi:latest", "aarch64"); err != nil {
+		t.Errorf("Expected multi-arch image to support arm64: %v", err)
+	}
+	if err := VerifyImageArch("amd64only:latest", "arm64"); err == nil {
+		t.Error("Expected amd64-only image to fail arm64 verification")
+	}
+	if err := VerifyImageArch("single:latest", "arm64"); err != nil {
+		t.Errorf("Expected single-platform manifest to be assumed compatible: %v", err)
+	}
+
+	t.Logf("✓ Base image architectures verified")
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"text/template"
@@ -71,6 +72,16 @@ var LanguageConfigs = map[string]LanguageConfig{
 	},
 }
 
+// Languages returns the names of the supported languages, sorted.
+func Languages() []string {
+	languages := make([]string, 0, len(LanguageConfigs))
+	for language := range LanguageConfigs {
+		languages = append(languages, language)
+	}
+	sort.Strings(languages)
+	return languages
+}
+
 // TemplateData holds the data for Dockerfile template
 type TemplateData struct {
 	BaseImage    string
@@ -113,11 +124,7 @@ func (g *Generator) GenerateDockerfile(language, baseImage string, port int, env
 	if !ok {
 		config = LanguageConfigs["generic"]
 	}
-
-	// Use user-provided base image or default
-	if baseImage == "" {
-		baseImage = config.DefaultBaseImage
-	}
+	baseImage = g.BaseImageFor(language, baseImage, repoPath)
 
 	data := TemplateData{
 		BaseImage:    baseImage,
@@ -140,6 +147,68 @@ func (g *Generator) GenerateDockerfile(language, baseImage string, port int, env
 	return buf.String(), nil
 }
 
+// BaseImageFor returns the base image a generated Dockerfile will use: the
+// user-provided image if set, otherwise the language default.
+func (g *Generator) BaseImageFor(language, baseImage, repoPath string) string {
+	if baseImage != "" {
+		return baseImage
+	}
+	if language == "" || language == "auto" {
+		language = g.DetectLanguage(repoPath)
+	}
+	config, ok := LanguageConfigs[language]
+	if !ok {
+		config = LanguageConfigs["generic"]
+	}
+	return config.DefaultBaseImage
+}
+
+// healthCheckProbe tries the HTTP clients available across the supported base
+// images: busybox wget (alpine), curl, then python3 (debian slim images).
+const healthCheckProbe = `wget -q -O /dev/null %[1]s || curl -fsS -o /dev/null %[1]s || python3 -c "import sys,urllib.request; urllib.request.urlopen(sys.argv[1], timeout=5)" %[1]s || exit 1`
+
+// HealthCheckCommand returns a shell command that exits non-zero unless the
+// service answers the health check path on the given container port. The
+// URL is single-quoted, and control characters in path percent-encoded so
+// it stays on the HEALTHCHECK line.
+func HealthCheckCommand(port int, path string) string {
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	var escaped strings.Builder
+	for i := 0; i < len(path); i++ {
+		if c := path[i]; c < 0x20 || c == 0x7f {
+			fmt.Fprintf(&escaped, "%%%02X", c)
+		} else {
+			escaped.WriteByte(c)
+		}
+	}
+	target := fmt.Sprintf("http://127.0.0.1:%d%s", port, escaped.String())
+	return fmt.Sprintf(healthCheckProbe, "'"+strings.ReplaceAll(target, "'", `'\''`)+"'")
+}
+
+// AddHealthCheck inserts a HEALTHCHECK instruction before the final CMD of a
+// generated Dockerfile so Docker's health status matches the agent's probe.
+// The Dockerfile is returned unchanged if path is empty.
+func (g *Generator) AddHealthCheck(dockerfile string, port int, path string, intervalSeconds int) string {
+	if strings.TrimSpace(path) == "" {
+		return dockerfile
+	}
+	if intervalSeconds <= 0 {
+		intervalSeconds = 30
+	}
+	instruction := fmt.Sprintf("HEALTHCHECK --interval=%ds --timeout=5s --start-period=10s --retries=3 CMD %s", intervalSeconds, HealthCheckCommand(port, strings.TrimSpace(path)))
+
+	lines := strings.Split(dockerfile, "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		if strings.HasPrefix(strings.TrimSpace(lines[i]), "CMD ") {
+			lines = append(lines[:i], append([]string{instruction}, lines[i:]...)...)
+			return strings.Join(lines, "\n")
+		}
+	}
+	return strings.TrimRight(dockerfile, "\n") + "\n" + instruction + "\n"
+}
+
 // WriteDockerfile writes the generated Dockerfile to disk
 func (g *Generator) WriteDockerfile(content, repoPath string) (string, error) {
 	dockerfilePath := filepath.Join(repoPath, "Dockerfile.auto")
@@ -2,7 +2,10 @@ package container
 
 import (
 	"os"
+	"os/exec"
 	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
 )
 
@@ -243,6 +246,46 @@ func TestGenerateDockerfile_BaseImageOverride(t *testing.T) {
 	t.Logf("✓ Correctly used custom base image")
 }
 
+func TestAddHealthCheck(t *testing.T) {
+	t.Logf("Testing HEALTHCHECK injection")
+
+	gen := NewGenerator(3000, 3100)
+	tempDir := t.TempDir()
+
+	content, err := gen.GenerateDockerfile("python", "", 8000, nil, "true", "python app.py", tempDir)
+	if err != nil {
+		t.Fatalf("Failed to generate Dockerfile: %v", err)
+	}
+
+	withCheck := gen.AddHealthCheck(content, 8000, "/health", 15)
+	if !contains(withCheck, "HEALTHCHECK --interval=15s") {
+		t.Errorf("Expected HEALTHCHECK with 15s interval, got:\n%s", withCheck)
+	}
+	if !contains(withCheck, "http://127.0.0.1:8000/health") {
+		t.Errorf("Expected health check URL, got:\n%s", withCheck)
+	}
+	if strings.Index(withCheck, "HEALTHCHECK") > strings.Index(withCheck, "CMD [") {
+		t.Error("Expected HEALTHCHECK before the final CMD")
+	}
+
+	if gen.AddHealthCheck(content, 8000, "", 15) != content {
+		t.Error("Expected Dockerfile unchanged without health check path")
+	}
+
+	command := HealthCheckCommand(8000, "/health?a=1&b=$(id)'\nRUN x")
+	if !contains(command, `'http://127.0.0.1:8000/health?a=1&b=$(id)'\''%0ARUN x'`) || strings.Contains(command, "\n") {
+		t.Errorf("Expected the URL shell-quoted on one line, got %s", command)
+	}
+	if runtime.GOOS != "windows" {
+		out, err := exec.Command("sh", "-c", "printf '%s' "+strings.SplitN(strings.TrimPrefix(command, "wget -q -O /dev/null "), " ||", 2)[0]).Output()
+		if err != nil || string(out) != "http://127.0.0.1:8000/health?a=1&b=$(id)'%0ARUN x" {
+			t.Errorf("Expected the shell to see the URL as one word, got %q (err=%v)", out, err)
+		}
+	}
+
+	t.Logf("✓ HEALTHCHECK injected before CMD")
+}
+
 func TestCheckDockerfileExists(t *testing.T) {
 	t.Logf("Testing Dockerfile detection")
 
@@ -38,7 +38,7 @@ func (m *StackNetworkManager) CreateStackNetwork(stackID string) error {
 	gateway := fmt.Sprintf("172.%d.0.1", hash)
 
 	_, err := runDockerWithTimeout(
-		30*time.Second,
+		DockerTimeout(DockerRun),
 		"network", "create",
 		"--driver", "bridge",
 		"--subnet", subnet,
@@ -64,7 +64,7 @@ func (m *StackNetworkManager) DeleteStackNetwork(stackID string) error {
 		return fmt.Errorf("failed to disconnect containers before deleting network: %w", err)
 	}
 
-	_, err := runDockerWithTimeout(30*time.Second, "network", "rm", networkName)
+	_, err := runDockerWithTimeout(DockerTimeout(DockerRun), "network", "rm", networkName)
 	if err != nil {
 		return fmt.Errorf("failed to delete network %s: %w", networkName, err)
 	}
@@ -80,7 +80,7 @@ func (m *StackNetworkManager) ConnectContainerToStackNetwork(stackID, containerI
 		return fmt.Errorf("network %s not found", networkName)
 	}
 
-	_, err := runDockerWithTimeout(30*time.Second, "network", "connect", networkName, containerID)
+	_, err := runDockerWithTimeout(DockerTimeout(DockerRun), "network", "connect", networkName, containerID)
 	if err != nil {
 		return fmt.Errorf("failed to connect container %s to network %s: %w", containerID, networkName, err)
 	}
@@ -96,7 +96,7 @@ func (m *StackNetworkManager) DisconnectContainerFromStackNetwork(stackID, conta
 		return nil
 	}
 
-	_, err := runDockerWithTimeout(30*time.Second, "network", "disconnect", networkName, containerID)
+	_, err := runDockerWithTimeout(DockerTimeout(DockerRun), "network", "disconnect", networkName, containerID)
 	if err != nil {
 		// Treat "not connected" and "not found" as idempotent.
 		errMsg := err.Error()
@@ -118,7 +118,7 @@ func (m *StackNetworkManager) DisconnectAllFromStackNetwork(stackID string) erro
 	}
 
 	output, err := runDockerWithTimeout(
-		30*time.Second,
+		DockerTimeout(DockerInspect),
 		"network", "inspect",
 		"--format", "{{range $id, $_ := .Containers}}{{println $id}}{{end}}",
 		networkName,
@@ -143,7 +143,7 @@ func (m *StackNetworkManager) DisconnectAllFromStackNetwork(stackID string) erro
 // ListStackNetworks returns all networks created for stacks.
 func (m *StackNetworkManager) ListStackNetworks() ([]NetworkResource, error) {
 	output, err := runDockerWithTimeout(
-		30*time.Second,
+		DockerTimeout(DockerInspect),
 		"network", "ls",
 		"--format", "{{.ID}}|{{.Name}}|{{.Driver}}|{{.Scope}}",
 	)
@@ -179,7 +179,7 @@ func (m *StackNetworkManager) ListStackNetworks() ([]NetworkResource, error) {
 }
 
 func (m *StackNetworkManager) networkExists(name string) bool {
-	_, err := runDockerWithTimeout(10*time.Second, "network", "inspect", name)
+	_, err := runDockerWithTimeout(DockerTimeout(DockerInspect), "network", "inspect", name)
 	return err == nil
 }
 
@@ -3,6 +3,7 @@ package container
 import (
 	"fmt"
 	"net"
+	"strconv"
 	"strings"
 	"sync"
 )
@@ -10,14 +11,75 @@ import (
 // PortPair represents a pair of ports for blue/green deployment
 type PortPair struct {
 	BluePort  int // Production/traffic port
-	GreenPort int // Deployment/staging port
+	GreenPort int // Deployment/staging port; 0 for single-port services
+}
+
+// Single reports whether the service holds only one port between deploys.
+func (p PortPair) Single() bool {
+	return p.GreenPort == 0
+}
+
+// Pairing strategies for allocating blue/green ports.
+const (
+	// PairingConsecutive allocates adjacent ports stepping by two from the
+	// start of the range, matching allocations made by older agents.
+	PairingConsecutive = "consecutive"
+	// PairingAny allocates the first two free ports, adjacent or not, so no
+	// port in the range is wasted.
+	PairingAny = "any"
+)
+
+// PortRange is an inclusive range of ports.
+type PortRange struct {
+	Start int
+	End   int
+}
+
+// Contains reports whether port is in the range.
+func (r PortRange) Contains(port int) bool {
+	return port >= r.Start && port <= r.End
+}
+
+// ParsePortRange parses "3306" or "5000-5010".
+func ParsePortRange(spec string) (PortRange, error) {
+	startText, endText, isRange := strings.Cut(strings.TrimSpace(spec), "-")
+	start, err := strconv.Atoi(strings.TrimSpace(startText))
+	if err != nil {
+		return PortRange{}, fmt.Errorf("invalid port range %q", spec)
+	}
+	end := start
+	if isRange {
+		if end, err = strconv.Atoi(strings.TrimSpace(endText)); err != nil {
+			return PortRange{}, fmt.Errorf("invalid port range %q", spec)
+		}
+	}
+	if start < 1 || end > 65535 || end < start {
+		return PortRange{}, fmt.Errorf("invalid port range %q", spec)
+	}
+	return PortRange{Start: start, End: end}, nil
+}
+
+// portFree reports whether a port can be bound on the host. It is a variable
+// so tests can simulate host services.
+var portFree = func(port int) bool {
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		// Some restricted environments disallow bind/listen entirely.
+		// In that case, rely on in-memory allocation tracking.
+		return strings.Contains(strings.ToLower(err.Error()), "operation not permitted")
+	}
+	listener.Close()
+	return true
 }
 
 // PortManager handles port allocation for services
 type PortManager struct {
 	start     int
 	end       int
-	allocated map[string]PortPair // service ID -> port pair
+	allocated map[string]PortPair  // service ID -> port pair
+	excluded  []PortRange          // never handed out, e.g. host databases
+	named     map[string]PortRange // sub-ranges services can ask for by name
+	pairing   string
 	mu        sync.RWMutex
 }
 
@@ -27,12 +89,45 @@ func NewPortManager(start, end int) *PortManager {
 		start:     start,
 		end:       end,
 		allocated: make(map[string]PortPair),
+		pairing:   PairingConsecutive,
+	}
+}
+
+// SetPairing sets the pairing strategy for new allocations.
+func (pm *PortManager) SetPairing(strategy string) error {
+	if strategy != PairingConsecutive && strategy != PairingAny {
+		return fmt.Errorf("unknown port pairing strategy %q", strategy)
 	}
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	pm.pairing = strategy
+	return nil
+}
+
+// SetExcluded sets ports that are never allocated.
+func (pm *PortManager) SetExcluded(ranges []PortRange) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	pm.excluded = ranges
+}
+
+// SetNamedRanges sets named sub-ranges, such as "web" or "workers", that
+// services can allocate from.
+func (pm *PortManager) SetNamedRanges(ranges map[string]PortRange) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	pm.named = ranges
 }
 
 // Allocate assigns a pair of ports to a service (blue and green)
 // Blue port is the base port, Green port is base + 1
 func (pm *PortManager) Allocate(serviceID string) (PortPair, error) {
+	return pm.AllocateIn(serviceID, "")
+}
+
+// AllocateIn assigns a port pair from the named range, or from the whole
+// range when rangeName is empty.
+func (pm *PortManager) AllocateIn(serviceID, rangeName string) (PortPair, error) {
 	pm.mu.Lock()
 	defer pm.mu.Unlock()
 
@@ -41,18 +136,164 @@ func (pm *PortManager) Allocate(serviceID string) (PortPair, error) {
 		return pair, nil
 	}
 
-	// Find an available port pair
-	// We need TWO consecutive available ports
-	for bluePort := pm.start; bluePort <= pm.end-1; bluePort += 2 {
+	r, err := pm.rangeFor(rangeName)
+	if err != nil {
+		return PortPair{}, err
+	}
+	pair, err := pm.findPair(r)
+	if err != nil {
+		return PortPair{}, err
+	}
+	pm.allocated[serviceID] = pair
+	return pair, nil
+}
+
+// AllocateSingle assigns one port to a service that borrows a second port
+// only while deploying (see AddSpare).
+func (pm *PortManager) AllocateSingle(serviceID, rangeName string) (PortPair, error) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	if pair, exists := pm.allocated[serviceID]; exists {
+		return pair, nil
+	}
+	r, err := pm.rangeFor(rangeName)
+	if err != nil {
+		return PortPair{}, err
+	}
+	port, err := pm.findPort(r, 0)
+	if err != nil {
+		return PortPair{}, err
+	}
+	pair := PortPair{BluePort: port}
+	pm.allocated[serviceID] = pair
+	return pair, nil
+}
+
+// AddSpare gives a single-port service a second port for the duration of a
+// deploy. Reserve the final single port afterwards to release the other.
+func (pm *PortManager) AddSpare(serviceID, rangeName string) (PortPair, error) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	pair, exists := pm.allocated[serviceID]
+	if !exists {
+		return PortPair{}, fmt.Errorf("no ports allocated for service %s", serviceID)
+	}
+	if !pair.Single() {
+		return pair, nil
+	}
+	r, err := pm.rangeFor(rangeName)
+	if err != nil {
+		return PortPair{}, err
+	}
+	spare, err := pm.findPort(r, pair.BluePort)
+	if err != nil {
+		return PortPair{}, err
+	}
+	pair.GreenPort = spare
+	pm.allocated[serviceID] = pair
+	return pair, nil
+}
+
+// Revalidate checks a service's allocated ports before a deploy, since host
+// services may have claimed them after the agent started. Ports other than
+// activePort (the one the running container holds; 0 if none) that are bound,
+// excluded or outside the service's range are replaced. It reports whether
+// the pair changed.
+func (pm *PortManager) Revalidate(serviceID, rangeName string, activePort int) (PortPair, bool, error) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	pair, exists := pm.allocated[serviceID]
+	if !exists {
+		return PortPair{}, false, fmt.Errorf("no ports allocated for service %s", serviceID)
+	}
+	r, err := pm.rangeFor(rangeName)
+	if err != nil {
+		return pair, false, err
+	}
+	usable := func(port int) bool {
+		return port == activePort || (r.Contains(port) && !pm.isExcluded(port) && portFree(port))
+	}
+	if usable(pair.BluePort) && (pair.Single() || usable(pair.GreenPort)) {
+		return pair, false, nil
+	}
+
+	// Free the old pair so its unaffected port can be reused.
+	delete(pm.allocated, serviceID)
+	var replacement PortPair
+	switch {
+	case pair.Single():
+		replacement.BluePort, err = pm.findPort(r, 0)
+	case activePort == pair.BluePort:
+		replacement.BluePort = activePort
+		replacement.GreenPort, err = pm.findPort(r, activePort)
+	case activePort == pair.GreenPort:
+		replacement.GreenPort = activePort
+		replacement.BluePort, err = pm.findPort(r, activePort)
+	default:
+		replacement, err = pm.findPair(r)
+	}
+	if err != nil {
+		pm.allocated[serviceID] = pair
+		return pair, false, err
+	}
+	pm.allocated[serviceID] = replacement
+	return replacement, true, nil
+}
+
+func (pm *PortManager) rangeFor(name string) (PortRange, error) {
+	if name == "" {
+		return PortRange{Start: pm.start, End: pm.end}, nil
+	}
+	r, ok := pm.named[name]
+	if !ok {
+		return PortRange{}, fmt.Errorf("unknown port range %q", name)
+	}
+	return r, nil
+}
+
+// findPair returns two available ports in r according to the pairing
+// strategy.
+func (pm *PortManager) findPair(r PortRange) (PortPair, error) {
+	if pm.pairing == PairingAny {
+		blue, err := pm.findPort(r, 0)
+		if err != nil {
+			return PortPair{}, fmt.Errorf("no available port pairs in range %d-%d", r.Start, r.End)
+		}
+		green, err := pm.findPort(r, blue)
+		if err != nil {
+			return PortPair{}, fmt.Errorf("no available port pairs in range %d-%d", r.Start, r.End)
+		}
+		return PortPair{BluePort: blue, GreenPort: green}, nil
+	}
+	for bluePort := r.Start; bluePort <= r.End-1; bluePort += 2 {
 		greenPort := bluePort + 1
 		if pm.isPortAvailable(bluePort) && pm.isPortAvailable(greenPort) {
-			pair := PortPair{BluePort: bluePort, GreenPort: greenPort}
-			pm.allocated[serviceID] = pair
-			return pair, nil
+			return PortPair{BluePort: bluePort, GreenPort: greenPort}, nil
 		}
 	}
+	return PortPair{}, fmt.Errorf("no available port pairs in range %d-%d", r.Start, r.End)
+}
 
-	return PortPair{}, fmt.Errorf("no available port pairs in range %d-%d", pm.start, pm.end)
+// findPort returns an available port in r other than skip.
+func (pm *PortManager) findPort(r PortRange, skip int) (int, error) {
+	for port := r.Start; port <= r.End; port++ {
+		if port != skip && pm.isPortAvailable(port) {
+			return port, nil
+		}
+	}
+	return 0, fmt.Errorf("no available ports in range %d-%d", r.Start, r.End)
+}
+
+func (pm *PortManager) isExcluded(port int) bool {
+	for _, r := range pm.excluded {
+		if r.Contains(port) {
+			return true
+		}
+	}
+	return false
 }
 
 // Get retrieves the allocated port pair for a service
@@ -71,6 +312,7 @@ func (pm *PortManager) Release(serviceID string) {
 }
 
 // Reserve sets a specific port pair for a service, used for restart recovery.
+// Any pair is accepted, including non-adjacent and single-port pairs.
 func (pm *PortManager) Reserve(serviceID string, pair PortPair) error {
 	pm.mu.Lock()
 	defer pm.mu.Unlock()
@@ -79,9 +321,10 @@ func (pm *PortManager) Reserve(serviceID string, pair PortPair) error {
 		if existingServiceID == serviceID {
 			continue
 		}
-		if existingPair.BluePort == pair.BluePort || existingPair.GreenPort == pair.GreenPort ||
-			existingPair.BluePort == pair.GreenPort || existingPair.GreenPort == pair.BluePort {
-			return fmt.Errorf("port pair conflict with service %s", existingServiceID)
+		for _, port := range []int{pair.BluePort, pair.GreenPort} {
+			if port != 0 && (existingPair.BluePort == port || existingPair.GreenPort == port) {
+				return fmt.Errorf("port pair conflict with service %s", existingServiceID)
+			}
 		}
 	}
 
@@ -89,7 +332,8 @@ func (pm *PortManager) Reserve(serviceID string, pair PortPair) error {
 	return nil
 }
 
-// isPortAvailable checks if a port is not in use and not allocated
+// isPortAvailable checks if a port is not in use, not excluded and not
+// allocated
 func (pm *PortManager) isPortAvailable(port int) bool {
 	// Check if already allocated to another service
 	for _, pair := range pm.allocated {
@@ -97,20 +341,12 @@ func (pm *PortManager) isPortAvailable(port int) bool {
 			return false
 		}
 	}
-
-	// Check if port is actually available on the system
-	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
-	if err != nil {
-		// Some restricted environments disallow bind/listen entirely.
-		// In that case, rely on in-memory allocation tracking.
-		if strings.Contains(strings.ToLower(err.Error()), "operation not permitted") {
-			return true
-		}
+	if pm.isExcluded(port) {
 		return false
 	}
-	listener.Close()
 
-	return true
+	// Check if port is actually available on the system
+	return portFree(port)
 }
 
 // FindAlternativePortPair searches for any available port pair beyond the range
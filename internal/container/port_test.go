@@ -295,3 +295,123 @@ func TestPortManager_Reserve_Conflict(t *testing.T) {
 
 	t.Logf("✓ Conflict correctly detected")
 }
+
+func TestPortManager_ExclusionsAndNamedRanges(t *testing.T) {
+	t.Logf("Testing excluded ports and named ranges")
+
+	pm := NewPortManager(3000, 3099)
+	pm.SetExcluded([]PortRange{{Start: 3000, End: 3000}, {Start: 3040, End: 3045}})
+	pm.SetNamedRanges(map[string]PortRange{"workers": {Start: 3040, End: 3059}})
+
+	pair, err := pm.Allocate("web")
+	if err != nil {
+		t.Fatalf("Failed to allocate: %v", err)
+	}
+	if pair.BluePort != 3002 || pair.GreenPort != 3003 {
+		t.Errorf("Expected excluded 3000 to be skipped, got %+v", pair)
+	}
+
+	pair, err = pm.AllocateIn("worker", "workers")
+	if err != nil {
+		t.Fatalf("Failed to allocate from named range: %v", err)
+	}
+	if pair.BluePort != 3046 || pair.GreenPort != 3047 {
+		t.Errorf("Expected first free pair in workers range after exclusions, got %+v", pair)
+	}
+
+	if _, err := pm.AllocateIn("other", "addons"); err == nil {
+		t.Errorf("Expected unknown range to fail")
+	}
+	if _, err := ParsePortRange("5000-4000"); err == nil {
+		t.Errorf("Expected reversed range to fail")
+	}
+	t.Logf("✓ Exclusions honoured and named ranges used")
+}
+
+func TestPortManager_Revalidate(t *testing.T) {
+	t.Logf("Testing port revalidation before deploy")
+
+	taken := map[int]bool{}
+	original := portFree
+	portFree = func(port int) bool { return !taken[port] }
+	defer func() { portFree = original }()
+
+	pm := NewPortManager(3000, 3009)
+	pair, _ := pm.Allocate("svc")
+
+	if _, moved, err := pm.Revalidate("svc", "", 0); err != nil || moved {
+		t.Fatalf("Expected unchanged pair, moved=%v err=%v", moved, err)
+	}
+
+	// A host service grabbed the green port while blue is serving traffic.
+	taken[pair.GreenPort] = true
+	moved, changed, err := pm.Revalidate("svc", "", pair.BluePort)
+	if err != nil || !changed {
+		t.Fatalf("Expected green port to move, changed=%v err=%v", changed, err)
+	}
+	if moved.BluePort != pair.BluePort || moved.GreenPort == pair.GreenPort {
+		t.Errorf("Expected active blue kept and new green chosen, got %+v", moved)
+	}
+
+	// With nothing running, a conflict moves the whole pair.
+	taken[moved.BluePort] = true
+	fresh, changed, err := pm.Revalidate("svc", "", 0)
+	if err != nil || !changed || taken[fresh.BluePort] || taken[fresh.GreenPort] {
+		t.Errorf("Expected a new free pair, got %+v changed=%v err=%v", fresh, changed, err)
+	}
+	if current, _ := pm.Get("svc"); current != fresh {
+		t.Errorf("Expected allocation to be updated, got %+v", current)
+	}
+	t.Logf("✓ Conflicting ports replaced before deploy")
+}
+
+func TestPortManager_PairingAndSinglePorts(t *testing.T) {
+	t.Logf("Testing pairing strategies and single-port services")
+
+	original := portFree
+	portFree = func(port int) bool { return port != 3002 }
+	defer func() { portFree = original }()
+
+	// Consecutive pairing skips 3002-3003 entirely when 3002 is taken.
+	pm := NewPortManager(3001, 3006)
+	pm.Allocate("a")
+	pair, _ := pm.Allocate("b")
+	if pair.BluePort != 3005 || pair.GreenPort != 3006 {
+		t.Errorf("Expected consecutive pair 3005/3006, got %+v", pair)
+	}
+
+	// Any pairing uses the ports consecutive pairing wastes.
+	pm = NewPortManager(3001, 3006)
+	if err := pm.SetPairing(PairingAny); err != nil {
+		t.Fatalf("SetPairing failed: %v", err)
+	}
+	pm.Allocate("a")
+	pair, _ = pm.Allocate("b")
+	if pair.BluePort != 3004 || pair.GreenPort != 3005 {
+		t.Errorf("Expected any pair 3004/3005, got %+v", pair)
+	}
+	if err := pm.SetPairing("random"); err == nil {
+		t.Errorf("Expected unknown strategy to fail")
+	}
+
+	// A single-port service borrows a spare only while deploying.
+	single, err := pm.AllocateSingle("c", "")
+	if err != nil || single.BluePort != 3006 || !single.Single() {
+		t.Fatalf("Expected single port 3006, got %+v err=%v", single, err)
+	}
+	pm.Release("a")
+	deploying, err := pm.AddSpare("c", "")
+	if err != nil || deploying.BluePort != 3006 || deploying.GreenPort != 3001 {
+		t.Fatalf("Expected spare 3001 during deploy, got %+v err=%v", deploying, err)
+	}
+	if err := pm.Reserve("c", PortPair{BluePort: 3001}); err != nil {
+		t.Fatalf("Reserve failed: %v", err)
+	}
+	if err := pm.Reserve("d", PortPair{BluePort: 3003}); err != nil {
+		t.Errorf("Expected two single-port services not to conflict: %v", err)
+	}
+	if next, _ := pm.AllocateSingle("e", ""); next.BluePort != 3006 {
+		t.Errorf("Expected released port 3006 to be reused, got %+v", next)
+	}
+	t.Logf("✓ Non-adjacent pairs and single ports allocated")
+}
@@ -0,0 +1,118 @@
+package container
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// DockerOp is a kind of docker invocation. Each kind has its own timeout.
+type DockerOp int
+
+const (
+	DockerBuild   DockerOp = iota // docker build
+	DockerPull                    // docker pull
+	DockerRun                     // run, create, start, exec, rename, tag, update and network changes
+	DockerInspect                 // inspect, ps, logs, stats and other reads
+	DockerStop                    // stop, kill, restart and removals
+)
+
+// DockerTimeouts is how long each kind of docker invocation may run before
+// it is killed. Stops also get the container's own stop timeout on top.
+type DockerTimeouts struct {
+	Build   time.Duration
+	Pull    time.Duration
+	Run     time.Duration
+	Inspect time.Duration
+	Stop    time.Duration
+}
+
+// DefaultDockerTimeouts are used for zero fields of SetDockerTimeouts.
+var DefaultDockerTimeouts = DockerTimeouts{
+	Build:   10 * time.Minute,
+	Pull:    10 * time.Minute,
+	Run:     2 * time.Minute,
+	Inspect: 30 * time.Second,
+	Stop:    time.Minute,
+}
+
+var dockerTimeouts = struct {
+	sync.RWMutex
+	DockerTimeouts
+}{DockerTimeouts: DefaultDockerTimeouts}
+
+// SetDockerTimeouts sets the timeouts for docker invocations; zero fields
+// keep their defaults.
+func SetDockerTimeouts(timeouts DockerTimeouts) {
+	orDefault := func(value, fallback time.Duration) time.Duration {
+		if value > 0 {
+			return value
+		}
+		return fallback
+	}
+	dockerTimeouts.Lock()
+	defer dockerTimeouts.Unlock()
+	dockerTimeouts.DockerTimeouts = DockerTimeouts{
+		Build:   orDefault(timeouts.Build, DefaultDockerTimeouts.Build),
+		Pull:    orDefault(timeouts.Pull, DefaultDockerTimeouts.Pull),
+		Run:     orDefault(timeouts.Run, DefaultDockerTimeouts.Run),
+		Inspect: orDefault(timeouts.Inspect, DefaultDockerTimeouts.Inspect),
+		Stop:    orDefault(timeouts.Stop, DefaultDockerTimeouts.Stop),
+	}
+}
+
+// DockerTimeout returns the timeout for a kind of docker invocation.
+func DockerTimeout(op DockerOp) time.Duration {
+	dockerTimeouts.RLock()
+	defer dockerTimeouts.RUnlock()
+	switch op {
+	case DockerBuild:
+		return dockerTimeouts.Build
+	case DockerPull:
+		return dockerTimeouts.Pull
+	case DockerRun:
+		return dockerTimeouts.Run
+	case DockerStop:
+		return dockerTimeouts.Stop
+	default:
+		return dockerTimeouts.Inspect
+	}
+}
+
+// DockerOutput runs docker with op's timeout and returns its standard
+// output, like exec.Cmd.Output. A run cut off by the timeout fails with an
+// error saying so.
+func DockerOutput(op DockerOp, args ...string) ([]byte, error) {
+	return runDocker(DockerTimeout(op), false, args)
+}
+
+// DockerCombinedOutput is DockerOutput with standard error included, like
+// exec.Cmd.CombinedOutput.
+func DockerCombinedOutput(op DockerOp, args ...string) ([]byte, error) {
+	return runDocker(DockerTimeout(op), true, args)
+}
+
+// DockerStopCombinedOutput runs a docker stop or restart that gives the
+// container grace to exit, so it may take the stop timeout plus grace.
+func DockerStopCombinedOutput(grace time.Duration, args ...string) ([]byte, error) {
+	return runDocker(DockerTimeout(DockerStop)+grace, true, args)
+}
+
+func runDocker(timeout time.Duration, combined bool, args []string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "docker", args...)
+	var output []byte
+	var err error
+	if combined {
+		output, err = cmd.CombinedOutput()
+	} else {
+		output, err = cmd.Output()
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return output, fmt.Errorf("docker %s timed out after %s", args[0], timeout)
+	}
+	return output, err
+}
@@ -0,0 +1,47 @@
+package container
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDockerTimeouts(t *testing.T) {
+	t.Logf("Testing docker invocations are bounded by their kind's timeout")
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a shell script as docker")
+	}
+	defer SetDockerTimeouts(DockerTimeouts{})
+
+	SetDockerTimeouts(DockerTimeouts{Inspect: 200 * time.Millisecond})
+	if got := DockerTimeout(DockerInspect); got != 200*time.Millisecond {
+		t.Errorf("Expected the inspect timeout set, got %s", got)
+	}
+	if got := DockerTimeout(DockerBuild); got != DefaultDockerTimeouts.Build {
+		t.Errorf("Expected an unset build timeout to keep its default, got %s", got)
+	}
+
+	binDir := t.TempDir()
+	script := "#!/bin/sh\nif [ \"$1\" = inspect ]; then exec sleep 5; fi\necho \"$@\"\n"
+	if err := os.WriteFile(filepath.Join(binDir, "docker"), []byte(script), 0755); err != nil {
+		t.Fatalf("Failed to write fake docker: %v", err)
+	}
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	start := time.Now()
+	_, err := DockerOutput(DockerInspect, "inspect", "web")
+	if err == nil || !strings.Contains(err.Error(), "docker inspect timed out after 200ms") {
+		t.Fatalf("Expected the inspect to time out, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("Expected the hung inspect to be killed, took %s", elapsed)
+	}
+	output, err := DockerCombinedOutput(DockerRun, "tag", "a", "b")
+	if err != nil || strings.TrimSpace(string(output)) != "tag a b" {
+		t.Errorf("Expected a quick command to run normally, got %q (err=%v)", output, err)
+	}
+	t.Logf("✓ Hung docker calls killed at their timeout")
+}
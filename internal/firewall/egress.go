@@ -0,0 +1,141 @@
+package firewall
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/buildvigil/agent/internal/platform"
+)
+
+// Endpoint is an outbound destination the agent needs to keep working.
+type Endpoint struct {
+	Host  string // Hostname or IP address
+	Port  int
+	Proto string // "tcp" or "udp"
+}
+
+var (
+	lookupHost     = net.LookupHost
+	resolvConfPath = "/etc/resolv.conf"
+)
+
+// EssentialEndpoints returns the destinations the agent must always reach:
+// the control plane URLs, DNS servers from resolv.conf, NTP servers and
+// container registries.
+func EssentialEndpoints(controlPlanes, registries, ntpServers []string) []Endpoint {
+	var endpoints []Endpoint
+	for _, controlPlane := range controlPlanes {
+		u, err := url.Parse(controlPlane)
+		if err != nil || u.Hostname() == "" {
+			continue
+		}
+		port := 443
+		if u.Scheme == "http" {
+			port = 80
+		}
+		if p, err := strconv.Atoi(u.Port()); err == nil {
+			port = p
+		}
+		endpoints = append(endpoints, Endpoint{Host: u.Hostname(), Port: port, Proto: "tcp"})
+	}
+	for _, server := range nameservers() {
+		endpoints = append(endpoints,
+			Endpoint{Host: server, Port: 53, Proto: "udp"},
+			Endpoint{Host: server, Port: 53, Proto: "tcp"})
+	}
+	for _, server := range ntpServers {
+		endpoints = append(endpoints, Endpoint{Host: server, Port: 123, Proto: "udp"})
+	}
+	for _, registry := range registries {
+		endpoints = append(endpoints, Endpoint{Host: registry, Port: 443, Proto: "tcp"})
+	}
+	return endpoints
+}
+
+// nameservers returns the non-loopback nameservers in resolv.conf.
+func nameservers() []string {
+	file, err := os.Open(resolvConfPath)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	var servers []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "nameserver" {
+			continue
+		}
+		if ip := net.ParseIP(fields[1]); ip != nil && !ip.IsLoopback() {
+			servers = append(servers, ip.String())
+		}
+	}
+	return servers
+}
+
+// SetEgressAllowlist sets the outbound destinations that are always allowed,
+// whatever the security mode.
+func (m *Manager) SetEgressAllowlist(endpoints []Endpoint) {
+	m.egress = endpoints
+}
+
+// egressRules resolves the allowlist into per-IP rules. A host that fails to
+// resolve keeps its last known addresses so a DNS hiccup never drops a rule.
+func (m *Manager) egressRules() [][]string {
+	if m.resolved == nil {
+		m.resolved = make(map[string][]string)
+	}
+	seen := make(map[string]bool)
+	var rules [][]string
+	for _, endpoint := range m.egress {
+		addrs := []string{endpoint.Host}
+		if net.ParseIP(endpoint.Host) == nil {
+			resolved, err := lookupHost(endpoint.Host)
+			if err != nil {
+				log.Printf("[Firewall] Failed to resolve egress host: host=%s err=%v", endpoint.Host, err)
+				resolved = m.resolved[endpoint.Host]
+			} else {
+				m.resolved[endpoint.Host] = resolved
+			}
+			addrs = resolved
+		}
+		sort.Strings(addrs)
+		for _, addr := range addrs {
+			rule := []string{"allow", "out", "to", addr, "port", strconv.Itoa(endpoint.Port), "proto", endpoint.Proto}
+			if key := strings.Join(rule, " "); !seen[key] {
+				seen[key] = true
+				rules = append(rules, rule)
+			}
+		}
+	}
+	return rules
+}
+
+// RefreshEgress re-resolves the egress allowlist and updates the rules if
+// any addresses changed. It does nothing until Apply has installed rules.
+func (m *Manager) RefreshEgress() error {
+	if platform.DevMode || m.mode == SecurityModeNone || len(m.egress) == 0 || m.applied == nil {
+		return nil
+	}
+	existing, err := m.agentRules()
+	if err != nil {
+		return err
+	}
+	desired := append(append([][]string{}, m.applied...), m.egressRules()...)
+	added, removed, err := m.converge(existing, desired)
+	if err != nil {
+		return fmt.Errorf("failed to refresh egress rules: %w", err)
+	}
+	if added > 0 || removed > 0 {
+		log.Printf("[Firewall] Egress rules refreshed: added=%d removed=%d", added, removed)
+	}
+	return nil
+}
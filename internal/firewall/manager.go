@@ -2,8 +2,12 @@ package firewall
 
 import (
 	"fmt"
+	"log"
+	"os"
 	"os/exec"
 	"strings"
+
+	"github.com/buildvigil/agent/internal/platform"
 )
 
 // SecurityMode represents the firewall security mode
@@ -18,12 +22,45 @@ const (
 	SecurityModeBlocked SecurityMode = "blocked"
 )
 
+// ruleComment tags the UFW rules managed by the agent.
+const ruleComment = "potato-cloud"
+
+var ufwOutput = defaultUFWOutput
+
+// ufwDefaultsFile holds UFW's default policies, which `ufw status` only
+// reports while UFW is active.
+var ufwDefaultsFile = "/etc/default/ufw"
+
+// policyDirections maps the settings in ufwDefaultsFile to the direction
+// `ufw default` takes, in the order they are restored.
+var policyDirections = []struct{ key, direction string }{
+	{"DEFAULT_INPUT_POLICY", "incoming"},
+	{"DEFAULT_OUTPUT_POLICY", "outgoing"},
+	{"DEFAULT_FORWARD_POLICY", "routed"},
+}
+
 // Manager handles firewall configuration
 type Manager struct {
 	mode       SecurityMode
 	daemonPort int
 	sshPort    int
 	sshCIDR    string
+
+	// egress is always allowed outbound; resolved caches its addresses.
+	egress   []Endpoint
+	resolved map[string][]string
+
+	// applied is the mode's rule set from the last Apply, without egress.
+	applied [][]string
+	// previous is the agent rule set and UFW state before the last Apply.
+	previous *ruleSnapshot
+}
+
+type ruleSnapshot struct {
+	rules  [][]string
+	active bool
+	// defaults maps a direction to its policy, e.g. "incoming" to "deny".
+	defaults map[string]string
 }
 
 // NewManager creates a new firewall manager
@@ -36,6 +73,50 @@ func NewManager(mode SecurityMode, daemonPort int) *Manager {
 	}
 }
 
+// Settings are the inputs of a Manager, so that a privileged helper can
+// rebuild the Manager the agent described.
+type Settings struct {
+	Mode       SecurityMode `json:"mode"`
+	DaemonPort int          `json:"daemon_port"`
+	SSHPort    int          `json:"ssh_port"`
+	SSHCIDR    string       `json:"ssh_cidr,omitempty"`
+	Egress     []Endpoint   `json:"egress,omitempty"`
+}
+
+// Settings returns the manager's inputs.
+func (m *Manager) Settings() Settings {
+	return Settings{Mode: m.mode, DaemonPort: m.daemonPort, SSHPort: m.sshPort, SSHCIDR: m.sshCIDR, Egress: m.egress}
+}
+
+// NewManagerFromSettings creates a manager from settings, rejecting values
+// no agent would send.
+func NewManagerFromSettings(s Settings) (*Manager, error) {
+	switch s.Mode {
+	case SecurityModeNone, SecurityModeDaemonPort, SecurityModeBlocked:
+	default:
+		return nil, fmt.Errorf("unknown security mode: %s", s.Mode)
+	}
+	if s.DaemonPort < 0 || s.DaemonPort > 65535 || s.SSHPort < 0 || s.SSHPort > 65535 {
+		return nil, fmt.Errorf("invalid port")
+	}
+	if s.SSHCIDR != "" {
+		if _, err := parseSSHCIDR(s.SSHCIDR); err != nil {
+			return nil, fmt.Errorf("invalid ssh_cidr %q", s.SSHCIDR)
+		}
+	}
+	for _, e := range s.Egress {
+		if e.Host == "" || strings.ContainsAny(e.Host, " \t\r\n/") || e.Port <= 0 || e.Port > 65535 || (e.Proto != "tcp" && e.Proto != "udp") {
+			return nil, fmt.Errorf("invalid egress endpoint %+v", e)
+		}
+	}
+	m := NewManager(s.Mode, s.DaemonPort)
+	m.SetSSHRestrictions(s.SSHPort, s.SSHCIDR)
+	if len(s.Egress) > 0 {
+		m.SetEgressAllowlist(s.Egress)
+	}
+	return m, nil
+}
+
 // SetSSHRestrictions sets SSH access restrictions
 func (m *Manager) SetSSHRestrictions(port int, cidr string) {
 	m.sshPort = port
@@ -44,6 +125,15 @@ func (m *Manager) SetSSHRestrictions(port int, cidr string) {
 
 // Apply applies the firewall rules based on security mode
 func (m *Manager) Apply() error {
+	if platform.DevMode {
+		log.Printf("[Firewall] Development mode: skipping firewall rules for mode=%s", m.mode)
+		return nil
+	}
+	if m.mode != SecurityModeNone {
+		if err := m.checkSSHLockout(); err != nil {
+			return err
+		}
+	}
 	switch m.mode {
 	case SecurityModeNone:
 		return m.applyNone()
@@ -64,119 +154,260 @@ func (m *Manager) applyNone() error {
 
 // applyDaemonPort allows only daemon port and optional SSH
 func (m *Manager) applyDaemonPort() error {
-	// Reset UFW to default
-	if err := m.resetUFW(); err != nil {
-		return fmt.Errorf("failed to reset UFW: %w", err)
+	rules := [][]string{
+		{"allow", "in", "on", "lo"},
+		{"allow", fmt.Sprintf("%d/tcp", m.daemonPort)},
 	}
+	if m.sshPort > 0 {
+		rules = append(rules, m.sshRule())
+	}
+	return m.applyRules(rules)
+}
 
-	// Set default policies
-	if err := m.runUFW("default", "deny", "incoming"); err != nil {
+// applyBlocked blocks all inbound traffic
+func (m *Manager) applyBlocked() error {
+	rules := [][]string{
+		{"allow", "in", "on", "lo"},
+	}
+	// Allow SSH only from a restricted source
+	if m.sshPort > 0 && m.sshCIDR != "" {
+		rules = append(rules, m.sshRule())
+	}
+	return m.applyRules(rules)
+}
+
+func (m *Manager) sshRule() []string {
+	if m.sshCIDR != "" {
+		return []string{"allow", "from", m.sshCIDR, "to", "any", "port", fmt.Sprintf("%d", m.sshPort)}
+	}
+	return []string{"allow", fmt.Sprintf("%d/tcp", m.sshPort)}
+}
+
+// applyRules converges the agent's tagged UFW rules on the given set plus the
+// egress allowlist. Rules not tagged by the agent are left alone, and UFW is
+// only enabled if it is not already active, so unchanged rules never drop
+// connections. The prior state is kept for Rollback.
+func (m *Manager) applyRules(rules [][]string) error {
+	existing, err := m.agentRules()
+	if err != nil {
 		return err
 	}
-	if err := m.runUFW("default", "allow", "outgoing"); err != nil {
+	active, err := m.isActive()
+	if err != nil {
 		return err
 	}
-
-	// Allow loopback
-	if err := m.runUFW("allow", "in", "on", "lo"); err != nil {
+	defaults, err := defaultPolicies()
+	if err != nil {
 		return err
 	}
+	m.previous = &ruleSnapshot{rules: existing, active: active, defaults: defaults}
 
-	// Allow daemon port
-	if err := m.runUFW("allow", fmt.Sprintf("%d/tcp", m.daemonPort)); err != nil {
-		return fmt.Errorf("failed to allow daemon port: %w", err)
+	if err := m.runUFW("default", "deny", "incoming"); err != nil {
+		return err
 	}
-
-	// Allow SSH if configured
-	if m.sshPort > 0 {
-		if m.sshCIDR != "" {
-			if err := m.runUFW("allow", "from", m.sshCIDR, "to", "any", "port", fmt.Sprintf("%d", m.sshPort)); err != nil {
-				return fmt.Errorf("failed to allow SSH: %w", err)
-			}
-		} else {
-			if err := m.runUFW("allow", fmt.Sprintf("%d/tcp", m.sshPort)); err != nil {
-				return fmt.Errorf("failed to allow SSH: %w", err)
-			}
-		}
+	if err := m.runUFW("default", "allow", "outgoing"); err != nil {
+		return err
 	}
-
-	// Enable UFW
-	if err := m.runUFW("--force", "enable"); err != nil {
-		return fmt.Errorf("failed to enable UFW: %w", err)
+	m.applied = rules
+	rules = append(append([][]string{}, rules...), m.egressRules()...)
+	added, removed, err := m.converge(existing, rules)
+	if err != nil {
+		return err
+	}
+	if !active {
+		if err := m.runUFW("--force", "enable"); err != nil {
+			return fmt.Errorf("failed to enable UFW: %w", err)
+		}
 	}
 
+	log.Printf("[Firewall] Rules applied: mode=%s added=%d removed=%d unchanged=%d enabled=%t", m.mode, added, removed, len(rules)-added, !active)
 	return nil
 }
 
-// applyBlocked blocks all inbound traffic
-func (m *Manager) applyBlocked() error {
-	// Reset UFW to default
-	if err := m.resetUFW(); err != nil {
-		return fmt.Errorf("failed to reset UFW: %w", err)
+// converge adds and removes agent rules to turn existing into desired.
+func (m *Manager) converge(existing, desired [][]string) (int, int, error) {
+	add, remove := diffRules(existing, desired)
+
+	// Add before removing so that replacing an SSH rule never leaves a gap.
+	for _, rule := range add {
+		if err := m.runUFW(append(rule, "comment", ruleComment)...); err != nil {
+			return 0, 0, fmt.Errorf("failed to add rule: %w", err)
+		}
 	}
+	for _, rule := range remove {
+		if err := m.runUFW(append([]string{"delete"}, rule...)...); err != nil {
+			return 0, 0, fmt.Errorf("failed to remove rule: %w", err)
+		}
+	}
+	return len(add), len(remove), nil
+}
 
-	// Set default policies
-	if err := m.runUFW("default", "deny", "incoming"); err != nil {
+// Rollback restores the agent rules, default policies and UFW enabled state
+// from before the last Apply. It is a no-op if Apply has not changed any
+// rules.
+func (m *Manager) Rollback() error {
+	if platform.DevMode || m.previous == nil {
+		return nil
+	}
+	existing, err := m.agentRules()
+	if err != nil {
 		return err
 	}
-	if err := m.runUFW("default", "allow", "outgoing"); err != nil {
+	added, removed, err := m.converge(existing, m.previous.rules)
+	if err != nil {
 		return err
 	}
+	for _, p := range policyDirections {
+		if policy, ok := m.previous.defaults[p.direction]; ok {
+			if err := m.runUFW("default", policy, p.direction); err != nil {
+				return fmt.Errorf("failed to restore default %s policy: %w", p.direction, err)
+			}
+		}
+	}
+	if !m.previous.active {
+		if err := m.runUFW("disable"); err != nil {
+			return fmt.Errorf("failed to disable UFW: %w", err)
+		}
+	}
+	log.Printf("[Firewall] Rules rolled back: mode=%s added=%d removed=%d disabled=%t", m.mode, added, removed, !m.previous.active)
+	m.previous = nil
+	m.applied = nil
+	return nil
+}
 
-	// Allow loopback only
-	if err := m.runUFW("allow", "in", "on", "lo"); err != nil {
+// Revert removes the rules added by the agent, leaving other rules and the
+// firewall's enabled state untouched.
+func (m *Manager) Revert() error {
+	if platform.DevMode {
+		return nil
+	}
+	existing, err := m.agentRules()
+	if err != nil {
 		return err
 	}
-
-	// Allow SSH if in development (optional)
-	if m.sshPort > 0 && m.sshCIDR != "" {
-		if err := m.runUFW("allow", "from", m.sshCIDR, "to", "any", "port", fmt.Sprintf("%d", m.sshPort)); err != nil {
-			return fmt.Errorf("failed to allow SSH: %w", err)
+	for _, rule := range existing {
+		if err := m.runUFW(append([]string{"delete"}, rule...)...); err != nil {
+			return fmt.Errorf("failed to remove rule: %w", err)
 		}
 	}
+	return nil
+}
 
-	// Enable UFW
-	if err := m.runUFW("--force", "enable"); err != nil {
-		return fmt.Errorf("failed to enable UFW: %w", err)
+// agentRules returns the rules tagged with ruleComment, as ufw arguments
+// without the comment.
+func (m *Manager) agentRules() ([][]string, error) {
+	output, err := ufwOutput("show", "added")
+	if err != nil {
+		return nil, err
 	}
+	return parseAgentRules(output), nil
+}
 
-	return nil
+// parseAgentRules parses `ufw show added` output, e.g.
+// "ufw allow 8080/tcp comment 'potato-cloud'".
+func parseAgentRules(output string) [][]string {
+	suffix := fmt.Sprintf(" comment '%s'", ruleComment)
+	var rules [][]string
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		rule, ok := strings.CutPrefix(line, "ufw ")
+		if !ok {
+			continue
+		}
+		if rule, ok = strings.CutSuffix(rule, suffix); !ok {
+			continue
+		}
+		rules = append(rules, strings.Fields(rule))
+	}
+	return rules
 }
 
-// Revert removes all firewall rules
-func (m *Manager) Revert() error {
-	return m.resetUFW()
+// diffRules returns the desired rules missing from existing and the existing
+// rules no longer desired.
+func diffRules(existing, desired [][]string) (add, remove [][]string) {
+	have := make(map[string]bool, len(existing))
+	for _, rule := range existing {
+		have[strings.Join(rule, " ")] = true
+	}
+	want := make(map[string]bool, len(desired))
+	for _, rule := range desired {
+		key := strings.Join(rule, " ")
+		want[key] = true
+		if !have[key] {
+			add = append(add, rule)
+		}
+	}
+	for _, rule := range existing {
+		if !want[strings.Join(rule, " ")] {
+			remove = append(remove, rule)
+		}
+	}
+	return add, remove
 }
 
-// resetUFW resets UFW to default state
-func (m *Manager) resetUFW() error {
-	// Disable UFW first
-	exec.Command("ufw", "--force", "disable").Run()
+// defaultPolicies reads UFW's default policy for each direction from
+// ufwDefaultsFile, e.g. "incoming" to "deny".
+func defaultPolicies() (map[string]string, error) {
+	data, err := os.ReadFile(ufwDefaultsFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read UFW default policies: %w", err)
+	}
+	return parseDefaultPolicies(string(data)), nil
+}
 
-	// Reset to defaults
-	if err := m.runUFW("--force", "reset"); err != nil {
-		// Reset might fail if UFW is not installed, that's ok
-		if !strings.Contains(err.Error(), "not found") {
-			return err
+// parseDefaultPolicies parses /etc/default/ufw settings such as
+// DEFAULT_INPUT_POLICY="DROP". Unknown values are left out.
+func parseDefaultPolicies(content string) map[string]string {
+	policies := map[string]string{"DROP": "deny", "ACCEPT": "allow", "REJECT": "reject"}
+	defaults := make(map[string]string)
+	for _, line := range strings.Split(content, "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), "=")
+		if !ok {
+			continue
+		}
+		policy, known := policies[strings.Trim(value, `"'`)]
+		if !known {
+			continue
+		}
+		for _, p := range policyDirections {
+			if p.key == key {
+				defaults[p.direction] = policy
+			}
 		}
 	}
+	return defaults
+}
 
-	return nil
+// isActive reports whether UFW is currently enabled.
+func (m *Manager) isActive() (bool, error) {
+	output, err := ufwOutput("status")
+	if err != nil {
+		return false, err
+	}
+	return strings.Contains(output, "Status: active"), nil
 }
 
 // runUFW executes a UFW command
 func (m *Manager) runUFW(args ...string) error {
+	_, err := ufwOutput(args...)
+	return err
+}
+
+func defaultUFWOutput(args ...string) (string, error) {
 	cmd := exec.Command("ufw", args...)
 	output, err := cmd.CombinedOutput()
 	if err != nil {
-		return fmt.Errorf("ufw %s failed: %w (output: %s)",
+		return "", fmt.Errorf("ufw %s failed: %w (output: %s)",
 			strings.Join(args, " "), err, string(output))
 	}
-	return nil
+	return string(output), nil
 }
 
 // IsAvailable checks if UFW is available on the system
 func (m *Manager) IsAvailable() bool {
+	if platform.DevMode {
+		return false
+	}
 	cmd := exec.Command("which", "ufw")
 	err := cmd.Run()
 	return err == nil
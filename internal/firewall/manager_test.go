@@ -0,0 +1,253 @@
+package firewall
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestApply_DiffsAgentRules(t *testing.T) {
+	t.Logf("Testing firewall rule diffing...")
+
+	var commands []string
+	original := ufwOutput
+	ufwOutput = func(args ...string) (string, error) {
+		cmd := strings.Join(args, " ")
+		switch cmd {
+		case "show added":
+			return "Added user rules (see 'ufw status' for running firewall):\n" +
+				"ufw allow in on lo comment 'potato-cloud'\n" +
+				"ufw allow 8080/tcp comment 'potato-cloud'\n" +
+				"ufw allow 22/tcp comment 'potato-cloud'\n" +
+				"ufw allow 5432/tcp\n", nil
+		case "status":
+			return "Status: active\n", nil
+		}
+		commands = append(commands, cmd)
+		return "", nil
+	}
+	defer func() { ufwOutput = original }()
+	setUFWDefaults(t, "DEFAULT_INPUT_POLICY=\"DROP\"\n")
+	originalClients := sshSessionClients
+	sshSessionClients = func(int) ([]net.IP, error) { return []net.IP{net.ParseIP("10.1.2.3")}, nil }
+	defer func() { sshSessionClients = originalClients }()
+
+	m := NewManager(SecurityModeDaemonPort, 8080)
+	m.SetSSHRestrictions(22, "10.0.0.0/8")
+	if err := m.Apply(); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+
+	expected := []string{
+		"default deny incoming",
+		"default allow outgoing",
+		"allow from 10.0.0.0/8 to any port 22 comment potato-cloud",
+		"delete allow 22/tcp",
+	}
+	if strings.Join(commands, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Unexpected ufw commands:\n%s\nexpected:\n%s", strings.Join(commands, "\n"), strings.Join(expected, "\n"))
+	}
+	for _, cmd := range commands {
+		if strings.Contains(cmd, "reset") || strings.Contains(cmd, "disable") || strings.Contains(cmd, "enable") || strings.Contains(cmd, "5432") {
+			t.Errorf("Unexpected disruptive or unrelated command: %s", cmd)
+		}
+	}
+	t.Logf("✓ Only changed agent rules were touched")
+}
+
+func TestCheckSSHLockout(t *testing.T) {
+	t.Logf("Testing SSH lockout safeguard...")
+
+	original := sshSessionClients
+	defer func() { sshSessionClients = original }()
+	sshSessionClients = func(int) ([]net.IP, error) {
+		return []net.IP{net.ParseIP("203.0.113.7"), net.ParseIP("127.0.0.1")}, nil
+	}
+
+	cases := []struct {
+		name    string
+		mode    SecurityMode
+		port    int
+		cidr    string
+		lockout bool
+	}{
+		{"open ssh", SecurityModeDaemonPort, 22, "", false},
+		{"client in cidr", SecurityModeBlocked, 22, "203.0.113.0/24", false},
+		{"client is bare ip", SecurityModeBlocked, 22, "203.0.113.7", false},
+		{"other bare ip", SecurityModeBlocked, 22, "203.0.113.8", true},
+		{"bare ipv6", SecurityModeBlocked, 22, "2001:db8::1", true},
+		{"invalid cidr", SecurityModeBlocked, 22, "not-an-ip", true},
+		{"client outside cidr", SecurityModeDaemonPort, 22, "10.0.0.0/8", true},
+		{"blocked without cidr", SecurityModeBlocked, 22, "", true},
+		{"ssh disabled", SecurityModeDaemonPort, 0, "", true},
+	}
+	for _, tc := range cases {
+		m := NewManager(tc.mode, 8080)
+		m.SetSSHRestrictions(tc.port, tc.cidr)
+		err := m.checkSSHLockout()
+		if (err != nil) != tc.lockout {
+			t.Errorf("%s: expected lockout=%v, got err=%v", tc.name, tc.lockout, err)
+		}
+	}
+
+	peers := parseSSPeers("0      0      10.0.0.5:22     203.0.113.7:51234\n0 0 [::1]:22 [fe80::1%eth0]:40000\n")
+	if len(peers) != 2 || peers[0].String() != "203.0.113.7" || peers[1].String() != "fe80::1" {
+		t.Errorf("Unexpected peers: %v", peers)
+	}
+	t.Logf("✓ Rules that would drop the current SSH session are refused")
+}
+
+// setUFWDefaults points ufwDefaultsFile at a file holding content.
+func setUFWDefaults(t *testing.T, content string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "ufw")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write UFW defaults: %v", err)
+	}
+	original := ufwDefaultsFile
+	ufwDefaultsFile = path
+	t.Cleanup(func() { ufwDefaultsFile = original })
+}
+
+// fakeUFW keeps agent rules, default policies and the enabled state in
+// memory.
+type fakeUFW struct {
+	rules    []string
+	defaults map[string]string
+	active   bool
+}
+
+func (f *fakeUFW) run(args ...string) (string, error) {
+	cmd := strings.Join(args, " ")
+	switch {
+	case cmd == "show added":
+		out := "Added user rules (see 'ufw status' for running firewall):\n"
+		for _, rule := range f.rules {
+			out += "ufw " + rule + " comment 'potato-cloud'\n"
+		}
+		return out, nil
+	case cmd == "status":
+		if f.active {
+			return "Status: active\n", nil
+		}
+		return "Status: inactive\n", nil
+	case cmd == "--force enable":
+		f.active = true
+	case cmd == "disable":
+		f.active = false
+	case len(args) == 3 && args[0] == "default":
+		if f.defaults == nil {
+			f.defaults = make(map[string]string)
+		}
+		f.defaults[args[2]] = args[1]
+	case strings.HasPrefix(cmd, "delete "):
+		rule := strings.TrimPrefix(cmd, "delete ")
+		for i, existing := range f.rules {
+			if existing == rule {
+				f.rules = append(f.rules[:i], f.rules[i+1:]...)
+				break
+			}
+		}
+	case strings.HasSuffix(cmd, " comment potato-cloud"):
+		f.rules = append(f.rules, strings.TrimSuffix(cmd, " comment potato-cloud"))
+	}
+	return "", nil
+}
+
+func TestRollback_RestoresPreviousRules(t *testing.T) {
+	t.Logf("Testing firewall rollback...")
+
+	fake := &fakeUFW{rules: []string{"allow in on lo", "allow 8080/tcp", "allow 22/tcp"}}
+	original := ufwOutput
+	ufwOutput = fake.run
+	defer func() { ufwOutput = original }()
+	setUFWDefaults(t, "# /etc/default/ufw\nIPV6=yes\nDEFAULT_INPUT_POLICY=\"ACCEPT\"\nDEFAULT_OUTPUT_POLICY=\"REJECT\"\nDEFAULT_FORWARD_POLICY=\"DROP\"\n")
+	originalClients := sshSessionClients
+	sshSessionClients = func(int) ([]net.IP, error) { return nil, nil }
+	defer func() { sshSessionClients = originalClients }()
+
+	m := NewManager(SecurityModeBlocked, 8080)
+	if err := m.Apply(); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	if strings.Join(fake.rules, ",") != "allow in on lo" || !fake.active || fake.defaults["incoming"] != "deny" {
+		t.Fatalf("Expected blocked rules applied and UFW enabled, got %v defaults=%v active=%v", fake.rules, fake.defaults, fake.active)
+	}
+
+	if err := m.Rollback(); err != nil {
+		t.Fatalf("Rollback failed: %v", err)
+	}
+	if strings.Join(fake.rules, ",") != "allow in on lo,allow 8080/tcp,allow 22/tcp" {
+		t.Errorf("Expected previous rules restored, got %v", fake.rules)
+	}
+	if fake.defaults["incoming"] != "allow" || fake.defaults["outgoing"] != "reject" || fake.defaults["routed"] != "deny" {
+		t.Errorf("Expected previous default policies restored, got %v", fake.defaults)
+	}
+	if fake.active {
+		t.Errorf("Expected UFW disabled again since it was inactive before Apply")
+	}
+	t.Logf("✓ Previous rule set, default policies and UFW state restored")
+}
+
+func TestEgressAllowlist_ResolvesAndRefreshes(t *testing.T) {
+	t.Logf("Testing essential egress rules...")
+
+	resolv := filepath.Join(t.TempDir(), "resolv.conf")
+	os.WriteFile(resolv, []byte("nameserver 127.0.0.53\nnameserver 1.1.1.1\nsearch local\n"), 0644)
+	originalResolv := resolvConfPath
+	resolvConfPath = resolv
+	defer func() { resolvConfPath = originalResolv }()
+
+	addrs := map[string][]string{"cp.example.com": {"203.0.113.10"}}
+	originalLookup := lookupHost
+	lookupHost = func(host string) ([]string, error) {
+		if resolved, ok := addrs[host]; ok {
+			return resolved, nil
+		}
+		return nil, errors.New("no such host")
+	}
+	defer func() { lookupHost = originalLookup }()
+
+	fake := &fakeUFW{active: true}
+	original := ufwOutput
+	ufwOutput = fake.run
+	defer func() { ufwOutput = original }()
+	setUFWDefaults(t, "DEFAULT_INPUT_POLICY=\"DROP\"\n")
+	originalClients := sshSessionClients
+	sshSessionClients = func(int) ([]net.IP, error) { return nil, nil }
+	defer func() { sshSessionClients = originalClients }()
+
+	endpoints := EssentialEndpoints([]string{"https://cp.example.com"}, nil, []string{"ntp.example.com"})
+	m := NewManager(SecurityModeBlocked, 8080)
+	m.SetEgressAllowlist(endpoints)
+	if err := m.Apply(); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	expected := "allow in on lo," +
+		"allow out to 203.0.113.10 port 443 proto tcp," +
+		"allow out to 1.1.1.1 port 53 proto udp," +
+		"allow out to 1.1.1.1 port 53 proto tcp"
+	if strings.Join(fake.rules, ",") != expected {
+		t.Fatalf("Unexpected rules:\n%s\nexpected:\n%s", strings.Join(fake.rules, ","), expected)
+	}
+
+	// The control plane moves; a failed lookup keeps the last known address.
+	addrs["cp.example.com"] = []string{"203.0.113.20"}
+	addrs["ntp.example.com"] = []string{"198.51.100.1"}
+	if err := m.RefreshEgress(); err != nil {
+		t.Fatalf("RefreshEgress failed: %v", err)
+	}
+	delete(addrs, "cp.example.com")
+	if err := m.RefreshEgress(); err != nil {
+		t.Fatalf("RefreshEgress failed: %v", err)
+	}
+	rules := strings.Join(fake.rules, ",")
+	if strings.Contains(rules, "203.0.113.10") || !strings.Contains(rules, "allow out to 203.0.113.20 port 443 proto tcp") ||
+		!strings.Contains(rules, "allow out to 198.51.100.1 port 123 proto udp") || !strings.Contains(rules, "allow in on lo") {
+		t.Errorf("Unexpected rules after refresh: %v", fake.rules)
+	}
+	t.Logf("✓ Control plane, DNS and NTP stay allowed as addresses change")
+}
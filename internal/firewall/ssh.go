@@ -0,0 +1,105 @@
+package firewall
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+var sshSessionClients = defaultSSHSessionClients
+
+// checkSSHLockout refuses rules that would cut off a connected SSH client.
+// If sessions cannot be detected the check is skipped.
+func (m *Manager) checkSSHLockout() error {
+	clients, err := sshSessionClients(m.sshPort)
+	if err != nil || len(clients) == 0 {
+		return nil
+	}
+
+	var allowed *net.IPNet
+	switch {
+	case m.sshPort <= 0:
+	case m.sshCIDR != "":
+		cidr, err := parseSSHCIDR(m.sshCIDR)
+		if err != nil {
+			return err
+		}
+		allowed = cidr
+	case m.mode == SecurityModeDaemonPort:
+		// SSH open to everyone.
+		return nil
+	}
+
+	for _, client := range clients {
+		if client.IsLoopback() {
+			continue
+		}
+		if allowed == nil || !allowed.Contains(client) {
+			return fmt.Errorf("refusing to apply firewall mode %s: it would lock out the SSH session from %s (ssh_port=%d ssh_allowed_cidr=%q)", m.mode, client, m.sshPort, m.sshCIDR)
+		}
+	}
+	return nil
+}
+
+// parseSSHCIDR parses the SSH allowed source, which ufw also accepts as a
+// bare address: that is a /32, or a /128 for IPv6.
+func parseSSHCIDR(value string) (*net.IPNet, error) {
+	if _, cidr, err := net.ParseCIDR(value); err == nil {
+		return cidr, nil
+	}
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid SSH allowed CIDR %q", value)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
+
+// defaultSSHSessionClients returns the peers of established connections to
+// the SSH port, plus the client of the current process's own SSH session.
+func defaultSSHSessionClients(port int) ([]net.IP, error) {
+	if port <= 0 {
+		port = 22
+	}
+	var clients []net.IP
+	// SSH_CLIENT is "client-ip client-port server-port".
+	clientIP, _, _ := strings.Cut(os.Getenv("SSH_CLIENT"), " ")
+	if ip := net.ParseIP(clientIP); ip != nil {
+		clients = append(clients, ip)
+	}
+
+	output, err := exec.Command("ss", "-Htn", "state", "established", fmt.Sprintf("( sport = :%d )", port)).Output()
+	if err != nil {
+		if len(clients) > 0 {
+			return clients, nil
+		}
+		return nil, fmt.Errorf("ss failed: %w", err)
+	}
+	return append(clients, parseSSPeers(string(output))...), nil
+}
+
+// parseSSPeers extracts peer addresses from `ss -Htn state established`
+// lines ("Recv-Q Send-Q Local:Port Peer:Port").
+func parseSSPeers(output string) []net.IP {
+	var peers []net.IP
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 4 {
+			continue
+		}
+		host, _, err := net.SplitHostPort(fields[3])
+		if err != nil {
+			continue
+		}
+		// IPv6 peers may carry a zone ("fe80::1%eth0").
+		host, _, _ = strings.Cut(strings.Trim(host, "[]"), "%")
+		if ip := net.ParseIP(host); ip != nil {
+			peers = append(peers, ip)
+		}
+	}
+	return peers
+}
@@ -8,6 +8,7 @@ import (
 	"path/filepath"
 	"strings"
 
+	"github.com/buildvigil/agent/internal/platform"
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/plumbing"
 	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
@@ -18,6 +19,8 @@ import (
 type Manager struct {
 	reposPath string
 	keysDir   string
+	caBundle  []byte
+	owner     *platform.Identity
 }
 
 // NewManager creates a new Git manager
@@ -25,6 +28,18 @@ func NewManager(reposPath string, keysDir string) *Manager {
 	return &Manager{reposPath: reposPath, keysDir: keysDir}
 }
 
+// SetCABundle trusts the PEM certificates in bundle, on top of the system
+// roots, for clones and fetches over HTTPS.
+func (m *Manager) SetCABundle(bundle []byte) {
+	m.caBundle = bundle
+}
+
+// SetOwner makes checkouts owned by id after each clone or pull; nil leaves
+// them owned by the agent.
+func (m *Manager) SetOwner(id *platform.Identity) {
+	m.owner = id
+}
+
 // getRepoPath returns the path for a service's repository
 func (m *Manager) getRepoPath(serviceID string) string {
 	return filepath.Join(m.reposPath, serviceID)
@@ -36,6 +51,13 @@ func (m *Manager) getRepoPath(serviceID string) string {
 func (m *Manager) CloneOrPull(serviceID string, gitURL string, gitRef string, gitCommit string, sshKeyName string) (string, error) {
 	repoPath := m.getRepoPath(serviceID)
 	sshKeyName = strings.TrimSpace(sshKeyName)
+	if m.owner != nil {
+		defer func() {
+			if err := m.owner.Chown(repoPath); err != nil && !os.IsNotExist(err) {
+				log.Printf("Failed to set repo owner: service=%s err=%v", serviceID, err)
+			}
+		}()
+	}
 
 	// Check if repo already exists
 	if _, err := os.Stat(repoPath); os.IsNotExist(err) {
@@ -142,6 +164,7 @@ func (m *Manager) clone(gitURL, destPath string, sshKeyName string) error {
 		URL:      gitURL,
 		Progress: os.Stdout,
 		Auth:     auth,
+		CABundle: m.caBundle,
 	})
 
 	if err != nil {
@@ -152,6 +175,7 @@ func (m *Manager) clone(gitURL, destPath string, sshKeyName string) error {
 			_, retryErr := git.PlainClone(destPath, false, &git.CloneOptions{
 				URL:      gitURL,
 				Progress: os.Stdout,
+				CABundle: m.caBundle,
 			})
 			if retryErr == nil {
 				return nil
@@ -186,11 +210,11 @@ func (m *Manager) checkoutCommit(repoPath string, repo *git.Repository, commit s
 	}
 
 	// First fetch to ensure we have the commit
-	if err := repo.Fetch(&git.FetchOptions{Progress: os.Stdout, Auth: auth}); err != nil && err != git.NoErrAlreadyUpToDate {
+	if err := repo.Fetch(&git.FetchOptions{Progress: os.Stdout, Auth: auth, CABundle: m.caBundle}); err != nil && err != git.NoErrAlreadyUpToDate {
 		log.Printf("Git fetch failed (commit=%s): %v", commit, err)
 		fetchErr := err
 		if strings.Contains(err.Error(), "invalid auth method") {
-			if retryErr := repo.Fetch(&git.FetchOptions{Progress: os.Stdout}); retryErr != nil && retryErr != git.NoErrAlreadyUpToDate {
+			if retryErr := repo.Fetch(&git.FetchOptions{Progress: os.Stdout, CABundle: m.caBundle}); retryErr != nil && retryErr != git.NoErrAlreadyUpToDate {
 				log.Printf("Git fetch retry without auth failed (commit=%s): %v", commit, retryErr)
 				fetchErr = retryErr
 			} else {
@@ -235,11 +259,11 @@ func (m *Manager) checkoutRef(repoPath string, repo *git.Repository, ref string,
 		return "", err
 	}
 
-	if err := repo.Fetch(&git.FetchOptions{Progress: os.Stdout, Auth: auth}); err != nil && err != git.NoErrAlreadyUpToDate {
+	if err := repo.Fetch(&git.FetchOptions{Progress: os.Stdout, Auth: auth, CABundle: m.caBundle}); err != nil && err != git.NoErrAlreadyUpToDate {
 		log.Printf("Git fetch failed (ref=%s): %v", ref, err)
 		fetchErr := err
 		if strings.Contains(err.Error(), "invalid auth method") {
-			if retryErr := repo.Fetch(&git.FetchOptions{Progress: os.Stdout}); retryErr != nil && retryErr != git.NoErrAlreadyUpToDate {
+			if retryErr := repo.Fetch(&git.FetchOptions{Progress: os.Stdout, CABundle: m.caBundle}); retryErr != nil && retryErr != git.NoErrAlreadyUpToDate {
 				log.Printf("Git fetch retry without auth failed (ref=%s): %v", ref, retryErr)
 				fetchErr = retryErr
 			} else {
@@ -261,11 +285,11 @@ func (m *Manager) checkoutRef(repoPath string, repo *git.Repository, ref string,
 
 	branchRef := plumbing.NewBranchReferenceName(ref)
 	if err := worktree.Checkout(&git.CheckoutOptions{Branch: branchRef, Force: true}); err == nil {
-		if err := worktree.Pull(&git.PullOptions{RemoteName: "origin", ReferenceName: branchRef, Force: true, Auth: auth}); err != nil && err != git.NoErrAlreadyUpToDate {
+		if err := worktree.Pull(&git.PullOptions{RemoteName: "origin", ReferenceName: branchRef, Force: true, Auth: auth, CABundle: m.caBundle}); err != nil && err != git.NoErrAlreadyUpToDate {
 			log.Printf("Git pull failed (ref=%s): %v", ref, err)
 			pullErr := err
 			if strings.Contains(err.Error(), "invalid auth method") {
-				if retryErr := worktree.Pull(&git.PullOptions{RemoteName: "origin", ReferenceName: branchRef, Force: true}); retryErr != nil && retryErr != git.NoErrAlreadyUpToDate {
+				if retryErr := worktree.Pull(&git.PullOptions{RemoteName: "origin", ReferenceName: branchRef, Force: true, CABundle: m.caBundle}); retryErr != nil && retryErr != git.NoErrAlreadyUpToDate {
 					log.Printf("Git pull retry without auth failed (ref=%s): %v", ref, retryErr)
 					pullErr = retryErr
 				} else {
@@ -373,7 +397,7 @@ func repoConfigURL(repo *git.Repository) string {
 }
 
 func fetchWithGitCLI(repoPath string) error {
-	cmd := exec.Command("git", "-C", repoPath, "fetch", "--all")
+	cmd := platform.Unprivileged(exec.Command("git", "-C", repoPath, "fetch", "--all"))
 	output, err := cmd.CombinedOutput()
 	if err != nil {
 		return fmt.Errorf("git fetch (cli) failed: %w (output: %s)", err, strings.TrimSpace(string(output)))
@@ -382,7 +406,7 @@ func fetchWithGitCLI(repoPath string) error {
 }
 
 func pullWithGitCLI(repoPath, ref string) error {
-	cmd := exec.Command("git", "-C", repoPath, "pull", "--ff-only", "origin", ref)
+	cmd := platform.Unprivileged(exec.Command("git", "-C", repoPath, "pull", "--ff-only", "origin", ref))
 	output, err := cmd.CombinedOutput()
 	if err != nil {
 		return fmt.Errorf("git pull (cli) failed: %w (output: %s)", err, strings.TrimSpace(string(output)))
@@ -391,7 +415,7 @@ func pullWithGitCLI(repoPath, ref string) error {
 }
 
 func cloneWithGitCLI(gitURL, destPath string) error {
-	cmd := exec.Command("git", "clone", gitURL, destPath)
+	cmd := platform.Unprivileged(exec.Command("git", "clone", gitURL, destPath))
 	output, err := cmd.CombinedOutput()
 	if err != nil {
 		return fmt.Errorf("git clone (cli) failed: %w (output: %s)", err, strings.TrimSpace(string(output)))
@@ -0,0 +1,100 @@
+// Package logexport writes service logs out of the state database: to a
+// local file on demand, to S3-compatible storage on a schedule, or to the
+// control plane as they arrive, so logs can be kept beyond the database's
+// retention cap.
+package logexport
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/buildvigil/agent/internal/state"
+)
+
+const (
+	FormatJSONL = "jsonl"
+	FormatText  = "text"
+
+	exportBatchSize = 1000
+)
+
+// ParseSince parses a -since value: a Go duration ("36h"), a number of days
+// ("7d"), an RFC3339 timestamp, or a date ("2026-01-31"). Durations are
+// relative to now. An empty value means no lower bound.
+func ParseSince(value string, now time.Time) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil && n >= 0 {
+			return now.AddDate(0, 0, -n), nil
+		}
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid -since value %q (use a duration like 24h or 7d, a date, or an RFC3339 time)", value)
+}
+
+// ValidateFormat checks an export format name.
+func ValidateFormat(format string) error {
+	switch format {
+	case FormatJSONL, FormatText:
+		return nil
+	default:
+		return fmt.Errorf("unsupported export format %q (use %s or %s)", format, FormatJSONL, FormatText)
+	}
+}
+
+// WriteEntry writes a single log line in the given format.
+func WriteEntry(w io.Writer, entry state.ServiceLog, format string) error {
+	if format == FormatText {
+		_, err := fmt.Fprintf(w, "%s %s [%s] %s\n", entry.CreatedAt.UTC().Format(time.RFC3339), entry.ServiceID, entry.Level, entry.Message)
+		return err
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// Export writes every log matching serviceID (empty for all services) and
+// filter with an ID greater than afterID, oldest first. It returns the number
+// of lines written and the highest log ID exported.
+func Export(stateMgr *state.Manager, w io.Writer, serviceID string, filter state.LogFilter, afterID int64, format string) (int, int64, error) {
+	if err := ValidateFormat(format); err != nil {
+		return 0, afterID, err
+	}
+
+	written := 0
+	lastID := afterID
+	for {
+		logs, err := stateMgr.ScanServiceLogs(serviceID, filter, lastID, exportBatchSize)
+		if err != nil {
+			return written, lastID, err
+		}
+		for _, entry := range logs {
+			if err := WriteEntry(w, entry, format); err != nil {
+				return written, lastID, fmt.Errorf("failed to write log export: %w", err)
+			}
+			written++
+			lastID = entry.ID
+		}
+		if len(logs) < exportBatchSize {
+			return written, lastID, nil
+		}
+	}
+}
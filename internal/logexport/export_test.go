@@ -0,0 +1,82 @@
+package logexport
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/buildvigil/agent/internal/state"
+)
+
+func newTestState(t *testing.T) *state.Manager {
+	t.Helper()
+	stateMgr, err := state.NewManager(filepath.Join(t.TempDir(), "state.db"))
+	if err != nil {
+		t.Fatalf("Failed to create state manager: %v", err)
+	}
+	t.Cleanup(func() { stateMgr.Close() })
+	return stateMgr
+}
+
+func TestParseSince(t *testing.T) {
+	t.Logf("Testing -since parsing...")
+
+	now := time.Date(2026, 5, 10, 12, 0, 0, 0, time.UTC)
+	cases := map[string]time.Time{
+		"":                     {},
+		"36h":                  now.Add(-36 * time.Hour),
+		"7d":                   now.AddDate(0, 0, -7),
+		"2026-05-01":           time.Date(2026, 5, 1, 0, 0, 0, 0, time.UTC),
+		"2026-05-01T08:00:00Z": time.Date(2026, 5, 1, 8, 0, 0, 0, time.UTC),
+	}
+	for input, want := range cases {
+		got, err := ParseSince(input, now)
+		if err != nil || !got.Equal(want) {
+			t.Errorf("ParseSince(%q) = %v, %v; want %v", input, got, err, want)
+		}
+	}
+	if _, err := ParseSince("yesterday", now); err == nil {
+		t.Errorf("Expected error for unparseable value")
+	}
+	t.Logf("✓ Durations, days, dates and timestamps parsed")
+}
+
+func TestExport_FormatsAndPaging(t *testing.T) {
+	t.Logf("Testing log export formats...")
+	stateMgr := newTestState(t)
+
+	for i := 0; i < exportBatchSize+5; i++ {
+		stateMgr.LogServiceMessage("svc-a", "info", "line")
+	}
+	stateMgr.LogServiceMessage("svc-b", "error", "other service")
+
+	var jsonl bytes.Buffer
+	written, lastID, err := Export(stateMgr, &jsonl, "svc-a", state.LogFilter{}, 0, FormatJSONL)
+	if err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	if written != exportBatchSize+5 || lastID != int64(exportBatchSize+5) {
+		t.Errorf("Expected %d lines up to id %d, got %d up to %d", exportBatchSize+5, exportBatchSize+5, written, lastID)
+	}
+	var first state.ServiceLog
+	line, _, _ := strings.Cut(jsonl.String(), "\n")
+	if err := json.Unmarshal([]byte(line), &first); err != nil || first.ServiceID != "svc-a" || first.Message != "line" {
+		t.Errorf("Unexpected JSONL line %q (err=%v)", line, err)
+	}
+
+	var text bytes.Buffer
+	if _, _, err := Export(stateMgr, &text, "", state.LogFilter{}, lastID, FormatText); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	if !strings.Contains(text.String(), "svc-b [error] other service") {
+		t.Errorf("Unexpected text export: %q", text.String())
+	}
+
+	if _, _, err := Export(stateMgr, &text, "", state.LogFilter{}, 0, "csv"); err == nil {
+		t.Errorf("Expected error for unsupported format")
+	}
+	t.Logf("✓ Logs exported as JSONL and text")
+}
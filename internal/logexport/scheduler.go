@@ -0,0 +1,174 @@
+package logexport
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/buildvigil/agent/internal/logging"
+	"github.com/buildvigil/agent/internal/state"
+)
+
+// Progress records what the scheduler has already uploaded so each run only
+// ships new data.
+type Progress struct {
+	LastLogID         int64     `json:"last_log_id"`
+	UploadedAgentLogs []string  `json:"uploaded_agent_logs"`
+	LastRunAt         time.Time `json:"last_run_at"`
+}
+
+// Scheduler periodically uploads service logs from the state database and
+// rotated agent log files to S3-compatible storage.
+type Scheduler struct {
+	state        *state.Manager
+	uploader     *S3Uploader
+	agentID      string
+	agentLogPath string
+	progressPath string
+	interval     time.Duration
+	stopChan     chan struct{}
+	done         chan struct{}
+}
+
+// NewScheduler creates a scheduler. progressPath is where upload progress is
+// persisted between runs and restarts.
+func NewScheduler(stateMgr *state.Manager, uploader *S3Uploader, agentID, agentLogPath, progressPath string, interval time.Duration) *Scheduler {
+	return &Scheduler{
+		state:        stateMgr,
+		uploader:     uploader,
+		agentID:      agentID,
+		agentLogPath: agentLogPath,
+		progressPath: progressPath,
+		interval:     interval,
+	}
+}
+
+// Start runs an export immediately and then every interval until Stop.
+func (s *Scheduler) Start() {
+	s.stopChan = make(chan struct{})
+	s.done = make(chan struct{})
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		for {
+			if err := s.RunOnce(context.Background()); err != nil {
+				log.Printf("[LogExport] Export failed: %v", err)
+			}
+			select {
+			case <-s.stopChan:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop stops the schedule and waits for an in-flight export to finish.
+func (s *Scheduler) Stop() {
+	if s.stopChan == nil {
+		return
+	}
+	close(s.stopChan)
+	<-s.done
+}
+
+// RunOnce uploads service logs added since the last run as one gzipped JSONL
+// object, and any rotated agent log files not uploaded yet.
+func (s *Scheduler) RunOnce(ctx context.Context) error {
+	progress := s.loadProgress()
+	start := time.Now().UTC()
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	written, lastID, err := Export(s.state, gz, "", state.LogFilter{}, progress.LastLogID, FormatJSONL)
+	if err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("failed to compress log export: %w", err)
+	}
+	if written > 0 {
+		name := fmt.Sprintf("%s/service-logs/%s-%d.jsonl.gz", s.agentID, start.Format("20060102T150405Z"), lastID)
+		if err := s.uploader.Put(ctx, s.uploader.Key(name), buf.Bytes(), "application/gzip"); err != nil {
+			return err
+		}
+		progress.LastLogID = lastID
+		log.Printf("[LogExport] Service logs uploaded: lines=%d last_id=%d", written, lastID)
+	}
+
+	uploaded := make(map[string]bool, len(progress.UploadedAgentLogs))
+	for _, name := range progress.UploadedAgentLogs {
+		uploaded[name] = true
+	}
+	var kept []string
+	for _, path := range logging.RotatedFiles(s.agentLogPath) {
+		name := filepath.Base(path)
+		// Only ship compressed files; an uncompressed one is still being gzipped.
+		if filepath.Ext(name) != ".gz" {
+			continue
+		}
+		kept = append(kept, name)
+		if uploaded[name] {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read rotated agent log: %w", err)
+		}
+		if err := s.uploader.Put(ctx, s.uploader.Key(s.agentID+"/agent-logs/"+name), data, "application/gzip"); err != nil {
+			s.saveProgress(progress)
+			return err
+		}
+		progress.UploadedAgentLogs = append(progress.UploadedAgentLogs, name)
+		log.Printf("[LogExport] Agent log uploaded: file=%s", name)
+	}
+	// Forget files that retention has already pruned locally.
+	progress.UploadedAgentLogs = intersect(progress.UploadedAgentLogs, kept)
+
+	progress.LastRunAt = start
+	s.saveProgress(progress)
+	return nil
+}
+
+func (s *Scheduler) loadProgress() Progress {
+	var progress Progress
+	data, err := os.ReadFile(s.progressPath)
+	if err != nil {
+		return progress
+	}
+	if err := json.Unmarshal(data, &progress); err != nil {
+		log.Printf("[LogExport] Ignoring unreadable progress file %s: %v", s.progressPath, err)
+	}
+	return progress
+}
+
+func (s *Scheduler) saveProgress(progress Progress) {
+	data, err := json.MarshalIndent(progress, "", "  ")
+	if err != nil {
+		return
+	}
+	if err := os.WriteFile(s.progressPath, data, 0600); err != nil {
+		log.Printf("[LogExport] Failed to save progress: %v", err)
+	}
+}
+
+func intersect(values, keep []string) []string {
+	allowed := make(map[string]bool, len(keep))
+	for _, value := range keep {
+		allowed[value] = true
+	}
+	var out []string
+	for _, value := range values {
+		if allowed[value] {
+			out = append(out, value)
+		}
+	}
+	return out
+}
@@ -0,0 +1,100 @@
+package logexport
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	auth := r.Header.Get("Authorization")
+	if r.Method != http.MethodPut || !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKID/") || r.Header.Get("X-Amz-Content-Sha256") == "" {
+		http.Error(w, "bad request", http.StatusForbidden)
+		return
+	}
+	body, _ := io.ReadAll(r.Body)
+	f.mu.Lock()
+	f.objects[r.URL.Path] = body
+	f.mu.Unlock()
+}
+
+func (f *fakeS3) keys() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var keys []string
+	for key := range f.objects {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func TestScheduler_UploadsOnlyNewData(t *testing.T) {
+	t.Logf("Testing scheduled S3 log export...")
+	stateMgr := newTestState(t)
+	s3 := &fakeS3{objects: map[string][]byte{}}
+	server := httptest.NewServer(s3)
+	defer server.Close()
+
+	uploader, err := NewS3Uploader(S3Config{
+		Endpoint:        server.URL,
+		Bucket:          "logs",
+		Prefix:          "/archive/",
+		AccessKeyID:     "AKID",
+		SecretAccessKey: "secret",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create uploader: %v", err)
+	}
+
+	dir := t.TempDir()
+	agentLog := filepath.Join(dir, "agent.log")
+	os.WriteFile(filepath.Join(dir, "agent-20260101T000000Z.log.gz"), []byte("gz"), 0600)
+	os.WriteFile(filepath.Join(dir, "agent-20260102T000000Z.log"), []byte("still compressing"), 0600)
+	stateMgr.LogServiceMessage("svc", "info", "hello")
+
+	scheduler := NewScheduler(stateMgr, uploader, "agent-1", agentLog, filepath.Join(dir, "export.json"), 0)
+	if err := scheduler.RunOnce(context.Background()); err != nil {
+		t.Fatalf("First run failed: %v", err)
+	}
+	if keys := s3.keys(); len(keys) != 2 {
+		t.Fatalf("Expected service log and one agent log uploaded, got %v", keys)
+	}
+	if _, ok := s3.objects["/logs/archive/agent-1/agent-logs/agent-20260101T000000Z.log.gz"]; !ok {
+		t.Errorf("Expected rotated agent log under prefix, got %v", s3.keys())
+	}
+
+	if err := scheduler.RunOnce(context.Background()); err != nil {
+		t.Fatalf("Second run failed: %v", err)
+	}
+	if keys := s3.keys(); len(keys) != 2 {
+		t.Errorf("Expected no new uploads without new data, got %v", keys)
+	}
+
+	stateMgr.LogServiceMessage("svc", "info", "again")
+	if err := scheduler.RunOnce(context.Background()); err != nil {
+		t.Fatalf("Third run failed: %v", err)
+	}
+	if keys := s3.keys(); len(keys) != 3 {
+		t.Errorf("Expected one new service log object, got %v", keys)
+	}
+	t.Logf("✓ Only new logs uploaded on each run")
+}
+
+func TestNewS3Uploader_RequiresCredentials(t *testing.T) {
+	t.Logf("Testing S3 config validation...")
+	if _, err := NewS3Uploader(S3Config{Endpoint: "https://s3.example.com", Bucket: "b"}); err == nil {
+		t.Errorf("Expected error without credentials")
+	}
+	t.Logf("✓ Missing credentials rejected")
+}
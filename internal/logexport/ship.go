@@ -0,0 +1,188 @@
+package logexport
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/buildvigil/agent/internal/api"
+	"github.com/buildvigil/agent/internal/state"
+)
+
+const (
+	// maxShipBatchBytes caps the message bytes in one batch so a burst of
+	// long lines doesn't produce an oversized request.
+	maxShipBatchBytes = 1 << 20
+	// maxShipBackoff bounds the wait after failed or throttled sends.
+	maxShipBackoff = 5 * time.Minute
+)
+
+// LogSender delivers a batch of log lines. *api.Client implements it.
+type LogSender interface {
+	SendLogs(ctx context.Context, entries []api.LogEntry) error
+}
+
+// ShipCursor records the last log line the control plane accepted, so
+// shipping resumes where it left off after a restart.
+type ShipCursor struct {
+	LastLogID     int64     `json:"last_log_id"`
+	LastShippedAt time.Time `json:"last_shipped_at"`
+}
+
+// Shipper streams new service logs from the state database to the control
+// plane in batches. It only advances its cursor once a batch is accepted,
+// and backs off when sends fail or the control plane throttles it.
+type Shipper struct {
+	state      *state.Manager
+	sender     LogSender
+	cursorPath string
+	interval   time.Duration
+	batchSize  int
+	stopChan   chan struct{}
+	cancel     context.CancelFunc
+	done       chan struct{}
+}
+
+// NewShipper creates a shipper that checks for new logs every interval and
+// sends at most batchSize lines per request. cursorPath is where progress is
+// persisted between runs and restarts.
+func NewShipper(stateMgr *state.Manager, sender LogSender, cursorPath string, interval time.Duration, batchSize int) *Shipper {
+	if batchSize <= 0 {
+		batchSize = 500
+	}
+	return &Shipper{
+		state:      stateMgr,
+		sender:     sender,
+		cursorPath: cursorPath,
+		interval:   interval,
+		batchSize:  batchSize,
+	}
+}
+
+// Start ships immediately and then every interval until Stop. After a
+// failure it waits longer each time, up to five minutes, or as long as the
+// control plane asked when it throttled the agent.
+func (s *Shipper) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.stopChan = make(chan struct{})
+	s.cancel = cancel
+	s.done = make(chan struct{})
+	go func() {
+		defer close(s.done)
+		backoff := s.interval
+		for {
+			wait := s.interval
+			if _, err := s.RunOnce(ctx); err != nil && ctx.Err() == nil {
+				backoff = min(backoff*2, maxShipBackoff)
+				wait = max(backoff, s.interval)
+				var throttled *api.ThrottledError
+				if errors.As(err, &throttled) && throttled.RetryAfter > 0 {
+					wait = throttled.RetryAfter
+				}
+				log.Printf("[LogShip] Shipping failed, retrying in %s: %v", wait, err)
+			} else {
+				backoff = s.interval
+			}
+
+			timer := time.NewTimer(wait)
+			select {
+			case <-s.stopChan:
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+		}
+	}()
+}
+
+// Stop stops shipping, abandoning an in-flight send; its lines are sent
+// again on the next start.
+func (s *Shipper) Stop() {
+	if s.stopChan == nil {
+		return
+	}
+	close(s.stopChan)
+	s.cancel()
+	<-s.done
+}
+
+// RunOnce sends every log line added since the cursor, one batch at a time,
+// and returns how many were accepted. It stops at the first batch that
+// isn't.
+func (s *Shipper) RunOnce(ctx context.Context) (int, error) {
+	cursor := s.loadCursor()
+	shipped := 0
+	for {
+		logs, err := s.state.ScanServiceLogs("", state.LogFilter{}, cursor.LastLogID, s.batchSize)
+		if err != nil {
+			return shipped, err
+		}
+		if len(logs) == 0 {
+			return shipped, nil
+		}
+
+		batch := make([]api.LogEntry, 0, len(logs))
+		size := 0
+		for _, entry := range logs {
+			size += len(entry.Message)
+			if len(batch) > 0 && size > maxShipBatchBytes {
+				break
+			}
+			batch = append(batch, api.LogEntry{
+				ID:          entry.ID,
+				ServiceID:   entry.ServiceID,
+				DeployID:    entry.DeployID,
+				ContainerID: entry.ContainerID,
+				Level:       entry.Level,
+				Message:     entry.Message,
+				CreatedAt:   entry.CreatedAt,
+			})
+		}
+		if err := s.sender.SendLogs(ctx, batch); err != nil {
+			return shipped, err
+		}
+
+		shipped += len(batch)
+		cursor.LastLogID = batch[len(batch)-1].ID
+		cursor.LastShippedAt = time.Now().UTC()
+		if err := s.saveCursor(cursor); err != nil {
+			return shipped, err
+		}
+		if len(batch) == len(logs) && len(logs) < s.batchSize {
+			return shipped, nil
+		}
+	}
+}
+
+func (s *Shipper) loadCursor() ShipCursor {
+	var cursor ShipCursor
+	data, err := os.ReadFile(s.cursorPath)
+	if err != nil {
+		return cursor
+	}
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		log.Printf("[LogShip] Ignoring unreadable cursor file %s: %v", s.cursorPath, err)
+	}
+	return cursor
+}
+
+// saveCursor writes the cursor through a temporary file so a crash never
+// leaves a truncated one behind.
+func (s *Shipper) saveCursor(cursor ShipCursor) error {
+	data, err := json.MarshalIndent(cursor, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.cursorPath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to save log ship cursor: %w", err)
+	}
+	if err := os.Rename(tmp, s.cursorPath); err != nil {
+		return fmt.Errorf("failed to save log ship cursor: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package logexport
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/buildvigil/agent/internal/api"
+)
+
+type fakeSender struct {
+	batches [][]api.LogEntry
+	err     error
+}
+
+func (f *fakeSender) SendLogs(ctx context.Context, entries []api.LogEntry) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.batches = append(f.batches, entries)
+	return nil
+}
+
+func TestShipper_BatchesAndResumes(t *testing.T) {
+	t.Logf("Testing log shipping batches and cursor...")
+	stateMgr := newTestState(t)
+	cursorPath := filepath.Join(t.TempDir(), "log_ship.json")
+	for i := 0; i < 5; i++ {
+		stateMgr.LogServiceMessage("svc", "info", "line")
+	}
+
+	sender := &fakeSender{}
+	shipped, err := NewShipper(stateMgr, sender, cursorPath, 0, 2).RunOnce(context.Background())
+	if err != nil {
+		t.Fatalf("RunOnce failed: %v", err)
+	}
+	if shipped != 5 || len(sender.batches) != 3 {
+		t.Fatalf("Expected 5 lines in 3 batches, got %d lines in %d batches", shipped, len(sender.batches))
+	}
+	if last := sender.batches[2]; len(last) != 1 || last[0].ID != 5 || last[0].ServiceID != "svc" {
+		t.Errorf("Unexpected last batch: %+v", last)
+	}
+
+	// A new shipper, as after a restart, only sends lines added since.
+	stateMgr.LogServiceMessage("svc", "error", "after restart")
+	sender = &fakeSender{}
+	shipped, err = NewShipper(stateMgr, sender, cursorPath, 0, 2).RunOnce(context.Background())
+	if err != nil {
+		t.Fatalf("RunOnce failed: %v", err)
+	}
+	if shipped != 1 || sender.batches[0][0].Message != "after restart" {
+		t.Errorf("Expected only the new line, got %d lines: %+v", shipped, sender.batches)
+	}
+	t.Logf("✓ Logs shipped in batches and resumed from the cursor")
+}
+
+func TestShipper_FailedSendKeepsCursor(t *testing.T) {
+	t.Logf("Testing a rejected batch is sent again...")
+	stateMgr := newTestState(t)
+	cursorPath := filepath.Join(t.TempDir(), "log_ship.json")
+	stateMgr.LogServiceMessage("svc", "info", "line")
+
+	throttled := &api.ThrottledError{StatusCode: 429}
+	sender := &fakeSender{err: throttled}
+	shipper := NewShipper(stateMgr, sender, cursorPath, 0, 10)
+	if _, err := shipper.RunOnce(context.Background()); !errors.As(err, &throttled) {
+		t.Fatalf("Expected throttled error, got %v", err)
+	}
+
+	sender.err = nil
+	shipped, err := shipper.RunOnce(context.Background())
+	if err != nil || shipped != 1 {
+		t.Errorf("Expected the line to be sent on retry, got %d lines (err=%v)", shipped, err)
+	}
+	t.Logf("✓ Cursor only advances once a batch is accepted")
+}
@@ -0,0 +1,169 @@
+package logging
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is a log verbosity level.
+type Level int
+
+const (
+	LevelInfo Level = iota
+	LevelDebug
+)
+
+// String returns the level name.
+func (l Level) String() string {
+	if l == LevelDebug {
+		return "debug"
+	}
+	return "info"
+}
+
+// ParseLevel parses a level name. "verbose" is accepted as an alias for debug.
+func ParseLevel(name string) (Level, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "info", "":
+		return LevelInfo, nil
+	case "debug", "verbose":
+		return LevelDebug, nil
+	default:
+		return LevelInfo, fmt.Errorf("unknown log level %q", name)
+	}
+}
+
+// levelState holds the configured base level and any remote override.
+// Module names are the log prefixes used across the agent, e.g. "agent",
+// "service", "proxy".
+type levelState struct {
+	mu sync.RWMutex
+
+	base Level
+
+	overridden bool
+	level      Level
+	modules    map[string]Level
+	until      time.Time
+}
+
+var levels = &levelState{}
+
+// SetBaseVerbose sets the level from local configuration (verbose_logging).
+func SetBaseVerbose(verbose bool) {
+	levels.mu.Lock()
+	defer levels.mu.Unlock()
+	levels.base = LevelInfo
+	if verbose {
+		levels.base = LevelDebug
+	}
+}
+
+// SetRemoteLevels installs a remote override of the global level and
+// per-module levels. An empty level with no modules clears the override. A
+// non-zero until makes the override expire automatically. It reports whether
+// the effective configuration changed.
+func SetRemoteLevels(level string, modules map[string]string, until time.Time) (bool, error) {
+	if strings.TrimSpace(level) == "" && len(modules) == 0 {
+		return ClearRemoteLevels(), nil
+	}
+
+	global, err := ParseLevel(level)
+	if err != nil {
+		return false, err
+	}
+	parsed := make(map[string]Level, len(modules))
+	for module, name := range modules {
+		moduleLevel, err := ParseLevel(name)
+		if err != nil {
+			return false, fmt.Errorf("module %s: %w", module, err)
+		}
+		parsed[strings.ToLower(module)] = moduleLevel
+	}
+
+	levels.mu.Lock()
+	defer levels.mu.Unlock()
+	changed := !levels.overridden || levels.level != global || !levels.until.Equal(until) || !sameModules(levels.modules, parsed)
+	levels.overridden = true
+	levels.level = global
+	levels.modules = parsed
+	levels.until = until
+	return changed, nil
+}
+
+// ClearRemoteLevels removes any remote override and reports whether one was set.
+func ClearRemoteLevels() bool {
+	levels.mu.Lock()
+	defer levels.mu.Unlock()
+	was := levels.overridden
+	levels.overridden = false
+	levels.modules = nil
+	levels.until = time.Time{}
+	return was
+}
+
+// DebugEnabled reports whether debug output is enabled for a module.
+func DebugEnabled(module string) bool {
+	return LevelFor(module) >= LevelDebug
+}
+
+// LevelFor returns the effective level for a module.
+func LevelFor(module string) Level {
+	levels.mu.RLock()
+	defer levels.mu.RUnlock()
+
+	if !levels.overridden || (!levels.until.IsZero() && time.Now().After(levels.until)) {
+		return levels.base
+	}
+	if moduleLevel, ok := levels.modules[strings.ToLower(module)]; ok {
+		return moduleLevel
+	}
+	return levels.level
+}
+
+// Snapshot describes the effective levels for reporting in heartbeats.
+func Snapshot() map[string]interface{} {
+	levels.mu.RLock()
+	defer levels.mu.RUnlock()
+
+	active := levels.overridden && (levels.until.IsZero() || time.Now().Before(levels.until))
+	out := map[string]interface{}{
+		"level":  levels.base.String(),
+		"remote": active,
+	}
+	if !active {
+		return out
+	}
+	out["level"] = levels.level.String()
+	if len(levels.modules) > 0 {
+		names := make([]string, 0, len(levels.modules))
+		for module := range levels.modules {
+			names = append(names, module)
+		}
+		sort.Strings(names)
+		modules := make(map[string]string, len(names))
+		for _, module := range names {
+			modules[module] = levels.modules[module].String()
+		}
+		out["modules"] = modules
+	}
+	if !levels.until.IsZero() {
+		out["until"] = levels.until.UTC().Format(time.RFC3339)
+	}
+	return out
+}
+
+func sameModules(a, b map[string]Level) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for module, level := range a {
+		if other, ok := b[module]; !ok || other != level {
+			return false
+		}
+	}
+	return true
+}
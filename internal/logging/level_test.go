@@ -0,0 +1,44 @@
+package logging
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRemoteLevels(t *testing.T) {
+	t.Logf("Testing remote log level overrides...")
+	defer ClearRemoteLevels()
+
+	SetBaseVerbose(false)
+	if DebugEnabled("service") {
+		t.Fatal("Expected debug disabled by default")
+	}
+
+	changed, err := SetRemoteLevels("info", map[string]string{"service": "debug"}, time.Time{})
+	if err != nil || !changed {
+		t.Fatalf("Expected override to apply, changed=%v err=%v", changed, err)
+	}
+	if !DebugEnabled("service") || DebugEnabled("proxy") {
+		t.Error("Expected debug only for the service module")
+	}
+	if changed, _ := SetRemoteLevels("info", map[string]string{"service": "debug"}, time.Time{}); changed {
+		t.Error("Expected identical override to report no change")
+	}
+
+	if _, err := SetRemoteLevels("debug", nil, time.Now().Add(-time.Minute)); err != nil {
+		t.Fatalf("Failed to set expired override: %v", err)
+	}
+	if DebugEnabled("agent") {
+		t.Error("Expected expired override to fall back to base level")
+	}
+
+	if _, err := SetRemoteLevels("trace", nil, time.Time{}); err == nil {
+		t.Error("Expected unknown level to be rejected")
+	}
+
+	SetRemoteLevels("", nil, time.Time{})
+	if Snapshot()["remote"] != false {
+		t.Error("Expected empty remote level to clear the override")
+	}
+	t.Logf("✓ Remote log levels applied, expired, and cleared")
+}
@@ -0,0 +1,229 @@
+// Package logging provides file output for the agent's own log with size and
+// time based rotation, gzip compression of rotated files, and retention.
+package logging
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const rotatedTimeFormat = "20060102T150405Z"
+
+// RotatingWriter is an io.Writer that appends to a log file and rotates it
+// once it exceeds maxSize bytes or has been open for longer than maxAge.
+type RotatingWriter struct {
+	path    string
+	maxSize int64
+	maxAge  time.Duration
+	retain  int
+
+	mu       sync.Mutex
+	file     *os.File
+	size     int64
+	openedAt time.Time
+	closed   bool
+
+	// rotated files are compressed and pruned in order by a single worker
+	rotated chan string
+	done    chan struct{}
+
+	now func() time.Time
+}
+
+// NewRotatingWriter opens (or creates) the log file at path. A zero maxSize or
+// maxAge disables that rotation trigger; retain is the number of rotated files
+// kept alongside the active file.
+func NewRotatingWriter(path string, maxSize int64, maxAge time.Duration, retain int) (*RotatingWriter, error) {
+	w := &RotatingWriter{
+		path:    path,
+		maxSize: maxSize,
+		maxAge:  maxAge,
+		retain:  retain,
+		rotated: make(chan string, 16),
+		done:    make(chan struct{}),
+		now:     time.Now,
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return nil, fmt.Errorf("failed to create log directory: %w", err)
+	}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	go w.compressLoop()
+	return w, nil
+}
+
+func (w *RotatingWriter) open() error {
+	file, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat log file: %w", err)
+	}
+	w.file = file
+	w.size = info.Size()
+	w.openedAt = w.now()
+	return nil
+}
+
+// Write appends p to the active file, rotating first if needed.
+func (w *RotatingWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return 0, os.ErrClosed
+	}
+	if w.shouldRotate(int64(len(p))) {
+		if err := w.rotate(); err != nil {
+			// Keep logging to the current file rather than dropping output.
+			fmt.Fprintf(os.Stderr, "log rotation failed: %v\n", err)
+		}
+	}
+
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+func (w *RotatingWriter) shouldRotate(incoming int64) bool {
+	if w.size == 0 {
+		return false
+	}
+	if w.maxSize > 0 && w.size+incoming > w.maxSize {
+		return true
+	}
+	return w.maxAge > 0 && w.now().Sub(w.openedAt) >= w.maxAge
+}
+
+// rotate renames the active file with a timestamp suffix, reopens a fresh
+// file, and compresses and prunes rotated files in the background.
+func (w *RotatingWriter) rotate() error {
+	if err := w.file.Close(); err != nil {
+		return fmt.Errorf("failed to close log file: %w", err)
+	}
+
+	rotated := rotatedName(w.path, w.now().UTC())
+	if err := os.Rename(w.path, rotated); err != nil {
+		if openErr := w.open(); openErr != nil {
+			return openErr
+		}
+		return fmt.Errorf("failed to rename log file: %w", err)
+	}
+	if err := w.open(); err != nil {
+		return err
+	}
+
+	w.rotated <- rotated
+	return nil
+}
+
+func (w *RotatingWriter) compressLoop() {
+	defer close(w.done)
+	for path := range w.rotated {
+		if err := compressFile(path); err != nil {
+			fmt.Fprintf(os.Stderr, "log compression failed: %v\n", err)
+		}
+		pruneRotated(w.path, w.retain)
+	}
+}
+
+// Close closes the active file and waits for pending compression to finish.
+func (w *RotatingWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	err := w.file.Close()
+	close(w.rotated)
+	<-w.done
+	return err
+}
+
+// Path returns the active log file path.
+func (w *RotatingWriter) Path() string {
+	return w.path
+}
+
+// rotatedName returns agent-<timestamp>.log for agent.log.
+func rotatedName(path string, at time.Time) string {
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+	return fmt.Sprintf("%s-%s%s", base, at.Format(rotatedTimeFormat), ext)
+}
+
+// RotatedFiles returns rotated log files for path, oldest first.
+func RotatedFiles(path string) []string {
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+	matches, _ := filepath.Glob(base + "-????????T??????Z" + ext + "*")
+	// Timestamped names sort chronologically.
+	sort.Strings(matches)
+	return matches
+}
+
+func pruneRotated(path string, retain int) {
+	if retain <= 0 {
+		return
+	}
+	files := RotatedFiles(path)
+	if len(files) <= retain {
+		return
+	}
+	for _, file := range files[:len(files)-retain] {
+		_ = os.Remove(file)
+	}
+}
+
+func compressFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0640)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(dst)
+	if _, err := io.Copy(gz, src); err != nil {
+		gz.Close()
+		dst.Close()
+		os.Remove(path + ".gz")
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		dst.Close()
+		os.Remove(path + ".gz")
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(path + ".gz")
+		return err
+	}
+	return os.Remove(path)
+}
+
+// Setup mirrors the standard logger to a rotating file in addition to stderr.
+func Setup(path string, maxSize int64, maxAge time.Duration, retain int) (*RotatingWriter, error) {
+	w, err := NewRotatingWriter(path, maxSize, maxAge, retain)
+	if err != nil {
+		return nil, err
+	}
+	log.SetOutput(io.MultiWriter(os.Stderr, w))
+	return w, nil
+}
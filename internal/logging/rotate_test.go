@@ -0,0 +1,75 @@
+package logging
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRotatingWriter_RotatesBySize(t *testing.T) {
+	t.Logf("Testing size-based log rotation...")
+
+	path := filepath.Join(t.TempDir(), "agent.log")
+	w, err := NewRotatingWriter(path, 32, 0, 2)
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	clock := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	w.now = func() time.Time {
+		clock = clock.Add(time.Second)
+		return clock
+	}
+
+	for i := 0; i < 5; i++ {
+		if _, err := w.Write([]byte(strings.Repeat("x", 20) + "\n")); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	rotated := RotatedFiles(path)
+	if len(rotated) != 2 {
+		t.Fatalf("Expected 2 retained rotated files, got %d: %v", len(rotated), rotated)
+	}
+	for _, file := range rotated {
+		if !strings.HasSuffix(file, ".log.gz") {
+			t.Errorf("Expected rotated file to be compressed: %s", file)
+		}
+	}
+	info, err := os.Stat(path)
+	if err != nil || info.Size() != 21 {
+		t.Errorf("Expected active file with one line, got size=%v err=%v", info.Size(), err)
+	}
+	t.Logf("✓ Log rotated, compressed, and pruned")
+}
+
+func TestRotatingWriter_RotatesByAge(t *testing.T) {
+	t.Logf("Testing time-based log rotation...")
+
+	path := filepath.Join(t.TempDir(), "agent.log")
+	clock := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	w, err := NewRotatingWriter(path, 0, time.Hour, 5)
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	w.now = func() time.Time { return clock }
+	w.openedAt = clock
+
+	w.Write([]byte("first\n"))
+	clock = clock.Add(2 * time.Hour)
+	w.Write([]byte("second\n"))
+	w.Close()
+
+	if len(RotatedFiles(path)) != 1 {
+		t.Fatalf("Expected 1 rotated file, got %v", RotatedFiles(path))
+	}
+	lines, err := TailLines(path, 10)
+	if err != nil || len(lines) != 1 || lines[0] != "second" {
+		t.Errorf("Expected active file to contain only 'second', got %v (err=%v)", lines, err)
+	}
+	t.Logf("✓ Log rotated after max age")
+}
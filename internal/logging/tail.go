@@ -0,0 +1,66 @@
+package logging
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// TailLines returns up to n trailing lines of the file at path.
+func TailLines(path string, n int) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+		if len(lines) > n {
+			lines = lines[1:]
+		}
+	}
+	return lines, scanner.Err()
+}
+
+// Follow writes new data appended to path to out until the process exits,
+// reopening the file when it is rotated.
+func Follow(path string, out io.Writer) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() { file.Close() }()
+
+	offset, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return fmt.Errorf("failed to seek log file: %w", err)
+	}
+
+	for {
+		n, err := io.Copy(out, file)
+		if err != nil {
+			return fmt.Errorf("failed to read log file: %w", err)
+		}
+		offset += n
+
+		info, statErr := os.Stat(path)
+		current, fileStatErr := file.Stat()
+		if statErr == nil && fileStatErr == nil && (!os.SameFile(info, current) || info.Size() < offset) {
+			reopened, err := os.Open(path)
+			if err == nil {
+				file.Close()
+				file = reopened
+				offset = 0
+				continue
+			}
+		}
+
+		time.Sleep(1 * time.Second)
+	}
+}
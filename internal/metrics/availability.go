@@ -0,0 +1,105 @@
+package metrics
+
+import (
+	"log"
+	"time"
+
+	"github.com/buildvigil/agent/internal/state"
+)
+
+// Uptime windows reported in heartbeats and by -uptime.
+var UptimeWindows = []time.Duration{24 * time.Hour, 7 * 24 * time.Hour, 30 * 24 * time.Hour}
+
+// availabilityRetention keeps enough history for the longest uptime window.
+const availabilityRetention = 31 * 24 * time.Hour
+
+// AvailabilityTracker probes running services on an interval and records the
+// results as availability intervals in state.
+type AvailabilityTracker struct {
+	state    *state.Manager
+	targets  func() []Target
+	probe    func(serviceID string) (bool, error)
+	interval time.Duration
+	now      func() time.Time
+
+	lastPrune time.Time
+	stopChan  chan struct{}
+	done      chan struct{}
+}
+
+// NewAvailabilityTracker creates a tracker. probe reports whether a service is
+// currently healthy.
+func NewAvailabilityTracker(stateMgr *state.Manager, targets func() []Target, probe func(serviceID string) (bool, error), interval time.Duration) *AvailabilityTracker {
+	return &AvailabilityTracker{
+		state:    stateMgr,
+		targets:  targets,
+		probe:    probe,
+		interval: interval,
+		now:      time.Now,
+	}
+}
+
+// Start probes every interval until Stop.
+func (t *AvailabilityTracker) Start() {
+	t.stopChan = make(chan struct{})
+	t.done = make(chan struct{})
+	go func() {
+		defer close(t.done)
+		ticker := time.NewTicker(t.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-t.stopChan:
+				return
+			case <-ticker.C:
+				t.ProbeAll()
+			}
+		}
+	}()
+}
+
+// Stop stops probing and waits for an in-flight round to finish.
+func (t *AvailabilityTracker) Stop() {
+	if t.stopChan == nil {
+		return
+	}
+	close(t.stopChan)
+	<-t.done
+}
+
+// ProbeAll probes every target once and records the results.
+func (t *AvailabilityTracker) ProbeAll() {
+	// Allow one missed probe before treating the gap as unobserved.
+	maxGap := 2*t.interval + t.interval/2
+	for _, target := range t.targets() {
+		healthy, err := t.probe(target.ServiceID)
+		if err != nil {
+			// Untracked or mid-deploy; leave the gap unobserved.
+			continue
+		}
+		if err := t.state.RecordAvailability(target.ServiceID, healthy, t.now(), maxGap); err != nil {
+			log.Printf("[Metrics] Failed to record availability: service=%s err=%v", target.ServiceID, err)
+		}
+	}
+
+	now := t.now()
+	if now.Sub(t.lastPrune) >= time.Hour {
+		if err := t.state.PruneAvailability(now.Add(-availabilityRetention)); err != nil {
+			log.Printf("[Metrics] Failed to prune availability: %v", err)
+		}
+		t.lastPrune = now
+	}
+}
+
+// ServiceUptimes returns uptime percentages for each of UptimeWindows.
+func ServiceUptimes(stateMgr *state.Manager, serviceID string, now time.Time) ([]state.Uptime, error) {
+	uptimes := make([]state.Uptime, 0, len(UptimeWindows))
+	for _, window := range UptimeWindows {
+		uptime, err := stateMgr.ServiceUptime(serviceID, window, now)
+		if err != nil {
+			return nil, err
+		}
+		uptimes = append(uptimes, uptime)
+	}
+	return uptimes, nil
+}
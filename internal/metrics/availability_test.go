@@ -0,0 +1,54 @@
+package metrics
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/buildvigil/agent/internal/state"
+)
+
+func TestAvailabilityTracker_ProbeAll(t *testing.T) {
+	t.Logf("Testing availability probing...")
+
+	stateMgr, err := state.NewManager(filepath.Join(t.TempDir(), "state.db"))
+	if err != nil {
+		t.Fatalf("Failed to create state manager: %v", err)
+	}
+	defer stateMgr.Close()
+
+	healthy := true
+	tracker := NewAvailabilityTracker(stateMgr,
+		func() []Target { return []Target{{ServiceID: "svc"}, {ServiceID: "gone"}} },
+		func(serviceID string) (bool, error) {
+			if serviceID == "gone" {
+				return false, errors.New("not tracked")
+			}
+			return healthy, nil
+		},
+		30*time.Second)
+	clock := time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)
+	tracker.now = func() time.Time { return clock }
+
+	for i := 0; i < 4; i++ {
+		tracker.ProbeAll()
+		clock = clock.Add(30 * time.Second)
+	}
+	healthy = false
+	tracker.ProbeAll()
+
+	uptimes, err := ServiceUptimes(stateMgr, "svc", clock)
+	if err != nil {
+		t.Fatalf("Failed to compute uptimes: %v", err)
+	}
+	if len(uptimes) != 3 || uptimes[0].Percent != 75 {
+		t.Errorf("Expected 75%% over 24h (90s up, 30s down), got %+v", uptimes)
+	}
+
+	ids, _ := stateMgr.ListAvailabilityServices()
+	if len(ids) != 1 || ids[0] != "svc" {
+		t.Errorf("Expected probe errors to be skipped, got %v", ids)
+	}
+	t.Logf("✓ Probe results recorded as availability")
+}
@@ -0,0 +1,210 @@
+// Package metrics samples per-service resource usage, request rates and
+// availability into the state database, where they are downsampled and pruned
+// over time, and reads host resource usage for heartbeats.
+package metrics
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/buildvigil/agent/internal/container"
+	"github.com/buildvigil/agent/internal/state"
+)
+
+// downsampleInterval is how often completed hours are rolled up and old
+// samples pruned.
+const downsampleInterval = 10 * time.Minute
+
+// Target is a running service to sample.
+type Target struct {
+	ServiceID     string
+	ContainerName string
+	Hostname      string
+}
+
+// ContainerStats is a point-in-time resource reading for a container.
+type ContainerStats struct {
+	CPUPercent  float64
+	MemoryBytes int64
+}
+
+var dockerStats = defaultDockerStats
+
+// Collector samples metrics on an interval.
+type Collector struct {
+	state           *state.Manager
+	targets         func() []Target
+	requestCounts   func() map[string]uint64
+	interval        time.Duration
+	rawRetention    time.Duration
+	hourlyRetention time.Duration
+
+	lastCounts     map[string]uint64
+	lastSampleAt   time.Time
+	lastDownsample time.Time
+	now            func() time.Time
+
+	stopChan chan struct{}
+	done     chan struct{}
+}
+
+// NewCollector creates a collector. targets lists the services to sample and
+// requestCounts returns cumulative proxied requests per hostname; either may
+// be nil.
+func NewCollector(stateMgr *state.Manager, targets func() []Target, requestCounts func() map[string]uint64, interval, rawRetention, hourlyRetention time.Duration) *Collector {
+	return &Collector{
+		state:           stateMgr,
+		targets:         targets,
+		requestCounts:   requestCounts,
+		interval:        interval,
+		rawRetention:    rawRetention,
+		hourlyRetention: hourlyRetention,
+		now:             time.Now,
+	}
+}
+
+// Start samples every interval until Stop.
+func (c *Collector) Start() {
+	c.stopChan = make(chan struct{})
+	c.done = make(chan struct{})
+	go func() {
+		defer close(c.done)
+		ticker := time.NewTicker(c.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-c.stopChan:
+				return
+			case <-ticker.C:
+				if err := c.Collect(); err != nil {
+					log.Printf("[Metrics] Collection failed: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// Stop stops sampling and waits for an in-flight collection to finish.
+func (c *Collector) Stop() {
+	if c.stopChan == nil {
+		return
+	}
+	close(c.stopChan)
+	<-c.done
+}
+
+// Collect takes one sample for every target and periodically downsamples.
+func (c *Collector) Collect() error {
+	now := c.now()
+	var targets []Target
+	if c.targets != nil {
+		targets = c.targets()
+	}
+
+	rates := c.requestRates(now)
+	if len(targets) > 0 {
+		names := make([]string, 0, len(targets))
+		for _, target := range targets {
+			names = append(names, target.ContainerName)
+		}
+		stats, err := dockerStats(names)
+		if err != nil {
+			return err
+		}
+		for _, target := range targets {
+			usage := stats[target.ContainerName]
+			sample := state.MetricSample{
+				ServiceID:   target.ServiceID,
+				BucketStart: now,
+				CPUPercent:  usage.CPUPercent,
+				MemoryBytes: usage.MemoryBytes,
+				RequestRate: rates[target.Hostname],
+			}
+			if err := c.state.RecordMetricSample(sample); err != nil {
+				return err
+			}
+		}
+	}
+
+	if now.Sub(c.lastDownsample) >= downsampleInterval {
+		if err := c.state.DownsampleMetrics(now, c.rawRetention, c.hourlyRetention); err != nil {
+			return err
+		}
+		c.lastDownsample = now
+	}
+	return nil
+}
+
+// requestRates converts cumulative request counters into requests per second
+// since the previous sample. The first sample has no baseline and reports 0.
+func (c *Collector) requestRates(now time.Time) map[string]float64 {
+	rates := map[string]float64{}
+	if c.requestCounts == nil {
+		return rates
+	}
+	counts := c.requestCounts()
+	elapsed := now.Sub(c.lastSampleAt).Seconds()
+	if c.lastCounts != nil && elapsed > 0 {
+		for host, count := range counts {
+			if previous, ok := c.lastCounts[host]; ok && count >= previous {
+				rates[host] = float64(count-previous) / elapsed
+			}
+		}
+	}
+	c.lastCounts = counts
+	c.lastSampleAt = now
+	return rates
+}
+
+func defaultDockerStats(names []string) (map[string]ContainerStats, error) {
+	args := append([]string{"stats", "--no-stream", "--format", "{{.Name}}\t{{.CPUPerc}}\t{{.MemUsage}}"}, names...)
+	output, err := container.DockerOutput(container.DockerInspect, args...)
+	if err != nil {
+		// docker stats fails outright if any container is missing, which is
+		// routine mid-deploy; report what we can on the next tick.
+		return nil, fmt.Errorf("docker stats failed: %w", err)
+	}
+	return parseDockerStats(string(output)), nil
+}
+
+// parseDockerStats parses "name\tcpu%\tused / limit" lines.
+func parseDockerStats(output string) map[string]ContainerStats {
+	stats := map[string]ContainerStats{}
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Split(strings.TrimSpace(line), "\t")
+		if len(fields) != 3 {
+			continue
+		}
+		var usage ContainerStats
+		usage.CPUPercent, _ = strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(fields[1]), "%"), 64)
+		used, _, _ := strings.Cut(fields[2], "/")
+		usage.MemoryBytes = parseSize(used)
+		stats[fields[0]] = usage
+	}
+	return stats
+}
+
+// parseSize parses docker's human-readable sizes such as "12.5MiB" or "1.2GB".
+func parseSize(value string) int64 {
+	value = strings.TrimSpace(value)
+	units := []struct {
+		suffix     string
+		multiplier float64
+	}{
+		{"TiB", 1 << 40}, {"GiB", 1 << 30}, {"MiB", 1 << 20}, {"KiB", 1 << 10},
+		{"TB", 1e12}, {"GB", 1e9}, {"MB", 1e6}, {"kB", 1e3}, {"B", 1},
+	}
+	for _, unit := range units {
+		if number, ok := strings.CutSuffix(value, unit.suffix); ok {
+			n, err := strconv.ParseFloat(strings.TrimSpace(number), 64)
+			if err != nil {
+				return 0
+			}
+			return int64(n * unit.multiplier)
+		}
+	}
+	return 0
+}
@@ -0,0 +1,72 @@
+package metrics
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/buildvigil/agent/internal/state"
+)
+
+func TestParseDockerStats(t *testing.T) {
+	t.Logf("Testing docker stats parsing...")
+
+	stats := parseDockerStats("potato-cloud-a\t12.50%\t64MiB / 1.944GiB\npotato-cloud-b\t0.00%\t1.5GB / 4GB\ngarbage\n")
+	if got := stats["potato-cloud-a"]; got.CPUPercent != 12.5 || got.MemoryBytes != 64<<20 {
+		t.Errorf("Unexpected stats for a: %+v", got)
+	}
+	if got := stats["potato-cloud-b"]; got.MemoryBytes != 1500000000 {
+		t.Errorf("Unexpected stats for b: %+v", got)
+	}
+	if len(stats) != 2 {
+		t.Errorf("Expected malformed lines skipped, got %v", stats)
+	}
+	t.Logf("✓ CPU and memory parsed")
+}
+
+func TestCollector_RecordsRequestRate(t *testing.T) {
+	t.Logf("Testing metric collection...")
+
+	stateMgr, err := state.NewManager(filepath.Join(t.TempDir(), "state.db"))
+	if err != nil {
+		t.Fatalf("Failed to create state manager: %v", err)
+	}
+	defer stateMgr.Close()
+
+	original := dockerStats
+	dockerStats = func(names []string) (map[string]ContainerStats, error) {
+		return map[string]ContainerStats{"potato-cloud-svc": {CPUPercent: 5, MemoryBytes: 1024}}, nil
+	}
+	defer func() { dockerStats = original }()
+
+	counts := map[string]uint64{"app.example.com": 100}
+	collector := NewCollector(stateMgr,
+		func() []Target {
+			return []Target{{ServiceID: "svc", ContainerName: "potato-cloud-svc", Hostname: "app.example.com"}}
+		},
+		func() map[string]uint64 { return counts },
+		time.Minute, 24*time.Hour, 30*24*time.Hour)
+	clock := time.Date(2026, 6, 1, 10, 0, 0, 0, time.UTC)
+	collector.now = func() time.Time { return clock }
+
+	if err := collector.Collect(); err != nil {
+		t.Fatalf("Collect failed: %v", err)
+	}
+	clock = clock.Add(time.Minute)
+	counts = map[string]uint64{"app.example.com": 220}
+	if err := collector.Collect(); err != nil {
+		t.Fatalf("Collect failed: %v", err)
+	}
+
+	samples, err := stateMgr.ListMetricSamples("svc", state.MetricResolutionRaw, time.Time{})
+	if err != nil {
+		t.Fatalf("Failed to list samples: %v", err)
+	}
+	if len(samples) != 2 {
+		t.Fatalf("Expected 2 samples, got %+v", samples)
+	}
+	if samples[0].RequestRate != 0 || samples[1].RequestRate != 2 || samples[1].MemoryBytes != 1024 {
+		t.Errorf("Expected rate 0 then 2 req/s, got %+v", samples)
+	}
+	t.Logf("✓ Samples recorded with request rate")
+}
@@ -0,0 +1,174 @@
+package metrics
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/buildvigil/agent/internal/api"
+	"github.com/buildvigil/agent/internal/platform"
+)
+
+// dockerPingTimeout bounds the daemon check so a hung daemon can't stall a
+// heartbeat.
+const dockerPingTimeout = 5 * time.Second
+
+var (
+	readLoadAvg = defaultReadLoadAvg
+	readMemInfo = defaultReadMemInfo
+	diskUsage   = platform.DiskUsage
+	dockerPing  = defaultDockerPing
+)
+
+// SystemCollector reads host and container resource usage on demand, for
+// the control plane to alert on resource exhaustion.
+type SystemCollector struct {
+	dataDir string
+	targets func() []Target
+}
+
+// NewSystemCollector creates a collector that reports disk usage of the
+// filesystem holding dataDir and container usage of the services targets
+// returns; targets may be nil.
+func NewSystemCollector(dataDir string, targets func() []Target) *SystemCollector {
+	return &SystemCollector{dataDir: dataDir, targets: targets}
+}
+
+// Snapshot reads current usage. Readings that fail are left at zero.
+func (c *SystemCollector) Snapshot() api.SystemMetrics {
+	snapshot := api.SystemMetrics{CPUCount: runtime.NumCPU()}
+	if load, err := readLoadAvg(); err == nil {
+		snapshot.Load1, snapshot.Load5, snapshot.Load15 = load[0], load[1], load[2]
+	}
+	if total, available, err := readMemInfo(); err == nil {
+		snapshot.MemoryTotalBytes, snapshot.MemoryAvailableBytes = total, available
+	}
+	if used, total, err := diskUsage(c.dataDir); err == nil {
+		snapshot.DiskUsedBytes, snapshot.DiskTotalBytes = used, total
+	}
+
+	if err := dockerPing(); err != nil {
+		snapshot.DockerError = err.Error()
+		return snapshot
+	}
+	snapshot.DockerAvailable = true
+
+	var targets []Target
+	if c.targets != nil {
+		targets = c.targets()
+	}
+	if len(targets) == 0 {
+		return snapshot
+	}
+	names := make([]string, 0, len(targets))
+	for _, target := range targets {
+		names = append(names, target.ContainerName)
+	}
+	stats, err := dockerStats(names)
+	if err != nil {
+		return snapshot
+	}
+	for _, target := range targets {
+		usage, ok := stats[target.ContainerName]
+		if !ok {
+			continue
+		}
+		snapshot.Services = append(snapshot.Services, api.ServiceResourceUsage{
+			ServiceID:   target.ServiceID,
+			CPUPercent:  usage.CPUPercent,
+			MemoryBytes: usage.MemoryBytes,
+		})
+	}
+	return snapshot
+}
+
+func defaultReadLoadAvg() ([3]float64, error) {
+	var load [3]float64
+	data, err := os.ReadFile("/proc/loadavg")
+	if err != nil {
+		return load, err
+	}
+	return parseLoadAvg(string(data))
+}
+
+// parseLoadAvg parses the first three fields of /proc/loadavg.
+func parseLoadAvg(data string) ([3]float64, error) {
+	var load [3]float64
+	fields := strings.Fields(data)
+	if len(fields) < 3 {
+		return load, fmt.Errorf("unexpected loadavg format: %q", data)
+	}
+	for i := range load {
+		value, err := strconv.ParseFloat(fields[i], 64)
+		if err != nil {
+			return load, fmt.Errorf("unexpected loadavg format: %q", data)
+		}
+		load[i] = value
+	}
+	return load, nil
+}
+
+func defaultReadMemInfo() (total, available uint64, err error) {
+	file, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+	return parseMemInfo(bufio.NewScanner(file))
+}
+
+// parseMemInfo reads MemTotal and MemAvailable, which /proc/meminfo gives
+// in kB.
+func parseMemInfo(scanner *bufio.Scanner) (total, available uint64, err error) {
+	for scanner.Scan() {
+		key, rest, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(rest)
+		if len(fields) == 0 {
+			continue
+		}
+		value, err := strconv.ParseUint(fields[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		switch key {
+		case "MemTotal":
+			total = value * 1024
+		case "MemAvailable":
+			available = value * 1024
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, 0, err
+	}
+	if total == 0 {
+		return 0, 0, fmt.Errorf("MemTotal missing from meminfo")
+	}
+	return total, available, nil
+}
+
+// DockerAvailable reports whether the Docker daemon answers.
+func DockerAvailable() bool {
+	return dockerPing() == nil
+}
+
+func defaultDockerPing() error {
+	ctx, cancel := context.WithTimeout(context.Background(), dockerPingTimeout)
+	defer cancel()
+	output, err := exec.CommandContext(ctx, "docker", "info", "--format", "{{.ServerVersion}}").CombinedOutput()
+	if ctx.Err() != nil {
+		return fmt.Errorf("docker daemon did not answer within %s", dockerPingTimeout)
+	}
+	if err != nil {
+		return fmt.Errorf("docker daemon unavailable: %s", strings.TrimSpace(string(output)))
+	}
+	return nil
+}
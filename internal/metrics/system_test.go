@@ -0,0 +1,61 @@
+package metrics
+
+import (
+	"bufio"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseLoadAvgAndMemInfo(t *testing.T) {
+	t.Logf("Testing /proc parsing...")
+
+	load, err := parseLoadAvg("0.52 1.04 2.50 3/812 12345\n")
+	if err != nil || load != [3]float64{0.52, 1.04, 2.5} {
+		t.Errorf("Unexpected load: %v (err=%v)", load, err)
+	}
+	if _, err := parseLoadAvg("garbage"); err == nil {
+		t.Errorf("Expected error for malformed loadavg")
+	}
+
+	meminfo := "MemTotal:        2048000 kB\nMemFree:          100000 kB\nMemAvailable:    1024000 kB\n"
+	total, available, err := parseMemInfo(bufio.NewScanner(strings.NewReader(meminfo)))
+	if err != nil || total != 2048000*1024 || available != 1024000*1024 {
+		t.Errorf("Unexpected memory: total=%d available=%d err=%v", total, available, err)
+	}
+	t.Logf("✓ Load and memory parsed")
+}
+
+func TestSystemCollector_Snapshot(t *testing.T) {
+	t.Logf("Testing system snapshot...")
+
+	originalLoad, originalMem, originalDisk, originalPing, originalStats := readLoadAvg, readMemInfo, diskUsage, dockerPing, dockerStats
+	t.Cleanup(func() {
+		readLoadAvg, readMemInfo, diskUsage, dockerPing, dockerStats = originalLoad, originalMem, originalDisk, originalPing, originalStats
+	})
+	readLoadAvg = func() ([3]float64, error) { return [3]float64{1, 2, 3}, nil }
+	readMemInfo = func() (uint64, uint64, error) { return 4 << 30, 1 << 30, nil }
+	diskUsage = func(string) (uint64, uint64, error) { return 90, 100, nil }
+	dockerPing = func() error { return nil }
+	dockerStats = func(names []string) (map[string]ContainerStats, error) {
+		return map[string]ContainerStats{"potato-cloud-web": {CPUPercent: 12.5, MemoryBytes: 64 << 20}}, nil
+	}
+
+	targets := func() []Target {
+		return []Target{{ServiceID: "web", ContainerName: "potato-cloud-web"}, {ServiceID: "gone", ContainerName: "potato-cloud-gone"}}
+	}
+	snapshot := NewSystemCollector("/data", targets).Snapshot()
+	if snapshot.Load1 != 1 || snapshot.Load15 != 3 || snapshot.MemoryTotalBytes != 4<<30 || snapshot.DiskUsedBytes != 90 || snapshot.DiskTotalBytes != 100 {
+		t.Errorf("Unexpected host readings: %+v", snapshot)
+	}
+	if !snapshot.DockerAvailable || len(snapshot.Services) != 1 || snapshot.Services[0].ServiceID != "web" || snapshot.Services[0].CPUPercent != 12.5 {
+		t.Errorf("Unexpected container readings: %+v", snapshot)
+	}
+
+	dockerPing = func() error { return errors.New("docker daemon unavailable") }
+	snapshot = NewSystemCollector("/data", targets).Snapshot()
+	if snapshot.DockerAvailable || snapshot.DockerError == "" || len(snapshot.Services) != 0 {
+		t.Errorf("Expected docker reported unavailable without container stats, got %+v", snapshot)
+	}
+	t.Logf("✓ Host, disk, docker and container usage reported")
+}
@@ -0,0 +1,6 @@
+//go:build linux && !devmode
+
+package platform
+
+// DevMode reports whether host firewall and DNS edits are skipped.
+const DevMode = false
@@ -0,0 +1,17 @@
+//go:build !windows
+
+package platform
+
+import "syscall"
+
+// DiskUsage reports used and total bytes on the filesystem containing path.
+// Used counts space unavailable to unprivileged users, matching df.
+func DiskUsage(path string) (used, total uint64, err error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, 0, err
+	}
+	total = uint64(st.Blocks) * uint64(st.Bsize)
+	available := uint64(st.Bavail) * uint64(st.Bsize)
+	return total - available, total, nil
+}
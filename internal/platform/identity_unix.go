@@ -0,0 +1,148 @@
+//go:build !windows
+
+package platform
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"syscall"
+)
+
+// Identity is the user and group that own the agent's files and run its
+// unprivileged commands.
+type Identity struct {
+	User   string
+	UID    uint32
+	GID    uint32
+	Groups []uint32 // Supplementary groups, e.g. docker
+}
+
+// LookupIdentity resolves a user and group by name or numeric ID. An empty
+// group means the user's primary group.
+func LookupIdentity(userName, groupName string) (*Identity, error) {
+	u, err := user.Lookup(userName)
+	if err != nil {
+		if u, err = user.LookupId(userName); err != nil {
+			return nil, fmt.Errorf("unknown user %q", userName)
+		}
+	}
+	id := &Identity{User: u.Username}
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("user %q has non-numeric uid %q", userName, u.Uid)
+	}
+	id.UID = uint32(uid)
+
+	gidText := u.Gid
+	if groupName != "" {
+		g, err := user.LookupGroup(groupName)
+		if err != nil {
+			if g, err = user.LookupGroupId(groupName); err != nil {
+				return nil, fmt.Errorf("unknown group %q", groupName)
+			}
+		}
+		gidText = g.Gid
+	}
+	gid, err := strconv.ParseUint(gidText, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("group %q has non-numeric gid %q", groupName, gidText)
+	}
+	id.GID = uint32(gid)
+
+	groupIDs, _ := u.GroupIds()
+	for _, text := range groupIDs {
+		if g, err := strconv.ParseUint(text, 10, 32); err == nil {
+			id.Groups = append(id.Groups, uint32(g))
+		}
+	}
+	return id, nil
+}
+
+// Chown gives path, and everything under it, to the identity. Symlinks are
+// changed themselves, never followed.
+func (id *Identity) Chown(path string) error {
+	return filepath.WalkDir(path, func(p string, _ fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := os.Lchown(p, int(id.UID), int(id.GID)); err != nil {
+			return fmt.Errorf("failed to chown %s: %w", p, err)
+		}
+		return nil
+	})
+}
+
+// FileOwner returns the uid and gid owning a file.
+func FileOwner(info os.FileInfo) (uint32, uint32, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return stat.Uid, stat.Gid, true
+}
+
+// SetUmask sets the process umask and returns the previous one.
+func SetUmask(mask int) int {
+	return syscall.Umask(mask)
+}
+
+// IsRoot reports whether the agent runs as root.
+func IsRoot() bool {
+	return os.Geteuid() == 0
+}
+
+var commandIdentity struct {
+	sync.Mutex
+	id *Identity
+}
+
+// SetCommandIdentity makes Unprivileged run commands as id, for an agent
+// that keeps root for firewall and /etc/hosts changes.
+func SetCommandIdentity(id *Identity) {
+	commandIdentity.Lock()
+	defer commandIdentity.Unlock()
+	commandIdentity.id = id
+}
+
+// Unprivileged makes cmd run as the command identity when the agent runs as
+// root and one is set; otherwise cmd is left as is.
+func Unprivileged(cmd *exec.Cmd) *exec.Cmd {
+	commandIdentity.Lock()
+	id := commandIdentity.id
+	commandIdentity.Unlock()
+	if id == nil || !IsRoot() {
+		return cmd
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Credential = &syscall.Credential{Uid: id.UID, Gid: id.GID, Groups: id.Groups}
+	return cmd
+}
+
+// DropPrivileges switches the whole process, every thread included, to id
+// and its supplementary groups. It can't be undone. Commands then run as id
+// without Unprivileged changing them.
+func DropPrivileges(id *Identity) error {
+	groups := make([]int, 0, len(id.Groups))
+	for _, g := range id.Groups {
+		groups = append(groups, int(g))
+	}
+	if err := syscall.Setgroups(groups); err != nil {
+		return fmt.Errorf("failed to set groups: %w", err)
+	}
+	if err := syscall.Setgid(int(id.GID)); err != nil {
+		return fmt.Errorf("failed to set gid %d: %w", id.GID, err)
+	}
+	if err := syscall.Setuid(int(id.UID)); err != nil {
+		return fmt.Errorf("failed to set uid %d: %w", id.UID, err)
+	}
+	SetCommandIdentity(nil)
+	return nil
+}
@@ -0,0 +1,57 @@
+//go:build windows
+
+package platform
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// Identity is the user and group that own the agent's files and run its
+// unprivileged commands. Windows has no uid/gid ownership, so it is never
+// resolved there.
+type Identity struct {
+	User   string
+	UID    uint32
+	GID    uint32
+	Groups []uint32
+}
+
+// LookupIdentity always fails on Windows.
+func LookupIdentity(userName, groupName string) (*Identity, error) {
+	return nil, fmt.Errorf("service_user is not supported on Windows")
+}
+
+// Chown does nothing on Windows.
+func (id *Identity) Chown(path string) error {
+	return nil
+}
+
+// FileOwner reports no owner on Windows.
+func FileOwner(info os.FileInfo) (uint32, uint32, bool) {
+	return 0, 0, false
+}
+
+// SetUmask does nothing on Windows.
+func SetUmask(mask int) int {
+	return 0
+}
+
+// IsRoot reports false on Windows.
+func IsRoot() bool {
+	return false
+}
+
+// SetCommandIdentity does nothing on Windows.
+func SetCommandIdentity(id *Identity) {}
+
+// Unprivileged returns cmd unchanged on Windows.
+func Unprivileged(cmd *exec.Cmd) *exec.Cmd {
+	return cmd
+}
+
+// DropPrivileges always fails on Windows.
+func DropPrivileges(id *Identity) error {
+	return fmt.Errorf("dropping privileges is not supported on Windows")
+}
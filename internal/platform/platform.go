@@ -0,0 +1,40 @@
+// Package platform isolates host integration that only exists on Linux
+// servers (UFW, /etc/hosts, signal-0 liveness probes) so the agent can also
+// be built in development mode for macOS and Windows against Docker Desktop.
+//
+// Development mode is enabled automatically on non-Linux builds, and can be
+// forced on Linux with the devmode build tag:
+//
+//	go build -tags devmode ./cmd/agent
+package platform
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// DefaultDataDir returns the default agent data directory.
+func DefaultDataDir() string {
+	if DevMode {
+		return filepath.Join(devHome(), "data")
+	}
+	return "/var/lib/potato-cloud"
+}
+
+// DefaultConfigPath returns the default configuration file path.
+func DefaultConfigPath() string {
+	if DevMode {
+		return filepath.Join(devHome(), "config.json")
+	}
+	return "/etc/potato-cloud/config.json"
+}
+
+// devHome is the per-user directory used in development mode, so a local run
+// never needs root.
+func devHome() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ".potato-cloud"
+	}
+	return filepath.Join(home, ".potato-cloud")
+}
@@ -0,0 +1,16 @@
+//go:build !windows
+
+package platform
+
+import (
+	"os"
+	"syscall"
+)
+
+// ProcessAlive reports whether a started process is still running.
+func ProcessAlive(p *os.Process) bool {
+	return p.Signal(syscall.Signal(0)) == nil
+}
+
+// UpgradeSignals stop the agent for an upgrade rather than a plain shutdown.
+var UpgradeSignals = []os.Signal{syscall.SIGUSR2}
@@ -0,0 +1,30 @@
+//go:build windows
+
+package platform
+
+import (
+	"os"
+	"syscall"
+)
+
+const stillActive = 259
+
+// UpgradeSignals stop the agent for an upgrade rather than a plain shutdown.
+// Windows has no user signals, so upgrades are reported as shutdowns.
+var UpgradeSignals []os.Signal
+
+// ProcessAlive reports whether a started process is still running. Windows
+// has no signal 0, so the exit code is queried instead.
+func ProcessAlive(p *os.Process) bool {
+	h, err := syscall.OpenProcess(syscall.PROCESS_QUERY_INFORMATION, false, uint32(p.Pid))
+	if err != nil {
+		return false
+	}
+	defer syscall.CloseHandle(h)
+
+	var code uint32
+	if err := syscall.GetExitCodeProcess(h, &code); err != nil {
+		return false
+	}
+	return code == stillActive
+}
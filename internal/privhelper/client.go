@@ -0,0 +1,157 @@
+package privhelper
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"time"
+
+	"github.com/buildvigil/agent/internal/firewall"
+)
+
+// callTimeout bounds one operation; applying firewall rules runs ufw once
+// per rule.
+const callTimeout = 2 * time.Minute
+
+// Client asks the helper listening on a unix socket to perform operations.
+type Client struct {
+	path string
+}
+
+// NewClient creates a client for the helper socket at path.
+func NewClient(path string) *Client {
+	return &Client{path: path}
+}
+
+// Ping checks the helper answers.
+func (c *Client) Ping() error {
+	_, _, err := c.call(request{Op: opFirewallAvailable})
+	return err
+}
+
+// Listen has the helper bind addr and pass back the listening socket.
+func (c *Client) Listen(addr string) (net.Listener, error) {
+	_, file, err := c.call(request{Op: opListen, Addr: addr})
+	if err != nil {
+		return nil, err
+	}
+	if file == nil {
+		return nil, fmt.Errorf("helper returned no listener for %s", addr)
+	}
+	defer file.Close()
+	return net.FileListener(file)
+}
+
+// Firewall returns a firewall that applies settings through the helper. It
+// has the methods of firewall.Manager that the agent uses.
+func (c *Client) Firewall(settings firewall.Settings) *Firewall {
+	return &Firewall{client: c, settings: settings}
+}
+
+// Hosts returns the /etc/hosts entries kept by the helper.
+func (c *Client) Hosts() *Hosts {
+	return &Hosts{client: c}
+}
+
+func (c *Client) call(req request) (response, *os.File, error) {
+	conn, err := net.DialTimeout("unix", c.path, 5*time.Second)
+	if err != nil {
+		return response{}, nil, fmt.Errorf("privileged helper unavailable: %w", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(callTimeout))
+
+	data, err := json.Marshal(req)
+	if err != nil {
+		return response{}, nil, err
+	}
+	if _, err := conn.Write(append(data, '\n')); err != nil {
+		return response{}, nil, fmt.Errorf("privileged helper: %w", err)
+	}
+	first, file, err := readWithFile(conn.(*net.UnixConn))
+	if err != nil {
+		return response{}, nil, fmt.Errorf("privileged helper: %w", err)
+	}
+	rest, err := io.ReadAll(conn)
+	if err != nil {
+		if file != nil {
+			file.Close()
+		}
+		return response{}, nil, fmt.Errorf("privileged helper: %w", err)
+	}
+
+	var resp response
+	if err := json.Unmarshal(append(first, rest...), &resp); err != nil {
+		if file != nil {
+			file.Close()
+		}
+		return response{}, nil, fmt.Errorf("privileged helper sent an invalid response: %w", err)
+	}
+	if resp.Error != "" {
+		if file != nil {
+			file.Close()
+		}
+		return resp, nil, fmt.Errorf("privileged helper: %s", resp.Error)
+	}
+	return resp, file, nil
+}
+
+func (c *Client) do(req request) error {
+	_, _, err := c.call(req)
+	return err
+}
+
+// Firewall applies one set of firewall settings through the helper.
+type Firewall struct {
+	client   *Client
+	settings firewall.Settings
+}
+
+// Apply applies the settings.
+func (f *Firewall) Apply() error {
+	return f.client.do(request{Op: opFirewallApply, Firewall: &f.settings})
+}
+
+// Rollback restores the rules from before the last Apply.
+func (f *Firewall) Rollback() error {
+	return f.client.do(request{Op: opFirewallRollback})
+}
+
+// Revert removes every rule the agent added.
+func (f *Firewall) Revert() error {
+	return f.client.do(request{Op: opFirewallRevert})
+}
+
+// RefreshEgress re-resolves the egress allowlist.
+func (f *Firewall) RefreshEgress() error {
+	return f.client.do(request{Op: opFirewallRefresh})
+}
+
+// IsAvailable reports whether the helper can manage the firewall.
+func (f *Firewall) IsAvailable() bool {
+	resp, _, err := f.client.call(request{Op: opFirewallAvailable})
+	return err == nil && resp.Available
+}
+
+// GetStatus returns the firewall status.
+func (f *Firewall) GetStatus() (map[string]interface{}, error) {
+	resp, _, err := f.client.call(request{Op: opFirewallStatus})
+	return resp.Status, err
+}
+
+// Hosts keeps the *.svc.internal entries in /etc/hosts through the helper.
+type Hosts struct {
+	client *Client
+}
+
+// UpdateServices replaces the entries with one per service name.
+func (h *Hosts) UpdateServices(serviceNames []string) error {
+	return h.client.do(request{Op: opHostsUpdate, Names: serviceNames})
+}
+
+// Cleanup removes the entries.
+func (h *Hosts) Cleanup() error {
+	return h.client.do(request{Op: opHostsCleanup})
+}
@@ -0,0 +1,43 @@
+//go:build !windows
+
+package privhelper
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+)
+
+// writeWithFile writes data, passing file's descriptor alongside if set.
+func writeWithFile(conn *net.UnixConn, data []byte, file *os.File) error {
+	var oob []byte
+	if file != nil {
+		oob = syscall.UnixRights(int(file.Fd()))
+	}
+	_, _, err := conn.WriteMsgUnix(data, oob, nil)
+	return err
+}
+
+// readWithFile reads the first chunk of a response and the descriptor
+// passed with it, if any.
+func readWithFile(conn *net.UnixConn) ([]byte, *os.File, error) {
+	buf := make([]byte, 64*1024)
+	oob := make([]byte, syscall.CmsgSpace(4))
+	n, oobn, _, _, err := conn.ReadMsgUnix(buf, oob)
+	if err != nil {
+		return nil, nil, err
+	}
+	if oobn == 0 {
+		return buf[:n], nil, nil
+	}
+	msgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+	if err != nil || len(msgs) != 1 {
+		return nil, nil, fmt.Errorf("invalid control message")
+	}
+	fds, err := syscall.ParseUnixRights(&msgs[0])
+	if err != nil || len(fds) != 1 {
+		return nil, nil, fmt.Errorf("invalid file descriptor")
+	}
+	return buf[:n], os.NewFile(uintptr(fds[0]), "helper-listener"), nil
+}
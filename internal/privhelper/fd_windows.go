@@ -0,0 +1,29 @@
+//go:build windows
+
+package privhelper
+
+import (
+	"fmt"
+	"net"
+	"os"
+)
+
+// writeWithFile writes data. Windows can't pass sockets over a unix socket,
+// so the helper can't hand out listeners there.
+func writeWithFile(conn *net.UnixConn, data []byte, file *os.File) error {
+	if file != nil {
+		return fmt.Errorf("passing listeners is not supported on Windows")
+	}
+	_, err := conn.Write(data)
+	return err
+}
+
+// readWithFile reads the first chunk of a response.
+func readWithFile(conn *net.UnixConn) ([]byte, *os.File, error) {
+	buf := make([]byte, 64*1024)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil, nil, err
+	}
+	return buf[:n], nil, nil
+}
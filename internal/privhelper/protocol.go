@@ -0,0 +1,38 @@
+// Package privhelper runs the agent's privileged operations in a small root
+// helper: applying firewall rules, editing the *.svc.internal entries in
+// /etc/hosts and binding privileged ports. The agent asks for them over a
+// unix socket, so it can run as an unprivileged user.
+package privhelper
+
+import (
+	"github.com/buildvigil/agent/internal/firewall"
+)
+
+// Operations the helper performs.
+const (
+	opFirewallApply     = "firewall.apply"
+	opFirewallRollback  = "firewall.rollback"
+	opFirewallRevert    = "firewall.revert"
+	opFirewallRefresh   = "firewall.refresh_egress"
+	opFirewallAvailable = "firewall.available"
+	opFirewallStatus    = "firewall.status"
+	opHostsUpdate       = "hosts.update"
+	opHostsCleanup      = "hosts.cleanup"
+	opListen            = "listen"
+)
+
+// request is one operation, sent as a line of JSON on its own connection.
+type request struct {
+	Op       string             `json:"op"`
+	Firewall *firewall.Settings `json:"firewall,omitempty"`
+	Names    []string           `json:"names,omitempty"` // Service names for hosts.update
+	Addr     string             `json:"addr,omitempty"`  // host:port for listen
+}
+
+// response answers a request. A listen response carries the listening
+// socket as an SCM_RIGHTS file descriptor.
+type response struct {
+	Error     string                 `json:"error,omitempty"`
+	Available bool                   `json:"available,omitempty"`
+	Status    map[string]interface{} `json:"status,omitempty"`
+}
@@ -0,0 +1,197 @@
+package privhelper
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+
+	"github.com/buildvigil/agent/internal/firewall"
+	"github.com/buildvigil/agent/internal/proxy"
+)
+
+// hostsNamePattern is what a service name in /etc/hosts may look like.
+var hostsNamePattern = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9._-]*[A-Za-z0-9])?$`)
+
+// Server performs privileged operations for the agent.
+type Server struct {
+	hosts   *proxy.DNSManager
+	listens map[string]bool
+
+	mu       sync.Mutex
+	fw       *firewall.Manager // Built from the last applied settings
+	listener net.Listener
+}
+
+// NewServer creates a helper that edits hosts through dns and binds only the
+// given host:port addresses.
+func NewServer(dns *proxy.DNSManager, allowedListens []string) *Server {
+	listens := make(map[string]bool, len(allowedListens))
+	for _, addr := range allowedListens {
+		listens[addr] = true
+	}
+	return &Server{hosts: dns, listens: listens}
+}
+
+// Listen serves on a unix socket at path, readable and writable by root
+// and, when gid is not negative, that group.
+func (s *Server) Listen(path string, gid int) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create socket directory: %w", err)
+	}
+	_ = os.Remove(path)
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", path, err)
+	}
+	mode := os.FileMode(0600)
+	if gid >= 0 {
+		mode = 0660
+		if err := os.Chown(path, 0, gid); err != nil {
+			listener.Close()
+			return fmt.Errorf("failed to chown helper socket: %w", err)
+		}
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		listener.Close()
+		return fmt.Errorf("failed to chmod helper socket: %w", err)
+	}
+
+	s.mu.Lock()
+	s.listener = listener
+	s.mu.Unlock()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serveConn(conn.(*net.UnixConn))
+		}
+	}()
+	log.Printf("[Helper] Listening on unix socket %s", path)
+	return nil
+}
+
+// Stop closes the socket.
+func (s *Server) Stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listener != nil {
+		s.listener.Close()
+		s.listener = nil
+	}
+}
+
+func (s *Server) serveConn(conn *net.UnixConn) {
+	defer conn.Close()
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err != nil {
+		return
+	}
+	var req request
+	if err := json.Unmarshal(line, &req); err != nil {
+		writeResponse(conn, response{Error: "invalid request"}, nil)
+		return
+	}
+
+	var file *os.File
+	resp, err := s.handle(req, &file)
+	if err != nil {
+		log.Printf("[Helper] %s failed: %v", req.Op, err)
+		resp.Error = err.Error()
+	}
+	if file != nil {
+		defer file.Close()
+	}
+	if err := writeResponse(conn, resp, file); err != nil {
+		log.Printf("[Helper] Failed to answer %s: %v", req.Op, err)
+	}
+}
+
+// handle performs one operation. A listen sets file to the socket to pass.
+func (s *Server) handle(req request, file **os.File) (response, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch req.Op {
+	case opFirewallApply:
+		if req.Firewall == nil {
+			return response{}, fmt.Errorf("missing firewall settings")
+		}
+		fw, err := firewall.NewManagerFromSettings(*req.Firewall)
+		if err != nil {
+			return response{}, err
+		}
+		s.fw = fw
+		log.Printf("[Helper] Applying firewall: mode=%s", req.Firewall.Mode)
+		return response{}, fw.Apply()
+	case opFirewallRollback:
+		if s.fw == nil {
+			return response{}, fmt.Errorf("no firewall change to roll back")
+		}
+		return response{}, s.fw.Rollback()
+	case opFirewallRevert:
+		return response{}, s.firewall().Revert()
+	case opFirewallRefresh:
+		if s.fw == nil {
+			return response{}, nil
+		}
+		return response{}, s.fw.RefreshEgress()
+	case opFirewallAvailable:
+		return response{Available: s.firewall().IsAvailable()}, nil
+	case opFirewallStatus:
+		status, err := s.firewall().GetStatus()
+		return response{Status: status}, err
+	case opHostsUpdate:
+		for _, name := range req.Names {
+			if !hostsNamePattern.MatchString(name) {
+				return response{}, fmt.Errorf("invalid service name %q", name)
+			}
+		}
+		return response{}, s.hosts.UpdateServices(req.Names)
+	case opHostsCleanup:
+		return response{}, s.hosts.Cleanup()
+	case opListen:
+		if !s.listens[req.Addr] {
+			return response{}, fmt.Errorf("listening on %s is not allowed", req.Addr)
+		}
+		listener, err := net.Listen("tcp", req.Addr)
+		if err != nil {
+			return response{}, err
+		}
+		defer listener.Close()
+		f, err := listener.(*net.TCPListener).File()
+		if err != nil {
+			return response{}, err
+		}
+		*file = f
+		log.Printf("[Helper] Passed listener: addr=%s", req.Addr)
+		return response{}, nil
+	default:
+		return response{}, fmt.Errorf("unknown operation %q", req.Op)
+	}
+}
+
+// firewall returns the last applied firewall, or one without settings for
+// reverting and status. Callers hold mu.
+func (s *Server) firewall() *firewall.Manager {
+	if s.fw != nil {
+		return s.fw
+	}
+	return firewall.NewManager(firewall.SecurityModeNone, 0)
+}
+
+func writeResponse(conn *net.UnixConn, resp response, file *os.File) error {
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	return writeWithFile(conn, append(data, '\n'), file)
+}
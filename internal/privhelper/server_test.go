@@ -0,0 +1,88 @@
+package privhelper
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/buildvigil/agent/internal/firewall"
+	"github.com/buildvigil/agent/internal/platform"
+	"github.com/buildvigil/agent/internal/proxy"
+)
+
+func TestHelper(t *testing.T) {
+	t.Logf("Testing hosts edits, listeners and refused requests through the helper")
+
+	// Unix socket paths are limited to ~100 bytes, which t.TempDir can exceed.
+	dir, err := os.MkdirTemp("", "helper")
+	if err != nil {
+		t.Fatalf("MkdirTemp: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	hostsFile := filepath.Join(dir, "hosts")
+	os.WriteFile(hostsFile, []byte("127.0.0.1 localhost\n"), 0644)
+	dns := proxy.NewDNSManager()
+	dns.SetHostsFile(hostsFile)
+
+	server := NewServer(dns, []string{"127.0.0.1:0"})
+	socket := filepath.Join(dir, "helper.sock")
+	if err := server.Listen(socket, -1); err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer server.Stop()
+	if info, err := os.Stat(socket); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("Expected a 0600 socket, got %v (err=%v)", info.Mode(), err)
+	}
+
+	client := NewClient(socket)
+	if err := client.Ping(); err != nil {
+		t.Fatalf("Ping: %v", err)
+	}
+	hosts := client.Hosts()
+	if err := hosts.UpdateServices([]string{"web", "api"}); err != nil {
+		t.Fatalf("UpdateServices: %v", err)
+	}
+	if err := hosts.UpdateServices([]string{"web\n10.0.0.1 bank.example.com"}); err == nil || !strings.Contains(err.Error(), "invalid service name") {
+		t.Errorf("Expected a name with a newline to be refused, got %v", err)
+	}
+	if !platform.DevMode {
+		content, _ := os.ReadFile(hostsFile)
+		if !strings.Contains(string(content), "127.0.0.1 api.svc.internal") || strings.Contains(string(content), "bank") {
+			t.Errorf("Unexpected hosts file:\n%s", content)
+		}
+	}
+
+	listener, err := client.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen through helper: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		if conn, err := listener.Accept(); err == nil {
+			conn.Write([]byte("ok"))
+			conn.Close()
+		}
+	}()
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect to the passed listener: %v", err)
+	}
+	buf := make([]byte, 2)
+	if _, err := conn.Read(buf); err != nil || string(buf) != "ok" {
+		t.Errorf("Expected the passed listener to accept, got %q (err=%v)", buf, err)
+	}
+	conn.Close()
+
+	if _, err := client.Listen("0.0.0.0:22"); err == nil || !strings.Contains(err.Error(), "not allowed") {
+		t.Errorf("Expected an address outside the allowlist to be refused, got %v", err)
+	}
+	if err := client.Firewall(firewall.Settings{Mode: "open-everything"}).Apply(); err == nil {
+		t.Error("Expected an unknown security mode to be refused")
+	}
+	if err := client.Firewall(firewall.Settings{Mode: firewall.SecurityModeBlocked, SSHPort: 22, SSHCIDR: "any; rm -rf /"}).Apply(); err == nil {
+		t.Error("Expected an invalid SSH CIDR to be refused")
+	}
+	t.Logf("✓ Helper edits hosts, passes allowed listeners and refuses the rest")
+}
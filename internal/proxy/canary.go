@@ -0,0 +1,98 @@
+package proxy
+
+import (
+	"math/rand"
+	"net/http"
+	"sync"
+)
+
+// canary diverts part of the traffic for a stable port to a canary port.
+type canary struct {
+	port     int
+	weight   int // Percent of requests sent to port
+	requests uint64
+	errors   uint64
+}
+
+// CanaryRouter diverts a share of the requests both proxies send to a
+// service's port to the new container of a canary deploy, and counts how the
+// canary answers. It is shared by the external and internal proxies.
+type CanaryRouter struct {
+	mu       sync.Mutex
+	canaries map[int]*canary // stable port -> canary
+	byPort   map[int]*canary // canary port -> canary
+	intn     func(n int) int
+}
+
+// NewCanaryRouter creates a router with no canaries.
+func NewCanaryRouter() *CanaryRouter {
+	return &CanaryRouter{
+		canaries: make(map[int]*canary),
+		byPort:   make(map[int]*canary),
+		intn:     rand.Intn,
+	}
+}
+
+// SetCanary sends weight percent of the requests for stablePort to
+// canaryPort, and resets the canary's counts.
+func (r *CanaryRouter) SetCanary(stablePort, canaryPort, weight int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if previous, ok := r.canaries[stablePort]; ok {
+		delete(r.byPort, previous.port)
+	}
+	c := &canary{port: canaryPort, weight: weight}
+	r.canaries[stablePort] = c
+	r.byPort[canaryPort] = c
+}
+
+// ClearCanary sends all requests for stablePort back to it.
+func (r *CanaryRouter) ClearCanary(stablePort int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if c, ok := r.canaries[stablePort]; ok {
+		delete(r.byPort, c.port)
+		delete(r.canaries, stablePort)
+	}
+}
+
+// CanaryCounts returns the requests sent to canaryPort since its canary was
+// set, and how many of them failed (5xx, including the proxy's 502 when the
+// canary does not answer).
+func (r *CanaryRouter) CanaryCounts(canaryPort int) (requests, errors uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if c, ok := r.byPort[canaryPort]; ok {
+		return c.requests, c.errors
+	}
+	return 0, 0
+}
+
+// route returns the port a request for port goes to, and whether it is a
+// canary port. A nil router routes every request to port.
+func (r *CanaryRouter) route(port int) (int, bool) {
+	if r == nil {
+		return port, false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c, ok := r.canaries[port]
+	if !ok || r.intn(100) >= c.weight {
+		return port, false
+	}
+	return c.port, true
+}
+
+// record counts a canary request's status.
+func (r *CanaryRouter) record(canaryPort, status int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c, ok := r.byPort[canaryPort]
+	if !ok {
+		return
+	}
+	c.requests++
+	if status >= http.StatusInternalServerError {
+		c.errors++
+	}
+}
@@ -0,0 +1,74 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestCanaryRouterSplitsTraffic(t *testing.T) {
+	t.Logf("Testing weighted traffic to a canary through both proxies")
+
+	serve := func(status int) (int, *int) {
+		hits := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hits++
+			w.WriteHeader(status)
+		}))
+		t.Cleanup(server.Close)
+		port, _ := strconv.Atoi(server.URL[strings.LastIndex(server.URL, ":")+1:])
+		return port, &hits
+	}
+	stablePort, stableHits := serve(http.StatusOK)
+	canaryPort, canaryHits := serve(http.StatusInternalServerError)
+
+	router := NewCanaryRouter()
+	draws := 0
+	router.intn = func(n int) int {
+		draws++
+		return (draws * 10) % n
+	}
+	external := NewExternalProxy(0, "127.0.0.1")
+	external.UpdateRoutes(map[string]int{"api.example.com": stablePort})
+	external.SetCanaryRouter(router)
+	internal := NewInternalProxy()
+	internal.UpdateRoutes(map[string]int{"api": stablePort})
+	internal.SetCanaryRouter(router)
+
+	send := func(n int) {
+		for i := 0; i < n; i++ {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Host = "api.example.com"
+			external.handleRequest(httptest.NewRecorder(), req)
+			req = httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Host = "api.svc.internal"
+			internal.handleRequest(httptest.NewRecorder(), req)
+		}
+	}
+
+	send(5)
+	if *canaryHits != 0 || *stableHits != 10 {
+		t.Fatalf("Expected all requests on the stable port without a canary, got stable=%d canary=%d", *stableHits, *canaryHits)
+	}
+
+	router.SetCanary(stablePort, canaryPort, 30)
+	send(5)
+	if *canaryHits != 3 || *stableHits != 17 {
+		t.Errorf("Expected 30%% of requests on the canary, got stable=%d canary=%d", *stableHits, *canaryHits)
+	}
+	if requests, errors := router.CanaryCounts(canaryPort); requests != 3 || errors != 3 {
+		t.Errorf("Expected 3 failed canary requests counted, got requests=%d errors=%d", requests, errors)
+	}
+
+	router.ClearCanary(stablePort)
+	send(5)
+	if *canaryHits != 3 {
+		t.Errorf("Expected no canary requests once cleared, got %d", *canaryHits)
+	}
+	if requests, _ := router.CanaryCounts(canaryPort); requests != 0 {
+		t.Errorf("Expected cleared canary counts dropped, got %d", requests)
+	}
+	t.Logf("✓ Canary share routed, counted and cleared")
+}
@@ -6,6 +6,8 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+
+	"github.com/buildvigil/agent/internal/platform"
 )
 
 // DNSManager manages local DNS entries for svc.internal domains
@@ -23,6 +25,12 @@ func NewDNSManager() *DNSManager {
 	}
 }
 
+// SetHostsFile changes the hosts file the manager edits, e.g. to a temporary
+// file in tests.
+func (d *DNSManager) SetHostsFile(path string) {
+	d.hostsFile = path
+}
+
 // atomicWriteFile writes content to a temp file then renames it into place
 // for crash-safe updates.
 func atomicWriteFile(path string, content []byte, perm os.FileMode) error {
@@ -55,6 +63,11 @@ func atomicWriteFile(path string, content []byte, perm os.FileMode) error {
 
 // UpdateServices updates the DNS entries for services
 func (d *DNSManager) UpdateServices(serviceNames []string) error {
+	// Development hosts (macOS/Windows) keep their hosts file untouched
+	if platform.DevMode {
+		return nil
+	}
+
 	// Read current hosts file
 	content, err := os.ReadFile(d.hostsFile)
 	if err != nil {
@@ -96,6 +109,10 @@ func (d *DNSManager) UpdateServices(serviceNames []string) error {
 
 // Cleanup removes all BuildVigil DNS entries
 func (d *DNSManager) Cleanup() error {
+	if platform.DevMode {
+		return nil
+	}
+
 	content, err := os.ReadFile(d.hostsFile)
 	if err != nil {
 		return fmt.Errorf("failed to read hosts file: %w", err)
@@ -3,10 +3,10 @@ package proxy
 import (
 	"context"
 	"fmt"
+	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
-	"strings"
 	"sync"
 	"time"
 )
@@ -15,9 +15,19 @@ import (
 type ExternalProxy struct {
 	port     int
 	bindAddr string
-	routes   map[string]int // hostname -> port
-	server   *http.Server
-	mu       sync.RWMutex
+	routes   map[string]int // hostname or wildcard pattern -> port
+	table    routeTable
+	// hostHeaders holds each route's host header policy, keyed like routes.
+	hostHeaders map[string]string
+	server      *http.Server
+	listener    net.Listener
+	mu          sync.RWMutex
+
+	requestsMu sync.Mutex
+	requests   map[string]uint64 // route hostname or pattern -> requests proxied
+
+	slo      *sloTracker
+	canaries *CanaryRouter
 }
 
 // NewExternalProxy creates a new external reverse proxy.
@@ -26,10 +36,15 @@ func NewExternalProxy(port int, bindAddr string) *ExternalProxy {
 		port:     port,
 		bindAddr: bindAddr,
 		routes:   make(map[string]int),
+		requests: make(map[string]uint64),
+		slo:      newSLOTracker(),
 	}
 }
 
-// UpdateRoutes updates the routing table (hostname -> port).
+// UpdateRoutes updates the routing table (hostname -> port). Hostnames are
+// matched case-insensitively and in punycode form; a "*.example.com" pattern
+// matches every subdomain of example.com without an exact route. Entries that
+// fail NormalizeHostPattern are ignored.
 func (p *ExternalProxy) UpdateRoutes(routes map[string]int) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
@@ -39,6 +54,13 @@ func (p *ExternalProxy) UpdateRoutes(routes map[string]int) {
 		next[k] = v
 	}
 	p.routes = next
+	p.table = newRouteTable(next)
+}
+
+// SetListener makes Start serve on listener, e.g. one bound by a privileged
+// helper, instead of binding the port itself.
+func (p *ExternalProxy) SetListener(listener net.Listener) {
+	p.listener = listener
 }
 
 // Start starts the proxy server.
@@ -51,6 +73,9 @@ func (p *ExternalProxy) Start() error {
 		Handler: mux,
 	}
 
+	if p.listener != nil {
+		return p.server.Serve(p.listener)
+	}
 	return p.server.ListenAndServe()
 }
 
@@ -67,14 +92,16 @@ func (p *ExternalProxy) Stop() error {
 }
 
 func (p *ExternalProxy) handleRequest(w http.ResponseWriter, r *http.Request) {
-	// Extract hostname from Host header (remove port if present)
-	host := r.Host
-	if idx := strings.Index(host, ":"); idx != -1 {
-		host = host[:idx]
+	host, err := NormalizeHost(r.Host)
+	if err != nil {
+		http.Error(w, "Invalid hostname", http.StatusBadRequest)
+		return
 	}
 
 	p.mu.RLock()
-	port, exists := p.routes[host]
+	matched, exists := p.table.lookup(host)
+	hostHeader := p.hostHeaders[matched.key]
+	canaries := p.canaries
 	p.mu.RUnlock()
 
 	if !exists {
@@ -82,6 +109,14 @@ func (p *ExternalProxy) handleRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	warmup := r.Header.Get(warmupHeader) != ""
+	if !warmup {
+		p.requestsMu.Lock()
+		p.requests[matched.key]++
+		p.requestsMu.Unlock()
+	}
+
+	port, isCanary := canaries.route(matched.port)
 	targetURL, err := url.Parse(fmt.Sprintf("http://127.0.0.1:%d", port))
 	if err != nil {
 		http.Error(w, "Invalid target URL", http.StatusInternalServerError)
@@ -94,9 +129,45 @@ func (p *ExternalProxy) handleRequest(w http.ResponseWriter, r *http.Request) {
 	r.Header.Set("X-Forwarded-Host", r.Host)
 	r.Header.Set("X-Forwarded-Proto", "http")
 	r.Header.Set("X-Forwarded-For", r.RemoteAddr)
+	r.Host = upstreamHost(hostHeader, r.Host)
 
 	// Full path is preserved (no path stripping)
-	proxy.ServeHTTP(w, r)
+	if warmup {
+		proxy.ServeHTTP(w, r)
+		return
+	}
+	// Requests are timed and their status recorded for SLO compliance; an
+	// unreachable upstream is answered with 502 by the reverse proxy.
+	recorder := &statusRecorder{ResponseWriter: w}
+	start := time.Now()
+	proxy.ServeHTTP(recorder, r)
+	if recorder.status == 0 {
+		recorder.status = http.StatusOK
+	}
+	p.slo.record(matched.key, recorder.status, time.Since(start))
+	if isCanary {
+		canaries.record(port, recorder.status)
+	}
+}
+
+// SetHostHeaders sets the host header policy of routes (hostname or pattern
+// -> policy); routes without one preserve the original Host.
+func (p *ExternalProxy) SetHostHeaders(policies map[string]string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	next := make(map[string]string, len(policies))
+	for k, v := range policies {
+		next[k] = v
+	}
+	p.hostHeaders = next
+}
+
+// SetCanaryRouter makes the proxy divert traffic to canary deploys.
+func (p *ExternalProxy) SetCanaryRouter(router *CanaryRouter) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.canaries = router
 }
 
 // GetPort returns the proxy port.
@@ -120,3 +191,16 @@ func (p *ExternalProxy) GetRoutes() map[string]int {
 	}
 	return out
 }
+
+// RequestCounts returns the number of requests proxied per hostname since the
+// proxy was created.
+func (p *ExternalProxy) RequestCounts() map[string]uint64 {
+	p.requestsMu.Lock()
+	defer p.requestsMu.Unlock()
+
+	out := make(map[string]uint64, len(p.requests))
+	for k, v := range p.requests {
+		out[k] = v
+	}
+	return out
+}
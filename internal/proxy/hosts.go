@@ -0,0 +1,143 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// NormalizeHost returns the canonical form of a request host for route
+// lookups: without port or trailing dot, lowercase, with internationalized
+// labels in punycode ("Bücher.example" becomes "xn--bcher-kva.example").
+func NormalizeHost(host string) (string, error) {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.TrimSpace(host), ".")
+	if host == "" {
+		return "", fmt.Errorf("empty hostname")
+	}
+	ascii, err := idna.Lookup.ToASCII(host)
+	if err != nil {
+		return "", fmt.Errorf("invalid hostname %q: %w", host, err)
+	}
+	return strings.ToLower(ascii), nil
+}
+
+// NormalizeHostPattern normalizes a route hostname, which may be a wildcard
+// pattern such as "*.example.com".
+func NormalizeHostPattern(pattern string) (string, error) {
+	suffix, wildcard := strings.CutPrefix(strings.TrimSpace(pattern), "*.")
+	if strings.Contains(suffix, "*") {
+		return "", fmt.Errorf("invalid hostname %q: a wildcard is only allowed as the whole first label", pattern)
+	}
+	host, err := NormalizeHost(suffix)
+	if err != nil {
+		return "", err
+	}
+	if wildcard {
+		return "*." + host, nil
+	}
+	return host, nil
+}
+
+// Host header policies for a route. Any other policy is a custom host sent
+// in place of the original.
+const (
+	HostHeaderPreserve = "preserve"
+	HostHeaderRewrite  = "rewrite"
+)
+
+// ValidateHostHeader checks a route's host header policy.
+func ValidateHostHeader(policy string) error {
+	switch policy {
+	case "", HostHeaderPreserve, HostHeaderRewrite:
+		return nil
+	}
+	host := policy
+	if h, port, err := net.SplitHostPort(policy); err == nil {
+		if _, err := strconv.Atoi(port); err != nil {
+			return fmt.Errorf("invalid host header %q: bad port", policy)
+		}
+		host = h
+	}
+	if _, err := NormalizeHost(host); err != nil || strings.ContainsAny(host, "/ ") {
+		return fmt.Errorf("invalid host header %q: use %q, %q or a hostname", policy, HostHeaderPreserve, HostHeaderRewrite)
+	}
+	return nil
+}
+
+// upstreamHost returns the Host header to send upstream under a policy.
+func upstreamHost(policy, original string) string {
+	switch policy {
+	case "", HostHeaderPreserve:
+		return original
+	case HostHeaderRewrite:
+		return "localhost"
+	default:
+		return policy
+	}
+}
+
+// route is a resolved entry of a routeTable. key is the hostname or pattern
+// as configured.
+type route struct {
+	key  string
+	port int
+}
+
+// wildcardRoute matches any hostname ending in suffix (".example.com").
+type wildcardRoute struct {
+	suffix string
+	route
+}
+
+// routeTable resolves hostnames to routes: exact hostnames first, then the
+// most specific wildcard pattern.
+type routeTable struct {
+	exact     map[string]route
+	wildcards []wildcardRoute
+}
+
+// newRouteTable builds a table from hostname (or pattern) -> port. Entries
+// that are not valid hostnames are skipped.
+func newRouteTable(routes map[string]int) routeTable {
+	table := routeTable{exact: make(map[string]route, len(routes))}
+	for key, port := range routes {
+		pattern, err := NormalizeHostPattern(key)
+		if err != nil {
+			continue
+		}
+		if suffix, ok := strings.CutPrefix(pattern, "*"); ok {
+			table.wildcards = append(table.wildcards, wildcardRoute{suffix: suffix, route: route{key: key, port: port}})
+			continue
+		}
+		table.exact[pattern] = route{key: key, port: port}
+	}
+	sort.Slice(table.wildcards, func(i, j int) bool {
+		a, b := table.wildcards[i].suffix, table.wildcards[j].suffix
+		if len(a) != len(b) {
+			return len(a) > len(b)
+		}
+		return a < b
+	})
+	return table
+}
+
+// lookup returns the route for a normalized hostname. A wildcard matches
+// subdomains at any depth but not the bare domain.
+func (t routeTable) lookup(host string) (route, bool) {
+	if r, ok := t.exact[host]; ok {
+		return r, true
+	}
+	for _, w := range t.wildcards {
+		if strings.HasSuffix(host, w.suffix) && len(host) > len(w.suffix) {
+			return w.route, true
+		}
+	}
+	return route{}, false
+}
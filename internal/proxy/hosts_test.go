@@ -0,0 +1,135 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeHostPattern(t *testing.T) {
+	t.Logf("Testing hostname normalization")
+
+	tests := []struct {
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{"API.Example.com", "api.example.com", false},
+		{"api.example.com.:8080", "api.example.com", false},
+		{"bücher.example", "xn--bcher-kva.example", false},
+		{"*.Example.com", "*.example.com", false},
+		{"*.bücher.example", "*.xn--bcher-kva.example", false},
+		{"a.*.example.com", "", true},
+		{"", "", true},
+	}
+	for _, tt := range tests {
+		got, err := NormalizeHostPattern(tt.input)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("NormalizeHostPattern(%q) = %q, %v; want %q (error %t)", tt.input, got, err, tt.want, tt.wantErr)
+		}
+	}
+	t.Logf("✓ Hostnames normalized")
+}
+
+func TestExternalProxyHostMatching(t *testing.T) {
+	t.Logf("Testing exact, wildcard and internationalized host routes")
+
+	backend := func(name string) (*httptest.Server, int) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(name))
+		}))
+		t.Cleanup(server.Close)
+		port, _ := strconv.Atoi(server.URL[strings.LastIndex(server.URL, ":")+1:])
+		return server, port
+	}
+	_, apiPort := backend("api")
+	_, wildcardPort := backend("wildcard")
+	_, deepPort := backend("deep")
+	_, idnPort := backend("idn")
+
+	p := NewExternalProxy(0, "127.0.0.1")
+	p.UpdateRoutes(map[string]int{
+		"api.example.com":   apiPort,
+		"*.example.com":     wildcardPort,
+		"*.eu.example.com":  deepPort,
+		"bücher.example":    idnPort,
+		"bad.*.example.com": 1,
+	})
+
+	tests := []struct {
+		host string
+		want string
+	}{
+		{"API.example.com", "api"},
+		{"shop.example.com:443", "wildcard"},
+		{"a.b.example.com", "wildcard"},
+		{"shop.eu.example.com", "deep"},
+		{"xn--bcher-kva.example", "idn"},
+		{"BÜCHER.example", "idn"},
+		{"example.com", ""},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Host = tt.host
+		rec := httptest.NewRecorder()
+		p.handleRequest(rec, req)
+		if tt.want == "" {
+			if rec.Code != http.StatusNotFound {
+				t.Errorf("Host %s: expected 404, got %d", tt.host, rec.Code)
+			}
+			continue
+		}
+		if rec.Code != http.StatusOK || rec.Body.String() != tt.want {
+			t.Errorf("Host %s: expected %s, got %d %q", tt.host, tt.want, rec.Code, rec.Body.String())
+		}
+	}
+
+	if counts := p.RequestCounts(); counts["*.example.com"] != 2 || counts["api.example.com"] != 1 {
+		t.Errorf("Expected requests counted per route, got %v", counts)
+	}
+	t.Logf("✓ Hosts matched case-insensitively, by wildcard and by punycode")
+}
+
+func TestExternalProxyHostHeaders(t *testing.T) {
+	t.Logf("Testing per-route host header policies")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Host + " " + r.Header.Get("X-Forwarded-Host")))
+	}))
+	defer server.Close()
+	port, _ := strconv.Atoi(server.URL[strings.LastIndex(server.URL, ":")+1:])
+
+	p := NewExternalProxy(0, "127.0.0.1")
+	p.UpdateRoutes(map[string]int{
+		"keep.example.com":   port,
+		"local.example.com":  port,
+		"custom.example.com": port,
+	})
+	p.SetHostHeaders(map[string]string{
+		"local.example.com":  HostHeaderRewrite,
+		"custom.example.com": "app.internal:8080",
+	})
+
+	for host, want := range map[string]string{
+		"keep.example.com":   "keep.example.com keep.example.com",
+		"local.example.com":  "localhost local.example.com",
+		"custom.example.com": "app.internal:8080 custom.example.com",
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Host = host
+		rec := httptest.NewRecorder()
+		p.handleRequest(rec, req)
+		if rec.Body.String() != want {
+			t.Errorf("Host %s: expected upstream %q, got %q", host, want, rec.Body.String())
+		}
+	}
+
+	for policy, valid := range map[string]bool{"": true, "preserve": true, "rewrite": true, "app.internal": true, "app.internal:8080": true, "bad host": false, "app:x": false} {
+		if err := ValidateHostHeader(policy); (err == nil) != valid {
+			t.Errorf("ValidateHostHeader(%q) = %v, want valid=%t", policy, err, valid)
+		}
+	}
+	t.Logf("✓ Host header preserved, rewritten or replaced per route")
+}
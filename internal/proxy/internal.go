@@ -3,6 +3,7 @@ package proxy
 import (
 	"context"
 	"fmt"
+	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
@@ -11,11 +12,17 @@ import (
 	"time"
 )
 
+// InternalAddr is where the internal proxy listens; *.svc.internal names
+// resolve to it.
+const InternalAddr = "127.0.0.1:80"
+
 // InternalProxy routes requests by Host header for service-to-service communication
 type InternalProxy struct {
-	routes map[string]int // service name -> port
-	server *http.Server
-	mu     sync.RWMutex
+	routes   map[string]int // service name -> port
+	canaries *CanaryRouter
+	server   *http.Server
+	listener net.Listener
+	mu       sync.RWMutex
 }
 
 // NewInternalProxy creates a new internal reverse proxy
@@ -32,16 +39,32 @@ func (p *InternalProxy) UpdateRoutes(routes map[string]int) {
 	p.routes = routes
 }
 
+// SetCanaryRouter makes the proxy divert traffic to canary deploys.
+func (p *InternalProxy) SetCanaryRouter(router *CanaryRouter) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.canaries = router
+}
+
+// SetListener makes Start serve on listener, e.g. one bound by a privileged
+// helper, instead of binding InternalAddr itself.
+func (p *InternalProxy) SetListener(listener net.Listener) {
+	p.listener = listener
+}
+
 // Start starts the internal proxy server on port 80
 func (p *InternalProxy) Start() error {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", p.handleRequest)
 
 	p.server = &http.Server{
-		Addr:    "127.0.0.1:80",
+		Addr:    InternalAddr,
 		Handler: mux,
 	}
 
+	if p.listener != nil {
+		return p.server.Serve(p.listener)
+	}
 	return p.server.ListenAndServe()
 }
 
@@ -77,6 +100,7 @@ func (p *InternalProxy) handleRequest(w http.ResponseWriter, r *http.Request) {
 
 	p.mu.RLock()
 	port, exists := p.routes[serviceName]
+	canaries := p.canaries
 	p.mu.RUnlock()
 
 	if !exists {
@@ -85,6 +109,7 @@ func (p *InternalProxy) handleRequest(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Proxy the request
+	port, isCanary := canaries.route(port)
 	targetURL, _ := url.Parse(fmt.Sprintf("http://127.0.0.1:%d", port))
 	proxy := httputil.NewSingleHostReverseProxy(targetURL)
 
@@ -93,7 +118,16 @@ func (p *InternalProxy) handleRequest(w http.ResponseWriter, r *http.Request) {
 	r.Header.Set("X-Forwarded-Proto", "http")
 	r.Header.Set("X-Forwarded-For", r.RemoteAddr)
 
-	proxy.ServeHTTP(w, r)
+	if !isCanary {
+		proxy.ServeHTTP(w, r)
+		return
+	}
+	recorder := &statusRecorder{ResponseWriter: w}
+	proxy.ServeHTTP(recorder, r)
+	if recorder.status == 0 {
+		recorder.status = http.StatusOK
+	}
+	canaries.record(port, recorder.status)
 }
 
 // GetServiceURL returns the internal URL for a service
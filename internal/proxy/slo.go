@@ -0,0 +1,133 @@
+package proxy
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// sloHistoryMinutes is how far back SLO counts are kept, in one-minute
+// buckets.
+const sloHistoryMinutes = 60
+
+// SLOCounts are the requests to a route over a window, with those that
+// failed (5xx or no response) and those slower than the route's latency
+// threshold.
+type SLOCounts struct {
+	Requests uint64
+	Errors   uint64
+	Slow     uint64
+}
+
+// sloSeries is a ring of per-minute counts for one route.
+type sloSeries struct {
+	minutes [sloHistoryMinutes]int64
+	counts  [sloHistoryMinutes]SLOCounts
+}
+
+// sloTracker counts requests per route for SLO compliance.
+type sloTracker struct {
+	mu         sync.Mutex
+	now        func() time.Time
+	thresholds map[string]time.Duration
+	series     map[string]*sloSeries
+}
+
+func newSLOTracker() *sloTracker {
+	return &sloTracker{
+		now:        time.Now,
+		thresholds: make(map[string]time.Duration),
+		series:     make(map[string]*sloSeries),
+	}
+}
+
+func (t *sloTracker) record(key string, status int, elapsed time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	series, ok := t.series[key]
+	if !ok {
+		series = &sloSeries{}
+		t.series[key] = series
+	}
+	minute := t.now().Unix() / 60
+	slot := minute % sloHistoryMinutes
+	if series.minutes[slot] != minute {
+		series.minutes[slot] = minute
+		series.counts[slot] = SLOCounts{}
+	}
+	counts := &series.counts[slot]
+	counts.Requests++
+	if status >= http.StatusInternalServerError {
+		counts.Errors++
+	}
+	if threshold := t.thresholds[key]; threshold > 0 && elapsed > threshold {
+		counts.Slow++
+	}
+}
+
+func (t *sloTracker) counts(key string, window time.Duration) SLOCounts {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var total SLOCounts
+	series, ok := t.series[key]
+	if !ok {
+		return total
+	}
+	current := t.now().Unix() / 60
+	minutes := int64(window / time.Minute)
+	for slot, minute := range series.minutes {
+		if minute > current-minutes && minute <= current {
+			total.Requests += series.counts[slot].Requests
+			total.Errors += series.counts[slot].Errors
+			total.Slow += series.counts[slot].Slow
+		}
+	}
+	return total
+}
+
+// SetLatencyThresholds sets the latency above which a request to a route
+// (hostname or pattern -> threshold) counts as slow.
+func (p *ExternalProxy) SetLatencyThresholds(thresholds map[string]time.Duration) {
+	p.slo.mu.Lock()
+	defer p.slo.mu.Unlock()
+
+	next := make(map[string]time.Duration, len(thresholds))
+	for k, v := range thresholds {
+		next[k] = v
+	}
+	p.slo.thresholds = next
+}
+
+// SLOCounts returns a route's request counts over the last window, at most
+// one hour, including the current minute.
+func (p *ExternalProxy) SLOCounts(route string, window time.Duration) SLOCounts {
+	return p.slo.counts(route, window)
+}
+
+// statusRecorder captures the status code written by the upstream.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
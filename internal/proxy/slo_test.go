@@ -0,0 +1,68 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSLOTrackerWindows(t *testing.T) {
+	t.Logf("Testing rolling SLO counts")
+
+	now := time.Unix(1_700_000_000, 0)
+	tracker := newSLOTracker()
+	tracker.now = func() time.Time { return now }
+	tracker.thresholds["api.example.com"] = 100 * time.Millisecond
+
+	tracker.record("api.example.com", http.StatusOK, 50*time.Millisecond)
+	tracker.record("api.example.com", http.StatusBadGateway, 10*time.Millisecond)
+	now = now.Add(10 * time.Minute)
+	tracker.record("api.example.com", http.StatusOK, 200*time.Millisecond)
+
+	if got := tracker.counts("api.example.com", 5*time.Minute); got != (SLOCounts{Requests: 1, Slow: 1}) {
+		t.Errorf("Unexpected 5m counts %+v", got)
+	}
+	if got := tracker.counts("api.example.com", time.Hour); got != (SLOCounts{Requests: 3, Errors: 1, Slow: 1}) {
+		t.Errorf("Unexpected 1h counts %+v", got)
+	}
+
+	// The ring slot of the first minute is reused an hour later.
+	now = now.Add(50 * time.Minute)
+	tracker.record("api.example.com", http.StatusOK, time.Millisecond)
+	if got := tracker.counts("api.example.com", time.Hour); got != (SLOCounts{Requests: 2, Slow: 1}) {
+		t.Errorf("Expected expired minutes dropped, got %+v", got)
+	}
+	t.Logf("✓ SLO counts roll over their windows")
+}
+
+func TestExternalProxyRecordsSLOCounts(t *testing.T) {
+	t.Logf("Testing SLO counts recorded for proxied requests")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+	port, _ := strconv.Atoi(server.URL[strings.LastIndex(server.URL, ":")+1:])
+
+	p := NewExternalProxy(0, "127.0.0.1")
+	p.UpdateRoutes(map[string]int{"api.example.com": port})
+	for _, path := range []string{"/", "/fail", "/"} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Host = "api.example.com"
+		p.handleRequest(httptest.NewRecorder(), req)
+	}
+	warm := httptest.NewRequest(http.MethodGet, "/fail", nil)
+	warm.Host = "api.example.com"
+	warm.Header.Set(warmupHeader, "1")
+	p.handleRequest(httptest.NewRecorder(), warm)
+
+	if got := p.SLOCounts("api.example.com", time.Hour); got.Requests != 3 || got.Errors != 1 {
+		t.Errorf("Expected 3 requests with 1 error, got %+v", got)
+	}
+	t.Logf("✓ Proxied requests counted, warm-ups ignored")
+}
@@ -0,0 +1,70 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// WarmupTimeout bounds each warm-up request; cold JIT paths can be slow.
+const WarmupTimeout = 30 * time.Second
+
+// warmupHeader marks warm-up requests so they are not counted as traffic.
+const warmupHeader = "X-Potato-Warmup"
+
+// WarmResult is the outcome of one warm-up request.
+type WarmResult struct {
+	Path       string
+	StatusCode int
+	Latency    time.Duration
+	Err        error
+}
+
+// Warm requests each path for host through the proxy, one at a time, so that
+// lazily initialised apps (JVM, Next.js) compile their hot paths before real
+// users hit them. Responses are drained and discarded; any status counts as
+// warmed since the goal is exercising the code path. A wildcard host is warmed
+// through its "warmup" subdomain.
+func (p *ExternalProxy) Warm(host string, paths []string) []WarmResult {
+	if suffix, ok := strings.CutPrefix(host, "*."); ok {
+		host = "warmup." + suffix
+	}
+
+	client := &http.Client{
+		Timeout: WarmupTimeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	results := make([]WarmResult, 0, len(paths))
+	for _, path := range paths {
+		if !strings.HasPrefix(path, "/") {
+			path = "/" + path
+		}
+		result := WarmResult{Path: path}
+		req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("http://127.0.0.1:%d%s", p.port, path), nil)
+		if err != nil {
+			result.Err = err
+			results = append(results, result)
+			continue
+		}
+		req.Host = host
+		req.Header.Set(warmupHeader, "1")
+
+		start := time.Now()
+		resp, err := client.Do(req)
+		if err != nil {
+			result.Err = err
+		} else {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			result.StatusCode = resp.StatusCode
+		}
+		result.Latency = time.Since(start)
+		results = append(results, result)
+	}
+	return results
+}
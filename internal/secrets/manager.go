@@ -16,6 +16,16 @@ import (
 	"golang.org/x/crypto/pbkdf2"
 )
 
+// AgentScope is the service ID under which agent-wide secrets are stored.
+// They are never injected into service containers.
+const AgentScope = "_agent"
+
+// GroupScope returns the service ID under which secrets shared by a service
+// group are stored.
+func GroupScope(group string) string {
+	return "_group-" + group
+}
+
 // Manager handles secure storage of secrets on the agent
 type Manager struct {
 	secretsDir string
@@ -0,0 +1,141 @@
+package service
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/buildvigil/agent/internal/api"
+)
+
+// SetBuildDir makes image builds run from a throwaway copy of each
+// repository under dir, e.g. on tmpfs or a fast disk, rather than from the
+// repository itself. A service's build_dir overrides it; empty builds in
+// place.
+func (m *Manager) SetBuildDir(dir string) error {
+	dir = strings.TrimSpace(dir)
+	if dir != "" && !filepath.IsAbs(dir) {
+		return fmt.Errorf("build_dir must be an absolute path: %q", dir)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.buildDir = dir
+	return nil
+}
+
+// prepareBuildDir copies repoPath, without .git, into a new directory under
+// the service's build dir and returns it, with a cleanup func to call once
+// the build succeeds. Failed builds leave their copy for inspection until
+// the service's next build. Without a build dir, repoPath is returned as is.
+func (m *Manager) prepareBuildDir(service api.Service, repoPath string) (string, func(), error) {
+	m.mu.RLock()
+	base := m.buildDir
+	m.mu.RUnlock()
+	if dir := strings.TrimSpace(service.BuildDir); dir != "" {
+		base = dir
+	}
+	if base == "" {
+		return repoPath, func() {}, nil
+	}
+	if !filepath.IsAbs(base) {
+		return "", nil, fmt.Errorf("build_dir must be an absolute path: %q", base)
+	}
+
+	start := time.Now()
+	removeBuildDirs(base, service.ID)
+	if err := os.MkdirAll(base, 0755); err != nil {
+		return "", nil, fmt.Errorf("failed to create build dir: %w", err)
+	}
+	dir, err := os.MkdirTemp(base, buildDirPrefix(service.ID))
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create build dir: %w", err)
+	}
+	if err := copyTree(repoPath, dir); err != nil {
+		os.RemoveAll(dir)
+		return "", nil, fmt.Errorf("failed to copy repository to build dir: %w", err)
+	}
+	log.Printf("[ServiceManager] Build dir prepared: service=%s dir=%s elapsed=%s", service.ID, dir, time.Since(start))
+	return dir, func() {
+		if err := os.RemoveAll(dir); err != nil {
+			log.Printf("[ServiceManager] Failed to remove build dir: service=%s dir=%s error=%v", service.ID, dir, err)
+		}
+	}, nil
+}
+
+func buildDirPrefix(serviceID string) string {
+	return serviceID + "-build-"
+}
+
+// removeBuildDirs removes copies left under base by the service's earlier
+// builds.
+func removeBuildDirs(base, serviceID string) {
+	matches, _ := filepath.Glob(filepath.Join(base, buildDirPrefix(serviceID)+"*"))
+	for _, match := range matches {
+		if err := os.RemoveAll(match); err != nil {
+			log.Printf("[ServiceManager] Failed to remove old build dir: service=%s dir=%s error=%v", serviceID, match, err)
+		}
+	}
+}
+
+// copyTree copies the files, directories and symlinks under src into dst,
+// skipping .git.
+func copyTree(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		if entry.Name() == ".git" {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		target := filepath.Join(dst, rel)
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		switch {
+		case entry.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm())
+		case info.Mode()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case info.Mode().IsRegular():
+			return copyFile(path, target, info.Mode().Perm())
+		}
+		return nil
+	})
+}
+
+func copyFile(src, dst string, mode fs.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
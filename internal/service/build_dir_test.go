@@ -0,0 +1,70 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/buildvigil/agent/internal/api"
+)
+
+func TestPrepareBuildDir(t *testing.T) {
+	t.Logf("Testing builds from a throwaway copy of the repository")
+
+	repo := t.TempDir()
+	os.MkdirAll(filepath.Join(repo, ".git", "objects"), 0755)
+	os.MkdirAll(filepath.Join(repo, "src"), 0755)
+	os.WriteFile(filepath.Join(repo, "src", "main.go"), []byte("package main\n"), 0644)
+	os.WriteFile(filepath.Join(repo, "run.sh"), []byte("#!/bin/sh\n"), 0755)
+	os.Symlink("src/main.go", filepath.Join(repo, "link.go"))
+
+	m := NewManager(t.TempDir(), nil, nil, 3000, 3010, false)
+	svc := api.Service{ID: "svc-1"}
+	dir, cleanup, err := m.prepareBuildDir(svc, repo)
+	if err != nil || dir != repo {
+		t.Fatalf("Expected in-place build without a build dir, got %s (%v)", dir, err)
+	}
+	cleanup()
+
+	base := t.TempDir()
+	if err := m.SetBuildDir(base); err != nil {
+		t.Fatalf("SetBuildDir failed: %v", err)
+	}
+	dir, _, err = m.prepareBuildDir(svc, repo)
+	if err != nil {
+		t.Fatalf("prepareBuildDir failed: %v", err)
+	}
+	if filepath.Dir(dir) != base {
+		t.Errorf("Expected copy under %s, got %s", base, dir)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "link.go")); err != nil || string(data) != "package main\n" {
+		t.Errorf("Expected files and symlinks copied, got %q (%v)", data, err)
+	}
+	if info, err := os.Stat(filepath.Join(dir, "run.sh")); err != nil || info.Mode().Perm() != 0755 {
+		t.Errorf("Expected file mode kept, got %v (%v)", info, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".git")); !os.IsNotExist(err) {
+		t.Errorf("Expected .git to be skipped")
+	}
+
+	// A failed build leaves its copy until the next build of the service.
+	next, nextCleanup, err := m.prepareBuildDir(svc, repo)
+	if err != nil {
+		t.Fatalf("prepareBuildDir failed: %v", err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("Expected previous build dir removed")
+	}
+	nextCleanup()
+	if _, err := os.Stat(next); !os.IsNotExist(err) {
+		t.Errorf("Expected build dir removed after a successful build")
+	}
+
+	if _, _, err := m.prepareBuildDir(api.Service{ID: "svc-2", BuildDir: "relative/dir"}, repo); err == nil {
+		t.Error("Expected a relative service build_dir to be rejected")
+	}
+	if err := m.SetBuildDir("tmp/builds"); err == nil {
+		t.Error("Expected a relative build_dir to be rejected")
+	}
+	t.Logf("✓ Builds run from a copy that is cleaned up")
+}
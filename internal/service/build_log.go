@@ -0,0 +1,66 @@
+package service
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"github.com/buildvigil/agent/internal/api"
+	"github.com/buildvigil/agent/internal/state"
+)
+
+// BuildLogLines is how many trailing lines of Docker build output are kept
+// for each build.
+const BuildLogLines = 2000
+
+// BuildLogReporter receives the output of each image build once it is
+// stored (e.g. to upload it).
+type BuildLogReporter func(buildLog api.BuildLog)
+
+// SetBuildLogReporter sets a reporter that receives each build's output.
+func (m *Manager) SetBuildLogReporter(reporter BuildLogReporter) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.buildLogs = reporter
+}
+
+// recordBuildLog stores a finished build's output under the deploy in
+// progress and passes it to the build log reporter.
+func (m *Manager) recordBuildLog(service api.Service, output *lineTail, started time.Time, status string) {
+	deployID := m.deployID
+	if deployID == "" {
+		deployID = newDeployID(service, started)
+	} else {
+		m.deployBuilt = true
+	}
+	buildLog := api.BuildLog{
+		ServiceID:  service.ID,
+		DeployID:   deployID,
+		GitCommit:  service.GitCommit,
+		Status:     status,
+		Builder:    m.builderName(),
+		Output:     strings.Join(output.Lines(), "\n"),
+		Truncated:  output.Truncated(),
+		StartedAt:  started.UTC(),
+		FinishedAt: time.Now().UTC(),
+	}
+	if m.state != nil {
+		err := m.state.SaveBuildLog(&state.BuildLog{
+			ServiceID:  buildLog.ServiceID,
+			DeployID:   buildLog.DeployID,
+			GitCommit:  buildLog.GitCommit,
+			Status:     buildLog.Status,
+			Builder:    buildLog.Builder,
+			Output:     buildLog.Output,
+			Truncated:  buildLog.Truncated,
+			StartedAt:  buildLog.StartedAt,
+			FinishedAt: buildLog.FinishedAt,
+		})
+		if err != nil {
+			log.Printf("[ServiceManager] Failed to store build log: service=%s deploy=%s err=%v", service.ID, deployID, err)
+		}
+	}
+	if m.buildLogs != nil {
+		m.buildLogs(buildLog)
+	}
+}
@@ -0,0 +1,58 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/buildvigil/agent/internal/api"
+	"github.com/buildvigil/agent/internal/state"
+	"github.com/buildvigil/agent/internal/testutil"
+)
+
+func TestBuildLogsRecordedPerDeploy(t *testing.T) {
+	t.Logf("Testing that build output is stored and reported per deploy")
+
+	docker := testutil.NewFakeDocker(t)
+	stateMgr, err := state.NewManager(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to create state manager: %v", err)
+	}
+	defer stateMgr.Close()
+	reposPath := t.TempDir()
+	m := NewManager(reposPath, stateMgr, nil, 3000, 3010, false)
+	var reported []api.BuildLog
+	m.SetBuildLogReporter(func(buildLog api.BuildLog) { reported = append(reported, buildLog) })
+
+	svc := api.Service{ID: "svc-1", Name: "web", GitURL: "https://example.com/web.git", GitCommit: "abc1234def"}
+	if err := os.MkdirAll(filepath.Join(reposPath, svc.ID), 0755); err != nil {
+		t.Fatalf("Failed to create repo dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(reposPath, svc.ID, "Dockerfile"), []byte("FROM scratch\n"), 0644); err != nil {
+		t.Fatalf("Failed to write Dockerfile: %v", err)
+	}
+
+	m.deployID = "deploy-ok"
+	if _, err := m.buildServiceImage(svc, "potato-cloud-svc-1:latest"); err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	docker.BuildShouldFail.Store(true)
+	m.deployID = "deploy-bad"
+	if _, err := m.buildServiceImage(svc, "potato-cloud-svc-1:latest"); err == nil {
+		t.Fatalf("Expected the build to fail")
+	}
+
+	ok, _ := stateMgr.GetBuildLog(svc.ID, "deploy-ok")
+	if ok == nil || ok.Status != "succeeded" || !strings.Contains(ok.Output, "Step 1/1") || ok.GitCommit != "abc1234def" {
+		t.Errorf("Unexpected successful build log: %+v", ok)
+	}
+	bad, _ := stateMgr.GetBuildLog(svc.ID, "")
+	if bad == nil || bad.DeployID != "deploy-bad" || bad.Status != "failed" || !strings.Contains(bad.Output, "fake build failed") {
+		t.Errorf("Unexpected failed build log: %+v", bad)
+	}
+	if len(reported) != 2 || reported[1].Status != "failed" || reported[1].Builder != "local" {
+		t.Errorf("Expected both builds reported, got %+v", reported)
+	}
+	t.Logf("✓ Build output stored under each deploy ID and reported")
+}
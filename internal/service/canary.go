@@ -0,0 +1,167 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/buildvigil/agent/internal/api"
+)
+
+// Canary defaults for services that leave them unset.
+const (
+	DefaultCanaryBake         = 5 * time.Minute
+	DefaultCanaryMaxErrorRate = 0.05
+)
+
+// canaryMinRequests is how many requests a canary must serve before its
+// error rate is judged.
+const canaryMinRequests = 20
+
+// canaryCheckInterval is how often a baking canary is checked.
+var canaryCheckInterval = 10 * time.Second
+
+// ErrCanaryFailed is wrapped by deploy errors from a canary that was rolled
+// back.
+var ErrCanaryFailed = errors.New("canary failed")
+
+// ErrCanaryBaking is wrapped by errors from deploying or stopping a service
+// whose canary is baking.
+var ErrCanaryBaking = errors.New("canary deploy in progress")
+
+// CanaryRouter diverts part of a service's traffic to the new container of a
+// canary deploy. The agent's proxies implement it.
+type CanaryRouter interface {
+	SetCanary(stablePort, canaryPort, weight int)
+	ClearCanary(stablePort int)
+	CanaryCounts(canaryPort int) (requests, errors uint64)
+}
+
+// SetCanaryRouter sets the router canary deploys shift traffic with. Without
+// one, services with a canary are deployed blue/green as usual.
+func (m *Manager) SetCanaryRouter(router CanaryRouter) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.canaryRouter = router
+}
+
+// validateCanary checks a service's canary settings before a deploy.
+func validateCanary(service api.Service) error {
+	canary := service.Canary
+	if canary == nil {
+		return nil
+	}
+	if canary.Weight < 1 || canary.Weight > 99 {
+		return fmt.Errorf("invalid canary weight %d; use a percentage from 1 to 99", canary.Weight)
+	}
+	if canary.BakeSeconds < 0 {
+		return fmt.Errorf("invalid canary bake_seconds %d", canary.BakeSeconds)
+	}
+	if canary.MaxErrorRate < 0 || canary.MaxErrorRate > 1 {
+		return fmt.Errorf("invalid canary max_error_rate %g; use a fraction from 0 to 1", canary.MaxErrorRate)
+	}
+	return nil
+}
+
+// canaryBake returns how long a service's canary is observed.
+func canaryBake(canary *api.ServiceCanary) time.Duration {
+	if canary.BakeSeconds > 0 {
+		return time.Duration(canary.BakeSeconds) * time.Second
+	}
+	return DefaultCanaryBake
+}
+
+// canaryMaxErrorRate returns the highest error rate a service's canary may
+// show.
+func canaryMaxErrorRate(canary *api.ServiceCanary) float64 {
+	if canary.MaxErrorRate > 0 {
+		return canary.MaxErrorRate
+	}
+	return DefaultCanaryMaxErrorRate
+}
+
+// runCanary sends the service's canary weight of the traffic for activePort
+// to the new container on canaryPort for the bake period, checking its health
+// and error rate as it goes. It returns an ErrCanaryFailed error when the
+// canary should be rolled back. Traffic goes back to activePort either way;
+// the caller cuts over to a promoted canary. Callers hold mu, which is
+// released while the canary bakes.
+func (m *Manager) runCanary(service api.Service, activePort int, canaryName string, canaryPort int) error {
+	canary := service.Canary
+	bake := canaryBake(canary)
+	maxErrorRate := canaryMaxErrorRate(canary)
+	m.reportLifecycle(service, "canary", "unknown", "")
+	m.canaryRouter.SetCanary(activePort, canaryPort, canary.Weight)
+	defer m.canaryRouter.ClearCanary(activePort)
+	log.Printf("[ServiceManager] Canary start: service=%s weight=%d%% bake=%s max_error_rate=%g port=%d", service.ID, canary.Weight, bake, maxErrorRate, canaryPort)
+	m.logDeploy(service.ID, "", "info", "Canary on port %d serving %d%% of traffic for %s", canaryPort, canary.Weight, bake)
+
+	requests, failed, err := m.bakeCanary(service, canaryName, canaryPort, bake, maxErrorRate)
+	if err != nil {
+		return err
+	}
+	log.Printf("[ServiceManager] Canary passed: service=%s requests=%d errors=%d", service.ID, requests, failed)
+	m.logDeploy(service.ID, "", "info", "Canary passed: %d requests, %d errors", requests, failed)
+	return nil
+}
+
+// bakeCanary watches a canary for the bake period and returns the requests
+// it served and how many failed. It releases mu while it waits, so other
+// services can be deployed and stopped meanwhile; the service itself is
+// marked as baking, which refuses its deploys and stops.
+func (m *Manager) bakeCanary(service api.Service, canaryName string, canaryPort int, bake time.Duration, maxErrorRate float64) (uint64, uint64, error) {
+	deadline := m.deployDeadline
+	saved := m.currentDeploy()
+	m.containersMu.Lock()
+	if m.baking == nil {
+		m.baking = make(map[string]bool)
+	}
+	m.baking[service.ID] = true
+	m.containersMu.Unlock()
+	m.mu.Unlock()
+	defer func() {
+		m.mu.Lock()
+		m.restoreDeploy(saved)
+		m.containersMu.Lock()
+		delete(m.baking, service.ID)
+		m.containersMu.Unlock()
+	}()
+
+	end := time.Now().Add(bake)
+	for {
+		wait := canaryCheckInterval
+		if remaining := time.Until(end); remaining < wait {
+			wait = remaining
+		}
+		if !deadline.IsZero() && time.Until(deadline) < wait {
+			wait = time.Until(deadline)
+		}
+		if wait > 0 {
+			time.Sleep(wait)
+		}
+		if !deadline.IsZero() && !time.Now().Before(deadline) {
+			return 0, 0, fmt.Errorf("%w (%s)", ErrDeployTimeout, maxDeployDuration(service))
+		}
+
+		requests, failed := m.canaryRouter.CanaryCounts(canaryPort)
+		if healthy, err := m.probeContainer(service, canaryName, canaryPort); err != nil || !healthy {
+			return requests, failed, fmt.Errorf("%w: container unhealthy after %d requests", ErrCanaryFailed, requests)
+		}
+		if requests >= canaryMinRequests {
+			if rate := float64(failed) / float64(requests); rate > maxErrorRate {
+				return requests, failed, fmt.Errorf("%w: error rate %.1f%% over %d requests exceeds %.1f%%", ErrCanaryFailed, rate*100, requests, maxErrorRate*100)
+			}
+		}
+		if !time.Now().Before(end) {
+			return requests, failed, nil
+		}
+	}
+}
+
+// isBaking reports whether a canary of the service is baking.
+func (m *Manager) isBaking(serviceID string) bool {
+	m.containersMu.RLock()
+	defer m.containersMu.RUnlock()
+	return m.baking[serviceID]
+}
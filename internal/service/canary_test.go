@@ -0,0 +1,95 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/buildvigil/agent/internal/api"
+)
+
+type fakeCanaryRouter struct {
+	set      map[int][2]int // stable port -> canary port, weight
+	requests uint64
+	errors   uint64
+}
+
+func (r *fakeCanaryRouter) SetCanary(stablePort, canaryPort, weight int) {
+	r.set[stablePort] = [2]int{canaryPort, weight}
+}
+
+func (r *fakeCanaryRouter) ClearCanary(stablePort int) {
+	delete(r.set, stablePort)
+}
+
+func (r *fakeCanaryRouter) CanaryCounts(canaryPort int) (uint64, uint64) {
+	return r.requests, r.errors
+}
+
+func TestRunCanary(t *testing.T) {
+	t.Logf("Testing canaries are promoted or rolled back on health and error rate...")
+	originalStatus, originalInterval := getContainerStatus, canaryCheckInterval
+	defer func() { getContainerStatus, canaryCheckInterval = originalStatus, originalInterval }()
+	canaryCheckInterval = 10 * time.Millisecond
+	status := "running"
+	getContainerStatus = func(string) (string, error) { return status, nil }
+
+	router := &fakeCanaryRouter{set: make(map[int][2]int)}
+	m := NewManager(t.TempDir(), nil, nil, 3000, 3010, false)
+	m.canaryRouter = router
+	service := api.Service{ID: "web", Canary: &api.ServiceCanary{Weight: 10, BakeSeconds: 1, MaxErrorRate: 0.1}}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	router.requests, router.errors = 100, 5
+	stopped := make(chan error, 1)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		stopped <- m.StopService("web")
+	}()
+	start := time.Now()
+	if err := m.runCanary(service, 3000, "potato-cloud-web-green", 3001); err != nil {
+		t.Fatalf("Expected a healthy canary to pass, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("Expected the canary to bake for a second, took %s", elapsed)
+	}
+	select {
+	case err := <-stopped:
+		if !errors.Is(err, ErrCanaryBaking) {
+			t.Errorf("Expected stopping a baking service to be refused, got %v", err)
+		}
+	default:
+		t.Errorf("Expected the manager to be free while the canary baked")
+	}
+	if m.isBaking("web") {
+		t.Errorf("Expected the service no longer baking")
+	}
+	if len(router.set) != 0 {
+		t.Errorf("Expected the canary route cleared, got %v", router.set)
+	}
+
+	router.requests, router.errors = 100, 20
+	start = time.Now()
+	err := m.runCanary(service, 3000, "potato-cloud-web-green", 3001)
+	if !errors.Is(err, ErrCanaryFailed) || time.Since(start) > 500*time.Millisecond {
+		t.Fatalf("Expected a canary over its error rate to fail early, got %v", err)
+	}
+	if classifyDeployError(err) != api.DeployErrorCanary || len(router.set) != 0 {
+		t.Errorf("Expected a canary_failed class and the route cleared, got %s %v", classifyDeployError(err), router.set)
+	}
+
+	router.requests, router.errors = 5, 5
+	status = "exited"
+	if err := m.runCanary(service, 3000, "potato-cloud-web-green", 3001); !errors.Is(err, ErrCanaryFailed) {
+		t.Fatalf("Expected an exited canary to fail, got %v", err)
+	}
+
+	for _, canary := range []api.ServiceCanary{{Weight: 0}, {Weight: 100}, {Weight: 10, BakeSeconds: -1}, {Weight: 10, MaxErrorRate: 2}} {
+		if err := validateCanary(api.Service{Canary: &canary}); err == nil {
+			t.Errorf("Expected canary %+v to be rejected", canary)
+		}
+	}
+	t.Logf("✓ Canaries promoted, rolled back and validated")
+}
@@ -0,0 +1,174 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/buildvigil/agent/internal/api"
+)
+
+const (
+	maxConfigFileBytes    = 64 << 10
+	maxConfigFilesBytes   = 1 << 20
+	defaultConfigFileMode = 0644
+)
+
+// SetConfigFilesDir configures where services' config files are written.
+func (m *Manager) SetConfigFilesDir(dir string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.configFilesDir = dir
+}
+
+// validateConfigFiles checks config file paths, sizes and modes.
+func validateConfigFiles(service api.Service) error {
+	seen := make(map[string]bool, len(service.ConfigFiles))
+	total := 0
+	for _, file := range service.ConfigFiles {
+		if !filepath.IsAbs(file.Path) || filepath.Clean(file.Path) != file.Path || file.Path == "/" {
+			return fmt.Errorf("invalid config file path %q; expected a clean absolute path", file.Path)
+		}
+		if seen[file.Path] {
+			return fmt.Errorf("duplicate config file path %q", file.Path)
+		}
+		seen[file.Path] = true
+		if len(file.Content) > maxConfigFileBytes {
+			return fmt.Errorf("config file %s is larger than %d bytes", file.Path, maxConfigFileBytes)
+		}
+		total += len(file.Content)
+		if _, err := configFileMode(file); err != nil {
+			return err
+		}
+	}
+	if total > maxConfigFilesBytes {
+		return fmt.Errorf("config files total %d bytes; limit is %d", total, maxConfigFilesBytes)
+	}
+	return nil
+}
+
+func configFileMode(file api.ConfigFile) (os.FileMode, error) {
+	if strings.TrimSpace(file.Mode) == "" {
+		return defaultConfigFileMode, nil
+	}
+	mode, err := strconv.ParseUint(strings.TrimSpace(file.Mode), 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("invalid mode %q for config file %s; expected octal like 0644", file.Mode, file.Path)
+	}
+	return os.FileMode(mode), nil
+}
+
+// configFilesHash fingerprints a service's config files. Each distinct set is
+// written to its own directory, so a blue container keeps its files while
+// green starts with the new ones.
+func configFilesHash(files []api.ConfigFile) string {
+	h := sha256.New()
+	for _, file := range files {
+		fmt.Fprintf(h, "%s\x00%s\x00%d\x00%s\x00", file.Path, file.Mode, len(file.Content), file.Content)
+	}
+	return hex.EncodeToString(h.Sum(nil))[:12]
+}
+
+// writeConfigFiles writes a service's config files under
+// <configs>/<service>/<hash>/ and returns the directory.
+func (m *Manager) writeConfigFiles(service api.Service) (string, error) {
+	if m.configFilesDir == "" {
+		return "", fmt.Errorf("config files directory not configured")
+	}
+	dir := filepath.Join(m.configFilesDir, service.ID, configFilesHash(service.ConfigFiles))
+	for _, file := range service.ConfigFiles {
+		mode, err := configFileMode(file)
+		if err != nil {
+			return "", err
+		}
+		hostPath := filepath.Join(dir, filepath.FromSlash(strings.TrimPrefix(file.Path, "/")))
+		if err := os.MkdirAll(filepath.Dir(hostPath), 0750); err != nil {
+			return "", fmt.Errorf("failed to create config file directory: %w", err)
+		}
+		tmp := hostPath + ".tmp"
+		if err := os.WriteFile(tmp, []byte(file.Content), mode); err != nil {
+			return "", fmt.Errorf("failed to write config file %s: %w", file.Path, err)
+		}
+		if err := os.Chmod(tmp, mode); err != nil {
+			return "", fmt.Errorf("failed to set mode on config file %s: %w", file.Path, err)
+		}
+		if err := os.Rename(tmp, hostPath); err != nil {
+			return "", fmt.Errorf("failed to write config file %s: %w", file.Path, err)
+		}
+	}
+	return dir, nil
+}
+
+// configFileArgs writes a service's config files and returns the read-only
+// bind mounts for them.
+func (m *Manager) configFileArgs(service api.Service) ([]string, error) {
+	if len(service.ConfigFiles) == 0 {
+		return nil, nil
+	}
+	dir, err := m.writeConfigFiles(service)
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, 0, 2*len(service.ConfigFiles))
+	for _, file := range service.ConfigFiles {
+		hostPath := filepath.Join(dir, filepath.FromSlash(strings.TrimPrefix(file.Path, "/")))
+		args = append(args, "-v", hostPath+":"+file.Path+":ro")
+	}
+	return args, nil
+}
+
+// serviceRunArgs returns containerRunArgs plus the service's resource limits,
+// config file mounts and volumes.
+func (m *Manager) serviceRunArgs(service api.Service) ([]string, error) {
+	args, err := containerRunArgs(service)
+	if err != nil {
+		return nil, err
+	}
+	limits, err := m.resourceLimitArgs(service)
+	if err != nil {
+		return nil, err
+	}
+	args = append(args, limits...)
+	mounts, err := m.configFileArgs(service)
+	if err != nil {
+		return nil, err
+	}
+	args = append(args, mounts...)
+	volumes, err := m.volumeArgs(service)
+	if err != nil {
+		return nil, err
+	}
+	return append(args, volumes...), nil
+}
+
+// pruneConfigFiles removes config file sets other than the service's current
+// one once no container uses them. With keepCurrent false every set goes.
+func (m *Manager) pruneConfigFiles(service api.Service, keepCurrent bool) {
+	if m.configFilesDir == "" || service.ID == "" {
+		return
+	}
+	serviceDir := filepath.Join(m.configFilesDir, service.ID)
+	if !keepCurrent || len(service.ConfigFiles) == 0 {
+		if err := os.RemoveAll(serviceDir); err != nil {
+			m.logVerbose("Failed to remove config files for %s: %v", service.ID, err)
+		}
+		return
+	}
+	entries, err := os.ReadDir(serviceDir)
+	if err != nil {
+		return
+	}
+	current := configFilesHash(service.ConfigFiles)
+	for _, entry := range entries {
+		if entry.Name() == current {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(serviceDir, entry.Name())); err != nil {
+			m.logVerbose("Failed to remove old config files for %s: %v", service.ID, err)
+		}
+	}
+}
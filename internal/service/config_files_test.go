@@ -0,0 +1,78 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/buildvigil/agent/internal/api"
+)
+
+func TestValidateConfigFiles(t *testing.T) {
+	t.Logf("Testing config file validation...")
+	cases := []struct {
+		name    string
+		files   []api.ConfigFile
+		wantErr string
+	}{
+		{"valid", []api.ConfigFile{{Path: "/etc/nginx/nginx.conf", Content: "events {}"}, {Path: "/app/run.sh", Mode: "0755"}}, ""},
+		{"relative", []api.ConfigFile{{Path: "etc/app.yaml"}}, "clean absolute path"},
+		{"traversal", []api.ConfigFile{{Path: "/etc/../root/.ssh/authorized_keys"}}, "clean absolute path"},
+		{"duplicate", []api.ConfigFile{{Path: "/a"}, {Path: "/a"}}, "duplicate"},
+		{"mode", []api.ConfigFile{{Path: "/a", Mode: "rw-r--r--"}}, "invalid mode"},
+		{"too large", []api.ConfigFile{{Path: "/a", Content: strings.Repeat("x", maxConfigFileBytes+1)}}, "larger than"},
+	}
+	for _, tc := range cases {
+		err := validateConfigFiles(api.Service{ConfigFiles: tc.files})
+		if tc.wantErr == "" && err != nil {
+			t.Errorf("%s: unexpected error %v", tc.name, err)
+		}
+		if tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)) {
+			t.Errorf("%s: expected error containing %q, got %v", tc.name, tc.wantErr, err)
+		}
+	}
+	t.Logf("✓ Config files validated")
+}
+
+func TestConfigFileArgs_WritesAndPrunes(t *testing.T) {
+	t.Logf("Testing config files are written per content set and mounted...")
+	dir := t.TempDir()
+	m := NewManager(t.TempDir(), nil, nil, 3000, 3010, false)
+	m.SetConfigFilesDir(dir)
+
+	v1 := api.Service{ID: "web", ConfigFiles: []api.ConfigFile{{Path: "/etc/nginx/nginx.conf", Content: "worker_processes 1;"}}}
+	args, err := m.configFileArgs(v1)
+	if err != nil {
+		t.Fatalf("Failed to write config files: %v", err)
+	}
+	hostPath := filepath.Join(dir, "web", configFilesHash(v1.ConfigFiles), "etc", "nginx", "nginx.conf")
+	if len(args) != 2 || args[0] != "-v" || args[1] != hostPath+":/etc/nginx/nginx.conf:ro" {
+		t.Fatalf("Unexpected mount args: %v", args)
+	}
+	if data, _ := os.ReadFile(hostPath); string(data) != "worker_processes 1;" {
+		t.Errorf("Unexpected file content %q", data)
+	}
+
+	v2 := api.Service{ID: "web", ConfigFiles: []api.ConfigFile{{Path: "/etc/nginx/nginx.conf", Content: "worker_processes 4;"}}}
+	if _, err := m.configFileArgs(v2); err != nil {
+		t.Fatalf("Failed to write new config files: %v", err)
+	}
+	if _, err := os.Stat(hostPath); err != nil {
+		t.Errorf("Expected previous set to stay until the deploy completes: %v", err)
+	}
+	m.pruneConfigFiles(v2, true)
+	if _, err := os.Stat(hostPath); !os.IsNotExist(err) {
+		t.Errorf("Expected previous set to be pruned, got %v", err)
+	}
+	entries, _ := os.ReadDir(filepath.Join(dir, "web"))
+	if len(entries) != 1 || entries[0].Name() != configFilesHash(v2.ConfigFiles) {
+		t.Errorf("Expected only the current set to remain, got %v", entries)
+	}
+
+	m.pruneConfigFiles(api.Service{ID: "web"}, false)
+	if _, err := os.Stat(filepath.Join(dir, "web")); !os.IsNotExist(err) {
+		t.Errorf("Expected service config directory to be removed, got %v", err)
+	}
+	t.Logf("✓ Config files written, mounted and pruned")
+}
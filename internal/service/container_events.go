@@ -0,0 +1,192 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ServiceLabel marks the containers the agent deploys for a service.
+const ServiceLabel = "potato-cloud.service"
+
+// agentActionWindow is how long after the agent touches a container its
+// docker events are attributed to the agent rather than to an operator.
+const agentActionWindow = 15 * time.Second
+
+var streamContainerEvents = defaultStreamContainerEvents
+
+// agentActions records when the agent last started, stopped, renamed or
+// signalled each container.
+var agentActions = struct {
+	sync.Mutex
+	at map[string]time.Time
+}{at: make(map[string]time.Time)}
+
+// noteAgentAction marks containers as just changed by the agent.
+func noteAgentAction(containerNames ...string) {
+	agentActions.Lock()
+	defer agentActions.Unlock()
+	now := time.Now()
+	for name, at := range agentActions.at {
+		if now.Sub(at) > agentActionWindow {
+			delete(agentActions.at, name)
+		}
+	}
+	for _, name := range containerNames {
+		agentActions.at[strings.TrimPrefix(name, "/")] = now
+	}
+	forgetContainerStatus(containerNames...)
+}
+
+func agentActedRecently(containerName string) bool {
+	agentActions.Lock()
+	defer agentActions.Unlock()
+	at, ok := agentActions.at[strings.TrimPrefix(containerName, "/")]
+	return ok && time.Since(at) <= agentActionWindow
+}
+
+// containerEvent is a docker container event as printed by
+// `docker events --format '{{json .}}'`.
+type containerEvent struct {
+	Action string `json:"Action"`
+	Actor  struct {
+		ID         string            `json:"ID"`
+		Attributes map[string]string `json:"Attributes"`
+	} `json:"Actor"`
+}
+
+func defaultStreamContainerEvents(ctx context.Context, handle func(containerEvent)) error {
+	cmd := exec.CommandContext(ctx, "docker", "events",
+		"--filter", "type=container",
+		"--filter", "label="+ServiceLabel,
+		"--format", "{{json .}}")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("failed to open docker events: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start docker events: %w", err)
+	}
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		var event containerEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			continue
+		}
+		handle(event)
+	}
+	return cmd.Wait()
+}
+
+// WatchContainerEvents follows docker events for agent-managed containers
+// until stop is closed, keeping cached container statuses current and
+// recording changes made outside the agent.
+func (m *Manager) WatchContainerEvents(stop <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-stop
+		cancel()
+	}()
+
+	for {
+		setWatchingContainerEvents(true)
+		err := streamContainerEvents(ctx, m.handleContainerEvent)
+		setWatchingContainerEvents(false)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("[ServiceManager] Docker events stream ended, restarting: err=%v", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(10 * time.Second):
+		}
+	}
+}
+
+// handleContainerEvent records an event as an out-of-band change when an
+// operator, not the agent or docker's restart policy, changed the container,
+// and records a container that exited on its own as a crash restart. Docker
+// reports an oom event just before the die of a container the kernel killed
+// for exceeding its memory limit.
+func (m *Manager) handleContainerEvent(event containerEvent) {
+	attrs := event.Actor.Attributes
+	serviceID := attrs[ServiceLabel]
+	name := attrs["name"]
+	action := event.Action
+	if serviceID == "" || name == "" || strings.HasPrefix(action, "exec_") || strings.HasPrefix(action, "health_status") {
+		return
+	}
+	updateContainerStatus(name, action, attrs)
+
+	m.eventsMu.Lock()
+	if m.lastContainerAction == nil {
+		m.lastContainerAction = make(map[string]string)
+	}
+	previous := m.lastContainerAction[name]
+	m.lastContainerAction[name] = action
+	if action == "destroy" {
+		delete(m.lastContainerAction, name)
+	}
+	m.eventsMu.Unlock()
+
+	if agentActedRecently(name) || (action == "rename" && agentActedRecently(attrs["oldName"])) {
+		return
+	}
+	switch action {
+	case "die":
+		// An operator's docker stop or docker kill signals the container
+		// first; any other exit is a crash.
+		if previous != "kill" {
+			m.recordCrash(serviceID, name, attrs["exitCode"], previous == "oom")
+		}
+		return
+	case "stop", "restart", "pause", "unpause", "update", "rename", "destroy":
+	case "kill":
+		// docker stop sends SIGTERM first and reports its own stop event.
+		if attrs["signal"] == "15" {
+			return
+		}
+	case "start":
+		// Only a start after an operator's stop; docker's restart policy
+		// starts containers after they die.
+		if previous != "stop" {
+			return
+		}
+	default:
+		return
+	}
+
+	log.Printf("[ServiceManager] Warning: out-of-band container change: service=%s container=%s action=%s", serviceID, name, action)
+	if m.state == nil {
+		return
+	}
+	if err := m.state.RecordEvent(serviceID, "out_of_band_change", fmt.Sprintf("container=%s action=%s", name, action)); err != nil {
+		m.logVerbose("Failed to record out-of-band event for %s: %v", serviceID, err)
+	}
+}
+
+// recordCrash records a crash restart, counted in heartbeats and by the crash
+// budget alert.
+func (m *Manager) recordCrash(serviceID, containerName, exitCode string, oomKilled bool) {
+	if oomKilled {
+		log.Printf("[ServiceManager] Warning: container killed for exceeding its memory limit: service=%s container=%s exit_code=%s", serviceID, containerName, exitCode)
+	} else {
+		log.Printf("[ServiceManager] Container exited unexpectedly: service=%s container=%s exit_code=%s", serviceID, containerName, exitCode)
+	}
+	if m.state == nil {
+		return
+	}
+	code, _ := strconv.Atoi(exitCode)
+	if err := m.state.RecordServiceRestart(serviceID, containerName, code, oomKilled, time.Now()); err != nil {
+		m.logVerbose("Failed to record restart for %s: %v", serviceID, err)
+	}
+}
@@ -0,0 +1,78 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/buildvigil/agent/internal/state"
+)
+
+func TestHandleContainerEvent_OutOfBand(t *testing.T) {
+	t.Logf("Testing out-of-band container changes are told apart from the agent's own...")
+	stateMgr, err := state.NewManager(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to create state manager: %v", err)
+	}
+	defer stateMgr.Close()
+	m := NewManager(t.TempDir(), stateMgr, nil, 3000, 3010, false)
+
+	send := func(name, action string, attrs map[string]string) {
+		event := containerEvent{Action: action}
+		event.Actor.Attributes = map[string]string{ServiceLabel: "web", "name": name}
+		for key, value := range attrs {
+			event.Actor.Attributes[key] = value
+		}
+		m.handleContainerEvent(event)
+	}
+	recorded := func() []string {
+		events, err := stateMgr.ListRecentEvents(20)
+		if err != nil {
+			t.Fatalf("Failed to read events: %v", err)
+		}
+		var messages []string
+		for _, event := range events {
+			if event.EventType == "out_of_band_change" {
+				messages = append(messages, event.Message)
+			}
+		}
+		return messages
+	}
+
+	// The agent's own blue/green cutover is not reported.
+	noteAgentAction("potato-cloud-web")
+	send("potato-cloud-web", "kill", map[string]string{"signal": "15"})
+	send("potato-cloud-web", "die", nil)
+	send("potato-cloud-web", "stop", nil)
+	// A crash restarted by docker's restart policy is not reported either.
+	send("potato-cloud-api", "die", map[string]string{"exitCode": "1"})
+	send("potato-cloud-api", "start", nil)
+	if got := recorded(); len(got) != 0 {
+		t.Fatalf("Expected no out-of-band events, got %v", got)
+	}
+
+	// An operator's docker stop and docker start are.
+	send("potato-cloud-api", "kill", map[string]string{"signal": "15"})
+	send("potato-cloud-api", "die", nil)
+	send("potato-cloud-api", "stop", nil)
+	send("potato-cloud-api", "start", nil)
+	send("potato-cloud-api", "exec_start: sh", nil)
+	got := recorded()
+	if len(got) != 2 {
+		t.Fatalf("Expected stop and start to be recorded, got %v", got)
+	}
+
+	// Only the exits nobody asked for count as crash restarts, and the one
+	// after an oom event as an OOM kill.
+	send("potato-cloud-api", "oom", nil)
+	send("potato-cloud-api", "die", map[string]string{"exitCode": "137"})
+	if got := recorded(); len(got) != 2 {
+		t.Errorf("Expected an OOM kill not to be recorded as out-of-band, got %v", got)
+	}
+	if count, err := stateMgr.CountServiceRestarts("web", time.Now().Add(-time.Hour)); err != nil || count != 2 {
+		t.Errorf("Expected two crash restarts, got %d (err=%v)", count, err)
+	}
+	if count, err := stateMgr.CountServiceOOMKills("web", time.Now().Add(-time.Hour)); err != nil || count != 1 {
+		t.Errorf("Expected one OOM kill, got %d (err=%v)", count, err)
+	}
+	t.Logf("✓ Out-of-band changes recorded")
+}
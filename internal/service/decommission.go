@@ -0,0 +1,60 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Decommission stops every service the agent runs and removes the networks
+// of stackIDs, any other stack networks, and the images the agent built. It
+// carries on past failures and returns them together.
+func (m *Manager) Decommission(stackIDs []string) error {
+	var errs []error
+	procs, err := m.state.ListServiceProcesses()
+	if err != nil {
+		errs = append(errs, fmt.Errorf("failed to list services: %w", err))
+	}
+	for _, proc := range procs {
+		if err := m.StopService(proc.ServiceID); err != nil {
+			errs = append(errs, fmt.Errorf("failed to stop service %s: %w", proc.ServiceID, err))
+			continue
+		}
+		m.ForgetTask(proc.ServiceID)
+		log.Printf("[ServiceManager] Decommission: stopped service=%s", proc.ServiceID)
+	}
+
+	stacks := append([]string(nil), stackIDs...)
+	if networks, err := ListStackNetworks(); err == nil {
+		for _, network := range networks {
+			stackID := strings.TrimSuffix(strings.TrimPrefix(network.Name, "stack-"), "-network")
+			stacks = append(stacks, stackID)
+		}
+	} else {
+		errs = append(errs, err)
+	}
+	seen := make(map[string]bool)
+	for _, stackID := range stacks {
+		if seen[stackID] {
+			continue
+		}
+		seen[stackID] = true
+		if err := DeleteStackNetwork(stackID); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	images, err := listAgentImages()
+	if err != nil {
+		errs = append(errs, fmt.Errorf("failed to list images: %w", err))
+	}
+	for _, img := range images {
+		if err := removeImage(img.ID); err != nil {
+			errs = append(errs, fmt.Errorf("failed to remove image %s: %w", img.Tag, err))
+			continue
+		}
+		log.Printf("[ServiceManager] Decommission: removed image=%s", img.Tag)
+	}
+	return errors.Join(errs...)
+}
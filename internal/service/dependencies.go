@@ -0,0 +1,170 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/buildvigil/agent/internal/api"
+)
+
+// ErrDependencyUnavailable is wrapped by deploy errors caused by a failed
+// dependency check.
+var ErrDependencyUnavailable = errors.New("dependency unavailable")
+
+// DefaultDependencyTimeout bounds a dependency check that sets no timeout.
+const DefaultDependencyTimeout = 5 * time.Second
+
+// dsnDefaultPorts are used when a DSN URL names no port.
+var dsnDefaultPorts = map[string]string{
+	"postgres":   "5432",
+	"postgresql": "5432",
+	"mysql":      "3306",
+	"redis":      "6379",
+	"rediss":     "6379",
+	"mongodb":    "27017",
+	"amqp":       "5672",
+	"amqps":      "5671",
+}
+
+// goMySQLAddress matches the address in a go-sql-driver DSN such as
+// user:pass@tcp(db:3306)/app.
+var goMySQLAddress = regexp.MustCompile(`@tcp\(([^)]+)\)`)
+
+// validateDependencies checks dependency definitions before a deploy.
+func validateDependencies(service api.Service) error {
+	seen := make(map[string]bool, len(service.Dependencies))
+	for _, dep := range service.Dependencies {
+		if !sidecarNamePattern.MatchString(dep.Name) {
+			return fmt.Errorf("invalid dependency name %q; use lowercase letters, digits and dashes", dep.Name)
+		}
+		if seen[dep.Name] {
+			return fmt.Errorf("duplicate dependency name %q", dep.Name)
+		}
+		seen[dep.Name] = true
+		hasTCP, hasHTTP := strings.TrimSpace(dep.TCP) != "", strings.TrimSpace(dep.HTTP) != ""
+		if hasTCP == hasHTTP {
+			return fmt.Errorf("dependency %s: set exactly one of tcp or http", dep.Name)
+		}
+		if dep.Timeout < 0 {
+			return fmt.Errorf("dependency %s: invalid timeout %d", dep.Name, dep.Timeout)
+		}
+	}
+	return nil
+}
+
+// dependencyAddress returns the host:port to dial for a tcp dependency given
+// as host:port or as a database DSN.
+func dependencyAddress(target string) (string, error) {
+	target = strings.TrimSpace(target)
+	if match := goMySQLAddress.FindStringSubmatch(target); match != nil {
+		target = match[1]
+	} else if strings.Contains(target, "://") {
+		u, err := url.Parse(target)
+		if err != nil || u.Hostname() == "" {
+			return "", fmt.Errorf("cannot parse DSN")
+		}
+		port := u.Port()
+		if port == "" {
+			port = dsnDefaultPorts[strings.ToLower(u.Scheme)]
+		}
+		if port == "" {
+			return "", fmt.Errorf("DSN scheme %q has no default port", u.Scheme)
+		}
+		return net.JoinHostPort(u.Hostname(), port), nil
+	}
+	host, port, err := net.SplitHostPort(target)
+	if err != nil || host == "" {
+		return "", fmt.Errorf("expected host:port or a DSN")
+	}
+	if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+		return "", fmt.Errorf("invalid port %q", port)
+	}
+	return target, nil
+}
+
+// checkDependency runs one dependency check. Errors name the address or URL
+// host only, so credentials in a DSN are not logged.
+func checkDependency(dep api.Dependency, lookup func(string) string) error {
+	timeout := DefaultDependencyTimeout
+	if dep.Timeout > 0 {
+		timeout = time.Duration(dep.Timeout) * time.Second
+	}
+
+	if target := strings.TrimSpace(dep.TCP); target != "" {
+		address, err := dependencyAddress(os.Expand(target, lookup))
+		if err != nil {
+			return fmt.Errorf("invalid tcp target: %w", err)
+		}
+		conn, err := net.DialTimeout("tcp", address, timeout)
+		if err != nil {
+			return fmt.Errorf("%s unreachable: %w", address, err)
+		}
+		conn.Close()
+		return nil
+	}
+
+	target := os.Expand(strings.TrimSpace(dep.HTTP), lookup)
+	u, err := url.Parse(target)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid http target; expected an http(s) URL")
+	}
+	client := &http.Client{
+		Timeout: timeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Get(target)
+	if err != nil {
+		return fmt.Errorf("GET %s failed: %w", u.Host, errors.Unwrap(err))
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned %d, expected 200", u.Host, resp.StatusCode)
+	}
+	return nil
+}
+
+// checkDependencies verifies a service's dependencies before its new
+// container starts, so a deploy fails with a clear reason instead of the app
+// crash-looping. Targets may reference the service's environment variables
+// and secrets, e.g. "tcp": "$DATABASE_URL".
+func (m *Manager) checkDependencies(service api.Service) error {
+	if len(service.Dependencies) == 0 {
+		return nil
+	}
+	vars := make(map[string]string, len(service.EnvironmentVars)+len(service.Secrets))
+	for key, value := range service.EnvironmentVars {
+		vars[key] = value
+	}
+	for key, value := range m.serviceSecrets(service) {
+		vars[key] = value
+	}
+	lookup := func(key string) string { return vars[key] }
+
+	var failures []string
+	for _, dep := range service.Dependencies {
+		start := time.Now()
+		if err := checkDependency(dep, lookup); err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", dep.Name, err))
+			continue
+		}
+		log.Printf("[ServiceManager] Dependency ready: service=%s dependency=%s elapsed=%s", service.ID, dep.Name, time.Since(start).Round(time.Millisecond))
+	}
+	if len(failures) == 0 {
+		return nil
+	}
+	err := fmt.Errorf("%w: %s", ErrDependencyUnavailable, strings.Join(failures, "; "))
+	m.logDeploy(service.ID, "", "error", "Dependency check failed: %v", err)
+	m.reportLifecycle(service, "dependency_unavailable", "unknown", err.Error())
+	return err
+}
@@ -0,0 +1,120 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/buildvigil/agent/internal/api"
+	containerpkg "github.com/buildvigil/agent/internal/container"
+)
+
+// ContainerLogCaptureLines is how many trailing lines of container output are
+// saved to service_logs when a container is stopped or fails its health check.
+const ContainerLogCaptureLines = 500
+
+var fetchContainerLogs = defaultFetchContainerLogs
+
+// newDeployID identifies a deploy by its start time and short commit, so IDs
+// sort chronologically and are recognisable in log listings.
+func newDeployID(service api.Service, now time.Time) string {
+	id := now.UTC().Format("20060102T150405Z")
+	commit := strings.TrimSpace(service.GitCommit)
+	if len(commit) > 7 {
+		commit = commit[:7]
+	}
+	if commit != "" {
+		id += "-" + commit
+	}
+	return id
+}
+
+// logDeploy records a lifecycle message for the deploy in progress, tagged
+// with its deploy ID and, once known, the container ID.
+func (m *Manager) logDeploy(serviceID, containerID, level, format string, args ...interface{}) {
+	if m.state == nil || m.deployID == "" {
+		return
+	}
+	message := fmt.Sprintf(format, args...)
+	if err := m.state.LogServiceMessageTagged(serviceID, m.deployID, containerID, level, message); err != nil {
+		m.logVerbose("Failed to record deploy log for %s: %v", serviceID, err)
+	}
+}
+
+// captureContainerLogs copies a container's recent output into service_logs,
+// tagged with the deploy and container that produced it, so it can still be
+// reviewed after the container is gone.
+func (m *Manager) captureContainerLogs(serviceID, deployID, containerID, containerName string) {
+	if m.state == nil || strings.TrimSpace(containerName) == "" {
+		return
+	}
+	lines, err := fetchContainerLogs(containerName, ContainerLogCaptureLines)
+	if err != nil {
+		m.logVerbose("Failed to capture logs for %s: %v", containerName, err)
+		return
+	}
+	for _, line := range lines {
+		if err := m.state.LogServiceMessageTagged(serviceID, deployID, containerID, line.level, line.message); err != nil {
+			m.logVerbose("Failed to store captured log for %s: %v", serviceID, err)
+			return
+		}
+	}
+	log.Printf("[ServiceManager] Container logs captured: service=%s deploy=%s container=%s lines=%d", serviceID, deployID, containerName, len(lines))
+}
+
+type containerLogLine struct {
+	at      time.Time
+	level   string
+	message string
+}
+
+// defaultFetchContainerLogs returns the tail of a container's stdout (info)
+// and stderr (error) interleaved by timestamp.
+func defaultFetchContainerLogs(containerName string, tail int) ([]containerLogLine, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), containerpkg.DockerTimeout(containerpkg.DockerInspect))
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "docker", "logs", "--timestamps", "--tail", strconv.Itoa(tail), containerName)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("docker logs timed out after %s", containerpkg.DockerTimeout(containerpkg.DockerInspect))
+		}
+		return nil, fmt.Errorf("docker logs failed: %w (output: %s)", err, strings.TrimSpace(stderr.String()))
+	}
+
+	lines := append(parseContainerLogLines(stdout.String(), "info"), parseContainerLogLines(stderr.String(), "error")...)
+	sort.SliceStable(lines, func(i, j int) bool { return lines[i].at.Before(lines[j].at) })
+	return lines, nil
+}
+
+// parseContainerLogLines splits `docker logs --timestamps` output into lines,
+// stripping the leading RFC3339 timestamp.
+func parseContainerLogLines(output, level string) []containerLogLine {
+	var lines []containerLogLine
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		text := scanner.Text()
+		line := containerLogLine{level: level, message: text}
+		if stamp, rest, ok := strings.Cut(text, " "); ok {
+			if at, err := time.Parse(time.RFC3339Nano, stamp); err == nil {
+				line.at = at
+				line.message = rest
+			}
+		}
+		if strings.TrimSpace(line.message) == "" {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
@@ -0,0 +1,40 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/buildvigil/agent/internal/api"
+)
+
+func TestNewDeployID(t *testing.T) {
+	t.Logf("Testing deploy ID format...")
+
+	at := time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC)
+	if got := newDeployID(api.Service{GitCommit: "0123456789abcdef"}, at); got != "20260304T050607Z-0123456" {
+		t.Errorf("Unexpected deploy ID: %s", got)
+	}
+	if got := newDeployID(api.Service{}, at); got != "20260304T050607Z" {
+		t.Errorf("Unexpected deploy ID without commit: %s", got)
+	}
+	t.Logf("✓ Deploy IDs include start time and short commit")
+}
+
+func TestParseContainerLogLines(t *testing.T) {
+	t.Logf("Testing docker logs output parsing...")
+
+	output := "2026-01-01T00:00:01.000000001Z listening on :8080\n" +
+		"2026-01-01T00:00:02Z \n" +
+		"no timestamp here\n"
+	lines := parseContainerLogLines(output, "info")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 lines (blank skipped), got %+v", lines)
+	}
+	if lines[0].message != "listening on :8080" || lines[0].at.IsZero() || lines[0].level != "info" {
+		t.Errorf("Expected timestamp stripped, got %+v", lines[0])
+	}
+	if lines[1].message != "no timestamp here" {
+		t.Errorf("Expected untimestamped line kept verbatim, got %+v", lines[1])
+	}
+	t.Logf("✓ Container log lines parsed")
+}
@@ -0,0 +1,88 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/buildvigil/agent/internal/api"
+)
+
+// DeployReporter receives the outcome of each DeployService attempt.
+type DeployReporter func(report api.DeployReport)
+
+// SetDeployReporter sets a reporter that receives each deploy's outcome.
+func (m *Manager) SetDeployReporter(reporter DeployReporter) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.deployReports = reporter
+}
+
+// reportDeploy passes the outcome of the deploy in progress to the deploy
+// reporter. Callers hold mu.
+func (m *Manager) reportDeploy(service api.Service, started time.Time, imageID, errorClass string, err error) {
+	if m.deployReports == nil {
+		return
+	}
+	finished := time.Now()
+	report := api.DeployReport{
+		ServiceID:   service.ID,
+		ServiceName: service.Name,
+		DeployID:    m.deployID,
+		GitCommit:   service.GitCommit,
+		ImageID:     imageID,
+		Result:      "succeeded",
+		StartedAt:   started.UTC(),
+		FinishedAt:  finished.UTC(),
+		DurationMS:  finished.Sub(started).Milliseconds(),
+	}
+	if m.deployBuilt {
+		report.BuildLog = m.deployID
+	}
+	report.Rollback = m.rollbackImage != ""
+	if err != nil {
+		report.Result = "failed"
+		report.Error = err.Error()
+		report.ErrorClass = errorClass
+		if errorClass == "" {
+			report.ErrorClass = classifyDeployError(err)
+		}
+	}
+	m.deployReports(report)
+}
+
+// classifyDeployError sorts a deploy failure into one of the
+// api.DeployError* classes by its cause and the step that wrapped it.
+func classifyDeployError(err error) string {
+	switch {
+	case errors.Is(err, ErrDeployTimeout):
+		return api.DeployErrorTimeout
+	case errors.Is(err, ErrDependencyUnavailable):
+		return api.DeployErrorDependency
+	case errors.Is(err, ErrCanaryFailed):
+		return api.DeployErrorCanary
+	}
+	message := err.Error()
+	for _, class := range []struct {
+		substr string
+		class  string
+	}{
+		{"docker pull failed", api.DeployErrorPull},
+		{"invalid run_command", api.DeployErrorInvalidConfig},
+		{"docker_run_args", api.DeployErrorInvalidConfig},
+		{"exceeds the agent's cap", api.DeployErrorInvalidConfig},
+		{"not allowed on this agent", api.DeployErrorInvalidConfig},
+		{"failed to prepare", api.DeployErrorBuild},
+		{"allocate", api.DeployErrorPort},
+		{"health check", api.DeployErrorHealthCheck},
+		{"proxy update failed", api.DeployErrorProxy},
+		{"failed to start", api.DeployErrorStart},
+		{"container is not running", api.DeployErrorStart},
+		{"stack network", api.DeployErrorStart},
+	} {
+		if strings.Contains(message, class.substr) {
+			return class.class
+		}
+	}
+	return api.DeployErrorUnknown
+}
@@ -0,0 +1,31 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/buildvigil/agent/internal/api"
+)
+
+func TestClassifyDeployError(t *testing.T) {
+	t.Logf("Testing deploy failures sorted into error classes")
+
+	for err, want := range map[error]string{
+		fmt.Errorf("failed to prepare image: %w", fmt.Errorf("docker pull failed: %w", errors.New("exit status 1"))): api.DeployErrorPull,
+		fmt.Errorf("failed to prepare image: %w", errors.New("docker build failed")):                                 api.DeployErrorBuild,
+		fmt.Errorf("%w: http://127.0.0.1:34000/ still failing after 5 attempts", ErrDeployTimeout):                   api.DeployErrorTimeout,
+		fmt.Errorf("waiting for db: %w", ErrDependencyUnavailable):                                                   api.DeployErrorDependency,
+		fmt.Errorf("green container health check failed: %w", errors.New("status 500")):                              api.DeployErrorHealthCheck,
+		fmt.Errorf("failed to allocate port: %w", errors.New("no free ports")):                                       api.DeployErrorPort,
+		fmt.Errorf("failed to start container: %w", errors.New("exit status 125")):                                   api.DeployErrorStart,
+		fmt.Errorf("proxy update failed: %w", errors.New("route conflict")):                                          api.DeployErrorProxy,
+		fmt.Errorf("invalid docker_run_args: %w", errors.New("unterminated quote")):                                  api.DeployErrorInvalidConfig,
+		errors.New("something else"): api.DeployErrorUnknown,
+	} {
+		if got := classifyDeployError(err); got != want {
+			t.Errorf("classifyDeployError(%q) = %s, want %s", err, got, want)
+		}
+	}
+	t.Logf("✓ Failures classified by cause and step")
+}
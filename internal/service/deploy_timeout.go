@@ -0,0 +1,45 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/buildvigil/agent/internal/api"
+)
+
+// DefaultMaxDeployDuration bounds a whole deploy (build, health checks, drain)
+// when the service does not set max_deploy_duration.
+const DefaultMaxDeployDuration = 30 * time.Minute
+
+// ErrDeployTimeout is wrapped by errors returned from a deploy that ran past
+// its maximum duration and was aborted.
+var ErrDeployTimeout = errors.New("deploy exceeded maximum duration")
+
+func maxDeployDuration(service api.Service) time.Duration {
+	if service.MaxDeployDuration > 0 {
+		return time.Duration(service.MaxDeployDuration) * time.Second
+	}
+	return DefaultMaxDeployDuration
+}
+
+// checkDeployDeadline returns an ErrDeployTimeout error once the current
+// deploy's deadline has passed. Callers must hold m.mu.
+func (m *Manager) checkDeployDeadline(service api.Service) error {
+	if m.deployDeadline.IsZero() || time.Now().Before(m.deployDeadline) {
+		return nil
+	}
+	return fmt.Errorf("%w (%s)", ErrDeployTimeout, maxDeployDuration(service))
+}
+
+// sleepWithinDeadline sleeps for d, waking early at the deploy deadline.
+func (m *Manager) sleepWithinDeadline(d time.Duration) {
+	if !m.deployDeadline.IsZero() {
+		if remaining := time.Until(m.deployDeadline); remaining < d {
+			d = remaining
+		}
+	}
+	if d > 0 {
+		time.Sleep(d)
+	}
+}
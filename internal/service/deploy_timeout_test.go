@@ -0,0 +1,57 @@
+package service
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/buildvigil/agent/internal/api"
+)
+
+func TestHealthCheck_StopsAtDeployDeadline(t *testing.T) {
+	t.Logf("Testing deploy deadline during health checks...")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	_, portStr, _ := strings.Cut(strings.TrimPrefix(server.URL, "http://"), ":")
+	port, _ := strconv.Atoi(portStr)
+
+	m := NewManager(t.TempDir(), nil, nil, 3000, 3010, false)
+	m.deployDeadline = time.Now().Add(300 * time.Millisecond)
+
+	service := api.Service{ID: "svc", HealthCheckPath: "/health", HealthCheckInterval: 1}
+	start := time.Now()
+	err := m.healthCheck(service, "potato-cloud-svc", port)
+	if !errors.Is(err, ErrDeployTimeout) {
+		t.Fatalf("Expected ErrDeployTimeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected health check to stop at the deploy deadline, took %s", elapsed)
+	}
+
+	if err := m.checkDeployDeadline(service); !errors.Is(err, ErrDeployTimeout) {
+		t.Errorf("Expected expired deadline to be reported, got %v", err)
+	}
+	m.deployDeadline = time.Time{}
+	if err := m.checkDeployDeadline(service); err != nil {
+		t.Errorf("Expected no deadline outside a deploy, got %v", err)
+	}
+	t.Logf("✓ Health loop aborted at deploy deadline")
+}
+
+func TestMaxDeployDuration(t *testing.T) {
+	t.Logf("Testing max deploy duration defaults...")
+	if got := maxDeployDuration(api.Service{}); got != DefaultMaxDeployDuration {
+		t.Errorf("Expected default %s, got %s", DefaultMaxDeployDuration, got)
+	}
+	if got := maxDeployDuration(api.Service{MaxDeployDuration: 90}); got != 90*time.Second {
+		t.Errorf("Expected 90s, got %s", got)
+	}
+	t.Logf("✓ Max deploy duration resolved")
+}
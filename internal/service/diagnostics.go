@@ -0,0 +1,197 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/buildvigil/agent/internal/api"
+	containerpkg "github.com/buildvigil/agent/internal/container"
+)
+
+const (
+	diagnosticsBuildLogLines   = 200
+	diagnosticsServiceLogLines = 100
+	diagnosticsRetainPerSvc    = 10
+)
+
+// DiagnosticsReporter receives a diagnostics bundle after a failed deploy.
+type DiagnosticsReporter func(bundle api.DeployDiagnostics)
+
+// deployTrace accumulates diagnostics for the deploy currently in progress.
+type deployTrace struct {
+	buildLog         *lineTail
+	containerName    string
+	containerInspect string
+	healthCheck      []string
+}
+
+func newDeployTrace() *deployTrace {
+	return &deployTrace{buildLog: newLineTail(diagnosticsBuildLogLines)}
+}
+
+// lineTail is an io.Writer that keeps the last N complete lines written to it.
+type lineTail struct {
+	max     int
+	lines   []string
+	partial string
+	dropped int
+	mu      sync.Mutex
+}
+
+func newLineTail(max int) *lineTail {
+	return &lineTail{max: max}
+}
+
+func (t *lineTail) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	text := t.partial + string(p)
+	parts := strings.Split(text, "\n")
+	t.partial = parts[len(parts)-1]
+	for _, line := range parts[:len(parts)-1] {
+		t.lines = append(t.lines, strings.TrimRight(line, "\r"))
+	}
+	if len(t.lines) > t.max {
+		t.dropped += len(t.lines) - t.max
+		t.lines = append([]string(nil), t.lines[len(t.lines)-t.max:]...)
+	}
+	return len(p), nil
+}
+
+// Truncated reports whether earlier lines were dropped to stay within max.
+func (t *lineTail) Truncated() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.dropped > 0
+}
+
+// Lines returns the retained lines, including any unterminated trailing line.
+func (t *lineTail) Lines() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	out := append([]string(nil), t.lines...)
+	if t.partial != "" {
+		out = append(out, t.partial)
+	}
+	if len(out) > t.max {
+		out = out[len(out)-t.max:]
+	}
+	return out
+}
+
+// SetDiagnostics configures where failed-deploy bundles are written and an
+// optional reporter that receives each bundle (e.g. to upload it).
+func (m *Manager) SetDiagnostics(dir string, reporter DiagnosticsReporter) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.diagnosticsDir = dir
+	m.diagnostics = reporter
+}
+
+func (m *Manager) traceHealthCheck(format string, args ...interface{}) {
+	if m.trace == nil {
+		return
+	}
+	line := fmt.Sprintf("%s "+format, append([]interface{}{time.Now().UTC().Format(time.RFC3339)}, args...)...)
+	m.trace.healthCheck = append(m.trace.healthCheck, line)
+}
+
+// captureFailedContainer records docker inspect output for a container that is
+// about to be removed after a failed deploy step.
+func (m *Manager) captureFailedContainer(containerName string) {
+	if m.trace == nil || strings.TrimSpace(containerName) == "" {
+		return
+	}
+	m.trace.containerName = containerName
+	output, err := containerpkg.DockerCombinedOutput(containerpkg.DockerInspect, "inspect", containerName)
+	if err != nil {
+		m.trace.containerInspect = fmt.Sprintf("docker inspect failed: %v (output: %s)", err, strings.TrimSpace(string(output)))
+		return
+	}
+	m.trace.containerInspect = strings.TrimSpace(string(output))
+}
+
+// collectDiagnostics writes a diagnostics bundle for a failed deploy under the
+// diagnostics directory and hands it to the configured reporter.
+func (m *Manager) collectDiagnostics(service api.Service, deployErr error) {
+	if m.diagnosticsDir == "" && m.diagnostics == nil {
+		return
+	}
+
+	bundle := api.DeployDiagnostics{
+		ServiceID:   service.ID,
+		ServiceName: service.Name,
+		GitCommit:   service.GitCommit,
+		Error:       deployErr.Error(),
+		CollectedAt: time.Now().UTC(),
+	}
+	if m.trace != nil {
+		bundle.BuildLogTail = m.trace.buildLog.Lines()
+		bundle.ContainerName = m.trace.containerName
+		bundle.ContainerInspect = m.trace.containerInspect
+		bundle.HealthCheckTrace = append([]string(nil), m.trace.healthCheck...)
+	}
+
+	if logs, err := m.state.GetServiceLogs(service.ID, diagnosticsServiceLogLines); err == nil {
+		// Logs are returned newest first; store them oldest first.
+		for i := len(logs) - 1; i >= 0; i-- {
+			entry := logs[i]
+			bundle.ServiceLogs = append(bundle.ServiceLogs, fmt.Sprintf("[%s] %s: %s", entry.CreatedAt.Format("2006-01-02 15:04:05"), entry.Level, entry.Message))
+		}
+	} else {
+		m.logVerbose("Failed to read service logs for diagnostics %s: %v", service.ID, err)
+	}
+
+	if m.diagnosticsDir != "" {
+		path, err := writeDiagnosticsBundle(m.diagnosticsDir, bundle)
+		if err != nil {
+			log.Printf("[ServiceManager] Failed to write diagnostics bundle: service=%s err=%v", service.ID, err)
+		} else {
+			log.Printf("[ServiceManager] Diagnostics bundle written: service=%s path=%s", service.ID, path)
+		}
+		pruneDiagnosticsBundles(m.diagnosticsDir, service.ID, diagnosticsRetainPerSvc)
+	}
+
+	if m.diagnostics != nil {
+		m.diagnostics(bundle)
+	}
+}
+
+func writeDiagnosticsBundle(dir string, bundle api.DeployDiagnostics) (string, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create diagnostics directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(bundle, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal diagnostics: %w", err)
+	}
+
+	name := fmt.Sprintf("%s-%s.json", bundle.ServiceID, bundle.CollectedAt.Format("20060102T150405Z"))
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return "", fmt.Errorf("failed to write diagnostics: %w", err)
+	}
+	return path, nil
+}
+
+func pruneDiagnosticsBundles(dir, serviceID string, keep int) {
+	matches, err := filepath.Glob(filepath.Join(dir, serviceID+"-????????T??????Z.json"))
+	if err != nil || len(matches) <= keep {
+		return
+	}
+	// Timestamped names sort chronologically.
+	sort.Strings(matches)
+	for _, path := range matches[:len(matches)-keep] {
+		_ = os.Remove(path)
+	}
+}
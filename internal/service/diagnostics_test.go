@@ -0,0 +1,101 @@
+package service
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/buildvigil/agent/internal/api"
+	"github.com/buildvigil/agent/internal/state"
+)
+
+func TestLineTail_KeepsLastLines(t *testing.T) {
+	tail := newLineTail(3)
+
+	tail.Write([]byte("one\ntwo\nthr"))
+	tail.Write([]byte("ee\nfour\nfive"))
+
+	lines := tail.Lines()
+	expected := []string{"three", "four", "five"}
+	if len(lines) != len(expected) {
+		t.Fatalf("Expected %d lines, got %d: %v", len(expected), len(lines), lines)
+	}
+	for i := range expected {
+		if lines[i] != expected[i] {
+			t.Errorf("Line %d: expected %q, got %q", i, expected[i], lines[i])
+		}
+	}
+}
+
+func TestCollectDiagnostics_WritesBundle(t *testing.T) {
+	stateMgr, err := state.NewManager(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to create state manager: %v", err)
+	}
+	defer stateMgr.Close()
+
+	if err := stateMgr.LogServiceMessage("svc-1", "error", "listen: address in use"); err != nil {
+		t.Fatalf("Failed to log message: %v", err)
+	}
+
+	dir := t.TempDir()
+	var reported *api.DeployDiagnostics
+	m := NewManager(t.TempDir(), stateMgr, nil, 3000, 3010, false)
+	m.SetDiagnostics(dir, func(bundle api.DeployDiagnostics) {
+		reported = &bundle
+	})
+
+	m.trace = newDeployTrace()
+	m.trace.buildLog.Write([]byte("Step 1/3 : FROM alpine\nERROR: failed to solve\n"))
+	m.traceHealthCheck("attempt=%d status=%d", 1, 503)
+
+	m.collectDiagnostics(api.Service{ID: "svc-1", Name: "api"}, errors.New("health check failed"))
+
+	if reported == nil {
+		t.Fatal("Expected diagnostics reporter to be called")
+	}
+	if len(reported.BuildLogTail) != 2 {
+		t.Errorf("Expected 2 build log lines, got %d", len(reported.BuildLogTail))
+	}
+	if len(reported.HealthCheckTrace) != 1 {
+		t.Errorf("Expected 1 health check trace line, got %d", len(reported.HealthCheckTrace))
+	}
+	if len(reported.ServiceLogs) != 1 {
+		t.Errorf("Expected 1 service log line, got %d", len(reported.ServiceLogs))
+	}
+
+	matches, _ := filepath.Glob(filepath.Join(dir, "svc-1-*.json"))
+	if len(matches) != 1 {
+		t.Fatalf("Expected 1 bundle on disk, got %d", len(matches))
+	}
+	if info, err := os.Stat(matches[0]); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("Expected bundle with 0600 permissions, got %v (err=%v)", info.Mode().Perm(), err)
+	}
+}
+
+func TestPruneDiagnosticsBundles(t *testing.T) {
+	dir := t.TempDir()
+	names := []string{
+		"svc-1-20260101T000000Z.json",
+		"svc-1-20260102T000000Z.json",
+		"svc-1-20260103T000000Z.json",
+		"svc-1-extra-20260101T000000Z.json",
+	}
+	for _, name := range names {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("{}"), 0600); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	pruneDiagnosticsBundles(dir, "svc-1", 2)
+
+	if _, err := os.Stat(filepath.Join(dir, "svc-1-20260101T000000Z.json")); !os.IsNotExist(err) {
+		t.Error("Expected oldest bundle to be pruned")
+	}
+	for _, name := range names[1:] {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("Expected %s to be kept: %v", name, err)
+		}
+	}
+}
@@ -1,6 +1,8 @@
 package service
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os/exec"
 	"sort"
@@ -9,6 +11,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/buildvigil/agent/internal/chaos"
 	containerpkg "github.com/buildvigil/agent/internal/container"
 )
 
@@ -21,9 +24,13 @@ var (
 	renameContainer    = defaultRenameContainer
 	containerExists    = defaultContainerExists
 	getContainerStatus = defaultGetContainerStatus
+	containerImageID   = defaultContainerImageID
 	getMappedHostPort  = defaultGetMappedHostPort
 	listImages         = defaultListImages
 	removeImage        = defaultRemoveImage
+	tagImage           = defaultTagImage
+	runTaskContainer   = defaultRunTaskContainer
+	execInContainer    = defaultExecInContainer
 
 	stackNetworkOnce sync.Once
 	stackNetworkMgr  *containerpkg.StackNetworkManager
@@ -38,9 +45,13 @@ func initStackNetworkManager() error {
 }
 
 func defaultBuildImage(repoPath, dockerfilePath, imageTag string) error {
-	args := []string{"build", "-f", dockerfilePath, "-t", imageTag, repoPath}
-	cmd := exec.Command("docker", args...)
-	output, err := cmd.CombinedOutput()
+	chaos.Delay()
+	if chaos.Inject(chaos.BuildFailure) {
+		return chaos.Error(chaos.BuildFailure, "docker build failed")
+	}
+	args := append([]string{"build"}, proxyBuildArgs()...)
+	args = append(args, "-f", dockerfilePath, "-t", imageTag, repoPath)
+	output, err := containerpkg.DockerCombinedOutput(containerpkg.DockerBuild, args...)
 	if err != nil {
 		return fmt.Errorf("docker build failed: %w\nOutput: %s", err, strings.TrimSpace(string(output)))
 	}
@@ -48,6 +59,7 @@ func defaultBuildImage(repoPath, dockerfilePath, imageTag string) error {
 }
 
 func defaultRunContainer(imageTag, containerName string, port int, envVars, secrets map[string]string) (string, error) {
+	chaos.Delay()
 	_ = stopContainer(containerName)
 
 	args := []string{"run", "-d", "--name", containerName}
@@ -65,8 +77,7 @@ func defaultRunContainer(imageTag, containerName string, port int, envVars, secr
 
 	args = append(args, imageTag)
 
-	cmd := exec.Command("docker", args...)
-	output, err := cmd.CombinedOutput()
+	output, err := containerpkg.DockerCombinedOutput(containerpkg.DockerRun, args...)
 	if err != nil {
 		return "", fmt.Errorf("docker run failed: %w\nOutput: %s", err, strings.TrimSpace(string(output)))
 	}
@@ -78,10 +89,13 @@ func defaultStopContainer(containerName string) error {
 	if strings.TrimSpace(containerName) == "" {
 		return nil
 	}
+	noteAgentAction(containerName)
+	defer noteAgentAction(containerName)
+	chaos.Delay()
 
-	_, _ = exec.Command("docker", "stop", "-t", "10", containerName).CombinedOutput()
+	_, _ = containerpkg.DockerStopCombinedOutput(10*time.Second, "stop", "-t", "10", containerName)
 
-	output, err := exec.Command("docker", "rm", "-f", containerName).CombinedOutput()
+	output, err := containerpkg.DockerCombinedOutput(containerpkg.DockerStop, "rm", "-f", containerName)
 	if err != nil {
 		msg := string(output)
 		if strings.Contains(msg, "No such container") || strings.Contains(msg, "No such object") {
@@ -95,7 +109,9 @@ func defaultStopContainer(containerName string) error {
 func defaultRenameContainer(oldName, newName string) error {
 	_ = stopContainer(newName)
 
-	output, err := exec.Command("docker", "rename", oldName, newName).CombinedOutput()
+	chaos.Delay()
+	noteAgentAction(oldName, newName)
+	output, err := containerpkg.DockerCombinedOutput(containerpkg.DockerRun, "rename", oldName, newName)
 	if err != nil {
 		return fmt.Errorf("docker rename failed: %w\nOutput: %s", err, strings.TrimSpace(string(output)))
 	}
@@ -103,7 +119,8 @@ func defaultRenameContainer(oldName, newName string) error {
 }
 
 func defaultContainerExists(containerName string) bool {
-	return exec.Command("docker", "inspect", "--format", "{{.Id}}", containerName).Run() == nil
+	_, err := containerpkg.DockerOutput(containerpkg.DockerInspect, "inspect", "--format", "{{.Id}}", containerName)
+	return err == nil
 }
 
 func defaultGetContainerStatus(containerName string) (string, error) {
@@ -111,7 +128,7 @@ func defaultGetContainerStatus(containerName string) (string, error) {
 		return "stopped", nil
 	}
 
-	output, err := exec.Command("docker", "inspect", "--format", "{{.State.Status}}", containerName).CombinedOutput()
+	output, err := containerpkg.DockerCombinedOutput(containerpkg.DockerInspect, "inspect", "--format", "{{.State.Status}}", containerName)
 	if err != nil {
 		msg := string(output)
 		if strings.Contains(msg, "No such object") || strings.Contains(msg, "No such container") {
@@ -127,6 +144,14 @@ func defaultGetContainerStatus(containerName string) (string, error) {
 	return status, nil
 }
 
+func defaultContainerImageID(containerName string) (string, error) {
+	output, err := containerpkg.DockerCombinedOutput(containerpkg.DockerInspect, "inspect", "--format", "{{.Image}}", containerName)
+	if err != nil {
+		return "", fmt.Errorf("docker inspect failed: %w\nOutput: %s", err, strings.TrimSpace(string(output)))
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
 func defaultGetMappedHostPort(containerName string, containerPort int) (int, error) {
 	if strings.TrimSpace(containerName) == "" || containerPort <= 0 {
 		return 0, nil
@@ -134,7 +159,7 @@ func defaultGetMappedHostPort(containerName string, containerPort int) (int, err
 
 	portSpec := fmt.Sprintf("%d/tcp", containerPort)
 	formatArg := fmt.Sprintf("{{with index .NetworkSettings.Ports %q}}{{(index . 0).HostPort}}{{end}}", portSpec)
-	output, err := exec.Command("docker", "inspect", "--format", formatArg, containerName).CombinedOutput()
+	output, err := containerpkg.DockerCombinedOutput(containerpkg.DockerInspect, "inspect", "--format", formatArg, containerName)
 	if err != nil {
 		msg := string(output)
 		if strings.Contains(msg, "No such object") || strings.Contains(msg, "No such container") {
@@ -184,11 +209,11 @@ func defaultListImages(serviceID string) ([]ImageInfo, error) {
 }
 
 func dockerImagesByReference(reference string) ([]string, error) {
-	output, err := exec.Command(
-		"docker", "images",
+	output, err := containerpkg.DockerCombinedOutput(
+		containerpkg.DockerInspect, "images",
 		"--filter", "reference="+reference,
 		"--format", "{{.Repository}}:{{.Tag}}|{{.ID}}|{{.CreatedAt}}",
-	).CombinedOutput()
+	)
 	if err != nil {
 		return nil, err
 	}
@@ -209,13 +234,90 @@ func dockerImagesByReference(reference string) ([]string, error) {
 }
 
 func defaultRemoveImage(imageID string) error {
-	output, err := exec.Command("docker", "rmi", "-f", imageID).CombinedOutput()
+	output, err := containerpkg.DockerCombinedOutput(containerpkg.DockerStop, "rmi", "-f", imageID)
 	if err != nil {
 		return fmt.Errorf("docker rmi failed: %w\nOutput: %s", err, strings.TrimSpace(string(output)))
 	}
 	return nil
 }
 
+func defaultTagImage(imageID, tag string) error {
+	output, err := containerpkg.DockerCombinedOutput(containerpkg.DockerRun, "tag", imageID, tag)
+	if err != nil {
+		return fmt.Errorf("docker tag failed: %w\nOutput: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// defaultRemoveServiceSidecars force-removes every sidecar container labelled
+// with serviceID.
+func defaultRemoveServiceSidecars(serviceID string) error {
+	output, err := containerpkg.DockerOutput(containerpkg.DockerInspect, "ps", "-aq", "--filter", "label="+sidecarLabel+"="+serviceID)
+	if err != nil {
+		return fmt.Errorf("docker ps failed: %w", err)
+	}
+	ids := strings.Fields(string(output))
+	if len(ids) == 0 {
+		return nil
+	}
+	if output, err := containerpkg.DockerCombinedOutput(containerpkg.DockerStop, append([]string{"rm", "-f"}, ids...)...); err != nil {
+		return fmt.Errorf("docker rm failed: %w\nOutput: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// defaultExecInContainer runs command with sh -c inside a running container
+// and fails unless it exits zero.
+func defaultExecInContainer(containerName, command string) error {
+	output, err := containerpkg.DockerCombinedOutput(containerpkg.DockerRun, "exec", containerName, "sh", "-c", command)
+	if err != nil {
+		return fmt.Errorf("docker exec failed: %w\nOutput: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// defaultRunTaskContainer creates a container on the stack network, runs it
+// in the foreground until it exits or ctx expires, and removes it. It returns
+// the exit code and combined output.
+func defaultRunTaskContainer(ctx context.Context, stackID, name, imageID string, env, runArgs, command []string) (int, string, error) {
+	_ = stopContainer(name)
+	defer func() { _ = stopContainer(name) }()
+
+	args := []string{"create", "--name", name}
+	args = append(args, runArgs...)
+	for _, e := range env {
+		args = append(args, "-e", e)
+	}
+	args = append(args, imageID)
+	args = append(args, command...)
+	output, err := containerpkg.DockerCombinedOutput(containerpkg.DockerRun, args...)
+	if err != nil {
+		return -1, "", fmt.Errorf("docker create failed: %w\nOutput: %s", err, strings.TrimSpace(string(output)))
+	}
+	if err := ConnectContainerToStackNetwork(strings.TrimSpace(string(output)), stackID); err != nil {
+		return -1, "", fmt.Errorf("failed to connect task to stack network: %w", err)
+	}
+
+	output, err = exec.CommandContext(ctx, "docker", "start", "-a", name).CombinedOutput()
+	if ctx.Err() != nil {
+		return -1, string(output), ctx.Err()
+	}
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return -1, string(output), fmt.Errorf("docker start failed: %w", err)
+	}
+
+	code, inspectErr := containerpkg.DockerOutput(containerpkg.DockerInspect, "inspect", "--format", "{{.State.ExitCode}}", name)
+	if inspectErr != nil {
+		return -1, string(output), fmt.Errorf("docker inspect failed: %w", inspectErr)
+	}
+	exitCode, convErr := strconv.Atoi(strings.TrimSpace(string(code)))
+	if convErr != nil {
+		return -1, string(output), fmt.Errorf("unexpected exit code %q: %w", strings.TrimSpace(string(code)), convErr)
+	}
+	return exitCode, string(output), nil
+}
+
 // ConnectContainerToStackNetwork connects a container to its stack's network.
 func ConnectContainerToStackNetwork(containerID, stackID string) error {
 	if err := initStackNetworkManager(); err != nil {
@@ -302,7 +404,7 @@ func (m *Manager) cleanupOldImages(serviceID string, keep int) {
 	}
 	if len(imageIDs) > 0 {
 		args := append([]string{"rmi", "-f"}, imageIDs...)
-		if out, err := exec.Command("docker", args...).CombinedOutput(); err != nil {
+		if out, err := containerpkg.DockerCombinedOutput(containerpkg.DockerStop, args...); err != nil {
 			m.logVerbose("Failed to batch remove images: %v (output: %s)", err, strings.TrimSpace(string(out)))
 		}
 	}
@@ -0,0 +1,56 @@
+package service
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/buildvigil/agent/internal/api"
+)
+
+// validateEntrypoint checks a service's entrypoint and workdir overrides and
+// that docker_run_args does not set the same flags.
+func validateEntrypoint(service api.Service) error {
+	if _, err := splitShellWords(service.Entrypoint); err != nil {
+		return fmt.Errorf("invalid entrypoint: %w", err)
+	}
+	workdir := strings.TrimSpace(service.Workdir)
+	if workdir != "" && !path.IsAbs(workdir) {
+		return fmt.Errorf("invalid workdir %q; expected an absolute path inside the container", service.Workdir)
+	}
+	for _, arg := range parseDockerRunArgs(service) {
+		key, _, _ := strings.Cut(arg, "=")
+		if strings.TrimSpace(service.Entrypoint) != "" && key == "--entrypoint" {
+			return fmt.Errorf("docker_run_args sets --entrypoint; use entrypoint instead")
+		}
+		if workdir != "" && (key == "-w" || key == "--workdir") {
+			return fmt.Errorf("docker_run_args sets %s; use workdir instead", key)
+		}
+	}
+	return nil
+}
+
+// entrypointRunArgs returns the --entrypoint and -w flags for a service.
+// Docker takes a single executable for --entrypoint, so only the first word
+// of the entrypoint is passed here; see entrypointCommand for the rest.
+func entrypointRunArgs(service api.Service) []string {
+	var args []string
+	if words, _ := splitShellWords(service.Entrypoint); len(words) > 0 {
+		args = append(args, "--entrypoint", words[0])
+	}
+	if workdir := strings.TrimSpace(service.Workdir); workdir != "" {
+		args = append(args, "-w", workdir)
+	}
+	return args
+}
+
+// entrypointCommand returns the entrypoint's arguments after the executable,
+// such as "-c" for "/bin/sh -c". They lead the container's command so the
+// run command follows them.
+func entrypointCommand(service api.Service) []string {
+	words, _ := splitShellWords(service.Entrypoint)
+	if len(words) < 2 {
+		return nil
+	}
+	return words[1:]
+}
@@ -0,0 +1,72 @@
+package service
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/buildvigil/agent/internal/api"
+)
+
+func TestEntrypointOverride(t *testing.T) {
+	t.Logf("Testing entrypoint and workdir overrides")
+
+	svc := api.Service{ID: "svc-1", ServiceType: "docker", DockerImage: "alpine:3.19",
+		Entrypoint: `/bin/sh -c`,
+		RunCommand: `"exec ./serve --port 8080"`,
+		Workdir:    " /srv/app ",
+	}
+	if err := validateContainerArgs(svc); err != nil {
+		t.Fatalf("Expected overrides to be valid, got %v", err)
+	}
+	if got := entrypointRunArgs(svc); !reflect.DeepEqual(got, []string{"--entrypoint", "/bin/sh", "-w", "/srv/app"}) {
+		t.Errorf("Unexpected run args: %q", got)
+	}
+	if got := containerCommandForService(svc); !reflect.DeepEqual(got, []string{"-c", "exec ./serve --port 8080"}) {
+		t.Errorf("Unexpected command: %q", got)
+	}
+
+	args, err := containerRunArgs(svc)
+	if err != nil {
+		t.Fatalf("containerRunArgs: %v", err)
+	}
+	if !strings.Contains(strings.Join(args, " "), "--entrypoint /bin/sh -w /srv/app") {
+		t.Errorf("Expected entrypoint flags in run args, got %q", args)
+	}
+
+	built := api.Service{ID: "svc-2", ServiceType: "git", Entrypoint: "/usr/bin/tini --", RunCommand: "ignored"}
+	if got := containerCommandForService(built); !reflect.DeepEqual(got, []string{"--"}) {
+		t.Errorf("Expected only entrypoint args for a built image, got %q", got)
+	}
+	if got := containerCommandForService(api.Service{ServiceType: "git"}); got != nil {
+		t.Errorf("Expected no command override, got %q", got)
+	}
+
+	bad := svc
+	bad.Workdir = "srv/app"
+	if err := validateContainerArgs(bad); err == nil || !strings.Contains(err.Error(), "workdir") {
+		t.Errorf("Expected a workdir error, got %v", err)
+	}
+	bad = svc
+	bad.Entrypoint = `/bin/sh "-c`
+	if err := validateContainerArgs(bad); err == nil || !strings.Contains(err.Error(), "entrypoint") {
+		t.Errorf("Expected an entrypoint error, got %v", err)
+	}
+	bad = svc
+	bad.DockerRunArgs = "--entrypoint=/bin/bash"
+	if err := validateContainerArgs(bad); err == nil {
+		t.Error("Expected --entrypoint in docker_run_args to conflict with entrypoint")
+	}
+	bad = svc
+	bad.DockerRunArgs = "-w /tmp"
+	if err := validateContainerArgs(bad); err == nil {
+		t.Error("Expected -w in docker_run_args to conflict with workdir")
+	}
+	ok := svc
+	ok.Workdir = ""
+	ok.DockerRunArgs = "-w /tmp"
+	if err := validateContainerArgs(ok); err != nil {
+		t.Errorf("Expected -w without workdir to be allowed, got %v", err)
+	}
+	t.Logf("✓ Entrypoint and workdir mapped to docker run flags")
+}
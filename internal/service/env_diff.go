@@ -0,0 +1,145 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/buildvigil/agent/internal/api"
+	containerpkg "github.com/buildvigil/agent/internal/container"
+)
+
+var containerEnvironment = defaultContainerEnvironment
+
+// EnvChange is an environment variable that differs between a service's
+// running container and what its next deploy would set. Values are left out
+// so a diff can be shown without revealing secrets.
+type EnvChange struct {
+	Name   string
+	Change string // "added", "removed" or "changed"
+	Secret bool   // the variable comes from an agent-stored secret
+}
+
+// DiffEnvironment compares the environment the next deploy of service would
+// get with the one containerName is running with. Variables the image
+// itself sets (PATH and the like) are ignored unless the service overrides
+// them. An empty containerName means nothing is running, so every variable
+// is added.
+func (m *Manager) DiffEnvironment(service api.Service, containerName string) ([]EnvChange, error) {
+	desired, running, err := m.environments(service, containerName)
+	if err != nil {
+		return nil, err
+	}
+
+	secret := make(map[string]bool, len(service.Secrets))
+	for _, name := range service.Secrets {
+		secret[name] = true
+	}
+	var changes []EnvChange
+	for key, value := range desired {
+		current, ok := running[key]
+		switch {
+		case !ok:
+			changes = append(changes, EnvChange{Name: key, Change: "added", Secret: secret[key]})
+		case current != value:
+			changes = append(changes, EnvChange{Name: key, Change: "changed", Secret: secret[key]})
+		}
+	}
+	for key := range running {
+		if _, ok := desired[key]; !ok {
+			changes = append(changes, EnvChange{Name: key, Change: "removed", Secret: secret[key]})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Name < changes[j].Name })
+	return changes, nil
+}
+
+// EnvironmentHashes fingerprints the environment the next deploy of service
+// would get and the one containerName is running with, compared as in
+// DiffEnvironment. The hashes match when there is no environment drift.
+func (m *Manager) EnvironmentHashes(service api.Service, containerName string) (desired, running string, err error) {
+	desiredEnv, runningEnv, err := m.environments(service, containerName)
+	if err != nil {
+		return "", "", err
+	}
+	return envHash(desiredEnv), envHash(runningEnv), nil
+}
+
+// ContainerStatus returns docker's status for a container, or "stopped" if
+// it doesn't exist.
+func (m *Manager) ContainerStatus(containerName string) (string, error) {
+	return getContainerStatus(containerName)
+}
+
+// environments returns the desired and running environments of a service,
+// leaving out image defaults the service doesn't override.
+func (m *Manager) environments(service api.Service, containerName string) (desired, running map[string]string, err error) {
+	desired = envMap(m.prepareEnvironment(service))
+	running = map[string]string{}
+	if containerName == "" {
+		return desired, running, nil
+	}
+	env, imageEnv, err := containerEnvironment(containerName)
+	if err != nil {
+		return nil, nil, err
+	}
+	running = envMap(env)
+	for key, value := range envMap(imageEnv) {
+		if _, set := desired[key]; !set && running[key] == value {
+			delete(running, key)
+		}
+	}
+	return desired, running, nil
+}
+
+// envHash is a short fingerprint of an environment that doesn't reveal its
+// values.
+func envHash(vars map[string]string) string {
+	keys := make([]string, 0, len(vars))
+	for key := range vars {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	sum := sha256.New()
+	for _, key := range keys {
+		fmt.Fprintf(sum, "%s=%s\n", key, vars[key])
+	}
+	return hex.EncodeToString(sum.Sum(nil))[:12]
+}
+
+// envMap parses KEY=VALUE entries; a later entry for a key wins, as with
+// repeated docker run -e flags.
+func envMap(env []string) map[string]string {
+	vars := make(map[string]string, len(env))
+	for _, entry := range env {
+		key, value, _ := strings.Cut(entry, "=")
+		if key != "" {
+			vars[key] = value
+		}
+	}
+	return vars
+}
+
+// defaultContainerEnvironment returns a container's environment and the
+// environment its image sets.
+func defaultContainerEnvironment(containerName string) ([]string, []string, error) {
+	output, err := containerpkg.DockerOutput(containerpkg.DockerInspect, "inspect", "--format", "{{json .Config.Env}}|{{.Image}}", containerName)
+	if err != nil {
+		return nil, nil, fmt.Errorf("docker inspect failed: %w", err)
+	}
+	envJSON, imageID, _ := strings.Cut(strings.TrimSpace(string(output)), "|")
+	var env []string
+	if err := json.Unmarshal([]byte(envJSON), &env); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse container environment: %w", err)
+	}
+
+	var imageEnv []string
+	output, err = containerpkg.DockerOutput(containerpkg.DockerInspect, "image", "inspect", "--format", "{{json .Config.Env}}", imageID)
+	if err == nil {
+		_ = json.Unmarshal(output, &imageEnv)
+	}
+	return env, imageEnv, nil
+}
@@ -0,0 +1,70 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/buildvigil/agent/internal/api"
+)
+
+func TestDiffEnvironment(t *testing.T) {
+	t.Logf("Testing environment diff against the running container...")
+
+	original := containerEnvironment
+	t.Cleanup(func() { containerEnvironment = original })
+	containerEnvironment = func(string) ([]string, []string, error) {
+		env := []string{"PATH=/usr/bin", "HOME=/root", "PORT=8080", "LOG_LEVEL=info", "OLD_FLAG=1"}
+		imageEnv := []string{"PATH=/usr/bin", "HOME=/app"}
+		return env, imageEnv, nil
+	}
+
+	m := NewManager(t.TempDir(), nil, nil, 3000, 3010, false)
+	service := api.Service{
+		ID:              "svc",
+		EnvironmentVars: map[string]string{"PORT": "8080", "LOG_LEVEL": "debug", "NEW_FLAG": "1"},
+	}
+	changes, err := m.DiffEnvironment(service, "potato-cloud-svc")
+	if err != nil {
+		t.Fatalf("DiffEnvironment failed: %v", err)
+	}
+	want := []EnvChange{
+		{Name: "HOME", Change: "removed"},
+		{Name: "LOG_LEVEL", Change: "changed"},
+		{Name: "NEW_FLAG", Change: "added"},
+		{Name: "OLD_FLAG", Change: "removed"},
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("Expected %+v, got %+v", want, changes)
+	}
+
+	changes, err = m.DiffEnvironment(service, "")
+	if err != nil || len(changes) != 3 {
+		t.Errorf("Expected every variable added without a container, got %+v (err=%v)", changes, err)
+	}
+	t.Logf("✓ Added, removed and changed variables reported; image defaults ignored")
+}
+
+func TestEnvironmentHashes(t *testing.T) {
+	t.Logf("Testing environment fingerprints...")
+
+	original := containerEnvironment
+	t.Cleanup(func() { containerEnvironment = original })
+	running := []string{"PATH=/usr/bin", "PORT=8080"}
+	containerEnvironment = func(string) ([]string, []string, error) {
+		return running, []string{"PATH=/usr/bin"}, nil
+	}
+
+	m := NewManager(t.TempDir(), nil, nil, 3000, 3010, false)
+	service := api.Service{ID: "svc", EnvironmentVars: map[string]string{"PORT": "8080"}}
+	desired, actual, err := m.EnvironmentHashes(service, "potato-cloud-svc")
+	if err != nil || desired == "" || desired != actual {
+		t.Errorf("Expected matching hashes, got desired=%q running=%q (err=%v)", desired, actual, err)
+	}
+
+	running = []string{"PATH=/usr/bin", "PORT=9090"}
+	desired, actual, _ = m.EnvironmentHashes(service, "potato-cloud-svc")
+	if desired == actual {
+		t.Errorf("Expected a changed value to change the running hash")
+	}
+	t.Logf("✓ Hashes match only when environments do")
+}
@@ -0,0 +1,115 @@
+package service
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Bounds on the health checks behind heartbeats. HealthCheckWorkers services
+// are checked at once, and CheckAllServicesHealth callers usually wait at
+// most HealthCheckDeadline for them.
+const (
+	HealthCheckWorkers  = 8
+	HealthCheckDeadline = 2 * time.Second
+	healthResultTTL     = 20 * time.Second
+)
+
+// HealthResult is the outcome of a service's latest health check.
+type HealthResult struct {
+	Healthy   bool
+	CheckedAt time.Time
+}
+
+// CheckAllServicesHealth checks every tracked service, HealthCheckWorkers at
+// a time, and returns within deadline with the latest result for each.
+// Results younger than healthResultTTL are reused. Checks still running at
+// the deadline finish in the background for the next call; until then a
+// service's previous result, if any, is returned. It does not wait for
+// deploys in progress.
+func (m *Manager) CheckAllServicesHealth(deadline time.Duration) map[string]HealthResult {
+	tracked := make(map[string]bool)
+	for serviceID := range m.trackedContainers() {
+		tracked[serviceID] = true
+	}
+
+	m.healthMu.Lock()
+	if m.healthResults == nil {
+		m.healthResults = make(map[string]HealthResult)
+		m.healthChecking = make(map[string]bool)
+	}
+	for serviceID := range m.healthResults {
+		if !tracked[serviceID] {
+			delete(m.healthResults, serviceID)
+		}
+	}
+	var due []string
+	for serviceID := range tracked {
+		if m.healthChecking[serviceID] {
+			continue
+		}
+		if result, ok := m.healthResults[serviceID]; ok && time.Since(result.CheckedAt) < healthResultTTL {
+			continue
+		}
+		m.healthChecking[serviceID] = true
+		due = append(due, serviceID)
+	}
+	m.healthMu.Unlock()
+
+	if len(due) > 0 {
+		sort.Strings(due)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			queue := make(chan string)
+			var wg sync.WaitGroup
+			for i := 0; i < HealthCheckWorkers && i < len(due); i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for serviceID := range queue {
+						m.checkHealth(serviceID)
+					}
+				}()
+			}
+			for _, serviceID := range due {
+				queue <- serviceID
+			}
+			close(queue)
+			wg.Wait()
+		}()
+
+		timer := time.NewTimer(deadline)
+		defer timer.Stop()
+		select {
+		case <-done:
+		case <-timer.C:
+			log.Printf("[ServiceManager] Health checks still running after %s; using cached results", deadline)
+		}
+	}
+
+	m.healthMu.Lock()
+	defer m.healthMu.Unlock()
+	results := make(map[string]HealthResult, len(tracked))
+	for serviceID := range tracked {
+		if result, ok := m.healthResults[serviceID]; ok {
+			results[serviceID] = result
+		}
+	}
+	return results
+}
+
+// checkHealth probes one service and caches the result. A service that
+// cannot be probed, e.g. one being removed, loses its cached result.
+func (m *Manager) checkHealth(serviceID string) {
+	healthy, err := m.ProbeService(serviceID)
+	m.healthMu.Lock()
+	defer m.healthMu.Unlock()
+	delete(m.healthChecking, serviceID)
+	if err != nil {
+		delete(m.healthResults, serviceID)
+		return
+	}
+	m.healthResults[serviceID] = HealthResult{Healthy: healthy, CheckedAt: time.Now()}
+}
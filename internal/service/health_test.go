@@ -0,0 +1,124 @@
+package service
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/buildvigil/agent/internal/api"
+)
+
+func TestCheckAllServicesHealth(t *testing.T) {
+	t.Logf("Testing health checks run in parallel within a deadline...")
+	originalStatus := getContainerStatus
+	defer func() { getContainerStatus = originalStatus }()
+
+	var inFlight, maxInFlight, probes int32
+	var mu sync.Mutex
+	slow := make(chan struct{})
+	getContainerStatus = func(name string) (string, error) {
+		atomic.AddInt32(&probes, 1)
+		n := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		mu.Lock()
+		if n > maxInFlight {
+			maxInFlight = n
+		}
+		mu.Unlock()
+		switch name {
+		case "potato-cloud-slow":
+			<-slow
+			return "running", nil
+		case "potato-cloud-down":
+			return "exited", nil
+		}
+		time.Sleep(20 * time.Millisecond)
+		return "running", nil
+	}
+
+	m := NewManager(t.TempDir(), nil, nil, 3000, 3100, false)
+	ids := []string{"slow", "down"}
+	for i := 0; i < 2*HealthCheckWorkers; i++ {
+		ids = append(ids, fmt.Sprintf("web-%02d", i))
+	}
+	for _, id := range ids {
+		m.containers[id] = &containerInfo{service: api.Service{ID: id}, containerName: "potato-cloud-" + id}
+	}
+
+	start := time.Now()
+	results := m.CheckAllServicesHealth(500 * time.Millisecond)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Expected the checks bounded by the deadline, took %s", elapsed)
+	}
+	if maxInFlight > HealthCheckWorkers || maxInFlight < 2 {
+		t.Errorf("Expected parallel checks capped at %d, got %d at once", HealthCheckWorkers, maxInFlight)
+	}
+	if _, ok := results["slow"]; ok {
+		t.Errorf("Expected no result for a check still running, got %+v", results["slow"])
+	}
+	if results["down"].Healthy || !results["web-00"].Healthy || len(results) != len(ids)-1 {
+		t.Errorf("Unexpected results: %+v", results)
+	}
+
+	close(slow)
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		m.healthMu.Lock()
+		_, ok := m.healthResults["slow"]
+		m.healthMu.Unlock()
+		if ok || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	probed := atomic.LoadInt32(&probes)
+	results = m.CheckAllServicesHealth(500 * time.Millisecond)
+	if !results["slow"].Healthy || len(results) != len(ids) {
+		t.Errorf("Expected the late check's result on the next call, got %+v", results)
+	}
+	if atomic.LoadInt32(&probes) != probed {
+		t.Error("Expected recent results to be reused rather than checked again")
+	}
+
+	delete(m.containers, "down")
+	if _, ok := m.CheckAllServicesHealth(time.Second)["down"]; ok {
+		t.Error("Expected untracked services to be dropped")
+	}
+	t.Logf("✓ Checks bounded in time and parallelism, and cached")
+}
+
+func TestStatusReadsDoNotWaitForDeploys(t *testing.T) {
+	t.Logf("Testing health checks and status reads answer while a deploy holds the manager")
+	originalStatus, originalInspect := getContainerStatus, inspectContainerState
+	defer func() { getContainerStatus, inspectContainerState = originalStatus, originalInspect }()
+	getContainerStatus = func(string) (string, error) { return "running", nil }
+	inspectContainerState = func(string) (string, bool, error) { return "running", true, nil }
+	forgetContainerStatus("potato-cloud-web")
+
+	m := NewManager(t.TempDir(), nil, nil, 3000, 3100, false)
+	m.containers["web"] = &containerInfo{service: api.Service{ID: "web"}, containerName: "potato-cloud-web", port: 3000}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if !m.CheckAllServicesHealth(time.Second)["web"].Healthy {
+			t.Errorf("Expected web to be healthy")
+		}
+		if status, _ := m.GetServiceStatus("web"); status["running"] != true || status["port"] != 3000 {
+			t.Errorf("Unexpected status: %v", status)
+		}
+		if running := m.RunningServices(); len(running) != 1 {
+			t.Errorf("Expected one running service, got %v", running)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatal("Expected status reads not to wait for the deploy lock")
+	}
+	t.Logf("✓ Status answered during a deploy")
+}
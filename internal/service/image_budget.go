@@ -0,0 +1,295 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/buildvigil/agent/internal/api"
+	containerpkg "github.com/buildvigil/agent/internal/container"
+)
+
+var (
+	listAgentImages = defaultListAgentImages
+	imageLayers     = defaultImageLayers
+)
+
+// imageLayer is one filesystem layer of an image. Images built from the same
+// base share layers, which are stored on disk once.
+type imageLayer struct {
+	ID   string
+	Size int64
+}
+
+// agentImage is an image built by the agent, with the service it belongs to
+// and its layers.
+type agentImage struct {
+	ImageInfo
+	ServiceID string
+	Layers    []imageLayer
+}
+
+// SetImageBudgets limits the disk used by image layers, per service and in
+// total across services, in MB; 0 disables a limit. A service's
+// image_budget_mb overrides the per-service limit.
+func (m *Manager) SetImageBudgets(serviceMB, totalMB int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.imageServiceBudgetMB = serviceMB
+	m.imageTotalBudgetMB = totalMB
+}
+
+// enforceImageBudgets removes older images until the layers of service's
+// images, and of all agent images, fit their budgets. The newest image of
+// each service, images of running containers and rollback images are kept.
+// Callers hold m.mu.
+func (m *Manager) enforceImageBudgets(service api.Service) {
+	serviceMB, totalMB := m.imageServiceBudgetMB, m.imageTotalBudgetMB
+	inUse := make(map[string]bool, len(m.containers))
+	for _, info := range m.containers {
+		inUse[info.imageTag] = true
+	}
+	if service.ImageBudgetMB > 0 {
+		serviceMB = service.ImageBudgetMB
+	}
+	if serviceMB <= 0 && totalMB <= 0 {
+		return
+	}
+
+	images, err := loadAgentImages()
+	if err != nil {
+		log.Printf("[ServiceManager] Failed to measure image disk use: %v", err)
+		return
+	}
+	protected := make(map[string]bool)
+	newest := make(map[string]bool)
+	for _, img := range images {
+		if !newest[img.ServiceID] || inUse[img.Tag] || strings.HasSuffix(img.Tag, ":"+rollbackImageTag) {
+			protected[img.ID] = true
+		}
+		newest[img.ServiceID] = true
+	}
+
+	ofService := func(img agentImage) bool { return img.ServiceID == service.ID }
+	log.Printf("[ServiceManager] Image disk use: service=%s bytes=%d total_bytes=%d", service.ID, layerBytes(images, ofService), layerBytes(images, nil))
+
+	var remove []agentImage
+	if serviceMB > 0 {
+		remove = planImageRemovals(images, ofService, protected, int64(serviceMB)<<20)
+		images = withoutImages(images, remove)
+	}
+	if totalMB > 0 {
+		more := planImageRemovals(images, nil, protected, int64(totalMB)<<20)
+		remove = append(remove, more...)
+	}
+	for _, img := range remove {
+		log.Printf("[ServiceManager] Removing image over disk budget: service=%s image=%s id=%s", img.ServiceID, img.Tag, img.ID)
+		if err := removeImage(img.ID); err != nil {
+			log.Printf("[ServiceManager] Failed to remove image: id=%s error=%v", img.ID, err)
+		}
+	}
+}
+
+// loadAgentImages lists agent images newest first, with their layers.
+func loadAgentImages() ([]agentImage, error) {
+	infos, err := listAgentImages()
+	if err != nil {
+		return nil, err
+	}
+	images := make([]agentImage, 0, len(infos))
+	for _, info := range infos {
+		layers, err := imageLayers(info.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to inspect image %s: %w", info.ID, err)
+		}
+		images = append(images, agentImage{ImageInfo: info, ServiceID: imageServiceID(info.Tag), Layers: layers})
+	}
+	sort.SliceStable(images, func(i, j int) bool {
+		iTime, iErr := time.Parse("2006-01-02 15:04:05 -0700 MST", images[i].CreatedAt)
+		jTime, jErr := time.Parse("2006-01-02 15:04:05 -0700 MST", images[j].CreatedAt)
+		if iErr != nil || jErr != nil {
+			return images[i].CreatedAt > images[j].CreatedAt
+		}
+		return iTime.After(jTime)
+	})
+	return images, nil
+}
+
+// planImageRemovals picks images in scope (all images when nil) to remove,
+// oldest unprotected first, until the layers used by the images in scope
+// fit in budget bytes. Images whose layers no other image shares free the
+// most space, so they are picked before ones that mostly share layers.
+// images must be sorted newest first.
+func planImageRemovals(images []agentImage, scope func(agentImage) bool, protected map[string]bool, budget int64) []agentImage {
+	var removed []agentImage
+	remaining := images
+	for layerBytes(remaining, scope) > budget {
+		best, bestFreed := -1, int64(-1)
+		for i := len(remaining) - 1; i >= 0; i-- {
+			img := remaining[i]
+			if protected[img.ID] || (scope != nil && !scope(img)) {
+				continue
+			}
+			if freed := freedBytes(remaining, i); freed > bestFreed {
+				best, bestFreed = i, freed
+			}
+		}
+		if best < 0 {
+			break
+		}
+		removed = append(removed, remaining[best])
+		remaining = withoutImages(remaining, remaining[best:best+1])
+	}
+	return removed
+}
+
+// layerBytes is the size of the distinct layers used by images in scope.
+func layerBytes(images []agentImage, scope func(agentImage) bool) int64 {
+	seen := make(map[string]bool)
+	var total int64
+	for _, img := range images {
+		if scope != nil && !scope(img) {
+			continue
+		}
+		for _, layer := range img.Layers {
+			if !seen[layer.ID] {
+				seen[layer.ID] = true
+				total += layer.Size
+			}
+		}
+	}
+	return total
+}
+
+// freedBytes is the size of the layers of images[index] that no other image
+// uses, i.e. the disk space removing it frees.
+func freedBytes(images []agentImage, index int) int64 {
+	shared := make(map[string]bool)
+	for i, img := range images {
+		if i == index {
+			continue
+		}
+		for _, layer := range img.Layers {
+			shared[layer.ID] = true
+		}
+	}
+	var freed int64
+	seen := make(map[string]bool)
+	for _, layer := range images[index].Layers {
+		if !shared[layer.ID] && !seen[layer.ID] {
+			seen[layer.ID] = true
+			freed += layer.Size
+		}
+	}
+	return freed
+}
+
+func withoutImages(images, remove []agentImage) []agentImage {
+	drop := make(map[string]bool, len(remove))
+	for _, img := range remove {
+		drop[img.ID] = true
+	}
+	kept := make([]agentImage, 0, len(images))
+	for _, img := range images {
+		if !drop[img.ID] {
+			kept = append(kept, img)
+		}
+	}
+	return kept
+}
+
+// imageServiceID returns the service an agent image tag belongs to.
+func imageServiceID(tag string) string {
+	name := tag
+	if i := strings.LastIndex(name, ":"); i > 0 {
+		name = name[:i]
+	}
+	for _, prefix := range []string{ImagePrefix + "/", ImagePrefix + "-"} {
+		if rest, ok := strings.CutPrefix(name, prefix); ok {
+			return rest
+		}
+	}
+	return name
+}
+
+func defaultListAgentImages() ([]ImageInfo, error) {
+	linesA, errA := dockerImagesByReference(ImagePrefix + "/*:*")
+	linesB, errB := dockerImagesByReference(ImagePrefix + "-*:*")
+	if errA != nil && errB != nil {
+		return nil, fmt.Errorf("docker images failed: %v; %v", errA, errB)
+	}
+
+	seen := make(map[string]struct{})
+	images := make([]ImageInfo, 0, len(linesA)+len(linesB))
+	for _, line := range append(linesA, linesB...) {
+		parts := strings.SplitN(line, "|", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		if _, exists := seen[parts[1]]; exists {
+			continue
+		}
+		seen[parts[1]] = struct{}{}
+		images = append(images, ImageInfo{Tag: parts[0], ID: parts[1], CreatedAt: parts[2]})
+	}
+	return images, nil
+}
+
+// defaultImageLayers returns an image's layers with their sizes, pairing
+// the layer digests from inspect with the per-step sizes from history.
+func defaultImageLayers(imageID string) ([]imageLayer, error) {
+	output, err := containerpkg.DockerOutput(containerpkg.DockerInspect, "image", "inspect", "--format", "{{json .RootFS.Layers}}|{{.Size}}", imageID)
+	if err != nil {
+		return nil, fmt.Errorf("docker image inspect failed: %w", err)
+	}
+	layersJSON, sizeText, _ := strings.Cut(strings.TrimSpace(string(output)), "|")
+	var layers []string
+	if err := json.Unmarshal([]byte(layersJSON), &layers); err != nil {
+		return nil, fmt.Errorf("failed to parse image layers: %w", err)
+	}
+	total, _ := strconv.ParseInt(strings.TrimSpace(sizeText), 10, 64)
+
+	output, err = containerpkg.DockerOutput(containerpkg.DockerInspect, "history", "--no-trunc", "--human=false", "--format", "{{.Size}}", imageID)
+	if err != nil {
+		return nil, fmt.Errorf("docker history failed: %w", err)
+	}
+	var sizes []int64
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		if size, err := strconv.ParseInt(strings.TrimSpace(line), 10, 64); err == nil {
+			sizes = append(sizes, size)
+		}
+	}
+	return pairLayerSizes(imageID, layers, sizes, total), nil
+}
+
+// pairLayerSizes matches layer digests (oldest first) to history sizes
+// (newest first). History also lists steps that made no layer, with size 0;
+// those are dropped when the counts differ. If the two still don't line up,
+// the image is treated as a single unshared layer of its total size.
+func pairLayerSizes(imageID string, layers []string, sizes []int64, total int64) []imageLayer {
+	oldestFirst := make([]int64, 0, len(sizes))
+	for i := len(sizes) - 1; i >= 0; i-- {
+		oldestFirst = append(oldestFirst, sizes[i])
+	}
+	if len(oldestFirst) != len(layers) {
+		nonEmpty := oldestFirst[:0]
+		for _, size := range oldestFirst {
+			if size > 0 {
+				nonEmpty = append(nonEmpty, size)
+			}
+		}
+		oldestFirst = nonEmpty
+	}
+	if len(oldestFirst) != len(layers) {
+		return []imageLayer{{ID: imageID, Size: total}}
+	}
+	paired := make([]imageLayer, len(layers))
+	for i, id := range layers {
+		paired[i] = imageLayer{ID: id, Size: oldestFirst[i]}
+	}
+	return paired
+}
@@ -0,0 +1,109 @@
+package service
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/buildvigil/agent/internal/api"
+)
+
+const mb = 1 << 20
+
+func testImage(serviceID, id string, layers ...imageLayer) agentImage {
+	return agentImage{
+		ImageInfo: ImageInfo{Tag: fmt.Sprintf("%s-%s:%s", ImagePrefix, serviceID, id), ID: id},
+		ServiceID: serviceID,
+		Layers:    layers,
+	}
+}
+
+func TestPlanImageRemovals_PrefersUnsharedLayers(t *testing.T) {
+	t.Logf("Testing budget removals prefer images with unshared layers")
+
+	base := imageLayer{ID: "base", Size: 100 * mb}
+	images := []agentImage{
+		testImage("svc-1", "new", base, imageLayer{ID: "app-3", Size: 10 * mb}),
+		testImage("svc-1", "mid", base, imageLayer{ID: "app-2", Size: 50 * mb}),
+		testImage("svc-1", "old", base, imageLayer{ID: "app-3", Size: 10 * mb}),
+	}
+	if got := layerBytes(images, nil); got != 160*mb {
+		t.Fatalf("Expected shared layers counted once (160MB), got %d", got/mb)
+	}
+
+	removed := planImageRemovals(images, nil, map[string]bool{"new": true}, 120*mb)
+	if len(removed) != 1 || removed[0].ID != "mid" {
+		t.Errorf("Expected only the image with unshared layers removed, got %+v", removed)
+	}
+
+	// Removing everything but the protected image still exceeds the budget.
+	removed = planImageRemovals(images, nil, map[string]bool{"new": true}, 50*mb)
+	if len(removed) != 2 || removed[0].ID != "mid" || removed[1].ID != "old" {
+		t.Errorf("Expected both older images removed, got %+v", removed)
+	}
+	t.Logf("✓ Images freeing the most disk removed first")
+}
+
+func TestPlanImageRemovals_ServiceScope(t *testing.T) {
+	t.Logf("Testing per-service budgets only remove that service's images")
+
+	images := []agentImage{
+		testImage("svc-1", "a2", imageLayer{ID: "a2", Size: 40 * mb}),
+		testImage("svc-2", "b1", imageLayer{ID: "b1", Size: 500 * mb}),
+		testImage("svc-1", "a1", imageLayer{ID: "a1", Size: 40 * mb}),
+	}
+	scope := func(img agentImage) bool { return img.ServiceID == "svc-1" }
+	removed := planImageRemovals(images, scope, map[string]bool{"a2": true, "b1": true}, 50*mb)
+	if len(removed) != 1 || removed[0].ID != "a1" {
+		t.Errorf("Expected svc-1's older image removed, got %+v", removed)
+	}
+	t.Logf("✓ Per-service budget scoped to the service")
+}
+
+func TestPairLayerSizes(t *testing.T) {
+	t.Logf("Testing layer digests paired with history sizes")
+
+	layers := []string{"sha256:base", "sha256:app"}
+	want := []imageLayer{{ID: "sha256:base", Size: 70}, {ID: "sha256:app", Size: 5}}
+	if got := pairLayerSizes("img", layers, []int64{5, 0, 0, 70}, 75); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected metadata steps dropped, got %+v", got)
+	}
+	if got := pairLayerSizes("img", layers, []int64{5, 70, 9}, 84); !reflect.DeepEqual(got, []imageLayer{{ID: "img", Size: 84}}) {
+		t.Errorf("Expected fallback to a single layer, got %+v", got)
+	}
+	t.Logf("✓ Layer sizes paired")
+}
+
+func TestEnforceImageBudgets(t *testing.T) {
+	t.Logf("Testing image budget enforcement after a build")
+
+	origList, origLayers, origRemove := listAgentImages, imageLayers, removeImage
+	defer func() { listAgentImages, imageLayers, removeImage = origList, origLayers, origRemove }()
+	listAgentImages = func() ([]ImageInfo, error) {
+		return []ImageInfo{
+			{Tag: ImagePrefix + "-svc-1:old", ID: "old", CreatedAt: "2026-01-01 10:00:00 +0000 UTC"},
+			{Tag: ImagePrefix + "-svc-1:latest", ID: "new", CreatedAt: "2026-01-02 10:00:00 +0000 UTC"},
+		}, nil
+	}
+	imageLayers = func(imageID string) ([]imageLayer, error) {
+		return []imageLayer{{ID: imageID, Size: 80 * mb}}, nil
+	}
+	var removed []string
+	removeImage = func(imageID string) error {
+		removed = append(removed, imageID)
+		return nil
+	}
+
+	m := NewManager(t.TempDir(), nil, nil, 3000, 3010, false)
+	m.enforceImageBudgets(api.Service{ID: "svc-1"})
+	if len(removed) != 0 {
+		t.Fatalf("Expected no removals without a budget, got %v", removed)
+	}
+
+	m.SetImageBudgets(0, 1000)
+	m.enforceImageBudgets(api.Service{ID: "svc-1", ImageBudgetMB: 100})
+	if !reflect.DeepEqual(removed, []string{"old"}) {
+		t.Errorf("Expected the older image removed, got %v", removed)
+	}
+	t.Logf("✓ Service image budget enforced")
+}
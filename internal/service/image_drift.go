@@ -0,0 +1,94 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/buildvigil/agent/internal/api"
+)
+
+// recordImageDigest stores the image ID of a service's newly deployed
+// container as the one it is expected to keep running, and returns it.
+// Callers hold m.mu.
+func (m *Manager) recordImageDigest(serviceID string) string {
+	info, ok := m.containers[serviceID]
+	if !ok || m.state == nil {
+		return ""
+	}
+	digest, err := containerImageID(info.containerName)
+	if err != nil || digest == "" {
+		m.logVerbose("Failed to read image of %s: %v", info.containerName, err)
+		return ""
+	}
+	if err := m.state.SetServiceImageDigest(serviceID, digest); err != nil {
+		log.Printf("[ServiceManager] Failed to record image digest: service=%s err=%v", serviceID, err)
+		return digest
+	}
+	m.driftMu.Lock()
+	delete(m.imageDrift, serviceID)
+	m.driftMu.Unlock()
+	return digest
+}
+
+// CheckImageDrift compares each running service container's image with the
+// one recorded at deploy, e.g. to catch a container recreated by hand from a
+// retagged image. It returns the IDs of drifted services.
+func (m *Manager) CheckImageDrift() []string {
+	type target struct {
+		serviceID     string
+		containerName string
+	}
+	tracked := m.trackedContainers()
+	targets := make([]target, 0, len(tracked))
+	for serviceID, info := range tracked {
+		targets = append(targets, target{serviceID, info.containerName})
+	}
+	if m.state == nil {
+		return nil
+	}
+
+	drift := make(map[string]api.ImageDrift)
+	for _, t := range targets {
+		proc, err := m.state.GetServiceProcess(t.serviceID)
+		if err != nil || proc == nil || proc.ImageDigest == "" || proc.Status != "running" {
+			continue
+		}
+		running, err := containerImageID(t.containerName)
+		if err != nil || running == "" || running == proc.ImageDigest {
+			continue
+		}
+		drift[t.serviceID] = api.ImageDrift{Expected: proc.ImageDigest, Running: running, DetectedAt: time.Now().UTC()}
+	}
+
+	m.driftMu.Lock()
+	defer m.driftMu.Unlock()
+	drifted := make([]string, 0, len(drift))
+	for serviceID, current := range drift {
+		if previous, ok := m.imageDrift[serviceID]; ok && previous.Running == current.Running {
+			current.DetectedAt = previous.DetectedAt
+			drift[serviceID] = current
+		} else {
+			log.Printf("[ServiceManager] Warning: image drift: service=%s expected=%s running=%s", serviceID, current.Expected, current.Running)
+			if err := m.state.RecordEvent(serviceID, "image_drift", fmt.Sprintf("expected=%s running=%s", current.Expected, current.Running)); err != nil {
+				m.logVerbose("Failed to record image drift event for %s: %v", serviceID, err)
+			}
+		}
+		drifted = append(drifted, serviceID)
+	}
+	m.imageDrift = drift
+	sort.Strings(drifted)
+	return drifted
+}
+
+// ImageDrift returns the last detected image drift for a service, or nil.
+func (m *Manager) ImageDrift(serviceID string) *api.ImageDrift {
+	m.driftMu.Lock()
+	defer m.driftMu.Unlock()
+	drift, ok := m.imageDrift[serviceID]
+	if !ok {
+		return nil
+	}
+	return &drift
+}
@@ -0,0 +1,56 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/buildvigil/agent/internal/api"
+	"github.com/buildvigil/agent/internal/state"
+)
+
+func TestCheckImageDrift(t *testing.T) {
+	t.Logf("Testing running image is compared with the deployed one...")
+	stateMgr, err := state.NewManager(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to create state manager: %v", err)
+	}
+	defer stateMgr.Close()
+
+	original := containerImageID
+	defer func() { containerImageID = original }()
+	running := "sha256:aaa"
+	containerImageID = func(containerName string) (string, error) {
+		return running, nil
+	}
+
+	m := NewManager(t.TempDir(), stateMgr, nil, 3000, 3010, false)
+	m.containers["web"] = &containerInfo{service: api.Service{ID: "web"}, containerName: "potato-cloud-web"}
+	if err := stateMgr.SaveServiceProcess(&state.ServiceProcess{ServiceID: "web", ServiceName: "web", Status: "running"}); err != nil {
+		t.Fatalf("Failed to save process: %v", err)
+	}
+	m.recordImageDigest("web")
+	if proc, _ := stateMgr.GetServiceProcess("web"); proc == nil || proc.ImageDigest != "sha256:aaa" {
+		t.Fatalf("Expected deployed image to be recorded, got %+v", proc)
+	}
+
+	if drifted := m.CheckImageDrift(); len(drifted) != 0 || m.ImageDrift("web") != nil {
+		t.Fatalf("Expected no drift, got %v", drifted)
+	}
+
+	running = "sha256:bbb"
+	drifted := m.CheckImageDrift()
+	drift := m.ImageDrift("web")
+	if len(drifted) != 1 || drift == nil || drift.Expected != "sha256:aaa" || drift.Running != "sha256:bbb" {
+		t.Fatalf("Expected drift to be flagged, got %v %+v", drifted, drift)
+	}
+	detectedAt := drift.DetectedAt
+	m.CheckImageDrift()
+	if again := m.ImageDrift("web"); again == nil || !again.DetectedAt.Equal(detectedAt) {
+		t.Errorf("Expected ongoing drift to keep its detection time, got %+v", again)
+	}
+
+	m.recordImageDigest("web")
+	if m.ImageDrift("web") != nil {
+		t.Error("Expected a redeploy to clear the drift")
+	}
+	t.Logf("✓ Image drift detected and cleared")
+}
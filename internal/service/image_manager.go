@@ -4,10 +4,11 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
-	"os/exec"
 	"sort"
 	"strings"
 	"time"
+
+	containerpkg "github.com/buildvigil/agent/internal/container"
 )
 
 const MaxImageHashes = 5
@@ -72,10 +73,10 @@ func (m *ImageManager) cleanupOldImages(imageTagPrefix string, keep int) error {
 }
 
 func (m *ImageManager) listImages(imageTagPrefix string) ([]DockerImage, error) {
-	output, err := exec.Command(
-		"docker", "images",
+	output, err := containerpkg.DockerCombinedOutput(
+		containerpkg.DockerInspect, "images",
 		"--format", "{{.Repository}}:{{.Tag}}|{{.ID}}|{{.CreatedAt}}",
-	).CombinedOutput()
+	)
 	if err != nil {
 		return nil, fmt.Errorf("docker images failed: %w", err)
 	}
@@ -129,7 +130,7 @@ func (m *ImageManager) getImageHash(imageID, imageTag string) string {
 }
 
 func (m *ImageManager) removeImage(imageID string) error {
-	output, err := exec.Command("docker", "rmi", "-f", imageID).CombinedOutput()
+	output, err := containerpkg.DockerCombinedOutput(containerpkg.DockerStop, "rmi", "-f", imageID)
 	if err != nil {
 		return fmt.Errorf("docker rmi failed: %w (%s)", err, strings.TrimSpace(string(output)))
 	}
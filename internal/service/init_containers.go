@@ -0,0 +1,116 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/buildvigil/agent/internal/api"
+)
+
+// DefaultInitContainerTimeout bounds an init container that sets no timeout.
+const DefaultInitContainerTimeout = 5 * time.Minute
+
+// validateInitContainers checks init container definitions before a deploy.
+func validateInitContainers(service api.Service) error {
+	seen := make(map[string]bool, len(service.InitContainers))
+	for _, initContainer := range service.InitContainers {
+		if !sidecarNamePattern.MatchString(initContainer.Name) {
+			return fmt.Errorf("invalid init container name %q; use lowercase letters, digits and dashes", initContainer.Name)
+		}
+		if seen[initContainer.Name] {
+			return fmt.Errorf("duplicate init container name %q", initContainer.Name)
+		}
+		seen[initContainer.Name] = true
+		if strings.TrimSpace(initContainer.Image) == "" {
+			return fmt.Errorf("init container %s: image is required", initContainer.Name)
+		}
+		if initContainer.Timeout < 0 {
+			return fmt.Errorf("init container %s: invalid timeout %d", initContainer.Name, initContainer.Timeout)
+		}
+		if _, err := splitShellWords(initContainer.Command); err != nil {
+			return fmt.Errorf("init container %s: invalid command: %w", initContainer.Name, err)
+		}
+		args, err := splitShellWords(initContainer.DockerRunArgs)
+		if err != nil {
+			return fmt.Errorf("init container %s: invalid docker_run_args: %w", initContainer.Name, err)
+		}
+		if flag, _ := resourceLimitArg(args); flag != "" {
+			return fmt.Errorf("init container %s: docker_run_args contains disallowed option %q, which the agent caps", initContainer.Name, flag)
+		}
+		if err := checkRunArgs(args); err != nil {
+			return fmt.Errorf("init container %s: %w", initContainer.Name, err)
+		}
+	}
+	return nil
+}
+
+// runInitContainers runs a service's init containers in order, each to a
+// zero exit, before its new container is started. Init containers see the
+// service's environment and secrets plus their own variables, mount the
+// service's volumes, and join the stack network.
+func (m *Manager) runInitContainers(service api.Service) error {
+	if len(service.InitContainers) == 0 {
+		return nil
+	}
+	volumeArgs, err := m.volumeArgs(service)
+	if err != nil {
+		return err
+	}
+	m.reportLifecycle(service, "initializing", "unknown", "")
+	baseEnv := m.prepareEnvironment(service)
+	for _, initContainer := range service.InitContainers {
+		env := append([]string{}, baseEnv...)
+		keys := make([]string, 0, len(initContainer.EnvironmentVars))
+		for key := range initContainer.EnvironmentVars {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			env = append(env, fmt.Sprintf("%s=%s", key, initContainer.EnvironmentVars[key]))
+		}
+
+		timeout := DefaultInitContainerTimeout
+		if initContainer.Timeout > 0 {
+			timeout = time.Duration(initContainer.Timeout) * time.Second
+		}
+		deadline := time.Now().Add(timeout)
+		deployLimited := !m.deployDeadline.IsZero() && m.deployDeadline.Before(deadline)
+		if deployLimited {
+			deadline = m.deployDeadline
+		}
+
+		name := fmt.Sprintf("%s-%s-init-%s", ContainerPrefix, service.ID, initContainer.Name)
+		start := time.Now()
+		ctx, cancel := context.WithDeadline(context.Background(), deadline)
+		runArgs, _ := splitShellWords(initContainer.DockerRunArgs)
+		runArgs = append(append([]string{}, volumeArgs...), runArgs...)
+		exitCode, output, err := runTaskContainer(ctx, service.ID, name, initContainer.Image, env, runArgs, parseContainerCommand(initContainer.Command))
+		expired := errors.Is(ctx.Err(), context.DeadlineExceeded)
+		cancel()
+
+		output = tailOutput(output, taskOutputLimit)
+		switch {
+		case expired && deployLimited:
+			err = fmt.Errorf("%w: init container %s still running", ErrDeployTimeout, initContainer.Name)
+		case expired:
+			err = fmt.Errorf("init container %s timed out after %s", initContainer.Name, timeout)
+		case err != nil:
+			err = fmt.Errorf("init container %s failed: %w", initContainer.Name, err)
+		case exitCode != 0:
+			err = fmt.Errorf("init container %s exited with code %d", initContainer.Name, exitCode)
+		}
+		if err != nil {
+			m.logDeploy(service.ID, "", "error", "Init container %s failed: %v\n%s", name, err, output)
+			m.reportLifecycle(service, "error", "unknown", err.Error())
+			return err
+		}
+		log.Printf("[ServiceManager] Init container complete: service=%s init=%s elapsed=%s", service.ID, initContainer.Name, time.Since(start))
+		m.logDeploy(service.ID, "", "info", "Init container %s completed in %s", name, time.Since(start).Round(time.Millisecond))
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/buildvigil/agent/internal/api"
+)
+
+func TestRunInitContainers(t *testing.T) {
+	t.Logf("Testing init containers run in order before deploy...")
+	original := runTaskContainer
+	defer func() { runTaskContainer = original }()
+
+	var ran []string
+	var seedEnv []string
+	runTaskContainer = func(ctx context.Context, stackID, name, imageID string, env, runArgs, command []string) (int, string, error) {
+		ran = append(ran, name)
+		if strings.HasSuffix(name, "-seed") {
+			seedEnv = env
+			return 3, "relation does not exist\n", nil
+		}
+		return 0, "", nil
+	}
+
+	m := NewManager(t.TempDir(), nil, nil, 3000, 3010, false)
+	service := api.Service{
+		ID:              "api",
+		EnvironmentVars: map[string]string{"DATABASE_HOST": "db"},
+		InitContainers: []api.InitContainer{
+			{Name: "wait-db", Image: "busybox", Command: "sh -c 'until nc -z db 5432; do sleep 1; done'"},
+			{Name: "seed", Image: "api-tools", EnvironmentVars: map[string]string{"SEED": "1"}},
+			{Name: "never", Image: "busybox"},
+		},
+	}
+	if err := validateInitContainers(service); err != nil {
+		t.Fatalf("Expected valid init containers, got %v", err)
+	}
+
+	err := m.runInitContainers(service)
+	if err == nil || !strings.Contains(err.Error(), "init container seed exited with code 3") {
+		t.Fatalf("Expected seed failure, got %v", err)
+	}
+	if len(ran) != 2 || ran[0] != "potato-cloud-api-init-wait-db" {
+		t.Errorf("Expected wait-db then seed, got %v", ran)
+	}
+	if strings.Join(seedEnv, " ") != "DATABASE_HOST=db SEED=1" {
+		t.Errorf("Expected service env plus init env, got %v", seedEnv)
+	}
+
+	if err := validateInitContainers(api.Service{InitContainers: []api.InitContainer{{Name: "x", Image: "a", DockerRunArgs: "--name foo"}}}); err == nil {
+		t.Error("Expected managed run args to be rejected")
+	}
+	t.Logf("✓ Init containers gate the deploy")
+}
@@ -0,0 +1,86 @@
+package service
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/buildvigil/agent/internal/api"
+)
+
+// zoneinfoDir is the host's IANA time zone database.
+var zoneinfoDir = "/usr/share/zoneinfo"
+
+var localePattern = regexp.MustCompile(`^([a-zA-Z]{2,3}(_[A-Z]{2})?|C|POSIX)(\.[A-Za-z0-9-]+)?(@[a-z]+)?$`)
+
+// localeSettings is a service's time zone and locale.
+type localeSettings struct {
+	timezone string
+	locale   string
+}
+
+// localeSettingsForService returns the validated time zone and locale for a
+// service. Both are optional; unset values leave the image's defaults alone.
+func localeSettingsForService(service api.Service) (localeSettings, error) {
+	settings := localeSettings{
+		timezone: strings.TrimSpace(service.Timezone),
+		locale:   strings.TrimSpace(service.Locale),
+	}
+	if settings.timezone != "" {
+		if settings.timezone == "Local" {
+			return settings, fmt.Errorf("invalid timezone %q; expected an IANA name such as Europe/Berlin", service.Timezone)
+		}
+		if _, err := time.LoadLocation(settings.timezone); err != nil {
+			return settings, fmt.Errorf("invalid timezone %q; expected an IANA name such as Europe/Berlin", service.Timezone)
+		}
+	}
+	if settings.locale != "" && !localePattern.MatchString(settings.locale) {
+		return settings, fmt.Errorf("invalid locale %q; expected a name such as en_US.UTF-8", service.Locale)
+	}
+	return settings, nil
+}
+
+// env returns TZ, LANG and LC_ALL for the configured settings. Variables the
+// service sets itself are left to it.
+func (s localeSettings) env(service api.Service) []string {
+	var env []string
+	add := func(key, value string) {
+		if value == "" {
+			return
+		}
+		if _, ok := service.EnvironmentVars[key]; ok {
+			return
+		}
+		env = append(env, key+"="+value)
+	}
+	add("TZ", s.timezone)
+	add("LANG", s.locale)
+	add("LC_ALL", s.locale)
+	return env
+}
+
+// runArgs mounts the host's zone file at /etc/localtime, and at its zoneinfo
+// path so TZ resolves in images that ship without tzdata. Nothing is mounted
+// when the host lacks the zone or the service mounts /etc/localtime itself.
+func (s localeSettings) runArgs(service api.Service) []string {
+	if s.timezone == "" {
+		return nil
+	}
+	for _, volume := range service.Volumes {
+		if path.Clean(volume.Path) == "/etc/localtime" {
+			return nil
+		}
+	}
+	hostPath := filepath.Join(zoneinfoDir, filepath.FromSlash(s.timezone))
+	if info, err := os.Stat(hostPath); err != nil || info.IsDir() {
+		return nil
+	}
+	return []string{
+		"-v", hostPath + ":/etc/localtime:ro",
+		"-v", hostPath + ":/usr/share/zoneinfo/" + s.timezone + ":ro",
+	}
+}
@@ -0,0 +1,49 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/buildvigil/agent/internal/api"
+)
+
+func TestLocaleSettingsForService(t *testing.T) {
+	t.Logf("Testing time zone and locale settings...")
+	original := zoneinfoDir
+	defer func() { zoneinfoDir = original }()
+	zoneinfoDir = t.TempDir()
+	if err := os.MkdirAll(filepath.Join(zoneinfoDir, "Europe"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(zoneinfoDir, "Europe", "Berlin"), []byte("TZif"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	service := api.Service{Timezone: "Europe/Berlin", Locale: "de_DE.UTF-8", EnvironmentVars: map[string]string{"LC_ALL": "C"}}
+	settings, err := localeSettingsForService(service)
+	if err != nil {
+		t.Fatalf("Expected valid settings: %v", err)
+	}
+	if env := strings.Join(settings.env(service), " "); env != "TZ=Europe/Berlin LANG=de_DE.UTF-8" {
+		t.Errorf("Expected TZ and LANG with the service's LC_ALL kept, got %q", env)
+	}
+	hostPath := filepath.Join(zoneinfoDir, "Europe", "Berlin")
+	args := strings.Join(settings.runArgs(service), " ")
+	if args != "-v "+hostPath+":/etc/localtime:ro -v "+hostPath+":/usr/share/zoneinfo/Europe/Berlin:ro" {
+		t.Errorf("Unexpected mounts: %s", args)
+	}
+
+	service.Volumes = []api.Volume{{HostPath: "/etc/localtime", Path: "/etc/localtime", ReadOnly: true}}
+	if args := settings.runArgs(service); len(args) != 0 {
+		t.Errorf("Expected the service's own localtime mount to win, got %v", args)
+	}
+
+	for _, bad := range []api.Service{{Timezone: "Mars/Olympus"}, {Timezone: "Local"}, {Timezone: "../../etc/passwd"}, {Locale: "en_US.UTF-8; rm"}} {
+		if _, err := localeSettingsForService(bad); err == nil {
+			t.Errorf("Expected %+v to be rejected", bad)
+		}
+	}
+	t.Logf("✓ Time zone and locale settings validated")
+}
@@ -2,18 +2,22 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
-	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/buildvigil/agent/internal/api"
+	"github.com/buildvigil/agent/internal/chaos"
 	containerpkg "github.com/buildvigil/agent/internal/container"
+	"github.com/buildvigil/agent/internal/logging"
 	"github.com/buildvigil/agent/internal/secrets"
 	"github.com/buildvigil/agent/internal/state"
 )
@@ -23,7 +27,6 @@ const (
 	HealthCheckInterval    = 30 * time.Second
 	ConnectionDrainTimeout = 30 * time.Second
 	MaxConcurrentBuilds    = 3
-	DockerBuildTimeout     = 10 * time.Minute
 	ContainerPrefix        = "potato-cloud"
 	ImagePrefix            = "potato-cloud"
 )
@@ -37,6 +40,8 @@ type containerInfo struct {
 	containerName string
 	imageTag      string
 	port          int
+	containerID   string
+	deployID      string
 }
 
 // Manager handles Docker container lifecycle for services.
@@ -48,9 +53,74 @@ type Manager struct {
 	portMgr      *containerpkg.PortManager
 	generator    *containerpkg.Generator
 	proxyUpdater ProxyUpdater
+	canaryRouter CanaryRouter
 	lifecycle    LifecycleReporter
 	verbose      bool
 	mu           sync.RWMutex
+
+	// containersMu guards containers along with mu: writers hold both, and
+	// status readers take only containersMu so they don't wait for deploys.
+	containersMu sync.RWMutex
+
+	taskMu      sync.Mutex
+	taskResults map[string]api.TaskResult
+
+	driftMu    sync.Mutex
+	imageDrift map[string]api.ImageDrift
+
+	// healthResults caches CheckAllServicesHealth results; healthChecking
+	// marks services whose check is in flight.
+	healthMu       sync.Mutex
+	healthResults  map[string]HealthResult
+	healthChecking map[string]bool
+
+	eventsMu            sync.Mutex
+	lastContainerAction map[string]string
+
+	diagnosticsDir string
+	diagnostics    DiagnosticsReporter
+	buildLogs      BuildLogReporter
+	deployReports  DeployReporter
+	deployBuilt    bool   // The deploy in progress built an image
+	rollbackImage  string // Set while RollbackService redeploys an earlier image
+	trace          *deployTrace
+	pluginsDir     string
+	configFilesDir string
+	deployID       string
+	deployDeadline time.Time
+
+	// baking marks services whose canary is baking, with mu released.
+	// It is guarded by containersMu.
+	baking map[string]bool
+
+	// buildDockerHost or buildxBuilder, when set, build images off this host.
+	buildDockerHost string
+	buildxBuilder   string
+	// buildDir, when set, holds throwaway copies of repositories to build from.
+	buildDir string
+
+	// Disk budgets for image layers in MB, per service and in total.
+	imageServiceBudgetMB int
+	imageTotalBudgetMB   int
+
+	// prePulls are registry image pulls started ahead of their deploys.
+	prePullMu sync.Mutex
+	prePulls  map[string]*prePull
+
+	// probeSlots, when set, caps HTTP health probes in flight at once. Probe
+	// settings have their own lock since deploys probe under mu.
+	probeMu    sync.RWMutex
+	probeSlots chan struct{}
+	// probeDirectIP probes containers on their bridge IP instead of the
+	// published host port.
+	probeDirectIP bool
+
+	// limitCaps bounds the ulimits, sysctls and /dev/shm services request,
+	// and bindMounts the host directories they may mount. They have their
+	// own lock since deploys run under mu.
+	limitMu    sync.Mutex
+	limitCaps  resourceLimitCaps
+	bindMounts []string
 }
 
 // NewManager creates a new service manager.
@@ -60,6 +130,7 @@ func NewManager(reposPath string, stateMgr *state.Manager, secretsMgr *secrets.M
 		state:      stateMgr,
 		secretsMgr: secretsMgr,
 		containers: make(map[string]*containerInfo),
+		baking:     make(map[string]bool),
 		portMgr:    containerpkg.NewPortManager(portStart, portEnd),
 		generator:  containerpkg.NewGenerator(portStart, portEnd),
 		verbose:    verbose,
@@ -82,21 +153,124 @@ func (m *Manager) SetLifecycleReporter(reporter LifecycleReporter) {
 
 // DeployService deploys a service using Docker containers with zero-downtime.
 func (m *Manager) DeployService(service api.Service) error {
+	return m.deploy(service, "")
+}
+
+// deploy deploys a service from rollbackImage, when set, instead of
+// building or pulling its image.
+func (m *Manager) deploy(service api.Service, rollbackImage string) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	if m.isBaking(service.ID) {
+		return fmt.Errorf("%w: service %s", ErrCanaryBaking, service.ID)
+	}
 	m.reportLifecycle(service, "building", "unknown", "")
 
 	containerName := fmt.Sprintf("%s-%s", ContainerPrefix, service.ID)
 	imageTag := fmt.Sprintf("%s-%s:latest", ImagePrefix, service.ID)
 	log.Printf("[ServiceManager] Deploy start: service=%s name=%s", service.ID, service.Name)
 
+	started := time.Now()
+	m.trace = newDeployTrace()
+	m.deployID = newDeployID(service, started)
+	m.deployDeadline = started.Add(maxDeployDuration(service))
+	m.rollbackImage = rollbackImage
+	defer func() {
+		m.rollbackImage = ""
+		m.trace = nil
+		m.deployID = ""
+		m.deployDeadline = time.Time{}
+		m.deployBuilt = false
+	}()
+	m.logDeploy(service.ID, "", "info", "Deploy %s started: commit=%s", m.deployID, service.GitCommit)
+
+	if err := validateSidecars(service); err != nil {
+		m.reportLifecycle(service, "error", "unknown", err.Error())
+		m.reportDeploy(service, started, "", api.DeployErrorInvalidConfig, err)
+		return err
+	}
+	if err := validateInitContainers(service); err != nil {
+		m.reportLifecycle(service, "error", "unknown", err.Error())
+		m.reportDeploy(service, started, "", api.DeployErrorInvalidConfig, err)
+		return err
+	}
+	if err := validateConfigFiles(service); err != nil {
+		m.reportLifecycle(service, "error", "unknown", err.Error())
+		m.reportDeploy(service, started, "", api.DeployErrorInvalidConfig, err)
+		return err
+	}
+	if _, err := reloadSignalForService(service); err != nil {
+		m.reportLifecycle(service, "error", "unknown", err.Error())
+		m.reportDeploy(service, started, "", api.DeployErrorInvalidConfig, err)
+		return err
+	}
+	if err := validateDependencies(service); err != nil {
+		m.reportLifecycle(service, "error", "unknown", err.Error())
+		m.reportDeploy(service, started, "", api.DeployErrorInvalidConfig, err)
+		return err
+	}
+	if err := validateCanary(service); err != nil {
+		m.reportLifecycle(service, "error", "unknown", err.Error())
+		m.reportDeploy(service, started, "", api.DeployErrorInvalidConfig, err)
+		return err
+	}
+	if err := m.validateVolumes(service); err != nil {
+		m.reportLifecycle(service, "error", "unknown", err.Error())
+		m.reportDeploy(service, started, "", api.DeployErrorInvalidConfig, err)
+		return err
+	}
+
+	var err error
 	currentInfo, exists := m.containers[service.ID]
-	if exists && currentInfo.port != 0 {
+	if exists && rollbackImage == "" {
+		m.recordRollbackTarget(currentInfo)
+	}
+	if IsWorker(service) {
+		log.Printf("[ServiceManager] Deploy mode: worker service=%s replace=%t", service.ID, exists)
+		err = m.deployWorker(service, currentInfo, containerName, imageTag)
+	} else if exists && currentInfo.port != 0 {
 		log.Printf("[ServiceManager] Deploy mode: blue/green service=%s activePort=%d", service.ID, currentInfo.port)
-		return m.blueGreenDeploy(service, currentInfo, containerName, imageTag)
+		err = m.blueGreenDeploy(service, currentInfo, containerName, imageTag)
+	} else {
+		log.Printf("[ServiceManager] Deploy mode: initial service=%s", service.ID)
+		err = m.initialDeploy(service, containerName, imageTag)
+	}
+	if err != nil {
+		m.logDeploy(service.ID, "", "error", "Deploy %s failed: %v", m.deployID, err)
+		if errors.Is(err, ErrDeployTimeout) {
+			m.reportLifecycle(service, "deploy_timeout", "unknown", err.Error())
+		}
+		m.collectDiagnostics(service, err)
+		m.reportDeploy(service, started, "", "", err)
+		return err
+	}
+	m.pruneConfigFiles(service, true)
+	m.reportDeploy(service, started, m.recordImageDigest(service.ID), "", nil)
+	if info, ok := m.containers[service.ID]; ok {
+		m.logDeploy(service.ID, info.containerID, "info", "Deploy %s complete: container=%s port=%d", m.deployID, info.containerName, info.port)
+		_ = m.runPlugins(HookPostDeploy, service, info.imageTag, info.containerName, info.port)
 	}
-	log.Printf("[ServiceManager] Deploy mode: initial service=%s", service.ID)
-	return m.initialDeploy(service, containerName, imageTag)
+	return nil
+}
+
+// deployInProgress is the state of the deploy holding mu.
+type deployInProgress struct {
+	trace         *deployTrace
+	deployID      string
+	deadline      time.Time
+	rollbackImage string
+	built         bool
+}
+
+// currentDeploy saves the state of the deploy in progress, so it can be
+// restored after mu was released and another deploy ran meanwhile.
+func (m *Manager) currentDeploy() deployInProgress {
+	return deployInProgress{m.trace, m.deployID, m.deployDeadline, m.rollbackImage, m.deployBuilt}
+}
+
+// restoreDeploy puts back the state saved by currentDeploy. Callers hold mu.
+func (m *Manager) restoreDeploy(d deployInProgress) {
+	m.trace, m.deployID, m.deployDeadline, m.rollbackImage, m.deployBuilt = d.trace, d.deployID, d.deadline, d.rollbackImage, d.built
 }
 
 func (m *Manager) initialDeploy(service api.Service, containerName, imageTag string) error {
@@ -107,16 +281,34 @@ func (m *Manager) initialDeploy(service api.Service, containerName, imageTag str
 		m.reportLifecycle(service, "error", "unknown", err.Error())
 		return fmt.Errorf("failed to prepare image: %w", err)
 	}
+	if err := m.checkDeployDeadline(service); err != nil {
+		return err
+	}
+	if err := m.checkDependencies(service); err != nil {
+		return err
+	}
+	if err := m.runInitContainers(service); err != nil {
+		return err
+	}
 
-	portPair, err := m.portMgr.Allocate(service.ID)
+	allocate := m.portMgr.AllocateIn
+	if service.SinglePort {
+		allocate = m.portMgr.AllocateSingle
+	}
+	portPair, err := allocate(service.ID, service.PortRange)
 	if err != nil {
 		return fmt.Errorf("failed to allocate port: %w", err)
 	}
+	if portPair, err = m.revalidatePorts(service, 0); err != nil {
+		m.portMgr.Release(service.ID)
+		return err
+	}
 	port := portPair.BluePort
 	log.Printf("[ServiceManager] Port allocated: service=%s hostPort=%d", service.ID, port)
 
 	env := m.prepareEnvironment(service)
-	if err := validateDockerRunArgs(service); err != nil {
+	runArgs, err := m.serviceRunArgs(service)
+	if err != nil {
 		m.portMgr.Release(service.ID)
 		m.reportLifecycle(service, "error", "unknown", err.Error())
 		return err
@@ -130,25 +322,36 @@ func (m *Manager) initialDeploy(service api.Service, containerName, imageTag str
 		containerPort = 8000
 	}
 	log.Printf("[ServiceManager] Container port resolved: service=%s containerPort=%d", service.ID, containerPort)
-	containerID, err := m.startContainer(containerName, imageRef, port, containerPort, env, parseDockerRunArgs(service), containerCommandForService(service))
+	containerID, err := m.startContainer(containerName, imageRef, port, containerPort, env, runArgs, containerCommandForService(service))
 	if err != nil {
 		m.portMgr.Release(service.ID)
 		m.reportLifecycle(service, "error", "unknown", err.Error())
 		return fmt.Errorf("failed to start container: %w", err)
 	}
 	log.Printf("[ServiceManager] Container started: service=%s container=%s id=%s", service.ID, containerName, containerID)
+	m.logDeploy(service.ID, containerID, "info", "Container %s started on port %d", containerName, port)
 
 	if err := ConnectContainerToStackNetwork(containerID, service.ID); err != nil {
-		_ = m.stopContainer(containerName)
+		_ = m.stopContainer(service, containerName)
 		m.portMgr.Release(service.ID)
 		m.reportLifecycle(service, "error", "unknown", err.Error())
 		return fmt.Errorf("failed to connect container to stack network: %w", err)
 	}
 	log.Printf("[ServiceManager] Network connected: service=%s network=stack-%s-network", service.ID, service.ID)
 
+	if err := m.startSidecars(service, containerName, containerID); err != nil {
+		_ = m.stopContainer(service, containerName)
+		_ = DisconnectContainerFromStackNetwork(containerID, service.ID)
+		m.portMgr.Release(service.ID)
+		m.reportLifecycle(service, "error", "unknown", err.Error())
+		return err
+	}
+
 	m.reportLifecycle(service, "health_check", "unknown", "")
-	if err := m.healthCheck(service, containerName, port); err != nil {
-		_ = m.stopContainer(containerName)
+	if err := m.healthCheckWithSidecars(service, containerName, port); err != nil {
+		m.captureFailedContainer(containerName)
+		m.captureContainerLogs(service.ID, m.deployID, containerID, containerName)
+		_ = m.stopContainer(service, containerName)
 		_ = DisconnectContainerFromStackNetwork(containerID, service.ID)
 		m.portMgr.Release(service.ID)
 		m.reportLifecycle(service, "error", "unhealthy", err.Error())
@@ -156,10 +359,24 @@ func (m *Manager) initialDeploy(service api.Service, containerName, imageTag str
 	}
 	log.Printf("[ServiceManager] Health check passed: service=%s", service.ID)
 
+	if err := m.runPlugins(HookPreCutover, service, imageRef, containerName, port); err != nil {
+		_ = m.stopContainer(service, containerName)
+		_ = DisconnectContainerFromStackNetwork(containerID, service.ID)
+		m.portMgr.Release(service.ID)
+		m.reportLifecycle(service, "error", "unknown", err.Error())
+		return err
+	}
+	if err := m.checkDeployDeadline(service); err != nil {
+		_ = m.stopContainer(service, containerName)
+		_ = DisconnectContainerFromStackNetwork(containerID, service.ID)
+		m.portMgr.Release(service.ID)
+		return err
+	}
+
 	if m.proxyUpdater != nil {
 		if err := m.proxyUpdater(service.ID, port); err != nil {
 			log.Printf("[ServiceManager] Proxy update failed during initial deploy: service=%s err=%v", service.ID, err)
-			_ = m.stopContainer(containerName)
+			_ = m.stopContainer(service, containerName)
 			_ = DisconnectContainerFromStackNetwork(containerID, service.ID)
 			m.portMgr.Release(service.ID)
 			m.reportLifecycle(service, "error", "unknown", fmt.Sprintf("proxy update failed: %v", err))
@@ -167,12 +384,14 @@ func (m *Manager) initialDeploy(service api.Service, containerName, imageTag str
 		}
 	}
 
-	m.containers[service.ID] = &containerInfo{
+	m.setContainer(service.ID, &containerInfo{
 		service:       service,
 		containerName: containerName,
 		imageTag:      imageRef,
 		port:          port,
-	}
+		containerID:   containerID,
+		deployID:      m.deployID,
+	})
 
 	if err := m.state.SaveServiceProcess(&state.ServiceProcess{
 		ServiceID:     service.ID,
@@ -187,6 +406,7 @@ func (m *Manager) initialDeploy(service api.Service, containerName, imageTag str
 		ActivePort:    port,
 		BaseImage:     service.BaseImage,
 		Language:      service.Language,
+		DeployID:      m.deployID,
 		Status:        "running",
 		StartedAt:     time.Now().UTC(),
 	}); err != nil {
@@ -207,11 +427,40 @@ func (m *Manager) blueGreenDeploy(service api.Service, currentInfo *containerInf
 		m.reportLifecycle(service, "error", "unknown", err.Error())
 		return fmt.Errorf("failed to prepare new image: %w", err)
 	}
+	if err := m.checkDeployDeadline(service); err != nil {
+		return err
+	}
+	if err := m.checkDependencies(service); err != nil {
+		return err
+	}
+	if err := m.runInitContainers(service); err != nil {
+		return err
+	}
 
-	portPair, exists := m.portMgr.Get(service.ID)
-	if !exists {
+	if _, exists := m.portMgr.Get(service.ID); !exists {
 		return fmt.Errorf("service port pair not found")
 	}
+	portPair, err := m.revalidatePorts(service, currentInfo.port)
+	if err != nil {
+		m.reportLifecycle(service, "error", "unknown", err.Error())
+		return err
+	}
+	if portPair.Single() {
+		// Borrow a second port for this deploy only.
+		if portPair, err = m.portMgr.AddSpare(service.ID, service.PortRange); err != nil {
+			m.reportLifecycle(service, "error", "unknown", err.Error())
+			return fmt.Errorf("failed to allocate deploy port: %w", err)
+		}
+	}
+	if service.SinglePort {
+		// Keep only the port left serving traffic, whether or not the deploy
+		// succeeded.
+		defer func() {
+			if err := m.portMgr.Reserve(service.ID, containerpkg.PortPair{BluePort: m.containers[service.ID].port}); err != nil {
+				m.logVerbose("Failed to release deploy port for %s: %v", service.ID, err)
+			}
+		}()
+	}
 	targetPort, err := selectBlueGreenTargetPort(currentInfo.port, portPair)
 	if err != nil {
 		m.reportLifecycle(service, "error", "unknown", err.Error())
@@ -220,7 +469,8 @@ func (m *Manager) blueGreenDeploy(service api.Service, currentInfo *containerInf
 	log.Printf("[ServiceManager] Blue/green port: service=%s activePort=%d targetPort=%d", service.ID, currentInfo.port, targetPort)
 
 	env := m.prepareEnvironment(service)
-	if err := validateDockerRunArgs(service); err != nil {
+	runArgs, err := m.serviceRunArgs(service)
+	if err != nil {
 		m.reportLifecycle(service, "error", "unknown", err.Error())
 		return err
 	}
@@ -233,32 +483,65 @@ func (m *Manager) blueGreenDeploy(service api.Service, currentInfo *containerInf
 		containerPort = 8000
 	}
 	log.Printf("[ServiceManager] Blue/green container port: service=%s containerPort=%d", service.ID, containerPort)
-	greenContainerID, err := m.startContainer(greenContainerName, imageRef, targetPort, containerPort, env, parseDockerRunArgs(service), containerCommandForService(service))
+	greenContainerID, err := m.startContainer(greenContainerName, imageRef, targetPort, containerPort, env, runArgs, containerCommandForService(service))
 	if err != nil {
 		m.reportLifecycle(service, "error", "unknown", err.Error())
 		return fmt.Errorf("failed to start green container: %w", err)
 	}
 	log.Printf("[ServiceManager] Green container started: service=%s container=%s id=%s", service.ID, greenContainerName, greenContainerID)
+	m.logDeploy(service.ID, greenContainerID, "info", "Container %s started on port %d", greenContainerName, targetPort)
 
 	if err := ConnectContainerToStackNetwork(greenContainerID, service.ID); err != nil {
-		_ = m.stopContainer(greenContainerName)
+		_ = m.stopContainer(service, greenContainerName)
 		m.reportLifecycle(service, "error", "unknown", err.Error())
 		return fmt.Errorf("failed to connect green container to stack network: %w", err)
 	}
+	if err := m.startSidecars(service, greenContainerName, greenContainerID); err != nil {
+		_ = m.stopContainer(service, greenContainerName)
+		_ = DisconnectContainerFromStackNetwork(greenContainerID, service.ID)
+		m.reportLifecycle(service, "error", "unknown", err.Error())
+		return err
+	}
 
 	m.reportLifecycle(service, "health_check", "unknown", "")
-	if err := m.healthCheck(service, greenContainerName, targetPort); err != nil {
-		_ = m.stopContainer(greenContainerName)
+	if err := m.healthCheckWithSidecars(service, greenContainerName, targetPort); err != nil {
+		m.captureFailedContainer(greenContainerName)
+		m.captureContainerLogs(service.ID, m.deployID, greenContainerID, greenContainerName)
+		_ = m.stopContainer(service, greenContainerName)
 		_ = DisconnectContainerFromStackNetwork(greenContainerID, service.ID)
 		m.reportLifecycle(service, "error", "unhealthy", err.Error())
 		return fmt.Errorf("green container health check failed: %w", err)
 	}
 	log.Printf("[ServiceManager] Green health check passed: service=%s", service.ID)
 
+	if err := m.runPlugins(HookPreCutover, service, imageRef, greenContainerName, targetPort); err != nil {
+		_ = m.stopContainer(service, greenContainerName)
+		_ = DisconnectContainerFromStackNetwork(greenContainerID, service.ID)
+		m.reportLifecycle(service, "error", "unknown", err.Error())
+		return err
+	}
+	if err := m.checkDeployDeadline(service); err != nil {
+		_ = m.stopContainer(service, greenContainerName)
+		_ = DisconnectContainerFromStackNetwork(greenContainerID, service.ID)
+		return err
+	}
+
+	// Rollbacks go straight back to a release that already served traffic.
+	if service.Canary != nil && m.canaryRouter != nil && m.rollbackImage == "" {
+		if err := m.runCanary(service, currentInfo.port, greenContainerName, targetPort); err != nil {
+			log.Printf("[ServiceManager] Canary failed, rolling back: service=%s err=%v", service.ID, err)
+			m.captureContainerLogs(service.ID, m.deployID, greenContainerID, greenContainerName)
+			_ = m.stopContainer(service, greenContainerName)
+			_ = DisconnectContainerFromStackNetwork(greenContainerID, service.ID)
+			m.reportLifecycle(service, "error", "unhealthy", err.Error())
+			return err
+		}
+	}
+
 	if m.proxyUpdater != nil {
 		if err := m.proxyUpdater(service.ID, targetPort); err != nil {
 			log.Printf("[ServiceManager] Proxy update failed, rolling back: service=%s err=%v", service.ID, err)
-			_ = m.stopContainer(greenContainerName)
+			_ = m.stopContainer(service, greenContainerName)
 			_ = DisconnectContainerFromStackNetwork(greenContainerID, service.ID)
 			m.reportLifecycle(service, "error", "unknown", fmt.Sprintf("proxy update failed: %v", err))
 			return fmt.Errorf("proxy update failed, rolled back to blue: %w", err)
@@ -266,9 +549,22 @@ func (m *Manager) blueGreenDeploy(service api.Service, currentInfo *containerInf
 		log.Printf("[ServiceManager] Blue/green traffic cutover: service=%s fromPort=%d toPort=%d", service.ID, currentInfo.port, targetPort)
 	}
 
-	time.Sleep(ConnectionDrainTimeout)
+	m.sleepWithinDeadline(ConnectionDrainTimeout)
+	if err := m.checkDeployDeadline(service); err != nil {
+		// Blue is still running; send traffic back to it and drop green.
+		log.Printf("[ServiceManager] Deploy timed out during drain, restoring blue: service=%s port=%d", service.ID, currentInfo.port)
+		if m.proxyUpdater != nil {
+			if err := m.proxyUpdater(service.ID, currentInfo.port); err != nil {
+				log.Printf("[ServiceManager] Failed to restore blue route: service=%s err=%v", service.ID, err)
+			}
+		}
+		_ = m.stopContainer(service, greenContainerName)
+		_ = DisconnectContainerFromStackNetwork(greenContainerID, service.ID)
+		return err
+	}
 
-	if err := m.stopContainer(currentInfo.containerName); err != nil {
+	m.captureContainerLogs(service.ID, currentInfo.deployID, currentInfo.containerID, currentInfo.containerName)
+	if err := m.stopContainer(currentInfo.service, currentInfo.containerName); err != nil {
 		m.logVerbose("Failed to stop blue container: %v", err)
 	}
 	_ = DisconnectContainerFromStackNetwork(currentInfo.containerName, service.ID)
@@ -279,14 +575,20 @@ func (m *Manager) blueGreenDeploy(service api.Service, currentInfo *containerInf
 		m.logVerbose("Failed to rename green container %s to %s: %v", greenContainerName, containerName, err)
 	} else {
 		activeContainerName = containerName
+		m.renameSidecars(service, greenContainerName, containerName)
 	}
 
-	m.containers[service.ID] = &containerInfo{
-			service:       service,
-			containerName: activeContainerName,
-			imageTag:      imageRef,
-			port:          targetPort,
-		}
+	m.setContainer(service.ID, &containerInfo{
+		service:       service,
+		containerName: activeContainerName,
+		imageTag:      imageRef,
+		port:          targetPort,
+		containerID:   greenContainerID,
+		deployID:      m.deployID,
+	})
+	if service.SinglePort {
+		portPair = containerpkg.PortPair{BluePort: targetPort}
+	}
 
 	if err := m.state.SaveServiceProcess(&state.ServiceProcess{
 		ServiceID:     service.ID,
@@ -296,11 +598,12 @@ func (m *Manager) blueGreenDeploy(service api.Service, currentInfo *containerInf
 		ContainerID:   greenContainerID,
 		ContainerName: activeContainerName,
 		ImageTag:      imageRef,
-			Port:          portPair.BluePort,
-			GreenPort:     portPair.GreenPort,
-			ActivePort:    targetPort,
+		Port:          portPair.BluePort,
+		GreenPort:     portPair.GreenPort,
+		ActivePort:    targetPort,
 		BaseImage:     service.BaseImage,
 		Language:      service.Language,
+		DeployID:      m.deployID,
 		Status:        "running",
 		StartedAt:     time.Now().UTC(),
 	}); err != nil {
@@ -332,7 +635,10 @@ func selectBlueGreenTargetPort(activePort int, pair containerpkg.PortPair) (int,
 
 func (m *Manager) buildServiceImage(service api.Service, imageTag string) (string, error) {
 	start := time.Now()
-	repoPath := filepath.Join(m.reposPath, service.ID)
+	repoPath, cleanupBuildDir, err := m.prepareBuildDir(service, filepath.Join(m.reposPath, service.ID))
+	if err != nil {
+		return "", err
+	}
 	contextPath := repoPath
 	if strings.TrimSpace(service.DockerContext) != "" {
 		if filepath.IsAbs(service.DockerContext) {
@@ -368,6 +674,7 @@ func (m *Manager) buildServiceImage(service api.Service, imageTag string) (strin
 	}
 	if !exists {
 		log.Printf("[ServiceManager] Generating Dockerfile: service=%s language=%s baseImage=%s", service.ID, service.Language, service.BaseImage)
+		m.warnIfArchUnsupported(service, m.generator.BaseImageFor(service.Language, service.BaseImage, contextPath))
 		dockerfileContent, err := m.generator.GenerateDockerfile(
 			service.Language,
 			service.BaseImage,
@@ -380,6 +687,7 @@ func (m *Manager) buildServiceImage(service api.Service, imageTag string) (strin
 		if err != nil {
 			return "", fmt.Errorf("failed to generate Dockerfile: %w", err)
 		}
+		dockerfileContent = m.generator.AddHealthCheck(dockerfileContent, containerPort, service.HealthCheckPath, service.HealthCheckInterval)
 
 		dockerfilePath, err = m.generator.WriteDockerfile(dockerfileContent, contextPath)
 		if err != nil {
@@ -393,24 +701,39 @@ func (m *Manager) buildServiceImage(service api.Service, imageTag string) (strin
 		}()
 	}
 
-	log.Printf("[ServiceManager] Docker build start: service=%s image=%s timeout=%s", service.ID, imageTag, DockerBuildTimeout)
-	buildCtx, buildCancel := context.WithTimeout(context.Background(), DockerBuildTimeout)
+	buildTimeout := containerpkg.DockerTimeout(containerpkg.DockerBuild)
+	log.Printf("[ServiceManager] Docker build start: service=%s image=%s timeout=%s builder=%s", service.ID, imageTag, buildTimeout, m.builderName())
+	buildCtx, buildCancel := context.WithTimeout(context.Background(), buildTimeout)
 	defer buildCancel()
-	buildCmd := exec.CommandContext(buildCtx, "docker", "build", "-t", imageTag, "-f", dockerfilePath, contextPath)
-	if m.verbose {
-		buildCmd.Stdout = os.Stdout
-		buildCmd.Stderr = os.Stderr
-	}
+	buildArgs := m.buildCommandArgs(service, imageTag, dockerfilePath, contextPath)
+	buildCmd := exec.CommandContext(buildCtx, "docker", buildArgs...)
+	buildLog := newLineTail(BuildLogLines)
+	buildOutput := []io.Writer{buildLog}
+	if m.isVerbose() {
+		buildOutput = append(buildOutput, os.Stdout)
+	}
+	if m.trace != nil {
+		buildOutput = append(buildOutput, m.trace.buildLog)
+	}
+	buildCmd.Stdout = io.MultiWriter(buildOutput...)
+	buildCmd.Stderr = buildCmd.Stdout
+	buildStart := time.Now()
 	if err := buildCmd.Run(); err != nil {
 		if buildCtx.Err() == context.DeadlineExceeded {
-			return "", fmt.Errorf("docker build timed out after %s: %w", DockerBuildTimeout, err)
+			m.recordBuildLog(service, buildLog, buildStart, "timed_out")
+			return "", fmt.Errorf("docker build timed out after %s: %w", buildTimeout, err)
 		}
+		m.recordBuildLog(service, buildLog, buildStart, "failed")
 		return "", fmt.Errorf("docker build failed: %w", err)
 	}
+	m.recordBuildLog(service, buildLog, buildStart, "succeeded")
+	if err := m.loadRemoteImage(buildCtx, imageTag); err != nil {
+		return "", err
+	}
+	cleanupBuildDir()
 	log.Printf("[ServiceManager] Docker build complete: service=%s elapsed=%s", service.ID, time.Since(start))
 
-	inspectCmd := exec.Command("docker", "inspect", "--format={{.Id}}", imageTag)
-	output, err := inspectCmd.Output()
+	output, err := containerpkg.DockerOutput(containerpkg.DockerInspect, "inspect", "--format={{.Id}}", imageTag)
 	if err != nil {
 		return "", fmt.Errorf("failed to inspect image: %w", err)
 	}
@@ -421,18 +744,35 @@ func (m *Manager) buildServiceImage(service api.Service, imageTag string) (strin
 	}
 	log.Printf("[ServiceManager] Image retention: service=%s keep=%d", service.ID, retention)
 	m.cleanupOldImages(service.ID, retention)
+	m.enforceImageBudgets(service)
 	log.Printf("[ServiceManager] Build done: service=%s imageID=%s totalElapsed=%s", service.ID, imageID, time.Since(start))
 	return imageID, nil
 }
 
-func (m *Manager) pullDockerImage(imageRef string) error {
+func (m *Manager) pullDockerImage(service api.Service, imageRef string) error {
+	if m.takePrePull(service, imageRef) {
+		log.Printf("[ServiceManager] Docker pull skipped, image pre-pulled: image=%s", imageRef)
+		return nil
+	}
+	return m.runDockerPull(service, imageRef)
+}
+
+func (m *Manager) runDockerPull(service api.Service, imageRef string) error {
 	log.Printf("[ServiceManager] Docker pull start: image=%s", imageRef)
-	cmd := exec.Command("docker", "pull", imageRef)
-	if m.verbose {
+	m.warnIfArchUnsupported(service, imageRef)
+	pullArgs := append([]string{"pull"}, platformArgs(service)...)
+	pullTimeout := containerpkg.DockerTimeout(containerpkg.DockerPull)
+	ctx, cancel := context.WithTimeout(context.Background(), pullTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "docker", append(pullArgs, imageRef)...)
+	if m.isVerbose() {
 		cmd.Stdout = os.Stdout
 		cmd.Stderr = os.Stderr
 	}
 	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return fmt.Errorf("docker pull timed out after %s: %w", pullTimeout, err)
+		}
 		return fmt.Errorf("docker pull failed: %w", err)
 	}
 	log.Printf("[ServiceManager] Docker pull complete: image=%s", imageRef)
@@ -440,12 +780,27 @@ func (m *Manager) pullDockerImage(imageRef string) error {
 }
 
 func (m *Manager) resolveDeployImage(service api.Service, imageTag string) (string, error) {
-	if strings.EqualFold(strings.TrimSpace(service.ServiceType), "docker") {
+	if err := m.runPlugins(HookPreBuild, service, "", "", 0); err != nil {
+		return "", err
+	}
+	imageRef, err := m.fetchDeployImage(service, imageTag)
+	if err != nil {
+		return "", err
+	}
+	_ = m.runPlugins(HookPostBuild, service, imageRef, "", 0)
+	return imageRef, nil
+}
+
+func (m *Manager) fetchDeployImage(service api.Service, imageTag string) (string, error) {
+	if m.rollbackImage != "" {
+		return m.rollbackImage, nil
+	}
+	if UsesPrebuiltImage(service) {
 		imageRef := strings.TrimSpace(service.DockerImage)
 		if imageRef == "" {
 			return "", fmt.Errorf("docker_image is required for docker service type")
 		}
-		if err := m.pullDockerImage(imageRef); err != nil {
+		if err := m.pullDockerImage(service, imageRef); err != nil {
 			return "", err
 		}
 		return imageRef, nil
@@ -454,13 +809,40 @@ func (m *Manager) resolveDeployImage(service api.Service, imageTag string) (stri
 }
 
 func (m *Manager) prepareEnvironment(service api.Service) []string {
-	env := make([]string, 0, len(service.EnvironmentVars))
+	env := make([]string, 0, len(service.EnvironmentVars)+len(service.Secrets))
 	for key, value := range service.EnvironmentVars {
 		env = append(env, fmt.Sprintf("%s=%s", key, value))
 	}
+	for key, value := range m.serviceSecrets(service) {
+		env = append(env, fmt.Sprintf("%s=%s", key, value))
+	}
+	if locale, err := localeSettingsForService(service); err == nil {
+		env = append(env, locale.env(service)...)
+	}
 	return env
 }
 
+// serviceSecrets returns the secrets a service references, preferring the
+// service's own value over its group's.
+func (m *Manager) serviceSecrets(service api.Service) map[string]string {
+	if m.secretsMgr == nil || len(service.Secrets) == 0 {
+		return nil
+	}
+	values := make(map[string]string, len(service.Secrets))
+	for _, name := range service.Secrets {
+		value, err := m.secretsMgr.GetSecret(name, service.ID)
+		if err != nil && service.Group != "" {
+			value, err = m.secretsMgr.GetSecret(name, secrets.GroupScope(service.Group))
+		}
+		if err != nil {
+			log.Printf("[ServiceManager] Warning: secret unavailable: service=%s name=%s err=%v", service.ID, name, err)
+			continue
+		}
+		values[name] = value
+	}
+	return values
+}
+
 func (m *Manager) startContainer(name, imageID string, hostPort int, containerPort int, env []string, runArgs []string, command []string) (string, error) {
 	if containerExists(name) {
 		log.Printf("[ServiceManager] Existing container found, removing: %s", name)
@@ -468,8 +850,10 @@ func (m *Manager) startContainer(name, imageID string, hostPort int, containerPo
 			log.Printf("[ServiceManager] Failed to remove existing container %s: %v", name, err)
 		}
 	}
-	portBinding := fmt.Sprintf("%d:%d", hostPort, containerPort)
-	args := []string{"run", "-d", "--name", name, "-p", portBinding}
+	args := []string{"run", "-d", "--name", name}
+	if hostPort > 0 {
+		args = append(args, "-p", fmt.Sprintf("%d:%d", hostPort, containerPort))
+	}
 	args = append(args, runArgs...)
 
 	for _, e := range env {
@@ -480,9 +864,9 @@ func (m *Manager) startContainer(name, imageID string, hostPort int, containerPo
 	args = append(args, command...)
 
 	log.Printf("[ServiceManager] Docker run: container=%s image=%s hostPort=%d containerPort=%d envCount=%d", name, imageID, hostPort, containerPort, len(env))
+	noteAgentAction(name)
 
-	cmd := exec.Command("docker", args...)
-	output, err := cmd.CombinedOutput()
+	output, err := containerpkg.DockerCombinedOutput(containerpkg.DockerRun, args...)
 	if err != nil {
 		return "", fmt.Errorf("failed to start container: %w (output: %s)", err, strings.TrimSpace(string(output)))
 	}
@@ -490,9 +874,67 @@ func (m *Manager) startContainer(name, imageID string, hostPort int, containerPo
 	return strings.TrimSpace(string(output)), nil
 }
 
+// serviceArch returns the architecture a service's image is built and run for.
+func serviceArch(service api.Service) string {
+	if arch := containerpkg.NormalizeArch(service.Arch); arch != "" {
+		return arch
+	}
+	return containerpkg.HostArch()
+}
+
+// platformArgs returns docker --platform flags when a service overrides its
+// architecture; host-arch services rely on Docker's default selection.
+func platformArgs(service api.Service) []string {
+	arch := serviceArch(service)
+	if arch == containerpkg.HostArch() {
+		return nil
+	}
+	return []string{"--platform", containerpkg.PlatformForArch(arch)}
+}
+
+// warnIfArchUnsupported logs a warning when an image's manifest shows it is
+// not published for the service's target architecture.
+func (m *Manager) warnIfArchUnsupported(service api.Service, image string) {
+	arch := serviceArch(service)
+	if err := containerpkg.VerifyImageArch(image, arch); err != nil {
+		log.Printf("[ServiceManager] Warning: base image architecture check: service=%s arch=%s err=%v", service.ID, arch, err)
+		if m.trace != nil {
+			m.trace.buildLog.Write([]byte(fmt.Sprintf("warning: %v\n", err)))
+		}
+	}
+}
+
+// containerRunArgs returns the extra docker run arguments for a service:
+// platform, restart policy, service label, stop flags, entrypoint and workdir
+// overrides and time zone mounts followed by user-supplied run args.
+func containerRunArgs(service api.Service) ([]string, error) {
+	if err := validateContainerArgs(service); err != nil {
+		return nil, err
+	}
+	restartPolicy, err := restartPolicyForService(service)
+	if err != nil {
+		return nil, err
+	}
+	stop, err := stopSettingsForService(service)
+	if err != nil {
+		return nil, err
+	}
+	locale, err := localeSettingsForService(service)
+	if err != nil {
+		return nil, err
+	}
+	args := append(platformArgs(service), "--restart", restartPolicy, "--label", ServiceLabel+"="+service.ID)
+	args = append(args, stop.runArgs()...)
+	args = append(args, entrypointRunArgs(service)...)
+	args = append(args, locale.runArgs(service)...)
+	return append(args, parseDockerRunArgs(service)...), nil
+}
+
+// parseDockerRunArgs returns a service's docker_run_args, split with shell
+// quoting. validateContainerArgs has checked they parse.
 func parseDockerRunArgs(service api.Service) []string {
-	if strings.EqualFold(strings.TrimSpace(service.ServiceType), "docker") {
-		args := strings.Fields(strings.TrimSpace(service.DockerRunArgs))
+	if UsesPrebuiltImage(service) {
+		args, _ := splitShellWords(service.DockerRunArgs)
 		if len(args) > 0 {
 			return args
 		}
@@ -500,78 +942,106 @@ func parseDockerRunArgs(service api.Service) []string {
 	return nil
 }
 
-func validateDockerRunArgs(service api.Service) error {
-	if !strings.EqualFold(strings.TrimSpace(service.ServiceType), "docker") {
+// validateContainerArgs checks that a service's docker_run_args, run_command
+// and entrypoint parse and that the run args leave agent-managed flags and
+// resource limits alone.
+func validateContainerArgs(service api.Service) error {
+	if err := validateEntrypoint(service); err != nil {
+		return err
+	}
+	if !UsesPrebuiltImage(service) {
 		return nil
 	}
-	args := strings.Fields(strings.TrimSpace(service.DockerRunArgs))
+	if _, err := splitShellWords(service.RunCommand); err != nil {
+		return fmt.Errorf("invalid run_command: %w", err)
+	}
+	args, err := splitShellWords(service.DockerRunArgs)
+	if err != nil {
+		return fmt.Errorf("invalid docker_run_args: %w", err)
+	}
+	if flag, field := resourceLimitArg(args); flag != "" {
+		return fmt.Errorf("docker_run_args contains disallowed option %q; use %s, which the agent caps", flag, field)
+	}
+	return checkRunArgs(args)
+}
+
+// checkRunArgs rejects user-supplied docker run flags the agent manages.
+func checkRunArgs(args []string) error {
 	forbidden := map[string]struct{}{
-		"-d":         {},
-		"--detach":   {},
-		"--name":     {},
-		"-p":         {},
-		"--publish":  {},
-		"--network":  {},
-		"--hostname": {},
+		"-d":             {},
+		"--detach":       {},
+		"--name":         {},
+		"-p":             {},
+		"--publish":      {},
+		"--network":      {},
+		"--hostname":     {},
+		"--restart":      {},
+		"--stop-signal":  {},
+		"--stop-timeout": {},
 	}
 	for _, arg := range args {
 		key := arg
 		if idx := strings.Index(arg, "="); idx > 0 {
 			key = arg[:idx]
 		}
+		if strings.HasPrefix(key, "-v") && !strings.HasPrefix(key, "--") {
+			key = "-v"
+		}
 		if _, blocked := forbidden[key]; blocked {
-			return fmt.Errorf("docker_run_args contains disallowed option %q; agent manages name/port/network", key)
+			return fmt.Errorf("docker_run_args contains disallowed option %q; agent manages name/port/network/restart (use restart_policy)", key)
+		}
+		if _, blocked := mountFlags[key]; blocked {
+			return fmt.Errorf("docker_run_args contains disallowed option %q; use volumes, which the agent checks against allowed_bind_mounts", key)
 		}
 	}
 	return nil
 }
 
-func containerCommandForService(service api.Service) []string {
-	if !strings.EqualFold(strings.TrimSpace(service.ServiceType), "docker") {
-		return nil
-	}
-	return parseContainerCommand(service.RunCommand)
+// mountFlags are the docker run flags that mount host paths or other
+// containers' volumes. Mounts go through a service's volumes instead.
+var mountFlags = map[string]struct{}{
+	"-v":             {},
+	"--volume":       {},
+	"--mount":        {},
+	"--volumes-from": {},
 }
 
-func parseContainerCommand(runCommand string) []string {
-	trimmed := strings.TrimSpace(runCommand)
-	if trimmed == "" {
+// containerCommandForService returns the command passed after the image: the
+// entrypoint's arguments, then the run command for services with a Docker
+// image.
+func containerCommandForService(service api.Service) []string {
+	command := entrypointCommand(service)
+	if UsesPrebuiltImage(service) {
+		command = append(command, parseContainerCommand(service.RunCommand)...)
+	}
+	if len(command) == 0 {
 		return nil
 	}
-	return strings.Fields(trimmed)
+	return command
 }
 
-func (m *Manager) stopContainer(name string) error {
-	if strings.TrimSpace(name) == "" {
+// parseContainerCommand splits a command override with shell quoting.
+// Callers validate the command first; one that doesn't parse yields no
+// override.
+func parseContainerCommand(runCommand string) []string {
+	args, _ := splitShellWords(runCommand)
+	if len(args) == 0 {
 		return nil
 	}
-
-	stopOut, stopErr := exec.Command("docker", "stop", "-t", "10", name).CombinedOutput()
-	if stopErr != nil {
-		msg := strings.ToLower(string(stopOut))
-		if !strings.Contains(msg, "no such container") && !strings.Contains(msg, "no such object") && !strings.Contains(msg, "is not running") {
-			return fmt.Errorf("failed to stop container: %w (output: %s)", stopErr, strings.TrimSpace(string(stopOut)))
-		}
-	}
-
-	rmOut, rmErr := exec.Command("docker", "rm", "-f", name).CombinedOutput()
-	if rmErr != nil {
-		msg := strings.ToLower(string(rmOut))
-		if !strings.Contains(msg, "no such container") && !strings.Contains(msg, "no such object") {
-			return fmt.Errorf("failed to remove container: %w (output: %s)", rmErr, strings.TrimSpace(string(rmOut)))
-		}
-	}
-
-	return nil
+	return args
 }
 
 func (m *Manager) healthCheck(service api.Service, containerName string, port int) error {
+	if chaos.Inject(chaos.HealthTimeout) {
+		return chaos.Error(chaos.HealthTimeout, "health check timed out")
+	}
 	healthPath := strings.TrimSpace(service.HealthCheckPath)
 	if healthPath == "" {
 		status, err := getContainerStatus(containerName)
 		if err != nil {
 			return fmt.Errorf("failed to read container status: %w", err)
 		}
+		m.traceHealthCheck("container=%s status=%s", containerName, status)
 		if status == "running" {
 			return nil
 		}
@@ -590,51 +1060,170 @@ func (m *Manager) healthCheck(service api.Service, containerName string, port in
 		interval = time.Second
 	}
 
-	client := &http.Client{Timeout: 5 * time.Second}
-	url := fmt.Sprintf("http://localhost:%d%s", port, healthPath)
+	url := m.probeURL(service, containerName, port, healthPath)
 	deadline := time.Now().Add(HealthCheckTimeout)
+	deployLimited := !m.deployDeadline.IsZero() && m.deployDeadline.Before(deadline)
+	if deployLimited {
+		deadline = m.deployDeadline
+	}
 	attempts := 0
 	start := time.Now()
 	log.Printf("[ServiceManager] Health check start: service=%s url=%s interval=%s timeout=%s", service.ID, url, interval, HealthCheckTimeout)
 
 	for {
 		attempts++
-		resp, err := client.Get(url)
+		resp, err := m.probeHTTP(url)
 		if err == nil && resp.StatusCode >= 200 && resp.StatusCode < 300 {
-			resp.Body.Close()
+			m.traceHealthCheck("attempt=%d url=%s status=%d", attempts, url, resp.StatusCode)
+			closeProbeBody(resp)
 			log.Printf("[ServiceManager] Health check success: service=%s attempts=%d elapsed=%s", service.ID, attempts, time.Since(start))
 			return nil
 		}
 		if resp != nil {
 			log.Printf("[ServiceManager] Health check attempt failed: service=%s attempt=%d status=%d", service.ID, attempts, resp.StatusCode)
-			resp.Body.Close()
+			m.traceHealthCheck("attempt=%d url=%s status=%d", attempts, url, resp.StatusCode)
+			closeProbeBody(resp)
 		} else if err != nil {
 			log.Printf("[ServiceManager] Health check attempt error: service=%s attempt=%d err=%v", service.ID, attempts, err)
+			m.traceHealthCheck("attempt=%d url=%s err=%v", attempts, url, err)
 		}
 
 		if time.Now().After(deadline) {
+			if deployLimited {
+				return fmt.Errorf("%w: %s still failing after %d attempts", ErrDeployTimeout, url, attempts)
+			}
 			return fmt.Errorf("health check timeout for %s after %d attempts", url, attempts)
 		}
-		time.Sleep(interval)
+		m.sleepWithinDeadline(interval)
 	}
 }
 
-// GetServicePort returns the current port for a service.
-func (m *Manager) GetServicePort(serviceID string) (int, bool) {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
+// setContainer tracks a service's container. Callers hold m.mu.
+func (m *Manager) setContainer(serviceID string, info *containerInfo) {
+	m.containersMu.Lock()
+	defer m.containersMu.Unlock()
+	m.containers[serviceID] = info
+}
+
+// untrackContainer stops tracking a service's container. Callers hold m.mu.
+func (m *Manager) untrackContainer(serviceID string) {
+	m.containersMu.Lock()
+	defer m.containersMu.Unlock()
+	delete(m.containers, serviceID)
+}
 
+// trackedContainer returns a copy of a service's tracked container. It does
+// not take mu, so it answers while a deploy is running.
+func (m *Manager) trackedContainer(serviceID string) (containerInfo, bool) {
+	m.containersMu.RLock()
+	defer m.containersMu.RUnlock()
 	info, exists := m.containers[serviceID]
+	if !exists {
+		return containerInfo{}, false
+	}
+	return *info, true
+}
+
+// trackedContainers returns a copy of every tracked container, by service
+// ID. Like trackedContainer, it does not wait for deploys.
+func (m *Manager) trackedContainers() map[string]containerInfo {
+	m.containersMu.RLock()
+	defer m.containersMu.RUnlock()
+	tracked := make(map[string]containerInfo, len(m.containers))
+	for serviceID, info := range m.containers {
+		tracked[serviceID] = *info
+	}
+	return tracked
+}
+
+// GetServicePort returns the current port for a service.
+func (m *Manager) GetServicePort(serviceID string) (int, bool) {
+	info, exists := m.trackedContainer(serviceID)
 	if !exists {
 		return 0, false
 	}
 	return info.port, true
 }
 
+// ProbeService checks once whether a tracked service is up: its container is
+// running and, when a health check path is set, the path returns 2xx.
+func (m *Manager) ProbeService(serviceID string) (bool, error) {
+	info, exists := m.trackedContainer(serviceID)
+	if !exists {
+		return false, fmt.Errorf("service %s not found", serviceID)
+	}
+	return m.probeContainer(info.service, info.containerName, info.port)
+}
+
+// probeContainer checks once whether a service's container is up. It does
+// not take mu, so deploys can probe the containers they start.
+func (m *Manager) probeContainer(service api.Service, containerName string, port int) (bool, error) {
+	status, err := getContainerStatus(containerName)
+	if err != nil {
+		return false, err
+	}
+	if status != "running" {
+		return false, nil
+	}
+	if !probeSidecars(service, containerName) {
+		return false, nil
+	}
+	if command := strings.TrimSpace(service.HealthCheckCommand); command != "" {
+		return execInContainer(containerName, command) == nil, nil
+	}
+
+	healthPath := strings.TrimSpace(service.HealthCheckPath)
+	if healthPath == "" || port == 0 {
+		return true, nil
+	}
+	if !strings.HasPrefix(healthPath, "/") {
+		healthPath = "/" + healthPath
+	}
+	resp, err := m.probeHTTP(m.probeURL(service, containerName, port, healthPath))
+	if err != nil {
+		return false, nil
+	}
+	closeProbeBody(resp)
+	return resp.StatusCode >= 200 && resp.StatusCode < 300, nil
+}
+
+// RunningService describes a service container tracked by the manager.
+type RunningService struct {
+	ServiceID     string
+	Name          string
+	Hostname      string
+	ContainerName string
+	Port          int
+}
+
+// RunningServices returns the services with a tracked container, sorted by ID.
+func (m *Manager) RunningServices() []RunningService {
+	tracked := m.trackedContainers()
+	running := make([]RunningService, 0, len(tracked))
+	for id, info := range tracked {
+		hostname := info.service.Hostname
+		if IsWorker(info.service) {
+			hostname = ""
+		}
+		running = append(running, RunningService{
+			ServiceID:     id,
+			Name:          info.service.Name,
+			Hostname:      hostname,
+			ContainerName: info.containerName,
+			Port:          info.port,
+		})
+	}
+	sort.Slice(running, func(i, j int) bool { return running[i].ServiceID < running[j].ServiceID })
+	return running
+}
+
 // StopService stops a service and cleans up resources.
 func (m *Manager) StopService(serviceID string) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	if m.isBaking(serviceID) {
+		return fmt.Errorf("%w: service %s", ErrCanaryBaking, serviceID)
+	}
 
 	info, exists := m.containers[serviceID]
 	if !exists {
@@ -645,19 +1234,26 @@ func (m *Manager) StopService(serviceID string) error {
 		info = &containerInfo{
 			containerName: proc.ContainerName,
 			port:          proc.ActivePort,
+			containerID:   proc.ContainerID,
+			deployID:      proc.DeployID,
 		}
 		if info.containerName == "" {
 			info.containerName = fmt.Sprintf("%s-%s", ContainerPrefix, serviceID)
 		}
 	}
 
-	if err := m.stopContainer(info.containerName); err != nil {
+	m.captureContainerLogs(serviceID, info.deployID, info.containerID, info.containerName)
+	if err := m.stopContainer(info.service, info.containerName); err != nil {
 		return fmt.Errorf("failed to stop container: %w", err)
 	}
 
 	_ = DisconnectContainerFromStackNetwork(info.containerName, serviceID)
+	if err := removeServiceSidecars(serviceID); err != nil {
+		m.logVerbose("Failed to remove sidecars for %s: %v", serviceID, err)
+	}
+	m.pruneConfigFiles(api.Service{ID: serviceID}, false)
 	m.portMgr.Release(serviceID)
-	delete(m.containers, serviceID)
+	m.untrackContainer(serviceID)
 	if err := m.state.DeleteServiceProcess(serviceID); err != nil {
 		m.logVerbose("Failed to delete service state for %s: %v", serviceID, err)
 	}
@@ -671,6 +1267,11 @@ func (m *Manager) RecoverService(service api.Service) (int, bool, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
+	if IsWorker(service) {
+		recovered, err := m.recoverWorker(service)
+		return 0, recovered, err
+	}
+
 	if info, ok := m.containers[service.ID]; ok && info.port > 0 {
 		return info.port, true, nil
 	}
@@ -687,6 +1288,8 @@ func (m *Manager) RecoverService(service api.Service) (int, bool, error) {
 	greenPort := 0
 	imageTagFromState := ""
 	gitCommitFromState := ""
+	containerID := ""
+	deployID := ""
 
 	if proc != nil {
 		if proc.ContainerName != "" {
@@ -707,6 +1310,8 @@ func (m *Manager) RecoverService(service api.Service) (int, bool, error) {
 		if proc.GreenPort > 0 {
 			greenPort = proc.GreenPort
 		}
+		containerID = proc.ContainerID
+		deployID = proc.DeployID
 	}
 
 	status, err := getContainerStatus(containerName)
@@ -716,6 +1321,7 @@ func (m *Manager) RecoverService(service api.Service) (int, bool, error) {
 	if status != "running" {
 		return 0, false, nil
 	}
+	m.reconcileRestartPolicy(service, containerName)
 
 	containerPort := service.DockerContainerPort
 	if containerPort == 0 {
@@ -737,14 +1343,16 @@ func (m *Manager) RecoverService(service api.Service) (int, bool, error) {
 	}
 
 	// IMPORTANT: Use the ALLOCATED port pair, not Docker's mapped port.
-	// The port manager allocated (blue, green) = (even, odd) ports.
-	// Docker's port mapping (HostPort) may differ from our allocation.
-	// We must use the allocated ports to maintain consistency.
+	// Pairs may be non-adjacent or single ports depending on the pairing
+	// strategy; Docker's port mapping (HostPort) may differ from our
+	// allocation. We must use the allocated ports to maintain consistency.
 	allocatedPair, exists := m.portMgr.Get(service.ID)
 	if !exists {
 		// Port pair not found in memory, fallback to calculating from Docker mapping
 		// This shouldn't happen in normal operation
-		if bluePort == 0 {
+		if bluePort == 0 && service.SinglePort {
+			bluePort = activePort
+		} else if bluePort == 0 {
 			if activePort%2 == 0 {
 				bluePort = activePort
 				greenPort = activePort + 1
@@ -759,7 +1367,7 @@ func (m *Manager) RecoverService(service api.Service) (int, bool, error) {
 		greenPort = allocatedPair.GreenPort
 	}
 
-	if greenPort == 0 {
+	if greenPort == 0 && !service.SinglePort {
 		greenPort = bluePort + 1
 	}
 	if imageTagFromState != "" {
@@ -774,18 +1382,21 @@ func (m *Manager) RecoverService(service api.Service) (int, bool, error) {
 		m.logVerbose("Port reserve failed during recovery for %s: %v", service.ID, err)
 	}
 
-	m.containers[service.ID] = &containerInfo{
+	m.setContainer(service.ID, &containerInfo{
 		service:       service,
 		containerName: containerName,
 		imageTag:      imageTag,
 		port:          activePort,
-	}
+		containerID:   containerID,
+		deployID:      deployID,
+	})
 
 	if err := m.state.SaveServiceProcess(&state.ServiceProcess{
 		ServiceID:     service.ID,
 		ServiceName:   service.Name,
 		GitCommit:     gitCommit,
 		Runtime:       "docker",
+		ContainerID:   containerID,
 		ContainerName: containerName,
 		ImageTag:      imageTag,
 		Port:          bluePort,
@@ -793,6 +1404,7 @@ func (m *Manager) RecoverService(service api.Service) (int, bool, error) {
 		ActivePort:    activePort,
 		BaseImage:     service.BaseImage,
 		Language:      service.Language,
+		DeployID:      deployID,
 		Status:        "running",
 		StartedAt:     time.Now().UTC(),
 	}); err != nil {
@@ -802,12 +1414,11 @@ func (m *Manager) RecoverService(service api.Service) (int, bool, error) {
 	return activePort, true, nil
 }
 
-// GetServiceStatus returns the status of a service.
+// GetServiceStatus returns the status of a service. The container status
+// comes from the status cache when it is fresh. It does not wait for a
+// deploy in progress, which may report the container it is replacing.
 func (m *Manager) GetServiceStatus(serviceID string) (map[string]interface{}, error) {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-
-	info, exists := m.containers[serviceID]
+	info, exists := m.trackedContainer(serviceID)
 	if !exists {
 		return map[string]interface{}{
 			"running": false,
@@ -815,8 +1426,7 @@ func (m *Manager) GetServiceStatus(serviceID string) (map[string]interface{}, er
 		}, nil
 	}
 
-	cmd := exec.Command("docker", "inspect", "--format={{.State.Status}}", info.containerName)
-	output, err := cmd.Output()
+	status, err := cachedContainerStatus(info.containerName)
 	if err != nil {
 		return map[string]interface{}{
 			"running": false,
@@ -824,7 +1434,6 @@ func (m *Manager) GetServiceStatus(serviceID string) (map[string]interface{}, er
 		}, nil
 	}
 
-	status := strings.TrimSpace(string(output))
 	running := status == "running"
 
 	return map[string]interface{}{
@@ -866,29 +1475,27 @@ func (m *Manager) GetStackNetworkName(stackID string) string {
 	return GetStackNetworkName(stackID)
 }
 
-// CleanupStack deletes a stack and all its resources.
+// CleanupStack deletes a stack and all its resources, including the named
+// volumes of its services.
 func (m *Manager) CleanupStack(stackID string) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
 	for serviceID, info := range m.containers {
 		if strings.HasPrefix(serviceID, stackID) {
-			if err := m.stopContainer(info.containerName); err != nil {
+			if err := m.stopContainer(info.service, info.containerName); err != nil {
 				m.logVerbose("Failed to stop container %s: %v", info.containerName, err)
 			}
 			_ = DisconnectContainerFromStackNetwork(info.containerName, stackID)
-			delete(m.containers, serviceID)
-		}
-	}
-
-	for serviceID := range m.containers {
-		if strings.HasPrefix(serviceID, stackID) {
+			m.untrackContainer(serviceID)
 			m.portMgr.Release(serviceID)
 		}
 	}
+
 	if err := DeleteStackNetwork(stackID); err != nil {
 		m.logVerbose("Failed to delete stack network: %v", err)
 	}
+	m.removeStackVolumes(stackID)
 
 	m.logVerbose("Stack %s cleaned up successfully", stackID)
 	return nil
@@ -896,11 +1503,8 @@ func (m *Manager) CleanupStack(stackID string) error {
 
 // ListStackServices returns all services in a stack.
 func (m *Manager) ListStackServices(stackID string) []api.Service {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-
 	services := make([]api.Service, 0)
-	for _, info := range m.containers {
+	for _, info := range m.trackedContainers() {
 		if strings.HasPrefix(info.service.ID, stackID) {
 			services = append(services, info.service)
 		}
@@ -910,11 +1514,8 @@ func (m *Manager) ListStackServices(stackID string) []api.Service {
 
 // GetServiceCount returns the number of services in a stack.
 func (m *Manager) GetServiceCount(stackID string) int {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-
 	count := 0
-	for serviceID := range m.containers {
+	for serviceID := range m.trackedContainers() {
 		if strings.HasPrefix(serviceID, stackID) {
 			count++
 		}
@@ -927,8 +1528,14 @@ func (m *Manager) IsStackEmpty(stackID string) bool {
 	return m.GetServiceCount(stackID) == 0
 }
 
+// isVerbose reports whether verbose output is enabled locally or by a remote
+// log level override for the service module.
+func (m *Manager) isVerbose() bool {
+	return m.verbose || logging.DebugEnabled("service")
+}
+
 func (m *Manager) logVerbose(format string, args ...interface{}) {
-	if m.verbose {
+	if m.isVerbose() {
 		fmt.Printf("[ServiceManager] "+format+"\n", args...)
 	}
 }
@@ -940,7 +1547,7 @@ func (m *Manager) reportLifecycle(service api.Service, status, healthStatus, las
 }
 
 func runningHealthStatus(service api.Service) string {
-	if strings.TrimSpace(service.HealthCheckPath) != "" {
+	if strings.TrimSpace(service.HealthCheckPath) != "" || strings.TrimSpace(service.HealthCheckCommand) != "" {
 		return "healthy"
 	}
 	return "unknown"
@@ -0,0 +1,159 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/buildvigil/agent/internal/api"
+	"github.com/buildvigil/agent/internal/platform"
+)
+
+// PluginHook identifies a point in the deploy pipeline where plugins run.
+type PluginHook string
+
+const (
+	HookPreBuild   PluginHook = "pre-build"
+	HookPostBuild  PluginHook = "post-build"
+	HookPreCutover PluginHook = "pre-cutover"
+	HookPostDeploy PluginHook = "post-deploy"
+
+	// HookAlert runs outside the deploy pipeline whenever a local alert fires
+	// or resolves.
+	HookAlert PluginHook = "alert"
+
+	PluginTimeout = 2 * time.Minute
+)
+
+// PluginEvent is the JSON document written to a plugin's stdin.
+type PluginEvent struct {
+	Hook          PluginHook `json:"hook"`
+	ServiceID     string     `json:"service_id"`
+	ServiceName   string     `json:"service_name"`
+	GitCommit     string     `json:"git_commit,omitempty"`
+	Image         string     `json:"image,omitempty"`
+	ContainerName string     `json:"container_name,omitempty"`
+	Port          int        `json:"port,omitempty"`
+	Timestamp     time.Time  `json:"timestamp"`
+}
+
+// SetPluginsDir configures the directory scanned for hook plugins. Every
+// executable file in the directory is invoked at each hook point with the hook
+// name as its only argument and a PluginEvent on stdin.
+func (m *Manager) SetPluginsDir(dir string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pluginsDir = dir
+}
+
+// runPlugins invokes all plugins for a hook in name order. A failing plugin on a
+// pre-* hook aborts the deploy; failures on other hooks are only logged.
+func (m *Manager) runPlugins(hook PluginHook, service api.Service, image, containerName string, port int) error {
+	plugins := listPlugins(m.pluginsDir)
+	if len(plugins) == 0 {
+		return nil
+	}
+
+	payload, err := json.Marshal(PluginEvent{
+		Hook:          hook,
+		ServiceID:     service.ID,
+		ServiceName:   service.Name,
+		GitCommit:     service.GitCommit,
+		Image:         image,
+		ContainerName: containerName,
+		Port:          port,
+		Timestamp:     time.Now().UTC(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal plugin event: %w", err)
+	}
+
+	blocking := strings.HasPrefix(string(hook), "pre-")
+	for _, path := range plugins {
+		start := time.Now()
+		output, err := runPlugin(path, hook, payload)
+		name := filepath.Base(path)
+		if err != nil {
+			log.Printf("[ServiceManager] Plugin failed: hook=%s plugin=%s service=%s err=%v output=%s", hook, name, service.ID, err, output)
+			if blocking {
+				return fmt.Errorf("plugin %s failed at %s: %w", name, hook, err)
+			}
+			continue
+		}
+		log.Printf("[ServiceManager] Plugin ran: hook=%s plugin=%s service=%s elapsed=%s", hook, name, service.ID, time.Since(start))
+		if output != "" {
+			m.logVerbose("Plugin %s output: %s", name, output)
+		}
+	}
+	return nil
+}
+
+// NotifyPlugins runs every plugin in dir for a notification hook such as
+// HookAlert with event as JSON on stdin. It does not take the manager lock, so
+// notifications are not held up by an in-progress deploy. Failures are only
+// logged.
+func NotifyPlugins(dir string, hook PluginHook, event interface{}) {
+	plugins := listPlugins(dir)
+	if len(plugins) == 0 {
+		return
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("[ServiceManager] Failed to marshal plugin event: hook=%s err=%v", hook, err)
+		return
+	}
+	for _, path := range plugins {
+		output, err := runPlugin(path, hook, payload)
+		if err != nil {
+			log.Printf("[ServiceManager] Plugin failed: hook=%s plugin=%s err=%v output=%s", hook, filepath.Base(path), err, output)
+		}
+	}
+}
+
+func runPlugin(path string, hook PluginHook, payload []byte) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), PluginTimeout)
+	defer cancel()
+
+	cmd := platform.Unprivileged(exec.CommandContext(ctx, path, string(hook)))
+	cmd.Stdin = bytes.NewReader(payload)
+	output, err := cmd.CombinedOutput()
+	if ctx.Err() == context.DeadlineExceeded {
+		return strings.TrimSpace(string(output)), fmt.Errorf("timed out after %s", PluginTimeout)
+	}
+	return strings.TrimSpace(string(output)), err
+}
+
+// listPlugins returns executable regular files in dir, sorted by name.
+// Hidden files are skipped so editors' swap files are never executed.
+func listPlugins(dir string) []string {
+	if dir == "" {
+		return nil
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+
+	var plugins []string
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() || info.Mode().Perm()&0111 == 0 {
+			continue
+		}
+		plugins = append(plugins, filepath.Join(dir, entry.Name()))
+	}
+	sort.Strings(plugins)
+	return plugins
+}
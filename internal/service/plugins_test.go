@@ -0,0 +1,87 @@
+package service
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/buildvigil/agent/internal/api"
+)
+
+func writePlugin(t *testing.T, dir, name, script string, mode os.FileMode) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(script), mode); err != nil {
+		t.Fatalf("Failed to write plugin %s: %v", name, err)
+	}
+}
+
+func TestListPlugins_SkipsNonExecutables(t *testing.T) {
+	t.Logf("Testing plugin discovery...")
+	dir := t.TempDir()
+	writePlugin(t, dir, "20-notify", "#!/bin/sh\n", 0755)
+	writePlugin(t, dir, "10-scan", "#!/bin/sh\n", 0755)
+	writePlugin(t, dir, "README", "docs", 0644)
+	writePlugin(t, dir, ".10-scan.swp", "", 0755)
+
+	plugins := listPlugins(dir)
+	if len(plugins) != 2 {
+		t.Fatalf("Expected 2 plugins, got %d: %v", len(plugins), plugins)
+	}
+	if filepath.Base(plugins[0]) != "10-scan" || filepath.Base(plugins[1]) != "20-notify" {
+		t.Errorf("Expected plugins in name order, got %v", plugins)
+	}
+	if listPlugins(filepath.Join(dir, "missing")) != nil {
+		t.Error("Expected no plugins for missing directory")
+	}
+	t.Logf("✓ Plugin discovery skips non-executables and hidden files")
+}
+
+func TestRunPlugins_PassesEventOnStdin(t *testing.T) {
+	t.Logf("Testing plugin invocation...")
+	dir := t.TempDir()
+	out := filepath.Join(t.TempDir(), "event.json")
+	writePlugin(t, dir, "capture", "#!/bin/sh\necho \"$1\" > "+out+".hook\ncat > "+out+"\n", 0755)
+
+	m := NewManager(t.TempDir(), nil, nil, 3000, 3010, false)
+	m.SetPluginsDir(dir)
+	service := api.Service{ID: "svc-1", Name: "api", GitCommit: "abc123"}
+	if err := m.runPlugins(HookPostBuild, service, "sha256:deadbeef", "", 0); err != nil {
+		t.Fatalf("Expected plugins to succeed: %v", err)
+	}
+
+	hook, err := os.ReadFile(out + ".hook")
+	if err != nil || string(hook) != "post-build\n" {
+		t.Errorf("Expected hook argument post-build, got %q (err=%v)", hook, err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("Plugin did not receive event: %v", err)
+	}
+	var event PluginEvent
+	if err := json.Unmarshal(data, &event); err != nil {
+		t.Fatalf("Failed to decode event: %v", err)
+	}
+	if event.ServiceID != "svc-1" || event.Image != "sha256:deadbeef" || event.Hook != HookPostBuild {
+		t.Errorf("Unexpected event: %+v", event)
+	}
+	t.Logf("✓ Plugin received hook name and JSON event")
+}
+
+func TestRunPlugins_FailureBlocksOnlyPreHooks(t *testing.T) {
+	t.Logf("Testing plugin failure handling...")
+	dir := t.TempDir()
+	writePlugin(t, dir, "reject", "#!/bin/sh\necho 'vulnerabilities found'\nexit 1\n", 0755)
+
+	m := NewManager(t.TempDir(), nil, nil, 3000, 3010, false)
+	m.SetPluginsDir(dir)
+	service := api.Service{ID: "svc-1"}
+
+	if err := m.runPlugins(HookPreCutover, service, "", "", 0); err == nil {
+		t.Error("Expected pre-cutover plugin failure to return an error")
+	}
+	if err := m.runPlugins(HookPostDeploy, service, "", "", 0); err != nil {
+		t.Errorf("Expected post-deploy plugin failure to be ignored, got %v", err)
+	}
+	t.Logf("✓ Pre-hook failures abort, post-hook failures are logged")
+}
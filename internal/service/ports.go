@@ -0,0 +1,52 @@
+package service
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/buildvigil/agent/internal/api"
+	containerpkg "github.com/buildvigil/agent/internal/container"
+)
+
+// SetPortPolicy configures ports that are never allocated (e.g. "3306" or
+// "5000-5010"), named sub-ranges services can select with port_range, and
+// the pairing strategy ("consecutive" or "any"; empty keeps the default).
+func (m *Manager) SetPortPolicy(excluded []string, named map[string]string, pairing string) error {
+	var excludedRanges []containerpkg.PortRange
+	for _, spec := range excluded {
+		r, err := containerpkg.ParsePortRange(spec)
+		if err != nil {
+			return fmt.Errorf("invalid excluded port: %w", err)
+		}
+		excludedRanges = append(excludedRanges, r)
+	}
+	namedRanges := make(map[string]containerpkg.PortRange, len(named))
+	for name, spec := range named {
+		r, err := containerpkg.ParsePortRange(spec)
+		if err != nil {
+			return fmt.Errorf("invalid port range %s: %w", name, err)
+		}
+		namedRanges[name] = r
+	}
+	if pairing != "" {
+		if err := m.portMgr.SetPairing(pairing); err != nil {
+			return err
+		}
+	}
+	m.portMgr.SetExcluded(excludedRanges)
+	m.portMgr.SetNamedRanges(namedRanges)
+	return nil
+}
+
+// revalidatePorts moves a service's allocation off ports that host services
+// claimed since it was made, keeping activePort for the running container.
+func (m *Manager) revalidatePorts(service api.Service, activePort int) (containerpkg.PortPair, error) {
+	pair, moved, err := m.portMgr.Revalidate(service.ID, service.PortRange, activePort)
+	if err != nil {
+		return pair, fmt.Errorf("failed to revalidate ports: %w", err)
+	}
+	if moved {
+		log.Printf("[ServiceManager] Port conflict, reallocated: service=%s blue=%d green=%d", service.ID, pair.BluePort, pair.GreenPort)
+	}
+	return pair, nil
+}
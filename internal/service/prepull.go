@@ -0,0 +1,77 @@
+package service
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"github.com/buildvigil/agent/internal/api"
+)
+
+// prePullMaxAge is how long a finished pre-pull stands in for the deploy's
+// own pull. Older ones are pulled again, in case the tag has moved.
+const prePullMaxAge = 10 * time.Minute
+
+// prePull is a registry image pull started before its deploy.
+type prePull struct {
+	started time.Time
+	done    chan struct{}
+	err     error
+}
+
+// prePullKey identifies a pull by image and target platform.
+func prePullKey(service api.Service, imageRef string) string {
+	return imageRef + "|" + strings.Join(platformArgs(service), " ")
+}
+
+// PrePullImages starts pulling the registry images of services in the
+// background, so their deploys find the image already local and the
+// cutover is not held up by the download. Images already being pulled are
+// skipped.
+func (m *Manager) PrePullImages(services []api.Service) {
+	for _, service := range services {
+		imageRef := strings.TrimSpace(service.DockerImage)
+		if !UsesPrebuiltImage(service) || imageRef == "" {
+			continue
+		}
+		key := prePullKey(service, imageRef)
+
+		m.prePullMu.Lock()
+		if existing, ok := m.prePulls[key]; ok && time.Since(existing.started) < prePullMaxAge {
+			m.prePullMu.Unlock()
+			continue
+		}
+		pull := &prePull{started: time.Now(), done: make(chan struct{})}
+		if m.prePulls == nil {
+			m.prePulls = make(map[string]*prePull)
+		}
+		m.prePulls[key] = pull
+		m.prePullMu.Unlock()
+
+		log.Printf("[ServiceManager] Pre-pulling image: service=%s image=%s", service.ID, imageRef)
+		go func(service api.Service, imageRef string) {
+			pull.err = m.runDockerPull(service, imageRef)
+			if pull.err != nil {
+				log.Printf("[ServiceManager] Pre-pull failed: service=%s image=%s error=%v", service.ID, imageRef, pull.err)
+			}
+			close(pull.done)
+		}(service, imageRef)
+	}
+}
+
+// takePrePull waits for a pre-pull of imageRef, if one was started, and
+// reports whether it left the image local. The pre-pull is used up, so a
+// later deploy pulls again.
+func (m *Manager) takePrePull(service api.Service, imageRef string) bool {
+	key := prePullKey(service, imageRef)
+	m.prePullMu.Lock()
+	pull, ok := m.prePulls[key]
+	delete(m.prePulls, key)
+	m.prePullMu.Unlock()
+	if !ok || time.Since(pull.started) >= prePullMaxAge {
+		return false
+	}
+
+	<-pull.done
+	return pull.err == nil
+}
@@ -0,0 +1,53 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/buildvigil/agent/internal/api"
+	"github.com/buildvigil/agent/internal/testutil"
+)
+
+func TestPrePullImages(t *testing.T) {
+	t.Logf("Testing registry images pulled ahead of deploy")
+
+	docker := testutil.NewFakeDocker(t)
+	m := NewManager(t.TempDir(), nil, nil, 3000, 3010, false)
+	svc := api.Service{ID: "svc-1", ServiceType: "docker", DockerImage: "nginx:1.25"}
+	built := api.Service{ID: "svc-2", GitURL: "https://example.com/app.git"}
+
+	m.PrePullImages([]api.Service{svc, built})
+	m.PrePullImages([]api.Service{svc})
+	if err := m.pullDockerImage(svc, "nginx:1.25"); err != nil {
+		t.Fatalf("pullDockerImage failed: %v", err)
+	}
+	if docker.CallCount("pull") != 1 || !docker.HasImage("nginx:1.25") {
+		t.Errorf("Expected the deploy to reuse a single pre-pull, got %d pulls", docker.CallCount("pull"))
+	}
+
+	// The pre-pull is used up; the next deploy pulls itself.
+	if err := m.pullDockerImage(svc, "nginx:1.25"); err != nil {
+		t.Fatalf("pullDockerImage failed: %v", err)
+	}
+	if docker.CallCount("pull") != 2 {
+		t.Errorf("Expected a fresh pull, got %d pulls", docker.CallCount("pull"))
+	}
+	t.Logf("✓ Deploy used the pre-pulled image")
+}
+
+func TestPrePullImages_FailureFallsBack(t *testing.T) {
+	t.Logf("Testing a failed pre-pull is retried by the deploy")
+
+	docker := testutil.NewFakeDocker(t)
+	docker.PullShouldFail.Store(true)
+	m := NewManager(t.TempDir(), nil, nil, 3000, 3010, false)
+	svc := api.Service{ID: "svc-1", ServiceType: "docker", DockerImage: "nginx:1.25"}
+
+	m.PrePullImages([]api.Service{svc})
+	if err := m.pullDockerImage(svc, "nginx:1.25"); err == nil {
+		t.Fatal("Expected the deploy's own pull to fail too")
+	}
+	if docker.CallCount("pull") != 2 {
+		t.Errorf("Expected the deploy to pull again, got %d pulls", docker.CallCount("pull"))
+	}
+	t.Logf("✓ Deploy pulled again after the pre-pull failed")
+}
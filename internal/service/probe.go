@@ -0,0 +1,175 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/buildvigil/agent/internal/api"
+	containerpkg "github.com/buildvigil/agent/internal/container"
+)
+
+// probeDNSTTL is how long a probe host's resolved addresses are reused.
+const probeDNSTTL = 30 * time.Second
+
+var (
+	containerIPAddress = defaultContainerIPAddress
+
+	// probeClient is shared by every HTTP health probe, so attempts reuse
+	// connections and host lookups instead of building a client each time.
+	probeClient = &http.Client{
+		Timeout: 5 * time.Second,
+		Transport: &http.Transport{
+			DialContext:         probeResolver.dialContext,
+			MaxIdleConnsPerHost: 2,
+			IdleConnTimeout:     30 * time.Second,
+		},
+	}
+	probeResolver = &cachingResolver{entries: make(map[string]resolvedHost)}
+)
+
+type resolvedHost struct {
+	addrs    []string
+	resolved time.Time
+}
+
+// cachingResolver remembers host lookups for probeDNSTTL.
+type cachingResolver struct {
+	mu      sync.Mutex
+	entries map[string]resolvedHost
+	lookup  func(ctx context.Context, host string) ([]string, error)
+}
+
+func (r *cachingResolver) resolve(ctx context.Context, host string) ([]string, error) {
+	if net.ParseIP(host) != nil {
+		return []string{host}, nil
+	}
+	r.mu.Lock()
+	entry, ok := r.entries[host]
+	r.mu.Unlock()
+	if ok && time.Since(entry.resolved) < probeDNSTTL {
+		return entry.addrs, nil
+	}
+
+	lookup := r.lookup
+	if lookup == nil {
+		lookup = net.DefaultResolver.LookupHost
+	}
+	addrs, err := lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	r.entries[host] = resolvedHost{addrs: addrs, resolved: time.Now()}
+	r.mu.Unlock()
+	return addrs, nil
+}
+
+// dialContext dials the first reachable address of the cached lookup.
+func (r *cachingResolver) dialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := r.resolve(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	dialer := &net.Dialer{Timeout: 2 * time.Second}
+	var lastErr error
+	for _, addr := range addrs {
+		conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(addr, port))
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+	}
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no addresses for %s", host)
+	}
+	return nil, lastErr
+}
+
+// SetHealthProbeParallelism caps how many HTTP health probes run at once
+// across all deploys and probes; 0 removes the cap.
+func (m *Manager) SetHealthProbeParallelism(limit int) {
+	m.probeMu.Lock()
+	defer m.probeMu.Unlock()
+	if limit <= 0 {
+		m.probeSlots = nil
+		return
+	}
+	m.probeSlots = make(chan struct{}, limit)
+}
+
+// SetHealthCheckDirectIP probes containers on their bridge network IP and
+// container port rather than through the port published on localhost. It
+// falls back to localhost when the container has no IP.
+func (m *Manager) SetHealthCheckDirectIP(enabled bool) {
+	m.probeMu.Lock()
+	defer m.probeMu.Unlock()
+	m.probeDirectIP = enabled
+}
+
+// probeURL returns the URL that checks healthPath on a service's container.
+func (m *Manager) probeURL(service api.Service, containerName string, port int, healthPath string) string {
+	m.probeMu.RLock()
+	direct := m.probeDirectIP
+	m.probeMu.RUnlock()
+	if direct {
+		if ip := containerIPAddress(containerName); ip != "" {
+			return fmt.Sprintf("http://%s%s", net.JoinHostPort(ip, fmt.Sprint(serviceContainerPort(service))), healthPath)
+		}
+	}
+	return fmt.Sprintf("http://localhost:%d%s", port, healthPath)
+}
+
+// probeHTTP sends one health probe, waiting for a free slot when probes are
+// capped.
+func (m *Manager) probeHTTP(url string) (*http.Response, error) {
+	m.probeMu.RLock()
+	slots := m.probeSlots
+	m.probeMu.RUnlock()
+	if slots != nil {
+		slots <- struct{}{}
+		defer func() { <-slots }()
+	}
+	return probeClient.Get(url)
+}
+
+// closeProbeBody drains a little of the body before closing it so the
+// connection can be reused.
+func closeProbeBody(resp *http.Response) {
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	resp.Body.Close()
+}
+
+// serviceContainerPort is the port the service listens on inside its
+// container.
+func serviceContainerPort(service api.Service) int {
+	if service.DockerContainerPort > 0 {
+		return service.DockerContainerPort
+	}
+	if service.Port > 0 {
+		return service.Port
+	}
+	return 8000
+}
+
+func defaultContainerIPAddress(containerName string) string {
+	output, err := containerpkg.DockerOutput(containerpkg.DockerInspect, "inspect", "--format", "{{range .NetworkSettings.Networks}}{{.IPAddress}} {{end}}", containerName)
+	if err != nil {
+		return ""
+	}
+	for _, ip := range strings.Fields(string(output)) {
+		if net.ParseIP(ip) != nil {
+			return ip
+		}
+	}
+	return ""
+}
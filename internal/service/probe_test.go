@@ -0,0 +1,121 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/buildvigil/agent/internal/api"
+)
+
+func TestCachingResolver(t *testing.T) {
+	t.Logf("Testing probe host lookups are cached...")
+
+	var lookups int
+	resolver := &cachingResolver{
+		entries: make(map[string]resolvedHost),
+		lookup: func(ctx context.Context, host string) ([]string, error) {
+			lookups++
+			return []string{"127.0.0.1"}, nil
+		},
+	}
+	for i := 0; i < 3; i++ {
+		addrs, err := resolver.resolve(context.Background(), "localhost")
+		if err != nil || len(addrs) != 1 || addrs[0] != "127.0.0.1" {
+			t.Fatalf("Unexpected lookup result: %v, %v", addrs, err)
+		}
+	}
+	if lookups != 1 {
+		t.Errorf("Expected one lookup, got %d", lookups)
+	}
+	if _, err := resolver.resolve(context.Background(), "10.0.0.5"); err != nil || lookups != 1 {
+		t.Errorf("Expected IP literals to skip lookups, got %d lookups (err=%v)", lookups, err)
+	}
+
+	resolver.entries["localhost"] = resolvedHost{addrs: []string{"127.0.0.1"}, resolved: time.Now().Add(-probeDNSTTL)}
+	resolver.resolve(context.Background(), "localhost")
+	if lookups != 2 {
+		t.Errorf("Expected an expired entry to be looked up again, got %d lookups", lookups)
+	}
+	t.Logf("✓ Lookups reused within the TTL")
+}
+
+func TestProbeHTTP_Parallelism(t *testing.T) {
+	t.Logf("Testing the health probe parallelism cap...")
+
+	var inFlight, peak atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+		inFlight.Add(-1)
+	}))
+	defer server.Close()
+
+	m := NewManager(t.TempDir(), nil, nil, 3000, 3010, false)
+	m.SetHealthProbeParallelism(2)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := m.probeHTTP(server.URL + "/health")
+			if err != nil {
+				t.Errorf("Probe failed: %v", err)
+				return
+			}
+			closeProbeBody(resp)
+		}()
+	}
+	wg.Wait()
+	if got := peak.Load(); got > 2 {
+		t.Errorf("Expected at most 2 probes in flight, got %d", got)
+	}
+	t.Logf("✓ Probes capped at the configured parallelism")
+}
+
+func TestProbeURL_DirectIP(t *testing.T) {
+	t.Logf("Testing health probes on the container's bridge IP...")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	_, portStr, _ := strings.Cut(strings.TrimPrefix(server.URL, "http://"), ":")
+	containerPort, _ := strconv.Atoi(portStr)
+
+	originalIP := containerIPAddress
+	t.Cleanup(func() { containerIPAddress = originalIP })
+	containerIPAddress = func(string) string { return "127.0.0.1" }
+
+	m := NewManager(t.TempDir(), nil, nil, 3000, 3010, false)
+	service := api.Service{ID: "svc", HealthCheckPath: "/health", DockerContainerPort: containerPort}
+	if got := m.probeURL(service, "potato-cloud-svc", 3005, "/health"); got != "http://localhost:3005/health" {
+		t.Errorf("Expected the published port by default, got %s", got)
+	}
+
+	m.SetHealthCheckDirectIP(true)
+	want := "http://127.0.0.1:" + portStr + "/health"
+	if got := m.probeURL(service, "potato-cloud-svc", 3005, "/health"); got != want {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+	if err := m.healthCheck(service, "potato-cloud-svc", 3005); err != nil {
+		t.Errorf("Expected health check on the container IP to pass, got %v", err)
+	}
+
+	containerIPAddress = func(string) string { return "" }
+	if got := m.probeURL(service, "potato-cloud-svc", 3005, "/health"); got != "http://localhost:3005/health" {
+		t.Errorf("Expected fallback to localhost without an IP, got %s", got)
+	}
+	t.Logf("✓ Direct IP probing with localhost fallback")
+}
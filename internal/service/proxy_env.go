@@ -0,0 +1,29 @@
+package service
+
+import (
+	"os"
+	"strings"
+)
+
+// proxyEnvNames are docker's predefined proxy build args.
+var proxyEnvNames = []string{"HTTP_PROXY", "HTTPS_PROXY", "NO_PROXY"}
+
+// proxyBuildArgs passes the agent's proxy environment to docker build so RUN
+// steps such as package installs work behind a corporate proxy. Docker keeps
+// predefined proxy args out of the image history.
+func proxyBuildArgs() []string {
+	var args []string
+	for _, name := range proxyEnvNames {
+		value := os.Getenv(name)
+		if value == "" {
+			value = os.Getenv(strings.ToLower(name))
+		}
+		if value == "" {
+			continue
+		}
+		args = append(args,
+			"--build-arg", name+"="+value,
+			"--build-arg", strings.ToLower(name)+"="+value)
+	}
+	return args
+}
@@ -0,0 +1,25 @@
+package service
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestProxyBuildArgs(t *testing.T) {
+	t.Logf("Testing proxy build args...")
+
+	t.Setenv("HTTP_PROXY", "")
+	t.Setenv("http_proxy", "")
+	t.Setenv("HTTPS_PROXY", "http://proxy.internal:3128")
+	t.Setenv("https_proxy", "")
+	t.Setenv("NO_PROXY", "")
+	t.Setenv("no_proxy", "localhost,.internal")
+
+	args := strings.Join(proxyBuildArgs(), " ")
+	expected := "--build-arg HTTPS_PROXY=http://proxy.internal:3128 --build-arg https_proxy=http://proxy.internal:3128 " +
+		"--build-arg NO_PROXY=localhost,.internal --build-arg no_proxy=localhost,.internal"
+	if args != expected {
+		t.Errorf("Unexpected build args:\n%s\nexpected:\n%s", args, expected)
+	}
+	t.Logf("✓ Proxy settings passed to docker build in both cases")
+}
@@ -0,0 +1,122 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/buildvigil/agent/internal/api"
+	containerpkg "github.com/buildvigil/agent/internal/container"
+)
+
+var allowedReloadSignals = map[string]bool{
+	"SIGHUP":  true,
+	"SIGUSR1": true,
+	"SIGUSR2": true,
+}
+
+var signalContainer = defaultSignalContainer
+
+func defaultSignalContainer(containerName, signal string) error {
+	noteAgentAction(containerName)
+	output, err := containerpkg.DockerCombinedOutput(containerpkg.DockerStop, "kill", "--signal", signal, containerName)
+	if err != nil {
+		return fmt.Errorf("docker kill failed: %w\nOutput: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// reloadSignalForService returns the validated reload signal, or "" when the
+// service always redeploys.
+func reloadSignalForService(service api.Service) (string, error) {
+	signal := strings.ToUpper(strings.TrimSpace(service.ReloadSignal))
+	if signal == "" {
+		return "", nil
+	}
+	if !strings.HasPrefix(signal, "SIG") {
+		signal = "SIG" + signal
+	}
+	if !allowedReloadSignals[signal] {
+		return "", fmt.Errorf("invalid reload_signal %q; expected SIGHUP, SIGUSR1, or SIGUSR2", service.ReloadSignal)
+	}
+	return signal, nil
+}
+
+// configOnlyChange reports whether next differs from current only in the
+// contents of its config files. Paths and modes must match, since they are
+// fixed by the running container's mounts.
+func configOnlyChange(current, next api.Service) bool {
+	if len(current.ConfigFiles) != len(next.ConfigFiles) {
+		return false
+	}
+	for i := range current.ConfigFiles {
+		if current.ConfigFiles[i].Path != next.ConfigFiles[i].Path || current.ConfigFiles[i].Mode != next.ConfigFiles[i].Mode {
+			return false
+		}
+	}
+	current.ConfigFiles, next.ConfigFiles = nil, nil
+	a, errA := json.Marshal(current)
+	b, errB := json.Marshal(next)
+	return errA == nil && errB == nil && bytes.Equal(a, b)
+}
+
+// ReloadConfig applies a config-file-only change to a running service without
+// a redeploy: the mounted files are rewritten in place and the container's
+// PID 1 gets the service's reload_signal. It returns false, leaving the
+// service untouched, when the change needs a full deploy.
+func (m *Manager) ReloadConfig(service api.Service) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	signal, err := reloadSignalForService(service)
+	if err != nil || signal == "" {
+		return false, err
+	}
+	info, ok := m.containers[service.ID]
+	if !ok || m.configFilesDir == "" || !configOnlyChange(info.service, service) {
+		return false, nil
+	}
+	if err := validateConfigFiles(service); err != nil {
+		return false, err
+	}
+	// The running container's files live in the directory named after the
+	// contents it was started with. After a restart the tracked definition
+	// may already be the new one, in which case the directory is missing and
+	// the service is redeployed instead.
+	currentDir := filepath.Join(m.configFilesDir, service.ID, configFilesHash(info.service.ConfigFiles))
+	if _, err := os.Stat(currentDir); err != nil {
+		return false, nil
+	}
+
+	for _, file := range service.ConfigFiles {
+		// Rewrite in place: a bind-mounted file keeps pointing at its inode,
+		// so replacing the file would leave the container with the old one.
+		hostPath := filepath.Join(currentDir, filepath.FromSlash(strings.TrimPrefix(file.Path, "/")))
+		if err := os.WriteFile(hostPath, []byte(file.Content), 0); err != nil {
+			return false, fmt.Errorf("failed to rewrite config file %s: %w", file.Path, err)
+		}
+	}
+	nextDir := filepath.Join(m.configFilesDir, service.ID, configFilesHash(service.ConfigFiles))
+	if nextDir != currentDir {
+		_ = os.RemoveAll(nextDir)
+		if err := os.Rename(currentDir, nextDir); err != nil {
+			m.logVerbose("Failed to rename config directory for %s: %v", service.ID, err)
+		}
+	}
+
+	if err := signalContainer(info.containerName, signal); err != nil {
+		return false, fmt.Errorf("failed to send %s: %w", signal, err)
+	}
+	m.containersMu.Lock()
+	info.service = service
+	m.containersMu.Unlock()
+	if err := m.state.RecordEvent(service.ID, "config_reloaded", fmt.Sprintf("signal=%s files=%d", signal, len(service.ConfigFiles))); err != nil {
+		m.logVerbose("Failed to record reload event for %s: %v", service.ID, err)
+	}
+	log.Printf("[ServiceManager] Config reloaded: service=%s container=%s signal=%s", service.ID, info.containerName, signal)
+	return true, nil
+}
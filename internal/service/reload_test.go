@@ -0,0 +1,97 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/buildvigil/agent/internal/api"
+	"github.com/buildvigil/agent/internal/state"
+)
+
+func TestConfigOnlyChange(t *testing.T) {
+	t.Logf("Testing config-only change detection...")
+	base := api.Service{ID: "web", DockerImage: "nginx", ConfigFiles: []api.ConfigFile{{Path: "/etc/nginx/nginx.conf", Content: "a"}}}
+
+	content := base
+	content.ConfigFiles = []api.ConfigFile{{Path: "/etc/nginx/nginx.conf", Content: "b"}}
+	if !configOnlyChange(base, content) {
+		t.Error("Expected a content edit to be config-only")
+	}
+
+	moved := base
+	moved.ConfigFiles = []api.ConfigFile{{Path: "/etc/nginx/conf.d/site.conf", Content: "a"}}
+	if configOnlyChange(base, moved) {
+		t.Error("Expected a path change to need a deploy")
+	}
+
+	env := content
+	env.EnvironmentVars = map[string]string{"WORKERS": "4"}
+	if configOnlyChange(base, env) {
+		t.Error("Expected an environment change to need a deploy")
+	}
+	t.Logf("✓ Config-only changes detected")
+}
+
+func TestReloadConfig_RewritesInPlaceAndSignals(t *testing.T) {
+	t.Logf("Testing config reload rewrites mounted files and signals the container...")
+	original := signalContainer
+	defer func() { signalContainer = original }()
+	var signalled []string
+	signalContainer = func(containerName, signal string) error {
+		signalled = append(signalled, containerName+" "+signal)
+		return nil
+	}
+
+	stateMgr, err := state.NewManager(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to create state manager: %v", err)
+	}
+	defer stateMgr.Close()
+
+	dir := t.TempDir()
+	m := NewManager(t.TempDir(), stateMgr, nil, 3000, 3010, false)
+	m.SetConfigFilesDir(dir)
+
+	v1 := api.Service{ID: "web", ReloadSignal: "hup", ConfigFiles: []api.ConfigFile{{Path: "/etc/nginx/nginx.conf", Content: "worker_processes 1;"}}}
+	if _, err := m.configFileArgs(v1); err != nil {
+		t.Fatalf("Failed to write config files: %v", err)
+	}
+	oldPath := filepath.Join(dir, "web", configFilesHash(v1.ConfigFiles), "etc", "nginx", "nginx.conf")
+	before, err := os.Stat(oldPath)
+	if err != nil {
+		t.Fatalf("Expected config file on disk: %v", err)
+	}
+	m.containers["web"] = &containerInfo{service: v1, containerName: "potato-cloud-web-blue"}
+
+	restart := v1
+	restart.DockerImage = "nginx:1.27"
+	if reloaded, err := m.ReloadConfig(restart); err != nil || reloaded {
+		t.Fatalf("Expected image change to need a deploy, got reloaded=%v err=%v", reloaded, err)
+	}
+
+	v2 := v1
+	v2.ConfigFiles = []api.ConfigFile{{Path: "/etc/nginx/nginx.conf", Content: "worker_processes 4;"}}
+	reloaded, err := m.ReloadConfig(v2)
+	if err != nil || !reloaded {
+		t.Fatalf("Expected reload, got reloaded=%v err=%v", reloaded, err)
+	}
+	newPath := filepath.Join(dir, "web", configFilesHash(v2.ConfigFiles), "etc", "nginx", "nginx.conf")
+	after, err := os.Stat(newPath)
+	if err != nil {
+		t.Fatalf("Expected config directory to follow the new contents: %v", err)
+	}
+	if !os.SameFile(before, after) {
+		t.Error("Expected the mounted file to be rewritten in place")
+	}
+	if data, _ := os.ReadFile(newPath); string(data) != "worker_processes 4;" {
+		t.Errorf("Unexpected file content %q", data)
+	}
+	if len(signalled) != 1 || signalled[0] != "potato-cloud-web-blue SIGHUP" {
+		t.Errorf("Expected one SIGHUP to the running container, got %v", signalled)
+	}
+	if m.containers["web"].service.ConfigFiles[0].Content != "worker_processes 4;" {
+		t.Error("Expected tracked definition to be updated")
+	}
+	t.Logf("✓ Config reloaded without a redeploy")
+}
@@ -0,0 +1,100 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os/exec"
+	"strings"
+
+	"github.com/buildvigil/agent/internal/api"
+)
+
+// SetRemoteBuild sends image builds to another Docker host or to a buildx
+// builder (e.g. a remote BuildKit), so small hosts only run the results. At
+// most one may be set; an empty pair builds locally.
+func (m *Manager) SetRemoteBuild(dockerHost, builder string) error {
+	dockerHost, builder = strings.TrimSpace(dockerHost), strings.TrimSpace(builder)
+	if dockerHost != "" && builder != "" {
+		return fmt.Errorf("set either a build Docker host or a buildx builder, not both")
+	}
+	if dockerHost != "" {
+		u, err := url.Parse(dockerHost)
+		if err != nil || u.Host == "" && u.Path == "" {
+			return fmt.Errorf("invalid build Docker host %q", dockerHost)
+		}
+		switch u.Scheme {
+		case "tcp", "ssh", "unix", "npipe":
+		default:
+			return fmt.Errorf("invalid build Docker host %q: use tcp://, ssh:// or unix://", dockerHost)
+		}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.buildDockerHost = dockerHost
+	m.buildxBuilder = builder
+	return nil
+}
+
+// buildCommandArgs returns the docker arguments that build a service image,
+// on the remote build host or builder when one is set.
+func (m *Manager) buildCommandArgs(service api.Service, imageTag, dockerfilePath, contextPath string) []string {
+	var args []string
+	switch {
+	case m.buildxBuilder != "":
+		// --load brings the finished image back into the local image store.
+		args = []string{"buildx", "build", "--builder", m.buildxBuilder, "--load"}
+	case m.buildDockerHost != "":
+		args = []string{"--host", m.buildDockerHost, "build"}
+	default:
+		args = []string{"build"}
+	}
+	args = append(args, platformArgs(service)...)
+	args = append(args, proxyBuildArgs()...)
+	return append(args, "-t", imageTag, "-f", dockerfilePath, contextPath)
+}
+
+// loadRemoteImage copies an image built on the remote Docker host into the
+// local image store by streaming `docker save` into `docker load`.
+func (m *Manager) loadRemoteImage(ctx context.Context, imageTag string) error {
+	if m.buildDockerHost == "" {
+		return nil
+	}
+	log.Printf("[ServiceManager] Loading image from build host: image=%s host=%s", imageTag, m.buildDockerHost)
+	save := exec.CommandContext(ctx, "docker", "--host", m.buildDockerHost, "save", imageTag)
+	load := exec.CommandContext(ctx, "docker", "load")
+
+	reader, writer := io.Pipe()
+	save.Stdout = writer
+	load.Stdin = reader
+	var saveErr, loadErr strings.Builder
+	save.Stderr = &saveErr
+	load.Stderr = &loadErr
+
+	if err := load.Start(); err != nil {
+		return fmt.Errorf("failed to start docker load: %w", err)
+	}
+	err := save.Run()
+	writer.CloseWithError(err)
+	if waitErr := load.Wait(); waitErr != nil && err == nil {
+		return fmt.Errorf("docker load failed: %w\nOutput: %s", waitErr, strings.TrimSpace(loadErr.String()))
+	}
+	if err != nil {
+		return fmt.Errorf("docker save on build host failed: %w\nOutput: %s", err, strings.TrimSpace(saveErr.String()))
+	}
+	return nil
+}
+
+// builderName describes where images are built, for logs.
+func (m *Manager) builderName() string {
+	switch {
+	case m.buildxBuilder != "":
+		return "buildx:" + m.buildxBuilder
+	case m.buildDockerHost != "":
+		return m.buildDockerHost
+	default:
+		return "local"
+	}
+}
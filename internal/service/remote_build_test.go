@@ -0,0 +1,81 @@
+package service
+
+import (
+	"context"
+	"os/exec"
+	"reflect"
+	"testing"
+
+	"github.com/buildvigil/agent/internal/api"
+	"github.com/buildvigil/agent/internal/testutil"
+)
+
+func TestSetRemoteBuild(t *testing.T) {
+	t.Logf("Testing remote build configuration")
+
+	m := NewManager(t.TempDir(), nil, nil, 3000, 3010, false)
+	for _, host := range []string{"ssh://builder@10.0.0.5", "tcp://10.0.0.5:2376", "unix:///run/builder.sock"} {
+		if err := m.SetRemoteBuild(host, ""); err != nil {
+			t.Errorf("Expected %s to be accepted, got %v", host, err)
+		}
+	}
+	if err := m.SetRemoteBuild("10.0.0.5", ""); err == nil {
+		t.Error("Expected host without scheme to be rejected")
+	}
+	if err := m.SetRemoteBuild("ssh://builder", "remote-kit"); err == nil {
+		t.Error("Expected host and builder together to be rejected")
+	}
+	t.Logf("✓ Remote build settings validated")
+}
+
+func TestBuildCommandArgs(t *testing.T) {
+	t.Logf("Testing build command per build location")
+
+	svc := api.Service{ID: "svc-1"}
+	tail := []string{"-t", "img:latest", "-f", "Dockerfile", "/src"}
+	tests := []struct {
+		host, builder string
+		want          []string
+	}{
+		{"", "", append([]string{"build"}, tail...)},
+		{"ssh://builder", "", append([]string{"--host", "ssh://builder", "build"}, tail...)},
+		{"", "remote-kit", append([]string{"buildx", "build", "--builder", "remote-kit", "--load"}, tail...)},
+	}
+	for _, tt := range tests {
+		m := NewManager(t.TempDir(), nil, nil, 3000, 3010, false)
+		if err := m.SetRemoteBuild(tt.host, tt.builder); err != nil {
+			t.Fatalf("SetRemoteBuild failed: %v", err)
+		}
+		if got := m.buildCommandArgs(svc, "img:latest", "Dockerfile", "/src"); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("host=%q builder=%q: got %v, want %v", tt.host, tt.builder, got, tt.want)
+		}
+	}
+	t.Logf("✓ Builds go to the configured host or builder")
+}
+
+func TestLoadRemoteImage(t *testing.T) {
+	t.Logf("Testing images copied from the build host")
+
+	docker := testutil.NewFakeDocker(t)
+	m := NewManager(t.TempDir(), nil, nil, 3000, 3010, false)
+	if err := m.SetRemoteBuild("ssh://builder", ""); err != nil {
+		t.Fatalf("SetRemoteBuild failed: %v", err)
+	}
+	if out, err := exec.Command("docker", m.buildCommandArgs(api.Service{}, "img:latest", "Dockerfile", ".")...).CombinedOutput(); err != nil {
+		t.Fatalf("Remote build failed: %v: %s", err, out)
+	}
+	if docker.HasImage("img:latest") {
+		t.Fatal("Expected the remote build to leave the local store untouched")
+	}
+
+	if err := m.loadRemoteImage(context.Background(), "img:latest"); err != nil {
+		t.Fatalf("loadRemoteImage failed: %v", err)
+	}
+	if !docker.HasImage("img:latest") {
+		t.Error("Expected image to be loaded locally")
+	}
+	if err := m.loadRemoteImage(context.Background(), "missing:latest"); err == nil {
+		t.Error("Expected a missing remote image to fail")
+	}
+	t.Logf("✓ Remote image streamed into the local store")
+}
@@ -0,0 +1,192 @@
+package service
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/buildvigil/agent/internal/api"
+)
+
+// resourceLimitFlags are the docker run flags set from a service's ulimits,
+// sysctls and shm_size_mb. No docker_run_args may set them around the caps,
+// including those of sidecars and init containers. The CPU, memory and
+// process limits are uncapped, and follow docker_run_args so they win over
+// the same flags there.
+var resourceLimitFlags = map[string]string{
+	"--ulimit":   "ulimits",
+	"--sysctl":   "sysctls",
+	"--shm-size": "shm_size_mb",
+}
+
+// resourceLimitArg returns the first of args that sets a capped resource
+// limit, and the service field that sets it instead.
+func resourceLimitArg(args []string) (flag, field string) {
+	for _, arg := range args {
+		key, _, _ := strings.Cut(arg, "=")
+		if field, ok := resourceLimitFlags[key]; ok {
+			return key, field
+		}
+	}
+	return "", ""
+}
+
+// minMemoryLimit is the smallest memory limit Docker accepts.
+const minMemoryLimit = 6 << 20
+
+// resourceLimitCaps bounds the ulimits, sysctls and /dev/shm size a service
+// may ask for.
+type resourceLimitCaps struct {
+	ulimits   map[string]int64 // Highest value per ulimit; absent or 0 refuses it, -1 allows unlimited
+	sysctls   []string         // Allowed names; a trailing "*" allows a prefix
+	shmSizeMB int              // Largest shm_size_mb; 0 refuses any
+}
+
+// SetResourceLimitCaps sets the highest ulimit per name (-1 allows
+// unlimited), the sysctls services may set (a trailing "*" matches a
+// prefix), and the largest /dev/shm in MB. Anything not allowed fails the
+// service's deploy.
+func (m *Manager) SetResourceLimitCaps(ulimits map[string]int64, sysctls []string, shmSizeMB int) {
+	m.limitMu.Lock()
+	defer m.limitMu.Unlock()
+	m.limitCaps = resourceLimitCaps{ulimits: ulimits, sysctls: sysctls, shmSizeMB: shmSizeMB}
+}
+
+// resourceLimitArgs returns the --ulimit, --sysctl, --shm-size, --cpus,
+// --memory and --pids-limit flags for a service, or an error when it asks for
+// more than the caps allow or a limit is invalid.
+func (m *Manager) resourceLimitArgs(service api.Service) ([]string, error) {
+	m.limitMu.Lock()
+	caps := m.limitCaps
+	m.limitMu.Unlock()
+	return caps.runArgs(service)
+}
+
+func (c resourceLimitCaps) runArgs(service api.Service) ([]string, error) {
+	var args []string
+	for _, name := range sortedKeys(service.Ulimits) {
+		soft, hard, err := parseUlimit(service.Ulimits[name])
+		if err != nil {
+			return nil, fmt.Errorf("invalid ulimit %s: %w", name, err)
+		}
+		limit := c.ulimits[name]
+		switch {
+		case limit == 0:
+			return nil, fmt.Errorf("ulimit %s is not allowed on this agent", name)
+		case limit > 0 && (hard < 0 || hard > limit):
+			return nil, fmt.Errorf("ulimit %s of %s exceeds the agent's cap of %d", name, service.Ulimits[name], limit)
+		}
+		args = append(args, "--ulimit", fmt.Sprintf("%s=%d:%d", name, soft, hard))
+	}
+	for _, name := range sortedKeys(service.Sysctls) {
+		value := strings.TrimSpace(service.Sysctls[name])
+		if value == "" || strings.ContainsAny(value, "\r\n") {
+			return nil, fmt.Errorf("invalid value %q for sysctl %s", service.Sysctls[name], name)
+		}
+		if !c.allowsSysctl(name) {
+			return nil, fmt.Errorf("sysctl %s is not allowed on this agent", name)
+		}
+		args = append(args, "--sysctl", name+"="+value)
+	}
+	switch {
+	case service.ShmSizeMB < 0:
+		return nil, fmt.Errorf("invalid shm_size_mb %d", service.ShmSizeMB)
+	case service.ShmSizeMB > c.shmSizeMB:
+		return nil, fmt.Errorf("shm_size_mb %d exceeds the agent's cap of %d", service.ShmSizeMB, c.shmSizeMB)
+	case service.ShmSizeMB > 0:
+		args = append(args, "--shm-size", fmt.Sprintf("%dm", service.ShmSizeMB))
+	}
+	switch {
+	case service.CPULimit < 0:
+		return nil, fmt.Errorf("invalid cpu_limit %g", service.CPULimit)
+	case service.CPULimit > 0:
+		args = append(args, "--cpus", strconv.FormatFloat(service.CPULimit, 'f', -1, 64))
+	}
+	if memory := strings.TrimSpace(service.MemoryLimit); memory != "" {
+		bytes, err := parseMemoryLimit(memory)
+		if err != nil {
+			return nil, fmt.Errorf("invalid memory_limit: %w", err)
+		}
+		if bytes < minMemoryLimit {
+			return nil, fmt.Errorf("memory_limit %s is below Docker's minimum of 6m", memory)
+		}
+		args = append(args, "--memory", strings.ToLower(memory))
+	}
+	switch {
+	case service.PidsLimit < 0:
+		return nil, fmt.Errorf("invalid pids_limit %d", service.PidsLimit)
+	case service.PidsLimit > 0:
+		args = append(args, "--pids-limit", strconv.Itoa(service.PidsLimit))
+	}
+	return args, nil
+}
+
+// parseMemoryLimit parses a Docker memory size such as 512m or 2g into bytes.
+// A bare number is bytes.
+func parseMemoryLimit(value string) (int64, error) {
+	number := strings.ToLower(value)
+	multiplier := int64(1)
+	if unit := number[len(number)-1]; unit < '0' || unit > '9' {
+		switch unit {
+		case 'b':
+		case 'k':
+			multiplier = 1 << 10
+		case 'm':
+			multiplier = 1 << 20
+		case 'g':
+			multiplier = 1 << 30
+		default:
+			return 0, fmt.Errorf("expected a size such as 512m or 2g, got %q", value)
+		}
+		number = number[:len(number)-1]
+	}
+	size, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || size <= 0 || size > math.MaxInt64/multiplier {
+		return 0, fmt.Errorf("expected a size such as 512m or 2g, got %q", value)
+	}
+	return size * multiplier, nil
+}
+
+func (c resourceLimitCaps) allowsSysctl(name string) bool {
+	for _, allowed := range c.sysctls {
+		if prefix, ok := strings.CutSuffix(allowed, "*"); ok {
+			if strings.HasPrefix(name, prefix) {
+				return true
+			}
+		} else if name == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// parseUlimit parses "limit" or "soft:hard", where -1 means unlimited.
+func parseUlimit(value string) (int64, int64, error) {
+	softText, hardText, split := strings.Cut(strings.TrimSpace(value), ":")
+	if !split {
+		hardText = softText
+	}
+	soft, err := strconv.ParseInt(softText, 10, 64)
+	if err != nil || soft < -1 {
+		return 0, 0, fmt.Errorf("expected a limit or soft:hard, got %q", value)
+	}
+	hard, err := strconv.ParseInt(hardText, 10, 64)
+	if err != nil || hard < -1 {
+		return 0, 0, fmt.Errorf("expected a limit or soft:hard, got %q", value)
+	}
+	if hard >= 0 && (soft < 0 || soft > hard) {
+		return 0, 0, fmt.Errorf("soft limit above hard limit in %q", value)
+	}
+	return soft, hard, nil
+}
+
+func sortedKeys(values map[string]string) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,81 @@
+package service
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/buildvigil/agent/internal/api"
+)
+
+func TestResourceLimitArgs(t *testing.T) {
+	t.Logf("Testing ulimits, sysctls and shm size against the agent's caps")
+
+	m := &Manager{}
+	m.SetResourceLimitCaps(map[string]int64{"nofile": 1048576, "memlock": -1, "nproc": 0}, []string{"net.core.somaxconn", "net.ipv4.tcp_*"}, 1024)
+
+	svc := api.Service{ID: "svc-db",
+		Ulimits:     map[string]string{"nofile": "65536", "memlock": "-1"},
+		Sysctls:     map[string]string{"net.ipv4.tcp_keepalive_time": "600", "net.core.somaxconn": "4096"},
+		ShmSizeMB:   512,
+		CPULimit:    1.5,
+		MemoryLimit: "512M",
+		PidsLimit:   200,
+	}
+	args, err := m.resourceLimitArgs(svc)
+	if err != nil {
+		t.Fatalf("Expected limits within the caps, got %v", err)
+	}
+	expected := []string{
+		"--ulimit", "memlock=-1:-1", "--ulimit", "nofile=65536:65536",
+		"--sysctl", "net.core.somaxconn=4096", "--sysctl", "net.ipv4.tcp_keepalive_time=600",
+		"--shm-size", "512m",
+		"--cpus", "1.5", "--memory", "512m", "--pids-limit", "200",
+	}
+	if !reflect.DeepEqual(args, expected) {
+		t.Errorf("Expected %q, got %q", expected, args)
+	}
+	if args, err := m.resourceLimitArgs(api.Service{ID: "svc-web"}); err != nil || len(args) != 0 {
+		t.Errorf("Expected no flags for a service without limits, got %q (err=%v)", args, err)
+	}
+
+	for name, tc := range map[string]struct {
+		svc  api.Service
+		want string
+	}{
+		"above cap":       {api.Service{Ulimits: map[string]string{"nofile": "1024:2000000"}}, "exceeds"},
+		"unlimited":       {api.Service{Ulimits: map[string]string{"nofile": "-1"}}, "exceeds"},
+		"refused ulimit":  {api.Service{Ulimits: map[string]string{"nproc": "100"}}, "not allowed"},
+		"unknown ulimit":  {api.Service{Ulimits: map[string]string{"core": "0"}}, "not allowed"},
+		"soft above hard": {api.Service{Ulimits: map[string]string{"nofile": "2048:1024"}}, "soft limit"},
+		"malformed":       {api.Service{Ulimits: map[string]string{"nofile": "lots"}}, "invalid ulimit"},
+		"sysctl":          {api.Service{Sysctls: map[string]string{"kernel.shmmax": "1"}}, "not allowed"},
+		"empty sysctl":    {api.Service{Sysctls: map[string]string{"net.core.somaxconn": " "}}, "invalid value"},
+		"shm above cap":   {api.Service{ShmSizeMB: 4096}, "exceeds"},
+		"negative shm":    {api.Service{ShmSizeMB: -1}, "invalid shm_size_mb"},
+		"negative cpus":   {api.Service{CPULimit: -1}, "invalid cpu_limit"},
+		"memory unit":     {api.Service{MemoryLimit: "2t"}, "invalid memory_limit"},
+		"memory too low":  {api.Service{MemoryLimit: "4m"}, "minimum"},
+		"negative pids":   {api.Service{PidsLimit: -1}, "invalid pids_limit"},
+	} {
+		if _, err := m.resourceLimitArgs(tc.svc); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: expected error containing %q, got %v", name, tc.want, err)
+		}
+	}
+
+	prebuilt := api.Service{ID: "svc-1", ServiceType: "docker", DockerImage: "postgres:16", DockerRunArgs: "--shm-size=1g"}
+	if err := validateContainerArgs(prebuilt); err == nil || !strings.Contains(err.Error(), "shm_size_mb") {
+		t.Errorf("Expected --shm-size in docker_run_args to be rejected, got %v", err)
+	}
+	for _, runArgs := range []string{"--sysctl net.core.somaxconn=65535", "--ulimit=nofile=1048576", "--shm-size 8g"} {
+		sidecar := api.Service{Sidecars: []api.Sidecar{{Name: "logs", Image: "busybox", DockerRunArgs: runArgs}}}
+		if err := validateSidecars(sidecar); err == nil || !strings.Contains(err.Error(), "which the agent caps") {
+			t.Errorf("Expected %q refused on a sidecar, got %v", runArgs, err)
+		}
+		initContainer := api.Service{InitContainers: []api.InitContainer{{Name: "migrate", Image: "busybox", DockerRunArgs: runArgs}}}
+		if err := validateInitContainers(initContainer); err == nil || !strings.Contains(err.Error(), "which the agent caps") {
+			t.Errorf("Expected %q refused on an init container, got %v", runArgs, err)
+		}
+	}
+	t.Logf("✓ Limits passed through within caps and refused beyond them")
+}
@@ -0,0 +1,91 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/buildvigil/agent/internal/api"
+	containerpkg "github.com/buildvigil/agent/internal/container"
+)
+
+// DefaultRestartPolicy lets Docker bring containers back after a daemon or
+// host restart while still honouring explicit stops made by the agent.
+const DefaultRestartPolicy = "unless-stopped"
+
+var (
+	getRestartPolicy    = defaultGetRestartPolicy
+	updateRestartPolicy = defaultUpdateRestartPolicy
+)
+
+// restartPolicyForService returns the normalized docker restart policy for a
+// service, or an error if the configured value is not a valid policy.
+func restartPolicyForService(service api.Service) (string, error) {
+	policy := strings.ToLower(strings.TrimSpace(service.RestartPolicy))
+	if policy == "" {
+		return DefaultRestartPolicy, nil
+	}
+	switch policy {
+	case "no", "always", "unless-stopped", "on-failure":
+		return policy, nil
+	}
+	if retries, ok := strings.CutPrefix(policy, "on-failure:"); ok {
+		if n, err := strconv.Atoi(retries); err == nil && n >= 0 {
+			return policy, nil
+		}
+	}
+	return "", fmt.Errorf("invalid restart_policy %q; expected no, always, unless-stopped, or on-failure[:max-retries]", service.RestartPolicy)
+}
+
+// restartPolicyMatches compares a policy as reported by docker inspect
+// ("name:max-retries") with a configured policy.
+func restartPolicyMatches(actual, expected string) bool {
+	name, retries, _ := strings.Cut(actual, ":")
+	if name == "" {
+		name = "no"
+	}
+	if name == "on-failure" && retries != "" && retries != "0" {
+		return expected == "on-failure:"+retries
+	}
+	return expected == name
+}
+
+// reconcileRestartPolicy updates a running container whose restart policy no
+// longer matches the service configuration, e.g. one started by an older agent.
+func (m *Manager) reconcileRestartPolicy(service api.Service, containerName string) {
+	expected, err := restartPolicyForService(service)
+	if err != nil {
+		log.Printf("[ServiceManager] Restart policy invalid: service=%s err=%v", service.ID, err)
+		return
+	}
+	actual, err := getRestartPolicy(containerName)
+	if err != nil {
+		m.logVerbose("Failed to inspect restart policy for %s: %v", containerName, err)
+		return
+	}
+	if restartPolicyMatches(actual, expected) {
+		return
+	}
+	log.Printf("[ServiceManager] Restart policy mismatch: service=%s container=%s actual=%s expected=%s", service.ID, containerName, actual, expected)
+	if err := updateRestartPolicy(containerName, expected); err != nil {
+		log.Printf("[ServiceManager] Failed to update restart policy: service=%s err=%v", service.ID, err)
+	}
+}
+
+func defaultGetRestartPolicy(containerName string) (string, error) {
+	output, err := containerpkg.DockerCombinedOutput(containerpkg.DockerInspect, "inspect", "--format", "{{.HostConfig.RestartPolicy.Name}}:{{.HostConfig.RestartPolicy.MaximumRetryCount}}", containerName)
+	if err != nil {
+		return "", fmt.Errorf("docker inspect failed: %w\nOutput: %s", err, strings.TrimSpace(string(output)))
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+func defaultUpdateRestartPolicy(containerName, policy string) error {
+	noteAgentAction(containerName)
+	output, err := containerpkg.DockerCombinedOutput(containerpkg.DockerRun, "update", "--restart", policy, containerName)
+	if err != nil {
+		return fmt.Errorf("docker update failed: %w\nOutput: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
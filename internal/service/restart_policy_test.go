@@ -0,0 +1,69 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/buildvigil/agent/internal/api"
+)
+
+func TestRestartPolicyForService(t *testing.T) {
+	t.Logf("Testing restart policy validation...")
+
+	cases := []struct {
+		policy   string
+		expected string
+		valid    bool
+	}{
+		{"", DefaultRestartPolicy, true},
+		{"no", "no", true},
+		{"Always", "always", true},
+		{"on-failure:5", "on-failure:5", true},
+		{"on-failure:-1", "", false},
+		{"sometimes", "", false},
+	}
+	for _, tc := range cases {
+		got, err := restartPolicyForService(api.Service{RestartPolicy: tc.policy})
+		if tc.valid && (err != nil || got != tc.expected) {
+			t.Errorf("Policy %q: expected %q, got %q (err=%v)", tc.policy, tc.expected, got, err)
+		}
+		if !tc.valid && err == nil {
+			t.Errorf("Policy %q: expected validation error", tc.policy)
+		}
+	}
+
+	if _, err := containerRunArgs(api.Service{ServiceType: "docker", DockerRunArgs: "--restart=always"}); err == nil {
+		t.Error("Expected --restart in docker_run_args to be rejected")
+	}
+	t.Logf("✓ Restart policies validated")
+}
+
+func TestReconcileRestartPolicy(t *testing.T) {
+	t.Logf("Testing restart policy reconciliation...")
+
+	originalGet, originalUpdate := getRestartPolicy, updateRestartPolicy
+	defer func() {
+		getRestartPolicy, updateRestartPolicy = originalGet, originalUpdate
+	}()
+
+	actual := "no:0"
+	var updated string
+	getRestartPolicy = func(containerName string) (string, error) { return actual, nil }
+	updateRestartPolicy = func(containerName, policy string) error {
+		updated = policy
+		return nil
+	}
+
+	m := NewManager(t.TempDir(), nil, nil, 3000, 3010, false)
+	m.reconcileRestartPolicy(api.Service{ID: "svc-1"}, "potato-cloud-svc-1")
+	if updated != DefaultRestartPolicy {
+		t.Errorf("Expected mismatched policy to be updated to %s, got %q", DefaultRestartPolicy, updated)
+	}
+
+	updated = ""
+	actual = "on-failure:3"
+	m.reconcileRestartPolicy(api.Service{ID: "svc-1", RestartPolicy: "on-failure:3"}, "potato-cloud-svc-1")
+	if updated != "" {
+		t.Errorf("Expected matching policy to be left alone, got update to %q", updated)
+	}
+	t.Logf("✓ Restart policy mismatches reconciled")
+}
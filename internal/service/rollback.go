@@ -0,0 +1,73 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/buildvigil/agent/internal/state"
+)
+
+// rollbackImageTag tags the image a service can be rolled back to, so it is
+// neither left dangling nor removed by image budgets.
+const rollbackImageTag = "rollback"
+
+// ErrNoRollbackTarget is returned by RollbackService when no earlier release
+// of the service is recorded.
+var ErrNoRollbackTarget = errors.New("no earlier release to roll back to")
+
+// recordRollbackTarget records the release a service runs before a deploy
+// replaces it. Callers hold mu.
+func (m *Manager) recordRollbackTarget(current *containerInfo) {
+	if current == nil || strings.TrimSpace(current.imageTag) == "" {
+		return
+	}
+	serviceID := current.service.ID
+	if strings.HasPrefix(current.imageTag, "sha256:") {
+		tag := fmt.Sprintf("%s-%s:%s", ImagePrefix, serviceID, rollbackImageTag)
+		if err := tagImage(current.imageTag, tag); err != nil {
+			log.Printf("[ServiceManager] Failed to tag rollback image: service=%s image=%s err=%v", serviceID, current.imageTag, err)
+		}
+	}
+	if err := m.state.SaveRollbackTarget(state.RollbackTarget{
+		ServiceID: serviceID,
+		ImageTag:  current.imageTag,
+		GitCommit: current.service.GitCommit,
+		Port:      current.port,
+		DeployID:  current.deployID,
+	}); err != nil {
+		log.Printf("[ServiceManager] Failed to record rollback target: service=%s err=%v", serviceID, err)
+	}
+}
+
+// RollbackService redeploys the release a service ran before its latest
+// deploy attempt, blue/green like any other deploy. The service keeps its
+// current definition; only the image and commit go back. When that release
+// is already running, as after a failed blue/green deploy, it is left as is.
+func (m *Manager) RollbackService(serviceID string) error {
+	target, err := m.state.GetRollbackTarget(serviceID)
+	if err != nil {
+		return err
+	}
+	if target == nil {
+		return fmt.Errorf("%w for service %s", ErrNoRollbackTarget, serviceID)
+	}
+
+	current, ok := m.trackedContainer(serviceID)
+	service, containerName, runningImage := current.service, current.containerName, current.imageTag
+	if !ok {
+		return fmt.Errorf("service %s is not deployed on this agent", serviceID)
+	}
+
+	if runningImage == target.ImageTag {
+		if status, err := getContainerStatus(containerName); err == nil && status == "running" {
+			log.Printf("[ServiceManager] Rollback: service=%s already runs image=%s deploy=%s", serviceID, target.ImageTag, target.DeployID)
+			m.reportLifecycle(service, "running", runningHealthStatus(service), "")
+			return nil
+		}
+	}
+	log.Printf("[ServiceManager] Rollback start: service=%s image=%s commit=%s deploy=%s", serviceID, target.ImageTag, target.GitCommit, target.DeployID)
+	service.GitCommit = target.GitCommit
+	return m.deploy(service, target.ImageTag)
+}
@@ -0,0 +1,78 @@
+package service
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/buildvigil/agent/internal/api"
+)
+
+func TestSplitShellWords(t *testing.T) {
+	t.Logf("Testing shell-style splitting of commands and run args")
+
+	cases := []struct {
+		input string
+		want  []string
+	}{
+		{"", nil},
+		{"  npm   start ", []string{"npm", "start"}},
+		{`sh -c "echo hello world"`, []string{"sh", "-c", "echo hello world"}},
+		{`--label 'team=web ops' -e MSG="it's ok"`, []string{"--label", "team=web ops", "-e", "MSG=it's ok"}},
+		{`echo a\ b "x \"y\" \n" 'lit\eral'`, []string{"echo", "a b", `x "y" \n`, `lit\eral`}},
+		{`--opt="" ''`, []string{"--opt=", ""}},
+		{"run \\\n --fast", []string{"run", "--fast"}},
+	}
+	for _, tc := range cases {
+		got, err := splitShellWords(tc.input)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tc.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%q: expected %q, got %q", tc.input, tc.want, got)
+		}
+	}
+
+	for _, input := range []string{`echo "unterminated`, `echo 'open`, `echo trailing\`} {
+		if _, err := splitShellWords(input); err == nil {
+			t.Errorf("%q: expected an error", input)
+		}
+	}
+	t.Logf("✓ Quotes and escapes honored, malformed input rejected")
+}
+
+func TestValidateContainerArgs(t *testing.T) {
+	t.Logf("Testing run_command and docker_run_args validation")
+
+	svc := api.Service{ID: "svc-1", ServiceType: "docker", DockerImage: "nginx:1.25",
+		RunCommand:    `sh -c "nginx -g 'daemon off;'"`,
+		DockerRunArgs: `--label "team=web ops" --memory 256m`,
+	}
+	if err := validateContainerArgs(svc); err != nil {
+		t.Fatalf("Expected quoted args to be valid, got %v", err)
+	}
+	if got := containerCommandForService(svc); !reflect.DeepEqual(got, []string{"sh", "-c", "nginx -g 'daemon off;'"}) {
+		t.Errorf("Unexpected command: %q", got)
+	}
+	if got := parseDockerRunArgs(svc); !reflect.DeepEqual(got, []string{"--label", "team=web ops", "--memory", "256m"}) {
+		t.Errorf("Unexpected run args: %q", got)
+	}
+
+	bad := svc
+	bad.RunCommand = `echo "oops`
+	if err := validateContainerArgs(bad); err == nil || !strings.Contains(err.Error(), "run_command") {
+		t.Errorf("Expected a run_command error, got %v", err)
+	}
+	bad = svc
+	bad.DockerRunArgs = `--label 'x`
+	if err := validateContainerArgs(bad); err == nil || !strings.Contains(err.Error(), "docker_run_args") {
+		t.Errorf("Expected a docker_run_args error, got %v", err)
+	}
+	bad = svc
+	bad.DockerRunArgs = `"--name" x`
+	if err := validateContainerArgs(bad); err == nil {
+		t.Errorf("Expected a quoted managed flag to still be rejected")
+	}
+	t.Logf("✓ Parse errors reported per field, managed flags still blocked")
+}
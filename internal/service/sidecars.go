@@ -0,0 +1,181 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/buildvigil/agent/internal/api"
+)
+
+const (
+	SidecarNetworkShared = "shared"
+	SidecarNetworkStack  = "stack"
+
+	// sidecarLabel marks sidecar containers with their service ID so they can
+	// be cleaned up when the service definition is no longer known.
+	sidecarLabel = "potato-cloud.sidecar-of"
+)
+
+var (
+	sidecarNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,30}$`)
+
+	removeServiceSidecars = defaultRemoveServiceSidecars
+)
+
+// sidecarContainerName names a sidecar after the primary container it
+// belongs to, so blue and green each have their own set.
+func sidecarContainerName(primary string, sidecar api.Sidecar) string {
+	return primary + "-" + sidecar.Name
+}
+
+func sidecarNetwork(sidecar api.Sidecar) string {
+	if network := strings.ToLower(strings.TrimSpace(sidecar.Network)); network != "" {
+		return network
+	}
+	return SidecarNetworkShared
+}
+
+// validateSidecars checks sidecar definitions before anything is started.
+func validateSidecars(service api.Service) error {
+	seen := make(map[string]bool, len(service.Sidecars))
+	for _, sidecar := range service.Sidecars {
+		if !sidecarNamePattern.MatchString(sidecar.Name) {
+			return fmt.Errorf("invalid sidecar name %q; use lowercase letters, digits and dashes", sidecar.Name)
+		}
+		if seen[sidecar.Name] {
+			return fmt.Errorf("duplicate sidecar name %q", sidecar.Name)
+		}
+		seen[sidecar.Name] = true
+		if strings.TrimSpace(sidecar.Image) == "" {
+			return fmt.Errorf("sidecar %s: image is required", sidecar.Name)
+		}
+		if network := sidecarNetwork(sidecar); network != SidecarNetworkShared && network != SidecarNetworkStack {
+			return fmt.Errorf("sidecar %s: invalid network %q; expected shared or stack", sidecar.Name, sidecar.Network)
+		}
+		if _, err := splitShellWords(sidecar.Command); err != nil {
+			return fmt.Errorf("sidecar %s: invalid command: %w", sidecar.Name, err)
+		}
+		args, err := splitShellWords(sidecar.DockerRunArgs)
+		if err != nil {
+			return fmt.Errorf("sidecar %s: invalid docker_run_args: %w", sidecar.Name, err)
+		}
+		if flag, _ := resourceLimitArg(args); flag != "" {
+			return fmt.Errorf("sidecar %s: docker_run_args contains disallowed option %q, which the agent caps", sidecar.Name, flag)
+		}
+		if err := checkRunArgs(args); err != nil {
+			return fmt.Errorf("sidecar %s: %w", sidecar.Name, err)
+		}
+	}
+	return nil
+}
+
+// startSidecars starts a service's sidecars next to its primary container,
+// with the service's volumes mounted. On error the caller stops the primary, which also removes any sidecars
+// already started.
+func (m *Manager) startSidecars(service api.Service, primaryName, primaryID string) error {
+	if len(service.Sidecars) == 0 {
+		return nil
+	}
+	restartPolicy, err := restartPolicyForService(service)
+	if err != nil {
+		return err
+	}
+	volumeArgs, err := m.volumeArgs(service)
+	if err != nil {
+		return err
+	}
+	for _, sidecar := range service.Sidecars {
+		name := sidecarContainerName(primaryName, sidecar)
+		args := []string{"--restart", restartPolicy, "--label", sidecarLabel + "=" + service.ID}
+		if sidecarNetwork(sidecar) == SidecarNetworkShared {
+			args = append(args, "--network", "container:"+primaryID)
+		}
+		args = append(args, volumeArgs...)
+		runArgs, _ := splitShellWords(sidecar.DockerRunArgs)
+		args = append(args, runArgs...)
+
+		env := make([]string, 0, len(sidecar.EnvironmentVars))
+		for key, value := range sidecar.EnvironmentVars {
+			env = append(env, fmt.Sprintf("%s=%s", key, value))
+		}
+		sort.Strings(env)
+
+		containerID, err := m.startContainer(name, sidecar.Image, 0, 0, env, args, parseContainerCommand(sidecar.Command))
+		if err != nil {
+			return fmt.Errorf("failed to start sidecar %s: %w", sidecar.Name, err)
+		}
+		if sidecarNetwork(sidecar) == SidecarNetworkStack {
+			if err := ConnectContainerToStackNetwork(containerID, service.ID); err != nil {
+				return fmt.Errorf("failed to connect sidecar %s to stack network: %w", sidecar.Name, err)
+			}
+		}
+		log.Printf("[ServiceManager] Sidecar started: service=%s sidecar=%s container=%s", service.ID, sidecar.Name, name)
+		m.logDeploy(service.ID, containerID, "info", "Sidecar %s started", name)
+	}
+	return nil
+}
+
+// healthCheckWithSidecars runs the primary container's health check, then
+// the sidecars'.
+func (m *Manager) healthCheckWithSidecars(service api.Service, containerName string, port int) error {
+	if err := m.healthCheck(service, containerName, port); err != nil {
+		return err
+	}
+	return m.checkSidecars(service, containerName)
+}
+
+// checkSidecars requires every sidecar to be running and to pass its health
+// check command, if it has one.
+func (m *Manager) checkSidecars(service api.Service, primaryName string) error {
+	for _, sidecar := range service.Sidecars {
+		name := sidecarContainerName(primaryName, sidecar)
+		status, err := getContainerStatus(name)
+		if err != nil {
+			return fmt.Errorf("sidecar %s: failed to read container status: %w", sidecar.Name, err)
+		}
+		if status != "running" {
+			return fmt.Errorf("sidecar %s is not running (status: %s)", sidecar.Name, status)
+		}
+		if err := m.execHealthCheck(service, name, sidecar.HealthCheckCommand); err != nil {
+			return fmt.Errorf("sidecar %s: %w", sidecar.Name, err)
+		}
+	}
+	return nil
+}
+
+// probeSidecars is the single-shot form of checkSidecars used by
+// availability probes.
+func probeSidecars(service api.Service, primaryName string) bool {
+	for _, sidecar := range service.Sidecars {
+		name := sidecarContainerName(primaryName, sidecar)
+		if status, err := getContainerStatus(name); err != nil || status != "running" {
+			return false
+		}
+		if command := strings.TrimSpace(sidecar.HealthCheckCommand); command != "" && execInContainer(name, command) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// stopSidecars removes the sidecars of a primary container. They are stopped
+// after the primary so log shippers and proxies outlive it.
+func (m *Manager) stopSidecars(service api.Service, primaryName string) {
+	for _, sidecar := range service.Sidecars {
+		if err := stopContainer(sidecarContainerName(primaryName, sidecar)); err != nil {
+			log.Printf("[ServiceManager] Failed to stop sidecar: service=%s sidecar=%s err=%v", service.ID, sidecar.Name, err)
+		}
+	}
+}
+
+// renameSidecars follows a primary container rename after a blue/green switch.
+func (m *Manager) renameSidecars(service api.Service, from, to string) {
+	for _, sidecar := range service.Sidecars {
+		if err := renameContainer(sidecarContainerName(from, sidecar), sidecarContainerName(to, sidecar)); err != nil {
+			m.logVerbose("Failed to rename sidecar %s of %s: %v", sidecar.Name, service.ID, err)
+		}
+	}
+}
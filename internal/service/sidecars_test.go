@@ -0,0 +1,104 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/buildvigil/agent/internal/api"
+)
+
+func TestValidateSidecars(t *testing.T) {
+	t.Logf("Testing sidecar validation...")
+	cases := []struct {
+		name     string
+		sidecars []api.Sidecar
+		wantErr  string
+	}{
+		{"valid", []api.Sidecar{{Name: "logs", Image: "fluent-bit"}, {Name: "sql-proxy", Image: "cloudsql-proxy", Network: "stack"}}, ""},
+		{"bad name", []api.Sidecar{{Name: "Logs", Image: "fluent-bit"}}, "invalid sidecar name"},
+		{"duplicate", []api.Sidecar{{Name: "logs", Image: "a"}, {Name: "logs", Image: "b"}}, "duplicate"},
+		{"no image", []api.Sidecar{{Name: "logs"}}, "image is required"},
+		{"bad network", []api.Sidecar{{Name: "logs", Image: "a", Network: "host"}}, "invalid network"},
+		{"managed flag", []api.Sidecar{{Name: "logs", Image: "a", DockerRunArgs: "--network=host"}}, "disallowed option"},
+	}
+	for _, tc := range cases {
+		err := validateSidecars(api.Service{ID: "svc", Sidecars: tc.sidecars})
+		if tc.wantErr == "" && err != nil {
+			t.Errorf("%s: unexpected error %v", tc.name, err)
+		}
+		if tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)) {
+			t.Errorf("%s: expected error containing %q, got %v", tc.name, tc.wantErr, err)
+		}
+	}
+	t.Logf("✓ Sidecar definitions validated")
+}
+
+func TestCheckSidecars(t *testing.T) {
+	t.Logf("Testing sidecar health evaluation...")
+	originalStatus, originalExec := getContainerStatus, execInContainer
+	defer func() { getContainerStatus, execInContainer = originalStatus, originalExec }()
+
+	statuses := map[string]string{"potato-cloud-api-logs": "running", "potato-cloud-api-sql": "exited"}
+	getContainerStatus = func(name string) (string, error) { return statuses[name], nil }
+	var execed []string
+	execInContainer = func(name, command string) error {
+		execed = append(execed, name)
+		return nil
+	}
+
+	m := NewManager(t.TempDir(), nil, nil, 3000, 3010, false)
+	service := api.Service{ID: "api", Sidecars: []api.Sidecar{
+		{Name: "logs", Image: "fluent-bit", HealthCheckCommand: "pgrep fluent-bit"},
+		{Name: "sql", Image: "cloudsql-proxy"},
+	}}
+	if err := m.checkSidecars(service, "potato-cloud-api"); err == nil || !strings.Contains(err.Error(), "sidecar sql is not running") {
+		t.Errorf("Expected exited sidecar to fail health, got %v", err)
+	}
+	if probeSidecars(service, "potato-cloud-api") {
+		t.Error("Expected probe to report an exited sidecar as down")
+	}
+
+	statuses["potato-cloud-api-sql"] = "running"
+	if err := m.checkSidecars(service, "potato-cloud-api"); err != nil {
+		t.Errorf("Expected healthy sidecars, got %v", err)
+	}
+	if len(execed) == 0 || execed[0] != "potato-cloud-api-logs" {
+		t.Errorf("Expected health command run in the logs sidecar, got %v", execed)
+	}
+
+	execInContainer = func(name, command string) error { return errors.New("exit status 1") }
+	if probeSidecars(service, "potato-cloud-api") {
+		t.Error("Expected probe to fail when a sidecar health command fails")
+	}
+	t.Logf("✓ Sidecars included in health evaluation")
+}
+
+func TestStopAndRenameSidecars(t *testing.T) {
+	t.Logf("Testing sidecars follow their primary container...")
+	originalStop, originalRename := stopContainer, renameContainer
+	defer func() { stopContainer, renameContainer = originalStop, originalRename }()
+
+	var stopped, renamed []string
+	stopContainer = func(name string) error {
+		stopped = append(stopped, name)
+		return nil
+	}
+	renameContainer = func(from, to string) error {
+		renamed = append(renamed, from+">"+to)
+		return nil
+	}
+
+	m := NewManager(t.TempDir(), nil, nil, 3000, 3010, false)
+	service := api.Service{ID: "api", Sidecars: []api.Sidecar{{Name: "logs", Image: "fluent-bit"}}}
+	m.renameSidecars(service, "potato-cloud-api-green", "potato-cloud-api")
+	m.stopSidecars(service, "potato-cloud-api")
+
+	if len(renamed) != 1 || renamed[0] != "potato-cloud-api-green-logs>potato-cloud-api-logs" {
+		t.Errorf("Unexpected renames: %v", renamed)
+	}
+	if len(stopped) != 1 || stopped[0] != "potato-cloud-api-logs" {
+		t.Errorf("Unexpected stops: %v", stopped)
+	}
+	t.Logf("✓ Sidecars renamed and stopped with primary")
+}
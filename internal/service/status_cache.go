@@ -0,0 +1,118 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	containerpkg "github.com/buildvigil/agent/internal/container"
+)
+
+// Container statuses are cached between heartbeats. Docker events keep the
+// status of labelled containers current while the events stream is up, so
+// those are reused for containerStatusWatchedTTL; others, and all of them
+// while the stream is down, for containerStatusTTL.
+const (
+	containerStatusTTL        = 10 * time.Second
+	containerStatusWatchedTTL = 5 * time.Minute
+)
+
+var inspectContainerState = defaultInspectContainerState
+
+type cachedStatus struct {
+	status   string
+	labelled bool
+	at       time.Time
+}
+
+// containerStatuses caches container statuses by container name. watching
+// is set while the docker events stream is open.
+var containerStatuses = struct {
+	sync.Mutex
+	entries  map[string]cachedStatus
+	watching bool
+}{entries: make(map[string]cachedStatus)}
+
+// cachedContainerStatus returns a container's status, running docker inspect
+// only when no fresh status is cached.
+func cachedContainerStatus(containerName string) (string, error) {
+	name := strings.TrimPrefix(containerName, "/")
+	containerStatuses.Lock()
+	entry, ok := containerStatuses.entries[name]
+	ttl := containerStatusTTL
+	if entry.labelled && containerStatuses.watching {
+		ttl = containerStatusWatchedTTL
+	}
+	containerStatuses.Unlock()
+	if ok && time.Since(entry.at) < ttl {
+		return entry.status, nil
+	}
+
+	status, labelled, err := inspectContainerState(name)
+	if err != nil {
+		forgetContainerStatus(name)
+		return "", err
+	}
+	containerStatuses.Lock()
+	containerStatuses.entries[name] = cachedStatus{status: status, labelled: labelled, at: time.Now()}
+	containerStatuses.Unlock()
+	return status, nil
+}
+
+// setContainerStatus records a status reported by docker events.
+func setContainerStatus(containerName, status string) {
+	containerStatuses.Lock()
+	defer containerStatuses.Unlock()
+	containerStatuses.entries[strings.TrimPrefix(containerName, "/")] = cachedStatus{status: status, labelled: true, at: time.Now()}
+}
+
+// forgetContainerStatus drops cached statuses, so the next lookup inspects
+// the container.
+func forgetContainerStatus(containerNames ...string) {
+	containerStatuses.Lock()
+	defer containerStatuses.Unlock()
+	for _, name := range containerNames {
+		delete(containerStatuses.entries, strings.TrimPrefix(name, "/"))
+	}
+}
+
+// setWatchingContainerEvents records whether the docker events stream is
+// open and keeping labelled statuses current.
+func setWatchingContainerEvents(watching bool) {
+	containerStatuses.Lock()
+	defer containerStatuses.Unlock()
+	containerStatuses.watching = watching
+}
+
+// updateContainerStatus applies a docker event to the status cache.
+func updateContainerStatus(name, action string, attrs map[string]string) {
+	switch action {
+	case "create":
+		setContainerStatus(name, "created")
+	case "start", "restart", "unpause":
+		setContainerStatus(name, "running")
+	case "pause":
+		setContainerStatus(name, "paused")
+	case "die", "stop":
+		setContainerStatus(name, "exited")
+	case "destroy":
+		forgetContainerStatus(name)
+	case "rename":
+		forgetContainerStatus(name, attrs["oldName"])
+	}
+}
+
+// defaultInspectContainerState returns a container's status and whether it
+// carries ServiceLabel, so docker events report its changes.
+func defaultInspectContainerState(containerName string) (string, bool, error) {
+	output, err := containerpkg.DockerOutput(containerpkg.DockerInspect, "inspect", "--format", fmt.Sprintf("{{.State.Status}} {{index .Config.Labels %q}}", ServiceLabel), containerName)
+	if err != nil {
+		return "", false, err
+	}
+	fields := strings.Fields(string(output))
+	if len(fields) == 0 {
+		return "", false, fmt.Errorf("docker inspect returned no status for %s", containerName)
+	}
+	return fields[0], len(fields) > 1, nil
+}
@@ -0,0 +1,90 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/buildvigil/agent/internal/api"
+)
+
+func TestContainerStatusCache(t *testing.T) {
+	t.Logf("Testing container statuses are cached and kept current by docker events...")
+	original := inspectContainerState
+	forgetContainerStatus("potato-cloud-web")
+	defer func() {
+		inspectContainerState = original
+		setWatchingContainerEvents(false)
+		forgetContainerStatus("potato-cloud-web")
+	}()
+	inspections := 0
+	status, labelled := "running", true
+	inspectContainerState = func(string) (string, bool, error) {
+		inspections++
+		return status, labelled, nil
+	}
+
+	m := NewManager(t.TempDir(), nil, nil, 3000, 3010, false)
+	m.containers["web"] = &containerInfo{service: api.Service{ID: "web"}, containerName: "potato-cloud-web"}
+	check := func(want string) {
+		t.Helper()
+		got, err := m.GetServiceStatus("web")
+		if err != nil || got["status"] != want || got["running"] != (want == "running") {
+			t.Fatalf("Expected status %s, got %v (err=%v)", want, got, err)
+		}
+	}
+
+	check("running")
+	check("running")
+	if inspections != 1 {
+		t.Fatalf("Expected one inspect for two lookups, got %d", inspections)
+	}
+
+	send := func(action string, attrs map[string]string) {
+		event := containerEvent{Action: action}
+		event.Actor.Attributes = map[string]string{ServiceLabel: "web", "name": "potato-cloud-web"}
+		for key, value := range attrs {
+			event.Actor.Attributes[key] = value
+		}
+		m.handleContainerEvent(event)
+	}
+	send("die", map[string]string{"exitCode": "1"})
+	check("exited")
+	send("start", nil)
+	check("running")
+	if inspections != 1 {
+		t.Errorf("Expected events to update the cache without inspecting, got %d inspections", inspections)
+	}
+
+	// Without the events stream, cached statuses expire after the short TTL.
+	setContainerStatus("potato-cloud-web", "running")
+	containerStatuses.Lock()
+	entry := containerStatuses.entries["potato-cloud-web"]
+	entry.at = time.Now().Add(-containerStatusTTL)
+	containerStatuses.entries["potato-cloud-web"] = entry
+	containerStatuses.Unlock()
+	status = "paused"
+	check("paused")
+	if inspections != 2 {
+		t.Errorf("Expected an expired status to be inspected again, got %d inspections", inspections)
+	}
+
+	// While the stream is open, labelled statuses are trusted for longer.
+	setWatchingContainerEvents(true)
+	containerStatuses.Lock()
+	entry = containerStatuses.entries["potato-cloud-web"]
+	entry.at = time.Now().Add(-containerStatusTTL)
+	containerStatuses.entries["potato-cloud-web"] = entry
+	containerStatuses.Unlock()
+	check("paused")
+	if inspections != 2 {
+		t.Errorf("Expected a watched status to be reused, got %d inspections", inspections)
+	}
+
+	noteAgentAction("potato-cloud-web")
+	status = "running"
+	check("running")
+	if inspections != 3 {
+		t.Errorf("Expected the agent's own changes to drop the cached status, got %d inspections", inspections)
+	}
+	t.Logf("✓ Statuses cached, updated from events and refreshed after changes")
+}
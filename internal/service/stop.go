@@ -0,0 +1,145 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/buildvigil/agent/internal/api"
+	containerpkg "github.com/buildvigil/agent/internal/container"
+)
+
+const (
+	DefaultStopSignal  = "SIGTERM"
+	DefaultStopTimeout = 10
+	MaxStopTimeout     = 300
+)
+
+var allowedStopSignals = map[string]bool{
+	"SIGTERM": true,
+	"SIGINT":  true,
+	"SIGQUIT": true,
+}
+
+// stopSettings controls how a service container is shut down.
+type stopSettings struct {
+	signal  string
+	timeout int
+	preStop string
+}
+
+// stopSettingsForService returns the validated stop signal, grace period and
+// pre-stop command for a service.
+func stopSettingsForService(service api.Service) (stopSettings, error) {
+	settings := stopSettings{
+		signal:  DefaultStopSignal,
+		timeout: DefaultStopTimeout,
+		preStop: strings.TrimSpace(service.PreStopCommand),
+	}
+
+	if signal := strings.ToUpper(strings.TrimSpace(service.StopSignal)); signal != "" {
+		if !strings.HasPrefix(signal, "SIG") {
+			signal = "SIG" + signal
+		}
+		if !allowedStopSignals[signal] {
+			return settings, fmt.Errorf("invalid stop_signal %q; expected SIGTERM, SIGINT, or SIGQUIT", service.StopSignal)
+		}
+		settings.signal = signal
+	}
+
+	if service.StopTimeout < 0 || service.StopTimeout > MaxStopTimeout {
+		return settings, fmt.Errorf("invalid stop_timeout %d; expected 0-%d seconds", service.StopTimeout, MaxStopTimeout)
+	}
+	if service.StopTimeout > 0 {
+		settings.timeout = service.StopTimeout
+	}
+	return settings, nil
+}
+
+// runArgs returns docker run flags that bake the stop signal and grace period
+// into the container, so any `docker stop` (including by the daemon) honours them.
+func (s stopSettings) runArgs() []string {
+	return []string{"--stop-signal", s.signal, "--stop-timeout", strconv.Itoa(s.timeout)}
+}
+
+// stopContainer runs the service's pre-stop hook, then stops and removes the
+// container, allowing it the configured grace period to exit.
+func (m *Manager) stopContainer(service api.Service, name string) error {
+	if strings.TrimSpace(name) == "" {
+		return nil
+	}
+	defer m.stopSidecars(service, name)
+
+	settings, err := stopSettingsForService(service)
+	if err != nil {
+		// Fall back to defaults; the container still has to go.
+		log.Printf("[ServiceManager] Invalid stop settings, using defaults: service=%s err=%v", service.ID, err)
+		settings = stopSettings{signal: DefaultStopSignal, timeout: DefaultStopTimeout}
+	}
+
+	if settings.preStop != "" {
+		m.runPreStop(service, name, settings)
+	}
+
+	stopOut, stopErr := containerpkg.DockerStopCombinedOutput(time.Duration(settings.timeout)*time.Second, "stop", "-t", strconv.Itoa(settings.timeout), name)
+	if stopErr != nil {
+		msg := strings.ToLower(string(stopOut))
+		if !strings.Contains(msg, "no such container") && !strings.Contains(msg, "no such object") && !strings.Contains(msg, "is not running") {
+			return fmt.Errorf("failed to stop container: %w (output: %s)", stopErr, strings.TrimSpace(string(stopOut)))
+		}
+	}
+
+	rmOut, rmErr := containerpkg.DockerCombinedOutput(containerpkg.DockerStop, "rm", "-f", name)
+	if rmErr != nil {
+		msg := strings.ToLower(string(rmOut))
+		if !strings.Contains(msg, "no such container") && !strings.Contains(msg, "no such object") {
+			return fmt.Errorf("failed to remove container: %w (output: %s)", rmErr, strings.TrimSpace(string(rmOut)))
+		}
+	}
+
+	return nil
+}
+
+// runPreStop executes the pre-stop command inside the container, bounded by
+// the stop grace period. Failures are logged and do not block the stop.
+func (m *Manager) runPreStop(service api.Service, name string, settings stopSettings) {
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(settings.timeout)*time.Second)
+	defer cancel()
+
+	output, err := exec.CommandContext(ctx, "docker", "exec", name, "sh", "-c", settings.preStop).CombinedOutput()
+	if err != nil {
+		log.Printf("[ServiceManager] Pre-stop hook failed: service=%s container=%s err=%v output=%s", service.ID, name, err, strings.TrimSpace(string(output)))
+		return
+	}
+	log.Printf("[ServiceManager] Pre-stop hook complete: service=%s container=%s elapsed=%s", service.ID, name, time.Since(start))
+}
+
+// RestartService restarts a service's running container in place, after its
+// pre-stop hook, keeping its port and routes.
+func (m *Manager) RestartService(serviceID string) error {
+	info, exists := m.trackedContainer(serviceID)
+	if !exists {
+		return fmt.Errorf("service %s not found", serviceID)
+	}
+
+	settings, err := stopSettingsForService(info.service)
+	if err != nil {
+		log.Printf("[ServiceManager] Invalid stop settings, using defaults: service=%s err=%v", serviceID, err)
+		settings = stopSettings{signal: DefaultStopSignal, timeout: DefaultStopTimeout}
+	}
+	if settings.preStop != "" {
+		m.runPreStop(info.service, info.containerName, settings)
+	}
+
+	output, err := containerpkg.DockerStopCombinedOutput(time.Duration(settings.timeout)*time.Second, "restart", "-t", strconv.Itoa(settings.timeout), info.containerName)
+	if err != nil {
+		return fmt.Errorf("failed to restart container: %w (output: %s)", err, strings.TrimSpace(string(output)))
+	}
+	log.Printf("[ServiceManager] Service restarted: service=%s container=%s", serviceID, info.containerName)
+	return nil
+}
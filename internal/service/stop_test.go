@@ -0,0 +1,40 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/buildvigil/agent/internal/api"
+)
+
+func TestStopSettingsForService(t *testing.T) {
+	t.Logf("Testing stop signal and timeout settings...")
+
+	settings, err := stopSettingsForService(api.Service{})
+	if err != nil || settings.signal != DefaultStopSignal || settings.timeout != DefaultStopTimeout {
+		t.Errorf("Expected defaults, got %+v (err=%v)", settings, err)
+	}
+
+	settings, err = stopSettingsForService(api.Service{StopSignal: "quit", StopTimeout: 45, PreStopCommand: " ./flush.sh "})
+	if err != nil {
+		t.Fatalf("Expected valid settings: %v", err)
+	}
+	if settings.signal != "SIGQUIT" || settings.timeout != 45 || settings.preStop != "./flush.sh" {
+		t.Errorf("Unexpected settings: %+v", settings)
+	}
+
+	args := settings.runArgs()
+	expected := []string{"--stop-signal", "SIGQUIT", "--stop-timeout", "45"}
+	for i := range expected {
+		if args[i] != expected[i] {
+			t.Errorf("Run arg %d: expected %q, got %q", i, expected[i], args[i])
+		}
+	}
+
+	if _, err := stopSettingsForService(api.Service{StopSignal: "SIGKILL"}); err == nil {
+		t.Error("Expected SIGKILL to be rejected")
+	}
+	if _, err := stopSettingsForService(api.Service{StopTimeout: MaxStopTimeout + 1}); err == nil {
+		t.Error("Expected stop_timeout above maximum to be rejected")
+	}
+	t.Logf("✓ Stop settings validated")
+}
@@ -0,0 +1,230 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/buildvigil/agent/internal/api"
+	"github.com/buildvigil/agent/internal/state"
+)
+
+const (
+	// ServiceTypeTask runs a container to completion on each new revision
+	// instead of keeping it running behind the proxy.
+	ServiceTypeTask = "task"
+
+	// DefaultTaskTimeout bounds a single task attempt.
+	DefaultTaskTimeout = time.Hour
+
+	// taskOutputLimit is how much of a task's output is kept, from the end.
+	taskOutputLimit = 4096
+)
+
+// IsTask reports whether a service is a run-to-completion task.
+func IsTask(service api.Service) bool {
+	return strings.EqualFold(strings.TrimSpace(service.ServiceType), ServiceTypeTask)
+}
+
+// UsesPrebuiltImage reports whether a service runs docker_image rather than
+// an image built from its git repository.
+func UsesPrebuiltImage(service api.Service) bool {
+	if IsTask(service) || IsWorker(service) {
+		return strings.TrimSpace(service.DockerImage) != ""
+	}
+	return strings.EqualFold(strings.TrimSpace(service.ServiceType), "docker")
+}
+
+// RunTask builds or pulls a task's image and runs it to completion, retrying
+// failed runs up to task_retries times. The outcome is recorded as an event,
+// persisted as the service's status and kept for heartbeats.
+func (m *Manager) RunTask(service api.Service) (api.TaskResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	result := api.TaskResult{ServiceID: service.ID, Revision: service.GitCommit}
+	m.reportLifecycle(service, "building", "unknown", "")
+	log.Printf("[ServiceManager] Task start: service=%s revision=%s", service.ID, service.GitCommit)
+
+	m.deployID = newDeployID(service, time.Now())
+	m.deployDeadline = time.Now().Add(maxDeployDuration(service))
+	defer func() {
+		m.deployID = ""
+		m.deployDeadline = time.Time{}
+	}()
+
+	imageTag := fmt.Sprintf("%s-%s:latest", ImagePrefix, service.ID)
+	imageRef, err := m.resolveDeployImage(service, imageTag)
+	if err != nil {
+		result.Error = fmt.Sprintf("failed to prepare image: %v", err)
+		return m.finishTask(service, imageRef, result), errors.New(result.Error)
+	}
+	if err := validateContainerArgs(service); err != nil {
+		result.Error = err.Error()
+		return m.finishTask(service, imageRef, result), err
+	}
+	if err := validateConfigFiles(service); err != nil {
+		result.Error = err.Error()
+		return m.finishTask(service, imageRef, result), err
+	}
+	locale, err := localeSettingsForService(service)
+	if err != nil {
+		result.Error = err.Error()
+		return m.finishTask(service, imageRef, result), err
+	}
+	if err := validateDependencies(service); err != nil {
+		result.Error = err.Error()
+		return m.finishTask(service, imageRef, result), err
+	}
+	if err := m.checkDependencies(service); err != nil {
+		result.Error = err.Error()
+		return m.finishTask(service, imageRef, result), err
+	}
+	limits, err := m.resourceLimitArgs(service)
+	if err != nil {
+		result.Error = err.Error()
+		return m.finishTask(service, imageRef, result), err
+	}
+	mounts, err := m.configFileArgs(service)
+	if err != nil {
+		result.Error = err.Error()
+		return m.finishTask(service, imageRef, result), err
+	}
+	volumes, err := m.volumeArgs(service)
+	if err != nil {
+		result.Error = err.Error()
+		return m.finishTask(service, imageRef, result), err
+	}
+	mounts = append(mounts, locale.runArgs(service)...)
+	mounts = append(mounts, limits...)
+	mounts = append(mounts, volumes...)
+	m.pruneConfigFiles(service, true)
+
+	result = m.finishTask(service, imageRef, m.runTaskAttempts(service, imageRef, mounts, result))
+	if !result.Succeeded {
+		return result, errors.New(result.Error)
+	}
+	return result, nil
+}
+
+// runTaskAttempts runs a task container until it exits zero or its retries
+// are used up.
+func (m *Manager) runTaskAttempts(service api.Service, imageRef string, mounts []string, result api.TaskResult) api.TaskResult {
+	timeout := DefaultTaskTimeout
+	if service.TaskTimeout > 0 {
+		timeout = time.Duration(service.TaskTimeout) * time.Second
+	}
+	containerName := fmt.Sprintf("%s-%s-task", ContainerPrefix, service.ID)
+	args := append(platformArgs(service), entrypointRunArgs(service)...)
+	args = append(args, parseDockerRunArgs(service)...)
+	args = append(args, mounts...)
+	env := m.prepareEnvironment(service)
+
+	m.reportLifecycle(service, "running", "unknown", "")
+	start := time.Now()
+	for attempt := 1; attempt <= service.TaskRetries+1; attempt++ {
+		result.Attempts = attempt
+		attemptStart := time.Now()
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		exitCode, output, runErr := runTaskContainer(ctx, service.ID, containerName, imageRef, env, args, containerCommandForService(service))
+		timedOut := ctx.Err() == context.DeadlineExceeded
+		cancel()
+
+		result.ExitCode = exitCode
+		result.Output = tailOutput(output, taskOutputLimit)
+		result.Error = ""
+		switch {
+		case timedOut:
+			result.Error = fmt.Sprintf("task timed out after %s", timeout)
+		case runErr != nil:
+			result.Error = runErr.Error()
+		case exitCode != 0:
+			result.Error = fmt.Sprintf("task exited with code %d", exitCode)
+		default:
+			result.Succeeded = true
+		}
+		log.Printf("[ServiceManager] Task attempt finished: service=%s attempt=%d exit=%d elapsed=%s err=%s",
+			service.ID, attempt, exitCode, time.Since(attemptStart), result.Error)
+		if result.Succeeded {
+			break
+		}
+	}
+	result.DurationMs = time.Since(start).Milliseconds()
+	return result
+}
+
+// finishTask records a task's outcome.
+func (m *Manager) finishTask(service api.Service, imageRef string, result api.TaskResult) api.TaskResult {
+	result.FinishedAt = time.Now().UTC()
+	status, eventType := "completed", "task_succeeded"
+	if !result.Succeeded {
+		status, eventType = "failed", "task_failed"
+	}
+
+	message := fmt.Sprintf("revision=%s exit=%d attempts=%d duration=%dms", result.Revision, result.ExitCode, result.Attempts, result.DurationMs)
+	if result.Error != "" {
+		message += " error=" + result.Error
+	}
+	if err := m.state.RecordEvent(service.ID, eventType, message); err != nil {
+		m.logVerbose("Failed to record task event for %s: %v", service.ID, err)
+	}
+	// A task that never ran keeps no revision, so the next sync tries again.
+	revision := service.GitCommit
+	if result.Attempts == 0 {
+		revision = ""
+	}
+	if err := m.state.SaveServiceProcess(&state.ServiceProcess{
+		ServiceID:   service.ID,
+		ServiceName: service.Name,
+		GitCommit:   revision,
+		Runtime:     "docker",
+		ImageTag:    imageRef,
+		DeployID:    m.deployID,
+		Status:      status,
+		LastError:   result.Error,
+		StartedAt:   time.Now().UTC(),
+	}); err != nil {
+		m.logVerbose("Failed to persist task state for %s: %v", service.ID, err)
+	}
+
+	m.taskMu.Lock()
+	if m.taskResults == nil {
+		m.taskResults = make(map[string]api.TaskResult)
+	}
+	m.taskResults[service.ID] = result
+	m.taskMu.Unlock()
+
+	m.reportLifecycle(service, status, "unknown", result.Error)
+	log.Printf("[ServiceManager] Task %s: service=%s %s", status, service.ID, message)
+	return result
+}
+
+// TaskResults returns the latest result of each task run since startup.
+func (m *Manager) TaskResults() []api.TaskResult {
+	m.taskMu.Lock()
+	defer m.taskMu.Unlock()
+	results := make([]api.TaskResult, 0, len(m.taskResults))
+	for _, result := range m.taskResults {
+		results = append(results, result)
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].ServiceID < results[j].ServiceID })
+	return results
+}
+
+// ForgetTask drops the result of a removed task.
+func (m *Manager) ForgetTask(serviceID string) {
+	m.taskMu.Lock()
+	defer m.taskMu.Unlock()
+	delete(m.taskResults, serviceID)
+}
+
+func tailOutput(output string, limit int) string {
+	if len(output) <= limit {
+		return output
+	}
+	return "..." + output[len(output)-limit:]
+}
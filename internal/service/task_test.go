@@ -0,0 +1,105 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/buildvigil/agent/internal/api"
+	"github.com/buildvigil/agent/internal/state"
+)
+
+func TestRunTaskAttempts_RetriesUntilSuccess(t *testing.T) {
+	t.Logf("Testing task retries and result recording...")
+
+	stateMgr, err := state.NewManager(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to create state manager: %v", err)
+	}
+	defer stateMgr.Close()
+
+	calls := 0
+	original := runTaskContainer
+	runTaskContainer = func(ctx context.Context, stackID, name, imageID string, env, runArgs, command []string) (int, string, error) {
+		calls++
+		if calls < 3 {
+			return 1, "migration failed\n", nil
+		}
+		return 0, strings.Repeat("x", taskOutputLimit+100), nil
+	}
+	defer func() { runTaskContainer = original }()
+
+	m := NewManager(t.TempDir(), stateMgr, nil, 3000, 3010, false)
+	service := api.Service{ID: "seed", Name: "seed", ServiceType: "task", DockerImage: "seed:1", GitCommit: "rev-1", TaskRetries: 2}
+	result := m.finishTask(service, "seed:1", m.runTaskAttempts(service, "seed:1", nil, api.TaskResult{ServiceID: "seed", Revision: "rev-1"}))
+
+	if !result.Succeeded || result.Attempts != 3 || result.ExitCode != 0 {
+		t.Fatalf("Expected success on third attempt, got %+v", result)
+	}
+	if len(result.Output) != taskOutputLimit+3 || !strings.HasPrefix(result.Output, "...") {
+		t.Errorf("Expected output truncated to %d bytes, got %d", taskOutputLimit, len(result.Output))
+	}
+
+	proc, err := stateMgr.GetServiceProcess("seed")
+	if err != nil || proc == nil {
+		t.Fatalf("Expected task state to be saved, got %v", err)
+	}
+	if proc.Status != "completed" || proc.GitCommit != "rev-1" {
+		t.Errorf("Expected completed at rev-1, got status=%s commit=%s", proc.Status, proc.GitCommit)
+	}
+	events, _ := stateMgr.ListRecentEvents(1)
+	if len(events) != 1 || events[0].EventType != "task_succeeded" {
+		t.Errorf("Expected task_succeeded event, got %+v", events)
+	}
+	if results := m.TaskResults(); len(results) != 1 || results[0].ServiceID != "seed" {
+		t.Errorf("Expected one task result, got %+v", results)
+	}
+	t.Logf("✓ Task succeeded after retries and was recorded")
+}
+
+func TestRunTaskAttempts_Failure(t *testing.T) {
+	t.Logf("Testing task failure and timeout reporting...")
+
+	original := runTaskContainer
+	runTaskContainer = func(ctx context.Context, stackID, name, imageID string, env, runArgs, command []string) (int, string, error) {
+		<-ctx.Done()
+		return -1, "", ctx.Err()
+	}
+	defer func() { runTaskContainer = original }()
+
+	m := NewManager(t.TempDir(), nil, nil, 3000, 3010, false)
+	service := api.Service{ID: "batch", ServiceType: "task", TaskTimeout: 1}
+	result := m.runTaskAttempts(service, "batch:1", nil, api.TaskResult{ServiceID: "batch"})
+	if result.Succeeded || result.Attempts != 1 || !strings.Contains(result.Error, "timed out") {
+		t.Errorf("Expected a single timed out attempt, got %+v", result)
+	}
+
+	runTaskContainer = func(ctx context.Context, stackID, name, imageID string, env, runArgs, command []string) (int, string, error) {
+		return -1, "", errors.New("docker create failed")
+	}
+	result = m.runTaskAttempts(api.Service{ID: "batch", ServiceType: "task", TaskRetries: 1}, "batch:1", nil, api.TaskResult{ServiceID: "batch"})
+	if result.Succeeded || result.Attempts != 2 || result.Error != "docker create failed" {
+		t.Errorf("Expected two failed attempts, got %+v", result)
+	}
+	t.Logf("✓ Task failures reported")
+}
+
+func TestUsesPrebuiltImage(t *testing.T) {
+	t.Logf("Testing prebuilt image detection...")
+	cases := []struct {
+		service api.Service
+		want    bool
+	}{
+		{api.Service{ServiceType: "docker", DockerImage: "nginx"}, true},
+		{api.Service{ServiceType: "task", DockerImage: "seed:1"}, true},
+		{api.Service{ServiceType: "task"}, false},
+		{api.Service{ServiceType: "git"}, false},
+	}
+	for _, tc := range cases {
+		if got := UsesPrebuiltImage(tc.service); got != tc.want {
+			t.Errorf("UsesPrebuiltImage(%+v) = %t, want %t", tc.service, got, tc.want)
+		}
+	}
+	t.Logf("✓ Prebuilt image detection correct")
+}
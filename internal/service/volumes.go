@@ -0,0 +1,183 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/buildvigil/agent/internal/api"
+	containerpkg "github.com/buildvigil/agent/internal/container"
+)
+
+var volumeNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]*$`)
+
+var (
+	createVolume       = defaultCreateVolume
+	listServiceVolumes = defaultListServiceVolumes
+	removeVolume       = defaultRemoveVolume
+)
+
+// SetAllowedBindMounts sets the host directories services may bind-mount;
+// a volume's host_path must be one of them or under one. With none, bind
+// mounts fail the service's deploy.
+func (m *Manager) SetAllowedBindMounts(dirs []string) {
+	cleaned := make([]string, 0, len(dirs))
+	for _, dir := range dirs {
+		if dir = strings.TrimSpace(dir); dir != "" {
+			cleaned = append(cleaned, filepath.Clean(dir))
+		}
+	}
+	m.limitMu.Lock()
+	defer m.limitMu.Unlock()
+	m.bindMounts = cleaned
+}
+
+// serviceVolumeName is the docker volume backing a service's named volume.
+// It depends only on the service ID, so blue and green mount the same one.
+func serviceVolumeName(serviceID, name string) string {
+	return "potato-cloud-" + serviceID + "-" + name
+}
+
+// validateVolumes checks a service's volumes against the allowed bind mount
+// directories.
+func (m *Manager) validateVolumes(service api.Service) error {
+	m.limitMu.Lock()
+	bindMounts := m.bindMounts
+	m.limitMu.Unlock()
+	return checkVolumes(service, bindMounts)
+}
+
+func checkVolumes(service api.Service, bindMounts []string) error {
+	names := make(map[string]bool)
+	paths := make(map[string]bool)
+	for _, volume := range service.Volumes {
+		target := volume.Path
+		if !path.IsAbs(target) || path.Clean(target) == "/" || strings.ContainsAny(target, ":,") {
+			return fmt.Errorf("invalid volume path %q; use an absolute path inside the container", volume.Path)
+		}
+		if paths[path.Clean(target)] {
+			return fmt.Errorf("volume path %s is mounted twice", target)
+		}
+		paths[path.Clean(target)] = true
+
+		switch {
+		case volume.Name != "" && volume.HostPath != "":
+			return fmt.Errorf("volume at %s sets both name and host_path", target)
+		case volume.Name != "":
+			if !volumeNamePattern.MatchString(volume.Name) {
+				return fmt.Errorf("invalid volume name %q; use lowercase letters, digits, '.', '_' and '-'", volume.Name)
+			}
+			if names[volume.Name] {
+				return fmt.Errorf("volume %s is listed twice", volume.Name)
+			}
+			names[volume.Name] = true
+		case volume.HostPath != "":
+			if !filepath.IsAbs(volume.HostPath) || strings.ContainsAny(volume.HostPath, ":,") {
+				return fmt.Errorf("invalid volume host_path %q; use an absolute host directory", volume.HostPath)
+			}
+			if !bindMountAllowed(filepath.Clean(volume.HostPath), bindMounts) {
+				return fmt.Errorf("host_path %s is not under the agent's allowed_bind_mounts", volume.HostPath)
+			}
+		default:
+			return fmt.Errorf("volume at %s needs a name or a host_path", target)
+		}
+	}
+	return nil
+}
+
+func bindMountAllowed(hostPath string, bindMounts []string) bool {
+	for _, dir := range bindMounts {
+		if hostPath == dir || strings.HasPrefix(hostPath, strings.TrimSuffix(dir, string(filepath.Separator))+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// volumeArgs creates a service's named volumes when missing and returns the
+// -v flags mounting its volumes.
+func (m *Manager) volumeArgs(service api.Service) ([]string, error) {
+	if len(service.Volumes) == 0 {
+		return nil, nil
+	}
+	if err := m.validateVolumes(service); err != nil {
+		return nil, err
+	}
+
+	args := make([]string, 0, 2*len(service.Volumes))
+	for _, volume := range service.Volumes {
+		source := filepath.Clean(volume.HostPath)
+		if volume.Name != "" {
+			source = serviceVolumeName(service.ID, volume.Name)
+			if err := createVolume(source, service.ID); err != nil {
+				return nil, err
+			}
+		}
+		mount := source + ":" + path.Clean(volume.Path)
+		if volume.ReadOnly {
+			mount += ":ro"
+		}
+		args = append(args, "-v", mount)
+	}
+	return args, nil
+}
+
+// removeStackVolumes removes the named volumes of a stack's services. Data
+// in bind-mounted host directories is left alone.
+func (m *Manager) removeStackVolumes(stackID string) {
+	volumes, err := listServiceVolumes()
+	if err != nil {
+		m.logVerbose("Failed to list volumes: %v", err)
+		return
+	}
+	for name, serviceID := range volumes {
+		if !strings.HasPrefix(serviceID, stackID) {
+			continue
+		}
+		if err := removeVolume(name); err != nil {
+			m.logVerbose("Failed to remove volume %s: %v", name, err)
+			continue
+		}
+		log.Printf("[ServiceManager] Removed volume: service=%s volume=%s", serviceID, name)
+	}
+}
+
+// defaultCreateVolume creates a volume labelled with its service; an
+// existing volume is left as is.
+func defaultCreateVolume(name, serviceID string) error {
+	output, err := containerpkg.DockerCombinedOutput(containerpkg.DockerRun, "volume", "create", "--label", ServiceLabel+"="+serviceID, name)
+	if err != nil {
+		return fmt.Errorf("failed to create volume %s: %w (output: %s)", name, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// defaultListServiceVolumes returns the agent's volumes mapped to the
+// service that uses them.
+func defaultListServiceVolumes() (map[string]string, error) {
+	output, err := containerpkg.DockerOutput(containerpkg.DockerInspect, "volume", "ls",
+		"--filter", "label="+ServiceLabel,
+		"--format", "{{.Name}}\t{{.Label \""+ServiceLabel+"\"}}")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list volumes: %w", err)
+	}
+	volumes := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		name, serviceID, ok := strings.Cut(line, "\t")
+		if ok && name != "" {
+			volumes[name] = serviceID
+		}
+	}
+	return volumes, nil
+}
+
+func defaultRemoveVolume(name string) error {
+	output, err := containerpkg.DockerCombinedOutput(containerpkg.DockerStop, "volume", "rm", name)
+	if err != nil {
+		return fmt.Errorf("%w (output: %s)", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package service
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/buildvigil/agent/internal/api"
+)
+
+func TestVolumeArgs(t *testing.T) {
+	t.Logf("Testing named volumes and bind mounts are validated and mounted")
+	originalCreate := createVolume
+	defer func() { createVolume = originalCreate }()
+	created := map[string]string{}
+	createVolume = func(name, serviceID string) error {
+		created[name] = serviceID
+		return nil
+	}
+
+	m := &Manager{}
+	m.SetAllowedBindMounts([]string{"/srv/uploads/", " "})
+	svc := api.Service{ID: "web", Volumes: []api.Volume{
+		{Name: "data", Path: "/var/lib/app/"},
+		{HostPath: "/srv/uploads/web", Path: "/uploads", ReadOnly: true},
+	}}
+	args, err := m.volumeArgs(svc)
+	if err != nil {
+		t.Fatalf("Expected volumes to be accepted, got %v", err)
+	}
+	expected := []string{"-v", "potato-cloud-web-data:/var/lib/app", "-v", "/srv/uploads/web:/uploads:ro"}
+	if !reflect.DeepEqual(args, expected) {
+		t.Errorf("Expected %q, got %q", expected, args)
+	}
+	if created["potato-cloud-web-data"] != "web" || len(created) != 1 {
+		t.Errorf("Expected only the named volume created for web, got %v", created)
+	}
+
+	for name, tc := range map[string]struct {
+		volume api.Volume
+		want   string
+	}{
+		"relative path":     {api.Volume{Name: "data", Path: "data"}, "invalid volume path"},
+		"root path":         {api.Volume{Name: "data", Path: "/"}, "invalid volume path"},
+		"no source":         {api.Volume{Path: "/data"}, "needs a name or a host_path"},
+		"both sources":      {api.Volume{Name: "data", HostPath: "/srv/uploads", Path: "/data"}, "both"},
+		"bad name":          {api.Volume{Name: "Data/1", Path: "/data"}, "invalid volume name"},
+		"relative host":     {api.Volume{HostPath: "srv/uploads", Path: "/data"}, "invalid volume host_path"},
+		"outside allowed":   {api.Volume{HostPath: "/etc", Path: "/data"}, "allowed_bind_mounts"},
+		"sibling prefix":    {api.Volume{HostPath: "/srv/uploads-old", Path: "/data"}, "allowed_bind_mounts"},
+		"escaping via dots": {api.Volume{HostPath: "/srv/uploads/../../etc", Path: "/data"}, "allowed_bind_mounts"},
+	} {
+		if err := m.validateVolumes(api.Service{Volumes: []api.Volume{tc.volume}}); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: expected error containing %q, got %v", name, tc.want, err)
+		}
+	}
+	twice := api.Service{Volumes: []api.Volume{{Name: "a", Path: "/data"}, {Name: "b", Path: "/data/"}}}
+	if err := m.validateVolumes(twice); err == nil || !strings.Contains(err.Error(), "mounted twice") {
+		t.Errorf("Expected a path mounted twice to be rejected, got %v", err)
+	}
+	if err := (&Manager{}).validateVolumes(api.Service{Volumes: []api.Volume{{HostPath: "/srv/uploads", Path: "/data"}}}); err == nil {
+		t.Errorf("Expected bind mounts refused without allowed_bind_mounts")
+	}
+	t.Logf("✓ Volumes mounted consistently and invalid ones refused")
+}
+
+func TestCleanupStackRemovesVolumes(t *testing.T) {
+	t.Logf("Testing stack cleanup removes its services' named volumes")
+	originalList, originalRemove := listServiceVolumes, removeVolume
+	defer func() { listServiceVolumes, removeVolume = originalList, originalRemove }()
+	listServiceVolumes = func() (map[string]string, error) {
+		return map[string]string{
+			"potato-cloud-stack1-web-data": "stack1-web",
+			"potato-cloud-stack1-db-pg":    "stack1-db",
+			"potato-cloud-stack2-web-data": "stack2-web",
+		}, nil
+	}
+	var removed []string
+	removeVolume = func(name string) error {
+		removed = append(removed, name)
+		return nil
+	}
+
+	m := NewManager(t.TempDir(), nil, nil, 3000, 3010, false)
+	if err := m.CleanupStack("stack1"); err != nil {
+		t.Fatalf("Failed to clean up stack: %v", err)
+	}
+	sort.Strings(removed)
+	expected := []string{"potato-cloud-stack1-db-pg", "potato-cloud-stack1-web-data"}
+	if !reflect.DeepEqual(removed, expected) {
+		t.Errorf("Expected %v removed, got %v", expected, removed)
+	}
+	t.Logf("✓ Only the stack's volumes removed")
+}
+
+func TestRunArgsRefuseMounts(t *testing.T) {
+	t.Logf("Testing docker_run_args can't mount host paths around allowed_bind_mounts")
+	for _, runArgs := range []string{"-v /:/host", "-v/:/host", "--volume=/etc:/etc", "--mount type=bind,source=/,target=/host", "--volumes-from other"} {
+		svc := api.Service{ID: "web", ServiceType: "docker", DockerImage: "nginx:1.25", DockerRunArgs: runArgs}
+		if err := validateContainerArgs(svc); err == nil || !strings.Contains(err.Error(), "use volumes") {
+			t.Errorf("Expected %q refused on the service, got %v", runArgs, err)
+		}
+		sidecar := api.Service{Sidecars: []api.Sidecar{{Name: "logs", Image: "busybox", DockerRunArgs: runArgs}}}
+		if err := validateSidecars(sidecar); err == nil {
+			t.Errorf("Expected %q refused on a sidecar", runArgs)
+		}
+		initContainer := api.Service{InitContainers: []api.InitContainer{{Name: "migrate", Image: "busybox", DockerRunArgs: runArgs}}}
+		if err := validateInitContainers(initContainer); err == nil {
+			t.Errorf("Expected %q refused on an init container", runArgs)
+		}
+	}
+	if err := validateContainerArgs(api.Service{ServiceType: "docker", DockerImage: "nginx:1.25", DockerRunArgs: "--volume-driver local"}); err != nil {
+		t.Errorf("Expected flags that mount nothing to be allowed, got %v", err)
+	}
+	t.Logf("✓ Mount flags refused in every docker_run_args")
+}
@@ -0,0 +1,246 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/buildvigil/agent/internal/api"
+	"github.com/buildvigil/agent/internal/state"
+)
+
+// ServiceTypeWorker is a long-running service that listens on no port, such
+// as a queue consumer. Workers get no host port, proxy route or DNS entry.
+const ServiceTypeWorker = "worker"
+
+// workerStartGrace is how long a new worker container must stay up before
+// it counts as started, so one that crashes on boot fails the deploy.
+var workerStartGrace = 5 * time.Second
+
+// IsWorker reports whether a service is a worker.
+func IsWorker(service api.Service) bool {
+	return strings.EqualFold(strings.TrimSpace(service.ServiceType), ServiceTypeWorker)
+}
+
+// deployWorker starts the new worker container next to the current one and
+// stops the old container once the new one is healthy. The two overlap
+// briefly, which queue consumers tolerate.
+func (m *Manager) deployWorker(service api.Service, currentInfo *containerInfo, containerName, imageTag string) error {
+	start := time.Now()
+	log.Printf("[ServiceManager] Worker deploy begin: service=%s", service.ID)
+	imageRef, err := m.resolveDeployImage(service, imageTag)
+	if err != nil {
+		m.reportLifecycle(service, "error", "unknown", err.Error())
+		return fmt.Errorf("failed to prepare image: %w", err)
+	}
+	if err := m.checkDeployDeadline(service); err != nil {
+		return err
+	}
+	if err := m.checkDependencies(service); err != nil {
+		return err
+	}
+	if err := m.runInitContainers(service); err != nil {
+		return err
+	}
+
+	env := m.prepareEnvironment(service)
+	runArgs, err := m.serviceRunArgs(service)
+	if err != nil {
+		m.reportLifecycle(service, "error", "unknown", err.Error())
+		return err
+	}
+	newContainerName := containerName
+	if currentInfo != nil {
+		newContainerName = containerName + "-green"
+	}
+	containerID, err := m.startContainer(newContainerName, imageRef, 0, 0, env, runArgs, containerCommandForService(service))
+	if err != nil {
+		m.reportLifecycle(service, "error", "unknown", err.Error())
+		return fmt.Errorf("failed to start container: %w", err)
+	}
+	log.Printf("[ServiceManager] Worker container started: service=%s container=%s id=%s", service.ID, newContainerName, containerID)
+	m.logDeploy(service.ID, containerID, "info", "Container %s started", newContainerName)
+
+	discard := func() {
+		_ = m.stopContainer(service, newContainerName)
+		_ = DisconnectContainerFromStackNetwork(containerID, service.ID)
+	}
+	if err := ConnectContainerToStackNetwork(containerID, service.ID); err != nil {
+		discard()
+		m.reportLifecycle(service, "error", "unknown", err.Error())
+		return fmt.Errorf("failed to connect container to stack network: %w", err)
+	}
+	if err := m.startSidecars(service, newContainerName, containerID); err != nil {
+		discard()
+		m.reportLifecycle(service, "error", "unknown", err.Error())
+		return err
+	}
+
+	m.reportLifecycle(service, "health_check", "unknown", "")
+	if err := m.workerHealthCheck(service, newContainerName); err != nil {
+		m.captureFailedContainer(newContainerName)
+		m.captureContainerLogs(service.ID, m.deployID, containerID, newContainerName)
+		discard()
+		m.reportLifecycle(service, "error", "unhealthy", err.Error())
+		return fmt.Errorf("health check failed: %w", err)
+	}
+	if err := m.runPlugins(HookPreCutover, service, imageRef, newContainerName, 0); err != nil {
+		discard()
+		m.reportLifecycle(service, "error", "unknown", err.Error())
+		return err
+	}
+	if err := m.checkDeployDeadline(service); err != nil {
+		discard()
+		return err
+	}
+
+	activeContainerName := newContainerName
+	if currentInfo != nil {
+		m.captureContainerLogs(service.ID, currentInfo.deployID, currentInfo.containerID, currentInfo.containerName)
+		if err := m.stopContainer(currentInfo.service, currentInfo.containerName); err != nil {
+			m.logVerbose("Failed to stop previous worker container: %v", err)
+		}
+		_ = DisconnectContainerFromStackNetwork(currentInfo.containerName, service.ID)
+		// Drop ports left over from before the service became a worker.
+		m.portMgr.Release(service.ID)
+		if err := renameContainer(newContainerName, containerName); err != nil {
+			m.logVerbose("Failed to rename worker container %s to %s: %v", newContainerName, containerName, err)
+		} else {
+			activeContainerName = containerName
+			m.renameSidecars(service, newContainerName, containerName)
+		}
+	}
+
+	m.setContainer(service.ID, &containerInfo{
+		service:       service,
+		containerName: activeContainerName,
+		imageTag:      imageRef,
+		containerID:   containerID,
+		deployID:      m.deployID,
+	})
+	m.saveWorkerProcess(service, activeContainerName, containerID, imageRef, m.deployID, service.GitCommit)
+	m.reportLifecycle(service, "running", runningHealthStatus(service), "")
+
+	log.Printf("[ServiceManager] Worker deploy complete: service=%s container=%s elapsed=%s", service.ID, activeContainerName, time.Since(start))
+	return nil
+}
+
+// workerHealthCheck waits out the start grace period, then requires the
+// container to be running and, when set, health_check_command to succeed.
+func (m *Manager) workerHealthCheck(service api.Service, containerName string) error {
+	m.sleepWithinDeadline(workerStartGrace)
+	status, err := getContainerStatus(containerName)
+	if err != nil {
+		return fmt.Errorf("failed to read container status: %w", err)
+	}
+	m.traceHealthCheck("container=%s status=%s", containerName, status)
+	if status != "running" {
+		return fmt.Errorf("container is not running (status: %s)", status)
+	}
+	if err := m.execHealthCheck(service, containerName, service.HealthCheckCommand); err != nil {
+		return err
+	}
+	return m.checkSidecars(service, containerName)
+}
+
+// execHealthCheck runs command inside the container until it exits zero,
+// giving up after HealthCheckTimeout or at the deploy deadline. An empty
+// command passes.
+func (m *Manager) execHealthCheck(service api.Service, containerName, command string) error {
+	command = strings.TrimSpace(command)
+	if command == "" {
+		return nil
+	}
+	interval := HealthCheckInterval
+	if service.HealthCheckInterval > 0 {
+		interval = time.Duration(service.HealthCheckInterval) * time.Second
+	}
+	deadline := time.Now().Add(HealthCheckTimeout)
+	deployLimited := !m.deployDeadline.IsZero() && m.deployDeadline.Before(deadline)
+	if deployLimited {
+		deadline = m.deployDeadline
+	}
+	for attempts := 1; ; attempts++ {
+		err := execInContainer(containerName, command)
+		m.traceHealthCheck("attempt=%d container=%s command=%q err=%v", attempts, containerName, command, err)
+		if err == nil {
+			log.Printf("[ServiceManager] Health check success: service=%s container=%s attempts=%d", service.ID, containerName, attempts)
+			return nil
+		}
+		log.Printf("[ServiceManager] Health check attempt failed: service=%s container=%s attempt=%d err=%v", service.ID, containerName, attempts, err)
+		if time.Now().After(deadline) {
+			if deployLimited {
+				return fmt.Errorf("%w: health check command still failing after %d attempts", ErrDeployTimeout, attempts)
+			}
+			return fmt.Errorf("health check command still failing after %d attempts: %w", attempts, err)
+		}
+		m.sleepWithinDeadline(interval)
+	}
+}
+
+// recoverWorker restores tracking for a running worker container. The caller
+// holds m.mu.
+func (m *Manager) recoverWorker(service api.Service) (bool, error) {
+	if _, ok := m.containers[service.ID]; ok {
+		return true, nil
+	}
+	proc, err := m.state.GetServiceProcess(service.ID)
+	if err != nil {
+		return false, err
+	}
+	containerName := fmt.Sprintf("%s-%s", ContainerPrefix, service.ID)
+	imageTag := fmt.Sprintf("%s-%s:latest", ImagePrefix, service.ID)
+	gitCommit := service.GitCommit
+	containerID, deployID := "", ""
+	if proc != nil {
+		if proc.ContainerName != "" {
+			containerName = proc.ContainerName
+		}
+		if proc.ImageTag != "" {
+			imageTag = proc.ImageTag
+		}
+		if strings.TrimSpace(gitCommit) == "" {
+			gitCommit = proc.GitCommit
+		}
+		containerID, deployID = proc.ContainerID, proc.DeployID
+	}
+
+	status, err := getContainerStatus(containerName)
+	if err != nil {
+		return false, err
+	}
+	if status != "running" {
+		return false, nil
+	}
+	m.reconcileRestartPolicy(service, containerName)
+
+	m.setContainer(service.ID, &containerInfo{
+		service:       service,
+		containerName: containerName,
+		imageTag:      imageTag,
+		containerID:   containerID,
+		deployID:      deployID,
+	})
+	m.saveWorkerProcess(service, containerName, containerID, imageTag, deployID, gitCommit)
+	return true, nil
+}
+
+func (m *Manager) saveWorkerProcess(service api.Service, containerName, containerID, imageTag, deployID, gitCommit string) {
+	if err := m.state.SaveServiceProcess(&state.ServiceProcess{
+		ServiceID:     service.ID,
+		ServiceName:   service.Name,
+		GitCommit:     gitCommit,
+		Runtime:       "docker",
+		ContainerID:   containerID,
+		ContainerName: containerName,
+		ImageTag:      imageTag,
+		BaseImage:     service.BaseImage,
+		Language:      service.Language,
+		DeployID:      deployID,
+		Status:        "running",
+		StartedAt:     time.Now().UTC(),
+	}); err != nil {
+		m.logVerbose("Failed to persist worker state for %s: %v", service.ID, err)
+	}
+}
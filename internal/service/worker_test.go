@@ -0,0 +1,87 @@
+package service
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/buildvigil/agent/internal/api"
+	"github.com/buildvigil/agent/internal/state"
+)
+
+func stubWorkerDocker(t *testing.T, status string, execErrs ...error) *int {
+	t.Helper()
+	originalStatus, originalExec, originalGrace := getContainerStatus, execInContainer, workerStartGrace
+	t.Cleanup(func() {
+		getContainerStatus, execInContainer, workerStartGrace = originalStatus, originalExec, originalGrace
+	})
+	workerStartGrace = 0
+	getContainerStatus = func(string) (string, error) { return status, nil }
+	calls := 0
+	execInContainer = func(containerName, command string) error {
+		calls++
+		if calls <= len(execErrs) {
+			return execErrs[calls-1]
+		}
+		return nil
+	}
+	return &calls
+}
+
+func TestWorkerHealthCheck(t *testing.T) {
+	t.Logf("Testing worker liveness and exec health checks...")
+	m := NewManager(t.TempDir(), nil, nil, 3000, 3010, false)
+
+	stubWorkerDocker(t, "exited")
+	if err := m.workerHealthCheck(api.Service{ID: "w", ServiceType: "worker"}, "potato-cloud-w"); err == nil {
+		t.Error("Expected an exited worker to fail its health check")
+	}
+
+	calls := stubWorkerDocker(t, "running")
+	if err := m.workerHealthCheck(api.Service{ID: "w", ServiceType: "worker"}, "potato-cloud-w"); err != nil {
+		t.Errorf("Expected a running worker to pass, got %v", err)
+	}
+	if *calls != 0 {
+		t.Errorf("Expected no exec check without health_check_command, got %d", *calls)
+	}
+
+	calls = stubWorkerDocker(t, "running", errors.New("not ready"))
+	service := api.Service{ID: "w", ServiceType: "worker", HealthCheckCommand: "test -f /tmp/ready", HealthCheckInterval: 1}
+	if err := m.workerHealthCheck(service, "potato-cloud-w"); err != nil {
+		t.Errorf("Expected exec check to pass on retry, got %v", err)
+	}
+	if *calls != 2 {
+		t.Errorf("Expected 2 exec attempts, got %d", *calls)
+	}
+	t.Logf("✓ Worker health checks correct")
+}
+
+func TestRecoverService_Worker(t *testing.T) {
+	t.Logf("Testing worker recovery without a port...")
+	stateMgr, err := state.NewManager(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to create state manager: %v", err)
+	}
+	defer stateMgr.Close()
+	stubWorkerDocker(t, "running")
+
+	m := NewManager(t.TempDir(), stateMgr, nil, 3000, 3010, false)
+	service := api.Service{ID: "consumer", Name: "consumer", ServiceType: "worker", Hostname: "ignored.example.com"}
+	port, recovered, err := m.RecoverService(service)
+	if err != nil || !recovered || port != 0 {
+		t.Fatalf("Expected worker recovered without port, got port=%d recovered=%t err=%v", port, recovered, err)
+	}
+	if port, exists := m.GetServicePort("consumer"); !exists || port != 0 {
+		t.Errorf("Expected tracked worker with no port, got port=%d exists=%t", port, exists)
+	}
+	if _, ok := m.portMgr.Get("consumer"); ok {
+		t.Error("Expected no ports reserved for a worker")
+	}
+	running := m.RunningServices()
+	if len(running) != 1 || running[0].Hostname != "" {
+		t.Errorf("Expected worker listed without hostname, got %+v", running)
+	}
+	if up, err := m.ProbeService("consumer"); err != nil || !up {
+		t.Errorf("Expected running worker to probe up, got up=%t err=%v", up, err)
+	}
+	t.Logf("✓ Worker recovered and tracked without port")
+}
@@ -0,0 +1,114 @@
+package state
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// BuildLogRetainPerService is how many build logs are kept per service.
+const BuildLogRetainPerService = 10
+
+// BuildLog is the output of one image build, stored under its deploy ID.
+type BuildLog struct {
+	ServiceID  string
+	DeployID   string
+	GitCommit  string
+	Status     string // "succeeded", "failed" or "timed_out"
+	Builder    string
+	Output     string
+	Truncated  bool // Output lost its earliest lines
+	StartedAt  time.Time
+	FinishedAt time.Time
+	UploadedAt time.Time // Zero until uploaded to the control plane
+}
+
+const buildLogColumns = "service_id, deploy_id, git_commit, status, builder, output, truncated, started_at, finished_at, uploaded_at"
+
+// SaveBuildLog stores a build's output, replacing an earlier build of the
+// same deploy, and prunes the service's build logs to
+// BuildLogRetainPerService.
+func (m *Manager) SaveBuildLog(buildLog *BuildLog) error {
+	_, err := m.db.Exec(`
+		INSERT INTO build_logs (`+buildLogColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, 0)
+		ON CONFLICT(service_id, deploy_id) DO UPDATE SET
+			git_commit = excluded.git_commit, status = excluded.status, builder = excluded.builder,
+			output = excluded.output, truncated = excluded.truncated, started_at = excluded.started_at,
+			finished_at = excluded.finished_at, uploaded_at = 0
+	`, buildLog.ServiceID, buildLog.DeployID, buildLog.GitCommit, buildLog.Status, buildLog.Builder,
+		buildLog.Output, buildLog.Truncated, buildLog.StartedAt.Unix(), buildLog.FinishedAt.Unix())
+	if err != nil {
+		return fmt.Errorf("failed to save build log: %w", err)
+	}
+	_, err = m.db.Exec(`
+		DELETE FROM build_logs WHERE service_id = ? AND deploy_id NOT IN (
+			SELECT deploy_id FROM build_logs WHERE service_id = ? ORDER BY started_at DESC, rowid DESC LIMIT ?
+		)
+	`, buildLog.ServiceID, buildLog.ServiceID, BuildLogRetainPerService)
+	if err != nil {
+		return fmt.Errorf("failed to prune build logs: %w", err)
+	}
+	return nil
+}
+
+// GetBuildLog returns the build log of a deploy, or of the service's latest
+// build when deployID is empty. It returns nil if there is none.
+func (m *Manager) GetBuildLog(serviceID, deployID string) (*BuildLog, error) {
+	query := "SELECT " + buildLogColumns + " FROM build_logs WHERE service_id = ? AND deploy_id = ?"
+	args := []interface{}{serviceID, deployID}
+	if deployID == "" {
+		query = "SELECT " + buildLogColumns + " FROM build_logs WHERE service_id = ? ORDER BY started_at DESC, rowid DESC LIMIT 1"
+		args = args[:1]
+	}
+	buildLog, err := scanBuildLog(m.db.QueryRow(query, args...))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get build log: %w", err)
+	}
+	return buildLog, nil
+}
+
+// PendingBuildLogs returns build logs not yet uploaded, oldest first.
+func (m *Manager) PendingBuildLogs() ([]*BuildLog, error) {
+	rows, err := m.db.Query("SELECT " + buildLogColumns + " FROM build_logs WHERE uploaded_at = 0 ORDER BY started_at, rowid")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pending build logs: %w", err)
+	}
+	defer rows.Close()
+
+	var logs []*BuildLog
+	for rows.Next() {
+		buildLog, err := scanBuildLog(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan build log: %w", err)
+		}
+		logs = append(logs, buildLog)
+	}
+	return logs, rows.Err()
+}
+
+// MarkBuildLogUploaded records that a build log reached the control plane.
+func (m *Manager) MarkBuildLogUploaded(serviceID, deployID string) error {
+	if _, err := m.db.Exec("UPDATE build_logs SET uploaded_at = ? WHERE service_id = ? AND deploy_id = ?", time.Now().Unix(), serviceID, deployID); err != nil {
+		return fmt.Errorf("failed to mark build log uploaded: %w", err)
+	}
+	return nil
+}
+
+func scanBuildLog(row interface{ Scan(...interface{}) error }) (*BuildLog, error) {
+	var buildLog BuildLog
+	var startedAt, finishedAt, uploadedAt int64
+	if err := row.Scan(&buildLog.ServiceID, &buildLog.DeployID, &buildLog.GitCommit, &buildLog.Status, &buildLog.Builder,
+		&buildLog.Output, &buildLog.Truncated, &startedAt, &finishedAt, &uploadedAt); err != nil {
+		return nil, err
+	}
+	buildLog.StartedAt = time.Unix(startedAt, 0).UTC()
+	buildLog.FinishedAt = time.Unix(finishedAt, 0).UTC()
+	if uploadedAt > 0 {
+		buildLog.UploadedAt = time.Unix(uploadedAt, 0).UTC()
+	}
+	return &buildLog, nil
+}
@@ -0,0 +1,61 @@
+package state
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestBuildLogs(t *testing.T) {
+	t.Logf("Testing build logs stored per deploy")
+
+	mgr := setupTestDB(t)
+
+	if buildLog, err := mgr.GetBuildLog("web", ""); err != nil || buildLog != nil {
+		t.Fatalf("Expected no build log, got %+v (err=%v)", buildLog, err)
+	}
+	start := time.Now().Add(-time.Hour)
+	for i := 0; i < BuildLogRetainPerService+2; i++ {
+		buildLog := &BuildLog{
+			ServiceID:  "web",
+			DeployID:   fmt.Sprintf("deploy-%02d", i),
+			Status:     "succeeded",
+			Output:     fmt.Sprintf("step %d", i),
+			StartedAt:  start.Add(time.Duration(i) * time.Minute),
+			FinishedAt: start.Add(time.Duration(i)*time.Minute + time.Second),
+		}
+		if err := mgr.SaveBuildLog(buildLog); err != nil {
+			t.Fatalf("Failed to save build log: %v", err)
+		}
+	}
+	if err := mgr.SaveBuildLog(&BuildLog{ServiceID: "api", DeployID: "deploy-x", Status: "failed", Output: "error: no such file", Truncated: true, StartedAt: start, FinishedAt: start}); err != nil {
+		t.Fatalf("Failed to save build log: %v", err)
+	}
+
+	latest, err := mgr.GetBuildLog("web", "")
+	if err != nil || latest == nil || latest.DeployID != fmt.Sprintf("deploy-%02d", BuildLogRetainPerService+1) {
+		t.Fatalf("Expected the latest build, got %+v (err=%v)", latest, err)
+	}
+	if old, _ := mgr.GetBuildLog("web", "deploy-00"); old != nil {
+		t.Errorf("Expected the oldest build log to be pruned")
+	}
+	failed, _ := mgr.GetBuildLog("api", "deploy-x")
+	if failed == nil || failed.Status != "failed" || !failed.Truncated || failed.Output != "error: no such file" {
+		t.Errorf("Unexpected build log: %+v", failed)
+	}
+
+	pending, err := mgr.PendingBuildLogs()
+	if err != nil || len(pending) != BuildLogRetainPerService+1 {
+		t.Fatalf("Expected every kept build log pending, got %d (err=%v)", len(pending), err)
+	}
+	if err := mgr.MarkBuildLogUploaded("api", "deploy-x"); err != nil {
+		t.Fatalf("Failed to mark uploaded: %v", err)
+	}
+	if pending, _ := mgr.PendingBuildLogs(); len(pending) != BuildLogRetainPerService {
+		t.Errorf("Expected the uploaded log to leave the pending list, got %d", len(pending))
+	}
+	if uploaded, _ := mgr.GetBuildLog("api", "deploy-x"); uploaded.UploadedAt.IsZero() {
+		t.Errorf("Expected the upload time to be recorded")
+	}
+	t.Logf("✓ Build logs saved, pruned, looked up and marked uploaded")
+}
@@ -0,0 +1,54 @@
+package state
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// ServiceConfirmation is an operator's go-ahead to apply one pending revision
+// of a protected service.
+type ServiceConfirmation struct {
+	ServiceID string    `json:"service_id"`
+	Revision  string    `json:"revision"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ConfirmServiceRevision records that revision of a protected service may be
+// deployed, replacing any earlier confirmation.
+func (m *Manager) ConfirmServiceRevision(serviceID, revision string) error {
+	_, err := m.db.Exec(`
+		INSERT INTO service_confirmations (service_id, revision, created_at)
+		VALUES (?, ?, ?)
+		ON CONFLICT(service_id) DO UPDATE SET revision = excluded.revision, created_at = excluded.created_at
+	`, serviceID, revision, time.Now().Unix())
+	if err != nil {
+		return fmt.Errorf("failed to confirm service revision: %w", err)
+	}
+	return nil
+}
+
+// GetServiceConfirmation returns a service's confirmation, or nil if it has
+// none.
+func (m *Manager) GetServiceConfirmation(serviceID string) (*ServiceConfirmation, error) {
+	confirmation := ServiceConfirmation{ServiceID: serviceID}
+	var createdAt int64
+	err := m.db.QueryRow("SELECT revision, created_at FROM service_confirmations WHERE service_id = ?", serviceID).Scan(&confirmation.Revision, &createdAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get service confirmation: %w", err)
+	}
+	confirmation.CreatedAt = time.Unix(createdAt, 0).UTC()
+	return &confirmation, nil
+}
+
+// ClearServiceConfirmation removes a service's confirmation once it has been
+// used.
+func (m *Manager) ClearServiceConfirmation(serviceID string) error {
+	if _, err := m.db.Exec("DELETE FROM service_confirmations WHERE service_id = ?", serviceID); err != nil {
+		return fmt.Errorf("failed to clear service confirmation: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package state
+
+import "testing"
+
+func TestServiceConfirmations(t *testing.T) {
+	t.Logf("Testing service confirmations")
+
+	mgr := setupTestDB(t)
+
+	if c, err := mgr.GetServiceConfirmation("db"); err != nil || c != nil {
+		t.Fatalf("Expected no confirmation, got %+v (err=%v)", c, err)
+	}
+	if err := mgr.ConfirmServiceRevision("db", "def1:abc"); err != nil {
+		t.Fatalf("Failed to confirm revision: %v", err)
+	}
+	if err := mgr.ConfirmServiceRevision("db", "def2:abc"); err != nil {
+		t.Fatalf("Failed to replace confirmation: %v", err)
+	}
+	c, err := mgr.GetServiceConfirmation("db")
+	if err != nil || c == nil || c.Revision != "def2:abc" {
+		t.Fatalf("Expected latest revision confirmed, got %+v (err=%v)", c, err)
+	}
+
+	if err := mgr.ClearServiceConfirmation("db"); err != nil {
+		t.Fatalf("Failed to clear confirmation: %v", err)
+	}
+	if c, _ := mgr.GetServiceConfirmation("db"); c != nil {
+		t.Errorf("Expected confirmation to be cleared, got %+v", c)
+	}
+	t.Logf("✓ Confirmations saved, replaced and cleared")
+}
@@ -0,0 +1,36 @@
+package state
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// SaveDesiredState stores the last desired state document fetched for a
+// stack, replacing the previous one.
+func (m *Manager) SaveDesiredState(stackID string, document []byte) error {
+	_, err := m.db.Exec(`
+		INSERT INTO desired_state_cache (stack_id, document, fetched_at)
+		VALUES (?, ?, ?)
+		ON CONFLICT(stack_id) DO UPDATE SET document = excluded.document, fetched_at = excluded.fetched_at
+	`, stackID, document, time.Now().Unix())
+	if err != nil {
+		return fmt.Errorf("failed to save desired state: %w", err)
+	}
+	return nil
+}
+
+// LoadDesiredState returns the last desired state document saved for a
+// stack and when it was fetched, or nil if none was saved.
+func (m *Manager) LoadDesiredState(stackID string) ([]byte, time.Time, error) {
+	var document []byte
+	var fetchedAt int64
+	err := m.db.QueryRow("SELECT document, fetched_at FROM desired_state_cache WHERE stack_id = ?", stackID).Scan(&document, &fetchedAt)
+	if err == sql.ErrNoRows {
+		return nil, time.Time{}, nil
+	}
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to load desired state: %w", err)
+	}
+	return document, time.Unix(fetchedAt, 0).UTC(), nil
+}
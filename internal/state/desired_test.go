@@ -0,0 +1,27 @@
+package state
+
+import "testing"
+
+func TestDesiredStateCache(t *testing.T) {
+	t.Logf("Testing the cached desired state per stack")
+
+	mgr := setupTestDB(t)
+
+	if document, _, err := mgr.LoadDesiredState("stack-1"); err != nil || document != nil {
+		t.Fatalf("Expected no cached state, got %q (err=%v)", document, err)
+	}
+	if err := mgr.SaveDesiredState("stack-1", []byte(`{"version":1}`)); err != nil {
+		t.Fatalf("Failed to save state: %v", err)
+	}
+	if err := mgr.SaveDesiredState("stack-1", []byte(`{"version":2}`)); err != nil {
+		t.Fatalf("Failed to replace state: %v", err)
+	}
+	document, fetchedAt, err := mgr.LoadDesiredState("stack-1")
+	if err != nil || string(document) != `{"version":2}` || fetchedAt.IsZero() {
+		t.Fatalf("Expected the latest state, got %q at %v (err=%v)", document, fetchedAt, err)
+	}
+	if document, _, _ := mgr.LoadDesiredState("stack-2"); document != nil {
+		t.Errorf("Expected no cached state for another stack, got %q", document)
+	}
+	t.Logf("✓ Desired state cached and replaced per stack")
+}
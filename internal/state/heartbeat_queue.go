@@ -0,0 +1,60 @@
+package state
+
+import (
+	"fmt"
+	"time"
+)
+
+// QueuedHeartbeat is a heartbeat that could not be delivered, kept for
+// replay once the control plane is reachable.
+type QueuedHeartbeat struct {
+	ID       int64
+	Payload  []byte // The heartbeat as JSON
+	QueuedAt time.Time
+}
+
+// QueueHeartbeat stores an undelivered heartbeat, dropping the oldest ones
+// beyond limit.
+func (m *Manager) QueueHeartbeat(payload []byte, limit int) error {
+	if _, err := m.db.Exec("INSERT INTO heartbeat_queue (payload, queued_at) VALUES (?, ?)", payload, time.Now().Unix()); err != nil {
+		return fmt.Errorf("failed to queue heartbeat: %w", err)
+	}
+	_, err := m.db.Exec(`
+		DELETE FROM heartbeat_queue WHERE id NOT IN (
+			SELECT id FROM heartbeat_queue ORDER BY id DESC LIMIT ?
+		)
+	`, limit)
+	if err != nil {
+		return fmt.Errorf("failed to prune heartbeat queue: %w", err)
+	}
+	return nil
+}
+
+// QueuedHeartbeats returns up to limit queued heartbeats, oldest first.
+func (m *Manager) QueuedHeartbeats(limit int) ([]QueuedHeartbeat, error) {
+	rows, err := m.db.Query("SELECT id, payload, queued_at FROM heartbeat_queue ORDER BY id LIMIT ?", limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list queued heartbeats: %w", err)
+	}
+	defer rows.Close()
+
+	var queued []QueuedHeartbeat
+	for rows.Next() {
+		var q QueuedHeartbeat
+		var queuedAt int64
+		if err := rows.Scan(&q.ID, &q.Payload, &queuedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan queued heartbeat: %w", err)
+		}
+		q.QueuedAt = time.Unix(queuedAt, 0).UTC()
+		queued = append(queued, q)
+	}
+	return queued, rows.Err()
+}
+
+// DeleteQueuedHeartbeat removes a heartbeat once it has been replayed.
+func (m *Manager) DeleteQueuedHeartbeat(id int64) error {
+	if _, err := m.db.Exec("DELETE FROM heartbeat_queue WHERE id = ?", id); err != nil {
+		return fmt.Errorf("failed to delete queued heartbeat: %w", err)
+	}
+	return nil
+}
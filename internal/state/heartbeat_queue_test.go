@@ -0,0 +1,33 @@
+package state
+
+import "testing"
+
+func TestHeartbeatQueue(t *testing.T) {
+	t.Logf("Testing the offline heartbeat queue")
+
+	mgr := setupTestDB(t)
+
+	for _, payload := range []string{`{"n":1}`, `{"n":2}`, `{"n":3}`, `{"n":4}`} {
+		if err := mgr.QueueHeartbeat([]byte(payload), 3); err != nil {
+			t.Fatalf("Failed to queue heartbeat: %v", err)
+		}
+	}
+	queued, err := mgr.QueuedHeartbeats(10)
+	if err != nil {
+		t.Fatalf("Failed to list queue: %v", err)
+	}
+	if len(queued) != 3 || string(queued[0].Payload) != `{"n":2}` || string(queued[2].Payload) != `{"n":4}` {
+		t.Fatalf("Expected the newest three heartbeats oldest first, got %+v", queued)
+	}
+	if queued[0].QueuedAt.IsZero() {
+		t.Error("Expected a queue time")
+	}
+
+	if err := mgr.DeleteQueuedHeartbeat(queued[0].ID); err != nil {
+		t.Fatalf("Failed to delete heartbeat: %v", err)
+	}
+	if queued, _ := mgr.QueuedHeartbeats(1); len(queued) != 1 || string(queued[0].Payload) != `{"n":3}` {
+		t.Errorf("Expected the next oldest heartbeat, got %+v", queued)
+	}
+	t.Logf("✓ Heartbeats queued, capped and removed in order")
+}
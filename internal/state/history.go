@@ -0,0 +1,120 @@
+package state
+
+import (
+	"bytes"
+	"compress/gzip"
+	"database/sql"
+	"fmt"
+	"io"
+	"time"
+)
+
+// MaxStateHistory is how many applied desired states are kept.
+const MaxStateHistory = 100
+
+// StateSnapshot is a desired state document as the agent applied it.
+type StateSnapshot struct {
+	Version   int       `json:"version"`
+	Hash      string    `json:"hash"`
+	Partial   bool      `json:"partial"`
+	AppliedAt time.Time `json:"applied_at"`
+	Document  []byte    `json:"-"` // JSON, only loaded by GetStateSnapshot and StateSnapshotAt
+}
+
+// RecordStateSnapshot stores a desired state document when it differs from
+// the one applied last, pruning the history to MaxStateHistory entries.
+func (m *Manager) RecordStateSnapshot(version int, hash string, partial bool, document []byte) error {
+	var lastHash string
+	var lastPartial bool
+	err := m.db.QueryRow("SELECT hash, partial FROM state_history ORDER BY id DESC LIMIT 1").Scan(&lastHash, &lastPartial)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("failed to get last applied state: %w", err)
+	}
+	if err == nil && lastHash == hash && lastPartial == partial {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write(document); err != nil {
+		return fmt.Errorf("failed to compress applied state: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("failed to compress applied state: %w", err)
+	}
+	if _, err := m.db.Exec(
+		"INSERT INTO state_history (version, hash, partial, applied_at, document) VALUES (?, ?, ?, ?, ?)",
+		version, hash, partial, time.Now().Unix(), buf.Bytes(),
+	); err != nil {
+		return fmt.Errorf("failed to record applied state: %w", err)
+	}
+	if _, err := m.db.Exec(
+		"DELETE FROM state_history WHERE id NOT IN (SELECT id FROM state_history ORDER BY id DESC LIMIT ?)",
+		MaxStateHistory,
+	); err != nil {
+		return fmt.Errorf("failed to prune state history: %w", err)
+	}
+	return nil
+}
+
+// ListStateSnapshots returns up to limit applied states, newest first,
+// without their documents. A limit of 0 returns the whole history.
+func (m *Manager) ListStateSnapshots(limit int) ([]StateSnapshot, error) {
+	if limit <= 0 {
+		limit = MaxStateHistory
+	}
+	rows, err := m.db.Query("SELECT version, hash, partial, applied_at FROM state_history ORDER BY id DESC LIMIT ?", limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list applied states: %w", err)
+	}
+	defer rows.Close()
+
+	var states []StateSnapshot
+	for rows.Next() {
+		var applied StateSnapshot
+		var appliedAt int64
+		if err := rows.Scan(&applied.Version, &applied.Hash, &applied.Partial, &appliedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan applied state: %w", err)
+		}
+		applied.AppliedAt = time.Unix(appliedAt, 0).UTC()
+		states = append(states, applied)
+	}
+	return states, rows.Err()
+}
+
+// GetStateSnapshot returns the latest snapshot of the given version,
+// or nil if it is not in the history.
+func (m *Manager) GetStateSnapshot(version int) (*StateSnapshot, error) {
+	return m.queryStateSnapshot("WHERE version = ? ORDER BY id DESC LIMIT 1", version)
+}
+
+// StateSnapshotAt returns the state that was active at t: the last one
+// applied at or before it, or nil if the history starts later.
+func (m *Manager) StateSnapshotAt(t time.Time) (*StateSnapshot, error) {
+	return m.queryStateSnapshot("WHERE applied_at <= ? ORDER BY id DESC LIMIT 1", t.Unix())
+}
+
+func (m *Manager) queryStateSnapshot(where string, arg interface{}) (*StateSnapshot, error) {
+	var applied StateSnapshot
+	var appliedAt int64
+	var compressed []byte
+	err := m.db.QueryRow("SELECT version, hash, partial, applied_at, document FROM state_history "+where, arg).
+		Scan(&applied.Version, &applied.Hash, &applied.Partial, &appliedAt, &compressed)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get applied state: %w", err)
+	}
+	applied.AppliedAt = time.Unix(appliedAt, 0).UTC()
+
+	gz, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress applied state: %w", err)
+	}
+	defer gz.Close()
+	if applied.Document, err = io.ReadAll(gz); err != nil {
+		return nil, fmt.Errorf("failed to decompress applied state: %w", err)
+	}
+	return &applied, nil
+}
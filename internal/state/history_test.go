@@ -0,0 +1,56 @@
+package state
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestStateHistory(t *testing.T) {
+	t.Logf("Testing the applied state history")
+
+	mgr := setupTestDB(t)
+
+	if applied, err := mgr.GetStateSnapshot(1); err != nil || applied != nil {
+		t.Fatalf("Expected an empty history, got %+v (err=%v)", applied, err)
+	}
+	if err := mgr.RecordStateSnapshot(1, "h1", false, []byte(`{"version":1}`)); err != nil {
+		t.Fatalf("Failed to record state: %v", err)
+	}
+	// The same state applied again is not recorded twice.
+	if err := mgr.RecordStateSnapshot(1, "h1", false, []byte(`{"version":1}`)); err != nil {
+		t.Fatalf("Failed to record state: %v", err)
+	}
+	if err := mgr.RecordStateSnapshot(2, "h2", true, []byte(`{"version":2}`)); err != nil {
+		t.Fatalf("Failed to record state: %v", err)
+	}
+
+	states, err := mgr.ListStateSnapshots(0)
+	if err != nil || len(states) != 2 {
+		t.Fatalf("Expected two applied states, got %+v (err=%v)", states, err)
+	}
+	if states[0].Version != 2 || !states[0].Partial || states[0].Document != nil {
+		t.Errorf("Expected the partial version 2 first without its document, got %+v", states[0])
+	}
+
+	applied, err := mgr.GetStateSnapshot(1)
+	if err != nil || applied == nil || string(applied.Document) != `{"version":1}` {
+		t.Fatalf("Expected version 1 with its document, got %+v (err=%v)", applied, err)
+	}
+	if applied, _ := mgr.StateSnapshotAt(time.Now()); applied == nil || applied.Version != 2 {
+		t.Errorf("Expected version 2 active now, got %+v", applied)
+	}
+	if applied, _ := mgr.StateSnapshotAt(time.Now().Add(-time.Hour)); applied != nil {
+		t.Errorf("Expected no state active an hour ago, got %+v", applied)
+	}
+
+	for i := 0; i < MaxStateHistory+5; i++ {
+		if err := mgr.RecordStateSnapshot(10+i, fmt.Sprintf("h%d", 10+i), false, []byte("{}")); err != nil {
+			t.Fatalf("Failed to record state: %v", err)
+		}
+	}
+	if states, _ := mgr.ListStateSnapshots(0); len(states) != MaxStateHistory {
+		t.Errorf("Expected the history pruned to %d states, got %d", MaxStateHistory, len(states))
+	}
+	t.Logf("✓ Applied states recorded, looked up and pruned")
+}
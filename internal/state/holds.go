@@ -0,0 +1,74 @@
+package state
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// ServiceHold pauses the agent's reconciliation of a service, e.g. while an
+// operator works on its container by hand.
+type ServiceHold struct {
+	ServiceID string    `json:"service_id"`
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// SetServiceHold places a service on hold, replacing any existing hold.
+func (m *Manager) SetServiceHold(serviceID, reason string) error {
+	_, err := m.db.Exec(`
+		INSERT INTO service_holds (service_id, reason, created_at)
+		VALUES (?, ?, ?)
+		ON CONFLICT(service_id) DO UPDATE SET reason = excluded.reason, created_at = excluded.created_at
+	`, serviceID, reason, time.Now().Unix())
+	if err != nil {
+		return fmt.Errorf("failed to set service hold: %w", err)
+	}
+	return nil
+}
+
+// ClearServiceHold releases a service's hold. It reports whether one existed.
+func (m *Manager) ClearServiceHold(serviceID string) (bool, error) {
+	result, err := m.db.Exec("DELETE FROM service_holds WHERE service_id = ?", serviceID)
+	if err != nil {
+		return false, fmt.Errorf("failed to clear service hold: %w", err)
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
+
+// GetServiceHold returns a service's hold, or nil if it has none.
+func (m *Manager) GetServiceHold(serviceID string) (*ServiceHold, error) {
+	hold := ServiceHold{ServiceID: serviceID}
+	var createdAt int64
+	err := m.db.QueryRow("SELECT reason, created_at FROM service_holds WHERE service_id = ?", serviceID).Scan(&hold.Reason, &createdAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get service hold: %w", err)
+	}
+	hold.CreatedAt = time.Unix(createdAt, 0).UTC()
+	return &hold, nil
+}
+
+// ListServiceHolds returns all held services.
+func (m *Manager) ListServiceHolds() ([]ServiceHold, error) {
+	rows, err := m.db.Query("SELECT service_id, reason, created_at FROM service_holds ORDER BY service_id")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list service holds: %w", err)
+	}
+	defer rows.Close()
+
+	var holds []ServiceHold
+	for rows.Next() {
+		var hold ServiceHold
+		var createdAt int64
+		if err := rows.Scan(&hold.ServiceID, &hold.Reason, &createdAt); err != nil {
+			return nil, fmt.Errorf("failed to scan service hold: %w", err)
+		}
+		hold.CreatedAt = time.Unix(createdAt, 0).UTC()
+		holds = append(holds, hold)
+	}
+	return holds, rows.Err()
+}
@@ -0,0 +1,34 @@
+package state
+
+import "testing"
+
+func TestServiceHolds(t *testing.T) {
+	t.Logf("Testing service holds")
+
+	mgr := setupTestDB(t)
+
+	if hold, err := mgr.GetServiceHold("web"); err != nil || hold != nil {
+		t.Fatalf("Expected no hold, got %+v (err=%v)", hold, err)
+	}
+	if err := mgr.SetServiceHold("web", "debugging"); err != nil {
+		t.Fatalf("Failed to set hold: %v", err)
+	}
+	if err := mgr.SetServiceHold("web", "debugging memory leak"); err != nil {
+		t.Fatalf("Failed to replace hold: %v", err)
+	}
+	hold, err := mgr.GetServiceHold("web")
+	if err != nil || hold == nil || hold.Reason != "debugging memory leak" || hold.CreatedAt.IsZero() {
+		t.Fatalf("Unexpected hold %+v (err=%v)", hold, err)
+	}
+	if holds, _ := mgr.ListServiceHolds(); len(holds) != 1 || holds[0].ServiceID != "web" {
+		t.Errorf("Expected one hold, got %+v", holds)
+	}
+
+	if released, err := mgr.ClearServiceHold("web"); err != nil || !released {
+		t.Fatalf("Expected hold to be released, got %v (err=%v)", released, err)
+	}
+	if released, _ := mgr.ClearServiceHold("web"); released {
+		t.Error("Expected second release to report no hold")
+	}
+	t.Logf("✓ Service holds set, listed and released")
+}
@@ -0,0 +1,130 @@
+package state
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// Service log lines are buffered and written in one transaction once
+// logBatchSize lines are waiting or logFlushInterval after the first, so
+// chatty services don't cost an autocommit per line. Reads of service_logs
+// flush first, and Close flushes what is left.
+const logBatchSize = 500
+
+// maxBufferedLogs caps the lines kept for retry while writes fail; the
+// oldest are dropped first.
+const maxBufferedLogs = 20 * logBatchSize
+
+var logFlushInterval = time.Second
+
+// bufferedLog is a service log line waiting to be written.
+type bufferedLog struct {
+	serviceID   string
+	deployID    string
+	containerID string
+	level       string
+	message     string
+	createdAt   string
+}
+
+// bufferLog queues a log line, flushing when the batch is full.
+func (m *Manager) bufferLog(entry bufferedLog) error {
+	m.logMu.Lock()
+	m.logBuffer = append(m.logBuffer, entry)
+	full := len(m.logBuffer) >= logBatchSize
+	if !full {
+		m.scheduleLogFlush()
+	}
+	m.logMu.Unlock()
+	if full {
+		return m.FlushLogs()
+	}
+	return nil
+}
+
+// FlushLogs writes buffered service log lines in one transaction. A flush
+// already in progress finishes first, so lines logged before a call are
+// readable once it returns. Lines a failed flush couldn't write go back in
+// the buffer for the next one.
+func (m *Manager) FlushLogs() error {
+	m.flushMu.Lock()
+	defer m.flushMu.Unlock()
+
+	m.logMu.Lock()
+	entries := m.logBuffer
+	m.logBuffer = nil
+	if m.logTimer != nil {
+		m.logTimer.Stop()
+		m.logTimer = nil
+	}
+	m.logMu.Unlock()
+	if len(entries) == 0 {
+		return nil
+	}
+	if err := m.writeLogs(entries); err != nil {
+		m.requeueLogs(entries)
+		return err
+	}
+	return nil
+}
+
+// writeLogs inserts log lines in one transaction.
+func (m *Manager) writeLogs(entries []bufferedLog) error {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin log batch: %w", err)
+	}
+	stmt, err := tx.Prepare(`
+		INSERT INTO service_logs (service_id, deploy_id, container_id, level, message, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to prepare log batch: %w", err)
+	}
+	defer stmt.Close()
+	for _, entry := range entries {
+		if _, err := stmt.Exec(entry.serviceID, entry.deployID, entry.containerID, entry.level, entry.message, entry.createdAt); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to log message: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit log batch: %w", err)
+	}
+	return nil
+}
+
+// requeueLogs puts lines back ahead of any logged since, and schedules a
+// retry.
+func (m *Manager) requeueLogs(entries []bufferedLog) {
+	m.logMu.Lock()
+	defer m.logMu.Unlock()
+	m.logBuffer = append(entries, m.logBuffer...)
+	if excess := len(m.logBuffer) - maxBufferedLogs; excess > 0 {
+		log.Printf("[State] Dropping %d unwritten service log lines", excess)
+		m.logBuffer = m.logBuffer[excess:]
+	}
+	m.scheduleLogFlush()
+}
+
+// scheduleLogFlush starts the flush timer unless it is running or the
+// manager is closing. Callers hold logMu.
+func (m *Manager) scheduleLogFlush() {
+	if m.logTimer == nil && !m.logClosed {
+		m.logTimer = time.AfterFunc(logFlushInterval, func() {
+			if err := m.FlushLogs(); err != nil {
+				log.Printf("[State] Failed to flush service logs: %v", err)
+			}
+		})
+	}
+}
+
+// flushLogsForRead flushes buffered log lines before a read of service_logs.
+// A failed flush is logged; the read still sees what was written.
+func (m *Manager) flushLogsForRead() {
+	if err := m.FlushLogs(); err != nil {
+		log.Printf("[State] Failed to flush service logs: %v", err)
+	}
+}
@@ -0,0 +1,87 @@
+package state
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestServiceLogBatching(t *testing.T) {
+	t.Logf("Testing service logs are written in batches and flushed on close")
+
+	original := logFlushInterval
+	defer func() { logFlushInterval = original }()
+	logFlushInterval = 50 * time.Millisecond
+
+	path := filepath.Join(t.TempDir(), "state.db")
+	mgr, err := NewManager(path)
+	if err != nil {
+		t.Fatalf("Failed to create state manager: %v", err)
+	}
+	written := func() int {
+		t.Helper()
+		var count int
+		if err := mgr.db.QueryRow("SELECT COUNT(*) FROM service_logs").Scan(&count); err != nil {
+			t.Fatalf("Failed to count logs: %v", err)
+		}
+		return count
+	}
+
+	for i := 0; i < logBatchSize-1; i++ {
+		if err := mgr.LogServiceMessage("svc-1", "info", "line"); err != nil {
+			t.Fatalf("Failed to log message: %v", err)
+		}
+	}
+	if n := written(); n != 0 {
+		t.Fatalf("Expected lines buffered below the batch size, got %d written", n)
+	}
+	mgr.LogServiceMessage("svc-1", "info", "line")
+	if n := written(); n != logBatchSize {
+		t.Fatalf("Expected a full batch written at once, got %d", n)
+	}
+
+	mgr.LogServiceMessage("svc-1", "info", "timed")
+	deadline := time.Now().Add(2 * time.Second)
+	for written() == logBatchSize && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := written(); n != logBatchSize+1 {
+		t.Fatalf("Expected a partial batch written after the flush interval, got %d", n)
+	}
+
+	logFlushInterval = time.Hour
+	mgr.LogServiceMessage("svc-1", "warn", "read")
+	logs, err := mgr.QueryServiceLogs("svc-1", LogFilter{}, 1)
+	if err != nil || len(logs) != 1 || logs[0].Message != "read" || logs[0].CreatedAt.IsZero() {
+		t.Fatalf("Expected reads to see buffered lines, got %+v (err=%v)", logs, err)
+	}
+
+	if _, err := mgr.db.Exec("ALTER TABLE service_logs RENAME TO service_logs_moved"); err != nil {
+		t.Fatalf("Failed to move table: %v", err)
+	}
+	mgr.LogServiceMessage("svc-1", "info", "retried")
+	if err := mgr.FlushLogs(); err == nil {
+		t.Fatal("Expected the flush to fail without the table")
+	}
+	if _, err := mgr.db.Exec("ALTER TABLE service_logs_moved RENAME TO service_logs"); err != nil {
+		t.Fatalf("Failed to restore table: %v", err)
+	}
+	logs, err = mgr.QueryServiceLogs("svc-1", LogFilter{}, 1)
+	if err != nil || len(logs) != 1 || logs[0].Message != "retried" {
+		t.Fatalf("Expected the line kept after a failed flush, got %+v (err=%v)", logs, err)
+	}
+
+	mgr.LogServiceMessage("svc-1", "info", "last")
+	if err := mgr.Close(); err != nil {
+		t.Fatalf("Failed to close state manager: %v", err)
+	}
+	mgr, err = NewManager(path)
+	if err != nil {
+		t.Fatalf("Failed to reopen state manager: %v", err)
+	}
+	defer mgr.Close()
+	if n := written(); n != logBatchSize+4 {
+		t.Errorf("Expected buffered lines flushed on close, got %d", n)
+	}
+	t.Logf("✓ Logs batched by size and time, kept on failure, and flushed on read and close")
+}
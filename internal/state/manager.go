@@ -3,6 +3,8 @@ package state
 import (
 	"database/sql"
 	"fmt"
+	"log"
+	"sync"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
@@ -11,6 +13,15 @@ import (
 // Manager handles local state persistence
 type Manager struct {
 	db *sql.DB
+
+	// logBuffer holds service log lines until FlushLogs writes them;
+	// flushMu serializes flushes. Once logClosed is set no flush is
+	// scheduled.
+	logMu     sync.Mutex
+	logBuffer []bufferedLog
+	logTimer  *time.Timer
+	logClosed bool
+	flushMu   sync.Mutex
 }
 
 // NewManager creates a new state manager
@@ -27,8 +38,14 @@ func NewManager(dbPath string) (*Manager, error) {
 	return &Manager{db: db}, nil
 }
 
-// Close closes the database connection
+// Close flushes buffered service logs and closes the database connection
 func (m *Manager) Close() error {
+	m.logMu.Lock()
+	m.logClosed = true
+	m.logMu.Unlock()
+	if err := m.FlushLogs(); err != nil {
+		log.Printf("[State] Failed to flush service logs: %v", err)
+	}
 	return m.db.Close()
 }
 
@@ -68,11 +85,139 @@ func migrate(db *sql.DB) error {
 		service_id TEXT NOT NULL,
 		level TEXT NOT NULL,
 		message TEXT NOT NULL,
+		deploy_id TEXT NOT NULL DEFAULT '',
+		container_id TEXT NOT NULL DEFAULT '',
 		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
 	);
 
-	CREATE INDEX IF NOT EXISTS idx_service_logs_service_id ON service_logs(service_id);
+	CREATE INDEX IF NOT EXISTS idx_service_logs_service_seq ON service_logs(service_id, id);
 	CREATE INDEX IF NOT EXISTS idx_service_logs_created_at ON service_logs(created_at);
+
+	CREATE TABLE IF NOT EXISTS agent_events (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		service_id TEXT NOT NULL DEFAULT '',
+		event_type TEXT NOT NULL,
+		message TEXT NOT NULL DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_agent_events_created_at ON agent_events(created_at);
+
+	CREATE TABLE IF NOT EXISTS proxy_routes (
+		kind TEXT NOT NULL,
+		host TEXT NOT NULL,
+		port INTEGER NOT NULL,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (kind, host)
+	);
+
+	CREATE TABLE IF NOT EXISTS service_metrics (
+		service_id TEXT NOT NULL,
+		resolution INTEGER NOT NULL,
+		bucket_start INTEGER NOT NULL,
+		cpu_percent REAL NOT NULL DEFAULT 0,
+		memory_bytes INTEGER NOT NULL DEFAULT 0,
+		request_rate REAL NOT NULL DEFAULT 0,
+		samples INTEGER NOT NULL DEFAULT 1,
+		PRIMARY KEY (service_id, resolution, bucket_start)
+	);
+
+	CREATE TABLE IF NOT EXISTS service_availability (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		service_id TEXT NOT NULL,
+		healthy INTEGER NOT NULL,
+		started_at INTEGER NOT NULL,
+		ended_at INTEGER NOT NULL
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_service_availability_service ON service_availability(service_id, ended_at);
+
+	CREATE TABLE IF NOT EXISTS pending_actions (
+		service_id TEXT PRIMARY KEY,
+		action TEXT NOT NULL,
+		reason TEXT NOT NULL DEFAULT '',
+		revision TEXT NOT NULL DEFAULT '',
+		attempts INTEGER NOT NULL DEFAULT 0,
+		last_error TEXT NOT NULL DEFAULT '',
+		next_attempt_at INTEGER NOT NULL,
+		updated_at INTEGER NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS service_holds (
+		service_id TEXT PRIMARY KEY,
+		reason TEXT NOT NULL DEFAULT '',
+		created_at INTEGER NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS build_logs (
+		service_id TEXT NOT NULL,
+		deploy_id TEXT NOT NULL,
+		git_commit TEXT NOT NULL DEFAULT '',
+		status TEXT NOT NULL,
+		builder TEXT NOT NULL DEFAULT '',
+		output TEXT NOT NULL,
+		truncated INTEGER NOT NULL DEFAULT 0,
+		started_at INTEGER NOT NULL,
+		finished_at INTEGER NOT NULL,
+		uploaded_at INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (service_id, deploy_id)
+	);
+
+	CREATE TABLE IF NOT EXISTS desired_state_cache (
+		stack_id TEXT PRIMARY KEY,
+		document BLOB NOT NULL,
+		fetched_at INTEGER NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS state_history (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		version INTEGER NOT NULL,
+		hash TEXT NOT NULL,
+		partial INTEGER NOT NULL DEFAULT 0,
+		applied_at INTEGER NOT NULL,
+		document BLOB NOT NULL
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_state_history_applied ON state_history(applied_at);
+
+	CREATE TABLE IF NOT EXISTS service_confirmations (
+		service_id TEXT PRIMARY KEY,
+		revision TEXT NOT NULL,
+		created_at INTEGER NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS service_revisions (
+		service_id TEXT PRIMARY KEY,
+		stack_version INTEGER NOT NULL,
+		revision TEXT NOT NULL,
+		applied_at INTEGER NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS service_restarts (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		service_id TEXT NOT NULL,
+		container_name TEXT NOT NULL DEFAULT '',
+		exit_code INTEGER NOT NULL DEFAULT 0,
+		oom_killed INTEGER NOT NULL DEFAULT 0,
+		restarted_at INTEGER NOT NULL
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_service_restarts_service ON service_restarts(service_id, restarted_at);
+
+	CREATE TABLE IF NOT EXISTS service_rollbacks (
+		service_id TEXT PRIMARY KEY,
+		image_tag TEXT NOT NULL,
+		git_commit TEXT NOT NULL DEFAULT '',
+		port INTEGER NOT NULL DEFAULT 0,
+		deploy_id TEXT NOT NULL DEFAULT '',
+		recorded_at INTEGER NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS heartbeat_queue (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		payload BLOB NOT NULL,
+		queued_at INTEGER NOT NULL
+	);
 	`
 
 	if _, err := db.Exec(schema); err != nil {
@@ -82,26 +227,60 @@ func migrate(db *sql.DB) error {
 	if err := ensureServiceProcessColumns(db); err != nil {
 		return err
 	}
+	if err := ensureServiceLogColumns(db); err != nil {
+		return err
+	}
+	if err := ensureColumns(db, "pending_actions", map[string]string{"revision": "TEXT NOT NULL DEFAULT ''"}); err != nil {
+		return err
+	}
+	if err := ensureColumns(db, "service_restarts", map[string]string{"oom_killed": "INTEGER NOT NULL DEFAULT 0"}); err != nil {
+		return err
+	}
 
 	return nil
 }
 
 func ensureServiceProcessColumns(db *sql.DB) error {
-	columns := map[string]string{
-		"runtime":        "TEXT NOT NULL DEFAULT 'docker'",
-		"container_id":   "TEXT",
-		"container_name": "TEXT",
-		"image_tag":      "TEXT",
-		"port":           "INTEGER",
-		"green_port":     "INTEGER",
-		"active_port":    "INTEGER",
-		"base_image":     "TEXT",
-		"language":       "TEXT",
-	}
-
-	rows, err := db.Query("PRAGMA table_info(service_processes)")
+	return ensureColumns(db, "service_processes", map[string]string{
+		"runtime":         "TEXT NOT NULL DEFAULT 'docker'",
+		"container_id":    "TEXT",
+		"container_name":  "TEXT",
+		"image_tag":       "TEXT",
+		"port":            "INTEGER",
+		"green_port":      "INTEGER",
+		"active_port":     "INTEGER",
+		"base_image":      "TEXT",
+		"language":        "TEXT",
+		"deploy_id":       "TEXT",
+		"definition_hash": "TEXT",
+		"image_digest":    "TEXT",
+	})
+}
+
+// ensureServiceLogColumns adds the deploy/container tags to log tables created
+// before they existed, then indexes them. The service_id index is superseded
+// by idx_service_logs_service_seq and dropped.
+func ensureServiceLogColumns(db *sql.DB) error {
+	err := ensureColumns(db, "service_logs", map[string]string{
+		"deploy_id":    "TEXT NOT NULL DEFAULT ''",
+		"container_id": "TEXT NOT NULL DEFAULT ''",
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := db.Exec("CREATE INDEX IF NOT EXISTS idx_service_logs_deploy_id ON service_logs(service_id, deploy_id)"); err != nil {
+		return fmt.Errorf("failed to create service_logs deploy index: %w", err)
+	}
+	if _, err := db.Exec("DROP INDEX IF EXISTS idx_service_logs_service_id"); err != nil {
+		return fmt.Errorf("failed to drop service_logs service index: %w", err)
+	}
+	return nil
+}
+
+func ensureColumns(db *sql.DB, table string, columns map[string]string) error {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
 	if err != nil {
-		return fmt.Errorf("failed to inspect service_processes: %w", err)
+		return fmt.Errorf("failed to inspect %s: %w", table, err)
 	}
 	defer rows.Close()
 
@@ -112,7 +291,7 @@ func ensureServiceProcessColumns(db *sql.DB) error {
 		var notNull, pk int
 		var dflt sql.NullString
 		if err := rows.Scan(&cid, &name, &ctype, &notNull, &dflt, &pk); err != nil {
-			return fmt.Errorf("failed to read service_processes columns: %w", err)
+			return fmt.Errorf("failed to read %s columns: %w", table, err)
 		}
 		existing[name] = true
 	}
@@ -121,7 +300,7 @@ func ensureServiceProcessColumns(db *sql.DB) error {
 		if existing[name] {
 			continue
 		}
-		stmt := fmt.Sprintf("ALTER TABLE service_processes ADD COLUMN %s %s", name, definition)
+		stmt := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, name, definition)
 		if _, err := db.Exec(stmt); err != nil {
 			return fmt.Errorf("failed to add column %s: %w", name, err)
 		}
@@ -179,30 +358,37 @@ func (m *Manager) SetAppliedState(version int, hash string) error {
 
 // ServiceProcess represents a running service process
 type ServiceProcess struct {
-	ServiceID     string    `json:"service_id"`
-	ServiceName   string    `json:"service_name"`
-	GitCommit     string    `json:"git_commit"`
-	Runtime       string    `json:"runtime"`
-	ContainerID   string    `json:"container_id"`
-	ContainerName string    `json:"container_name"`
-	ImageTag      string    `json:"image_tag"`
-	PID           int       `json:"pid"`
-	Port          int       `json:"port"`        // Blue port (base port)
-	GreenPort     int       `json:"green_port"`  // Green port (base + 1)
-	ActivePort    int       `json:"active_port"` // Currently active port (blue or green)
-	BaseImage     string    `json:"base_image"`
-	Language      string    `json:"language"`
-	Status        string    `json:"status"`
-	RestartCount  int       `json:"restart_count"`
-	LastError     string    `json:"last_error"`
-	StartedAt     time.Time `json:"started_at"`
-	UpdatedAt     time.Time `json:"updated_at"`
+	ServiceID     string `json:"service_id"`
+	ServiceName   string `json:"service_name"`
+	GitCommit     string `json:"git_commit"`
+	Runtime       string `json:"runtime"`
+	ContainerID   string `json:"container_id"`
+	ContainerName string `json:"container_name"`
+	ImageTag      string `json:"image_tag"`
+	PID           int    `json:"pid"`
+	Port          int    `json:"port"`        // Blue port (base port)
+	GreenPort     int    `json:"green_port"`  // Green port (base + 1)
+	ActivePort    int    `json:"active_port"` // Currently active port (blue or green)
+	BaseImage     string `json:"base_image"`
+	Language      string `json:"language"`
+	DeployID      string `json:"deploy_id"`
+	// DefinitionHash fingerprints the desired service definition last applied.
+	// It is maintained separately from SaveServiceProcess.
+	DefinitionHash string `json:"definition_hash"`
+	// ImageDigest is the image ID the deployed container was started from.
+	// It is maintained separately from SaveServiceProcess.
+	ImageDigest  string    `json:"image_digest"`
+	Status       string    `json:"status"`
+	RestartCount int       `json:"restart_count"`
+	LastError    string    `json:"last_error"`
+	StartedAt    time.Time `json:"started_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
 }
 
 // GetServiceProcess retrieves a service process record
 func (m *Manager) GetServiceProcess(serviceID string) (*ServiceProcess, error) {
 	row := m.db.QueryRow(`
-		SELECT service_id, service_name, git_commit, runtime, container_id, container_name, image_tag, pid, port, green_port, active_port, base_image, language, status, restart_count, last_error, started_at, updated_at
+		SELECT service_id, service_name, git_commit, runtime, container_id, container_name, image_tag, pid, port, green_port, active_port, base_image, language, deploy_id, definition_hash, image_digest, status, restart_count, last_error, started_at, updated_at
 		FROM service_processes
 		WHERE service_id = ?
 	`, serviceID)
@@ -210,8 +396,8 @@ func (m *Manager) GetServiceProcess(serviceID string) (*ServiceProcess, error) {
 	var p ServiceProcess
 	var startedAt, updatedAt sql.NullString
 	var port, greenPort, activePort sql.NullInt64
-	var baseImage, language sql.NullString
-	err := row.Scan(&p.ServiceID, &p.ServiceName, &p.GitCommit, &p.Runtime, &p.ContainerID, &p.ContainerName, &p.ImageTag, &p.PID, &port, &greenPort, &activePort, &baseImage, &language, &p.Status, &p.RestartCount, &p.LastError, &startedAt, &updatedAt)
+	var baseImage, language, deployID, definitionHash, imageDigest sql.NullString
+	err := row.Scan(&p.ServiceID, &p.ServiceName, &p.GitCommit, &p.Runtime, &p.ContainerID, &p.ContainerName, &p.ImageTag, &p.PID, &port, &greenPort, &activePort, &baseImage, &language, &deployID, &definitionHash, &imageDigest, &p.Status, &p.RestartCount, &p.LastError, &startedAt, &updatedAt)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -234,6 +420,11 @@ func (m *Manager) GetServiceProcess(serviceID string) (*ServiceProcess, error) {
 	if language.Valid {
 		p.Language = language.String
 	}
+	if deployID.Valid {
+		p.DeployID = deployID.String
+	}
+	p.DefinitionHash = definitionHash.String
+	p.ImageDigest = imageDigest.String
 	if startedAt.Valid {
 		p.StartedAt, _ = time.Parse(time.RFC3339, startedAt.String)
 	}
@@ -291,8 +482,8 @@ func (m *Manager) SaveServiceProcess(p *ServiceProcess) error {
 		p.Runtime = "docker"
 	}
 	_, err := m.db.Exec(`
-		INSERT INTO service_processes (service_id, service_name, git_commit, runtime, container_id, container_name, image_tag, pid, port, green_port, active_port, base_image, language, status, restart_count, last_error, started_at, updated_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+		INSERT INTO service_processes (service_id, service_name, git_commit, runtime, container_id, container_name, image_tag, pid, port, green_port, active_port, base_image, language, deploy_id, status, restart_count, last_error, started_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
 		ON CONFLICT(service_id) DO UPDATE SET
 			service_name = excluded.service_name,
 			git_commit = excluded.git_commit,
@@ -306,12 +497,13 @@ func (m *Manager) SaveServiceProcess(p *ServiceProcess) error {
 			active_port = excluded.active_port,
 			base_image = excluded.base_image,
 			language = excluded.language,
+			deploy_id = excluded.deploy_id,
 			status = excluded.status,
 			restart_count = excluded.restart_count,
 			last_error = excluded.last_error,
 			started_at = excluded.started_at,
 			updated_at = excluded.updated_at
-	`, p.ServiceID, p.ServiceName, p.GitCommit, p.Runtime, p.ContainerID, p.ContainerName, p.ImageTag, p.PID, p.Port, p.GreenPort, p.ActivePort, p.BaseImage, p.Language, p.Status, p.RestartCount, p.LastError, p.StartedAt)
+	`, p.ServiceID, p.ServiceName, p.GitCommit, p.Runtime, p.ContainerID, p.ContainerName, p.ImageTag, p.PID, p.Port, p.GreenPort, p.ActivePort, p.BaseImage, p.Language, p.DeployID, p.Status, p.RestartCount, p.LastError, p.StartedAt)
 
 	if err != nil {
 		return fmt.Errorf("failed to save service process: %w", err)
@@ -320,6 +512,26 @@ func (m *Manager) SaveServiceProcess(p *ServiceProcess) error {
 	return nil
 }
 
+// SetServiceDefinitionHash records the definition hash last applied for a
+// service. It is a no-op if the service has no process record.
+func (m *Manager) SetServiceDefinitionHash(serviceID, hash string) error {
+	_, err := m.db.Exec("UPDATE service_processes SET definition_hash = ? WHERE service_id = ?", hash, serviceID)
+	if err != nil {
+		return fmt.Errorf("failed to set definition hash: %w", err)
+	}
+	return nil
+}
+
+// SetServiceImageDigest records the image ID a service's deployed container
+// runs. It is a no-op if the service has no process record.
+func (m *Manager) SetServiceImageDigest(serviceID, digest string) error {
+	_, err := m.db.Exec("UPDATE service_processes SET image_digest = ? WHERE service_id = ?", digest, serviceID)
+	if err != nil {
+		return fmt.Errorf("failed to set image digest: %w", err)
+	}
+	return nil
+}
+
 // DeleteServiceProcess removes a service process record
 func (m *Manager) DeleteServiceProcess(serviceID string) error {
 	_, err := m.db.Exec("DELETE FROM service_processes WHERE service_id = ?", serviceID)
@@ -331,16 +543,21 @@ func (m *Manager) DeleteServiceProcess(serviceID string) error {
 
 // LogServiceMessage logs a message from a service
 func (m *Manager) LogServiceMessage(serviceID, level, message string) error {
-	_, err := m.db.Exec(`
-		INSERT INTO service_logs (service_id, level, message)
-		VALUES (?, ?, ?)
-	`, serviceID, level, message)
-
-	if err != nil {
-		return fmt.Errorf("failed to log message: %w", err)
-	}
+	return m.LogServiceMessageTagged(serviceID, "", "", level, message)
+}
 
-	return nil
+// LogServiceMessageTagged logs a message from a service, tagged with the
+// deploy and container that produced it. Lines are written in batches; see
+// FlushLogs.
+func (m *Manager) LogServiceMessageTagged(serviceID, deployID, containerID, level, message string) error {
+	return m.bufferLog(bufferedLog{
+		serviceID:   serviceID,
+		deployID:    deployID,
+		containerID: containerID,
+		level:       level,
+		message:     message,
+		createdAt:   time.Now().UTC().Format(logTimeFormat),
+	})
 }
 
 // GetAllServiceProcesses retrieves all service processes
@@ -400,17 +617,18 @@ func (m *Manager) GetAllServiceProcesses() []*ServiceProcess {
 	return processes
 }
 
-// GetServiceLogs retrieves logs for a service
+// GetServiceLogs retrieves the most recent logs for a service, newest first
 func (m *Manager) GetServiceLogs(serviceID string, limit int) ([]struct {
 	Level     string    `json:"level"`
 	Message   string    `json:"message"`
 	CreatedAt time.Time `json:"created_at"`
 }, error) {
+	m.flushLogsForRead()
 	rows, err := m.db.Query(`
 		SELECT level, message, created_at
 		FROM service_logs
 		WHERE service_id = ?
-		ORDER BY created_at DESC
+		ORDER BY id DESC
 		LIMIT ?
 	`, serviceID, limit)
 	if err != nil {
@@ -441,19 +659,26 @@ func (m *Manager) GetServiceLogs(serviceID string, limit int) ([]struct {
 	return logs, nil
 }
 
-// StreamLogs streams logs for a service in real-time
+// StreamLogsPageSize is the most rows StreamLogs returns at once. Callers
+// that get a full page ask again from its last ID.
+const StreamLogsPageSize = 1000
+
+// StreamLogs returns up to StreamLogsPageSize logs for a service with an ID
+// greater than lastID, oldest first, for following logs in real-time
 func (m *Manager) StreamLogs(serviceID string, lastID int64) ([]struct {
 	ID        int64     `json:"id"`
 	Level     string    `json:"level"`
 	Message   string    `json:"message"`
 	CreatedAt time.Time `json:"created_at"`
 }, error) {
+	m.flushLogsForRead()
 	rows, err := m.db.Query(`
 		SELECT id, level, message, created_at
 		FROM service_logs
 		WHERE service_id = ? AND id > ?
-		ORDER BY created_at ASC
-	`, serviceID, lastID)
+		ORDER BY id ASC
+		LIMIT ?
+	`, serviceID, lastID, StreamLogsPageSize)
 	if err != nil {
 		return nil, fmt.Errorf("failed to stream logs: %w", err)
 	}
@@ -486,12 +711,195 @@ func (m *Manager) StreamLogs(serviceID string, lastID int64) ([]struct {
 	return logs, nil
 }
 
+// ServiceLog is a service log line with the deploy and container that
+// produced it.
+type ServiceLog struct {
+	ID          int64     `json:"id"`
+	ServiceID   string    `json:"service_id,omitempty"`
+	DeployID    string    `json:"deploy_id,omitempty"`
+	ContainerID string    `json:"container_id,omitempty"`
+	Level       string    `json:"level"`
+	Message     string    `json:"message"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// logTimeFormat is how service log timestamps are stored: UTC with fixed-width
+// milliseconds, so they sort as text in the order they were logged.
+const logTimeFormat = "2006-01-02 15:04:05.000"
+
+// LogFilter narrows QueryServiceLogs. Empty fields match everything;
+// ContainerID matches by prefix so short container IDs work.
+type LogFilter struct {
+	DeployID    string
+	ContainerID string
+	Since       time.Time
+}
+
+// where appends the filter's conditions to a WHERE clause.
+func (f LogFilter) where(query string, args []interface{}) (string, []interface{}) {
+	if f.DeployID != "" {
+		query += " AND deploy_id = ?"
+		args = append(args, f.DeployID)
+	}
+	if f.ContainerID != "" {
+		query += " AND container_id LIKE ? || '%'"
+		args = append(args, f.ContainerID)
+	}
+	if !f.Since.IsZero() {
+		query += " AND created_at >= ?"
+		args = append(args, f.Since.UTC().Format(logTimeFormat))
+	}
+	return query, args
+}
+
+// QueryServiceLogs returns the most recent logs for a service matching filter,
+// newest first.
+func (m *Manager) QueryServiceLogs(serviceID string, filter LogFilter, limit int) ([]ServiceLog, error) {
+	query := `
+		SELECT id, service_id, deploy_id, container_id, level, message, created_at
+		FROM service_logs
+		WHERE service_id = ?`
+	query, args := filter.where(query, []interface{}{serviceID})
+	query += " ORDER BY id DESC LIMIT ?"
+	args = append(args, limit)
+
+	return m.queryServiceLogs(query, args...)
+}
+
+// ScanServiceLogs returns up to limit logs with an ID greater than afterID,
+// oldest first, for paging through logs in bulk. An empty serviceID matches
+// all services.
+func (m *Manager) ScanServiceLogs(serviceID string, filter LogFilter, afterID int64, limit int) ([]ServiceLog, error) {
+	query := `
+		SELECT id, service_id, deploy_id, container_id, level, message, created_at
+		FROM service_logs
+		WHERE id > ?`
+	args := []interface{}{afterID}
+	if serviceID != "" {
+		query += " AND service_id = ?"
+		args = append(args, serviceID)
+	}
+	query, args = filter.where(query, args)
+	query += " ORDER BY id ASC LIMIT ?"
+	args = append(args, limit)
+
+	return m.queryServiceLogs(query, args...)
+}
+
+func (m *Manager) queryServiceLogs(query string, args ...interface{}) ([]ServiceLog, error) {
+	m.flushLogsForRead()
+	rows, err := m.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query service logs: %w", err)
+	}
+	defer rows.Close()
+
+	var logs []ServiceLog
+	for rows.Next() {
+		var entry ServiceLog
+		var createdAt string
+		if err := rows.Scan(&entry.ID, &entry.ServiceID, &entry.DeployID, &entry.ContainerID, &entry.Level, &entry.Message, &createdAt); err != nil {
+			return nil, fmt.Errorf("failed to scan log: %w", err)
+		}
+		entry.CreatedAt, _ = time.Parse(time.RFC3339, createdAt)
+		logs = append(logs, entry)
+	}
+
+	return logs, rows.Err()
+}
+
+// LogDeploy summarizes the logs captured for one deploy of a service.
+type LogDeploy struct {
+	DeployID  string    `json:"deploy_id"`
+	Lines     int       `json:"lines"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// ListLogDeploys returns the deploys that have captured logs for a service,
+// most recent first.
+func (m *Manager) ListLogDeploys(serviceID string) ([]LogDeploy, error) {
+	m.flushLogsForRead()
+	rows, err := m.db.Query(`
+		SELECT deploy_id, COUNT(*), MIN(created_at), MAX(created_at)
+		FROM service_logs
+		WHERE service_id = ? AND deploy_id != ''
+		GROUP BY deploy_id
+		ORDER BY MIN(id) DESC
+	`, serviceID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list log deploys: %w", err)
+	}
+	defer rows.Close()
+
+	var deploys []LogDeploy
+	for rows.Next() {
+		var d LogDeploy
+		var firstSeen, lastSeen string
+		if err := rows.Scan(&d.DeployID, &d.Lines, &firstSeen, &lastSeen); err != nil {
+			return nil, fmt.Errorf("failed to scan log deploy: %w", err)
+		}
+		d.FirstSeen = parseSQLiteTime(firstSeen)
+		d.LastSeen = parseSQLiteTime(lastSeen)
+		deploys = append(deploys, d)
+	}
+
+	return deploys, rows.Err()
+}
+
+// parseSQLiteTime parses timestamps from aggregate queries, which lose the
+// column's DATETIME type and come back in SQLite's text format.
+func parseSQLiteTime(value string) time.Time {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t
+	}
+	t, _ := time.Parse("2006-01-02 15:04:05", value)
+	return t
+}
+
+// ResolveDeployRef turns "current" or "previous" into a concrete deploy ID for
+// a service. The current deploy is the one recorded for the running container,
+// falling back to the most recent deploy with logs. Any other value is
+// returned unchanged.
+func (m *Manager) ResolveDeployRef(serviceID, ref string) (string, error) {
+	if ref != "current" && ref != "previous" {
+		return ref, nil
+	}
+
+	deploys, err := m.ListLogDeploys(serviceID)
+	if err != nil {
+		return "", err
+	}
+	current := ""
+	if proc, err := m.GetServiceProcess(serviceID); err == nil && proc != nil {
+		current = proc.DeployID
+	}
+	if current == "" && len(deploys) > 0 {
+		current = deploys[0].DeployID
+	}
+	if ref == "current" {
+		if current == "" {
+			return "", fmt.Errorf("no deploys recorded for service %s", serviceID)
+		}
+		return current, nil
+	}
+
+	for i, d := range deploys {
+		if d.DeployID == current && i+1 < len(deploys) {
+			return deploys[i+1].DeployID, nil
+		}
+	}
+	return "", fmt.Errorf("no previous deploy recorded for service %s", serviceID)
+}
+
 // CleanupOldLogs removes old log entries to maintain retention limit
 func (m *Manager) CleanupOldLogs(serviceID string, retention int) error {
 	if retention <= 0 {
 		retention = 10000
 	}
 
+	m.flushLogsForRead()
+
 	// Count total logs for service
 	var count int
 	err := m.db.QueryRow("SELECT COUNT(*) FROM service_logs WHERE service_id = ?", serviceID).Scan(&count)
@@ -505,15 +913,18 @@ func (m *Manager) CleanupOldLogs(serviceID string, retention int) error {
 
 	// Delete oldest logs, keeping retention count
 	deleteCount := count - retention + 1000 // Delete extra to avoid frequent cleanups
+	if deleteCount > count {
+		deleteCount = count
+	}
 	_, err = m.db.Exec(`
-		DELETE FROM service_logs 
-		WHERE id IN (
-			SELECT id FROM service_logs 
-			WHERE service_id = ? 
-			ORDER BY created_at ASC 
-			LIMIT ?
+		DELETE FROM service_logs
+		WHERE service_id = ? AND id <= (
+			SELECT id FROM service_logs
+			WHERE service_id = ?
+			ORDER BY id ASC
+			LIMIT 1 OFFSET ?
 		)
-	`, serviceID, deleteCount)
+	`, serviceID, serviceID, deleteCount-1)
 
 	if err != nil {
 		return fmt.Errorf("failed to cleanup old logs: %w", err)
@@ -521,3 +932,136 @@ func (m *Manager) CleanupOldLogs(serviceID string, retention int) error {
 
 	return nil
 }
+
+// maxAgentEvents bounds the agent_events table.
+const maxAgentEvents = 5000
+
+// AgentEvent is a notable agent or service event kept for troubleshooting.
+type AgentEvent struct {
+	ID        int64     `json:"id"`
+	ServiceID string    `json:"service_id,omitempty"`
+	EventType string    `json:"event_type"`
+	Message   string    `json:"message,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// RecordEvent stores an agent event, trimming the oldest events beyond the cap.
+func (m *Manager) RecordEvent(serviceID, eventType, message string) error {
+	_, err := m.db.Exec(`
+		INSERT INTO agent_events (service_id, event_type, message)
+		VALUES (?, ?, ?)
+	`, serviceID, eventType, message)
+	if err != nil {
+		return fmt.Errorf("failed to record event: %w", err)
+	}
+
+	_, err = m.db.Exec(`
+		DELETE FROM agent_events
+		WHERE id <= (SELECT MAX(id) FROM agent_events) - ?
+	`, maxAgentEvents)
+	if err != nil {
+		return fmt.Errorf("failed to trim events: %w", err)
+	}
+
+	return nil
+}
+
+// ListRecentEvents returns the most recent events, newest first.
+func (m *Manager) ListRecentEvents(limit int) ([]AgentEvent, error) {
+	if limit <= 0 {
+		limit = 100
+	}
+
+	rows, err := m.db.Query(`
+		SELECT id, service_id, event_type, message, created_at
+		FROM agent_events
+		ORDER BY id DESC
+		LIMIT ?
+	`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []AgentEvent
+	for rows.Next() {
+		var e AgentEvent
+		var createdAt string
+		if err := rows.Scan(&e.ID, &e.ServiceID, &e.EventType, &e.Message, &createdAt); err != nil {
+			return nil, fmt.Errorf("failed to scan event: %w", err)
+		}
+		e.CreatedAt, _ = time.Parse(time.RFC3339, createdAt)
+		events = append(events, e)
+	}
+
+	return events, nil
+}
+
+// LastServiceEvent returns a service's most recent event of the given type,
+// or nil if it has none.
+func (m *Manager) LastServiceEvent(serviceID, eventType string) (*AgentEvent, error) {
+	e := AgentEvent{ServiceID: serviceID, EventType: eventType}
+	var createdAt string
+	err := m.db.QueryRow(`
+		SELECT id, message, created_at
+		FROM agent_events
+		WHERE service_id = ? AND event_type = ?
+		ORDER BY id DESC
+		LIMIT 1
+	`, serviceID, eventType).Scan(&e.ID, &e.Message, &createdAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get event: %w", err)
+	}
+	e.CreatedAt, _ = time.Parse(time.RFC3339, createdAt)
+	return &e, nil
+}
+
+// SaveRoutes replaces the persisted route table of the given kind ("external" or "internal").
+func (m *Manager) SaveRoutes(kind string, routes map[string]int) error {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM proxy_routes WHERE kind = ?", kind); err != nil {
+		return fmt.Errorf("failed to clear routes: %w", err)
+	}
+	for host, port := range routes {
+		if _, err := tx.Exec(`
+			INSERT INTO proxy_routes (kind, host, port, updated_at)
+			VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+		`, kind, host, port); err != nil {
+			return fmt.Errorf("failed to save route %s: %w", host, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit routes: %w", err)
+	}
+	return nil
+}
+
+// GetRoutes returns the persisted route table of the given kind.
+func (m *Manager) GetRoutes(kind string) (map[string]int, error) {
+	rows, err := m.db.Query("SELECT host, port FROM proxy_routes WHERE kind = ?", kind)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get routes: %w", err)
+	}
+	defer rows.Close()
+
+	routes := make(map[string]int)
+	for rows.Next() {
+		var host string
+		var port int
+		if err := rows.Scan(&host, &port); err != nil {
+			return nil, fmt.Errorf("failed to scan route: %w", err)
+		}
+		routes[host] = port
+	}
+
+	return routes, nil
+}
@@ -2,6 +2,7 @@ package state
 
 import (
 	"fmt"
+	"strings"
 	"testing"
 	"time"
 )
@@ -263,6 +264,182 @@ func TestStreamLogs(t *testing.T) {
 	t.Logf("✓ Log streaming works correctly")
 }
 
+func TestServiceLogs_IndexedPaging(t *testing.T) {
+	t.Logf("Testing logs are paged by ID through the service index")
+
+	mgr := setupTestDB(t)
+	total := StreamLogsPageSize + 5
+	for i := 0; i < total; i++ {
+		if err := mgr.LogServiceMessage("test-service", "info", fmt.Sprintf("line %d", i)); err != nil {
+			t.Fatalf("Failed to log message %d: %v", i, err)
+		}
+	}
+	mgr.LogServiceMessage("other-service", "info", "elsewhere")
+
+	page, err := mgr.StreamLogs("test-service", 0)
+	if err != nil || len(page) != StreamLogsPageSize || page[0].Message != "line 0" {
+		t.Fatalf("Expected a first page of %d from line 0, got %d (err=%v)", StreamLogsPageSize, len(page), err)
+	}
+	rest, err := mgr.StreamLogs("test-service", page[len(page)-1].ID)
+	if err != nil || len(rest) != 5 || rest[4].Message != fmt.Sprintf("line %d", total-1) {
+		t.Fatalf("Expected the last 5 lines on the next page, got %d (err=%v)", len(rest), err)
+	}
+
+	// Lines logged within the same second still come back newest first.
+	latest, err := mgr.GetServiceLogs("test-service", 2)
+	if err != nil || len(latest) != 2 || latest[0].Message != fmt.Sprintf("line %d", total-1) || latest[1].Message != fmt.Sprintf("line %d", total-2) {
+		t.Fatalf("Expected the newest lines first, got %+v (err=%v)", latest, err)
+	}
+
+	var createdAt string
+	if err := mgr.db.QueryRow("SELECT created_at || '' FROM service_logs LIMIT 1").Scan(&createdAt); err != nil || len(createdAt) != len(logTimeFormat) {
+		t.Errorf("Expected timestamps stored as %q, got %q (err=%v)", logTimeFormat, createdAt, err)
+	}
+
+	rows, err := mgr.db.Query("EXPLAIN QUERY PLAN SELECT id, level, message, created_at FROM service_logs WHERE service_id = ? AND id > ? ORDER BY id ASC LIMIT ?", "test-service", 0, 10)
+	if err != nil {
+		t.Fatalf("Failed to explain stream query: %v", err)
+	}
+	var plan []string
+	for rows.Next() {
+		var id, parent, notUsed int
+		var detail string
+		rows.Scan(&id, &parent, &notUsed, &detail)
+		plan = append(plan, detail)
+	}
+	rows.Close()
+	if !strings.Contains(strings.Join(plan, "; "), "idx_service_logs_service_seq") {
+		t.Errorf("Expected the stream query to use idx_service_logs_service_seq, got %v", plan)
+	}
+
+	// Databases from before the composite index lose the old one.
+	mgr.db.Exec("CREATE INDEX idx_service_logs_service_id ON service_logs(service_id)")
+	if err := migrate(mgr.db); err != nil {
+		t.Fatalf("Failed to migrate: %v", err)
+	}
+	var indexes int
+	mgr.db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'index' AND name = 'idx_service_logs_service_id'").Scan(&indexes)
+	if indexes != 0 {
+		t.Errorf("Expected the superseded service_id index dropped")
+	}
+	t.Logf("✓ Logs paged by ID, newest first within a second, through the composite index")
+}
+
+func TestQueryServiceLogs_DeployAndContainerFilters(t *testing.T) {
+	t.Logf("Testing log filtering by deploy and container")
+
+	mgr := setupTestDB(t)
+
+	lines := []struct{ deploy, container, message string }{
+		{"d1", "aaaa1111", "old deploy started"},
+		{"d1", "aaaa1111", "old deploy crashed"},
+		{"d2", "bbbb2222", "new deploy started"},
+		{"", "", "untagged"},
+	}
+	for _, line := range lines {
+		if err := mgr.LogServiceMessageTagged("svc", line.deploy, line.container, "info", line.message); err != nil {
+			t.Fatalf("Failed to log: %v", err)
+		}
+	}
+
+	logs, err := mgr.QueryServiceLogs("svc", LogFilter{DeployID: "d1"}, 10)
+	if err != nil {
+		t.Fatalf("Failed to query logs: %v", err)
+	}
+	if len(logs) != 2 || logs[0].Message != "old deploy crashed" || logs[0].ContainerID != "aaaa1111" {
+		t.Errorf("Expected d1 logs newest first, got %+v", logs)
+	}
+
+	logs, err = mgr.QueryServiceLogs("svc", LogFilter{ContainerID: "bbbb"}, 10)
+	if err != nil {
+		t.Fatalf("Failed to query logs: %v", err)
+	}
+	if len(logs) != 1 || logs[0].DeployID != "d2" {
+		t.Errorf("Expected container prefix to match d2 log, got %+v", logs)
+	}
+
+	logs, err = mgr.QueryServiceLogs("svc", LogFilter{}, 10)
+	if err != nil || len(logs) != 4 {
+		t.Errorf("Expected 4 unfiltered logs, got %d (err=%v)", len(logs), err)
+	}
+
+	t.Logf("✓ Logs filtered by deploy and container")
+}
+
+func TestResolveDeployRef(t *testing.T) {
+	t.Logf("Testing current/previous deploy resolution")
+
+	mgr := setupTestDB(t)
+
+	if _, err := mgr.ResolveDeployRef("svc", "previous"); err == nil {
+		t.Errorf("Expected error with no deploys recorded")
+	}
+
+	for _, deploy := range []string{"d1", "d2", "d3"} {
+		if err := mgr.LogServiceMessageTagged("svc", deploy, "", "info", "deploy "+deploy); err != nil {
+			t.Fatalf("Failed to log: %v", err)
+		}
+	}
+
+	deploys, err := mgr.ListLogDeploys("svc")
+	if err != nil {
+		t.Fatalf("Failed to list deploys: %v", err)
+	}
+	if len(deploys) != 3 || deploys[0].DeployID != "d3" || deploys[0].Lines != 1 {
+		t.Errorf("Expected 3 deploys newest first, got %+v", deploys)
+	}
+
+	if got, _ := mgr.ResolveDeployRef("svc", "previous"); got != "d2" {
+		t.Errorf("Expected previous=d2 without a recorded process, got %q", got)
+	}
+
+	// A failed deploy (d3) leaves the running process on d2.
+	if err := mgr.SaveServiceProcess(&ServiceProcess{ServiceID: "svc", ServiceName: "svc", DeployID: "d2", Status: "running"}); err != nil {
+		t.Fatalf("Failed to save process: %v", err)
+	}
+	if got, _ := mgr.ResolveDeployRef("svc", "current"); got != "d2" {
+		t.Errorf("Expected current=d2, got %q", got)
+	}
+	if got, _ := mgr.ResolveDeployRef("svc", "previous"); got != "d1" {
+		t.Errorf("Expected previous=d1, got %q", got)
+	}
+	if got, _ := mgr.ResolveDeployRef("svc", "d3"); got != "d3" {
+		t.Errorf("Expected explicit deploy ID to pass through, got %q", got)
+	}
+
+	t.Logf("✓ Deploy references resolved")
+}
+
+func TestServiceDefinitionHash(t *testing.T) {
+	t.Logf("Testing service definition hash persistence")
+
+	mgr := setupTestDB(t)
+
+	proc := &ServiceProcess{ServiceID: "svc", ServiceName: "api", Runtime: "docker", Status: "running"}
+	if err := mgr.SaveServiceProcess(proc); err != nil {
+		t.Fatalf("Failed to save process: %v", err)
+	}
+	if err := mgr.SetServiceDefinitionHash("svc", "hash-1"); err != nil {
+		t.Fatalf("Failed to set definition hash: %v", err)
+	}
+
+	// Redeploys save the process again; the hash must survive.
+	proc.GitCommit = "def456"
+	if err := mgr.SaveServiceProcess(proc); err != nil {
+		t.Fatalf("Failed to update process: %v", err)
+	}
+	retrieved, _ := mgr.GetServiceProcess("svc")
+	if retrieved.DefinitionHash != "hash-1" {
+		t.Errorf("Expected definition hash 'hash-1', got '%s'", retrieved.DefinitionHash)
+	}
+
+	if err := mgr.SetServiceDefinitionHash("missing", "hash-2"); err != nil {
+		t.Errorf("Expected no error for untracked service, got %v", err)
+	}
+
+	t.Logf("✓ Definition hash persisted across process saves")
+}
+
 func TestCleanupOldLogs(t *testing.T) {
 	t.Logf("Testing log cleanup")
 
@@ -408,3 +585,76 @@ func TestAppliedState(t *testing.T) {
 
 	t.Logf("✓ Applied state saved and retrieved correctly")
 }
+
+func TestRecordAndListEvents(t *testing.T) {
+	t.Logf("Testing agent event recording")
+
+	mgr := setupTestDB(t)
+
+	if err := mgr.RecordEvent("svc-1", "lifecycle", "status=building"); err != nil {
+		t.Fatalf("Failed to record event: %v", err)
+	}
+	if err := mgr.RecordEvent("", "agent", "started"); err != nil {
+		t.Fatalf("Failed to record event: %v", err)
+	}
+
+	events, err := mgr.ListRecentEvents(10)
+	if err != nil {
+		t.Fatalf("Failed to list events: %v", err)
+	}
+
+	if len(events) != 2 {
+		t.Fatalf("Expected 2 events, got %d", len(events))
+	}
+	if events[0].EventType != "agent" {
+		t.Errorf("Expected newest event first, got '%s'", events[0].EventType)
+	}
+	if events[1].ServiceID != "svc-1" {
+		t.Errorf("Expected service ID 'svc-1', got '%s'", events[1].ServiceID)
+	}
+
+	last, err := mgr.LastServiceEvent("svc-1", "lifecycle")
+	if err != nil || last == nil || last.Message != "status=building" || last.CreatedAt.IsZero() {
+		t.Errorf("Expected last lifecycle event of svc-1, got %+v (err=%v)", last, err)
+	}
+	if last, _ := mgr.LastServiceEvent("svc-1", "out_of_band_change"); last != nil {
+		t.Errorf("Expected no out-of-band event, got %+v", last)
+	}
+
+	t.Logf("✓ Events recorded and listed correctly")
+}
+
+func TestSaveAndGetRoutes(t *testing.T) {
+	t.Logf("Testing route table persistence")
+
+	mgr := setupTestDB(t)
+
+	if err := mgr.SaveRoutes("external", map[string]int{"api.example.com": 3000, "web.example.com": 3002}); err != nil {
+		t.Fatalf("Failed to save routes: %v", err)
+	}
+	if err := mgr.SaveRoutes("external", map[string]int{"api.example.com": 3001}); err != nil {
+		t.Fatalf("Failed to replace routes: %v", err)
+	}
+
+	routes, err := mgr.GetRoutes("external")
+	if err != nil {
+		t.Fatalf("Failed to get routes: %v", err)
+	}
+
+	if len(routes) != 1 {
+		t.Fatalf("Expected 1 route after replace, got %d", len(routes))
+	}
+	if routes["api.example.com"] != 3001 {
+		t.Errorf("Expected port 3001, got %d", routes["api.example.com"])
+	}
+
+	internal, err := mgr.GetRoutes("internal")
+	if err != nil {
+		t.Fatalf("Failed to get internal routes: %v", err)
+	}
+	if len(internal) != 0 {
+		t.Errorf("Expected no internal routes, got %d", len(internal))
+	}
+
+	t.Logf("✓ Routes saved and retrieved correctly")
+}
@@ -0,0 +1,97 @@
+package state
+
+import (
+	"fmt"
+	"time"
+)
+
+// Metric resolutions, in seconds. Raw samples are averaged into hourly
+// buckets once each hour completes.
+const (
+	MetricResolutionRaw    = 60
+	MetricResolutionHourly = 3600
+)
+
+// MetricSample is one service's resource usage for a time bucket. Raw samples
+// have Samples == 1; downsampled buckets hold the average of their samples.
+type MetricSample struct {
+	ServiceID   string    `json:"service_id"`
+	Resolution  int       `json:"resolution"`
+	BucketStart time.Time `json:"bucket_start"`
+	CPUPercent  float64   `json:"cpu_percent"`
+	MemoryBytes int64     `json:"memory_bytes"`
+	RequestRate float64   `json:"request_rate"`
+	Samples     int       `json:"samples"`
+}
+
+// RecordMetricSample stores a raw sample. Samples landing in the same raw
+// bucket replace each other.
+func (m *Manager) RecordMetricSample(sample MetricSample) error {
+	bucket := sample.BucketStart.Unix() / MetricResolutionRaw * MetricResolutionRaw
+	_, err := m.db.Exec(`
+		INSERT INTO service_metrics (service_id, resolution, bucket_start, cpu_percent, memory_bytes, request_rate, samples)
+		VALUES (?, ?, ?, ?, ?, ?, 1)
+		ON CONFLICT(service_id, resolution, bucket_start) DO UPDATE SET
+			cpu_percent = excluded.cpu_percent,
+			memory_bytes = excluded.memory_bytes,
+			request_rate = excluded.request_rate
+	`, sample.ServiceID, MetricResolutionRaw, bucket, sample.CPUPercent, sample.MemoryBytes, sample.RequestRate)
+	if err != nil {
+		return fmt.Errorf("failed to record metric sample: %w", err)
+	}
+	return nil
+}
+
+// DownsampleMetrics averages raw samples into hourly buckets for every hour
+// that ended before now, then deletes raw samples older than rawRetention and
+// hourly buckets older than hourlyRetention.
+func (m *Manager) DownsampleMetrics(now time.Time, rawRetention, hourlyRetention time.Duration) error {
+	currentHour := now.Unix() / MetricResolutionHourly * MetricResolutionHourly
+	_, err := m.db.Exec(`
+		INSERT INTO service_metrics (service_id, resolution, bucket_start, cpu_percent, memory_bytes, request_rate, samples)
+		SELECT service_id, ?, bucket_start / ? * ?, AVG(cpu_percent), CAST(AVG(memory_bytes) AS INTEGER), AVG(request_rate), COUNT(*)
+		FROM service_metrics
+		WHERE resolution = ? AND bucket_start < ?
+		GROUP BY service_id, bucket_start / ?
+		ON CONFLICT(service_id, resolution, bucket_start) DO NOTHING
+	`, MetricResolutionHourly, MetricResolutionHourly, MetricResolutionHourly, MetricResolutionRaw, currentHour, MetricResolutionHourly)
+	if err != nil {
+		return fmt.Errorf("failed to downsample metrics: %w", err)
+	}
+
+	_, err = m.db.Exec(`
+		DELETE FROM service_metrics
+		WHERE (resolution = ? AND bucket_start < ?) OR (resolution = ? AND bucket_start < ?)
+	`, MetricResolutionRaw, now.Add(-rawRetention).Unix(), MetricResolutionHourly, now.Add(-hourlyRetention).Unix())
+	if err != nil {
+		return fmt.Errorf("failed to prune metrics: %w", err)
+	}
+	return nil
+}
+
+// ListMetricSamples returns a service's samples at the given resolution with
+// buckets starting at or after since, oldest first.
+func (m *Manager) ListMetricSamples(serviceID string, resolution int, since time.Time) ([]MetricSample, error) {
+	rows, err := m.db.Query(`
+		SELECT service_id, resolution, bucket_start, cpu_percent, memory_bytes, request_rate, samples
+		FROM service_metrics
+		WHERE service_id = ? AND resolution = ? AND bucket_start >= ?
+		ORDER BY bucket_start ASC
+	`, serviceID, resolution, since.Unix())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list metrics: %w", err)
+	}
+	defer rows.Close()
+
+	var samples []MetricSample
+	for rows.Next() {
+		var sample MetricSample
+		var bucketStart int64
+		if err := rows.Scan(&sample.ServiceID, &sample.Resolution, &bucketStart, &sample.CPUPercent, &sample.MemoryBytes, &sample.RequestRate, &sample.Samples); err != nil {
+			return nil, fmt.Errorf("failed to scan metric sample: %w", err)
+		}
+		sample.BucketStart = time.Unix(bucketStart, 0).UTC()
+		samples = append(samples, sample)
+	}
+	return samples, rows.Err()
+}
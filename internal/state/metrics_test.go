@@ -0,0 +1,56 @@
+package state
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDownsampleMetrics(t *testing.T) {
+	t.Logf("Testing metric downsampling and retention")
+
+	mgr := setupTestDB(t)
+
+	hour := time.Date(2026, 6, 1, 10, 0, 0, 0, time.UTC)
+	for i, cpu := range []float64{10, 20, 30} {
+		if err := mgr.RecordMetricSample(MetricSample{
+			ServiceID:   "svc",
+			BucketStart: hour.Add(time.Duration(i) * time.Minute),
+			CPUPercent:  cpu,
+			MemoryBytes: 100,
+			RequestRate: 2,
+		}); err != nil {
+			t.Fatalf("Failed to record sample: %v", err)
+		}
+	}
+	// A sample in the still-open hour must not be downsampled yet.
+	mgr.RecordMetricSample(MetricSample{ServiceID: "svc", BucketStart: hour.Add(time.Hour), CPUPercent: 90})
+
+	now := hour.Add(time.Hour + 5*time.Minute)
+	if err := mgr.DownsampleMetrics(now, 24*time.Hour, 30*24*time.Hour); err != nil {
+		t.Fatalf("Downsample failed: %v", err)
+	}
+	// Running again must not double count.
+	if err := mgr.DownsampleMetrics(now, 24*time.Hour, 30*24*time.Hour); err != nil {
+		t.Fatalf("Second downsample failed: %v", err)
+	}
+
+	hourly, err := mgr.ListMetricSamples("svc", MetricResolutionHourly, time.Time{})
+	if err != nil {
+		t.Fatalf("Failed to list hourly metrics: %v", err)
+	}
+	if len(hourly) != 1 || hourly[0].CPUPercent != 20 || hourly[0].Samples != 3 || !hourly[0].BucketStart.Equal(hour) {
+		t.Fatalf("Expected one hourly bucket averaging 3 samples, got %+v", hourly)
+	}
+
+	// Past raw retention the raw samples go, the hourly bucket stays.
+	if err := mgr.DownsampleMetrics(hour.Add(48*time.Hour), 24*time.Hour, 30*24*time.Hour); err != nil {
+		t.Fatalf("Downsample failed: %v", err)
+	}
+	raw, _ := mgr.ListMetricSamples("svc", MetricResolutionRaw, time.Time{})
+	hourly, _ = mgr.ListMetricSamples("svc", MetricResolutionHourly, time.Time{})
+	if len(raw) != 0 || len(hourly) != 2 {
+		t.Errorf("Expected raw pruned and 2 hourly buckets, got raw=%d hourly=%d", len(raw), len(hourly))
+	}
+
+	t.Logf("✓ Metrics downsampled hourly and pruned")
+}
@@ -0,0 +1,93 @@
+package state
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// PendingAction is something the agent still intends to do for a service:
+// a deploy that is running, failed and will be retried, or is held back.
+type PendingAction struct {
+	ServiceID     string    `json:"service_id"`
+	Action        string    `json:"action"` // "deploy", "sync_repo" or "remove"
+	Reason        string    `json:"reason"`
+	Revision      string    `json:"revision"` // What the action applies; failures reset when it changes
+	Attempts      int       `json:"attempts"` // Consecutive failed attempts
+	LastError     string    `json:"last_error,omitempty"`
+	NextAttemptAt time.Time `json:"next_attempt_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// SavePendingAction records a service's pending action, replacing any
+// previous one.
+func (m *Manager) SavePendingAction(p PendingAction) error {
+	_, err := m.db.Exec(`
+		INSERT INTO pending_actions (service_id, action, reason, revision, attempts, last_error, next_attempt_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(service_id) DO UPDATE SET
+			action = excluded.action,
+			reason = excluded.reason,
+			revision = excluded.revision,
+			attempts = excluded.attempts,
+			last_error = excluded.last_error,
+			next_attempt_at = excluded.next_attempt_at,
+			updated_at = excluded.updated_at
+	`, p.ServiceID, p.Action, p.Reason, p.Revision, p.Attempts, p.LastError, p.NextAttemptAt.Unix(), time.Now().Unix())
+	if err != nil {
+		return fmt.Errorf("failed to save pending action: %w", err)
+	}
+	return nil
+}
+
+// GetPendingAction returns a service's pending action, or nil if it has none.
+func (m *Manager) GetPendingAction(serviceID string) (*PendingAction, error) {
+	p := PendingAction{ServiceID: serviceID}
+	var nextAttemptAt, updatedAt int64
+	err := m.db.QueryRow(`
+		SELECT action, reason, revision, attempts, last_error, next_attempt_at, updated_at
+		FROM pending_actions WHERE service_id = ?
+	`, serviceID).Scan(&p.Action, &p.Reason, &p.Revision, &p.Attempts, &p.LastError, &nextAttemptAt, &updatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pending action: %w", err)
+	}
+	p.NextAttemptAt = time.Unix(nextAttemptAt, 0).UTC()
+	p.UpdatedAt = time.Unix(updatedAt, 0).UTC()
+	return &p, nil
+}
+
+// ClearPendingAction removes a service's pending action.
+func (m *Manager) ClearPendingAction(serviceID string) error {
+	if _, err := m.db.Exec("DELETE FROM pending_actions WHERE service_id = ?", serviceID); err != nil {
+		return fmt.Errorf("failed to clear pending action: %w", err)
+	}
+	return nil
+}
+
+// ListPendingActions returns all pending actions by service ID.
+func (m *Manager) ListPendingActions() ([]PendingAction, error) {
+	rows, err := m.db.Query(`
+		SELECT service_id, action, reason, revision, attempts, last_error, next_attempt_at, updated_at
+		FROM pending_actions ORDER BY service_id
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pending actions: %w", err)
+	}
+	defer rows.Close()
+
+	var actions []PendingAction
+	for rows.Next() {
+		var p PendingAction
+		var nextAttemptAt, updatedAt int64
+		if err := rows.Scan(&p.ServiceID, &p.Action, &p.Reason, &p.Revision, &p.Attempts, &p.LastError, &nextAttemptAt, &updatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan pending action: %w", err)
+		}
+		p.NextAttemptAt = time.Unix(nextAttemptAt, 0).UTC()
+		p.UpdatedAt = time.Unix(updatedAt, 0).UTC()
+		actions = append(actions, p)
+	}
+	return actions, rows.Err()
+}
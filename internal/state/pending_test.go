@@ -0,0 +1,41 @@
+package state
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPendingActions(t *testing.T) {
+	t.Logf("Testing pending actions")
+
+	mgr := setupTestDB(t)
+
+	next := time.Now().Add(30 * time.Second).Truncate(time.Second)
+	if err := mgr.SavePendingAction(PendingAction{ServiceID: "web", Action: "deploy", Reason: "definition_changed"}); err != nil {
+		t.Fatalf("Failed to save pending action: %v", err)
+	}
+	if err := mgr.SavePendingAction(PendingAction{ServiceID: "web", Action: "deploy", Reason: "definition_changed", Revision: "abc123", Attempts: 1, LastError: "health check failed", NextAttemptAt: next}); err != nil {
+		t.Fatalf("Failed to update pending action: %v", err)
+	}
+	p, err := mgr.GetPendingAction("web")
+	if err != nil || p == nil {
+		t.Fatalf("Expected pending action, got %+v (err=%v)", p, err)
+	}
+	if p.Attempts != 1 || p.Revision != "abc123" || p.LastError != "health check failed" || !p.NextAttemptAt.Equal(next) {
+		t.Errorf("Unexpected pending action: %+v", p)
+	}
+	if err := mgr.SavePendingAction(PendingAction{ServiceID: "api", Action: "remove", Reason: "held"}); err != nil {
+		t.Fatalf("Failed to save pending action: %v", err)
+	}
+	if actions, _ := mgr.ListPendingActions(); len(actions) != 2 || actions[0].ServiceID != "api" {
+		t.Errorf("Expected two actions ordered by service, got %+v", actions)
+	}
+
+	if err := mgr.ClearPendingAction("web"); err != nil {
+		t.Fatalf("Failed to clear pending action: %v", err)
+	}
+	if p, _ := mgr.GetPendingAction("web"); p != nil {
+		t.Errorf("Expected pending action to be cleared, got %+v", p)
+	}
+	t.Logf("✓ Pending actions saved, listed and cleared")
+}
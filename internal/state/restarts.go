@@ -0,0 +1,55 @@
+package state
+
+import (
+	"fmt"
+	"time"
+)
+
+// restartRetention is how long crash restarts are kept; the longest window
+// reported is a day.
+const restartRetention = 24 * time.Hour
+
+// RecordServiceRestart records that a service's container exited without the
+// agent or an operator stopping it, and whether the kernel killed it for
+// exceeding its memory limit, and drops records older than a day.
+func (m *Manager) RecordServiceRestart(serviceID, containerName string, exitCode int, oomKilled bool, at time.Time) error {
+	_, err := m.db.Exec(`
+		INSERT INTO service_restarts (service_id, container_name, exit_code, oom_killed, restarted_at)
+		VALUES (?, ?, ?, ?, ?)
+	`, serviceID, containerName, exitCode, oomKilled, at.Unix())
+	if err != nil {
+		return fmt.Errorf("failed to record restart: %w", err)
+	}
+	if _, err := m.db.Exec("DELETE FROM service_restarts WHERE restarted_at < ?", at.Add(-restartRetention).Unix()); err != nil {
+		return fmt.Errorf("failed to trim restarts: %w", err)
+	}
+	return nil
+}
+
+// CountServiceRestarts returns how many crash restarts a service had since the
+// given time.
+func (m *Manager) CountServiceRestarts(serviceID string, since time.Time) (int, error) {
+	var count int
+	err := m.db.QueryRow(`
+		SELECT COUNT(*) FROM service_restarts
+		WHERE service_id = ? AND restarted_at >= ?
+	`, serviceID, since.Unix()).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count restarts: %w", err)
+	}
+	return count, nil
+}
+
+// CountServiceOOMKills returns how many of a service's crash restarts since
+// the given time were OOM kills.
+func (m *Manager) CountServiceOOMKills(serviceID string, since time.Time) (int, error) {
+	var count int
+	err := m.db.QueryRow(`
+		SELECT COUNT(*) FROM service_restarts
+		WHERE service_id = ? AND restarted_at >= ? AND oom_killed = 1
+	`, serviceID, since.Unix()).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count OOM kills: %w", err)
+	}
+	return count, nil
+}
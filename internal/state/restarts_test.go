@@ -0,0 +1,40 @@
+package state
+
+import (
+	"testing"
+	"time"
+)
+
+func TestServiceRestarts(t *testing.T) {
+	t.Logf("Testing crash restart counts over rolling windows")
+
+	mgr := setupTestDB(t)
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+
+	for _, ago := range []time.Duration{30 * time.Hour, 5 * time.Hour, 2 * time.Hour, 20 * time.Minute, time.Minute} {
+		if err := mgr.RecordServiceRestart("web", "potato-cloud-web", 137, ago < 3*time.Hour, now.Add(-ago)); err != nil {
+			t.Fatalf("Failed to record restart: %v", err)
+		}
+	}
+	mgr.RecordServiceRestart("worker", "potato-cloud-worker", 1, false, now)
+
+	if count, err := mgr.CountServiceRestarts("web", now.Add(-time.Hour)); err != nil || count != 2 {
+		t.Errorf("Expected 2 restarts in the last hour, got %d (err=%v)", count, err)
+	}
+	if count, _ := mgr.CountServiceRestarts("web", now.Add(-24*time.Hour)); count != 4 {
+		t.Errorf("Expected 4 restarts in the last day, got %d", count)
+	}
+	if count, _ := mgr.CountServiceRestarts("web", time.Time{}); count != 4 {
+		t.Errorf("Expected restarts older than a day to be dropped, got %d", count)
+	}
+	if count, _ := mgr.CountServiceRestarts("db", time.Time{}); count != 0 {
+		t.Errorf("Expected no restarts for an unknown service, got %d", count)
+	}
+	if count, err := mgr.CountServiceOOMKills("web", now.Add(-24*time.Hour)); err != nil || count != 3 {
+		t.Errorf("Expected 3 OOM kills in the last day, got %d (err=%v)", count, err)
+	}
+	if count, _ := mgr.CountServiceOOMKills("worker", time.Time{}); count != 0 {
+		t.Errorf("Expected no OOM kills for a service that exited, got %d", count)
+	}
+	t.Logf("✓ Restarts and OOM kills counted per window and trimmed after a day")
+}
@@ -0,0 +1,62 @@
+package state
+
+import (
+	"fmt"
+	"time"
+)
+
+// ServiceRevision is the revision of a service last applied successfully,
+// and the stack version it was applied from.
+type ServiceRevision struct {
+	ServiceID    string    `json:"service_id"`
+	StackVersion int       `json:"stack_version"`
+	Revision     string    `json:"revision"`
+	AppliedAt    time.Time `json:"applied_at"`
+}
+
+// SetServiceRevision records that a service's revision from a stack version
+// was applied.
+func (m *Manager) SetServiceRevision(serviceID string, version int, revision string) error {
+	_, err := m.db.Exec(`
+		INSERT INTO service_revisions (service_id, stack_version, revision, applied_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT(service_id) DO UPDATE SET
+			stack_version = excluded.stack_version,
+			revision = excluded.revision,
+			applied_at = CASE WHEN service_revisions.revision = excluded.revision
+				THEN service_revisions.applied_at ELSE excluded.applied_at END
+	`, serviceID, version, revision, time.Now().Unix())
+	if err != nil {
+		return fmt.Errorf("failed to set service revision: %w", err)
+	}
+	return nil
+}
+
+// DeleteServiceRevision forgets a service's applied revision.
+func (m *Manager) DeleteServiceRevision(serviceID string) error {
+	if _, err := m.db.Exec("DELETE FROM service_revisions WHERE service_id = ?", serviceID); err != nil {
+		return fmt.Errorf("failed to delete service revision: %w", err)
+	}
+	return nil
+}
+
+// ListServiceRevisions returns the applied revision of every service.
+func (m *Manager) ListServiceRevisions() ([]ServiceRevision, error) {
+	rows, err := m.db.Query("SELECT service_id, stack_version, revision, applied_at FROM service_revisions ORDER BY service_id")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list service revisions: %w", err)
+	}
+	defer rows.Close()
+
+	var revisions []ServiceRevision
+	for rows.Next() {
+		var rev ServiceRevision
+		var appliedAt int64
+		if err := rows.Scan(&rev.ServiceID, &rev.StackVersion, &rev.Revision, &appliedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan service revision: %w", err)
+		}
+		rev.AppliedAt = time.Unix(appliedAt, 0).UTC()
+		revisions = append(revisions, rev)
+	}
+	return revisions, rows.Err()
+}
@@ -0,0 +1,38 @@
+package state
+
+import "testing"
+
+func TestServiceRevisions(t *testing.T) {
+	t.Logf("Testing per-service applied revisions")
+
+	mgr := setupTestDB(t)
+
+	if err := mgr.SetServiceRevision("web", 3, "def:abc"); err != nil {
+		t.Fatalf("Failed to set revision: %v", err)
+	}
+	if err := mgr.SetServiceRevision("api", 3, "def:123"); err != nil {
+		t.Fatalf("Failed to set revision: %v", err)
+	}
+	if err := mgr.SetServiceRevision("web", 4, "def:abc"); err != nil {
+		t.Fatalf("Failed to update revision: %v", err)
+	}
+
+	revisions, err := mgr.ListServiceRevisions()
+	if err != nil {
+		t.Fatalf("Failed to list revisions: %v", err)
+	}
+	if len(revisions) != 2 || revisions[0].ServiceID != "api" || revisions[1].ServiceID != "web" {
+		t.Fatalf("Unexpected revisions %+v", revisions)
+	}
+	if revisions[1].StackVersion != 4 || revisions[1].Revision != "def:abc" || revisions[1].AppliedAt.IsZero() {
+		t.Errorf("Unexpected web revision %+v", revisions[1])
+	}
+
+	if err := mgr.DeleteServiceRevision("api"); err != nil {
+		t.Fatalf("Failed to delete revision: %v", err)
+	}
+	if revisions, _ := mgr.ListServiceRevisions(); len(revisions) != 1 {
+		t.Errorf("Expected one revision after delete, got %+v", revisions)
+	}
+	t.Logf("✓ Service revisions recorded, listed and deleted")
+}
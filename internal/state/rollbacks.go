@@ -0,0 +1,62 @@
+package state
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// RollbackTarget is the release a service ran before its latest deploy
+// attempt, which a rollback redeploys.
+type RollbackTarget struct {
+	ServiceID  string    `json:"service_id"`
+	ImageTag   string    `json:"image_tag"` // Image ID or reference the release ran
+	GitCommit  string    `json:"git_commit"`
+	Port       int       `json:"port"` // Host port the release served on
+	DeployID   string    `json:"deploy_id"`
+	RecordedAt time.Time `json:"recorded_at"`
+}
+
+// SaveRollbackTarget records a service's rollback target, replacing any
+// previous one.
+func (m *Manager) SaveRollbackTarget(t RollbackTarget) error {
+	_, err := m.db.Exec(`
+		INSERT INTO service_rollbacks (service_id, image_tag, git_commit, port, deploy_id, recorded_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(service_id) DO UPDATE SET
+			image_tag = excluded.image_tag,
+			git_commit = excluded.git_commit,
+			port = excluded.port,
+			deploy_id = excluded.deploy_id,
+			recorded_at = excluded.recorded_at
+	`, t.ServiceID, t.ImageTag, t.GitCommit, t.Port, t.DeployID, time.Now().Unix())
+	if err != nil {
+		return fmt.Errorf("failed to save rollback target: %w", err)
+	}
+	return nil
+}
+
+// GetRollbackTarget returns a service's rollback target, or nil if it has
+// none.
+func (m *Manager) GetRollbackTarget(serviceID string) (*RollbackTarget, error) {
+	target := RollbackTarget{ServiceID: serviceID}
+	var recordedAt int64
+	err := m.db.QueryRow("SELECT image_tag, git_commit, port, deploy_id, recorded_at FROM service_rollbacks WHERE service_id = ?", serviceID).
+		Scan(&target.ImageTag, &target.GitCommit, &target.Port, &target.DeployID, &recordedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get rollback target: %w", err)
+	}
+	target.RecordedAt = time.Unix(recordedAt, 0).UTC()
+	return &target, nil
+}
+
+// DeleteRollbackTarget forgets a service's rollback target.
+func (m *Manager) DeleteRollbackTarget(serviceID string) error {
+	if _, err := m.db.Exec("DELETE FROM service_rollbacks WHERE service_id = ?", serviceID); err != nil {
+		return fmt.Errorf("failed to delete rollback target: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package state
+
+import "testing"
+
+func TestRollbackTargets(t *testing.T) {
+	t.Logf("Testing rollback targets")
+
+	mgr := setupTestDB(t)
+
+	if target, err := mgr.GetRollbackTarget("web"); err != nil || target != nil {
+		t.Fatalf("Expected no rollback target, got %+v (err=%v)", target, err)
+	}
+	if err := mgr.SaveRollbackTarget(RollbackTarget{ServiceID: "web", ImageTag: "sha256:aaa", GitCommit: "abc123", Port: 3000, DeployID: "d-1"}); err != nil {
+		t.Fatalf("Failed to save rollback target: %v", err)
+	}
+	if err := mgr.SaveRollbackTarget(RollbackTarget{ServiceID: "web", ImageTag: "sha256:bbb", GitCommit: "def456", Port: 3001, DeployID: "d-2"}); err != nil {
+		t.Fatalf("Failed to replace rollback target: %v", err)
+	}
+	target, err := mgr.GetRollbackTarget("web")
+	if err != nil || target == nil || target.ImageTag != "sha256:bbb" || target.GitCommit != "def456" || target.Port != 3001 || target.DeployID != "d-2" || target.RecordedAt.IsZero() {
+		t.Fatalf("Unexpected rollback target %+v (err=%v)", target, err)
+	}
+
+	if err := mgr.DeleteRollbackTarget("web"); err != nil {
+		t.Fatalf("Failed to delete rollback target: %v", err)
+	}
+	if target, _ := mgr.GetRollbackTarget("web"); target != nil {
+		t.Errorf("Expected the rollback target to be gone, got %+v", target)
+	}
+	t.Logf("✓ Rollback targets saved, replaced and deleted")
+}
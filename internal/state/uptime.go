@@ -0,0 +1,160 @@
+package state
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// AvailabilityInterval is a span during which a service was continuously
+// observed healthy or unhealthy.
+type AvailabilityInterval struct {
+	ServiceID string    `json:"service_id"`
+	Healthy   bool      `json:"healthy"`
+	StartedAt time.Time `json:"started_at"`
+	EndedAt   time.Time `json:"ended_at"`
+}
+
+// RecordAvailability records a probe result. It extends the service's latest
+// interval when the result is unchanged and the previous probe was within
+// maxGap; otherwise it starts a new interval. Gaps longer than maxGap (agent
+// down, service not deployed) count as unobserved rather than up or down.
+func (m *Manager) RecordAvailability(serviceID string, healthy bool, at time.Time, maxGap time.Duration) error {
+	var id int64
+	var lastHealthy bool
+	var endedAt int64
+	err := m.db.QueryRow(`
+		SELECT id, healthy, ended_at FROM service_availability
+		WHERE service_id = ?
+		ORDER BY ended_at DESC, id DESC
+		LIMIT 1
+	`, serviceID).Scan(&id, &lastHealthy, &endedAt)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("failed to read availability: %w", err)
+	}
+
+	now := at.Unix()
+	if err == nil && now >= endedAt && now-endedAt <= int64(maxGap/time.Second) {
+		if lastHealthy == healthy {
+			_, err = m.db.Exec("UPDATE service_availability SET ended_at = ? WHERE id = ?", now, id)
+			if err != nil {
+				return fmt.Errorf("failed to extend availability: %w", err)
+			}
+			return nil
+		}
+		// The status changed somewhere between the two probes; attribute the
+		// gap to the new status so intervals stay contiguous.
+		_, err = m.db.Exec(`
+			INSERT INTO service_availability (service_id, healthy, started_at, ended_at)
+			VALUES (?, ?, ?, ?)
+		`, serviceID, healthy, endedAt, now)
+	} else {
+		_, err = m.db.Exec(`
+			INSERT INTO service_availability (service_id, healthy, started_at, ended_at)
+			VALUES (?, ?, ?, ?)
+		`, serviceID, healthy, now, now)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to record availability: %w", err)
+	}
+	return nil
+}
+
+// LatestAvailability returns the service's most recent interval, or nil if it
+// has never been probed.
+func (m *Manager) LatestAvailability(serviceID string) (*AvailabilityInterval, error) {
+	interval := AvailabilityInterval{ServiceID: serviceID}
+	var startedAt, endedAt int64
+	err := m.db.QueryRow(`
+		SELECT healthy, started_at, ended_at FROM service_availability
+		WHERE service_id = ?
+		ORDER BY ended_at DESC, id DESC
+		LIMIT 1
+	`, serviceID).Scan(&interval.Healthy, &startedAt, &endedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read availability: %w", err)
+	}
+	interval.StartedAt = time.Unix(startedAt, 0).UTC()
+	interval.EndedAt = time.Unix(endedAt, 0).UTC()
+	return &interval, nil
+}
+
+// Uptime is the share of observed time a service was healthy over a window.
+type Uptime struct {
+	Window   time.Duration `json:"window"`
+	Percent  float64       `json:"percent"`
+	Observed time.Duration `json:"observed"`
+}
+
+// ServiceUptime computes uptime over [now-window, now]. Percent is 100 when
+// nothing was observed in the window.
+func (m *Manager) ServiceUptime(serviceID string, window time.Duration, now time.Time) (Uptime, error) {
+	from := now.Add(-window).Unix()
+	to := now.Unix()
+	rows, err := m.db.Query(`
+		SELECT healthy, started_at, ended_at FROM service_availability
+		WHERE service_id = ? AND ended_at > ? AND started_at < ?
+	`, serviceID, from, to)
+	if err != nil {
+		return Uptime{}, fmt.Errorf("failed to query availability: %w", err)
+	}
+	defer rows.Close()
+
+	var observed, healthy int64
+	for rows.Next() {
+		var up bool
+		var start, end int64
+		if err := rows.Scan(&up, &start, &end); err != nil {
+			return Uptime{}, fmt.Errorf("failed to scan availability: %w", err)
+		}
+		if start < from {
+			start = from
+		}
+		if end > to {
+			end = to
+		}
+		observed += end - start
+		if up {
+			healthy += end - start
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return Uptime{}, err
+	}
+
+	uptime := Uptime{Window: window, Percent: 100, Observed: time.Duration(observed) * time.Second}
+	if observed > 0 {
+		uptime.Percent = float64(healthy) * 100 / float64(observed)
+	}
+	return uptime, nil
+}
+
+// ListAvailabilityServices returns the service IDs with recorded availability.
+func (m *Manager) ListAvailabilityServices() ([]string, error) {
+	rows, err := m.db.Query("SELECT DISTINCT service_id FROM service_availability ORDER BY service_id")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list availability services: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan service id: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// PruneAvailability deletes intervals that ended before the cutoff.
+func (m *Manager) PruneAvailability(before time.Time) error {
+	if _, err := m.db.Exec("DELETE FROM service_availability WHERE ended_at < ?", before.Unix()); err != nil {
+		return fmt.Errorf("failed to prune availability: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package state
+
+import (
+	"testing"
+	"time"
+)
+
+func TestServiceUptime(t *testing.T) {
+	t.Logf("Testing availability intervals and uptime")
+
+	mgr := setupTestDB(t)
+
+	start := time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)
+	maxGap := 90 * time.Second
+	probe := func(offset time.Duration, healthy bool) {
+		t.Helper()
+		if err := mgr.RecordAvailability("svc", healthy, start.Add(offset), maxGap); err != nil {
+			t.Fatalf("Failed to record availability: %v", err)
+		}
+	}
+
+	// Healthy for 30 minutes, unhealthy for 10, then the agent is down for an
+	// hour (unobserved), then healthy for 20 more minutes.
+	for m := 0; m <= 30; m++ {
+		probe(time.Duration(m)*time.Minute, true)
+	}
+	for m := 31; m <= 40; m++ {
+		probe(time.Duration(m)*time.Minute, false)
+	}
+	for m := 100; m <= 120; m++ {
+		probe(time.Duration(m)*time.Minute, true)
+	}
+
+	uptime, err := mgr.ServiceUptime("svc", 24*time.Hour, start.Add(120*time.Minute))
+	if err != nil {
+		t.Fatalf("Failed to compute uptime: %v", err)
+	}
+	if uptime.Observed != 60*time.Minute {
+		t.Errorf("Expected 60m observed (gap excluded), got %s", uptime.Observed)
+	}
+	if got := uptime.Percent; got < 83.3 || got > 83.4 {
+		t.Errorf("Expected ~83.33%% uptime (50 of 60 minutes), got %.2f", got)
+	}
+
+	// A window covering only the last 20 minutes is fully healthy.
+	uptime, _ = mgr.ServiceUptime("svc", 20*time.Minute, start.Add(120*time.Minute))
+	if uptime.Percent != 100 {
+		t.Errorf("Expected 100%% over last 20m, got %.2f", uptime.Percent)
+	}
+
+	if err := mgr.PruneAvailability(start.Add(50 * time.Minute)); err != nil {
+		t.Fatalf("Failed to prune: %v", err)
+	}
+	uptime, _ = mgr.ServiceUptime("svc", 24*time.Hour, start.Add(120*time.Minute))
+	if uptime.Observed != 20*time.Minute {
+		t.Errorf("Expected pruned intervals dropped, observed=%s", uptime.Observed)
+	}
+
+	t.Logf("✓ Uptime computed from observed intervals")
+}
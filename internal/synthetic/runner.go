@@ -0,0 +1,209 @@
+// Package synthetic runs HTTP checks against services' public URLs from the
+// VM, verifying the whole path a real request takes: DNS, the tunnel, the
+// external proxy and the container.
+package synthetic
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/buildvigil/agent/internal/api"
+)
+
+const (
+	// tickInterval is how often the runner looks for due checks.
+	tickInterval = 5 * time.Second
+
+	defaultInterval = 60 * time.Second
+	defaultTimeout  = 10 * time.Second
+
+	// maxBodyBytes bounds how much of a response is searched for BodyContains.
+	maxBodyBytes = 1 << 20
+)
+
+// Runner executes synthetic checks on their intervals and keeps the latest
+// result of each.
+type Runner struct {
+	mu      sync.Mutex
+	checks  map[string]api.SyntheticCheck
+	results map[string]api.SyntheticResult
+	nextRun map[string]time.Time
+	now     func() time.Time
+
+	stopChan chan struct{}
+	done     chan struct{}
+}
+
+// NewRunner creates a runner with no checks.
+func NewRunner() *Runner {
+	return &Runner{
+		checks:  map[string]api.SyntheticCheck{},
+		results: map[string]api.SyntheticResult{},
+		nextRun: map[string]time.Time{},
+		now:     time.Now,
+	}
+}
+
+// SetChecks replaces the configured checks. Results for removed checks are
+// dropped; new or changed checks run on the next tick.
+func (r *Runner) SetChecks(checks []api.SyntheticCheck) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	next := make(map[string]api.SyntheticCheck, len(checks))
+	for _, check := range checks {
+		if check.Name == "" {
+			check.Name = check.URL
+		}
+		next[check.Name] = check
+	}
+	for name, check := range r.checks {
+		if updated, ok := next[name]; !ok || updated != check {
+			delete(r.results, name)
+			delete(r.nextRun, name)
+		}
+	}
+	r.checks = next
+}
+
+// Start runs due checks until Stop.
+func (r *Runner) Start() {
+	r.stopChan = make(chan struct{})
+	r.done = make(chan struct{})
+	go func() {
+		defer close(r.done)
+		ticker := time.NewTicker(tickInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-r.stopChan:
+				return
+			case <-ticker.C:
+				r.RunDue()
+			}
+		}
+	}()
+}
+
+// Stop stops the runner and waits for in-flight checks to finish.
+func (r *Runner) Stop() {
+	if r.stopChan == nil {
+		return
+	}
+	close(r.stopChan)
+	<-r.done
+}
+
+// RunDue runs every check whose interval has elapsed, concurrently.
+func (r *Runner) RunDue() {
+	now := r.now()
+	r.mu.Lock()
+	var due []api.SyntheticCheck
+	for name, check := range r.checks {
+		if now.Before(r.nextRun[name]) {
+			continue
+		}
+		interval := defaultInterval
+		if check.IntervalSeconds > 0 {
+			interval = time.Duration(check.IntervalSeconds) * time.Second
+		}
+		r.nextRun[name] = now.Add(interval)
+		due = append(due, check)
+	}
+	r.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, check := range due {
+		wg.Add(1)
+		go func(check api.SyntheticCheck) {
+			defer wg.Done()
+			result := Run(check)
+			r.record(check, result)
+		}(check)
+	}
+	wg.Wait()
+}
+
+func (r *Runner) record(check api.SyntheticCheck, result api.SyntheticResult) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// Drop results for checks removed or changed while running.
+	if current, ok := r.checks[check.Name]; !ok || current != check {
+		return
+	}
+	previous, seen := r.results[check.Name]
+	if !seen || previous.OK != result.OK {
+		if result.OK {
+			log.Printf("[Synthetic] Check passing: name=%s url=%s latency=%dms", result.Name, result.URL, result.LatencyMs)
+		} else {
+			log.Printf("[Synthetic] Check failing: name=%s url=%s err=%s", result.Name, result.URL, result.Error)
+		}
+	}
+	r.results[check.Name] = result
+}
+
+// Results returns the latest result of each check, ordered by name.
+func (r *Runner) Results() []api.SyntheticResult {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	results := make([]api.SyntheticResult, 0, len(r.results))
+	for _, result := range r.results {
+		results = append(results, result)
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Name < results[j].Name })
+	return results
+}
+
+// Run executes a single check. Redirects are not followed so that
+// ExpectedStatus can assert on them.
+func Run(check api.SyntheticCheck) api.SyntheticResult {
+	timeout := defaultTimeout
+	if check.TimeoutSeconds > 0 {
+		timeout = time.Duration(check.TimeoutSeconds) * time.Second
+	}
+	client := &http.Client{
+		Timeout: timeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	result := api.SyntheticResult{Name: check.Name, URL: check.URL, CheckedAt: time.Now().UTC()}
+	start := time.Now()
+	resp, err := client.Get(check.URL)
+	if err != nil {
+		result.LatencyMs = time.Since(start).Milliseconds()
+		result.Error = err.Error()
+		return result
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBodyBytes))
+	result.LatencyMs = time.Since(start).Milliseconds()
+	result.StatusCode = resp.StatusCode
+	if err != nil {
+		result.Error = fmt.Sprintf("failed to read body: %v", err)
+		return result
+	}
+
+	expected := check.ExpectedStatus
+	if expected == 0 {
+		expected = http.StatusOK
+	}
+	switch {
+	case resp.StatusCode != expected:
+		result.Error = fmt.Sprintf("expected status %d, got %d", expected, resp.StatusCode)
+	case check.BodyContains != "" && !strings.Contains(string(body), check.BodyContains):
+		result.Error = fmt.Sprintf("body does not contain %q", check.BodyContains)
+	case check.MaxLatencyMs > 0 && result.LatencyMs > int64(check.MaxLatencyMs):
+		result.Error = fmt.Sprintf("latency %dms exceeds %dms", result.LatencyMs, check.MaxLatencyMs)
+	default:
+		result.OK = true
+	}
+	return result
+}
@@ -0,0 +1,88 @@
+package synthetic
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/buildvigil/agent/internal/api"
+)
+
+func TestRun_Assertions(t *testing.T) {
+	t.Logf("Testing synthetic check assertions...")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/old":
+			http.Redirect(w, r, "/new", http.StatusMovedPermanently)
+		case "/slow":
+			time.Sleep(50 * time.Millisecond)
+			w.Write([]byte("ok"))
+		default:
+			w.Write([]byte("status: ok"))
+		}
+	}))
+	defer server.Close()
+
+	cases := []struct {
+		name  string
+		check api.SyntheticCheck
+		ok    bool
+	}{
+		{"status", api.SyntheticCheck{URL: server.URL + "/"}, true},
+		{"body", api.SyntheticCheck{URL: server.URL + "/", BodyContains: "status: ok"}, true},
+		{"body mismatch", api.SyntheticCheck{URL: server.URL + "/", BodyContains: "healthy"}, false},
+		{"redirect not followed", api.SyntheticCheck{URL: server.URL + "/old", ExpectedStatus: 301}, true},
+		{"latency", api.SyntheticCheck{URL: server.URL + "/slow", MaxLatencyMs: 10}, false},
+		{"unreachable", api.SyntheticCheck{URL: "http://127.0.0.1:1/", TimeoutSeconds: 1}, false},
+	}
+	for _, tc := range cases {
+		result := Run(tc.check)
+		if result.OK != tc.ok {
+			t.Errorf("%s: expected ok=%v, got %+v", tc.name, tc.ok, result)
+		}
+		if !result.OK && result.Error == "" {
+			t.Errorf("%s: expected an error message", tc.name)
+		}
+	}
+	t.Logf("✓ Status, body and latency assertions applied")
+}
+
+func TestRunner_SchedulesAndReplacesChecks(t *testing.T) {
+	t.Logf("Testing synthetic check scheduling...")
+
+	hits := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+	}))
+	defer server.Close()
+
+	runner := NewRunner()
+	clock := time.Date(2026, 6, 1, 10, 0, 0, 0, time.UTC)
+	runner.now = func() time.Time { return clock }
+	runner.SetChecks([]api.SyntheticCheck{{Name: "home", URL: server.URL, IntervalSeconds: 60}})
+
+	runner.RunDue()
+	clock = clock.Add(30 * time.Second)
+	runner.RunDue()
+	if hits != 1 {
+		t.Errorf("Expected check to wait for its interval, got %d hits", hits)
+	}
+	clock = clock.Add(30 * time.Second)
+	runner.RunDue()
+	if hits != 2 {
+		t.Errorf("Expected check to run again after interval, got %d hits", hits)
+	}
+
+	results := runner.Results()
+	if len(results) != 1 || !results[0].OK || results[0].Name != "home" {
+		t.Fatalf("Unexpected results: %+v", results)
+	}
+
+	runner.SetChecks(nil)
+	if len(runner.Results()) != 0 {
+		t.Errorf("Expected results for removed checks to be dropped")
+	}
+	t.Logf("✓ Checks run on their interval and removed checks are dropped")
+}
@@ -0,0 +1,28 @@
+package testutil
+
+import (
+	"testing"
+
+	"github.com/buildvigil/agent/internal/config"
+)
+
+// NewConfig returns an agent configuration for tests: a temporary data
+// directory, the given control plane, and background samplers, probes and
+// alerts turned off so only the reconcile loop runs.
+func NewConfig(t *testing.T, controlPlaneURL string) *config.Config {
+	t.Helper()
+	cfg := config.DefaultConfig()
+	cfg.AgentID = "agent-test"
+	cfg.StackID = "stack-test"
+	cfg.ControlPlane = controlPlaneURL
+	cfg.DataDir = t.TempDir()
+	cfg.PollInterval = 1
+	cfg.PortRangeStart = 34000
+	cfg.PortRangeEnd = 34100
+	cfg.FirewallConfirmMinutes = 0
+	cfg.MetricsIntervalSeconds = 0
+	cfg.UptimeProbeIntervalSeconds = 0
+	cfg.AlertIntervalSeconds = 0
+	cfg.LogExportIntervalMinutes = 0
+	return cfg
+}
@@ -0,0 +1,382 @@
+// Package testutil provides fakes for high-level agent tests: a control plane
+// HTTP server that serves scripted desired states and queued commands and
+// records heartbeats and command results, and a Docker runtime that stands
+// in for the docker CLI.
+package testutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/buildvigil/agent/internal/api"
+)
+
+// FakeControlPlane is an in-process control plane. Desired states are served
+// in the order they were scripted; the last one is repeated.
+type FakeControlPlane struct {
+	URL string
+
+	mu          sync.Mutex
+	states      []api.DesiredState
+	stackStates map[string]api.DesiredState
+	served      int
+	failNext    int
+	failStatus  int
+	requests    []string
+	heartbeats  []api.HeartbeatRequest
+	diagnostics []api.DeployDiagnostics
+	buildLogs   []api.BuildLog
+	response    api.HeartbeatResponse
+	streams     map[chan api.StateEvent]bool
+	commands    []api.Command
+	results     []api.CommandResult
+	offline     []api.OfflineNotice
+	deregisters int
+	deploys     []api.DeployReport
+}
+
+// NewFakeControlPlane starts a fake control plane that is shut down when the
+// test ends.
+func NewFakeControlPlane(t *testing.T) *FakeControlPlane {
+	t.Helper()
+	cp := &FakeControlPlane{}
+	server := httptest.NewServer(http.HandlerFunc(cp.handle))
+	t.Cleanup(server.Close)
+	cp.URL = server.URL
+	return cp
+}
+
+// SetDesiredState serves state on every following fetch.
+func (cp *FakeControlPlane) SetDesiredState(state api.DesiredState) {
+	cp.Script(state)
+}
+
+// SetStackState serves state for stackID only, in place of the scripted
+// states.
+func (cp *FakeControlPlane) SetStackState(stackID string, state api.DesiredState) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	if cp.stackStates == nil {
+		cp.stackStates = make(map[string]api.DesiredState)
+	}
+	cp.stackStates[stackID] = state
+}
+
+// Script serves the states in order, one per fetch, then keeps serving the
+// last one.
+func (cp *FakeControlPlane) Script(states ...api.DesiredState) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	cp.states = append([]api.DesiredState(nil), states...)
+	cp.served = 0
+}
+
+// Publish serves state on every following fetch and announces it to agents
+// connected to the desired state stream.
+func (cp *FakeControlPlane) Publish(state api.DesiredState) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	cp.states = []api.DesiredState{state}
+	cp.served = 0
+	for stream := range cp.streams {
+		stream <- api.StateEvent{Version: state.Version, Hash: state.Hash}
+	}
+}
+
+// Streams returns the number of connected desired state streams.
+func (cp *FakeControlPlane) Streams() int {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	return len(cp.streams)
+}
+
+// DropStreams disconnects every desired state stream.
+func (cp *FakeControlPlane) DropStreams() {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	for stream := range cp.streams {
+		close(stream)
+		delete(cp.streams, stream)
+	}
+}
+
+// QueueCommands serves commands to the agent until it posts their results,
+// and announces them to agents connected to the desired state stream.
+func (cp *FakeControlPlane) QueueCommands(commands ...api.Command) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	cp.commands = append(cp.commands, commands...)
+	for stream := range cp.streams {
+		stream <- api.StateEvent{Commands: true}
+	}
+}
+
+// CommandResults returns the command results posted so far.
+func (cp *FakeControlPlane) CommandResults() []api.CommandResult {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	return append([]api.CommandResult(nil), cp.results...)
+}
+
+// FailNext answers the next n requests with 500.
+func (cp *FakeControlPlane) FailNext(n int) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	cp.failNext = n
+	cp.failStatus = http.StatusInternalServerError
+}
+
+// RejectNext answers the next n requests with 400.
+func (cp *FakeControlPlane) RejectNext(n int) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	cp.failNext = n
+	cp.failStatus = http.StatusBadRequest
+}
+
+// SetHeartbeatResponse sets the reply to heartbeats.
+func (cp *FakeControlPlane) SetHeartbeatResponse(resp api.HeartbeatResponse) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	cp.response = resp
+}
+
+// Requests returns the method and path of every request received, e.g.
+// "POST /api/agents/heartbeat".
+func (cp *FakeControlPlane) Requests() []string {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	return append([]string(nil), cp.requests...)
+}
+
+// Heartbeats returns the heartbeats received so far.
+func (cp *FakeControlPlane) Heartbeats() []api.HeartbeatRequest {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	return append([]api.HeartbeatRequest(nil), cp.heartbeats...)
+}
+
+// LastHeartbeat returns the most recent heartbeat.
+func (cp *FakeControlPlane) LastHeartbeat() (api.HeartbeatRequest, bool) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	if len(cp.heartbeats) == 0 {
+		return api.HeartbeatRequest{}, false
+	}
+	return cp.heartbeats[len(cp.heartbeats)-1], true
+}
+
+// Diagnostics returns the deploy diagnostics uploaded so far.
+func (cp *FakeControlPlane) Diagnostics() []api.DeployDiagnostics {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	return append([]api.DeployDiagnostics(nil), cp.diagnostics...)
+}
+
+// BuildLogs returns the build logs uploaded so far.
+func (cp *FakeControlPlane) BuildLogs() []api.BuildLog {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	return append([]api.BuildLog(nil), cp.buildLogs...)
+}
+
+// DeployReports returns the deploy reports received so far.
+func (cp *FakeControlPlane) DeployReports() []api.DeployReport {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	return append([]api.DeployReport(nil), cp.deploys...)
+}
+
+// OfflineNotices returns the offline notices received so far.
+func (cp *FakeControlPlane) OfflineNotices() []api.OfflineNotice {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	return append([]api.OfflineNotice(nil), cp.offline...)
+}
+
+// Deregistered reports whether the agent deregistered.
+func (cp *FakeControlPlane) Deregistered() bool {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	return cp.deregisters > 0
+}
+
+func (cp *FakeControlPlane) handle(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/desired-state/stream") {
+		cp.handleStream(w, r)
+		return
+	}
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	cp.requests = append(cp.requests, r.Method+" "+r.URL.Path)
+	if cp.failNext > 0 {
+		cp.failNext--
+		http.Error(w, "injected failure", cp.failStatus)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/desired-state"):
+		stackID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/stacks/"), "/desired-state")
+		if state, ok := cp.stackStates[stackID]; ok {
+			writeJSON(w, state)
+			return
+		}
+		if len(cp.states) == 0 {
+			http.Error(w, "no desired state scripted", http.StatusNotFound)
+			return
+		}
+		index := cp.served
+		if index >= len(cp.states) {
+			index = len(cp.states) - 1
+		}
+		cp.served++
+		writeJSON(w, cp.states[index])
+	case r.Method == http.MethodGet && strings.Contains(r.URL.Path, "/services/"):
+		id := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+		if svc, ok := cp.currentService(id); ok {
+			writeJSON(w, svc)
+			return
+		}
+		http.NotFound(w, r)
+	case r.Method == http.MethodPost && r.URL.Path == "/api/agents/heartbeat":
+		var hb api.HeartbeatRequest
+		if err := json.NewDecoder(r.Body).Decode(&hb); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		cp.heartbeats = append(cp.heartbeats, hb)
+		writeJSON(w, cp.response)
+	case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/commands"):
+		writeJSON(w, map[string][]api.Command{"commands": cp.commands})
+	case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/result"):
+		var result api.CommandResult
+		if err := json.NewDecoder(r.Body).Decode(&result); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		cp.results = append(cp.results, result)
+		pending := cp.commands[:0]
+		for _, cmd := range cp.commands {
+			if cmd.ID != result.CommandID {
+				pending = append(pending, cmd)
+			}
+		}
+		cp.commands = pending
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPost && r.URL.Path == "/api/agents/build-logs":
+		var buildLog api.BuildLog
+		if err := json.NewDecoder(r.Body).Decode(&buildLog); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		cp.buildLogs = append(cp.buildLogs, buildLog)
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodPost && r.URL.Path == "/api/agents/deploy-reports":
+		var report api.DeployReport
+		if err := json.NewDecoder(r.Body).Decode(&report); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		cp.deploys = append(cp.deploys, report)
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodPost && r.URL.Path == "/api/agents/offline":
+		var notice api.OfflineNotice
+		if err := json.NewDecoder(r.Body).Decode(&notice); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		cp.offline = append(cp.offline, notice)
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPost && r.URL.Path == "/api/agents/deregister":
+		cp.deregisters++
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPost && r.URL.Path == "/api/agents/diagnostics":
+		var bundle api.DeployDiagnostics
+		if err := json.NewDecoder(r.Body).Decode(&bundle); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		cp.diagnostics = append(cp.diagnostics, bundle)
+		w.WriteHeader(http.StatusCreated)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// handleStream serves a server-sent events stream of published states until
+// the client leaves or DropStreams is called.
+func (cp *FakeControlPlane) handleStream(w http.ResponseWriter, r *http.Request) {
+	stream := make(chan api.StateEvent, 16)
+	cp.mu.Lock()
+	cp.requests = append(cp.requests, r.Method+" "+r.URL.Path)
+	if cp.streams == nil {
+		cp.streams = make(map[chan api.StateEvent]bool)
+	}
+	cp.streams[stream] = true
+	cp.mu.Unlock()
+	defer func() {
+		cp.mu.Lock()
+		delete(cp.streams, stream)
+		cp.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.WriteHeader(http.StatusOK)
+	w.(http.Flusher).Flush()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-stream:
+			if !ok {
+				return
+			}
+			if event.Commands {
+				fmt.Fprint(w, "event: commands\ndata: {}\n\n")
+			} else {
+				data, _ := json.Marshal(event)
+				fmt.Fprintf(w, "event: state\ndata: %s\n\n", data)
+			}
+			w.(http.Flusher).Flush()
+		}
+	}
+}
+
+// currentService finds a service in the most recently served state. Callers
+// hold mu.
+func (cp *FakeControlPlane) currentService(id string) (api.Service, bool) {
+	for _, state := range cp.stackStates {
+		for _, svc := range state.Services {
+			if svc.ID == id {
+				return svc, true
+			}
+		}
+	}
+	if len(cp.states) == 0 {
+		return api.Service{}, false
+	}
+	index := cp.served - 1
+	if index < 0 {
+		index = 0
+	}
+	if index >= len(cp.states) {
+		index = len(cp.states) - 1
+	}
+	for _, svc := range cp.states[index].Services {
+		if svc.ID == id {
+			return svc, true
+		}
+	}
+	return api.Service{}, false
+}
+
+func writeJSON(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(value)
+}
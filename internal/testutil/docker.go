@@ -0,0 +1,666 @@
+package testutil
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// fakeDockerEnv is set only in the docker shim's environment, telling the
+// re-executed test binary to act as the docker CLI.
+const (
+	fakeDockerEnv    = "POTATO_FAKE_DOCKER"
+	fakeDockerURLEnv = "POTATO_FAKE_DOCKER_URL"
+	// fakeImageArchive prefixes the image reference in the fake output of
+	// docker save, which docker load reads back.
+	fakeImageArchive = "fake-image-archive:"
+)
+
+// The agent runs the docker CLI directly, so FakeDocker puts a docker shim on
+// PATH that re-executes the test binary. This init turns that process into a
+// client of the FakeDocker server in the parent test and exits before any
+// test runs.
+func init() {
+	if os.Getenv(fakeDockerEnv) != "1" {
+		return
+	}
+	os.Exit(runDockerShim(os.Args[1:]))
+}
+
+type dockerCall struct {
+	Args  []string `json:"args"`
+	Stdin string   `json:"stdin,omitempty"`
+}
+
+type dockerResult struct {
+	Stdout string `json:"stdout"`
+	Stderr string `json:"stderr"`
+	Code   int    `json:"code"`
+}
+
+func runDockerShim(args []string) int {
+	call := dockerCall{Args: args}
+	if len(args) > 0 && args[0] == "load" {
+		stdin, _ := io.ReadAll(os.Stdin)
+		call.Stdin = string(stdin)
+	}
+	body, _ := json.Marshal(call)
+	resp, err := http.Post(os.Getenv(fakeDockerURLEnv), "application/json", bytes.NewReader(body))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fake docker unreachable: %v\n", err)
+		return 125
+	}
+	defer resp.Body.Close()
+	var result dockerResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		fmt.Fprintf(os.Stderr, "fake docker returned an invalid reply: %v\n", err)
+		return 125
+	}
+	io.WriteString(os.Stdout, result.Stdout)
+	io.WriteString(os.Stderr, result.Stderr)
+	return result.Code
+}
+
+// FakeContainer is a container in the fake runtime.
+type FakeContainer struct {
+	ID       string
+	Name     string
+	Image    string
+	Status   string // "created", "running" or "exited"
+	ExitCode int
+	Labels   map[string]string
+	Ports    map[int]int // container port -> host port
+	Env      []string
+	Args     []string
+}
+
+// FakeDocker is an in-memory Docker runtime behind a fake docker CLI. Builds
+// and pulls record images, runs record containers, and inspect reports their
+// state, so the service manager can deploy, health check and stop services
+// without a Docker daemon.
+type FakeDocker struct {
+	// BuildShouldFail, PullShouldFail and RunShouldFail make the matching
+	// docker command fail; tests may flip them while commands run.
+	// ServeHTTP answers 200 on published host ports so HTTP health checks
+	// pass. TaskExitCode is the exit code of task runs.
+	BuildShouldFail atomic.Bool
+	PullShouldFail  atomic.Bool
+	RunShouldFail   atomic.Bool
+	ServeHTTP       bool
+	TaskExitCode    int
+
+	mu         sync.Mutex
+	calls      [][]string
+	images     map[string]string // reference or tag -> image ID
+	remote     map[string]string // images on a build host given with --host
+	containers map[string]*FakeContainer
+	networks   map[string]bool
+	listeners  map[string][]net.Listener
+	nextID     int
+}
+
+// NewFakeDocker installs a fake docker CLI on PATH for the rest of the test.
+// Tests using it must not run in parallel.
+func NewFakeDocker(t *testing.T) *FakeDocker {
+	t.Helper()
+	f := &FakeDocker{
+		images:     make(map[string]string),
+		remote:     make(map[string]string),
+		containers: make(map[string]*FakeContainer),
+		networks:   make(map[string]bool),
+		listeners:  make(map[string][]net.Listener),
+	}
+	server := httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(func() {
+		server.Close()
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		for name := range f.listeners {
+			f.closeListeners(name)
+		}
+	})
+
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatalf("Failed to locate test binary: %v", err)
+	}
+	binDir := t.TempDir()
+	shim := fmt.Sprintf("#!/bin/sh\n%s=1 exec '%s' \"$@\"\n", fakeDockerEnv, strings.ReplaceAll(exe, "'", `'\''`))
+	if err := os.WriteFile(filepath.Join(binDir, "docker"), []byte(shim), 0755); err != nil {
+		t.Fatalf("Failed to write docker shim: %v", err)
+	}
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv(fakeDockerURLEnv, server.URL)
+	return f
+}
+
+// Calls returns the arguments of every docker command run so far.
+func (f *FakeDocker) Calls() [][]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	calls := make([][]string, len(f.calls))
+	for i, call := range f.calls {
+		calls[i] = append([]string(nil), call...)
+	}
+	return calls
+}
+
+// CallCount returns how many docker commands started with the given
+// subcommand, e.g. "build" or "run".
+func (f *FakeDocker) CallCount(subcommand string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	count := 0
+	for _, call := range f.calls {
+		if len(call) > 0 && call[0] == subcommand {
+			count++
+		}
+	}
+	return count
+}
+
+// Container returns a copy of the named container.
+func (f *FakeDocker) Container(name string) (FakeContainer, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c, ok := f.containers[name]
+	if !ok {
+		return FakeContainer{}, false
+	}
+	return *c, true
+}
+
+// ContainerNames returns the names of all containers, sorted.
+func (f *FakeDocker) ContainerNames() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	names := make([]string, 0, len(f.containers))
+	for name := range f.containers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SetContainerStatus changes a container's state, e.g. to simulate a crash
+// with "exited".
+func (f *FakeDocker) SetContainerStatus(name, status string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if c, ok := f.containers[name]; ok {
+		c.Status = status
+	}
+}
+
+// HasImage reports whether an image is in the local image store.
+func (f *FakeDocker) HasImage(reference string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, ok := f.images[reference]
+	return ok
+}
+
+// AddImage makes an image available locally, as if it had been pulled.
+func (f *FakeDocker) AddImage(reference string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.images[reference] = f.imageID(reference)
+}
+
+func (f *FakeDocker) serve(w http.ResponseWriter, r *http.Request) {
+	var call dockerCall
+	if err := json.NewDecoder(r.Body).Decode(&call); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	result := f.run(call.Args, call.Stdin)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func (f *FakeDocker) run(args []string, stdin string) dockerResult {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, append([]string(nil), args...))
+	if len(args) == 0 {
+		return dockerResult{}
+	}
+
+	// Commands against a build host act on its separate image store.
+	images := f.images
+	if (args[0] == "--host" || args[0] == "-H") && len(args) > 2 {
+		images = f.remote
+		args = args[2:]
+	}
+
+	switch args[0] {
+	case "build", "buildx":
+		if args[0] == "buildx" && (len(args) < 2 || args[1] != "build") {
+			return dockerResult{}
+		}
+		if f.BuildShouldFail.Load() {
+			return failed("fake build failed")
+		}
+		if tag := flagValue(args, "-t", "--tag"); tag != "" {
+			images[tag] = f.imageID(tag)
+		}
+		return dockerResult{Stdout: "Step 1/1 : fake build\nSuccessfully built\n"}
+	case "save":
+		ref := args[len(args)-1]
+		if _, ok := images[ref]; !ok {
+			return failed("Error: No such image: " + ref)
+		}
+		return dockerResult{Stdout: fakeImageArchive + ref}
+	case "load":
+		ref, ok := strings.CutPrefix(stdin, fakeImageArchive)
+		if !ok {
+			return failed("Error: unexpected image archive")
+		}
+		images[ref] = f.imageID(ref)
+		return dockerResult{Stdout: "Loaded image: " + ref + "\n"}
+	case "pull":
+		if f.PullShouldFail.Load() {
+			return failed("fake pull failed")
+		}
+		ref := args[len(args)-1]
+		f.images[ref] = f.imageID(ref)
+	case "image":
+		if len(args) > 1 && args[1] == "inspect" {
+			return f.inspect(args[1:])
+		}
+	case "inspect":
+		return f.inspect(args)
+	case "run", "create":
+		return f.create(args)
+	case "start":
+		name := args[len(args)-1]
+		c, ok := f.containers[name]
+		if !ok {
+			return failed("Error: No such container: " + name)
+		}
+		if hasFlag(args, "-a", "--attach") {
+			c.Status, c.ExitCode = "exited", f.TaskExitCode
+			return dockerResult{Code: f.TaskExitCode}
+		}
+		c.Status = "running"
+	case "stop":
+		for _, name := range positional(args[1:], "-t", "--time", "-s", "--signal") {
+			if c, ok := f.containers[name]; ok {
+				c.Status = "exited"
+				f.closeListeners(name)
+			}
+		}
+	case "rm":
+		for _, name := range positional(args[1:]) {
+			if _, ok := f.findContainer(name); !ok {
+				return failed("Error: No such container: " + name)
+			}
+			f.removeContainer(name)
+		}
+	case "rename":
+		if len(args) != 3 {
+			return failed("rename requires two arguments")
+		}
+		c, ok := f.containers[args[1]]
+		if !ok {
+			return failed("Error: No such container: " + args[1])
+		}
+		delete(f.containers, args[1])
+		c.Name = args[2]
+		f.containers[args[2]] = c
+		f.listeners[args[2]] = f.listeners[args[1]]
+		delete(f.listeners, args[1])
+	case "restart":
+		name := args[len(args)-1]
+		c, ok := f.containers[name]
+		if !ok {
+			return failed("Error: No such container: " + name)
+		}
+		c.Status = "running"
+	case "kill", "exec", "update":
+		if name := firstPositional(args[1:], "-s", "--signal", "--restart", "-e", "-u", "-w"); name != "" {
+			if _, ok := f.containers[name]; !ok {
+				return failed("Error: No such container: " + name)
+			}
+		}
+	case "manifest":
+		// A single-platform manifest, so architecture checks pass.
+		return dockerResult{Stdout: `{"schemaVersion":2,"mediaType":"application/vnd.docker.distribution.manifest.v2+json"}` + "\n"}
+	case "network":
+		return f.network(args[1:])
+	case "ps":
+		return f.ps(args)
+	}
+	return dockerResult{}
+}
+
+func (f *FakeDocker) create(args []string) dockerResult {
+	if f.RunShouldFail.Load() {
+		return failed("fake run failed")
+	}
+	c := &FakeContainer{Labels: make(map[string]string), Ports: make(map[int]int), Args: args}
+	if args[0] == "run" {
+		c.Status = "running"
+	} else {
+		c.Status = "created"
+	}
+	for i := 1; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "-") {
+			c.Image = arg
+			break
+		}
+		name, value, hasValue := strings.Cut(arg, "=")
+		if !hasValue && !booleanRunFlags[arg] && i+1 < len(args) {
+			i++
+			value = args[i]
+		} else if !hasValue {
+			continue
+		}
+		switch name {
+		case "--name":
+			c.Name = value
+		case "-l", "--label":
+			key, labelValue, _ := strings.Cut(value, "=")
+			c.Labels[key] = labelValue
+		case "-e", "--env":
+			c.Env = append(c.Env, value)
+		case "-p", "--publish":
+			parts := strings.Split(value, ":")
+			if len(parts) >= 2 {
+				host, _ := strconv.Atoi(parts[len(parts)-2])
+				container, _ := strconv.Atoi(strings.TrimSuffix(parts[len(parts)-1], "/tcp"))
+				c.Ports[container] = host
+			}
+		}
+	}
+	if c.Name == "" {
+		c.Name = fmt.Sprintf("fake_%d", f.nextID+1)
+	}
+	if _, exists := f.containers[c.Name]; exists {
+		return failed(fmt.Sprintf("Conflict. The container name %q is already in use", "/"+c.Name))
+	}
+	f.nextID++
+	sum := sha256.Sum256([]byte(fmt.Sprintf("container-%d-%s", f.nextID, c.Name)))
+	c.ID = hex.EncodeToString(sum[:])
+	f.containers[c.Name] = c
+
+	if c.Status == "running" && f.ServeHTTP {
+		for _, host := range c.Ports {
+			listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", host))
+			if err != nil {
+				continue
+			}
+			f.listeners[c.Name] = append(f.listeners[c.Name], listener)
+			go http.Serve(listener, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+		}
+	}
+	return dockerResult{Stdout: c.ID + "\n"}
+}
+
+var (
+	portFormat  = regexp.MustCompile(`"(\d+)/tcp"`)
+	labelFormat = regexp.MustCompile(`index \.Config\.Labels "([^"]+)"`)
+)
+
+func (f *FakeDocker) inspect(args []string) dockerResult {
+	format := flagValue(args, "-f", "--format")
+	targets := positional(args[1:], "-f", "--format", "--type")
+	if len(targets) == 0 {
+		return failed("inspect requires at least one argument")
+	}
+	target := targets[0]
+
+	if c, ok := f.findContainer(target); ok {
+		if format == "" {
+			data, _ := json.Marshal([]map[string]interface{}{{
+				"Id":    c.ID,
+				"Name":  "/" + c.Name,
+				"Image": f.imageRef(c.Image),
+				"State": map[string]interface{}{"Status": c.Status, "ExitCode": c.ExitCode, "Running": c.Status == "running"},
+				"Config": map[string]interface{}{
+					"Image":  c.Image,
+					"Labels": c.Labels,
+				},
+			}})
+			return dockerResult{Stdout: string(data) + "\n"}
+		}
+		var out string
+		switch {
+		case strings.Contains(format, "NetworkSettings.Ports"):
+			if match := portFormat.FindStringSubmatch(format); match != nil {
+				port, _ := strconv.Atoi(match[1])
+				if host, ok := c.Ports[port]; ok {
+					out = strconv.Itoa(host)
+				}
+			}
+		case strings.Contains(format, ".State.ExitCode"):
+			out = strconv.Itoa(c.ExitCode)
+		case strings.Contains(format, ".State.Status"):
+			out = c.Status
+			if match := labelFormat.FindStringSubmatch(format); match != nil {
+				out += " " + c.Labels[match[1]]
+			}
+		case strings.Contains(format, ".HostConfig.RestartPolicy"):
+			out = "no:0"
+		case strings.Contains(format, ".Config.Env"):
+			env, _ := json.Marshal(c.Env)
+			out = string(env)
+			if strings.Contains(format, "{{.Image}}") {
+				out += "|" + f.imageRef(c.Image)
+			}
+		case strings.Contains(format, ".Config.Image"):
+			out = c.Image
+		case strings.Contains(format, ".Image"):
+			out = f.imageRef(c.Image)
+		case strings.Contains(format, ".Id"):
+			out = c.ID
+		case strings.Contains(format, ".Name"):
+			out = "/" + c.Name
+		}
+		return dockerResult{Stdout: out + "\n"}
+	}
+
+	if id, ok := f.images[target]; ok {
+		if format == "" {
+			data, _ := json.Marshal([]map[string]interface{}{{"Id": id, "RepoTags": []string{target}}})
+			return dockerResult{Stdout: string(data) + "\n"}
+		}
+		if strings.Contains(format, ".Id") {
+			return dockerResult{Stdout: id + "\n"}
+		}
+		return dockerResult{Stdout: "\n"}
+	}
+	return failed("Error: No such object: " + target)
+}
+
+func (f *FakeDocker) network(args []string) dockerResult {
+	if len(args) == 0 {
+		return dockerResult{}
+	}
+	switch args[0] {
+	case "create":
+		name := args[len(args)-1]
+		if f.networks[name] {
+			return failed(fmt.Sprintf("network with name %s already exists", name))
+		}
+		f.networks[name] = true
+	case "inspect":
+		name := args[len(args)-1]
+		if !f.networks[name] {
+			return failed("Error: No such network: " + name)
+		}
+		return dockerResult{Stdout: "[]\n"}
+	case "rm":
+		for _, name := range args[1:] {
+			delete(f.networks, name)
+		}
+	case "ls":
+		var names []string
+		for name := range f.networks {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		if len(names) == 0 {
+			return dockerResult{}
+		}
+		return dockerResult{Stdout: strings.Join(names, "\n") + "\n"}
+	}
+	return dockerResult{}
+}
+
+func (f *FakeDocker) ps(args []string) dockerResult {
+	var label string
+	for i, arg := range args {
+		if arg == "--filter" && i+1 < len(args) && strings.HasPrefix(args[i+1], "label=") {
+			label = strings.TrimPrefix(args[i+1], "label=")
+		}
+	}
+	var ids []string
+	for _, c := range f.containers {
+		if label != "" {
+			key, value, hasValue := strings.Cut(label, "=")
+			actual, ok := c.Labels[key]
+			if !ok || (hasValue && actual != value) {
+				continue
+			}
+		}
+		ids = append(ids, c.ID)
+	}
+	sort.Strings(ids)
+	if len(ids) == 0 {
+		return dockerResult{}
+	}
+	return dockerResult{Stdout: strings.Join(ids, "\n") + "\n"}
+}
+
+// findContainer looks a container up by name or ID prefix.
+func (f *FakeDocker) findContainer(ref string) (*FakeContainer, bool) {
+	ref = strings.TrimPrefix(ref, "/")
+	if c, ok := f.containers[ref]; ok {
+		return c, true
+	}
+	for _, c := range f.containers {
+		if len(ref) >= 12 && strings.HasPrefix(c.ID, ref) {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+func (f *FakeDocker) removeContainer(ref string) {
+	c, ok := f.findContainer(ref)
+	if !ok {
+		return
+	}
+	f.closeListeners(c.Name)
+	delete(f.containers, c.Name)
+}
+
+func (f *FakeDocker) closeListeners(name string) {
+	for _, listener := range f.listeners[name] {
+		listener.Close()
+	}
+	delete(f.listeners, name)
+}
+
+// imageRef returns the image ID for a reference, registering references run
+// without a prior pull.
+func (f *FakeDocker) imageRef(reference string) string {
+	if strings.HasPrefix(reference, "sha256:") {
+		return reference
+	}
+	id, ok := f.images[reference]
+	if !ok {
+		id = f.imageID(reference)
+		f.images[reference] = id
+	}
+	return id
+}
+
+func (f *FakeDocker) imageID(reference string) string {
+	sum := sha256.Sum256([]byte("image:" + reference))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// booleanRunFlags are docker run/create flags that take no value.
+var booleanRunFlags = map[string]bool{
+	"-d": true, "--detach": true, "--rm": true, "-i": true, "--interactive": true,
+	"-t": true, "--tty": true, "-it": true, "--init": true, "--read-only": true,
+	"--privileged": true, "-P": true, "--publish-all": true,
+}
+
+func failed(message string) dockerResult {
+	return dockerResult{Stderr: message + "\n", Code: 1}
+}
+
+// flagValue returns the value of the first of the named flags, given as
+// "-f value" or "-f=value".
+func flagValue(args []string, names ...string) string {
+	for i, arg := range args {
+		for _, name := range names {
+			if arg == name && i+1 < len(args) {
+				return args[i+1]
+			}
+			if strings.HasPrefix(arg, name+"=") {
+				return strings.TrimPrefix(arg, name+"=")
+			}
+		}
+	}
+	return ""
+}
+
+func hasFlag(args []string, names ...string) bool {
+	for _, arg := range args {
+		for _, name := range names {
+			if arg == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// positional returns the non-flag arguments, skipping the values of the
+// named flags.
+func positional(args []string, valueFlags ...string) []string {
+	var out []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "-") {
+			out = append(out, arg)
+			continue
+		}
+		for _, name := range valueFlags {
+			if arg == name {
+				i++
+				break
+			}
+		}
+	}
+	return out
+}
+
+func firstPositional(args []string, valueFlags ...string) string {
+	if names := positional(args, valueFlags...); len(names) > 0 {
+		return names[0]
+	}
+	return ""
+}
@@ -0,0 +1,89 @@
+package testutil
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/buildvigil/agent/internal/api"
+)
+
+func TestFakeControlPlaneScript(t *testing.T) {
+	t.Logf("Testing scripted desired states and recorded heartbeats")
+
+	cp := NewFakeControlPlane(t)
+	cp.Script(
+		api.DesiredState{StackID: "stack-test", Version: 1, Hash: "v1"},
+		api.DesiredState{StackID: "stack-test", Version: 2, Hash: "v2", Services: []api.Service{{ID: "svc-1", Name: "web"}}},
+	)
+	client := api.NewClient(cp.URL, "agent-test", "", "")
+
+	for _, want := range []int{1, 2, 2} {
+		state, err := client.GetDesiredState(context.Background(), "stack-test")
+		if err != nil {
+			t.Fatalf("Failed to fetch desired state: %v", err)
+		}
+		if state.Version != want {
+			t.Errorf("Expected version %d, got %d", want, state.Version)
+		}
+	}
+
+	cp.FailNext(1)
+	if _, err := client.GetDesiredState(context.Background(), "stack-test"); err == nil {
+		t.Errorf("Expected injected failure")
+	}
+
+	if _, err := client.SendHeartbeat(context.Background(), api.HeartbeatRequest{StackVersion: 2, AgentStatus: "healthy"}); err != nil {
+		t.Fatalf("Failed to send heartbeat: %v", err)
+	}
+	if hb, ok := cp.LastHeartbeat(); !ok || hb.StackVersion != 2 {
+		t.Errorf("Expected recorded heartbeat, got %+v (ok=%t)", hb, ok)
+	}
+	t.Logf("✓ Fake control plane served the script")
+}
+
+func TestFakeDockerCLI(t *testing.T) {
+	t.Logf("Testing the fake docker CLI")
+
+	docker := NewFakeDocker(t)
+	run := func(args ...string) (string, error) {
+		out, err := exec.Command("docker", args...).CombinedOutput()
+		return strings.TrimSpace(string(out)), err
+	}
+
+	if _, err := run("pull", "nginx:1.25"); err != nil {
+		t.Fatalf("Pull failed: %v", err)
+	}
+	id, err := run("run", "-d", "--name", "web", "-p", "34001:80", "--label", "potato-cloud.service=svc-1", "-e", "A=B", "nginx:1.25")
+	if err != nil || id == "" {
+		t.Fatalf("Run failed: %q %v", id, err)
+	}
+	if status, _ := run("inspect", "--format", "{{.State.Status}}", "web"); status != "running" {
+		t.Errorf("Expected running container, got %q", status)
+	}
+	if port, _ := run("inspect", "--format", `{{with index .NetworkSettings.Ports "80/tcp"}}{{(index . 0).HostPort}}{{end}}`, "web"); port != "34001" {
+		t.Errorf("Expected mapped port 34001, got %q", port)
+	}
+	if ids, _ := run("ps", "-aq", "--filter", "label=potato-cloud.service=svc-1"); ids != id {
+		t.Errorf("Expected labelled container %s, got %q", id, ids)
+	}
+	if _, err := run("rename", "web", "web-old"); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+	if _, err := run("rm", "-f", "web-old"); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	if out, err := run("inspect", "web-old"); err == nil || !strings.Contains(out, "No such object") {
+		t.Errorf("Expected missing container, got %q (err=%v)", out, err)
+	}
+
+	docker.BuildShouldFail.Store(true)
+	if _, err := run("build", "-t", "app:1", "."); err == nil {
+		t.Errorf("Expected build failure")
+	}
+	if docker.CallCount("run") != 1 || len(docker.ContainerNames()) != 0 {
+		t.Errorf("Unexpected runtime state: calls=%v containers=%v", docker.Calls(), docker.ContainerNames())
+	}
+	t.Logf("✓ Fake docker CLI tracked containers")
+}
@@ -1,6 +1,8 @@
 package tunnel
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -9,8 +11,9 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strings"
-	"syscall"
 	"time"
+
+	"github.com/buildvigil/agent/internal/platform"
 )
 
 // CloudflareTunnel manages Cloudflare Tunnel connections
@@ -68,6 +71,26 @@ func NewCloudflareTunnel(configPath string, credentials CloudflareCredentials) *
 	}
 }
 
+// SetExtraCAs trusts the PEM certificates in bundle for Cloudflare API calls
+// on top of the system roots, such as the CA of a TLS-inspecting egress
+// proxy. Proxies come from the environment.
+func (ct *CloudflareTunnel) SetExtraCAs(bundle []byte) error {
+	if len(bundle) == 0 {
+		return nil
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(bundle) {
+		return fmt.Errorf("no certificates found in extra CAs")
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{MinVersion: tls.VersionTLS12, RootCAs: pool}
+	ct.httpClient.Transport = transport
+	return nil
+}
+
 // CreateTunnel creates a new Cloudflare tunnel
 func (ct *CloudflareTunnel) CreateTunnel(name, secret string) error {
 	if ct.credentials.TunnelID != "" {
@@ -285,7 +308,7 @@ func (ct *CloudflareTunnel) Start() error {
 	// Wait a moment to check if it started successfully
 	time.Sleep(2 * time.Second)
 	if ct.process.Process != nil {
-		if err := ct.process.Process.Signal(syscall.Signal(0)); err != nil {
+		if !platform.ProcessAlive(ct.process.Process) {
 			return fmt.Errorf("cloudflared process failed to start")
 		}
 	}
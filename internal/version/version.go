@@ -0,0 +1,114 @@
+// Package version describes the running agent build so the control plane can
+// gate features per agent version.
+//
+// Release builds set the build details with ldflags:
+//
+//	go build -ldflags "-X github.com/buildvigil/agent/internal/version.Version=1.4.0 \
+//	  -X github.com/buildvigil/agent/internal/version.Commit=$(git rev-parse HEAD) \
+//	  -X github.com/buildvigil/agent/internal/version.BuildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)" \
+//	  ./cmd/agent
+package version
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+// Set via ldflags; see the package documentation.
+var (
+	Version   = "dev"
+	Commit    = ""
+	BuildDate = ""
+)
+
+// Features lists the capabilities this agent build supports. Names are
+// stable; the control plane checks for them before sending a setting that
+// older agents would ignore.
+var Features = []string{
+	"architecture",
+	"auto_rollback",
+	"canary_deploys",
+	"certificate_alerts",
+	"config_files",
+	"config_reload",
+	"dependencies",
+	"deploy_backoff",
+	"entrypoint_override",
+	"feature_flags",
+	"grpc_transport",
+	"host_header_policy",
+	"image_drift",
+	"init_containers",
+	"log_export",
+	"pending_actions",
+	"protected_services",
+	"remote_log_levels",
+	"resource_limits",
+	"service_groups",
+	"service_holds",
+	"service_refs",
+	"sidecars",
+	"synthetic_checks",
+	"tasks",
+	"timezone_locale",
+	"ulimits_sysctls",
+	"volumes",
+	"workers",
+}
+
+// Info is the agent's build information.
+type Info struct {
+	Version   string   `json:"version"`
+	Commit    string   `json:"commit,omitempty"`
+	BuildDate string   `json:"build_date,omitempty"`
+	GoVersion string   `json:"go_version"`
+	Platform  string   `json:"platform"`
+	Features  []string `json:"features"`
+}
+
+// Get returns the build information. Builds without ldflags fall back to the
+// VCS revision and commit time the Go toolchain embeds.
+func Get() Info {
+	info := Info{
+		Version:   Version,
+		Commit:    Commit,
+		BuildDate: BuildDate,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+		Features:  append([]string(nil), Features...),
+	}
+	if build, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range build.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				if info.Commit == "" {
+					info.Commit = setting.Value
+				}
+			case "vcs.time":
+				if info.BuildDate == "" {
+					info.BuildDate = setting.Value
+				}
+			}
+		}
+	}
+	return info
+}
+
+// String returns a one-line summary such as "1.4.0 (commit 3f2a9c1, built
+// 2026-01-31T10:00:00Z)".
+func (i Info) String() string {
+	s := i.Version
+	commit := i.Commit
+	if len(commit) > 12 {
+		commit = commit[:12]
+	}
+	switch {
+	case commit != "" && i.BuildDate != "":
+		s += " (commit " + commit + ", built " + i.BuildDate + ")"
+	case commit != "":
+		s += " (commit " + commit + ")"
+	case i.BuildDate != "":
+		s += " (built " + i.BuildDate + ")"
+	}
+	return s
+}
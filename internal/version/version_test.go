@@ -0,0 +1,52 @@
+package version
+
+import (
+	"encoding/json"
+	"sort"
+	"testing"
+)
+
+func TestGetUsesLinkedValues(t *testing.T) {
+	t.Logf("Testing build info set via ldflags")
+
+	oldVersion, oldCommit, oldDate := Version, Commit, BuildDate
+	t.Cleanup(func() { Version, Commit, BuildDate = oldVersion, oldCommit, oldDate })
+	Version, Commit, BuildDate = "1.4.0", "3f2a9c1d8e7b6a5f4e3d", "2026-01-31T10:00:00Z"
+
+	info := Get()
+	if info.Version != "1.4.0" || info.Commit != Commit || info.BuildDate != BuildDate {
+		t.Fatalf("Unexpected build info: %+v", info)
+	}
+	if got := info.String(); got != "1.4.0 (commit 3f2a9c1d8e7b, built 2026-01-31T10:00:00Z)" {
+		t.Errorf("Unexpected summary: %q", got)
+	}
+
+	data, err := json.Marshal(info)
+	if err != nil {
+		t.Fatalf("Failed to marshal build info: %v", err)
+	}
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Failed to decode build info: %v", err)
+	}
+	for _, key := range []string{"version", "commit", "build_date", "go_version", "platform", "features"} {
+		if _, ok := decoded[key]; !ok {
+			t.Errorf("Expected %q in JSON output: %s", key, data)
+		}
+	}
+	t.Logf("✓ Build info reported")
+}
+
+func TestFeaturesAreSortedAndUnique(t *testing.T) {
+	t.Logf("Testing feature list")
+
+	if !sort.StringsAreSorted(Features) {
+		t.Errorf("Expected features sorted: %v", Features)
+	}
+	for i := 1; i < len(Features); i++ {
+		if Features[i] == Features[i-1] {
+			t.Errorf("Duplicate feature %q", Features[i])
+		}
+	}
+	t.Logf("✓ Features are sorted and unique")
+}